@@ -10,6 +10,7 @@ import (
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hashicorp/raft"
+	"github.com/robustirc/robustirc/internal/ircserver"
 	"github.com/robustirc/robustirc/internal/privacy"
 	"github.com/robustirc/robustirc/internal/robust"
 	"github.com/stapelberg/glog"
@@ -89,7 +90,8 @@ func canary(fsm raft.FSM, statePath string) {
 
 	enc := json.NewEncoder(f)
 
-	iterator := rs.store.GetBulkIterator(rs.firstIndex, rs.lastIndex+1)
+	defer rs.Release()
+	iterator := rs.snap.LogIterator(rs.firstIndex)
 	defer iterator.Release()
 	available := iterator.First()
 	for available {
@@ -137,7 +139,7 @@ func canary(fsm raft.FSM, statePath string) {
 		if nmsg.Type != robust.IRCFromClient {
 			continue
 		}
-		ircmsg := irc.ParseMessage(nmsg.Data)
+		ircmsg := ircserver.ParseMessage(nmsg.Data)
 		if ircmsg.Command == irc.PING || ircmsg.Command == irc.PONG {
 			continue
 		}
@@ -155,7 +157,7 @@ func canary(fsm raft.FSM, statePath string) {
 				ifc["0x"+strconv.FormatUint(k, 16)] = v
 			}
 			cm.Output[idx] = canaryMessageOutput{
-				Text:           privacy.FilterIrcmsg(irc.ParseMessage(vmsg.Data)).String(),
+				Text:           privacy.FilterIrcmsg(ircserver.ParseMessage(vmsg.Data)).String(),
 				InterestingFor: ifc,
 			}
 		}
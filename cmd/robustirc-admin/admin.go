@@ -0,0 +1,255 @@
+// robustirc-admin performs common operations on a RobustIRC network via
+// its (password-protected) HTTP API, e.g.:
+//
+//	robustirc-admin -network=robustirc.net health
+//	robustirc-admin -network=robustirc.net peers remove dove.robustirc.net:60667
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/robustirc/internal/health"
+	"github.com/robustirc/internal/robusthttp"
+)
+
+var (
+	network = flag.String("network",
+		"",
+		`DNS name to connect to (e.g. "robustirc.net"). The _robustirc._tcp SRV record must be present.`)
+
+	networkPassword = flag.String("network_password",
+		"",
+		"A secure password to protect the communication between raft nodes. Use pwgen(1) or similar.")
+
+	server = flag.String("server",
+		"",
+		`Node to talk to (e.g. "dove.robustirc.net:60667"). Defaults to the first node of -network. Requests which need the raft leader are forwarded to it.`)
+)
+
+const usage = `Usage: robustirc-admin [flags] <command> [args]
+
+Commands:
+  health                   show the state of all nodes
+  peers                    list the raft peers
+  peers add <addr>         add a node to the network
+  peers remove <addr>      remove a node from the network
+  leader-transfer          make the leader step down
+  snapshot                 take a snapshot on -server
+  kill-session <id>...     delete the specified sessions
+  set-config <file>        apply <file> as the network configuration
+
+Flags:
+`
+
+// do sends an HTTP request to |path| on |server| and returns the response
+// body and header, or an error if the status code is not 200 OK.
+func do(server, method, path string, body io.Reader, header http.Header) ([]byte, http.Header, error) {
+	url := fmt.Sprintf("https://%s%s", server, path)
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := robusthttp.Client(*networkPassword, true).Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s: Expected OK, got %v (%q)",
+			url, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, resp.Header, nil
+}
+
+func cmdHealth(servers []string) error {
+	statuses, healthErr := health.EnsureNetworkHealthy(servers, *networkPassword)
+	if len(statuses) == 0 {
+		return healthErr
+	}
+	names := make([]string, 0, len(statuses))
+	for name := range statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "NODE\tSTATE\tAPPLIED\tCOMMITTED\tLAST CONTACT\tBINARY\n")
+	for _, name := range names {
+		status := statuses[name]
+		lastContact := "-"
+		if !status.LastContact.IsZero() {
+			lastContact = status.CurrentTime.Sub(status.LastContact).Round(time.Millisecond).String() + " ago"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\n",
+			name, status.State, status.AppliedIndex, status.CommitIndex, lastContact, status.ExecutableHash)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if healthErr != nil {
+		return fmt.Errorf("network unhealthy: %v", healthErr)
+	}
+	fmt.Println("network healthy")
+	return nil
+}
+
+func cmdPeers(server string, args []string) error {
+	if len(args) == 0 {
+		status, err := health.GetServerStatus(server, *networkPassword)
+		if err != nil {
+			return err
+		}
+		for _, peer := range status.Peers {
+			if peer == status.Leader {
+				fmt.Printf("%s (leader)\n", peer)
+			} else {
+				fmt.Println(peer)
+			}
+		}
+		return nil
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("syntax: peers [add|remove <addr>]")
+	}
+	var path, done string
+	switch args[0] {
+	case "add":
+		path, done = "/join", "added"
+	case "remove":
+		path, done = "/part", "removed"
+	default:
+		return fmt.Errorf("unknown peers subcommand %q", args[0])
+	}
+	data, err := json.Marshal(struct{ Addr string }{args[1]})
+	if err != nil {
+		return err
+	}
+	if _, _, err := do(server, "POST", path, bytes.NewReader(data), nil); err != nil {
+		return err
+	}
+	log.Printf("Peer %q %s.\n", args[1], done)
+	return nil
+}
+
+func cmdLeaderTransfer(server string) error {
+	leader, _, err := do(server, "POST", "/transferleadership", nil, nil)
+	if err != nil {
+		return err
+	}
+	log.Printf("Leadership transferred, new leader is %q\n", string(leader))
+	return nil
+}
+
+func cmdSnapshot(server string) error {
+	if _, _, err := do(server, "GET", "/snapshot", nil, nil); err != nil {
+		return err
+	}
+	log.Printf("%s took a snapshot\n", server)
+	return nil
+}
+
+func cmdKillSession(server string, ids []string) error {
+	if len(ids) == 0 {
+		return fmt.Errorf("syntax: kill-session <id>...")
+	}
+	form := url.Values{"session": ids}
+	header := http.Header{"Content-Type": []string{"application/x-www-form-urlencoded"}}
+	if _, _, err := do(server, "POST", "/kill", strings.NewReader(form.Encode()), header); err != nil {
+		return err
+	}
+	log.Printf("Killed %d session(s)\n", len(ids))
+	return nil
+}
+
+func cmdSetConfig(server string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("syntax: set-config <file>")
+	}
+	config, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	// Like robustirc-editconfig, refer to the current revision so that
+	// concurrent changes are not overwritten.
+	_, current, err := do(server, "GET", "/config", nil, nil)
+	if err != nil {
+		return err
+	}
+	revision := current.Get("X-Robustirc-Config-Revision")
+	header := http.Header{"X-Robustirc-Config-Revision": []string{revision}}
+	if _, _, err := do(server, "POST", "/config", bytes.NewReader(config), header); err != nil {
+		return err
+	}
+	log.Printf("Applied %q (based on revision %s)\n", args[0], revision)
+	return nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var servers []string
+	if *server != "" {
+		servers = []string{*server}
+	}
+	if strings.TrimSpace(*network) != "" {
+		servers = health.ResolveNetwork(*network)
+	}
+	if len(servers) == 0 {
+		log.Fatal("You need to specify -network or -server")
+	}
+	target := servers[0]
+	if *server != "" {
+		target = *server
+	}
+
+	args := flag.Args()[1:]
+	var err error
+	switch flag.Arg(0) {
+	case "health":
+		err = cmdHealth(servers)
+	case "peers":
+		err = cmdPeers(target, args)
+	case "leader-transfer":
+		err = cmdLeaderTransfer(target)
+	case "snapshot":
+		err = cmdSnapshot(target)
+	case "kill-session":
+		err = cmdKillSession(target, args)
+	case "set-config":
+		err = cmdSetConfig(target, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,10 +1,18 @@
 // dump dumps all of the data that RobustIRC persists on disk,
 // i.e. raftlog/, irclog/ and subdirectories of snapshots/.
+//
+// The export subcommand writes a raftlog or irclog as newline-delimited JSON
+// to stdout, the import subcommand creates a new store from such JSON read
+// from stdin, e.g. to migrate a raftlog between backends:
+//
+//	robustirc-dump export -path=raftlog | robustirc-dump import -backend=bbolt -path=raftlog.bolt
+//
+// Imported irclogs lack the session index, which is only built when applying
+// messages, so they are only suitable for inspection.
 package main
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
@@ -20,9 +28,12 @@ import (
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hashicorp/raft"
+	"github.com/robustirc/robustirc/internal/encryption"
 	"github.com/robustirc/robustirc/internal/privacy"
 	"github.com/robustirc/robustirc/internal/raftlog"
+	"github.com/robustirc/robustirc/internal/raftstore"
 	"github.com/robustirc/robustirc/internal/robust"
+	"github.com/robustirc/robustirc/internal/snapshotframe"
 	"github.com/syndtr/goleveldb/leveldb"
 	leveldb_errors "github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/opt"
@@ -41,6 +52,16 @@ var (
 		false,
 		"Display only messages which (should have) undergone at least one compaction cycle because of their age.")
 
+	privacyFilter = flag.String("privacy_filter",
+		string(privacy.RedactAll),
+		"Which information to remove from the dump: “all” removes all message texts, “private” only removes texts of private queries and passwords, “none” removes nothing.")
+	policy privacy.Policy
+
+	encryptionKeyFile = flag.String("encryption_key_file",
+		"",
+		"Path to the key file with which the data was encrypted, see robustirc -encryption_key_file.")
+	cipher *encryption.Cipher
+
 	padding = len(fmt.Sprintf("%d", uint64(math.MaxUint64)))
 	format  = fmt.Sprintf("%%%dd\t%%s\t%%s\t%%v (%%v)\t%%s\t%%s", padding) + "\n"
 
@@ -58,7 +79,7 @@ func dumpLog(key uint64, rlog *raft.Log) {
 	unfilteredMsg := robust.NewMessageFromBytes(rlog.Data, robust.IdFromRaftIndex(rlog.Index))
 	rmsg := &unfilteredMsg
 	if rmsg.Type == robust.IRCFromClient {
-		rmsg = privacy.FilterMsg(rmsg)
+		rmsg = policy.FilterMsg(rmsg)
 	} else if rmsg.Type == robust.State {
 		state, err := base64.StdEncoding.DecodeString(rmsg.Data)
 		if err != nil {
@@ -71,7 +92,7 @@ func dumpLog(key uint64, rlog *raft.Log) {
 			log.Printf("Could not unmarshal proto: %v", err)
 			return
 		}
-		snapshot = privacy.FilterSnapshot(snapshot)
+		snapshot = policy.FilterSnapshot(snapshot)
 		var marshaler proto.TextMarshaler
 		rmsg.Data = marshaler.Text(&snapshot)
 	}
@@ -87,6 +108,15 @@ func dumpLog(key uint64, rlog *raft.Log) {
 	lastId = rmsg.Id.Id
 }
 
+// logFromValue decodes the log entry value |v|, decrypting it if necessary.
+func logFromValue(v []byte) (*raft.Log, error) {
+	v, err := raftstore.OpenValue(cipher, v)
+	if err != nil {
+		return nil, err
+	}
+	return raftlog.FromBytes(v)
+}
+
 func dumpLeveldb(path string) error {
 	db, err := leveldb.OpenFile(path, &opt.Options{
 		ErrorIfMissing: true,
@@ -107,11 +137,11 @@ func dumpLeveldb(path string) error {
 	defer i.Release()
 
 	if i.Last() {
-		for bytes.HasPrefix(i.Key(), []byte("stablestore-")) {
+		for !raftstore.IsLogKey(i.Key()) {
 			i.Prev()
 		}
 		for {
-			rlog, err := raftlog.FromBytes(i.Value())
+			rlog, err := logFromValue(i.Value())
 			if err != nil {
 				log.Fatalf("Corrupted database: %v", err)
 			}
@@ -128,10 +158,10 @@ func dumpLeveldb(path string) error {
 	fmt.Printf(fmt.Sprintf("%%%ds", padding)+"\tValue\n", "Key")
 
 	for i.Next() {
-		if bytes.HasPrefix(i.Key(), []byte("stablestore-")) {
-			// TODO: also dump the stablestore values
+		if !raftstore.IsLogKey(i.Key()) {
+			// TODO: also dump the stablestore values and the session index
 		} else {
-			rlog, err := raftlog.FromBytes(i.Value())
+			rlog, err := logFromValue(i.Value())
 			if err != nil {
 				log.Fatalf("Corrupted database: %v", err)
 			}
@@ -149,15 +179,36 @@ func dumpSnapshotProto(b *bufio.Reader) error {
 		return err
 	}
 	var lenbuf [8]byte // binary.Size(uint64(0))
-	for {
+	return dumpSnapshotRecords(func() ([]byte, error) {
 		if _, err := io.ReadFull(b, lenbuf[:]); err != nil {
-			if err == io.EOF {
-				return nil
-			}
-			return err
+			return nil, err
 		}
 		buf := make([]byte, binary.BigEndian.Uint64(lenbuf[:]))
 		if _, err := io.ReadFull(b, buf); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	})
+}
+
+func dumpSnapshotChecksummed(b *bufio.Reader) error {
+	log.Printf("decoding checksummed protobuf snapshot")
+	r, err := snapshotframe.NewReader(b)
+	if err != nil {
+		return err
+	}
+	return dumpSnapshotRecords(r.Next)
+}
+
+// dumpSnapshotRecords dumps the records returned by |next| until it returns
+// io.EOF.
+func dumpSnapshotRecords(next func() ([]byte, error)) error {
+	for {
+		buf, err := next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
 			return err
 		}
 		rlog, err := raftlog.FromBytes(buf)
@@ -197,12 +248,21 @@ func dumpSnapshot(path string) error {
 	}
 	defer f.Close()
 
+	r, _, err := encryption.MaybeDecrypt(f, cipher)
+	if err != nil {
+		return err
+	}
+
 	// XXX(1.0): remove this conditional, all snapshots are protobuf-encoded now
-	b := bufio.NewReader(f)
+	b := bufio.NewReader(r)
 	first, err := b.Peek(1)
 	if err != nil {
 		return err
 	}
+	if first[0] == snapshotframe.Magic {
+		// checksummed protobuf snapshot, see -snapshot_checksums
+		return dumpSnapshotChecksummed(b)
+	}
 	if first[0] == 'p' {
 		// protobuf snapshot prefix (invalid JSON)
 		return dumpSnapshotProto(b)
@@ -211,12 +271,40 @@ func dumpSnapshot(path string) error {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		var run func([]string) error
+		switch os.Args[1] {
+		case "export":
+			run = runExport
+		case "import":
+			run = runImport
+		}
+		if run != nil {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatalf("%s: %v\n", os.Args[1], err)
+			}
+			return
+		}
+	}
+
 	flag.Parse()
 
 	if strings.TrimSpace(*path) == "" {
 		log.Fatalf("specifying -path is required\n")
 	}
 
+	var ok bool
+	if policy, ok = privacy.ParsePolicy(*privacyFilter); !ok {
+		log.Fatalf("invalid -privacy_filter value %q\n", *privacyFilter)
+	}
+
+	if *encryptionKeyFile != "" {
+		var err error
+		if cipher, err = encryption.LoadKeyFile(*encryptionKeyFile); err != nil {
+			log.Fatalf("-encryption_key_file: %v\n", err)
+		}
+	}
+
 	leveldbErr := dumpLeveldb(*path)
 	if leveldbErr == nil {
 		return
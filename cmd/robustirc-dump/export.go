@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/hashicorp/raft"
+	"github.com/robustirc/robustirc/internal/encryption"
+	"github.com/robustirc/robustirc/internal/raftstore"
+)
+
+// stableKeys are the raft.StableStore keys which raft uses.
+var stableKeys = []string{"CurrentTerm", "LastVoteTerm", "LastVoteCand"}
+
+// importBatchSize is the number of log entries which import stores at once.
+const importBatchSize = 1000
+
+// exportRecord is one line of the newline-delimited JSON written by export
+// and read by import. Exactly one of Log and StableKey is set.
+type exportRecord struct {
+	Log *raft.Log `json:",omitempty"`
+
+	StableKey   string `json:",omitempty"`
+	StableValue []byte `json:",omitempty"`
+}
+
+// storeFlags returns a FlagSet for the |subcommand| with the flags common
+// to export and import.
+func storeFlags(subcommand string) (fs *flag.FlagSet, path, backend, keyFile *string) {
+	fs = flag.NewFlagSet(subcommand, flag.ExitOnError)
+	path = fs.String("path",
+		"",
+		"Path to the store (e.g. raftlog/ or irclog/ in -raftdir).")
+	backend = fs.String("backend",
+		"leveldb",
+		"Storage backend of the store, see robustirc -raftlog_backend. The irclog always uses \"leveldb\".")
+	keyFile = fs.String("encryption_key_file",
+		"",
+		"Path to the key file with which the store is encrypted, see robustirc -encryption_key_file.")
+	return fs, path, backend, keyFile
+}
+
+// openStore opens the store at |path|, see storeFlags.
+func openStore(path, backend, keyFile string, create bool) (raftstore.LogStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("specifying -path is required")
+	}
+	if _, err := os.Stat(path); create && err == nil {
+		return nil, fmt.Errorf("%q already exists, import only creates new stores", path)
+	} else if !create && err != nil {
+		return nil, err
+	}
+	// useProtobuf would convert existing LevelDB stores in place, which
+	// export must not do. Stores created by import use protobuf.
+	store, err := raftstore.OpenBackend(backend, path, create, create, raftstore.Options{})
+	if err != nil {
+		return nil, err
+	}
+	if keyFile != "" {
+		c, err := encryption.LoadKeyFile(keyFile)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		leveldbStore, ok := store.(*raftstore.LevelDBStore)
+		if !ok {
+			store.Close()
+			return nil, fmt.Errorf("-encryption_key_file is not supported with -backend=%s", backend)
+		}
+		leveldbStore.EnableEncryption(c)
+	}
+	return store, nil
+}
+
+// exportStore writes the stable store values and all log entries of |store|
+// to |w| as newline-delimited JSON.
+func exportStore(store raftstore.LogStore, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, key := range stableKeys {
+		value, err := store.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			continue
+		}
+		if err := enc.Encode(&exportRecord{StableKey: key, StableValue: value}); err != nil {
+			return err
+		}
+	}
+
+	first, err := store.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		return err
+	}
+	if last == 0 {
+		return nil
+	}
+	if leveldbStore, ok := store.(*raftstore.LevelDBStore); ok {
+		return leveldbStore.Iterate(first, last, func(l *raft.Log) error {
+			return enc.Encode(&exportRecord{Log: l})
+		})
+	}
+	for index := first; index <= last; index++ {
+		var l raft.Log
+		if err := store.GetLog(index, &l); err != nil {
+			if err == raft.ErrLogNotFound {
+				continue // e.g. deleted by compaction
+			}
+			return err
+		}
+		if err := enc.Encode(&exportRecord{Log: &l}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importStore stores the records read from |r|, as written by exportStore,
+// in |store|.
+func importStore(store raftstore.LogStore, r io.Reader) (entries int, err error) {
+	dec := json.NewDecoder(r)
+	var batch []*raft.Log
+	for {
+		var rec exportRecord
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return entries, err
+		}
+		switch {
+		case rec.Log != nil:
+			batch = append(batch, rec.Log)
+			if len(batch) == importBatchSize {
+				if err := store.StoreLogs(batch); err != nil {
+					return entries, err
+				}
+				entries += len(batch)
+				batch = nil
+			}
+		case rec.StableKey != "":
+			if err := store.Set([]byte(rec.StableKey), rec.StableValue); err != nil {
+				return entries, err
+			}
+		default:
+			return entries, fmt.Errorf("record contains neither a log entry nor a stable store value")
+		}
+	}
+	if len(batch) > 0 {
+		if err := store.StoreLogs(batch); err != nil {
+			return entries, err
+		}
+		entries += len(batch)
+	}
+	return entries, nil
+}
+
+func runExport(args []string) error {
+	fs, path, backend, keyFile := storeFlags("export")
+	fs.Parse(args)
+	store, err := openStore(*path, *backend, *keyFile, false)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	w := bufio.NewWriter(os.Stdout)
+	if err := exportStore(store, w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func runImport(args []string) error {
+	fs, path, backend, keyFile := storeFlags("import")
+	fs.Parse(args)
+	store, err := openStore(*path, *backend, *keyFile, true)
+	if err != nil {
+		return err
+	}
+	entries, err := importStore(store, bufio.NewReader(os.Stdin))
+	if err != nil {
+		store.Close()
+		return err
+	}
+	log.Printf("Imported %d log entries into %q", entries, *path)
+	return store.Close()
+}
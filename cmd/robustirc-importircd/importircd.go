@@ -0,0 +1,103 @@
+// robustirc-importircd converts the configuration of a classic IRC server
+// (UnrealIRCd or InspIRCd) into a RobustIRC network configuration, which can
+// then be applied using robustirc-editconfig.
+//
+// Operators, O:lines, the admin block and link blocks (which RobustIRC only
+// uses for services) are converted. Settings without a RobustIRC equivalent,
+// such as Q:lines and vhosts, are reported on stderr.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/robustirc/robustirc/internal/config"
+)
+
+var format = flag.String("format",
+	"auto",
+	`Format of the configuration file: "unreal", "inspircd" or "auto" (detect based on the contents)`)
+
+// converted is the result of reading a classic IRC server configuration.
+type converted struct {
+	cfg config.Network
+
+	// skipped contains human-readable descriptions of the settings which
+	// could not be converted.
+	skipped []string
+}
+
+func (c *converted) skip(format string, v ...interface{}) {
+	c.skipped = append(c.skipped, fmt.Sprintf(format, v...))
+}
+
+// isAdminClass returns whether an operator class (UnrealIRCd) or type
+// (InspIRCd) grants server administrator privileges.
+func isAdminClass(class string) bool {
+	return strings.Contains(strings.ToLower(class), "admin")
+}
+
+// detectFormat guesses the format of |contents|: InspIRCd uses XML-like tags,
+// UnrealIRCd uses blocks in curly braces.
+func detectFormat(contents string) string {
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if strings.HasPrefix(line, "<") {
+			return "inspircd"
+		}
+		return "unreal"
+	}
+	return "unreal"
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-format=unreal|inspircd] <ircd.conf>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	b, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	contents := string(b)
+
+	f := *format
+	if f == "auto" {
+		f = detectFormat(contents)
+	}
+	var result *converted
+	switch f {
+	case "unreal":
+		result, err = convertUnreal(contents)
+	case "inspircd":
+		result, err = convertInspircd(contents)
+	default:
+		log.Fatalf("Unknown -format %q", f)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, s := range result.skipped {
+		log.Printf("skipped: %s", s)
+	}
+
+	if err := toml.NewEncoder(os.Stdout).Encode(result.cfg); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/robustirc/robustirc/internal/config"
+)
+
+var (
+	inspircdTagRe  = regexp.MustCompile(`(?s)<\s*([A-Za-z]+)(.*?)>`)
+	inspircdAttrRe = regexp.MustCompile(`([A-Za-z0-9_]+)\s*=\s*"([^"]*)"`)
+)
+
+// tag is an InspIRCd configuration tag such as
+// <oper name="secure" password="secret" type="NetAdmin">.
+type tag struct {
+	name  string
+	attrs map[string]string
+}
+
+// parseInspircd returns all tags in |contents|, removing comments.
+func parseInspircd(contents string) []tag {
+	var lines []string
+	for _, line := range strings.Split(contents, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	contents = strings.Join(lines, "\n")
+
+	var tags []tag
+	for _, m := range inspircdTagRe.FindAllStringSubmatch(contents, -1) {
+		t := tag{
+			name:  strings.ToLower(m[1]),
+			attrs: make(map[string]string),
+		}
+		for _, attr := range inspircdAttrRe.FindAllStringSubmatch(m[2], -1) {
+			t.attrs[strings.ToLower(attr[1])] = attr[2]
+		}
+		tags = append(tags, t)
+	}
+	return tags
+}
+
+func convertInspircd(contents string) (*converted, error) {
+	result := &converted{cfg: config.DefaultConfig}
+
+	tags := parseInspircd(contents)
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("no InspIRCd configuration tags found")
+	}
+	for _, t := range tags {
+		switch t.name {
+		case "oper":
+			if hash := t.attrs["hash"]; hash != "" && hash != "plaintext" {
+				// RobustIRC needs the plain text password.
+				result.skip("oper %q (password is hashed with %s)", t.attrs["name"], hash)
+				continue
+			}
+			result.cfg.IRC.Operators = append(result.cfg.IRC.Operators, config.IRCOp{
+				Name:     t.attrs["name"],
+				Password: t.attrs["password"],
+				Admin:    isAdminClass(t.attrs["type"]),
+			})
+
+		case "link":
+			if t.attrs["recvpass"] == "" {
+				result.skip("link %q (no recvpass)", t.attrs["name"])
+				continue
+			}
+			result.cfg.IRC.Services = append(result.cfg.IRC.Services, config.Service{
+				Password: t.attrs["recvpass"],
+			})
+
+		case "admin":
+			result.cfg.Admin.Organization = t.attrs["name"]
+			result.cfg.Admin.Email = t.attrs["email"]
+
+		case "badnick":
+			result.skip("badnick %q (no RobustIRC equivalent, use services)", t.attrs["nick"])
+
+		case "vhost":
+			result.skip("vhost %q (no RobustIRC equivalent)", t.attrs["host"])
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/robustirc/robustirc/internal/config"
+)
+
+// block is an UnrealIRCd configuration entry such as
+// “oper name { password "secret"; };” or “password "secret";”.
+type block struct {
+	name     string
+	value    string
+	children []*block
+}
+
+// child returns the value of the first child entry called |name|.
+func (b *block) child(name string) string {
+	for _, c := range b.children {
+		if strings.EqualFold(c.name, name) {
+			return c.value
+		}
+	}
+	return ""
+}
+
+// tokenizeUnreal splits an UnrealIRCd configuration file into words, quoted
+// strings and the characters “{”, “}” and “;”, removing comments.
+func tokenizeUnreal(contents string) ([]string, error) {
+	var tokens []string
+	for idx := 0; idx < len(contents); {
+		r := rune(contents[idx])
+		switch {
+		case unicode.IsSpace(r):
+			idx++
+
+		case r == '#' || strings.HasPrefix(contents[idx:], "//"):
+			end := strings.IndexByte(contents[idx:], '\n')
+			if end == -1 {
+				return tokens, nil
+			}
+			idx += end + 1
+
+		case strings.HasPrefix(contents[idx:], "/*"):
+			end := strings.Index(contents[idx+2:], "*/")
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated comment")
+			}
+			idx += 2 + end + 2
+
+		case r == '{' || r == '}' || r == ';':
+			tokens = append(tokens, string(r))
+			idx++
+
+		case r == '"':
+			end := strings.IndexByte(contents[idx+1:], '"')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			// Keep the quote so that parseUnreal can distinguish strings
+			// from syntax characters.
+			tokens = append(tokens, contents[idx:idx+1+end])
+			idx += 1 + end + 1
+
+		default:
+			end := strings.IndexFunc(contents[idx:], func(r rune) bool {
+				return unicode.IsSpace(r) || r == '{' || r == '}' || r == ';'
+			})
+			if end == -1 {
+				end = len(contents) - idx
+			}
+			tokens = append(tokens, contents[idx:idx+end])
+			idx += end
+		}
+	}
+	return tokens, nil
+}
+
+// parseUnreal parses |tokens| into blocks until the end of the tokens or the
+// closing “}” of the enclosing block.
+func parseUnreal(tokens []string) ([]*block, []string, error) {
+	var blocks []*block
+	for len(tokens) > 0 {
+		if tokens[0] == "}" {
+			return blocks, tokens[1:], nil
+		}
+		if tokens[0] == ";" {
+			tokens = tokens[1:]
+			continue
+		}
+		b := &block{name: strings.TrimPrefix(tokens[0], `"`)}
+		tokens = tokens[1:]
+		if len(tokens) > 0 && tokens[0] != "{" && tokens[0] != ";" && tokens[0] != "}" {
+			b.value = strings.TrimPrefix(tokens[0], `"`)
+			tokens = tokens[1:]
+		}
+		if len(tokens) > 0 && tokens[0] == "{" {
+			var err error
+			b.children, tokens, err = parseUnreal(tokens[1:])
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, tokens, nil
+}
+
+// parseOLine parses an old-style “O:host:password:name:flags:class” line.
+func parseOLine(line string) (config.IRCOp, bool) {
+	parts := strings.Split(line, ":")
+	if len(parts) < 4 || !strings.EqualFold(parts[0], "O") {
+		return config.IRCOp{}, false
+	}
+	op := config.IRCOp{
+		Name:     parts[3],
+		Password: parts[2],
+	}
+	if len(parts) > 4 {
+		// A (server administrator) and N (network administrator).
+		op.Admin = strings.ContainsAny(parts[4], "AN")
+	}
+	return op, true
+}
+
+func convertUnreal(contents string) (*converted, error) {
+	result := &converted{cfg: config.DefaultConfig}
+
+	// Old-style configuration lines (e.g. O:lines) are converted before
+	// tokenizing, since they are not valid block syntax.
+	var rest []string
+	for _, line := range strings.Split(contents, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if op, ok := parseOLine(trimmed); ok {
+			result.cfg.IRC.Operators = append(result.cfg.IRC.Operators, op)
+			continue
+		}
+		if len(trimmed) > 1 && trimmed[1] == ':' && strings.ContainsRune("QVC", unicode.ToUpper(rune(trimmed[0]))) {
+			result.skip("%q (no RobustIRC equivalent)", trimmed)
+			continue
+		}
+		rest = append(rest, line)
+	}
+
+	tokens, err := tokenizeUnreal(strings.Join(rest, "\n"))
+	if err != nil {
+		return nil, err
+	}
+	blocks, tokens, err := parseUnreal(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) > 0 {
+		return nil, fmt.Errorf("unexpected %q", tokens[0])
+	}
+
+	for _, b := range blocks {
+		switch strings.ToLower(b.name) {
+		case "oper":
+			op := config.IRCOp{
+				Name:     b.value,
+				Password: b.child("password"),
+				Admin:    isAdminClass(b.child("operclass")) || isAdminClass(b.child("class")),
+			}
+			if op.Password == "" || strings.HasPrefix(op.Password, "$") {
+				// e.g. password { "…"; } with authentication options, or a
+				// password hash: RobustIRC needs the plain text password.
+				result.skip("oper %q (unsupported password configuration)", b.value)
+				continue
+			}
+			result.cfg.IRC.Operators = append(result.cfg.IRC.Operators, op)
+
+		case "link":
+			password := b.child("password")
+			if password == "" {
+				password = b.child("password-receive")
+			}
+			if password == "" {
+				result.skip("link %q (no password)", b.value)
+				continue
+			}
+			result.cfg.IRC.Services = append(result.cfg.IRC.Services, config.Service{
+				Password: password,
+			})
+
+		case "admin":
+			// The admin block consists of free-form lines, conventionally
+			// the organization followed by contact details.
+			for idx, c := range b.children {
+				line := c.name
+				if idx == 0 {
+					result.cfg.Admin.Organization = line
+					continue
+				}
+				if strings.Contains(line, "@") {
+					result.cfg.Admin.Email = line
+				} else if result.cfg.Admin.Location == "" {
+					result.cfg.Admin.Location = line
+				}
+			}
+
+		case "ban":
+			if strings.EqualFold(b.value, "nick") {
+				result.skip("ban nick %q (no RobustIRC equivalent, use services)", b.child("mask"))
+			}
+
+		case "vhost":
+			result.skip("vhost %q (no RobustIRC equivalent)", b.child("vhost"))
+		}
+	}
+
+	return result, nil
+}
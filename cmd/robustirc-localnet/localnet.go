@@ -42,6 +42,10 @@ var (
 	startShell = flag.Bool("shell",
 		false,
 		"Enter interactive shell once RobustIRC network is brought up")
+
+	enablePartitionHooks = flag.Bool("enable_partition_hooks",
+		false,
+		"Allow dropping/delaying raft traffic between nodes via /partition (for testing only)")
 )
 
 const config = `
@@ -219,6 +223,10 @@ func main() {
 		log.Fatalf("There already is a localnet instance running. Either use -stop or specify a different -localnet_dir")
 	}
 
+	if *enablePartitionHooks {
+		l.EnablePartitionHooks = "1"
+	}
+
 	success := false
 
 	defer func() {
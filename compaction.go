@@ -1,43 +1,166 @@
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hashicorp/raft"
+	"github.com/robustirc/robustirc/internal/ircserver"
 	"github.com/robustirc/robustirc/internal/raftstore"
 	"github.com/robustirc/robustirc/internal/robust"
+	"github.com/robustirc/robustirc/internal/snapshotframe"
+	"github.com/robustirc/robustirc/internal/snapshotmeta"
+
+	"gopkg.in/sorcix/irc.v2"
 
 	pb "github.com/robustirc/robustirc/internal/proto"
 )
 
 type robustSnapshot struct {
-	firstIndex    uint64
-	lastIndex     uint64
-	store         *raftstore.LevelDBStore
+	firstIndex uint64
+	lastIndex  uint64
+	// snap is the view of the ircstore at the time the snapshot was taken.
+	// Persist reads exclusively from snap, so that Apply (which continues
+	// while Persist runs) and DeleteRange cannot interfere.
+	snap          *raftstore.Snapshot
 	state         []byte
 	compactionEnd time.Time
+
+	// warmState is only set with -warm_start and is written to disk once
+	// the snapshot was persisted successfully.
+	warmState *warmState
+
+	// summary is the first record of protobuf snapshots, see
+	// countRetained for how summary.Retained is filled in.
+	summary snapshotmeta.Summary
+}
+
+// retainedKey returns the key under which |nlog| is counted in
+// snapshotmeta.Summary.Retained.
+func retainedKey(nlog *raft.Log) string {
+	msg := robust.NewMessageFromBytes(nlog.Data, robust.IdFromRaftIndex(nlog.Index))
+	var ircmsg *irc.Message
+	if msg.Type == robust.IRCFromClient {
+		ircmsg = ircserver.ParseMessage(msg.Data)
+	}
+	return compactionKey(&msg, ircmsg)
+}
+
+// compactionKey returns the IRC command of |msg| (with |ircmsg| being the
+// parsed msg.Data, if any) or its robust.Type for other messages.
+func compactionKey(msg *robust.Message, ircmsg *irc.Message) string {
+	if ircmsg != nil {
+		return strings.ToUpper(ircmsg.Command)
+	}
+	return msg.Type.String()
 }
 
-func writeLenPrefixed(sink raft.SnapshotSink, val []byte) (n int, err error) {
+// retained returns true if compaction needs to stop at |msg| (with |ircmsg|
+// being the parsed msg.Data, if any), see ircserver.IsCompactable. Messages
+// after a retained message are not compacted either: replaying the retained
+// message on top of a state which already contains later messages would
+// reorder them.
+func retained(msg *robust.Message, ircmsg *irc.Message) bool {
+	return msg.Type == robust.IRCFromClient && ircmsg != nil && !ircserver.IsCompactable(ircmsg.Command)
+}
+
+// countRetained fills in s.summary.Retained. The summary precedes the log
+// entries in the snapshot, so they are counted before writing them.
+func (s *robustSnapshot) countRetained() error {
+	s.summary.Retained = make(map[string]int)
+	iterator := s.snap.LogIterator(s.firstIndex)
+	defer iterator.Release()
+	for available := iterator.First(); available; available = iterator.Next() {
+		var nlog raft.Log
+		if err := unmarshalLog(iterator.Value(), &nlog); err == nil {
+			s.summary.Retained[retainedKey(&nlog)]++
+		}
+	}
+	return iterator.Error()
+}
+
+// writeLenPrefixed writes the concatenation of |parts|, prefixed with its
+// length. Passing multiple parts avoids copying large values (e.g. the
+// state) only to prepend a marker byte.
+func writeLenPrefixed(sink io.Writer, parts ...[]byte) (n int, err error) {
+	var total int
+	for _, part := range parts {
+		total += len(part)
+	}
 	var lenbuf [8]byte // binary.Size(uint64(0))
-	binary.BigEndian.PutUint64(lenbuf[:], uint64(len(val)))
-	nLen, err := sink.Write(lenbuf[:])
+	binary.BigEndian.PutUint64(lenbuf[:], uint64(total))
+	n, err = sink.Write(lenbuf[:])
 	if err != nil {
-		return nLen, err
+		return n, err
 	}
-	nVal, err := sink.Write(val)
-	if err != nil {
-		return nVal, err
+	for _, part := range parts {
+		nPart, err := sink.Write(part)
+		n += nPart
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// readLenPrefixed returns a function which reads the records written by
+// writeLenPrefixed from |b|, after discarding the leading 'p' of
+// unchecksummed protobuf snapshots.
+func readLenPrefixed(b *bufio.Reader) func() ([]byte, error) {
+	discarded := false
+	return func() ([]byte, error) {
+		if !discarded {
+			if _, err := b.ReadByte(); err != nil {
+				return nil, err
+			}
+			discarded = true
+		}
+		var lenbuf [8]byte // binary.Size(uint64(0))
+		if _, err := io.ReadFull(b, lenbuf[:]); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, binary.BigEndian.Uint64(lenbuf[:]))
+		if _, err := io.ReadFull(b, buf); err != nil {
+			return nil, err
+		}
+		return buf, nil
 	}
-	return nLen + nVal, nil
 }
 
-func (s *robustSnapshot) persistJSON(sink raft.SnapshotSink) error {
+// nopWriteCloser turns an io.Writer into an io.WriteCloser whose Close does
+// nothing, for writing uncompressed snapshots.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newSnapshotWriter wraps |w| such that snapshots are compressed according to
+// |compression| (see -snapshot_compression). The returned writer must be
+// closed to flush all data to |w|.
+func newSnapshotWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "", "none":
+		return nopWriteCloser{w}, nil
+	case "gzip":
+		// gzip streams start with 0x1f 0x8b, which is neither a valid
+		// JSON nor a protobuf snapshot, see FSM.Restore.
+		return gzip.NewWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q, expected \"none\" or \"gzip\"", compression)
+	}
+}
+
+func (s *robustSnapshot) persistJSON(sink io.Writer) error {
 	start := time.Now()
 	log.Printf("persisting JSON-encoded snapshot")
 	stateMsg := robust.Message{
@@ -63,7 +186,7 @@ func (s *robustSnapshot) persistJSON(sink raft.SnapshotSink) error {
 	if err != nil {
 		return err
 	}
-	iterator := s.store.GetBulkIterator(s.firstIndex, s.lastIndex+1)
+	iterator := s.snap.LogIterator(s.firstIndex)
 	defer iterator.Release()
 	available := iterator.First()
 	for available {
@@ -86,16 +209,70 @@ func (s *robustSnapshot) persistJSON(sink raft.SnapshotSink) error {
 }
 
 // Persist writes a robustSnapshot to disk, i.e. handles the
-// serialization details.
+// serialization details. Log entries are streamed from s.snap one at a
+// time, so memory usage does not grow with the size of the irclog.
 func (s *robustSnapshot) Persist(sink raft.SnapshotSink) error {
+	w, err := newSnapshotWriter(sink, *snapshotCompression)
+	if err != nil {
+		return err
+	}
 	if !*useProtobuf {
 		// XXX(1.0): delete this branch
-		return s.persistJSON(sink)
+		if err := s.persistJSON(w); err != nil {
+			return err
+		}
+		return w.Close()
+	}
+	if err := s.persistProtobuf(w); err != nil {
+		return err
 	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if s.warmState != nil {
+		if err := writeWarmState(s.warmState); err != nil {
+			// Not fatal: restarts fall back to replaying all log entries.
+			log.Printf("Could not write warm state: %v", err)
+		}
+	}
+
+	log.Printf("Snapshot done\n")
+
+	return nil
+}
+
+func (s *robustSnapshot) persistProtobuf(sink io.Writer) error {
 	start := time.Now()
-	log.Printf("persisting protobuf-encoded snapshot")
-	var snapshotBytes int
-	n, err := sink.Write([]byte{'p'}) // signal a protobuf snapshot
+	log.Printf("persisting protobuf-encoded snapshot (compression: %s, checksums: %v)", *snapshotCompression, *snapshotChecksums)
+	var (
+		snapshotBytes int
+		framed        *snapshotframe.Writer
+		writeRecord   = func(parts ...[]byte) (int, error) {
+			return writeLenPrefixed(sink, parts...)
+		}
+	)
+	if *snapshotChecksums {
+		var err error
+		if framed, err = snapshotframe.NewWriter(sink); err != nil {
+			return err
+		}
+		writeRecord = framed.WriteRecord
+	} else {
+		if _, err := sink.Write([]byte{'p'}); err != nil { // signal a protobuf snapshot
+			return err
+		}
+	}
+	snapshotBytes++
+
+	if err := s.countRetained(); err != nil {
+		return err
+	}
+	summary, err := snapshotmeta.Record(&s.summary)
+	if err != nil {
+		return err
+	}
+	n, err := writeRecord(summary)
 	if err != nil {
 		return err
 	}
@@ -122,19 +299,19 @@ func (s *robustSnapshot) Persist(sink raft.SnapshotSink) error {
 
 	log.Printf("Copying non-deleted messages into snapshot\n")
 
-	n, err = writeLenPrefixed(sink, append([]byte{'p'}, stateMsgProto...))
+	n, err = writeRecord([]byte{'p'}, stateMsgProto)
 	if err != nil {
 		return err
 	}
 	snapshotBytes += n
-	iterator := s.store.GetBulkIterator(s.firstIndex, s.lastIndex+1)
+	iterator := s.snap.LogIterator(s.firstIndex)
 	defer iterator.Release()
 	available := iterator.First()
 	for available {
 		if err := iterator.Error(); err != nil {
 			return err
 		}
-		n, err := writeLenPrefixed(sink, iterator.Value())
+		n, err := writeRecord(iterator.Value())
 		if err != nil {
 			return err
 		}
@@ -142,12 +319,16 @@ func (s *robustSnapshot) Persist(sink raft.SnapshotSink) error {
 
 		available = iterator.Next()
 	}
+	if framed != nil {
+		if err := framed.Close(); err != nil {
+			return err
+		}
+	}
 	log.Printf("snapshot: wrote %d bytes in %v", snapshotBytes, time.Since(start))
 
-	log.Printf("Snapshot done\n")
-
 	return nil
 }
 
 func (s *robustSnapshot) Release() {
+	s.snap.Release()
 }
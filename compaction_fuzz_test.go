@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+	"github.com/robustirc/robustirc/internal/ircserver"
+	"github.com/robustirc/robustirc/internal/outputstream"
+	"github.com/robustirc/robustirc/internal/raftstore"
+	"github.com/robustirc/robustirc/internal/robust"
+)
+
+// fuzzCommands are the IRC commands FuzzCompaction chooses from. %[1]s is
+// replaced with a channel, %[2]s with a nickname and %[3]s with a word.
+var fuzzCommands = []string{
+	"JOIN %[1]s",
+	"JOIN %[1]s %[3]s",
+	"PART %[1]s",
+	"PRIVMSG %[1]s :%[3]s",
+	"NOTICE %[2]s :%[3]s",
+	"TOPIC %[1]s :%[3]s",
+	"MODE %[1]s +i",
+	"MODE %[1]s -i",
+	"MODE %[1]s +k %[3]s",
+	"MODE %[1]s -k %[3]s",
+	"MODE %[1]s +l 2",
+	"MODE %[1]s -l",
+	"MODE %[1]s +o %[2]s",
+	"MODE %[1]s -o %[2]s",
+	"MODE %[1]s +v %[2]s",
+	"MODE %[1]s +b %[2]s!*@*",
+	"MODE %[1]s -b %[2]s!*@*",
+	"MODE %[2]s +i",
+	"INVITE %[2]s %[1]s",
+	"KICK %[1]s %[2]s :%[3]s",
+	"NICK %[2]s",
+	"AWAY :%[3]s",
+	"AWAY",
+	"OPER mero foo",
+	"QUIT :%[3]s",
+}
+
+var (
+	fuzzChannels = []string{"#a", "#B", "#chaos-hd"}
+	fuzzNicks    = []string{"sECuRE", "mero", "xeen", "Guest"}
+	fuzzWords    = []string{"key", "hey", "bye"}
+)
+
+// fuzzLogs turns |data| into log entries: three sessions log in, followed by
+// one IRC message per 3 bytes of |data|. The last |recent| entries are too
+// new to be compacted.
+func fuzzLogs(data []byte, recent int) []*raft.Log {
+	var logs []*raft.Log
+	base := time.Unix(1420228218, 0)
+	add := func(msg robust.Message) {
+		msg.Id = robust.Id{Id: uint64(len(logs) + 1)}
+		msg.UnixNano = base.Add(time.Duration(len(logs)) * time.Second).UnixNano()
+		b, err := json.Marshal(&msg)
+		if err != nil {
+			panic(err)
+		}
+		logs = appendLog(logs, string(b))
+	}
+	for idx, nick := range fuzzNicks[:3] {
+		add(robust.Message{Type: robust.CreateSession, Data: "auth"})
+		session := robust.Id{Id: uint64(3*idx + 1)}
+		add(robust.Message{Session: session, Type: robust.IRCFromClient, Data: "NICK " + nick})
+		add(robust.Message{Session: session, Type: robust.IRCFromClient, Data: "USER u 0 * :" + nick})
+	}
+	for len(data) >= 3 {
+		session, cmd, arg := int(data[0]), int(data[1]), int(data[2])
+		data = data[3:]
+		add(robust.Message{
+			Session: robust.Id{Id: uint64(3*(session%3) + 1)},
+			Type:    robust.IRCFromClient,
+			Data: fmt.Sprintf(fuzzCommands[cmd%len(fuzzCommands)],
+				fuzzChannels[arg%len(fuzzChannels)],
+				fuzzNicks[(arg/3)%len(fuzzNicks)],
+				fuzzWords[(arg/12)%len(fuzzWords)]),
+		})
+	}
+	if recent > len(logs) {
+		recent = len(logs)
+	}
+	now := time.Now()
+	for idx := len(logs) - recent; idx < len(logs); idx++ {
+		var msg robust.Message
+		if err := json.Unmarshal(logs[idx].Data, &msg); err != nil {
+			panic(err)
+		}
+		msg.UnixNano = now.Add(time.Duration(idx) * time.Millisecond).UnixNano()
+		b, err := json.Marshal(&msg)
+		if err != nil {
+			panic(err)
+		}
+		logs[idx].Data = b
+	}
+	return logs
+}
+
+// FuzzCompaction verifies that compacting the log and replaying the retained
+// entries on top of the compacted state results in the same state as
+// applying the entire log.
+func FuzzCompaction(f *testing.F) {
+	f.Add([]byte{}, 0)
+	f.Add([]byte{0, 0, 0, 1, 0, 0, 0, 3, 0, 1, 12, 3}, 2)
+	f.Add([]byte{0, 0, 1, 0, 8, 1, 1, 0, 1, 1, 9, 1, 0, 5, 0}, 1)
+	f.Add([]byte{0, 0, 0, 1, 0, 0, 0, 12, 3, 0, 19, 3, 1, 20, 9, 2, 24, 0}, 3)
+	f.Add([]byte{2, 0, 2, 2, 23, 0, 2, 15, 5, 0, 0, 2, 1, 0, 2, 0, 6, 2, 0, 18, 5}, 4)
+
+	f.Fuzz(func(t *testing.T, data []byte, recent int) {
+		if recent < 0 {
+			recent = -recent
+		}
+		if len(data) > 3*100 {
+			// Longer inputs make fuzzing slow without covering more.
+			data = data[:3*100]
+		}
+
+		ircServer = ircserver.NewIRCServer("testnetwork", time.Now())
+		var err error
+		outputStream, err = outputstream.NewOutputStream("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer outputStream.Close()
+		ircstore, err := raftstore.NewMemoryStore(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ircstore.Close()
+		fsm := FSM{
+			ircstore:             ircstore,
+			lastSnapshotState:    make(map[uint64][]byte),
+			sessionExpirationDur: 10 * time.Minute,
+		}
+
+		for _, l := range fuzzLogs(data, recent) {
+			fsm.Apply(l)
+		}
+		want, err := ircServer.CanonicalState()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		snapshot, err := fsm.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer snapshot.Release()
+		rs := snapshot.(*robustSnapshot)
+
+		replayed := ircserver.NewIRCServer("testnetwork", time.Now())
+		if _, err := replayed.Unmarshal(rs.state); err != nil {
+			t.Fatal(err)
+		}
+		iterator := rs.snap.LogIterator(rs.firstIndex)
+		defer iterator.Release()
+		for available := iterator.First(); available; available = iterator.Next() {
+			var nlog raft.Log
+			if err := unmarshalLog(iterator.Value(), &nlog); err != nil {
+				t.Fatal(err)
+			}
+			msg := robust.NewMessageFromBytes(nlog.Data, robust.IdFromRaftIndex(nlog.Index))
+			if _, err := fsm.applyRobustMessage(&msg, replayed, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := replayed.CanonicalState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(got, want) {
+			t.Fatalf("state after compaction and replay differs:\ngot  %v\nwant %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+
+	"github.com/robustirc/robustirc/internal/robust"
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// compactionReport describes which log entries the next compaction would
+// remove, without removing them. See -dump_compaction_report.
+type compactionReport struct {
+	// First and Last are the first and last index of the ircstore.
+	First uint64
+	Last  uint64
+
+	// FirstRetained is the index of the first log entry which would be
+	// retained, i.e. which is too new or uses an Uncompactable command.
+	FirstRetained uint64
+
+	// Removed is the total number of log entries which would be removed.
+	Removed int
+
+	// ByCommand counts the removed log entries by IRC command (or by
+	// robust.Type for messages without an IRC command).
+	ByCommand map[string]int
+
+	// BySession counts the removed log entries by session id.
+	BySession map[string]int
+}
+
+// compactionReport runs the compaction analysis on a copy of the compacted
+// state, leaving the ircstore, the output stream and fsm.lastSnapshotState
+// untouched.
+func (fsm *FSM) compactionReport() (*compactionReport, error) {
+	first, err := fsm.ircstore.FirstIndex()
+	if err != nil {
+		return nil, err
+	}
+	last, err := fsm.ircstore.LastIndex()
+	if err != nil {
+		return nil, err
+	}
+	if first < 1 {
+		return nil, fmt.Errorf("first index of ircstore (%d) is < 1", first)
+	}
+
+	tmpServer, err := fsm.compactedState(first, false)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &compactionReport{
+		First:     first,
+		Last:      last,
+		ByCommand: make(map[string]int),
+		BySession: make(map[string]int),
+	}
+	report.FirstRetained, _, err = fsm.compact(first, last, fsm.compactionEnd(), tmpServer, func(i uint64, msg *robust.Message, ircmsg *irc.Message) {
+		report.Removed++
+		report.ByCommand[compactionKey(msg, ircmsg)]++
+		session := msg.Session.Id
+		if msg.Type == robust.CreateSession {
+			session = msg.Id.Id
+		}
+		if session != 0 {
+			report.BySession["0x"+strconv.FormatUint(session, 16)]++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// writeCompactionReport writes fsm.compactionReport() to |path| as JSON.
+func writeCompactionReport(fsm *FSM, path string) {
+	report, err := fsm.compactionReport()
+	if err != nil {
+		log.Fatalf("compactionReport(): %v\n", err)
+	}
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Compaction would remove %d of %d log entries, report written to %q\n", report.Removed, report.Last-report.First+1, path)
+}
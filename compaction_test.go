@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -17,9 +19,13 @@ import (
 	"github.com/robustirc/robustirc/internal/outputstream"
 	"github.com/robustirc/robustirc/internal/raftstore"
 	"github.com/robustirc/robustirc/internal/robust"
+	"github.com/robustirc/robustirc/internal/snapshotframe"
+	"github.com/robustirc/robustirc/internal/snapshotmeta"
 	"github.com/stapelberg/glog"
 
 	"github.com/hashicorp/raft"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/sorcix/irc.v2"
 )
 
 func appendLog(logs []*raft.Log, msg string) []*raft.Log {
@@ -53,6 +59,35 @@ func verifyEndState(t *testing.T) {
 	}
 }
 
+// verifySummary verifies the summary of the most recent snapshot in |fss|.
+func verifySummary(t *testing.T, fss raft.SnapshotStore) {
+	snapshots, err := fss.List()
+	if err != nil {
+		t.Fatalf("fss.List(): %v", err)
+	}
+	_, rc, err := fss.Open(snapshots[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	summary, err := snapshotmeta.Read(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary == nil {
+		t.Fatalf("snapshot %q has no summary", snapshots[0].ID)
+	}
+	if got, want := summary.Sessions, 1; got != want {
+		t.Errorf("summary.Sessions: got %d, want %d", got, want)
+	}
+	if got, want := summary.Channels, 1; got != want {
+		t.Errorf("summary.Channels: got %d, want %d", got, want)
+	}
+	if got, want := summary.Retained, map[string]int{"PART": 1, "JOIN": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("summary.Retained: got %v, want %v", got, want)
+	}
+}
+
 func snapshot(fsm raft.FSM, fss raft.SnapshotStore, numLogs uint64) error {
 	snapshot, err := fsm.Snapshot()
 	if err != nil {
@@ -110,34 +145,74 @@ func restore(fsm raft.FSM, fss raft.SnapshotStore, numLogs uint64) error {
 	return nil
 }
 
-// TestCompaction does a full snapshot, persists it to disk, restores it and
-// makes sure the state matches expectations. The other test functions directly
-// test what should be compacted.
-func TestCompaction(t *testing.T) {
+// newTestFSM resets ircServer and outputStream and returns an FSM with an
+// in-memory raft log. The ircstore is kept in memory, unless |tempdir| is
+// non-empty: then, it is stored in |tempdir| (which -raftdir is pointed to
+// until the test ends), so that the FSM can be restored from snapshots.
+func newTestFSM(t *testing.T, tempdir string) *FSM {
+	t.Helper()
 	ircServer = ircserver.NewIRCServer("testnetwork", time.Now())
 	var err error
 	outputStream, err = outputstream.NewOutputStream("")
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	tempdir := t.TempDir()
-	flag.Set("raftdir", tempdir)
-
-	logstore, err := raftstore.NewLevelDBStore(filepath.Join(tempdir, "raftlog"), false, false)
+	logstore, err := raftstore.NewMemoryStore(false)
 	if err != nil {
-		t.Fatalf("Unexpected error in NewLevelDBStore: %v", err)
+		t.Fatalf("Unexpected error in NewMemoryStore: %v", err)
+	}
+	var ircstore *raftstore.LevelDBStore
+	if tempdir == "" {
+		ircstore, err = raftstore.NewMemoryStore(false)
+	} else {
+		oldRaftDir := *raftDir
+		t.Cleanup(func() { *raftDir = oldRaftDir })
+		*raftDir = tempdir
+		ircstore, err = raftstore.NewLevelDBStore(filepath.Join(tempdir, "irclog"), false, false)
 	}
-	ircstore, err := raftstore.NewLevelDBStore(filepath.Join(tempdir, "irclog"), false, false)
 	if err != nil {
-		t.Fatalf("Unexpected error in NewLevelDBStore: %v", err)
+		t.Fatalf("Unexpected error creating the ircstore: %v", err)
 	}
-	fsm := FSM{
+	return &FSM{
 		store:                logstore,
 		ircstore:             ircstore,
 		lastSnapshotState:    make(map[uint64][]byte),
 		sessionExpirationDur: 10 * time.Minute,
 		ReplaceState: func(*ircserver.IRCServer, *raftstore.LevelDBStore, *outputstream.OutputStream) {
-			// no-op for the compaction test
+			// no-op for tests
 		},
 	}
+}
+
+// TestCompaction does a full snapshot, persists it to disk, restores it and
+// makes sure the state matches expectations, for each snapshot format. The
+// other test functions directly test what should be compacted.
+func TestCompaction(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		compression string
+		checksums   bool
+	}{
+		{"plain", "none", false},
+		{"gzip", "gzip", false},
+		{"checksums", "none", true},
+		{"gzip+checksums", "gzip", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(old string) { *snapshotCompression = old }(*snapshotCompression)
+			*snapshotCompression = tt.compression
+			defer func(old bool) { *snapshotChecksums = old }(*snapshotChecksums)
+			*snapshotChecksums = tt.checksums
+			testCompaction(t)
+		})
+	}
+}
+
+func testCompaction(t *testing.T) {
+	tempdir := t.TempDir()
+	fsm := newTestFSM(t, tempdir)
+	logstore := fsm.store
 
 	var logs []*raft.Log
 	logs = appendLog(logs, `{"Id": {"Id": 1}, "Type": 0, "Data": "auth"}`)
@@ -172,19 +247,20 @@ func TestCompaction(t *testing.T) {
 
 	// Snapshot twice so that we know state is carried over from one
 	// snapshot to the next.
-	if err := snapshot(&fsm, fss, uint64(len(logs))); err != nil {
+	if err := snapshot(fsm, fss, uint64(len(logs))); err != nil {
 		t.Fatal(err)
 	}
+	verifySummary(t, fss)
 	// raft uses time.Now() in the snapshot name, so advance time by 1ms to
 	// guarantee we get a different filename.
 	time.Sleep(1 * time.Millisecond)
-	if err := snapshot(&fsm, fss, uint64(len(logs))); err != nil {
+	if err := snapshot(fsm, fss, uint64(len(logs))); err != nil {
 		t.Fatal(err)
 	}
 
 	ircServer = ircserver.NewIRCServer("testnetwork", time.Now())
 
-	if err := restore(&fsm, fss, uint64(len(logs))); err != nil {
+	if err := restore(fsm, fss, uint64(len(logs))); err != nil {
 		t.Fatal(err)
 	}
 
@@ -193,27 +269,24 @@ func TestCompaction(t *testing.T) {
 	// Restore() a fresh FSM, then take another snapshot, restore it
 	// and verify the end state. This covers the code path where the
 	// previous snapshot was not done in the same process run.
-	ircstore = fsm.ircstore
-	fsm = FSM{
+	fsm = &FSM{
 		store:             logstore,
-		ircstore:          ircstore,
+		ircstore:          fsm.ircstore,
 		lastSnapshotState: make(map[uint64][]byte),
-		ReplaceState: func(*ircserver.IRCServer, *raftstore.LevelDBStore, *outputstream.OutputStream) {
-			// no-op for the compaction test
-		},
+		ReplaceState:      fsm.ReplaceState,
 	}
 
-	if err := restore(&fsm, fss, uint64(len(logs))); err != nil {
+	if err := restore(fsm, fss, uint64(len(logs))); err != nil {
 		t.Fatal(err)
 	}
 
-	if err := snapshot(&fsm, fss, uint64(len(logs))); err != nil {
+	if err := snapshot(fsm, fss, uint64(len(logs))); err != nil {
 		t.Fatal(err)
 	}
 
 	ircServer = ircserver.NewIRCServer("testnetwork", time.Now())
 
-	if err := restore(&fsm, fss, uint64(len(logs))); err != nil {
+	if err := restore(fsm, fss, uint64(len(logs))); err != nil {
 		t.Fatal(err)
 	}
 
@@ -231,3 +304,309 @@ func TestMain(m *testing.M) {
 	// TODO: cleanup tmp-outputstream and permanent-compaction*
 	os.Exit(m.Run())
 }
+
+func TestRetained(t *testing.T) {
+	defer func() { ircserver.Commands["WHOIS"].Uncompactable = false }()
+	ircserver.Commands["WHOIS"].Uncompactable = true
+
+	for _, tt := range []struct {
+		msg  robust.Message
+		want bool
+	}{
+		{robust.Message{Type: robust.CreateSession}, false},
+		{robust.Message{Type: robust.IRCFromClient, Data: "PRIVMSG #chan :hey"}, false},
+		{robust.Message{Type: robust.IRCFromClient, Data: "svsnick foo bar"}, false},
+		{robust.Message{Type: robust.IRCFromClient, Data: "whois foo"}, true},
+		// Not an IRC command at all, ProcessMessage does not touch the state.
+		{robust.Message{Type: robust.IRCFromClient, Data: "NOSUCHCOMMAND"}, false},
+	} {
+		var ircmsg *irc.Message
+		if tt.msg.Type == robust.IRCFromClient {
+			ircmsg = ircserver.ParseMessage(tt.msg.Data)
+		}
+		if got := retained(&tt.msg, ircmsg); got != tt.want {
+			t.Errorf("retained(%v): got %v, want %v", tt.msg, got, tt.want)
+		}
+	}
+}
+
+// TestCompactionRetain verifies that compaction stops at the first message
+// which uses an Uncompactable command.
+func TestCompactionRetain(t *testing.T) {
+	defer func() { ircserver.Commands["WHOIS"].Uncompactable = false }()
+	ircserver.Commands["WHOIS"].Uncompactable = true
+
+	fsm := newTestFSM(t, "")
+
+	var logs []*raft.Log
+	logs = appendLog(logs, `{"Id": {"Id": 1}, "Type": 0, "Data": "auth"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 2}, "Session": {"Id": 1}, "Type": 2, "Data": "NICK sECuRE"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 3}, "Session": {"Id": 1}, "Type": 2, "Data": "USER blah 0 * :Michael Stapelberg"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 4}, "Session": {"Id": 1}, "Type": 2, "Data": "WHOIS sECuRE"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 5}, "Session": {"Id": 1}, "Type": 2, "Data": "JOIN #chaos-hd"}`)
+	for _, log := range logs {
+		fsm.Apply(log)
+	}
+
+	snapshot, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatalf("Unexpected error in fsm.Snapshot(): %v", err)
+	}
+	defer snapshot.Release()
+	if got, want := snapshot.(*robustSnapshot).firstIndex, uint64(4); got != want {
+		t.Fatalf("snapshot.firstIndex: got %d, want %d", got, want)
+	}
+	if first, _ := fsm.ircstore.FirstIndex(); first != 4 {
+		t.Fatalf("fsm.ircstore.FirstIndex(): got %d, want %d", first, 4)
+	}
+}
+
+// TestCompactionModeState verifies that folding MODE messages into the
+// snapshot state results in exactly the channel modes of the live state,
+// including keys which were set and later removed again.
+func TestCompactionModeState(t *testing.T) {
+	fsm := newTestFSM(t, "")
+
+	var logs []*raft.Log
+	logs = appendLog(logs, `{"Id": {"Id": 1}, "Type": 0, "Data": "auth"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 2}, "Session": {"Id": 1}, "Type": 2, "Data": "NICK sECuRE"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 3}, "Session": {"Id": 1}, "Type": 2, "Data": "USER blah 0 * :Michael Stapelberg"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 4}, "Session": {"Id": 1}, "Type": 2, "Data": "JOIN #chaos-hd"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 5}, "Session": {"Id": 1}, "Type": 2, "Data": "MODE #chaos-hd +k old"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 6}, "Session": {"Id": 1}, "Type": 2, "Data": "MODE #chaos-hd -k old"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 7}, "Session": {"Id": 1}, "Type": 2, "Data": "MODE #chaos-hd +kl new 5"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 8}, "Session": {"Id": 1}, "Type": 2, "Data": "MODE #chaos-hd -l+s"}`)
+
+	// Too new to be compacted.
+	nowID := time.Now().UnixNano()
+	logs = appendLog(logs, `{"Id": {"Id": 9}, "UnixNano": `+strconv.FormatInt(nowID, 10)+`, "Session": {"Id": 1}, "Type": 2, "Data": "AWAY :lunch"}`)
+	for _, log := range logs {
+		fsm.Apply(log)
+	}
+
+	snapshot, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatalf("Unexpected error in fsm.Snapshot(): %v", err)
+	}
+	defer snapshot.Release()
+	first := snapshot.(*robustSnapshot).firstIndex
+	if first != 9 {
+		t.Fatalf("snapshot.firstIndex: got %d, want %d", first, 9)
+	}
+
+	compacted := ircserver.NewIRCServer("testnetwork", time.Now())
+	if _, err := compacted.Unmarshal(fsm.lastSnapshotState[first-1]); err != nil {
+		t.Fatal(err)
+	}
+
+	channelModes := func(i *ircserver.IRCServer) []string {
+		msg := robust.Message{
+			Id:      robust.Id{Id: 10},
+			Session: robust.Id{Id: 1},
+			Type:    robust.IRCFromClient,
+			Data:    "MODE #chaos-hd",
+		}
+		reply := i.ProcessMessage(&msg, ircserver.ParseMessage(msg.Data))
+		var got []string
+		for _, m := range reply.Messages {
+			got = append(got, m.Data)
+		}
+		return got
+	}
+	want := []string{":testnetwork 324 sECuRE #chaos-hd +knst new"}
+	if got := channelModes(compacted); !reflect.DeepEqual(got, want) {
+		t.Fatalf("compacted MODE #chaos-hd: got %q, want %q", got, want)
+	}
+	if got := channelModes(ircServer); !reflect.DeepEqual(got, want) {
+		t.Fatalf("live MODE #chaos-hd: got %q, want %q", got, want)
+	}
+}
+
+// TestCompactionReport verifies that the dry-run compaction reports what
+// would be removed, without removing anything.
+func TestCompactionReport(t *testing.T) {
+	fsm := newTestFSM(t, "")
+
+	var logs []*raft.Log
+	logs = appendLog(logs, `{"Id": {"Id": 1}, "Type": 0, "Data": "auth"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 2}, "Session": {"Id": 1}, "Type": 2, "Data": "NICK sECuRE"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 3}, "Session": {"Id": 1}, "Type": 2, "Data": "USER blah 0 * :Michael Stapelberg"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 4}, "Session": {"Id": 1}, "Type": 2, "Data": "JOIN #chaos-hd"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 5}, "Session": {"Id": 1}, "Type": 2, "Data": "privmsg #chaos-hd :heya"}`)
+
+	// Too new to be compacted.
+	nowID := time.Now().UnixNano()
+	logs = appendLog(logs, `{"Id": {"Id": 6}, "UnixNano": `+strconv.FormatInt(nowID, 10)+`, "Session": {"Id": 1}, "Type": 2, "Data": "AWAY :lunch"}`)
+	for _, log := range logs {
+		fsm.Apply(log)
+	}
+
+	report, err := fsm.compactionReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &compactionReport{
+		First:         1,
+		Last:          6,
+		FirstRetained: 6,
+		Removed:       5,
+		ByCommand: map[string]int{
+			"create_session": 1,
+			"NICK":           1,
+			"USER":           1,
+			"JOIN":           1,
+			"PRIVMSG":        1,
+		},
+		BySession: map[string]int{"0x1": 5},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Fatalf("compactionReport(): got %+v, want %+v", report, want)
+	}
+
+	if first, _ := fsm.ircstore.FirstIndex(); first != 1 {
+		t.Fatalf("fsm.ircstore.FirstIndex(): got %d, want %d", first, 1)
+	}
+	if got := len(fsm.lastSnapshotState); got != 0 {
+		t.Fatalf("len(fsm.lastSnapshotState): got %d, want 0", got)
+	}
+}
+
+func TestCompactionMetrics(t *testing.T) {
+	fsm := newTestFSM(t, "")
+
+	var logs []*raft.Log
+	logs = appendLog(logs, `{"Id": {"Id": 1}, "Type": 0, "Data": "auth"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 2}, "Session": {"Id": 1}, "Type": 2, "Data": "NICK sECuRE"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 3}, "Session": {"Id": 1}, "Type": 2, "Data": "USER blah 0 * :Michael Stapelberg"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 4}, "Session": {"Id": 1}, "Type": 2, "Data": "privmsg #chaos-hd :heya"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 5}, "Session": {"Id": 1}, "Type": 2, "Data": "privmsg #chaos-hd :heya"}`)
+
+	// Too new to be compacted.
+	nowID := time.Now().UnixNano()
+	logs = appendLog(logs, `{"Id": {"Id": 6}, "UnixNano": `+strconv.FormatInt(nowID, 10)+`, "Session": {"Id": 1}, "Type": 2, "Data": "AWAY :lunch"}`)
+	for _, log := range logs {
+		fsm.Apply(log)
+	}
+
+	examined := testutil.ToFloat64(compactionExamined)
+	privmsgs := testutil.ToFloat64(compactionDropped.WithLabelValues("PRIVMSG"))
+	snapshot, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snapshot.Release()
+
+	if got, want := testutil.ToFloat64(compactionExamined)-examined, 6.0; got != want {
+		t.Errorf("compaction_examined_entries increased by %v, want %v", got, want)
+	}
+	if got, want := testutil.ToFloat64(compactionDropped.WithLabelValues("PRIVMSG"))-privmsgs, 2.0; got != want {
+		t.Errorf(`compaction_dropped_entries{command="PRIVMSG"} increased by %v, want %v`, got, want)
+	}
+	if got, want := testutil.ToFloat64(lastSnapshotIndex), 6.0; got != want {
+		t.Errorf("last_snapshot_index: got %v, want %v", got, want)
+	}
+}
+
+// TestRestoreCorruptedSnapshot verifies that restoring a truncated or
+// corrupted checksummed snapshot fails instead of restoring partial state, and
+// that restoring the intact snapshot reports its progress.
+func TestRestoreCorruptedSnapshot(t *testing.T) {
+	defer func(old bool) { *snapshotChecksums = old }(*snapshotChecksums)
+	*snapshotChecksums = true
+
+	tempdir := t.TempDir()
+	fsm := newTestFSM(t, tempdir)
+	ircstore := fsm.ircstore
+
+	var logs []*raft.Log
+	logs = appendLog(logs, `{"Id": {"Id": 1}, "Type": 0, "Data": "auth"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 2}, "Session": {"Id": 1}, "Type": 2, "Data": "NICK sECuRE"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 3}, "Session": {"Id": 1}, "Type": 2, "Data": "USER blah 0 * :Michael Stapelberg"}`)
+	nowID := time.Now().UnixNano()
+	logs = appendLog(logs, `{"Id": {"Id": 4}, "UnixNano": `+strconv.FormatInt(nowID, 10)+`, "Session": {"Id": 1}, "Type": 2, "Data": "JOIN #chaos-hd"}`)
+	for _, log := range logs {
+		fsm.Apply(log)
+	}
+
+	fss, err := raft.NewFileSnapshotStore(tempdir, 5, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshot(fsm, fss, uint64(len(logs))); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := fss.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, readcloser, err := fss.Open(snapshots[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := ioutil.ReadAll(readcloser)
+	readcloser.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupted := append([]byte(nil), valid...)
+	corrupted[len(corrupted)/2] ^= 0xff
+
+	for _, tt := range []struct {
+		name     string
+		contents []byte
+		want     error
+	}{
+		{"truncated", valid[:len(valid)-1], snapshotframe.ErrTruncated},
+		{"truncated record", valid[:len(valid)/2], snapshotframe.ErrTruncated},
+		{"corrupted", corrupted, snapshotframe.ErrChecksum},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := fsm.Restore(ioutil.NopCloser(bytes.NewReader(tt.contents)))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("fsm.Restore(): got %v, want %v", err, tt.want)
+			}
+			// The state from before the failed restore must be intact.
+			if got, want := ircServer.NumSessions(), 1; got != want {
+				t.Errorf("NumSessions(): got %d, want %d", got, want)
+			}
+			if fsm.ircstore != ircstore {
+				t.Errorf("fsm.ircstore was replaced")
+			}
+			if last, err := fsm.ircstore.LastIndex(); err != nil || last != 4 {
+				t.Errorf("ircstore.LastIndex(): got %d, %v, want 4, nil", last, err)
+			}
+			if _, err := os.Stat(filepath.Join(tempdir, "irclog.restore")); !os.IsNotExist(err) {
+				t.Errorf("staging irclog was not removed: %v", err)
+			}
+		})
+	}
+
+	// Restore the snapshot through a sizedSnapshotStore, as raft does.
+	_, readcloser, err = (&sizedSnapshotStore{fss}).Open(snapshots[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fsm.Restore(readcloser); err != nil {
+		t.Fatalf("fsm.Restore(): %v", err)
+	}
+	if got, want := ircServer.NumSessions(), 1; got != want {
+		t.Fatalf("NumSessions(): got %d, want %d", got, want)
+	}
+	if got, want := testutil.ToFloat64(restoreBytesRead), float64(len(valid)); got != want {
+		t.Errorf("restore_bytes_read: got %v, want %v", got, want)
+	}
+	if got, want := testutil.ToFloat64(restoreBytesTotal), float64(len(valid)); got != want {
+		t.Errorf("restore_bytes_total: got %v, want %v", got, want)
+	}
+	// The summary, the state and the retained JOIN.
+	if got, want := testutil.ToFloat64(restoreEntries), 3.0; got != want {
+		t.Errorf("restore_entries: got %v, want %v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(tempdir, "irclog.restore")); !os.IsNotExist(err) {
+		t.Errorf("staging irclog was not moved into place: %v", err)
+	}
+	if last, _ := fsm.ircstore.LastIndex(); last != 4 {
+		t.Errorf("ircstore.LastIndex(): got %d, want 4", last)
+	}
+}
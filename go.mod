@@ -3,8 +3,10 @@ module github.com/robustirc/robustirc
 require (
 	github.com/BurntSushi/toml v0.3.1
 	github.com/armon/go-metrics v0.3.3
+	github.com/dgraph-io/badger/v2 v2.2007.2
 	github.com/golang/protobuf v1.3.2
 	github.com/hashicorp/go-hclog v0.14.1
+	github.com/hashicorp/golang-lru v0.5.0
 	github.com/hashicorp/raft v1.1.2
 	github.com/prometheus/client_golang v1.4.0
 	github.com/prometheus/common v0.9.1
@@ -14,28 +16,34 @@ require (
 	github.com/sergi/go-diff v1.0.0
 	github.com/stapelberg/glog v0.0.0-20160603071839-f15f13b47694
 	github.com/syndtr/goleveldb v0.0.0-20181012014443-6b91fda63f2e
-	golang.org/x/net v0.0.0-20190613194153-d28f0bde5980
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/net v0.0.0-20190620200207-3b0461eec859
 	gopkg.in/sorcix/irc.v2 v2.0.0-20190306112350-8d7a73540b90
 )
 
 require (
+	github.com/DataDog/zstd v1.4.1 // indirect
 	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash v1.1.0 // indirect
 	github.com/cespare/xxhash/v2 v2.1.1 // indirect
+	github.com/dgraph-io/ristretto v0.0.3-0.20200630154024-f66de99634de // indirect
+	github.com/dgryski/go-farm v0.0.0-20190423205320-6a90982ecee2 // indirect
+	github.com/dustin/go-humanize v1.0.0 // indirect
 	github.com/fatih/color v1.7.0 // indirect
-	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
+	github.com/golang/snappy v0.0.1 // indirect
 	github.com/hashicorp/go-immutable-radix v1.0.0 // indirect
 	github.com/hashicorp/go-msgpack v0.5.5 // indirect
-	github.com/hashicorp/golang-lru v0.5.0 // indirect
 	github.com/mattn/go-colorable v0.1.4 // indirect
 	github.com/mattn/go-isatty v0.0.10 // indirect
 	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
 	github.com/onsi/ginkgo v1.6.0 // indirect
 	github.com/onsi/gomega v1.4.2 // indirect
+	github.com/pkg/errors v0.8.1 // indirect
 	github.com/prometheus/client_model v0.2.0 // indirect
 	github.com/prometheus/procfs v0.0.8 // indirect
 	github.com/rjeczalik/notify v0.9.2 // indirect
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e // indirect
-	golang.org/x/sys v0.0.0-20200122134326-e047566fdf82 // indirect
+	golang.org/x/sys v0.0.0-20200202164722-d101bd2416d5 // indirect
 	golang.org/x/text v0.3.0 // indirect
 )
 
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/raft"
+	"github.com/robustirc/robustirc/internal/raftstore"
+)
+
+// logBounds are the first and last index of a raft.LogStore, both 0 if the
+// store is empty.
+type logBounds struct {
+	first, last uint64
+}
+
+func boundsOf(s raft.LogStore) (logBounds, error) {
+	first, err := s.FirstIndex()
+	if err != nil {
+		return logBounds{}, err
+	}
+	last, err := s.LastIndex()
+	if err != nil {
+		return logBounds{}, err
+	}
+	return logBounds{first: first, last: last}, nil
+}
+
+// checkIntegrity verifies that the latest snapshot (which contains all log
+// entries up to and including |snapshotIndex|, 0 if there is no snapshot)
+// and the raftlog together contain all log entries, which is not the case
+// after e.g. restoring only some of the files in -raftdir from a backup.
+//
+// The irclog is derived from the snapshot and the raftlog: Restore() re-creates
+// it from the snapshot and Apply() adds the raftlog entries. heal is true if
+// the irclog contains entries which neither of them contain and hence must be
+// re-created.
+func checkIntegrity(snapshotIndex uint64, raftlog, irclog logBounds) (heal bool, err error) {
+	if snapshotIndex == 0 && raftlog.first > 1 {
+		return false, fmt.Errorf("raftlog starts at index %d, but there is no snapshot containing indexes 1 to %d", raftlog.first, raftlog.first-1)
+	}
+	if snapshotIndex > 0 && raftlog.first > snapshotIndex+1 {
+		return false, fmt.Errorf("raftlog starts at index %d, but the latest snapshot only contains indexes up to %d: indexes %d to %d are missing", raftlog.first, snapshotIndex, snapshotIndex+1, raftlog.first-1)
+	}
+	known := snapshotIndex
+	if raftlog.last > known {
+		known = raftlog.last
+	}
+	return irclog.last > known, nil
+}
+
+// verifyStores runs checkIntegrity on the stores in -raftdir. In case the
+// irclog needs to be re-created, it returns the new (empty) irclog, which the
+// raftlog entries will be applied to.
+func verifyStores(snapshots raft.SnapshotStore, logStore raft.LogStore, ircStore *raftstore.LevelDBStore) (*raftstore.LevelDBStore, error) {
+	metas, err := snapshots.List()
+	if err != nil {
+		return nil, err
+	}
+	var snapshotIndex uint64
+	if len(metas) > 0 {
+		snapshotIndex = metas[0].Index
+	}
+	raftlog, err := boundsOf(logStore)
+	if err != nil {
+		return nil, err
+	}
+	irclog, err := boundsOf(ircStore)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Verifying stores: snapshot index %d, raftlog indexes [%d, %d], irclog indexes [%d, %d]",
+		snapshotIndex, raftlog.first, raftlog.last, irclog.first, irclog.last)
+	heal, err := checkIntegrity(snapshotIndex, raftlog, irclog)
+	if err != nil {
+		return nil, fmt.Errorf("%v. Restore all of %q from a consistent backup or wipe it and re-join the network.", err, *raftDir)
+	}
+	if !heal {
+		return ircStore, nil
+	}
+
+	log.Printf("irclog contains indexes up to %d, which neither the snapshot nor the raftlog contain. Re-creating the irclog from the snapshot and raftlog.", irclog.last)
+	if err := ircStore.Close(); err != nil {
+		return nil, err
+	}
+	irclogPath := filepath.Join(*raftDir, "irclog")
+	if err := os.RemoveAll(irclogPath); err != nil {
+		return nil, err
+	}
+	return raftstore.NewLevelDBStoreWithOptions(irclogPath, true, *useProtobuf, leveldbOptions(*irclogSync))
+}
+
+// fsckStores checks the raftlog (if it is a LevelDBStore) and the irclog for
+// corruption and their bounds for consistency with the latest snapshot, see
+// -fsck. It returns whether no problems were found (or all were repaired).
+func fsckStores(snapshots raft.SnapshotStore, logStore raftstore.LogStore, ircStore *raftstore.LevelDBStore, repair bool) (bool, error) {
+	ok := true
+	if leveldbStore, isLevelDB := logStore.(*raftstore.LevelDBStore); isLevelDB {
+		report, err := leveldbStore.Fsck(repair)
+		if err != nil {
+			return false, fmt.Errorf("raftlog: %v", err)
+		}
+		log.Printf("fsck raftlog: %v", report)
+		if len(report.Gaps) > 0 {
+			log.Printf("fsck raftlog: the raftlog must not contain gaps. Restore %q from a backup or wipe it and re-join the network.", *raftDir)
+			ok = false
+		}
+		ok = ok && (report.OK() || report.Repaired)
+	} else {
+		log.Printf("fsck raftlog: skipped, only LevelDB raftlogs can be checked")
+	}
+
+	report, err := ircStore.Fsck(repair)
+	if err != nil {
+		return false, fmt.Errorf("irclog: %v", err)
+	}
+	log.Printf("fsck irclog: %v", report)
+	ok = ok && (report.OK() || report.Repaired)
+
+	metas, err := snapshots.List()
+	if err != nil {
+		return false, err
+	}
+	var snapshotIndex uint64
+	if len(metas) > 0 {
+		snapshotIndex = metas[0].Index
+	}
+	raftlog, err := boundsOf(logStore)
+	if err != nil {
+		return false, err
+	}
+	irclog, err := boundsOf(ircStore)
+	if err != nil {
+		return false, err
+	}
+	heal, err := checkIntegrity(snapshotIndex, raftlog, irclog)
+	if err != nil {
+		log.Printf("fsck: %v", err)
+		return false, nil
+	}
+	if heal {
+		log.Printf("fsck: irclog contains indexes up to %d, which neither the snapshot nor the raftlog contain. It will be re-created on the next start.", irclog.last)
+	}
+	return ok, nil
+}
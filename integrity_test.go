@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestCheckIntegrity(t *testing.T) {
+	for _, tt := range []struct {
+		desc          string
+		snapshotIndex uint64
+		raftlog       logBounds
+		irclog        logBounds
+		heal          bool
+		wantErr       bool
+	}{
+		{desc: "empty"},
+		{
+			desc:    "no snapshot yet",
+			raftlog: logBounds{1, 10},
+			irclog:  logBounds{1, 9},
+		},
+		{
+			desc:          "snapshot and raftlog overlap",
+			snapshotIndex: 10,
+			raftlog:       logBounds{5, 20},
+			irclog:        logBounds{8, 20},
+		},
+		{
+			desc:          "raftlog fully compacted",
+			snapshotIndex: 10,
+			irclog:        logBounds{8, 10},
+		},
+		{
+			desc:    "raftlog restored from an older backup",
+			raftlog: logBounds{1, 10},
+			irclog:  logBounds{1, 15},
+			heal:    true,
+		},
+		{
+			desc:   "irclog without raftlog",
+			irclog: logBounds{1, 15},
+			heal:   true,
+		},
+		{
+			desc:    "snapshots missing",
+			raftlog: logBounds{11, 20},
+			wantErr: true,
+		},
+		{
+			desc:          "gap between snapshot and raftlog",
+			snapshotIndex: 10,
+			raftlog:       logBounds{15, 20},
+			wantErr:       true,
+		},
+	} {
+		heal, err := checkIntegrity(tt.snapshotIndex, tt.raftlog, tt.irclog)
+		if got, want := err != nil, tt.wantErr; got != want {
+			t.Errorf("%s: checkIntegrity() = %v, want error: %v", tt.desc, err, want)
+			continue
+		}
+		if got, want := heal, tt.heal; got != want {
+			t.Errorf("%s: checkIntegrity() heal = %v, want %v", tt.desc, got, want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/ircserver"
+	"github.com/robustirc/robustirc/internal/robust"
+)
+
+// recordReadActivity notes that |session| just received data (messages or
+// pings) via a GetMessages request served by this node.
+//
+// Read activity is deliberately not replicated: writing to raft on every
+// GetMessages poll would be prohibitively expensive. It is only used for
+// display purposes on this node, session expiration is still driven by the
+// replicated Session.LastActivity (see IRCServer.ExpireSessions).
+func (api *HTTP) recordReadActivity(session robust.Id) {
+	api.readActivityMu.Lock()
+	defer api.readActivityMu.Unlock()
+	api.readActivity[session.Id] = time.Now()
+}
+
+// copyReadActivity returns a copy of the read activity, keyed by session id.
+func (api *HTTP) copyReadActivity() map[uint64]time.Time {
+	api.readActivityMu.Lock()
+	defer api.readActivityMu.Unlock()
+	result := make(map[uint64]time.Time, len(api.readActivity))
+	for id, t := range api.readActivity {
+		result[id] = t
+	}
+	return result
+}
+
+// recordReceived counts |data|, which |session| posted to this node, for
+// STATS m and l. Like the read activity, the counters are not replicated.
+// Requests which another node proxied to this node were already counted by
+// that node.
+func (api *HTTP) recordReceived(r *http.Request, session robust.Id, data string) {
+	if api.vouched(r) {
+		return
+	}
+	ircmsg := ircserver.ParseMessage(data)
+	api.receivedMu.Lock()
+	defer api.receivedMu.Unlock()
+	api.messagesReceived[session.Id]++
+	// Unknown commands are not counted, so that clients cannot grow the
+	// map without bounds.
+	if ircmsg != nil && ircserver.IsCommand(ircmsg.Command) {
+		api.commandCounts[strings.ToUpper(ircmsg.Command)]++
+	}
+}
+
+// copyReceived returns copies of the counters of recordReceived.
+func (api *HTTP) copyReceived() (map[string]uint64, map[uint64]uint64) {
+	api.receivedMu.Lock()
+	defer api.receivedMu.Unlock()
+	commandCounts := make(map[string]uint64, len(api.commandCounts))
+	for command, count := range api.commandCounts {
+		commandCounts[command] = count
+	}
+	messagesReceived := make(map[uint64]uint64, len(api.messagesReceived))
+	for id, count := range api.messagesReceived {
+		messagesReceived[id] = count
+	}
+	return commandCounts, messagesReceived
+}
+
+// PruneReadActivity forgets the read activity (and the received message
+// counters) of sessions which no longer exist. It should be called
+// periodically on every node.
+func (api *HTTP) PruneReadActivity() {
+	sessions := api.ircServer().GetSessions()
+	api.readActivityMu.Lock()
+	defer api.readActivityMu.Unlock()
+	for id := range api.readActivity {
+		if _, ok := sessions[robust.Id{Id: id}]; !ok {
+			delete(api.readActivity, id)
+		}
+	}
+	api.receivedMu.Lock()
+	defer api.receivedMu.Unlock()
+	for id := range api.messagesReceived {
+		if _, ok := sessions[robust.Id{Id: id}]; !ok {
+			delete(api.messagesReceived, id)
+		}
+	}
+}
+
+// idle returns how long ago |s| was last active, taking into account both
+// the replicated activity and the read activity observed by this node.
+func idle(s ircserver.Session, lastRead time.Time) time.Duration {
+	last := s.LastActivity
+	if lastRead.After(last) {
+		last = lastRead
+	}
+	return time.Since(last).Truncate(time.Second)
+}
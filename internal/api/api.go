@@ -12,9 +12,9 @@ import (
 	"net/url"
 	"os"
 	"runtime"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/protobuf/proto"
@@ -25,6 +25,7 @@ import (
 	"github.com/robustirc/robustirc/internal/ircserver"
 	"github.com/robustirc/robustirc/internal/outputstream"
 	"github.com/robustirc/robustirc/internal/raftstore"
+	"github.com/robustirc/robustirc/internal/resolver"
 	"github.com/robustirc/robustirc/internal/robust"
 	"github.com/stapelberg/glog"
 )
@@ -80,6 +81,7 @@ type HTTP struct {
 	outputUnlocked    *outputstream.OutputStream
 
 	raftNode        *raft.Raft
+	snapshots       raft.SnapshotStore
 	transport       *rafthttp.HTTPTransport
 	network         string
 	networkPassword string
@@ -90,6 +92,21 @@ type HTTP struct {
 	getMessagesRequests   map[string]GetMessagesStats
 	getMessagesRequestsMu sync.RWMutex
 
+	// draining is set to 1 (atomically) once the node is shutting down, see
+	// Drain.
+	draining uint32
+
+	// readActivity contains the time at which each session last received
+	// data via GetMessages from this node, see recordReadActivity.
+	readActivity   map[uint64]time.Time
+	readActivityMu sync.Mutex
+
+	// commandCounts and messagesReceived count the messages which clients
+	// posted to this node (for STATS m and l), see recordReceived.
+	commandCounts    map[string]uint64
+	messagesReceived map[uint64]uint64
+	receivedMu       sync.Mutex
+
 	throttleMu         sync.Mutex
 	lastWrongPassword  time.Time
 	throttlingExponent int
@@ -98,6 +115,69 @@ type HTTP struct {
 	useProtobuf bool
 
 	raftProtocolVersion int
+
+	// localQueryStaleness is the maximum time since the last contact with
+	// the raft leader for which read-only IRC commands are answered from
+	// local state. Zero disables answering queries locally.
+	localQueryStaleness time.Duration
+
+	// shedApplyLatency and shedQueueDepth are the load shedding thresholds
+	// (see shouldShed). Zero disables the respective threshold.
+	shedApplyLatency time.Duration
+	shedQueueDepth   uint64
+
+	// applyLatency (nanoseconds) and applyLatencyUpdated (unix nanoseconds)
+	// must be accessed atomically, see recordApplyLatency.
+	applyLatency        uint64
+	applyLatencyUpdated int64
+
+	// localReplySeq numbers the locally answered replies (see
+	// localReplyOffset). It must be accessed atomically.
+	localReplySeq uint64
+
+	// partitionHandler serves /partition when the partition testing hooks
+	// are enabled, see EnablePartitionHooks.
+	partitionHandler http.Handler
+
+	// stateHashHandler serves /statehash when state hashes are recorded,
+	// see EnableStateHashes.
+	stateHashHandler http.Handler
+
+	// raftlog is compacted by /compact and backed up by /backup, see
+	// SetRaftlog. It is nil unless the raftlog is a LevelDBStore.
+	raftlog *raftstore.LevelDBStore
+
+	// servicesListener is true when services must link via the dedicated
+	// services listener, see ServicesHandler.
+	servicesListener bool
+
+	// archiveFiles are the files rendered by EnableArchive when they are
+	// served under /archive/, keyed by file name.
+	archiveFiles map[string][]byte
+	archiveMu    sync.RWMutex
+
+	// resolver looks up hostnames of new sessions when the ResolveHostnames
+	// option is enabled, see resolveHostname.
+	resolver *resolver.Resolver
+}
+
+// EnableStateHashes makes |h| available as /statehash on the private API.
+// Must be called before serving requests.
+func (api *HTTP) EnableStateHashes(h http.Handler) {
+	api.stateHashHandler = h
+}
+
+// SetRaftlog makes POST /compact and GET /backup include |raftlog| in
+// addition to the irclog. Must be called before serving requests.
+func (api *HTTP) SetRaftlog(raftlog *raftstore.LevelDBStore) {
+	api.raftlog = raftlog
+}
+
+// EnablePartitionHooks makes |h| available as /partition on the private API.
+// Must be called before serving requests. Only used for testing, see package
+// partition.
+func (api *HTTP) EnablePartitionHooks(h http.Handler) {
+	api.partitionHandler = h
 }
 
 func (h *HTTP) ircServer() *ircserver.IRCServer {
@@ -127,21 +207,29 @@ func (h *HTTP) ReplaceState(ircServer *ircserver.IRCServer, ircStore *raftstore.
 }
 
 // NewHTTP creates a new HTTP API handler.
-func NewHTTP(ircServer *ircserver.IRCServer, raftNode *raft.Raft, ircStore *raftstore.LevelDBStore, output *outputstream.OutputStream, transport *rafthttp.HTTPTransport, network string, networkPassword string, raftDir string, peerAddr string, mux *http.ServeMux, useProtobuf bool, raftProtocolVersion int) *HTTP {
+func NewHTTP(ircServer *ircserver.IRCServer, raftNode *raft.Raft, snapshots raft.SnapshotStore, ircStore *raftstore.LevelDBStore, output *outputstream.OutputStream, transport *rafthttp.HTTPTransport, network string, networkPassword string, raftDir string, peerAddr string, mux *http.ServeMux, useProtobuf bool, raftProtocolVersion int, localQueryStaleness time.Duration, shedApplyLatency time.Duration, shedQueueDepth uint64) *HTTP {
 	api := &HTTP{
 		ircServerUnlocked: ircServer,
 		ircStoreUnlocked:  ircStore,
 		outputUnlocked:    output,
 
 		raftNode:            raftNode,
+		snapshots:           snapshots,
 		transport:           transport,
 		network:             network,
 		networkPassword:     networkPassword,
 		raftDir:             raftDir,
 		peerAddr:            peerAddr,
 		getMessagesRequests: make(map[string]GetMessagesStats),
+		readActivity:        make(map[uint64]time.Time),
+		commandCounts:       make(map[string]uint64),
+		messagesReceived:    make(map[uint64]uint64),
 		useProtobuf:         useProtobuf,
 		raftProtocolVersion: raftProtocolVersion,
+		localQueryStaleness: localQueryStaleness,
+		shedApplyLatency:    shedApplyLatency,
+		shedQueueDepth:      shedQueueDepth,
+		resolver:            resolver.New(2*time.Second, time.Hour),
 	}
 
 	mux.HandleFunc("/robustirc/v1/", api.dispatchPublic)
@@ -168,6 +256,27 @@ type GetMessagesStats struct {
 	TrustedBridge string
 	cancel        func(superseded bool)
 	api           *HTTP
+
+	// localReplies receives replies to queries which were answered
+	// locally, see answerQueryLocally.
+	localReplies chan<- localReply
+
+	// lastSeen is the id of the last IRC output message which was sent to
+	// the client. It must be accessed atomically.
+	lastSeen *uint64
+}
+
+// maxQueueDepth caps the number of pending messages which QueueDepth counts.
+const maxQueueDepth = 10000
+
+// QueueDepth returns the number of IRC output messages for the session which
+// have not yet been sent to the client.
+func (stats GetMessagesStats) QueueDepth() uint64 {
+	if stats.lastSeen == nil {
+		return 0
+	}
+	lastSeen := robust.Id{Id: atomic.LoadUint64(stats.lastSeen)}
+	return uint64(stats.api.output().Pending(stats.Session, lastSeen, maxQueueDepth))
 }
 
 func (stats GetMessagesStats) NickWithFallback() string {
@@ -244,6 +353,10 @@ func (api *HTTP) dispatchPrivate(w http.ResponseWriter, r *http.Request) {
 			api.handleStatusGetMessage(w, r)
 			return
 
+		case "/status/getmessage/network":
+			api.handleStatusNetworkGetMessage(w, r)
+			return
+
 		case "/status/sessions":
 			api.handleStatusSessions(w, r)
 			return
@@ -268,6 +381,10 @@ func (api *HTTP) dispatchPrivate(w http.ResponseWriter, r *http.Request) {
 			api.handleLeader(w, r)
 			return
 
+		case "/getmessagesrequests":
+			api.handleGetMessagesRequests(w, r)
+			return
+
 		case "/config":
 			api.handleGetConfig(w, r)
 			return
@@ -275,6 +392,22 @@ func (api *HTTP) dispatchPrivate(w http.ResponseWriter, r *http.Request) {
 		case "/metrics":
 			promhttp.Handler().ServeHTTP(w, r)
 			return
+
+		case "/partition":
+			if api.partitionHandler != nil {
+				api.partitionHandler.ServeHTTP(w, r)
+				return
+			}
+
+		case "/backup":
+			api.handleBackup(w, r)
+			return
+
+		case "/statehash":
+			if api.stateHashHandler != nil {
+				api.stateHashHandler.ServeHTTP(w, r)
+				return
+			}
 		}
 
 	case http.MethodPost:
@@ -292,10 +425,20 @@ func (api *HTTP) dispatchPrivate(w http.ResponseWriter, r *http.Request) {
 			api.handlePart(w, r)
 			return
 
+		case "/transferleadership":
+			api.handleTransferLeadership(w, r)
+			return
+
 		case "/quit":
 			api.handleQuit(w, r)
 			return
 
+		case "/partition":
+			if api.partitionHandler != nil {
+				api.partitionHandler.ServeHTTP(w, r)
+				return
+			}
+
 		case "/config":
 			api.handlePostConfig(w, r)
 			return
@@ -303,6 +446,14 @@ func (api *HTTP) dispatchPrivate(w http.ResponseWriter, r *http.Request) {
 		case "/kill":
 			api.handleKill(w, r)
 			return
+
+		case "/import":
+			api.handleImport(w, r)
+			return
+
+		case "/compact":
+			api.handleCompact(w, r)
+			return
 		}
 	}
 
@@ -354,7 +505,7 @@ func (api *HTTP) dispatchPublic(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	http.Error(w, "Not found", http.StatusNotFound)
+	api.writeError(w, http.StatusNotFound, codeNotFound, "Not found", 0)
 }
 
 // applyMessageWait applies the specified message to the network via
@@ -362,6 +513,7 @@ func (api *HTTP) dispatchPublic(w http.ResponseWriter, r *http.Request) {
 // Raft index.
 func (api *HTTP) applyMessageWait(msg *robust.Message, timeout time.Duration) error {
 	msg.UnixNano = time.Now().UnixNano()
+	msg.Origin = api.peerAddr
 
 	var (
 		msgbytes []byte
@@ -380,10 +532,12 @@ func (api *HTTP) applyMessageWait(msg *robust.Message, timeout time.Duration) er
 		}
 	}
 
+	start := time.Now()
 	f := api.raftNode.Apply(msgbytes, timeout)
 	if err := f.Error(); err != nil {
 		return err
 	}
+	api.recordApplyLatency(time.Since(start))
 	if err, ok := f.Response().(error); ok {
 		return err
 	}
@@ -399,8 +553,7 @@ func (api *HTTP) ApplyMessageWait(msg *robust.Message, timeout time.Duration) er
 func (api *HTTP) maybeProxyToLeader(w http.ResponseWriter, r *http.Request, body io.ReadCloser) {
 	leader := string(api.raftNode.Leader())
 	if leader == "" {
-		http.Error(w, fmt.Sprintf("No leader known. Please try another server."),
-			http.StatusInternalServerError)
+		api.writeError(w, http.StatusServiceUnavailable, codeNoLeader, "No leader known. Please try another server.", 1)
 		return
 	}
 
@@ -408,7 +561,7 @@ func (api *HTTP) maybeProxyToLeader(w http.ResponseWriter, r *http.Request, body
 	if !ok {
 		u, err := url.Parse("https://" + leader)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("url.Parse(): %v", err), http.StatusInternalServerError)
+			api.writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("url.Parse(): %v", err), 0)
 			return
 		}
 		p = httputil.NewSingleHostReverseProxy(u)
@@ -422,6 +575,11 @@ func (api *HTTP) maybeProxyToLeader(w http.ResponseWriter, r *http.Request, body
 	location.Host = leader
 	w.Header().Set("Content-Location", location.String())
 	log.Printf("Proxying request (%q) to leader %q\n", r.URL.Path, leader)
+	body, err := api.prepareProxyRequest(r, body)
+	if err != nil {
+		api.writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("prepareProxyRequest(): %v", err), 0)
+		return
+	}
 	r.Body = body
 	p.ServeHTTP(w, r)
 }
@@ -429,10 +587,11 @@ func (api *HTTP) maybeProxyToLeader(w http.ResponseWriter, r *http.Request, body
 func (api *HTTP) session(r *http.Request, sessionId string) (robust.Id, error) {
 	var sessionid robust.Id
 
-	id, err := strconv.ParseUint(sessionId, 0, 64)
+	parsed, err := robust.ParseSessionId(sessionId)
 	if err != nil {
 		return sessionid, fmt.Errorf("invalid session: %v", err)
 	}
+	id := parsed.Id
 
 	header := r.Header.Get("X-Session-Auth")
 	if header == "" {
@@ -461,7 +620,7 @@ func (api *HTTP) sessionOrProxy(w http.ResponseWriter, r *http.Request, sessionI
 	}
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		api.writeError(w, http.StatusNotFound, codeInvalidSession, err.Error(), 0)
 	}
 	return sessionid, err
 }
@@ -481,6 +640,16 @@ func (api *HTTP) deleteGetMessagesRequests(sessionId string) {
 	delete(api.getMessagesRequests, sessionId)
 }
 
+// Drain aborts all GetMessages requests served by this node and rejects new
+// ones, so that clients connect to a different node. Used before shutting
+// down the node (DIE, RESTART).
+func (api *HTTP) Drain() {
+	atomic.StoreUint32(&api.draining, 1)
+	for _, stats := range api.copyGetMessagesRequests() {
+		stats.cancel(false)
+	}
+}
+
 func (api *HTTP) copyGetMessagesRequests() map[string]GetMessagesStats {
 	result := make(map[string]GetMessagesStats)
 	api.getMessagesRequestsMu.RLock()
@@ -0,0 +1,265 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/robustirc/robustirc/internal/ircserver"
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// archiveEntry is a message which was sent to an archived channel.
+type archiveEntry struct {
+	Id      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	Nick    string    `json:"nick"`
+	Command string    `json:"command"`
+	Text    string    `json:"text"`
+}
+
+// archiveChannel is the retained history of an archived channel.
+type archiveChannel struct {
+	Channel string
+	// File is the name of the channel’s files without extension.
+	File    string
+	Entries []archiveEntry
+}
+
+// archiveFileName returns the file name (without extension) for |channel|,
+// e.g. “robustirc” for “#RobustIRC”.
+func archiveFileName(channel string) string {
+	lower := string(ircserver.ChanToLower(channel))
+	return url.PathEscape(strings.TrimPrefix(lower, "#"))
+}
+
+// archiveHistory returns the messages in the retained history (i.e. the
+// irclog) which were sent to |channels|, keyed by ChanToLower(channel).
+func (api *HTTP) archiveHistory(channels []string) (map[string][]archiveEntry, error) {
+	wanted := make(map[string]bool, len(channels))
+	for _, channel := range channels {
+		wanted[string(ircserver.ChanToLower(channel))] = true
+	}
+
+	lo, err := api.ircStore().FirstIndex()
+	if err != nil {
+		return nil, err
+	}
+	hi, err := api.ircStore().LastIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	history := make(map[string][]archiveEntry)
+	if lo == 0 {
+		return history, nil
+	}
+	// Not every message goes into the ircStore, and messages may have been
+	// compacted in the meantime, so some indexes are skipped.
+	err = api.ircStore().Iterate(lo, hi, func(elog *raft.Log) error {
+		if elog.Type != raft.LogCommand {
+			return nil
+		}
+		msg := robust.NewMessageFromBytes(elog.Data, robust.IdFromRaftIndex(elog.Index))
+		if msg.Type != robust.IRCFromClient {
+			return nil
+		}
+		// Use the output instead of the input message so that the prefix
+		// and channel transforms are the same as what channel members saw.
+		output, ok := api.output().Get(msg.Id)
+		if !ok {
+			return nil
+		}
+		for _, out := range output {
+			ircmsg := ircserver.ParseMessage(out.Data)
+			if ircmsg == nil || ircmsg.Prefix == nil || len(ircmsg.Params) < 2 {
+				continue
+			}
+			if ircmsg.Command != irc.PRIVMSG &&
+				ircmsg.Command != irc.NOTICE &&
+				ircmsg.Command != irc.TOPIC {
+				continue
+			}
+			channel := string(ircserver.ChanToLower(ircmsg.Params[0]))
+			if !wanted[channel] {
+				continue
+			}
+			history[channel] = append(history[channel], archiveEntry{
+				Id:      msg.Id.String(),
+				Time:    msg.Timestamp(),
+				Nick:    ircmsg.Prefix.Name,
+				Command: ircmsg.Command,
+				Text:    ircmsg.Trailing(),
+			})
+			// All members receive the same message, so there is no need to
+			// look at the others.
+			break
+		}
+		return nil
+	})
+	return history, err
+}
+
+// renderArchive renders the retained history of |channels| into static
+// files: index.html plus an HTML and JSON file per channel. Channels which
+// are currently secret are skipped.
+func (api *HTTP) renderArchive(channels []string, now time.Time) (map[string][]byte, error) {
+	history, err := api.archiveHistory(channels)
+	if err != nil {
+		return nil, err
+	}
+
+	var archived []archiveChannel
+	for _, channel := range channels {
+		if api.ircServer().SecretChannel(channel) {
+			continue
+		}
+		entries := history[string(ircserver.ChanToLower(channel))]
+		if entries == nil {
+			entries = []archiveEntry{}
+		}
+		archived = append(archived, archiveChannel{
+			Channel: channel,
+			File:    archiveFileName(channel),
+			Entries: entries,
+		})
+	}
+	sort.Slice(archived, func(i, j int) bool {
+		return archived[i].File < archived[j].File
+	})
+
+	files := make(map[string][]byte)
+	for _, c := range archived {
+		var buf bytes.Buffer
+		if err := templates.ExecuteTemplate(&buf, "templates/archive", struct {
+			archiveChannel
+			Generated time.Time
+		}{c, now}); err != nil {
+			return nil, err
+		}
+		files[c.File+".html"] = buf.Bytes()
+
+		b, err := json.Marshal(c.Entries)
+		if err != nil {
+			return nil, err
+		}
+		files[c.File+".json"] = b
+	}
+
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, "templates/archiveindex", struct {
+		Channels  []archiveChannel
+		Generated time.Time
+	}{archived, now}); err != nil {
+		return nil, err
+	}
+	files["index.html"] = buf.Bytes()
+	return files, nil
+}
+
+// writeArchive writes |files| into |dir|. Each file is written to a temporary
+// file first and then renamed, so that web servers never serve partial files.
+func writeArchive(dir string, files map[string][]byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for name, content := range files {
+		tmp, err := ioutil.TempFile(dir, "."+name)
+		if err != nil {
+			return err
+		}
+		if _, err := tmp.Write(content); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+		if err := os.Chmod(tmp.Name(), 0644); err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+		if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+	return nil
+}
+
+// EnableArchive periodically (every |interval|) renders the retained history
+// of |channels| into static files. If |dir| is empty, the files are served
+// (without authentication) under /archive/ on |mux|, otherwise they are
+// written to |dir|. Must be called before serving requests.
+func (api *HTTP) EnableArchive(mux *http.ServeMux, channels []string, dir string, interval time.Duration) {
+	if dir == "" {
+		mux.HandleFunc("/archive/", api.handleArchive)
+	}
+	go func() {
+		for {
+			files, err := api.renderArchive(channels, time.Now())
+			if err != nil {
+				log.Printf("Could not render archive: %v", err)
+			} else if dir != "" {
+				if err := writeArchive(dir, files); err != nil {
+					log.Printf("Could not write archive: %v", err)
+				}
+			} else {
+				api.setArchiveFiles(files)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func (api *HTTP) handleArchive(w http.ResponseWriter, r *http.Request) {
+	defer exitOnRecover()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// File names are path-escaped channel names, so look at the escaped
+	// path (e.g. “#a/b” is stored as “a%2Fb.html”).
+	name := strings.TrimPrefix(path.Clean(r.URL.EscapedPath()), "/archive")
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		name = "index.html"
+	}
+
+	content, ok := api.archiveFile(name)
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(content))
+}
+
+func (api *HTTP) setArchiveFiles(files map[string][]byte) {
+	api.archiveMu.Lock()
+	defer api.archiveMu.Unlock()
+	api.archiveFiles = files
+}
+
+// archiveFile returns the rendered archive file |name|, if any.
+func (api *HTTP) archiveFile(name string) ([]byte, bool) {
+	api.archiveMu.RLock()
+	defer api.archiveMu.RUnlock()
+	content, ok := api.archiveFiles[name]
+	return content, ok
+}
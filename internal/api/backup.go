@@ -0,0 +1,155 @@
+package api
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// backupPrefix is the name prefix of the temporary directories in which
+// handleBackup assembles backups.
+const backupPrefix = "tmp-backup-"
+
+// DeleteOldBackups deletes the temporary directories of backups which were
+// interrupted, e.g. by a crash.
+func DeleteOldBackups(raftDir string) error {
+	dir, err := os.Open(raftDir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if strings.HasPrefix(name, backupPrefix) {
+			if err := os.RemoveAll(filepath.Join(raftDir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// handleBackup streams a tarball of the irclog, raftlog and snapshots of this
+// node while it keeps running. Extracting the tarball into an empty -raftdir
+// results in a node which starts from the backed up state.
+//
+// The irclog is copied before the raftlog, and the snapshots are linked last,
+// so that the raftlog and the snapshots cover everything the irclog contains
+// (see verifyStores).
+func (api *HTTP) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if api.raftlog == nil {
+		http.Error(w, "Backups require a LevelDB raftlog", http.StatusNotImplemented)
+		return
+	}
+	log.Println("Backup request from", r.RemoteAddr)
+	start := time.Now()
+
+	tmp, err := ioutil.TempDir(api.raftDir, backupPrefix)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := api.ircStore().Backup(filepath.Join(tmp, "irclog")); err != nil {
+		http.Error(w, fmt.Sprintf("Could not back up irclog: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := api.raftlog.Backup(filepath.Join(tmp, "raftlog")); err != nil {
+		http.Error(w, fmt.Sprintf("Could not back up raftlog: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := api.linkSnapshots(filepath.Join(tmp, "snapshots")); err != nil {
+		http.Error(w, fmt.Sprintf("Could not back up snapshots: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q",
+		fmt.Sprintf("robustirc-backup-%s-%s.tar", api.peerAddr, start.Format("20060102-150405"))))
+	if err := writeTar(w, tmp); err != nil {
+		// The response status was already sent, so the client can only
+		// notice the error by the truncated tarball.
+		log.Printf("Could not write backup: %v", err)
+		return
+	}
+	log.Printf("Backup done in %v", time.Since(start))
+}
+
+// linkSnapshots hard-links the files of all snapshots into |dir|, so that
+// raft can delete old snapshots while the backup is written.
+func (api *HTTP) linkSnapshots(dir string) error {
+	metas, err := api.snapshots.List()
+	if err != nil {
+		return err
+	}
+	for _, meta := range metas {
+		src := filepath.Join(api.raftDir, "snapshots", meta.ID)
+		dst := filepath.Join(dir, meta.ID)
+		if err := os.MkdirAll(dst, 0700); err != nil {
+			return err
+		}
+		fis, err := ioutil.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			if err := os.Link(filepath.Join(src, fi.Name()), filepath.Join(dst, fi.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeTar writes all files below |dir| to |w| as a tarball, with names
+// relative to |dir|.
+func writeTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	}); err != nil {
+		return err
+	}
+	return tw.Close()
+}
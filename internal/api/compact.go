@@ -0,0 +1,25 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/robustirc/robustirc/internal/raftstore"
+)
+
+// handleCompact compacts the LevelDB databases of this node, e.g. to reclaim
+// disk space right away after deleting many log entries.
+func (api *HTTP) handleCompact(w http.ResponseWriter, r *http.Request) {
+	log.Println("Compaction request from", r.RemoteAddr)
+	stores := []*raftstore.LevelDBStore{api.ircStore()}
+	if api.raftlog != nil {
+		stores = append(stores, api.raftlog)
+	}
+	for _, store := range stores {
+		if err := store.Compact(); err != nil {
+			http.Error(w, fmt.Sprintf("Compact(): %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+}
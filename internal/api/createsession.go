@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -22,14 +23,15 @@ func (api *HTTP) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 
 	b := make([]byte, 128)
 	if _, err := rand.Read(b); err != nil {
-		http.Error(w, fmt.Sprintf("Cannot generate SessionAuth cookie: %v", err), http.StatusInternalServerError)
+		api.writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Cannot generate SessionAuth cookie: %v", err), 0)
 		return
 	}
 	sessionauth := fmt.Sprintf("%x", b)
 
 	msg := &robust.Message{
-		Type: robust.CreateSession,
-		Data: sessionauth,
+		Type:       robust.CreateSession,
+		Data:       sessionauth,
+		RemoteAddr: api.remoteAddr(r),
 	}
 	if err := api.applyMessageWait(msg, 10*time.Second); err != nil {
 		if err == raft.ErrNotLeader {
@@ -37,13 +39,21 @@ func (api *HTTP) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if err == ircserver.ErrSessionLimitReached {
-			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			api.writeError(w, http.StatusTooManyRequests, codeSessionLimit, err.Error(), 10)
 			return
 		}
-		http.Error(w, fmt.Sprintf("Apply(): %v", err), http.StatusInternalServerError)
+		if errors.Is(err, ircserver.ErrBanned) {
+			api.writeError(w, http.StatusForbidden, codeForbidden, err.Error(), 0)
+			return
+		}
+		api.writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Apply(): %v", err), 0)
 		return
 	}
 
+	if api.ircServer().ResolveHostnames() {
+		go api.resolveHostname(msg.Id, msg.RemoteAddr)
+	}
+
 	sessionid := fmt.Sprintf("0x%x", msg.Id.Id)
 
 	w.Header().Set("Content-Type", "application/json")
@@ -60,3 +70,21 @@ func (api *HTTP) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Could not send /session reply: %v\n", err)
 	}
 }
+
+// resolveHostname looks up the hostname of |addr| and records it for
+// |session| in the raft log. This happens in the background so that slow DNS
+// servers do not delay the session creation.
+func (api *HTTP) resolveHostname(session robust.Id, addr string) {
+	hostname := api.resolver.Lookup(addr)
+	if hostname == "" {
+		return
+	}
+	msg := &robust.Message{
+		Session: session,
+		Type:    robust.ResolvedHostname,
+		Data:    hostname,
+	}
+	if err := api.applyMessageWait(msg, 10*time.Second); err != nil {
+		log.Printf("Could not apply hostname %q for session %v: %v\n", hostname, session, err)
+	}
+}
@@ -20,7 +20,7 @@ func (api *HTTP) handleDeleteSession(w http.ResponseWriter, r *http.Request, ses
 	var body bytes.Buffer
 	rd := io.TeeReader(r.Body, &body)
 	if err := json.NewDecoder(rd).Decode(&req); err != nil {
-		http.Error(w, fmt.Sprintf("Could not decode request: %v", err), http.StatusInternalServerError)
+		api.writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Could not decode request: %v", err), 0)
 		return
 	}
 
@@ -39,7 +39,7 @@ func (api *HTTP) handleDeleteSession(w http.ResponseWriter, r *http.Request, ses
 			api.maybeProxyToLeader(w, r, nopCloser{&body})
 			return
 		}
-		http.Error(w, fmt.Sprintf("Apply(): %v", err), http.StatusInternalServerError)
+		api.writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Apply(): %v", err), 0)
 		return
 	}
 }
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Error codes of the public API (CreateSession, PostMessage, GetMessages and
+// DeleteSession). Bridges should base their retry and failover logic on the
+// code, not on the HTTP status or the message.
+const (
+	// codeBadRequest: the request is malformed. Retrying will not help.
+	codeBadRequest = "bad_request"
+
+	// codeInvalidSession: the session does not exist (anymore) or the
+	// X-Session-Auth header does not match. Create a new session.
+	codeInvalidSession = "invalid_session"
+
+	// codeSessionNotYetSeen: this node has not yet applied the creation of
+	// the session. Retry after retry_after seconds or use a different node.
+	codeSessionNotYetSeen = "session_not_yet_seen"
+
+	// codeNoLeader: the raft network currently has no leader. Retry after
+	// retry_after seconds, preferably on a different node.
+	codeNoLeader = "no_leader"
+
+	// codePartitioned: this node lost contact to the rest of the network.
+	// Use a different node, e.g. leader_hint.
+	codePartitioned = "partitioned"
+
+	// codeOverloaded: the network sheds load. Retry the same request after
+	// retry_after seconds.
+	codeOverloaded = "overloaded"
+
+	// codeShuttingDown: this node is shutting down (DIE or RESTART). Use a
+	// different node.
+	codeShuttingDown = "shutting_down"
+
+	// codeSessionLimit: the network reached its MaxSessions limit. Retry after
+	// retry_after seconds.
+	codeSessionLimit = "session_limit"
+
+	// codeForbidden: the request is not permitted, e.g. services linking via
+	// the public listener. Retrying will not help.
+	codeForbidden = "forbidden"
+
+	// codeNotFound: the requested API endpoint does not exist.
+	codeNotFound = "not_found"
+
+	// codeInternal: any other error, e.g. a raft Apply() timeout. Retry,
+	// preferably on a different node.
+	codeInternal = "internal"
+)
+
+// apiError is the JSON body of all error replies of the public API.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// RetryAfter is the number of seconds after which the request should be
+	// retried. Also sent as Retry-After header.
+	RetryAfter int `json:"retry_after,omitempty"`
+
+	// LeaderHint is the address of the raft leader as known to this node, if
+	// this node is not the leader itself.
+	LeaderHint string `json:"leader_hint,omitempty"`
+}
+
+// writeError replies to the request with the specified error code and HTTP
+// status. retryAfter is in seconds, use 0 to not suggest a delay.
+func (api *HTTP) writeError(w http.ResponseWriter, status int, code, message string, retryAfter int) {
+	e := apiError{
+		Code:       code,
+		Message:    message,
+		RetryAfter: retryAfter,
+	}
+	if api.raftNode != nil {
+		if leader := string(api.raftNode.Leader()); leader != api.peerAddr {
+			e.LeaderHint = leader
+		}
+	}
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(&e); err != nil {
+		log.Printf("Could not send error reply: %v\n", err)
+	}
+}
@@ -7,7 +7,7 @@ import (
 	"log"
 	"net/http"
 	"strconv"
-	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/raft"
@@ -34,19 +34,18 @@ func (api *HTTP) pingMessage() *robust.Message {
 }
 
 func parseLastSeen(lastSeenStr string) (first uint64, last uint64, err error) {
-	parts := strings.Split(lastSeenStr, ".")
-	if got, want := len(parts), 2; got != want {
-		return 0, 0, fmt.Errorf("Unexpected number of parts: got %d, want %d", got, want)
-	}
-	first, err = strconv.ParseUint(parts[0], 0, 64)
+	id, err := robust.ParseId(lastSeenStr)
 	if err != nil {
 		return 0, 0, err
 	}
-	last, err = strconv.ParseUint(parts[1], 0, 64)
-	if err != nil {
-		return 0, 0, err
+	// Locally answered replies (see localReplyOffset) are not part of the
+	// output stream, and their sequence numbers are only meaningful to the
+	// node which answered them. The client received the entire output batch
+	// id.Id before such a reply, so resume after that batch on any node.
+	if id.Reply >= localReplyOffset {
+		id.Reply = localReplyOffset
 	}
-	return first, last, nil
+	return id.Id, id.Reply, nil
 }
 
 func (api *HTTP) pingTicker(ctx context.Context, msgschan chan<- []*robust.Message) {
@@ -70,6 +69,9 @@ func outputToRobustMessages(msgs []outputstream.Message) []*robust.Message {
 			Type:           robust.IRCToClient,
 			Data:           msg.Data,
 			InterestingFor: msg.InterestingFor,
+			Target:         msg.Target,
+			Seq:            msg.Seq,
+			UnixNano:       msg.UnixNano,
 		}
 	}
 	return result
@@ -89,7 +91,7 @@ func (api *HTTP) getMessages(ctx context.Context, lastSeen robust.Id, msgschan c
 	// Id=1431542836610113945.
 	// Hence, we need to Get(1431542836610113945.2) to send
 	// 1431542836610113945.3 and following to the client.
-	if msgs, ok := api.output().Get(lastSeen); ok && int(lastSeen.Reply) < len(msgs) {
+	if msgs, ok := api.output().Get(lastSeen); ok && lastSeen.Reply < uint64(len(msgs)) {
 		select {
 		case <-ctx.Done():
 			return
@@ -156,9 +158,9 @@ func (api *HTTP) handleGetMessages(w http.ResponseWriter, r *http.Request, sessi
 	session, err := api.session(r, sessionId)
 	if err != nil {
 		if err == ircserver.ErrSessionNotYetSeen {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.writeError(w, http.StatusServiceUnavailable, codeSessionNotYetSeen, err.Error(), 1)
 		} else {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			api.writeError(w, http.StatusNotFound, codeInvalidSession, err.Error(), 0)
 		}
 		return
 	}
@@ -168,8 +170,7 @@ func (api *HTTP) handleGetMessages(w http.ResponseWriter, r *http.Request, sessi
 		first, last, err := parseLastSeen(ls)
 		if err != nil {
 			log.Printf("cannot parse %q: %v\n", ls, err)
-			http.Error(w, fmt.Sprintf("Malformed lastseen value (%q)", ls),
-				http.StatusInternalServerError)
+			api.writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Malformed lastseen value (%q)", ls), 0)
 			return
 		}
 		lastSeen = robust.Id{
@@ -179,12 +180,17 @@ func (api *HTTP) handleGetMessages(w http.ResponseWriter, r *http.Request, sessi
 		log.Printf("Trying to resume at %v\n", lastSeen)
 	}
 
+	if atomic.LoadUint32(&api.draining) == 1 {
+		api.writeError(w, http.StatusServiceUnavailable, codeShuttingDown, "This node is shutting down", 0)
+		return
+	}
+
 	// Fail early if raft is not functional right now:
 	if lastContact, partitioned := api.partitioned(); partitioned {
 		// Reject this GetMessages request so that clients can connect to a
 		// different server and receive new messages.
 		log.Printf("Rejecting GetMessages request due to LastContact (%v) too long ago\n", lastContact)
-		http.Error(w, fmt.Sprintf("raft: LastContact (%v) too long ago", lastContact), http.StatusInternalServerError)
+		api.writeError(w, http.StatusServiceUnavailable, codePartitioned, fmt.Sprintf("raft: LastContact (%v) too long ago", lastContact), 0)
 		return
 	}
 
@@ -201,6 +207,7 @@ func (api *HTTP) handleGetMessages(w http.ResponseWriter, r *http.Request, sessi
 	var lastFlush time.Time
 	willFlush := false
 	msgschan := make(chan []*robust.Message)
+	localchan := make(chan localReply, 10)
 
 	sessionId = strconv.FormatUint(session.Id, 10)
 	ctx, cancel := context.WithCancel(r.Context())
@@ -224,6 +231,7 @@ func (api *HTTP) handleGetMessages(w http.ResponseWriter, r *http.Request, sessi
 		}
 	}
 
+	delivered := lastSeen.Id
 	api.setGetMessagesRequests(sessionId, GetMessagesStats{
 		RemoteAddr:    r.RemoteAddr,
 		Session:       session,
@@ -234,6 +242,8 @@ func (api *HTTP) handleGetMessages(w http.ResponseWriter, r *http.Request, sessi
 		TrustedBridge: api.ircServer().TrustedBridge(r.Header.Get("X-Bridge-Auth")),
 		cancel:        cancelAll,
 		api:           api,
+		localReplies:  localchan,
+		lastSeen:      &delivered,
 	})
 
 	defer cancelAll(false)
@@ -241,21 +251,89 @@ func (api *HTTP) handleGetMessages(w http.ResponseWriter, r *http.Request, sessi
 	go api.pingTicker(ctx, msgschan)
 	go api.getMessages(ctx, lastSeen, msgschan)
 
+	// streamed is the id of the most recent output stream batch which was
+	// processed (i.e. sent to the client if interesting for it).
+	streamed := lastSeen.Id
+	// pendingLocal are locally answered replies which wait for the output
+	// stream to catch up, see localReply.after.
+	var pendingLocal []localReply
+	sendLocalReplies := func() bool {
+		sent := false
+		for len(pendingLocal) > 0 && pendingLocal[0].after <= streamed {
+			serverTime := api.ircServer().HasCap(session, "server-time")
+			for _, msg := range pendingLocal[0].msgs {
+				// Locally answered queries are not part of the output
+				// stream. Their ids sort after the output which was
+				// already sent, so that clients which resume using
+				// lastseen neither miss nor repeat output, and they are
+				// distinct, so that clients can tell them apart.
+				msg.Id = robust.Id{
+					Id:    streamed,
+					Reply: localReplyOffset + atomic.AddUint64(&api.localReplySeq, 1),
+				}
+				if serverTime {
+					msg.Data = ircserver.AddServerTime(msg.Data, time.Now())
+				}
+				if err := enc.Encode(msg); err != nil {
+					log.Printf("Error encoding JSON: %v\n", err)
+					return false
+				}
+			}
+			pendingLocal = pendingLocal[1:]
+			sent = true
+		}
+		if sent {
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			lastFlush = time.Now()
+			api.recordReadActivity(session)
+		}
+		return true
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
 
+		case lr := <-localchan:
+			pendingLocal = append(pendingLocal, lr)
+			if !sendLocalReplies() {
+				return
+			}
+
 		case msgs := <-msgschan:
+			// Checked once per batch to avoid locking for every message.
+			serverTime := api.ircServer().HasCap(session, "server-time")
+			messageTags := api.ircServer().HasCap(session, "message-tags")
 			for _, msg := range msgs {
+				if msg.Type == robust.IRCToClient && msg.Id.Id > streamed {
+					streamed = msg.Id.Id
+				}
 				if msg.Type != robust.Ping && !msg.InterestingFor[session.Id] {
 					continue
 				}
 
+				if !messageTags && msg.Type == robust.IRCToClient {
+					msg.Data = ircserver.StripTags(msg.Data)
+				}
+				if serverTime && msg.Type == robust.IRCToClient && msg.UnixNano != 0 {
+					msg.Data = ircserver.AddServerTime(msg.Data, msg.Timestamp())
+				}
+
 				if err := enc.Encode(msg); err != nil {
 					log.Printf("Error encoding JSON: %v\n", err)
 					return
 				}
+				if msg.Type == robust.IRCToClient {
+					lastSeen = msg.Id
+					atomic.StoreUint64(&delivered, lastSeen.Id)
+				}
+			}
+			api.recordReadActivity(session)
+			if !sendLocalReplies() {
+				return
 			}
 
 			if _, err := api.ircServer().GetSession(session); err != nil {
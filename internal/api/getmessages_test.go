@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/outputstream"
+	"github.com/robustirc/robustirc/internal/robust"
+)
+
+func TestResumeAfterLocalReply(t *testing.T) {
+	output, err := outputstream.NewOutputStream("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	output.Add([]outputstream.Message{
+		{Id: robust.Id{Id: 1, Reply: 1}, Data: "first"},
+		{Id: robust.Id{Id: 1, Reply: 2}, Data: "second"},
+	})
+	output.Add([]outputstream.Message{
+		{Id: robust.Id{Id: 2, Reply: 1}, Data: "third"},
+	})
+	api := &HTTP{outputUnlocked: output}
+
+	for _, lastseen := range []string{
+		// A reply which a (possibly different) node answered locally after
+		// streaming the first batch.
+		fmt.Sprintf("1.%d", localReplyOffset+42),
+		fmt.Sprintf("1.%d", uint64(math.MaxUint64)),
+		// Seen the entire first batch.
+		"1.2",
+	} {
+		t.Run(lastseen, func(t *testing.T) {
+			first, last, err := parseLastSeen(lastseen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			msgschan := make(chan []*robust.Message)
+			go api.getMessages(ctx, robust.Id{Id: first, Reply: last}, msgschan)
+			select {
+			case msgs := <-msgschan:
+				if len(msgs) != 1 || msgs[0].Data != "third" {
+					t.Fatalf("getMessages(%s): got %+v, want only the third message", lastseen, msgs)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Timeout waiting for getMessages(%s)", lastseen)
+			}
+		})
+	}
+}
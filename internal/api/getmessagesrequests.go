@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robustirc/internal/robusthttp"
+	"github.com/robustirc/robustirc/internal/ircserver"
+	"github.com/robustirc/robustirc/internal/robust"
+)
+
+// getMessagesRequest is the JSON representation of a GetMessagesStats, as
+// exchanged between the nodes of the network.
+type getMessagesRequest struct {
+	Node          string
+	Session       robust.Id
+	Nick          string
+	RemoteAddr    string
+	ForwardedFor  string
+	TrustedBridge string
+	UserAgent     string
+	Started       time.Time
+	QueueDepth    uint64
+}
+
+// Client returns the address of the client, i.e. X-Forwarded-For if the
+// request came in via a bridge, RemoteAddr otherwise.
+func (r getMessagesRequest) Client() string {
+	if r.ForwardedFor != "" {
+		return r.ForwardedFor
+	}
+	return r.RemoteAddr
+}
+
+// StartedAndRelative converts |r.Started| into a human-readable formatted
+// time, followed by a relative time specification.
+func (r getMessagesRequest) StartedAndRelative() string {
+	return GetMessagesStats{Started: r.Started}.StartedAndRelative()
+}
+
+// networkQueryTimeout is the maximum time for which we wait for other nodes
+// to answer inter-node queries.
+const networkQueryTimeout = 2 * time.Second
+
+func (api *HTTP) localGetMessagesRequests() []getMessagesRequest {
+	var result []getMessagesRequest
+	for _, stats := range api.copyGetMessagesRequests() {
+		result = append(result, getMessagesRequest{
+			Node:          api.peerAddr,
+			Session:       stats.Session,
+			Nick:          stats.NickWithFallback(),
+			RemoteAddr:    stats.RemoteAddr,
+			ForwardedFor:  stats.ForwardedFor,
+			TrustedBridge: stats.TrustedBridge,
+			UserAgent:     stats.UserAgent,
+			Started:       stats.Started,
+			QueueDepth:    stats.QueueDepth(),
+		})
+	}
+	return result
+}
+
+func (api *HTTP) handleGetMessagesRequests(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(api.localGetMessagesRequests()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (api *HTTP) peerGetMessagesRequests(ctx context.Context, peer string) ([]getMessagesRequest, error) {
+	url := fmt.Sprintf("https://%s/getmessagesrequests", peer)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := robusthttp.Client(api.networkPassword, true).Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: Expected OK, got %v", url, resp.Status)
+	}
+	var result []getMessagesRequest
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// networkGetMessagesRequests returns the GetMessages requests of all nodes in
+// the network, sorted by node and session. Nodes which could not be queried
+// are returned in the error map.
+func (api *HTTP) networkGetMessagesRequests(ctx context.Context) ([]getMessagesRequest, map[string]error) {
+	result := api.localGetMessagesRequests()
+	errs := make(map[string]error)
+
+	cfgf := api.raftNode.GetConfiguration()
+	if err := cfgf.Error(); err != nil {
+		errs[api.peerAddr] = err
+		return result, errs
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, networkQueryTimeout)
+	defer cancel()
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for _, server := range cfgf.Configuration().Servers {
+		peer := string(server.Address)
+		if peer == api.peerAddr {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			requests, err := api.peerGetMessagesRequests(ctx, peer)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[peer] = err
+				return
+			}
+			result = append(result, requests...)
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Node != result[j].Node {
+			return result[i].Node < result[j].Node
+		}
+		return result[i].Session.Id < result[j].Session.Id
+	})
+	return result, errs
+}
+
+// networkConnections returns the GetMessages requests of the network in the
+// form which ircserver.NodeInfo expects.
+func (api *HTTP) networkConnections(ctx context.Context) map[uint64][]ircserver.ConnectionInfo {
+	requests, _ := api.networkGetMessagesRequests(ctx)
+	connections := make(map[uint64][]ircserver.ConnectionInfo)
+	for _, req := range requests {
+		connections[req.Session.Id] = append(connections[req.Session.Id], ircserver.ConnectionInfo{
+			Node:       req.Node,
+			RemoteAddr: req.Client(),
+			QueueDepth: req.QueueDepth,
+		})
+	}
+	return connections
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/robustirc/robustirc/internal/ircserver"
+	"github.com/robustirc/robustirc/internal/robust"
+)
+
+// handleImport imports channels (e.g. when migrating a network from a
+// different IRC server to RobustIRC). Each channel is applied as a separate
+// raft entry. Importing is idempotent, so a failed import can be retried.
+func (api *HTTP) handleImport(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Channels []ircserver.ImportedChannel
+	}
+	var body bytes.Buffer
+	if err := json.NewDecoder(io.TeeReader(r.Body, &body)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Could not decode your request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Verify the entire document before applying anything, so that a typo
+	// does not result in a partial import.
+	for _, c := range req.Channels {
+		if err := c.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if api.raftNode.State() != raft.Leader {
+		api.maybeProxyToLeader(w, r, nopCloser{&body})
+		return
+	}
+
+	for idx, c := range req.Channels {
+		data, err := json.Marshal(&c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		msg := &robust.Message{
+			Type: robust.ChannelImport,
+			Data: string(data),
+		}
+		if err := api.applyMessageWait(msg, 10*time.Second); err != nil {
+			if err == raft.ErrNotLeader && idx == 0 {
+				api.maybeProxyToLeader(w, r, nopCloser{&body})
+				return
+			}
+			http.Error(w, fmt.Sprintf("Apply(%s): %v", c.Name, err), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "imported %s\n", c.Name)
+	}
+}
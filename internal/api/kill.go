@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/hashicorp/raft"
@@ -26,13 +25,13 @@ func (api *HTTP) handleKill(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for _, sessionid := range r.Form["session"] {
-		id, err := strconv.ParseUint(sessionid, 0, 64)
+		id, err := robust.ParseSessionId(sessionid)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		msg := &robust.Message{
-			Session: robust.Id{Id: id},
+			Session: id,
 			Type:    robust.DeleteSession,
 			Data:    "killed",
 		}
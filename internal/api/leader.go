@@ -1,7 +1,29 @@
 package api
 
-import "net/http"
+import (
+	"log"
+	"net/http"
+
+	"github.com/hashicorp/raft"
+)
 
 func (api *HTTP) handleLeader(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(api.raftNode.Leader()))
 }
+
+// handleTransferLeadership makes the current leader step down in favor of
+// the most up-to-date follower, e.g. before maintenance on the leader.
+func (api *HTTP) handleTransferLeadership(w http.ResponseWriter, r *http.Request) {
+	log.Println("Leadership transfer request from", r.RemoteAddr)
+	if api.raftNode.State() != raft.Leader {
+		api.maybeProxyToLeader(w, r, r.Body)
+		return
+	}
+
+	if err := api.raftNode.LeadershipTransfer().Error(); err != nil {
+		log.Println("Could not transfer leadership:", err)
+		http.Error(w, "Could not transfer leadership", http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(api.raftNode.Leader()))
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robustirc/robustirc/internal/ircserver"
+)
+
+var shedMessages = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: "api",
+		Name:      "shed_messages",
+		Help:      "Messages rejected due to load shedding, by session priority",
+	},
+	[]string{"priority"},
+)
+
+func init() {
+	prometheus.MustRegister(shedMessages)
+}
+
+// applyLatencyMaxAge is the age after which the apply latency average is no
+// longer considered. Otherwise, shedding all traffic would prevent the
+// average from ever recovering.
+const applyLatencyMaxAge = 10 * time.Second
+
+// recordApplyLatency updates the exponentially weighted moving average of
+// the raft Apply latency.
+func (api *HTTP) recordApplyLatency(d time.Duration) {
+	for {
+		old := atomic.LoadUint64(&api.applyLatency)
+		avg := old - old/8 + uint64(d)/8
+		if atomic.CompareAndSwapUint64(&api.applyLatency, old, avg) {
+			break
+		}
+	}
+	atomic.StoreInt64(&api.applyLatencyUpdated, time.Now().UnixNano())
+}
+
+// overload returns how far the node is beyond its load shedding thresholds,
+// i.e. a value < 1 means the node is not overloaded.
+func (api *HTTP) overload() float64 {
+	var load float64
+	if api.shedApplyLatency > 0 &&
+		time.Since(time.Unix(0, atomic.LoadInt64(&api.applyLatencyUpdated))) < applyLatencyMaxAge {
+		latency := time.Duration(atomic.LoadUint64(&api.applyLatency))
+		load = float64(latency) / float64(api.shedApplyLatency)
+	}
+	if api.shedQueueDepth > 0 {
+		applied, last := api.raftNode.AppliedIndex(), api.raftNode.LastIndex()
+		if last > applied {
+			if depth := float64(last-applied) / float64(api.shedQueueDepth); depth > load {
+				load = depth
+			}
+		}
+	}
+	return load
+}
+
+// shouldShed returns whether messages of sessions with priority |p| should
+// be rejected. Users are shed once a threshold is exceeded, bots once it is
+// exceeded twofold, operators once it is exceeded fourfold. Services are
+// never shed.
+func (api *HTTP) shouldShed(p ircserver.Priority) bool {
+	if p == ircserver.PriorityServices {
+		return false
+	}
+	load := api.overload()
+	switch p {
+	case ircserver.PriorityUser:
+		return load >= 1
+	case ircserver.PriorityBot:
+		return load >= 2
+	}
+	return load >= 4
+}
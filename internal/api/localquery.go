@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/robustirc/robustirc/internal/ircserver"
+	"github.com/robustirc/robustirc/internal/robust"
+)
+
+// localReplyOffset is added to the Reply field of the ids of locally answered
+// replies, so that they cannot be confused with output stream messages, whose
+// Reply field is their index within the output batch.
+const localReplyOffset = 1 << 32
+
+// localReply is the reply to a query which was answered locally.
+type localReply struct {
+	// after is the id of the most recent output stream batch at the time
+	// the query was answered. The reply must not be sent to the client
+	// before the output up to this batch, otherwise it could overtake
+	// e.g. the JOIN which a NAMES reply reflects.
+	after uint64
+
+	msgs []*robust.Message
+}
+
+// localRepliesFor returns the channel on which replies can be delivered to the
+// GetMessages request of |session| on this node, or nil.
+func (api *HTTP) localRepliesFor(session robust.Id) chan<- localReply {
+	api.getMessagesRequestsMu.RLock()
+	defer api.getMessagesRequestsMu.RUnlock()
+	stats, ok := api.getMessagesRequests[strconv.FormatUint(session.Id, 10)]
+	if !ok {
+		return nil
+	}
+	return stats.localReplies
+}
+
+// answerQueryLocally answers read-only IRC commands (e.g. WHO) from the state
+// which this node has applied so far, without appending them to the raft
+// log. It returns false if the message needs to go through raft, e.g. because
+// it modifies state, because the GetMessages request of |session| is served
+// by a different node (we could not deliver the reply) or because the local
+// state might be too stale.
+func (api *HTTP) answerQueryLocally(ctx context.Context, session robust.Id, data string) bool {
+	if api.localQueryStaleness <= 0 {
+		return false
+	}
+
+	ircmsg := ircserver.ParseMessage(data)
+	if ircmsg == nil || !ircserver.IsQueryCommand(ircmsg.Command) {
+		return false
+	}
+
+	if api.raftNode.State() != raft.Leader &&
+		time.Since(api.raftNode.LastContact()) > api.localQueryStaleness {
+		return false
+	}
+
+	// Messages which are still in flight (e.g. a JOIN which the client sent
+	// right before NAMES) must be applied first.
+	if api.raftNode.AppliedIndex() < api.raftNode.LastIndex() {
+		return false
+	}
+
+	localReplies := api.localRepliesFor(session)
+	if localReplies == nil {
+		return false
+	}
+
+	// TRACE reports which node serves which GetMessages request, MAP and
+	// LINKS report the raft peer set, STATS m and l report the messages
+	// posted to this node. Only the nodes themselves know about either.
+	info := &ircserver.NodeInfo{}
+	switch strings.ToUpper(ircmsg.Command) {
+	case "TRACE":
+		info.Connections = api.networkConnections(ctx)
+	case "MAP", "LINKS":
+		info.Peers = api.raftPeers()
+	case "STATS":
+		info.CommandCounts, info.MessagesReceived = api.copyReceived()
+	}
+
+	reply, err := api.ircServer().ProcessQueryWithNodeInfo(session, ircmsg, info)
+	if err != nil {
+		return false
+	}
+	// Determined after answering the query, so that the output of all
+	// messages which the reply reflects is covered.
+	after := api.output().LastSeen().Id
+
+	msgs := make([]*robust.Message, len(reply.Messages))
+	for idx, msg := range reply.Messages {
+		msgs[idx] = &robust.Message{
+			Type:           robust.IRCToClient,
+			Data:           msg.Data,
+			InterestingFor: msg.InterestingFor,
+		}
+	}
+
+	select {
+	case localReplies <- localReply{after: after, msgs: msgs}:
+		return true
+	default:
+		// The GetMessages request is gone or congested, fall back to raft.
+		return false
+	}
+}
+
+// raftPeers returns the raft peer set in the form which ircserver.NodeInfo
+// expects. The state of other nodes is derived from the raft configuration,
+// as only the node itself knows its exact state.
+func (api *HTTP) raftPeers() []ircserver.PeerInfo {
+	cfgf := api.raftNode.GetConfiguration()
+	if err := cfgf.Error(); err != nil {
+		return nil
+	}
+	leader := string(api.raftNode.Leader())
+	var peers []ircserver.PeerInfo
+	for _, server := range cfgf.Configuration().Servers {
+		peer := ircserver.PeerInfo{
+			Addr: string(server.Address),
+			Self: string(server.Address) == api.peerAddr,
+		}
+		switch {
+		case peer.Self:
+			peer.State = strings.ToLower(api.raftNode.State().String())
+		case peer.Addr == leader:
+			peer.State = "leader"
+		case server.Suffrage != raft.Voter:
+			peer.State = "nonvoter"
+		default:
+			peer.State = "follower"
+		}
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Addr < peers[j].Addr
+	})
+	return peers
+}
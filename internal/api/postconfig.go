@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"strconv"
 	"time"
@@ -11,7 +12,10 @@ import (
 	"github.com/BurntSushi/toml"
 	"github.com/hashicorp/raft"
 	"github.com/robustirc/robustirc/internal/config"
+	"github.com/robustirc/robustirc/internal/ircserver"
+	"github.com/robustirc/robustirc/internal/privacy"
 	"github.com/robustirc/robustirc/internal/robust"
+	"github.com/robustirc/robustirc/plugin"
 )
 
 func (api *HTTP) configRevision() uint64 {
@@ -34,6 +38,42 @@ func (api *HTTP) applyConfig(revision uint64, body string) error {
 	return api.applyMessageWait(msg, 10*time.Second)
 }
 
+// validateConfig returns an error if |cfg| must not be applied.
+func validateConfig(cfg *config.Network) error {
+	if _, ok := privacy.ParsePolicy(cfg.PrivacyFilter); !ok {
+		return fmt.Errorf("Invalid PrivacyFilter %q (want one of %q, %q, %q)",
+			cfg.PrivacyFilter, privacy.RedactAll, privacy.RedactPrivate, privacy.RedactNone)
+	}
+	if cfg.STSDuration < 0 {
+		return fmt.Errorf("Invalid STSDuration %v (must not be negative)", cfg.STSDuration)
+	}
+	if err := plugin.ValidateTransforms(cfg.ChannelTransforms); err != nil {
+		return err
+	}
+	if err := ircserver.ValidateNumericTexts(cfg.NumericTexts); err != nil {
+		return err
+	}
+	return plugin.ValidateConfig(cfg.Plugins)
+}
+
+// ApplyConfigFile applies the network configuration stored in |path| as the
+// next configuration revision. It must be called on the raft leader and is
+// used to fulfill REHASH requests.
+func (api *HTTP) ApplyConfigFile(path string) error {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfg config.Network
+	if _, err := toml.Decode(string(body), &cfg); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if err := validateConfig(&cfg); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return api.applyConfig(api.configRevision(), string(body))
+}
+
 func (api *HTTP) handlePostConfig(w http.ResponseWriter, r *http.Request) {
 	revision, err := strconv.ParseUint(r.Header.Get("X-RobustIRC-Config-Revision"), 0, 64)
 	if err != nil {
@@ -41,9 +81,14 @@ func (api *HTTP) handlePostConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var unused config.Network
+	var cfg config.Network
 	var body bytes.Buffer
-	if _, err := toml.DecodeReader(io.TeeReader(r.Body, &body), &unused); err != nil {
+	if _, err := toml.DecodeReader(io.TeeReader(r.Body, &body), &cfg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateConfig(&cfg); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
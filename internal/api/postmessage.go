@@ -2,9 +2,11 @@ package api
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"strings"
@@ -14,6 +16,44 @@ import (
 	"github.com/robustirc/robustirc/internal/robust"
 )
 
+// remoteAddr returns the address of the client which sent |r|, i.e. the first
+// X-Forwarded-For address for trusted bridges, without the port.
+func (api *HTTP) remoteAddr(r *http.Request) string {
+	remoteAddr := r.RemoteAddr
+	if api.ircServer().TrustedBridge(r.Header.Get("X-Bridge-Auth")) != "" {
+		remoteAddr = r.Header.Get("X-Forwarded-For")
+		if idx := strings.Index(remoteAddr, ","); idx > -1 {
+			remoteAddr = remoteAddr[:idx]
+		}
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		remoteAddr = host
+	}
+	return remoteAddr
+}
+
+// clientCertHeader carries the fingerprint of the TLS client certificate of
+// the client when a request is sent by a trusted bridge or proxied to the
+// leader, see maybeProxyToLeader.
+const clientCertHeader = "X-Client-Cert-Fingerprint"
+
+// certFingerprint returns the SHA-256 fingerprint of the TLS client
+// certificate which the client that sent |r| presented, if any. Trusted
+// bridges and other nodes (which vouch for it, see prepareProxyRequest) pass
+// it on in the X-Client-Cert-Fingerprint header.
+func (api *HTTP) certFingerprint(r *http.Request) string {
+	if api.ircServer().TrustedBridge(r.Header.Get("X-Bridge-Auth")) != "" {
+		return strings.ToLower(r.Header.Get(clientCertHeader))
+	}
+	if api.vouched(r) {
+		return strings.ToLower(r.Header.Get(clientCertHeader))
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return fmt.Sprintf("%x", sha256.Sum256(r.TLS.PeerCertificates[0].Raw))
+	}
+	return ""
+}
+
 // handlePostMessage is called by the robustirc-bridge whenever a message should be
 // posted. The handler blocks until either the data was written or an error
 // occurred. If successful, it returns the unique id of the message.
@@ -27,23 +67,36 @@ func (api *HTTP) handlePostMessage(w http.ResponseWriter, r *http.Request, sessi
 		ClientMessageId uint64
 	}
 
-	// We limit the amount of bytes read to 2048 to prevent reading overly long
-	// requests in the first place. The IRC line length limit is 512 bytes, so
-	// with 2048 bytes we have plenty of headroom to encode 512 bytes in JSON:
+	// We limit the amount of bytes read to 16384 to prevent reading overly
+	// long requests in the first place. The IRC line length limit is 512
+	// bytes, plus 4096 bytes for client message tags⁴, so with 16384 bytes we
+	// have plenty of headroom to encode 4608 bytes in JSON:
 	// The highest unicode code point is 0x10FFFF¹, which is expressed with
 	// 4 bytes when using UTF-8², but gets blown up to 12 bytes when escaped in
-	// JSON³. Hence, the JSON representation of a 512 byte IRC message has an
-	// upper bound of 3x512 = 1536 bytes. We use 2048 bytes to have enough
+	// JSON³. Hence, the JSON representation of a 4608 byte IRC message has an
+	// upper bound of 3x4608 = 13824 bytes. We use 16384 bytes to have enough
 	// space for encoding the struct field names etc.
 	// ① http://unicode.org/glossary/#code_point
 	// ② https://tools.ietf.org/html/rfc3629#section-3
 	// ③ https://tools.ietf.org/html/rfc7159#section-7
+	// ④ https://ircv3.net/specs/extensions/message-tags#size-limit
 	//
 	// We save a copy of the request in case we need to proxy it to the leader.
 	var body bytes.Buffer
-	rd := io.TeeReader(http.MaxBytesReader(w, r.Body, 2048), &body)
+	rd := io.TeeReader(http.MaxBytesReader(w, r.Body, 16384), &body)
 	if err := json.NewDecoder(rd).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		api.writeError(w, http.StatusBadRequest, codeBadRequest, err.Error(), 0)
+		return
+	}
+	// The vouch of a proxying node covers the entire body.
+	if _, err := io.Copy(ioutil.Discard, rd); err != nil {
+		api.writeError(w, http.StatusBadRequest, codeBadRequest, err.Error(), 0)
+		return
+	}
+	r = api.verifyVouch(r, body.Bytes())
+
+	if err := api.authorizeServicesLink(r, session, req.Data); err != nil {
+		api.writeError(w, http.StatusForbidden, codeForbidden, err.Error(), 0)
 		return
 	}
 
@@ -52,20 +105,21 @@ func (api *HTTP) handlePostMessage(w http.ResponseWriter, r *http.Request, sessi
 		return
 	}
 
+	api.recordReceived(r, session, req.Data)
+
+	if api.answerQueryLocally(r.Context(), session, req.Data) {
+		return
+	}
+
 	if api.raftNode.State() != raft.Leader {
 		api.maybeProxyToLeader(w, r, nopCloser{&body})
 		return
 	}
 
-	remoteAddr := r.RemoteAddr
-	if api.ircServer().TrustedBridge(r.Header.Get("X-Bridge-Auth")) != "" {
-		remoteAddr = r.Header.Get("X-Forwarded-For")
-		if idx := strings.Index(remoteAddr, ","); idx > -1 {
-			remoteAddr = remoteAddr[:idx]
-		}
-	}
-	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
-		remoteAddr = host
+	if priority := api.ircServer().SessionPriority(session); api.shouldShed(priority) {
+		shedMessages.WithLabelValues(priority.String()).Inc()
+		api.writeError(w, http.StatusServiceUnavailable, codeOverloaded, "Server overloaded, please retry", 1)
+		return
 	}
 
 	// IRC messages are separated by the newline character, so ensure the
@@ -79,14 +133,15 @@ func (api *HTTP) handlePostMessage(w http.ResponseWriter, r *http.Request, sessi
 		Type:            robust.IRCFromClient,
 		Data:            data,
 		ClientMessageId: req.ClientMessageId,
-		RemoteAddr:      remoteAddr,
+		RemoteAddr:      api.remoteAddr(r),
+		CertFingerprint: api.certFingerprint(r),
 	}
 	if err := api.applyMessageWait(msg, 10*time.Second); err != nil {
 		if err == raft.ErrNotLeader {
 			api.maybeProxyToLeader(w, r, nopCloser{&body})
 			return
 		}
-		http.Error(w, fmt.Sprintf("Apply(): %v", err), http.StatusInternalServerError)
+		api.writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Apply(): %v", err), 0)
 		return
 	}
 }
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/ircserver"
+	"github.com/robustirc/robustirc/internal/robust"
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// servicesLinkHeader carries the certificate fingerprint of a services link
+// when a request is proxied to the leader, see maybeProxyToLeader. It is only
+// respected on requests which carry a valid proxyVouchHeader.
+const servicesLinkHeader = "X-Services-Link"
+
+// proxyVouchHeader carries a timestamp and an HMAC (keyed with the network
+// password) over the request line, the timestamp, the request body and the
+// servicesLinkHeader and clientCertHeader values which the proxying node
+// vouches for, see vouch.
+const proxyVouchHeader = "X-Robustirc-Vouch"
+
+// maxVouchAge is the maximum age (and clock skew) of a proxyVouchHeader. Older
+// vouches are rejected, so that captured requests cannot be replayed later.
+const maxVouchAge = 1 * time.Minute
+
+var errServicesListenerRequired = errors.New("services must link via the services listener")
+
+type servicesLinkKey struct{}
+
+type vouchedKey struct{}
+
+// ServicesHandler returns the handler for the dedicated services listener,
+// which only serves the session API. Once called, services can no longer link
+// via the public listener. Must be called before serving requests.
+func (api *HTTP) ServicesHandler() http.Handler {
+	api.servicesListener = true
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robustirc/v1/", func(w http.ResponseWriter, r *http.Request) {
+		var fingerprint string
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			fingerprint = fmt.Sprintf("%x", sha256.Sum256(r.TLS.PeerCertificates[0].Raw))
+		}
+		ctx := context.WithValue(r.Context(), servicesLinkKey{}, fingerprint)
+		api.dispatchPublic(w, r.WithContext(ctx))
+	})
+	return mux
+}
+
+// servicesLink returns whether |r| arrived on the services listener (of this
+// node or, if proxied, of a different node) and the fingerprint of the TLS
+// client certificate which was presented, if any.
+func (api *HTTP) servicesLink(r *http.Request) (fingerprint string, ok bool) {
+	if fingerprint, ok := r.Context().Value(servicesLinkKey{}).(string); ok {
+		return fingerprint, true
+	}
+	if _, ok := r.Header[servicesLinkHeader]; !ok {
+		return "", false
+	}
+	if !api.vouched(r) {
+		return "", false
+	}
+	return r.Header.Get(servicesLinkHeader), true
+}
+
+// vouch returns the proxyVouchHeader value for |r| with |body|, created at
+// |now|.
+func (api *HTTP) vouch(r *http.Request, body []byte, now time.Time) string {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	return timestamp + " " + hex.EncodeToString(api.vouchMAC(r, body, timestamp))
+}
+
+func (api *HTTP) vouchMAC(r *http.Request, body []byte, timestamp string) []byte {
+	mac := hmac.New(sha256.New, []byte(api.networkPassword))
+	fmt.Fprintf(mac, "%s %s\n%s\n%x\n%q\n%q", r.Method, r.URL.Path, timestamp, sha256.Sum256(body), r.Header[clientCertHeader], r.Header[servicesLinkHeader])
+	return mac.Sum(nil)
+}
+
+// verifyVouch returns |r| marked as vouched for (see vouched) if a node of the
+// network (i.e. one which knows the network password) vouched for the
+// clientCertHeader and servicesLinkHeader of |r| with body |body| within the
+// last maxVouchAge. Otherwise, |r| is returned unchanged.
+func (api *HTTP) verifyVouch(r *http.Request, body []byte) *http.Request {
+	parts := strings.SplitN(r.Header.Get(proxyVouchHeader), " ", 2)
+	if len(parts) != 2 {
+		return r
+	}
+	unix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return r
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > maxVouchAge || age < -maxVouchAge {
+		return r
+	}
+	got, err := hex.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(got, api.vouchMAC(r, body, parts[0])) {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), vouchedKey{}, true))
+}
+
+// vouched returns whether verifyVouch accepted the proxyVouchHeader of |r|.
+func (api *HTTP) vouched(r *http.Request) bool {
+	vouched, _ := r.Context().Value(vouchedKey{}).(bool)
+	return vouched
+}
+
+// prepareProxyRequest replaces the headers of |r| which the leader trusts
+// from other nodes with the values this node determined itself, so that
+// clients cannot forge them. It returns the request body to proxy, which
+// needs to be read to vouch for it.
+func (api *HTTP) prepareProxyRequest(r *http.Request, body io.ReadCloser) (io.ReadCloser, error) {
+	fingerprint := api.certFingerprint(r)
+	servicesFingerprint, link := api.servicesLink(r)
+
+	r.Header.Del(clientCertHeader)
+	r.Header.Del(servicesLinkHeader)
+	r.Header.Del(proxyVouchHeader)
+
+	if fingerprint == "" && !link {
+		return body, nil
+	}
+	// The leader cannot see the TLS connection of the client, so we vouch
+	// for its certificate (used by SASL EXTERNAL) and for it having arrived
+	// on the services listener.
+	if fingerprint != "" {
+		r.Header.Set(clientCertHeader, fingerprint)
+	}
+	if link {
+		r.Header.Set(servicesLinkHeader, servicesFingerprint)
+	}
+	b, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set(proxyVouchHeader, api.vouch(r, b, time.Now()))
+	return nopCloser{bytes.NewReader(b)}, nil
+}
+
+// authorizeServicesLink returns an error if |data|, sent by |session|, must
+// be rejected because services use the public listener while the services
+// listener is enabled, or because they did not present the certificate which
+// is pinned in their configuration.
+func (api *HTTP) authorizeServicesLink(r *http.Request, session robust.Id, data string) error {
+	fingerprint, link := api.servicesLink(r)
+	public := !link && api.servicesListener
+
+	if public && api.ircServer().SessionPriority(session) == ircserver.PriorityServices {
+		return errServicesListenerRequired
+	}
+
+	// Like handlePostMessage, only consider the first line.
+	if idx := strings.IndexByte(data, '\n'); idx > -1 {
+		data = data[:idx]
+	}
+	ircmsg := ircserver.ParseMessage(data)
+	if ircmsg == nil {
+		return nil
+	}
+	switch strings.ToUpper(ircmsg.Command) {
+	case irc.SERVER:
+		if public {
+			return errServicesListenerRequired
+		}
+
+	case irc.PASS:
+		pass := strings.Join(ircmsg.Params, " ")
+		if !strings.HasPrefix(pass, "services=") {
+			return nil
+		}
+		if public {
+			return errServicesListenerRequired
+		}
+		pinned, ok := api.ircServer().ServiceCertFingerprint(strings.TrimPrefix(pass, "services="))
+		if ok && pinned != "" && !strings.EqualFold(pinned, fingerprint) {
+			return fmt.Errorf("services TLS client certificate does not match the pinned fingerprint")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/ircserver"
+)
+
+func newTestHTTP(networkPassword string) *HTTP {
+	return &HTTP{
+		ircServerUnlocked: ircserver.NewIRCServer("robustirc.net", time.Now()),
+		networkPassword:   networkPassword,
+	}
+}
+
+// proxied returns the request (and its body) which the leader receives when
+// |r| is proxied by |follower|, see maybeProxyToLeader.
+func proxied(t *testing.T, follower *HTTP, r *http.Request) (*http.Request, []byte) {
+	body, err := follower.prepareProxyRequest(r, r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaderReq := httptest.NewRequest(r.Method, r.URL.Path, nil)
+	leaderReq.Header = r.Header.Clone()
+	return leaderReq, b
+}
+
+func TestServicesLinkForgedThroughFollower(t *testing.T) {
+	follower := newTestHTTP("secret")
+	leader := newTestHTTP("secret")
+
+	r := httptest.NewRequest("POST", "/robustirc/v1/1/message", nil)
+	r.Header.Set(servicesLinkHeader, "")
+	r.Header.Set(clientCertHeader, "f00")
+	r.Header.Set(proxyVouchHeader, "0123456789abcdef")
+	r.SetBasicAuth("robustirc", "secret")
+
+	leaderReq, body := proxied(t, follower, r)
+	leaderReq = leader.verifyVouch(leaderReq, body)
+	if _, ok := leader.servicesLink(leaderReq); ok {
+		t.Fatalf("leader accepted forged %s header", servicesLinkHeader)
+	}
+	if got := leader.certFingerprint(leaderReq); got != "" {
+		t.Fatalf("leader accepted forged %s header: got fingerprint %q", clientCertHeader, got)
+	}
+}
+
+func TestServicesLinkVouchedByFollower(t *testing.T) {
+	follower := newTestHTTP("secret")
+	leader := newTestHTTP("secret")
+
+	const data = `{"Data": "PASS services=mypass", "ClientMessageId": 1}`
+	r := httptest.NewRequest("POST", "/robustirc/v1/1/message", strings.NewReader(data))
+	r = r.WithContext(context.WithValue(r.Context(), servicesLinkKey{}, "f00"))
+
+	leaderReq, body := proxied(t, follower, r)
+	if got := string(body); got != data {
+		t.Fatalf("proxied body: got %q, want %q", got, data)
+	}
+	fingerprint, ok := leader.servicesLink(leader.verifyVouch(leaderReq, body))
+	if !ok || fingerprint != "f00" {
+		t.Fatalf("servicesLink() = %q, %v, want %q, true", fingerprint, ok, "f00")
+	}
+
+	// A different network password must not be able to vouch.
+	other := newTestHTTP("other")
+	if _, ok := other.servicesLink(other.verifyVouch(leaderReq, body)); ok {
+		t.Fatalf("servicesLink() accepted a request vouched for with a different network password")
+	}
+
+	// The vouch covers the request body, so that a captured request cannot
+	// be replayed with a different message.
+	replayed := []byte(`{"Data": "PASS services=otherpass", "ClientMessageId": 2}`)
+	if _, ok := leader.servicesLink(leader.verifyVouch(leaderReq, replayed)); ok {
+		t.Fatalf("servicesLink() accepted a vouch for a different request body")
+	}
+
+	// Stale vouches are rejected.
+	stale := leaderReq.Clone(leaderReq.Context())
+	stale.Header.Set(proxyVouchHeader, follower.vouch(stale, body, time.Now().Add(-2*maxVouchAge)))
+	if _, ok := leader.servicesLink(leader.verifyVouch(stale, body)); ok {
+		t.Fatalf("servicesLink() accepted a stale vouch")
+	}
+
+	// The vouch covers the request path (and hence the session).
+	leaderReq.URL.Path = "/robustirc/v1/2/message"
+	if _, ok := leader.servicesLink(leader.verifyVouch(leaderReq, body)); ok {
+		t.Fatalf("servicesLink() accepted a vouch for a different request path")
+	}
+}
@@ -3,6 +3,11 @@ package api
 import (
 	"log"
 	"net/http"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/robustirc/robustirc/internal/robust"
+	"github.com/robustirc/robustirc/internal/snapshotmeta"
 )
 
 func (api *HTTP) handleSnapshot(res http.ResponseWriter, req *http.Request) {
@@ -10,3 +15,56 @@ func (api *HTTP) handleSnapshot(res http.ResponseWriter, req *http.Request) {
 	api.raftNode.Snapshot()
 	log.Println("done taking snapshot")
 }
+
+// OldestIrclogEntry returns the index and timestamp of the first irclog
+// entry. |index| is 0 if the irclog is empty.
+func (api *HTTP) OldestIrclogEntry() (index uint64, ts time.Time, err error) {
+	store := api.ircStore()
+	first, err := store.FirstIndex()
+	if err != nil || first == 0 {
+		return 0, time.Time{}, err
+	}
+	var elog raft.Log
+	if err := store.GetLog(first, &elog); err != nil {
+		return 0, time.Time{}, err
+	}
+	msg := robust.NewMessageFromBytes(elog.Data, robust.IdFromRaftIndex(elog.Index))
+	return first, msg.Timestamp(), nil
+}
+
+// snapshotInfo describes a snapshot on disk. Summary is nil for snapshots
+// which were taken before summaries were introduced.
+type snapshotInfo struct {
+	Meta    *raft.SnapshotMeta
+	Summary *snapshotmeta.Summary
+}
+
+// snapshotInfos returns all snapshots on disk, most recent first.
+func (api *HTTP) snapshotInfos() ([]snapshotInfo, error) {
+	metas, err := api.snapshots.List()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]snapshotInfo, len(metas))
+	for idx, meta := range metas {
+		summary, err := api.snapshotSummary(meta.ID)
+		if err != nil {
+			log.Printf("Could not read summary of snapshot %q: %v", meta.ID, err)
+		}
+		infos[idx] = snapshotInfo{
+			Meta:    meta,
+			Summary: summary,
+		}
+	}
+	return infos, nil
+}
+
+// snapshotSummary returns the summary stored in the snapshot with id |id|.
+func (api *HTTP) snapshotSummary(id string) (*snapshotmeta.Summary, error) {
+	_, rc, err := api.snapshots.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return snapshotmeta.Read(rc)
+}
@@ -13,13 +13,22 @@ import (
 	"github.com/robustirc/robustirc/internal/config"
 	"github.com/robustirc/robustirc/internal/ircserver"
 	"github.com/robustirc/robustirc/internal/privacy"
-	"github.com/robustirc/robustirc/internal/raftlog"
 	"github.com/robustirc/robustirc/internal/robust"
 
 	pb "github.com/robustirc/robustirc/internal/proto"
 )
 
-//go:generate go run gentmpl.go -package=api templates/header templates/footer templates/status templates/getmessage templates/sessions templates/state templates/statusirclog templates/irclog
+//go:generate go run gentmpl.go -package=api templates/header templates/footer templates/status templates/getmessage templates/networkgetmessage templates/sessions templates/state templates/statusirclog templates/irclog templates/archive templates/archiveindex
+
+// privacyPolicy returns the privacy filter policy of the network
+// configuration. Invalid values result in the most restrictive policy.
+func (api *HTTP) privacyPolicy() privacy.Policy {
+	i := api.ircServer()
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	policy, _ := privacy.ParsePolicy(i.Config.PrivacyFilter)
+	return policy
+}
 
 func (api *HTTP) handleStatusGetMessage(w http.ResponseWriter, req *http.Request) {
 	if err := templates.ExecuteTemplate(w, "templates/getmessage", struct {
@@ -38,15 +47,53 @@ func (api *HTTP) handleStatusGetMessage(w http.ResponseWriter, req *http.Request
 	}
 }
 
+func (api *HTTP) handleStatusNetworkGetMessage(w http.ResponseWriter, req *http.Request) {
+	requests, errs := api.networkGetMessagesRequests(req.Context())
+	perNode := make(map[string]int)
+	for _, r := range requests {
+		perNode[r.Node]++
+	}
+	if err := templates.ExecuteTemplate(w, "templates/networkgetmessage", struct {
+		Addr               string
+		GetMessageRequests map[string]GetMessagesStats
+		NetworkRequests    []getMessagesRequest
+		PerNode            map[string]int
+		Errors             map[string]error
+		CurrentLink        string
+		Sessions           map[robust.Id]ircserver.Session
+	}{
+		Addr:               api.peerAddr,
+		GetMessageRequests: api.copyGetMessagesRequests(),
+		NetworkRequests:    requests,
+		PerNode:            perNode,
+		Errors:             errs,
+		CurrentLink:        "/status/getmessage",
+		Sessions:           api.ircServer().GetSessions(),
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 func (api *HTTP) handleStatusSessions(w http.ResponseWriter, req *http.Request) {
+	sessions := api.ircServer().GetSessions()
+	lastRead := api.copyReadActivity()
+	idleTimes := make(map[uint64]time.Duration, len(sessions))
+	for id, s := range sessions {
+		idleTimes[id.Id] = idle(s, lastRead[id.Id])
+	}
 	if err := templates.ExecuteTemplate(w, "templates/sessions", struct {
 		Addr               string
 		Sessions           map[robust.Id]ircserver.Session
+		LastRead           map[uint64]time.Time
+		Idle               map[uint64]time.Duration
 		CurrentLink        string
 		GetMessageRequests map[string]GetMessagesStats
 	}{
 		Addr:               api.peerAddr,
-		Sessions:           api.ircServer().GetSessions(),
+		Sessions:           sessions,
+		LastRead:           lastRead,
+		Idle:               idleTimes,
 		CurrentLink:        "/status/sessions",
 		GetMessageRequests: api.copyGetMessagesRequests(),
 	}); err != nil {
@@ -65,7 +112,7 @@ func (api *HTTP) handleStatusState(w http.ResponseWriter, req *http.Request) {
 		if err := proto.Unmarshal(state, &snapshot); err != nil {
 			textState = fmt.Sprintf("unmarshaling state failed: %v", err)
 		} else {
-			snapshot = privacy.FilterSnapshot(snapshot)
+			snapshot = api.privacyPolicy().FilterSnapshot(snapshot)
 			var marshaler proto.TextMarshaler
 			textState = marshaler.Text(&snapshot)
 		}
@@ -121,22 +168,22 @@ func (api *HTTP) handleStatusIrclog(w http.ResponseWriter, req *http.Request) {
 		hi = lo + 50
 	}
 
+	policy := api.privacyPolicy()
 	var entries []*raft.Log
 	if lo != 0 && hi != 0 {
-		for i := lo; i <= hi; i++ {
-			l := new(raft.Log)
-
-			if err := api.ircStore().GetLog(i, l); err != nil {
-				// Not every message goes into the ircStore (e.g. raft peer change
-				// messages do not).
-				continue
-			}
+		// Not every message goes into the ircStore (e.g. raft peer change
+		// messages do not), so some indexes are skipped.
+		if err := api.ircStore().Iterate(lo, hi, func(l *raft.Log) error {
 			if l.Type == raft.LogCommand {
 				msg := robust.NewMessageFromBytes(l.Data, robust.IdFromRaftIndex(l.Index))
-				msg.Data = msg.PrivacyFilter()
+				msg.Data = policy.FilterMsg(&msg).Data
 				l.Data, _ = json.Marshal(&msg)
 			}
 			entries = append(entries, l)
+			return nil
+		}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 	}
 
@@ -183,6 +230,12 @@ func (api *HTTP) handleStatus(res http.ResponseWriter, req *http.Request) {
 		p[idx] = string(server.Address)
 	}
 
+	snapshots, err := api.snapshotInfos()
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// robustirc-rollingrestart wants a machine-readable version of the status.
 	if req.Header.Get("Accept") == "application/json" {
 		type jsonStatus struct {
@@ -194,6 +247,7 @@ func (api *HTTP) handleStatus(res http.ResponseWriter, req *http.Request) {
 			LastContact    time.Time
 			ExecutableHash string
 			CurrentTime    time.Time
+			Snapshots      []snapshotInfo
 		}
 		res.Header().Set("Content-Type", "application/json")
 		leaderStr := string(api.raftNode.Leader())
@@ -217,6 +271,7 @@ func (api *HTTP) handleStatus(res http.ResponseWriter, req *http.Request) {
 			LastContact:    api.raftNode.LastContact(),
 			ExecutableHash: executablehash,
 			CurrentTime:    time.Now(),
+			Snapshots:      snapshots,
 		}); err != nil {
 			log.Printf("%v\n", err)
 			http.Error(res, err.Error(), http.StatusInternalServerError)
@@ -235,6 +290,7 @@ func (api *HTTP) handleStatus(res http.ResponseWriter, req *http.Request) {
 		Sessions           map[robust.Id]ircserver.Session
 		GetMessageRequests map[string]GetMessagesStats
 		NetConfig          config.Network
+		Snapshots          []snapshotInfo
 		CurrentLink        string
 	}{
 		Addr:               api.peerAddr,
@@ -245,6 +301,7 @@ func (api *HTTP) handleStatus(res http.ResponseWriter, req *http.Request) {
 		Sessions:           api.ircServer().GetSessions(),
 		GetMessageRequests: api.copyGetMessagesRequests(),
 		NetConfig:          api.ircServer().Config,
+		Snapshots:          snapshots,
 		CurrentLink:        "/status",
 	}
 
@@ -255,24 +312,31 @@ func (api *HTTP) handleStatus(res http.ResponseWriter, req *http.Request) {
 }
 
 func (api *HTTP) handleIrclog(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.ParseUint(r.FormValue("sessionid"), 0, 64)
-	if err != nil || id == 0 {
+	session, err := robust.ParseSessionId(r.FormValue("sessionid"))
+	if err != nil || session.Id == 0 {
 		http.Error(w, "Invalid session", http.StatusBadRequest)
 		return
 	}
 
-	session := robust.Id{Id: id}
-
 	// TODO(secure): pagination
 
+	// Only look at the log entries which are relevant for this session instead
+	// of scanning the entire ircstore.
+	indexes, err := api.ircStore().SessionIndexes(session.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	policy := api.privacyPolicy()
 	var messages []*robust.Message
-	first, _ := api.ircStore().FirstIndex()
-	last, _ := api.ircStore().LastIndex()
-	iterator := api.ircStore().GetBulkIterator(first, last+1)
-	defer iterator.Release()
-	for iterator.Next() {
-		elog, err := raftlog.FromBytes(iterator.Value())
-		if err != nil {
+	for _, index := range indexes {
+		var elog raft.Log
+		if err := api.ircStore().GetLog(index, &elog); err != nil {
+			if err == raft.ErrLogNotFound {
+				// Compacted in the meantime.
+				continue
+			}
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -281,7 +345,7 @@ func (api *HTTP) handleIrclog(w http.ResponseWriter, r *http.Request) {
 		}
 		msg := robust.NewMessageFromBytes(elog.Data, robust.IdFromRaftIndex(elog.Index))
 		if msg.Session.Id == session.Id {
-			messages = append(messages, &msg)
+			messages = append(messages, policy.FilterMsg(&msg))
 		}
 		output, ok := api.output().Get(msg.Id)
 		if ok {
@@ -289,16 +353,11 @@ func (api *HTTP) handleIrclog(w http.ResponseWriter, r *http.Request) {
 				if !msg.InterestingFor[session.Id] {
 					continue
 				}
-				messages = append(messages, msg)
+				messages = append(messages, policy.FilterMsg(msg))
 			}
 		}
 	}
 
-	if err := iterator.Error(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
 	args := struct {
 		Session  robust.Id
 		Messages []*robust.Message
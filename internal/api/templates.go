@@ -1,6 +1,6 @@
 package api
 
-// Generated by "go run gentmpl.go templates/header templates/footer templates/status templates/getmessage templates/sessions templates/state templates/statusirclog templates/irclog".
+// Generated by "go run gentmpl.go templates/header templates/footer templates/status templates/getmessage templates/networkgetmessage templates/sessions templates/state templates/statusirclog templates/irclog templates/archive templates/archiveindex".
 // Do not edit manually.
 
 import (
@@ -159,6 +159,12 @@ $(document).ready(function() {
 							<td><span title="Reason: {{ $reason }}">{{ $addr }}</span></td>
 						</tr>
 						{{ end }}
+						{{ range $pattern, $reason := .NetConfig.Qlines }}
+						<tr>
+							<th>Reserved nick (QLINE)</th>
+							<td><span title="Reason: {{ $reason }}">{{ $pattern }}</span></td>
+						</tr>
+						{{ end }}
 
 					</table>
 				</div>
@@ -175,11 +181,48 @@ $(document).ready(function() {
 					</table>
 				</div>
 			</div>
+
+			<div class="row">
+				<div class="col-sm-12">
+					<h2>Snapshots</h2>
+					<table class="table table-condensed table-striped">
+						<thead>
+							<tr>
+								<th>ID</th>
+								<th>Index</th>
+								<th>Size</th>
+								<th>Sessions</th>
+								<th>Channels</th>
+								<th>Retained entries</th>
+								<th>Compaction duration</th>
+							</tr>
+						</thead>
+						<tbody>
+						{{ range .Snapshots }}
+							<tr>
+								<td>{{ .Meta.ID }}</td>
+								<td>{{ .Meta.Index }}</td>
+								<td>{{ .Meta.Size }}</td>
+								{{ with .Summary }}
+								<td>{{ .Sessions }}</td>
+								<td>{{ .Channels }}</td>
+								<td>{{ range $cmd, $num := .Retained }}{{ $cmd }}: {{ $num }}<br>{{ end }}</td>
+								<td>{{ .CompactionDuration }}</td>
+								{{ else }}
+								<td colspan="4">no summary</td>
+								{{ end }}
+							</tr>
+						{{ end }}
+						</tbody>
+					</table>
+				</div>
+			</div>
 {{ template "templates/footer" . }}
 `))
 	template.Must(templates.New("templates/getmessage").Parse(`{{ template "templates/header" . }}
 			<div class="row">
 				<h2>Active GetMessage requests <span class="badge" style="vertical-align: middle">{{ .GetMessageRequests | len }}</span></h2>
+				<p><a href="/status/getmessage/network">Show all nodes</a></p>
 				<form action="/kill" method="post">
 				<input type="submit" value="Kill selected sessions">
 				<table class="table table-striped" data-toggle="table" data-sort-name="id">
@@ -213,6 +256,62 @@ $(document).ready(function() {
 				</form>
 			</div>
 {{ template "templates/footer" . }}
+`))
+	template.Must(templates.New("templates/networkgetmessage").Parse(`{{ template "templates/header" . }}
+			<div class="row">
+				<h2>Network-wide GetMessage requests <span class="badge" style="vertical-align: middle">{{ .NetworkRequests | len }}</span></h2>
+				<p><a href="/status/getmessage">Show only this node</a></p>
+				{{ range $node, $err := .Errors }}
+				<div class="alert alert-danger" role="alert">Could not query <code>{{ $node }}</code>: {{ $err }}</div>
+				{{ end }}
+				<table class="table table-striped">
+					<thead>
+						<tr>
+							<th>Node</th>
+							<th>GetMessage requests</th>
+						</tr>
+					</thead>
+					<tbody>
+					{{ range $node, $count := .PerNode }}
+						<tr>
+							<td><code>{{ $node }}</code></td>
+							<td>{{ $count }}</td>
+						</tr>
+					{{ end }}
+					</tbody>
+				</table>
+				<table class="table table-striped" data-toggle="table" data-sort-name="node">
+					<thead>
+						<tr>
+							<th data-field="node" data-sortable="true">Node</th>
+							<th data-field="id" data-sortable="true">Session ID</th>
+							<th data-field="nick" data-sortable="true">Nick</th>
+							<th data-field="remoteaddr" data-sortable="true">RemoteAddr</th>
+							<th data-field="started" data-sortable="true">Started</th>
+							<th data-field="queue" data-sortable="true">Queue depth</th>
+							<th data-field="useragent" data-sortable="true">User Agent</th>
+						</tr>
+					</thead>
+					<tbody>
+					{{ range $idx, $val := .NetworkRequests }}
+						<tr>
+							<td><code>{{ $val.Node }}</code></td>
+							<td><code>{{ $val.Session.Id | printf "0x%x" }}</code></td>
+							<td>{{ $val.Nick }}</td>
+							{{ if ne $val.TrustedBridge "" }}
+							<td><span title="{{ $val.RemoteAddr }}">{{ $val.TrustedBridge }}</span> → {{ $val.ForwardedFor }}</td>
+							{{ else }}
+							<td><span title="{{ $val.ForwardedFor }} (UNTRUSTED!)">{{ $val.RemoteAddr }}</span></td>
+							{{ end }}
+							<td>{{ $val.StartedAndRelative }}</td>
+							<td>{{ $val.QueueDepth }}</td>
+							<td>{{ $val.UserAgent }}</td>
+						</tr>
+					{{ end }}
+					</tbody>
+				</table>
+			</div>
+{{ template "templates/footer" . }}
 `))
 	template.Must(templates.New("templates/sessions").Parse(`{{ template "templates/header" . }}
 			<div class="row">
@@ -227,6 +326,8 @@ $(document).ready(function() {
 							<th data-field="nick" data-sortable="true">Nick</th>
 							<th data-field="remoteaddr" data-sortable="true">RemoteAddr</th>
 							<th data-field="lastactivity" data-sortable="true">Last Activity</th>
+							<th data-field="lastread" data-sortable="true">Last Read (this node)</th>
+							<th data-field="idle" data-sortable="true">Idle</th>
 							<th data-field="channels" data-sortable="true">Channels</th>
 						</tr>
 					</thead>
@@ -238,6 +339,8 @@ $(document).ready(function() {
 							<td class="col-sm-2">{{ .Nick }}</td>
 							<td class="col-sm-2">{{ .RemoteAddr }}</code></td>
 							<td class="col-sm-2">{{ .LastActivity }}</code></td>
+							<td class="col-sm-2">{{ with index $.LastRead .Id.Id }}{{ if not .IsZero }}{{ . }}{{ end }}{{ end }}</td>
+							<td class="col-sm-1">{{ index $.Idle .Id.Id }}</td>
 							<td class="col-sm-7">
 							{{ range $key, $val := .Channels }}
 							{{ $key }},
@@ -309,6 +412,7 @@ $(document).ready(function() {
 						<tr>
 							<th>Message ID</th>
 							<th>Time</th>
+							<th>Origin</th>
 							<th>Text</th>
 						</tr>
 					</thead>
@@ -316,14 +420,15 @@ $(document).ready(function() {
 						{{ range .Messages }}
 						<tr>
 							<td class="col-sm-2"><code>{{ .Id.Id }}.{{ .Id.Reply }}</code></td>
-							<td class="col-sm-3">{{ .Timestamp }}</td>
+							<td class="col-sm-2">{{ .Timestamp }}</td>
+							<td class="col-sm-1">{{ .Origin }}</td>
 							<td class="col-sm-7">
 							{{ if eq .Id.Reply 0 }}
 							<span class="glyphicon glyphicon-arrow-right"></span>
 							{{ else }}
 							<span class="glyphicon glyphicon-arrow-left"></span>
 							{{ end }}
-							<samp>{{ .PrivacyFilter }}</samp></td>
+							<samp>{{ .Data }}</samp></td>
 						</tr>
 						{{ end }}
 					</tbody>
@@ -332,5 +437,63 @@ $(document).ready(function() {
 		</div>
 	</body>
 </html>
+`))
+	template.Must(templates.New("templates/archive").Parse(`<!DOCTYPE html>
+<html>
+	<head>
+		<title>{{ .Channel }} archive</title>
+		<link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.1/css/bootstrap.min.css">
+	</head>
+	<body>
+		<div class="container-fluid">
+			<div class="page-header">
+				<h1>{{ .Channel }} <small>generated {{ .Generated.Format "2006-01-02 15:04:05 -07:00" }}</small></h1>
+				<a href="index.html">All channels</a> · <a href="{{ .File }}.json">JSON</a>
+			</div>
+
+			<div class="row">
+				<table class="table table-striped table-condensed">
+					<thead>
+						<tr>
+							<th>Time</th>
+							<th>Nick</th>
+							<th>Text</th>
+						</tr>
+					</thead>
+					<tbody>
+						{{ range .Entries }}
+						<tr id="{{ .Id }}">
+							<td class="col-sm-2"><a href="#{{ .Id }}">{{ .Time.Format "2006-01-02 15:04:05 -07:00" }}</a></td>
+							<td class="col-sm-1">{{ if eq .Command "NOTICE" }}-{{ .Nick }}-{{ else if eq .Command "TOPIC" }}*{{ else }}&lt;{{ .Nick }}&gt;{{ end }}</td>
+							<td class="col-sm-9">{{ if eq .Command "TOPIC" }}{{ .Nick }} changed the topic to: {{ end }}{{ .Text }}</td>
+						</tr>
+						{{ end }}
+					</tbody>
+				</table>
+			</div>
+		</div>
+	</body>
+</html>
+`))
+	template.Must(templates.New("templates/archiveindex").Parse(`<!DOCTYPE html>
+<html>
+	<head>
+		<title>Channel archives</title>
+		<link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.1/css/bootstrap.min.css">
+	</head>
+	<body>
+		<div class="container-fluid">
+			<div class="page-header">
+				<h1>Channel archives <small>generated {{ .Generated.Format "2006-01-02 15:04:05 -07:00" }}</small></h1>
+			</div>
+
+			<ul>
+				{{ range .Channels }}
+				<li><a href="{{ .File }}.html">{{ .Channel }}</a> ({{ .Entries | len }} messages)</li>
+				{{ end }}
+			</ul>
+		</div>
+	</body>
+</html>
 `))
 }
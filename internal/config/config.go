@@ -49,10 +49,19 @@ func (hs HexString) String() string {
 type IRCOp struct {
 	Name     string
 	Password string
+
+	// Admin marks the operator as server administrator (user mode +A),
+	// e.g. for joining admin-only (+A) channels.
+	Admin bool
 }
 
 type Service struct {
 	Password string
+
+	// CertFingerprint optionally pins the TLS client certificate which the
+	// services must present on the services listener (-services_listen), as
+	// hex-encoded SHA-256 hash of the DER-encoded certificate.
+	CertFingerprint string
 }
 
 // IRC is the IRC-related configuration.
@@ -61,6 +70,18 @@ type IRC struct {
 	Services  []Service
 }
 
+// Admin contains the administrative contact details returned by the ADMIN
+// command.
+type Admin struct {
+	// Location, e.g. the city and country where the network is operated.
+	Location string
+
+	// Organization operating the network.
+	Organization string
+
+	Email string
+}
+
 // Network is the network configuration, i.e. the top level.
 type Network struct {
 	Revision uint64 `toml:"-"`
@@ -94,10 +115,100 @@ type Network struct {
 	MaxSessions uint64
 	MaxChannels uint64
 
+	// MaxChannelsPerUser limits the number of channels a single session
+	// can join (advertised as CHANLIMIT). Does not apply to SVSJOIN. Set to
+	// 0 to disable the limit.
+	MaxChannelsPerUser uint64
+
+	// GuestNickOnCollision makes the server assign a Guest nickname (derived
+	// from the session id) instead of returning ERR_NICKNAMEINUSE when the
+	// nickname requested during registration is already in use.
+	GuestNickOnCollision bool
+
+	// ResolveHostnames makes the raft leader look up the reverse DNS name of
+	// new sessions’ addresses. Names which resolve back to the address are
+	// shown as the host part of the session’s prefix (instead of
+	// robust/0x…), unless the session logged in before the lookup finished.
+	ResolveHostnames bool
+
 	// Banned is a map from remote address to ban reason, managed via the GLINE
 	// IRC command.
 	Banned map[string]string
 
+	// Qlines is a map from nickname pattern (e.g. “*Serv”, matched
+	// case-insensitively, * matches any sequence of characters) to the
+	// reason why the nicknames are reserved, managed via the QLINE IRC
+	// command. IRC operators may use reserved nicknames.
+	Qlines map[string]string
+
+	// ClientCertAccounts maps SHA-256 fingerprints (lowercase hex) of TLS
+	// client certificates to account names. Clients which present one of
+	// these certificates can log into the account via SASL EXTERNAL.
+	ClientCertAccounts map[string]string
+
+	// PrivacyFilter selects which information is removed from IRC messages
+	// before they are displayed (e.g. on the irclog status pages): “all”
+	// (default) removes all message texts, “private” only removes texts of
+	// private queries and passwords, “none” removes nothing.
+	PrivacyFilter string
+
+	// WhowasHistory is the number of nicknames which are remembered for
+	// the WHOWAS command after their session quit or changed nickname.
+	// 0 (e.g. in configs which predate WHOWAS) means the default of 100.
+	WhowasHistory uint64
+
+	// ChannelHistory is the number of PRIVMSG and NOTICE messages per
+	// channel which are kept in the IRC server state, i.e. which survive
+	// compaction and can be replayed using CHATHISTORY. Set to 0 to disable.
+	ChannelHistory uint64
+
+	// Plugins contains the configuration of IRC command plugins (see package
+	// plugin), keyed by plugin namespace, e.g. [Plugins.deploy].
+	Plugins map[string]map[string]string
+
+	// MaxTargets limits the number of comma-separated targets of JOIN,
+	// PART, KICK and LIST commands. Set to 0 to disable the limit.
+	MaxTargets uint64
+
+	// ReplyPageSize limits the number of entries in a single WHO, LIST or
+	// NAMES reply, so that queries on large networks cannot monopolize a
+	// session’s output. Clients retrieve further entries using the
+	// continuation token contained in the reply. Set to 0 to disable.
+	ReplyPageSize uint64
+
+	// Admin contains the contact details of the network administrators.
+	Admin Admin
+
+	// ChannelTransforms maps channel names (case-insensitive) to the name of
+	// a transform (see plugin.RegisterTransform) which is applied to the text
+	// of PRIVMSGs and NOTICEs sent to the channel, e.g. “latin1” to convert
+	// text sent by legacy ISO-8859-1 clients to UTF-8.
+	ChannelTransforms map[string]string
+
+	// NumericTexts overrides the human-readable text (i.e. the last
+	// parameter) of numeric replies, keyed by numeric (e.g. “401”), so that
+	// networks can localize server responses. Values are text/template
+	// templates which can refer to .Nick (the recipient), .Params (all
+	// other parameters) and .Text (the original text), e.g.
+	// “{{ index .Params 0 }}: Kein solcher Nick/Kanal”.
+	NumericTexts map[string]string
+
+	// CloakKey is a secret key (use e.g. openssl rand -hex 32 to generate)
+	// from which the hosts of sessions with user mode +x are derived. Keep
+	// it secret, as anyone who knows it can tell which host a cloak belongs
+	// to. Changing it only affects cloaks set afterwards.
+	CloakKey HexString
+
+	// STSDuration is advertised as the strict transport security policy (see
+	// https://ircv3.net/specs/extensions/sts): compliant clients only
+	// connect to the network using TLS for this long after they last saw the
+	// policy. Set to 0 (default) to not advertise a policy.
+	STSDuration Duration
+
+	// STSPreload allows web browsers and client developers to include the
+	// network in STS preload lists. Only has an effect if STSDuration is set.
+	STSPreload bool
+
 	// WhitelistedOrigins contains HTTP origins
 	// (e.g. https://webchat.example.com) which are whitelisted for cross-origin
 	// HTTP requests.
@@ -0,0 +1,286 @@
+// Package encryption implements the AES-GCM encryption at rest of log entries
+// and snapshots, so that IRC logs (which contain private conversations) are
+// not stored in plaintext on disk.
+//
+// Single values (e.g. LevelDB values) are sealed as:
+//
+//	12-byte nonce | ciphertext and tag
+//
+// Streams (e.g. snapshots) start with StreamMagic and an 8-byte random nonce
+// prefix, followed by chunks:
+//
+//	uint32 length | ciphertext and tag
+//
+// Each chunk holds at most chunkSize bytes of plaintext and is sealed with
+// the nonce prefix followed by the big-endian uint32 chunk number. The last
+// chunk is sealed with additional data {1} (all others with {0}), so that
+// truncated streams are detected.
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// KeySize is the size of the AES-256 key in bytes.
+const KeySize = 32
+
+// StreamMagic is the first part of an encrypted stream. It differs from the
+// first bytes of all unencrypted snapshot formats.
+var StreamMagic = []byte("RIRCAES1")
+
+const (
+	noncePrefixSize = 8
+	chunkSize       = 64 * 1024
+)
+
+var (
+	lastChunk     = []byte{1}
+	nonLastChunk  = []byte{0}
+	errTruncated  = errors.New("encrypted stream truncated")
+	errChunkLimit = errors.New("encrypted stream has too many chunks")
+)
+
+// Cipher encrypts and decrypts values and streams with one key.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// New returns a Cipher for the AES-256 |key|.
+func New(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, not %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// LoadKeyFile returns a Cipher for the key stored in |path|, either as
+// KeySize raw bytes or hex-encoded (e.g. created with
+// “openssl rand -hex 32”).
+func LoadKeyFile(path string) (*Cipher, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != KeySize {
+		key := make([]byte, KeySize)
+		trimmed := bytes.TrimSpace(b)
+		if n, err := hex.Decode(key, trimmed); err != nil || n != KeySize || len(trimmed) != 2*KeySize {
+			return nil, fmt.Errorf("%s: expected %d raw bytes or %d hex characters", path, KeySize, 2*KeySize)
+		}
+		b = key
+	}
+	return New(b)
+}
+
+// Seal returns |plaintext| encrypted and authenticated, appended to |dst|.
+func (c *Cipher) Seal(dst, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	dst = append(dst, nonce...)
+	return c.aead.Seal(dst, nonce, plaintext, nil), nil
+}
+
+// Open returns the plaintext of |sealed|, which was returned by Seal.
+func (c *Cipher) Open(sealed []byte) ([]byte, error) {
+	if len(sealed) < c.aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted value too short (%d bytes)", len(sealed))
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	return c.aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// Writer encrypts a stream, see NewWriter.
+type Writer struct {
+	w      io.Writer
+	c      *Cipher
+	prefix [noncePrefixSize]byte
+	buf    []byte
+	chunk  uint64
+	err    error
+}
+
+// NewWriter returns a Writer which writes the encryption of everything
+// written to it to |w|. Close must be called to finish the stream; it does
+// not close |w|.
+func (c *Cipher) NewWriter(w io.Writer) (*Writer, error) {
+	e := &Writer{w: w, c: c, buf: make([]byte, 0, chunkSize)}
+	if _, err := io.ReadFull(rand.Reader, e.prefix[:]); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(StreamMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(e.prefix[:]); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *Writer) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(e.buf) == chunkSize {
+			if e.err = e.flush(nonLastChunk); e.err != nil {
+				return written, e.err
+			}
+		}
+		n := copy(e.buf[len(e.buf):chunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close writes the last chunk.
+func (e *Writer) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	e.err = e.flush(lastChunk)
+	if e.err == nil {
+		e.err = errors.New("encryption.Writer closed")
+		return nil
+	}
+	return e.err
+}
+
+func (e *Writer) flush(additional []byte) error {
+	if e.chunk == math.MaxUint32 {
+		return errChunkLimit
+	}
+	sealed := e.c.aead.Seal(nil, nonce(e.prefix, e.chunk), e.buf, additional)
+	e.chunk++
+	e.buf = e.buf[:0]
+	var lenbuf [4]byte
+	binary.BigEndian.PutUint32(lenbuf[:], uint32(len(sealed)))
+	if _, err := e.w.Write(lenbuf[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+func nonce(prefix [noncePrefixSize]byte, chunk uint64) []byte {
+	n := make([]byte, noncePrefixSize+4)
+	copy(n, prefix[:])
+	binary.BigEndian.PutUint32(n[noncePrefixSize:], uint32(chunk))
+	return n
+}
+
+// reader decrypts a stream written by Writer.
+type reader struct {
+	r      io.Reader
+	c      *Cipher
+	prefix [noncePrefixSize]byte
+	buf    []byte
+	chunk  uint64
+	done   bool
+}
+
+func (d *reader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *reader) next() error {
+	var lenbuf [4]byte
+	if _, err := io.ReadFull(d.r, lenbuf[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errTruncated
+		}
+		return err
+	}
+	length := binary.BigEndian.Uint32(lenbuf[:])
+	if length > chunkSize+uint32(d.c.aead.Overhead()) {
+		return fmt.Errorf("encrypted chunk %d too large (%d bytes)", d.chunk, length)
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errTruncated
+		}
+		return err
+	}
+	n := nonce(d.prefix, d.chunk)
+	plain, err := d.c.aead.Open(nil, n, sealed, nonLastChunk)
+	if err != nil {
+		if plain, err = d.c.aead.Open(nil, n, sealed, lastChunk); err != nil {
+			return fmt.Errorf("encrypted chunk %d: %v", d.chunk, err)
+		}
+		d.done = true
+		// Data after the last chunk indicates a corrupted stream.
+		var extra [1]byte
+		if n, err := io.ReadFull(d.r, extra[:]); n > 0 {
+			return fmt.Errorf("unexpected data after the last encrypted chunk")
+		} else if err != io.EOF {
+			return err
+		}
+	}
+	d.chunk++
+	if d.chunk == math.MaxUint32 && !d.done {
+		return errChunkLimit
+	}
+	d.buf = plain
+	return nil
+}
+
+// MaybeDecrypt returns a reader which decrypts |r| if it starts with
+// StreamMagic. Otherwise, the returned reader returns |r| unmodified, so that
+// streams written before encryption was enabled can still be read. The
+// returned bool is true if |r| is encrypted. |c| may be nil, in which case
+// encrypted streams result in an error.
+func MaybeDecrypt(r io.Reader, c *Cipher) (io.Reader, bool, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(StreamMagic))
+	if err != nil && err != io.EOF {
+		return nil, false, err
+	}
+	if !bytes.Equal(magic, StreamMagic) {
+		return br, false, nil
+	}
+	if c == nil {
+		return nil, true, fmt.Errorf("stream is encrypted, but no encryption key was specified")
+	}
+	if _, err := br.Discard(len(StreamMagic)); err != nil {
+		return nil, true, err
+	}
+	d := &reader{r: br, c: c}
+	if _, err := io.ReadFull(br, d.prefix[:]); err != nil {
+		return nil, true, errTruncated
+	}
+	return d, true, nil
+}
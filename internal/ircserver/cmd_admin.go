@@ -0,0 +1,46 @@
+package ircserver
+
+import "gopkg.in/sorcix/irc.v2"
+
+func init() {
+	Commands["ADMIN"] = &ircCommand{
+		Func:     (*IRCServer).cmdAdmin,
+		ReadOnly: true,
+	}
+}
+
+func (i *IRCServer) cmdAdmin(s *Session, reply *Replyctx, msg *irc.Message) {
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	admin := i.Config.Admin
+
+	if admin.Location == "" && admin.Organization == "" && admin.Email == "" {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOADMININFO,
+			Params:  []string{s.Nick, i.ServerPrefix.Name, "No administrative info available"},
+		})
+		return
+	}
+
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.RPL_ADMINME,
+		Params:  []string{s.Nick, i.ServerPrefix.Name, "Administrative info"},
+	})
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.RPL_ADMINLOC1,
+		Params:  []string{s.Nick, admin.Location},
+	})
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.RPL_ADMINLOC2,
+		Params:  []string{s.Nick, admin.Organization},
+	})
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.RPL_ADMINEMAIL,
+		Params:  []string{s.Nick, admin.Email},
+	})
+}
@@ -0,0 +1,33 @@
+package ircserver
+
+import (
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/config"
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestAdmin(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("ADMIN")),
+		":robustirc.net 423 sECuRE robustirc.net :No administrative info available")
+
+	i.Config.Admin = config.Admin{
+		Location:     "Zürich, Switzerland",
+		Organization: "RobustIRC",
+		Email:        "admin@robustirc.net",
+	}
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("ADMIN")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 256 sECuRE robustirc.net :Administrative info"),
+			irc.ParseMessage(":robustirc.net 257 sECuRE :Zürich, Switzerland"),
+			irc.ParseMessage(":robustirc.net 258 sECuRE :RobustIRC"),
+			irc.ParseMessage(":robustirc.net 259 sECuRE :admin@robustirc.net"),
+		})
+}
@@ -0,0 +1,148 @@
+package ircserver
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/robustirc/robustirc/internal/config"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// saslMechanisms are the supported SASL mechanisms, see cmdAuthenticate.
+const saslMechanisms = "EXTERNAL"
+
+func init() {
+	Commands["AUTHENTICATE"] = &ircCommand{
+		Func:      (*IRCServer).cmdAuthenticate,
+		MinParams: 1,
+	}
+	registerCap("sasl", capability{
+		value: func(cfg *config.Network) string { return saslMechanisms },
+	})
+}
+
+// clientCertAccount returns the account which the network configuration
+// associates with the TLS client certificate |fingerprint|, if any.
+func (i *IRCServer) clientCertAccount(fingerprint string) (string, bool) {
+	if fingerprint == "" {
+		return "", false
+	}
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	account, ok := i.Config.ClientCertAccounts[strings.ToLower(fingerprint)]
+	return account, ok
+}
+
+// cmdAuthenticate implements SASL authentication (see
+// https://ircv3.net/specs/extensions/sasl-3.1) using the EXTERNAL mechanism:
+// clients log into the account which config.Network.ClientCertAccounts
+// associates with the TLS client certificate they presented.
+func (i *IRCServer) cmdAuthenticate(s *Session, reply *Replyctx, msg *irc.Message) {
+	nick := s.Nick
+	if nick == "" {
+		nick = "*"
+	}
+
+	if !s.caps["sasl"] {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_SASLFAIL,
+			Params:  []string{nick, "SASL authentication failed: the sasl capability is not enabled"},
+		})
+		return
+	}
+
+	if s.account != "" {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_SASLALREADY,
+			Params:  []string{nick, "You have already authenticated using SASL"},
+		})
+		return
+	}
+
+	data := msg.Params[0]
+	if data == "*" {
+		s.saslMechanism = ""
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_SASLABORTED,
+			Params:  []string{nick, "SASL authentication aborted"},
+		})
+		return
+	}
+
+	if s.saslMechanism == "" {
+		if strings.ToUpper(data) != "EXTERNAL" {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.RPL_SASLMECHS,
+				Params:  []string{nick, saslMechanisms, "are available SASL mechanisms"},
+			})
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.ERR_SASLFAIL,
+				Params:  []string{nick, "SASL authentication failed"},
+			})
+			return
+		}
+		s.saslMechanism = "EXTERNAL"
+		i.sendUser(s, reply, &irc.Message{
+			Command: irc.AUTHENTICATE,
+			Params:  []string{"+"},
+		})
+		return
+	}
+
+	// The only message of EXTERNAL is the (optional) authorization
+	// identity, which is too short to ever be split into 400 byte chunks.
+	s.saslMechanism = ""
+	if len(data) >= 400 {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_SASLTOOLONG,
+			Params:  []string{nick, "SASL message too long"},
+		})
+		return
+	}
+	var authzid string
+	if data != "+" {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.ERR_SASLFAIL,
+				Params:  []string{nick, "SASL authentication failed: invalid base64"},
+			})
+			return
+		}
+		authzid = string(decoded)
+	}
+
+	account, ok := i.clientCertAccount(s.certFingerprint)
+	if !ok || (authzid != "" && authzid != account) {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_SASLFAIL,
+			Params:  []string{nick, "SASL authentication failed"},
+		})
+		return
+	}
+
+	s.account = account
+	mask := "*"
+	if s.Nick != "" {
+		mask = s.ircPrefix.String()
+	}
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.RPL_LOGGEDIN,
+		Params:  []string{nick, mask, account, "You are now logged in as " + account},
+	})
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.RPL_SASLSUCCESS,
+		Params:  []string{nick, "SASL authentication successful"},
+	})
+}
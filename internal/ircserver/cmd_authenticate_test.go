@@ -0,0 +1,96 @@
+package ircserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+const testFingerprint = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+func TestAuthenticateExternal(t *testing.T) {
+	i, ids := stdIRCServer()
+	i.Config.ClientCertAccounts = map[string]string{
+		testFingerprint: "alice",
+	}
+
+	id := robust.Id{Id: 1420228218166687920}
+	i.CreateSession(id, "auth-alice", time.Unix(0, int64(id.Id)))
+	withCert := &robust.Message{Session: id, CertFingerprint: testFingerprint}
+	withoutCert := &robust.Message{Session: id}
+
+	mustMatchMsg(t,
+		i.ProcessMessage(withCert, irc.ParseMessage("AUTHENTICATE EXTERNAL")),
+		":robustirc.net 904 * :SASL authentication failed: the sasl capability is not enabled")
+
+	i.ProcessMessage(withoutCert, irc.ParseMessage("CAP REQ sasl"))
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(withCert, irc.ParseMessage("AUTHENTICATE PLAIN")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 908 * EXTERNAL :are available SASL mechanisms"),
+			irc.ParseMessage(":robustirc.net 904 * :SASL authentication failed"),
+		})
+
+	// Without a (known) certificate, authentication fails.
+	mustMatchMsg(t,
+		i.ProcessMessage(withoutCert, irc.ParseMessage("AUTHENTICATE EXTERNAL")),
+		"AUTHENTICATE +")
+	mustMatchMsg(t,
+		i.ProcessMessage(withoutCert, irc.ParseMessage("AUTHENTICATE +")),
+		":robustirc.net 904 * :SASL authentication failed")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(withCert, irc.ParseMessage("AUTHENTICATE EXTERNAL")),
+		"AUTHENTICATE +")
+	mustMatchMsg(t,
+		i.ProcessMessage(withCert, irc.ParseMessage("AUTHENTICATE *")),
+		":robustirc.net 906 * :SASL authentication aborted")
+
+	// An authorization identity other than the account is rejected.
+	i.ProcessMessage(withCert, irc.ParseMessage("AUTHENTICATE EXTERNAL"))
+	mustMatchMsg(t,
+		i.ProcessMessage(withCert, irc.ParseMessage("AUTHENTICATE Ym9i")), // bob
+		":robustirc.net 904 * :SASL authentication failed")
+
+	i.ProcessMessage(withoutCert, irc.ParseMessage("NICK alice"))
+	i.ProcessMessage(withoutCert, irc.ParseMessage("USER alice 0 * :Alice"))
+
+	// The exchange survives snapshots.
+	i.ProcessMessage(withCert, irc.ParseMessage("AUTHENTICATE EXTERNAL"))
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewIRCServer("robustirc.net", time.Now())
+	if _, err := restored.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+	i = restored
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(withCert, irc.ParseMessage("AUTHENTICATE YWxpY2U=")), // alice
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 900 alice alice!alice@robust/0x13b5aa0a2bcfb8b0 alice :You are now logged in as alice"),
+			irc.ParseMessage(":robustirc.net 903 alice :SASL authentication successful"),
+		})
+
+	mustMatchMsg(t,
+		i.ProcessMessage(withCert, irc.ParseMessage("AUTHENTICATE EXTERNAL")),
+		":robustirc.net 907 alice :You have already authenticated using SASL")
+
+	i.ProcessMessage(withoutCert, irc.ParseMessage("CAP END"))
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("WHOIS alice")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 311 sECuRE alice alice robust/0x13b5aa0a2bcfb8b0 * :Alice"),
+			irc.ParseMessage(":robustirc.net 312 sECuRE alice robustirc.net :RobustIRC"),
+			irc.ParseMessage(":robustirc.net 330 sECuRE alice alice :is logged in as"),
+			irc.ParseMessage(":robustirc.net 317 sECuRE alice 0 1420228218 :seconds idle, signon time"),
+			irc.ParseMessage(":robustirc.net 318 sECuRE alice :End of /WHOIS list"),
+		})
+}
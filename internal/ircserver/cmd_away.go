@@ -10,10 +10,19 @@ func init() {
 	Commands["AWAY"] = &ircCommand{
 		Func: (*IRCServer).cmdAway,
 	}
+	registerCap("away-notify", capability{})
 }
 
 func (i *IRCServer) cmdAway(s *Session, reply *Replyctx, msg *irc.Message) {
 	s.AwayMsg = strings.TrimSpace(msg.Trailing())
+	away := &irc.Message{
+		Prefix:  &s.ircPrefix,
+		Command: irc.AWAY,
+	}
+	if s.AwayMsg != "" {
+		away.Params = []string{s.AwayMsg}
+	}
+	i.sendCommonChannelsWithCap(s, reply, "away-notify", away)
 	if s.AwayMsg != "" {
 		i.sendUser(s, reply, &irc.Message{
 			Prefix:  i.ServerPrefix,
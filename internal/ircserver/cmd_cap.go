@@ -0,0 +1,269 @@
+package ircserver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/robustirc/robustirc/internal/config"
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// capability is an IRCv3 capability which clients can enable via CAP REQ.
+type capability struct {
+	// value returns the value which is advertised to clients which
+	// negotiate CAP version 302 or newer (e.g. “EXTERNAL” for
+	// “sasl=EXTERNAL”) under the network configuration |cfg|. The empty
+	// string means no value. May be nil.
+	value func(cfg *config.Network) string
+
+	// informational capabilities (e.g. sts) only convey their value and
+	// cannot be enabled via CAP REQ. They are only advertised to clients
+	// which negotiate CAP version 302 or newer, and only while their value
+	// is not empty.
+	informational bool
+}
+
+// capabilities are the registered IRCv3 capabilities, see registerCap.
+var capabilities = make(map[string]capability)
+
+// registerCap makes capability |name| available to clients. Features
+// register their capability in an init function next to their
+// implementation and check whether a session enabled it via Session.caps.
+func registerCap(name string, c capability) {
+	if _, ok := capabilities[name]; ok {
+		panic(fmt.Sprintf("capability %q registered twice", name))
+	}
+	capabilities[name] = c
+}
+
+// advertisedCaps returns the values (see capability.value) of all
+// capabilities which are advertised under |cfg|, keyed by name.
+// Informational capabilities without value are not advertised.
+func advertisedCaps(cfg *config.Network) map[string]string {
+	result := make(map[string]string, len(capabilities))
+	for name, c := range capabilities {
+		var value string
+		if c.value != nil {
+			value = c.value(cfg)
+		}
+		if c.informational && value == "" {
+			continue
+		}
+		result[name] = value
+	}
+	return result
+}
+
+// maxCapLine is the maximum length of the capability list in a single CAP
+// LS or CAP LIST reply, leaving enough room for the prefix and the other
+// parameters within the 512 byte limit of IRC messages.
+const maxCapLine = 400
+
+func init() {
+	Commands["CAP"] = &ircCommand{
+		Func:      (*IRCServer).cmdCap,
+		MinParams: 1,
+	}
+	// See sendCapChanges.
+	registerCap("cap-notify", capability{})
+}
+
+// sendCapChanges sends CAP NEW and CAP DEL to all sessions which enabled
+// cap-notify when applying |newCfg| changes the advertised capabilities
+// (or their values), e.g. when an STS policy is configured. Capabilities
+// which are no longer available are disabled.
+func (i *IRCServer) sendCapChanges(reply *Replyctx, newCfg *config.Network) {
+	oldCaps := func() map[string]string {
+		i.ConfigMu.RLock()
+		defer i.ConfigMu.RUnlock()
+		return advertisedCaps(&i.Config)
+	}()
+	newCaps := advertisedCaps(newCfg)
+	var added, removed []string
+	for name, value := range newCaps {
+		if oldValue, ok := oldCaps[name]; !ok || oldValue != value {
+			if value != "" {
+				name += "=" + value
+			}
+			added = append(added, name)
+		}
+	}
+	for name := range oldCaps {
+		if _, ok := newCaps[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	// Sorted so that messages are created in the same order on all servers.
+	ids := make([]robust.Id, 0, len(i.sessions))
+	for id := range i.sessions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a].Id < ids[b].Id })
+	for _, id := range ids {
+		s := i.sessions[id]
+		if !s.caps["cap-notify"] {
+			continue
+		}
+		nick := s.Nick
+		if nick == "" {
+			nick = "*"
+		}
+		if len(removed) > 0 {
+			for _, name := range removed {
+				delete(s.caps, name)
+			}
+			i.sendCapList(s, reply, nick, "DEL", removed, 302)
+		}
+		if len(added) > 0 {
+			i.sendCapList(s, reply, nick, "NEW", added, 302)
+		}
+	}
+}
+
+// capVersion returns the CAP version requested by CAP LS, e.g. 302 for
+// “CAP LS 302”. Clients which do not specify a version get 0.
+func capVersion(msg *irc.Message) int {
+	if len(msg.Params) < 2 {
+		return 0
+	}
+	version, err := strconv.Atoi(msg.Params[1])
+	if err != nil {
+		return 0
+	}
+	return version
+}
+
+// sendCapList sends |caps| as CAP |subcommand| to |s|. For clients which
+// negotiated CAP version 302, long lists are split into multiple messages,
+// all but the last one marked with “*”.
+func (i *IRCServer) sendCapList(s *Session, reply *Replyctx, nick, subcommand string, caps []string, version int) {
+	lines := []string{strings.Join(caps, " ")}
+	if version >= 302 {
+		lines = joinLimited(caps, maxCapLine)
+		if len(lines) == 0 {
+			lines = []string{""}
+		}
+	}
+	for idx, line := range lines {
+		params := []string{nick, subcommand, line}
+		if idx < len(lines)-1 {
+			params = []string{nick, subcommand, "*", line}
+		}
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.CAP,
+			Params:  params,
+		})
+	}
+}
+
+func (i *IRCServer) cmdCap(s *Session, reply *Replyctx, msg *irc.Message) {
+	nick := s.Nick
+	if nick == "" {
+		nick = "*"
+	}
+
+	switch subcommand := strings.ToUpper(msg.Params[0]); subcommand {
+	case irc.CAP_LS:
+		if !s.loggedIn {
+			s.capNegotiating = true
+		}
+		version := capVersion(msg)
+		if version >= 302 {
+			// As per https://ircv3.net/specs/extensions/capability-negotiation#cap-notify
+			s.caps["cap-notify"] = true
+		}
+		advertised := func() map[string]string {
+			i.ConfigMu.RLock()
+			defer i.ConfigMu.RUnlock()
+			return advertisedCaps(&i.Config)
+		}()
+		names := make([]string, 0, len(advertised))
+		for name := range advertised {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		caps := make([]string, 0, len(names))
+		for _, name := range names {
+			if version < 302 {
+				// Informational capabilities are meaningless without
+				// their value.
+				if !capabilities[name].informational {
+					caps = append(caps, name)
+				}
+				continue
+			}
+			if value := advertised[name]; value != "" {
+				name += "=" + value
+			}
+			caps = append(caps, name)
+		}
+		i.sendCapList(s, reply, nick, irc.CAP_LS, caps, version)
+
+	case irc.CAP_LIST:
+		caps := make([]string, 0, len(s.caps))
+		for c := range s.caps {
+			caps = append(caps, c)
+		}
+		sort.Strings(caps)
+		// CAP LIST does not carry a version, so always allow splitting.
+		i.sendCapList(s, reply, nick, irc.CAP_LIST, caps, 302)
+
+	case irc.CAP_REQ:
+		if !s.loggedIn {
+			s.capNegotiating = true
+		}
+		var requested string
+		if len(msg.Params) > 1 {
+			requested = msg.Params[1]
+		}
+		// Requests are applied either entirely or not at all.
+		fields := strings.Fields(requested)
+		for _, field := range fields {
+			if c, ok := capabilities[strings.TrimPrefix(field, "-")]; !ok || c.informational {
+				i.sendUser(s, reply, &irc.Message{
+					Prefix:  i.ServerPrefix,
+					Command: irc.CAP,
+					Params:  []string{nick, irc.CAP_NAK, requested},
+				})
+				return
+			}
+		}
+		for _, field := range fields {
+			if strings.HasPrefix(field, "-") {
+				delete(s.caps, field[1:])
+			} else {
+				s.caps[field] = true
+			}
+		}
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.CAP,
+			Params:  []string{nick, irc.CAP_ACK, requested},
+		})
+
+	case irc.CAP_END:
+		if !s.capNegotiating {
+			return
+		}
+		s.capNegotiating = false
+		i.maybeLogin(s, reply, msg)
+
+	default:
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: "410", // ERR_INVALIDCAPCMD
+			Params:  []string{nick, subcommand, "Invalid CAP subcommand"},
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package ircserver
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/config"
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestCap(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	ids["capable"] = robust.Id{Id: 1420228218166687920}
+	i.CreateSession(ids["capable"], "auth-capable", time.Unix(0, int64(ids["capable"].Id)))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["capable"]}, irc.ParseMessage("CAP LS 302")),
+		":robustirc.net CAP * LS :away-notify cap-notify invite-notify message-tags sasl=EXTERNAL server-time setname userhost-in-names")
+
+	i.ProcessMessage(&robust.Message{Session: ids["capable"]}, irc.ParseMessage("NICK capable"))
+	if got := i.ProcessMessage(&robust.Message{Session: ids["capable"]}, irc.ParseMessage("USER cap 0 * :Cap Able")); len(got.Messages) > 0 {
+		t.Fatalf("registration completed during CAP negotiation: got %v", got.Messages)
+	}
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["capable"]}, irc.ParseMessage("CAP REQ :away-notify foo")),
+		":robustirc.net CAP capable NAK :away-notify foo")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["capable"]}, irc.ParseMessage("CAP REQ :away-notify userhost-in-names")),
+		":robustirc.net CAP capable ACK :away-notify userhost-in-names")
+
+	// The negotiation state survives snapshots, e.g. when a different node
+	// takes over mid-negotiation.
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewIRCServer("robustirc.net", time.Now())
+	if _, err := restored.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+	restored.Config = i.Config
+	i = restored
+
+	// cap-notify is implicitly enabled by CAP LS 302.
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["capable"]}, irc.ParseMessage("CAP LIST")),
+		":robustirc.net CAP capable LIST :away-notify cap-notify userhost-in-names")
+
+	got := i.ProcessMessage(&robust.Message{Session: ids["capable"]}, irc.ParseMessage("CAP END"))
+	if len(got.Messages) == 0 {
+		t.Fatalf("CAP END did not complete registration")
+	}
+	if msg := irc.ParseMessage(got.Messages[0].Data); msg.Command != irc.RPL_WELCOME {
+		t.Fatalf("unexpected reply to CAP END: got %q, want %s", got.Messages[0].Data, irc.RPL_WELCOME)
+	}
+
+	i.ProcessMessage(&robust.Message{Session: ids["capable"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["capable"]}, irc.ParseMessage("NAMES #test")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 353 capable = #test :@capable!cap@robust/0x13b5aa0a2bcfb8b0 sECuRE!blah@robust/0x13b5aa0a2bcfb8ad"),
+			irc.ParseMessage(":robustirc.net 366 capable #test :End of /NAMES list."),
+		})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("AWAY :lunch")),
+		[]*irc.Message{
+			irc.ParseMessage(":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad AWAY :lunch"),
+			irc.ParseMessage(":robustirc.net 306 sECuRE :You have been marked as being away"),
+		})
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["capable"]}, irc.ParseMessage("CAP REQ -away-notify")),
+		":robustirc.net CAP capable ACK -away-notify")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("AWAY")),
+		":robustirc.net 305 sECuRE :You are no longer marked as being away")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["capable"]}, irc.ParseMessage("CAP FOO")),
+		":robustirc.net 410 capable FOO :Invalid CAP subcommand")
+}
+
+func TestCapLs302(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	// Register enough capabilities to exceed a single line.
+	var registered []string
+	for idx := 0; idx < 30; idx++ {
+		name := fmt.Sprintf("example.org/capability-%02d", idx)
+		registerCap(name, capability{
+			value: func(cfg *config.Network) string { return "v" },
+		})
+		registered = append(registered, name)
+	}
+	defer func() {
+		for _, name := range registered {
+			delete(capabilities, name)
+		}
+	}()
+
+	got := i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("CAP LS 302"))
+	if len(got.Messages) != 3 {
+		t.Fatalf("CAP LS 302: got %d messages, want 3", len(got.Messages))
+	}
+	var caps []string
+	for idx, msg := range got.Messages {
+		ircmsg := irc.ParseMessage(msg.Data)
+		if len(msg.Data) > 512 {
+			t.Errorf("CAP LS 302 reply %q exceeds 512 bytes", msg.Data)
+		}
+		continued := len(ircmsg.Params) == 4 && ircmsg.Params[2] == "*"
+		if last := idx == len(got.Messages)-1; continued == last {
+			t.Errorf("CAP LS 302 reply %q: continuation marker = %v, want %v", msg.Data, continued, !last)
+		}
+		caps = append(caps, strings.Fields(ircmsg.Trailing())...)
+	}
+	// sts is not advertised, as no STSDuration is configured.
+	if got, want := len(caps), len(capabilities)-1; got != want {
+		t.Fatalf("CAP LS 302 advertised %d capabilities, want %d", got, want)
+	}
+	if got, want := caps[2], "example.org/capability-00=v"; got != want {
+		t.Fatalf("CAP LS 302: got %q, want %q", got, want)
+	}
+
+	// Without a version, values are omitted and the list is not split.
+	got = i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("CAP LS"))
+	if len(got.Messages) != 1 {
+		t.Fatalf("CAP LS: got %d messages, want 1", len(got.Messages))
+	}
+	if strings.Contains(got.Messages[0].Data, "=") {
+		t.Fatalf("CAP LS: unexpected value in %q", got.Messages[0].Data)
+	}
+}
+
+func TestCapNotify(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("CAP LS 302"))
+
+	cfg := i.Config
+	cfg.STSDuration = config.Duration(time.Hour)
+	mustMatchMsg(t,
+		i.ConfigApplied(&robust.Message{Id: robust.Id{Id: 1425052755000000000}, Type: robust.Config, Revision: 2}, cfg),
+		":robustirc.net CAP sECuRE NEW :sts=duration=3600")
+	i.Config = cfg
+
+	cfg.STSPreload = true
+	mustMatchMsg(t,
+		i.ConfigApplied(&robust.Message{Id: robust.Id{Id: 1425052756000000000}, Type: robust.Config, Revision: 3}, cfg),
+		":robustirc.net CAP sECuRE NEW :sts=duration=3600,preload")
+	i.Config = cfg
+
+	cfg.STSDuration = 0
+	mustMatchMsg(t,
+		i.ConfigApplied(&robust.Message{Id: robust.Id{Id: 1425052757000000000}, Type: robust.Config, Revision: 4}, cfg),
+		":robustirc.net CAP sECuRE DEL :sts")
+	i.Config = cfg
+
+	// Unrelated changes do not result in any messages.
+	cfg.MaxTargets = 4
+	mustMatchIrcmsgs(t,
+		i.ConfigApplied(&robust.Message{Id: robust.Id{Id: 1425052758000000000}, Type: robust.Config, Revision: 5}, cfg),
+		[]*irc.Message{})
+}
@@ -0,0 +1,71 @@
+package ircserver
+
+import (
+	"strconv"
+	"strings"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func init() {
+	Commands["CHATHISTORY"] = &ircCommand{
+		Func:      (*IRCServer).cmdChathistory,
+		MinParams: 4,
+		ReadOnly:  true,
+	}
+}
+
+// cmdChathistory replays the channel history which is retained in the state
+// (see recordHistory), following
+// https://ircv3.net/specs/extensions/chathistory. Only “CHATHISTORY LATEST
+// <channel> * <limit>” is supported: history entries carry no msgid which
+// clients could refer to. Clients which enabled server-time receive the time
+// at which each message was originally sent.
+func (i *IRCServer) cmdChathistory(s *Session, reply *Replyctx, msg *irc.Message) {
+	subcommand := strings.ToUpper(msg.Params[0])
+	if subcommand != "LATEST" || msg.Params[2] != "*" {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: "FAIL",
+			Params:  []string{"CHATHISTORY", "INVALID_PARAMS", subcommand, "Only LATEST with * is supported"},
+		})
+		return
+	}
+	limit, err := strconv.Atoi(msg.Params[3])
+	if err != nil || limit <= 0 {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: "FAIL",
+			Params:  []string{"CHATHISTORY", "INVALID_PARAMS", subcommand, "Invalid limit"},
+		})
+		return
+	}
+	c, ok := i.channels[ChanToLower(msg.Params[1])]
+	if ok {
+		_, ok = c.nicks[NickToLower(s.Nick)]
+	}
+	if !ok {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: "FAIL",
+			Params:  []string{"CHATHISTORY", "INVALID_TARGET", subcommand, msg.Params[1], "Messages could not be retrieved"},
+		})
+		return
+	}
+
+	history := c.history
+	if len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+	for _, entry := range history {
+		robustmsg := i.send(reply, &irc.Message{
+			Prefix:  irc.ParsePrefix(entry.prefix),
+			Command: entry.command,
+			Params:  []string{c.name, entry.text},
+		})
+		robustmsg.InterestingFor[s.Id.Id] = true
+		if s.caps["server-time"] {
+			robustmsg.Data = AddServerTime(robustmsg.Data, entry.time)
+		}
+	}
+}
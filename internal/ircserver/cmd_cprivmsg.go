@@ -0,0 +1,87 @@
+package ircserver
+
+import (
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func init() {
+	Commands["CPRIVMSG"] = &ircCommand{
+		Func:      (*IRCServer).cmdCprivmsg,
+		MinParams: 3,
+	}
+	Commands["CNOTICE"] = &ircCommand{
+		Func:      (*IRCServer).cmdCprivmsg,
+		MinParams: 3,
+	}
+}
+
+// cmdCprivmsg implements CPRIVMSG and CNOTICE (“CPRIVMSG <nick> <channel>
+// :<text>”), which send a private message to a user with whom the sender
+// shares <channel>. Clients such as bots which message many users use these
+// commands so that their messages are not subject to per-target throttling.
+func (i *IRCServer) cmdCprivmsg(s *Session, reply *Replyctx, msg *irc.Message) {
+	command := irc.PRIVMSG
+	if msg.Command == "CNOTICE" {
+		command = irc.NOTICE
+	}
+	// As per RFC2812 section 3.3.2, never send automatic replies to notices.
+	sendError := func(errmsg *irc.Message) {
+		if command == irc.PRIVMSG {
+			i.sendUser(s, reply, errmsg)
+		}
+	}
+
+	nickname, channelname := msg.Params[0], msg.Params[1]
+	c, ok := i.channels[ChanToLower(channelname)]
+	if !ok {
+		sendError(&irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOSUCHCHANNEL,
+			Params:  []string{s.Nick, channelname, "No such channel"},
+		})
+		return
+	}
+	if _, ok := c.nicks[NickToLower(s.Nick)]; !ok {
+		sendError(&irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOTONCHANNEL,
+			Params:  []string{s.Nick, c.name, "You're not on that channel"},
+		})
+		return
+	}
+	session, ok := i.nicks[NickToLower(nickname)]
+	if !ok {
+		sendError(&irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOSUCHNICK,
+			Params:  []string{s.Nick, nickname, "No such nick/channel"},
+		})
+		return
+	}
+	if _, ok := c.nicks[NickToLower(session.Nick)]; !ok {
+		sendError(&irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_USERNOTINCHANNEL,
+			Params:  []string{s.Nick, session.Nick, c.name, "They aren't on that channel"},
+		})
+		return
+	}
+
+	if session.silenced(s) {
+		return
+	}
+
+	i.sendUser(session, reply, &irc.Message{
+		Prefix:  &s.ircPrefix,
+		Command: command,
+		Params:  []string{session.Nick, msg.Trailing()},
+	})
+
+	if session.AwayMsg != "" && command == irc.PRIVMSG {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.RPL_AWAY,
+			Params:  []string{s.Nick, session.Nick, session.AwayMsg},
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package ircserver
+
+import (
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestCprivmsg(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("CPRIVMSG mero #test :hey")),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad PRIVMSG mero :hey")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("CNOTICE mero #test :hey")),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad NOTICE mero :hey")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("CPRIVMSG xeen #test :hey")),
+		":robustirc.net 441 sECuRE xeen #test :They aren't on that channel")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("CPRIVMSG mero #test :hey")),
+		":robustirc.net 442 xeen #test :You're not on that channel")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("CPRIVMSG mero #toast :hey")),
+		":robustirc.net 403 sECuRE #toast :No such channel")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("CPRIVMSG sorcix #test :hey")),
+		":robustirc.net 401 sECuRE sorcix :No such nick/channel")
+
+	// No automatic replies to notices.
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("CNOTICE xeen #test :hey")),
+		[]*irc.Message{})
+}
@@ -0,0 +1,73 @@
+package ircserver
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func init() {
+	Commands["DIE"] = &ircCommand{
+		Func:      (*IRCServer).cmdDie,
+		MinParams: 1,
+	}
+	Commands["RESTART"] = &ircCommand{
+		Func:      (*IRCServer).cmdDie,
+		MinParams: 1,
+	}
+}
+
+// ShutdownRequest is a request to shut down (DIE) or restart (RESTART) a
+// single node of the network.
+type ShutdownRequest struct {
+	// Node is the raft address of the node, e.g. "fastbox.robustirc.net:60667".
+	Node string
+
+	// Restart is true for RESTART, false for DIE.
+	Restart bool
+}
+
+// cmdDie implements DIE and RESTART (“DIE <node>”). As every node applies
+// the request, it names the node which should shut down. The state machine
+// picks up the request via TakeShutdownRequest; the IRC state itself is not
+// modified.
+func (i *IRCServer) cmdDie(s *Session, reply *Replyctx, msg *irc.Message) {
+	if !s.Operator {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOPRIVILEGES,
+			Params:  []string{s.Nick, "Permission Denied - You're not an IRC operator"},
+		})
+		return
+	}
+
+	i.shutdownRequest = &ShutdownRequest{
+		Node:    msg.Params[0],
+		Restart: strings.ToUpper(msg.Command) == "RESTART",
+	}
+	what := "shut down"
+	if i.shutdownRequest.Restart {
+		what = "restart"
+	}
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.NOTICE,
+		Params:  []string{s.Nick, fmt.Sprintf("Asked node %s to %s", msg.Params[0], what)},
+	})
+	i.sendOperators(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.NOTICE,
+		Params:  []string{"*", fmt.Sprintf("*** Notice -- %s asked node %s to %s", s.Nick, msg.Params[0], what)},
+	})
+}
+
+// TakeShutdownRequest returns the ShutdownRequest of the message which was
+// just processed, if any, and forgets about it.
+func (i *IRCServer) TakeShutdownRequest() *ShutdownRequest {
+	i.sessionsMu.Lock()
+	defer i.sessionsMu.Unlock()
+	req := i.shutdownRequest
+	i.shutdownRequest = nil
+	return req
+}
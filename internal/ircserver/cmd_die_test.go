@@ -0,0 +1,48 @@
+package ircserver
+
+import (
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestDie(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("DIE localhost:13001")),
+		":robustirc.net 481 sECuRE :Permission Denied - You're not an IRC operator")
+
+	if req := i.TakeShutdownRequest(); req != nil {
+		t.Fatalf("TakeShutdownRequest() = %+v, want nil", req)
+	}
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("OPER mero foo"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("OPER xeen foo"))
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("RESTART localhost:13001")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net NOTICE mero :Asked node localhost:13001 to restart"),
+			irc.ParseMessage(":robustirc.net NOTICE * :*** Notice -- mero asked node localhost:13001 to restart"),
+		})
+
+	req := i.TakeShutdownRequest()
+	if req == nil || req.Node != "localhost:13001" || !req.Restart {
+		t.Fatalf("TakeShutdownRequest() = %+v, want {Node: localhost:13001, Restart: true}", req)
+	}
+	if req := i.TakeShutdownRequest(); req != nil {
+		t.Fatalf("TakeShutdownRequest() = %+v after taking the request, want nil", req)
+	}
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("restart localhost:13001"))
+	if req := i.TakeShutdownRequest(); req == nil || !req.Restart {
+		t.Fatalf("TakeShutdownRequest() = %+v after lower-case restart, want Restart: true", req)
+	}
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("DIE")),
+		":robustirc.net 461 mero DIE :Not enough parameters")
+}
@@ -2,6 +2,7 @@ package ircserver
 
 import (
 	"fmt"
+	"strings"
 
 	"gopkg.in/sorcix/irc.v2"
 )
@@ -11,6 +12,10 @@ func init() {
 		Func:      (*IRCServer).cmdGline,
 		MinParams: 2,
 	}
+	Commands["UNGLINE"] = &ircCommand{
+		Func:      (*IRCServer).cmdUngline,
+		MinParams: 1,
+	}
 }
 
 func (i *IRCServer) cmdGline(s *Session, reply *Replyctx, msg *irc.Message) {
@@ -24,6 +29,13 @@ func (i *IRCServer) cmdGline(s *Session, reply *Replyctx, msg *irc.Message) {
 
 	}
 
+	// GLINE [minutes] user@host :reason adds a G-line, whereas
+	// GLINE nick :reason bans the current address of nick.
+	if len(msg.Params) > 2 || strings.Contains(msg.Params[0], "@") {
+		i.addKlineLocked(s, reply, "G", msg.Params)
+		return
+	}
+
 	nick := NickToLower(msg.Params[0])
 	session, ok := i.nicks[nick]
 	if !ok {
@@ -44,9 +56,49 @@ func (i *IRCServer) cmdGline(s *Session, reply *Replyctx, msg *irc.Message) {
 		return
 	}
 
+	i.ban(session.RemoteAddr, msg.Trailing())
+
+	i.cmdKill(s, reply, msg)
+}
+
+// ban adds |remoteAddr| to the network configuration’s list of banned
+// addresses. ConfigMu must not be held while killing the session, as deleting
+// sessions consults the configuration.
+func (i *IRCServer) ban(remoteAddr, reason string) {
 	i.ConfigMu.Lock()
 	defer i.ConfigMu.Unlock()
-	i.Config.Banned[session.RemoteAddr] = msg.Trailing()
+	i.Config.Banned[remoteAddr] = reason
+}
 
-	i.cmdKill(s, reply, msg)
+func (i *IRCServer) cmdUngline(s *Session, reply *Replyctx, msg *irc.Message) {
+	if !s.Operator {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOPRIVILEGES,
+			Params:  []string{s.Nick, "Permission Denied - You're not an IRC operator"},
+		})
+		return
+	}
+
+	if i.unban(msg.Params[0]) {
+		i.sendOperators(nil, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.NOTICE,
+			Params:  []string{"*", fmt.Sprintf("*** Notice -- %s has removed the G-line for [%s]", s.Nick, msg.Params[0])},
+		})
+		return
+	}
+	i.removeKlineLocked(s, reply, "G", msg.Params[0])
+}
+
+// unban removes |remoteAddr| from the network configuration’s list of banned
+// addresses and returns whether it was banned.
+func (i *IRCServer) unban(remoteAddr string) bool {
+	i.ConfigMu.Lock()
+	defer i.ConfigMu.Unlock()
+	if _, ok := i.Config.Banned[remoteAddr]; !ok {
+		return false
+	}
+	delete(i.Config.Banned, remoteAddr)
+	return true
 }
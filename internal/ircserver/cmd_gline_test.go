@@ -30,6 +30,7 @@ func TestGline(t *testing.T) {
 	mustMatchIrcmsgs(t,
 		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("GLINE secure :bye")),
 		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net NOTICE * :*** Notice -- Client exiting: sECuRE (blah@robust/0x13b5aa0a2bcfb8ad) [192.168.1.2] {session 0x13b5aa0a2bcfb8ad} (Killed: bye)"),
 			irc.ParseMessage(":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad QUIT :Killed by mero: bye"),
 			irc.ParseMessage(":mero!foo@robust/0x13b5aa0a2bcfb8ae KILL sECuRE :ircd!robust/0x13b5aa0a2bcfb8ae!mero (bye)"),
 			irc.ParseMessage("ERROR :Closing Link: sECuRE[robust/0x13b5aa0a2bcfb8ad] (Killed (mero (bye)))"),
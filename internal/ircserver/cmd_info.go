@@ -0,0 +1,29 @@
+package ircserver
+
+import "gopkg.in/sorcix/irc.v2"
+
+func init() {
+	Commands["INFO"] = &ircCommand{
+		Func:     (*IRCServer).cmdInfo,
+		ReadOnly: true,
+	}
+}
+
+func (i *IRCServer) cmdInfo(s *Session, reply *Replyctx, msg *irc.Message) {
+	for _, line := range []string{
+		"RobustIRC " + Version,
+		"RobustIRC is IRC without netsplits, see https://robustirc.net/",
+		"This server was created " + i.ServerCreation.UTC().String(),
+	} {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.RPL_INFO,
+			Params:  []string{s.Nick, line},
+		})
+	}
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.RPL_ENDOFINFO,
+		Params:  []string{s.Nick, "End of INFO list"},
+	})
+}
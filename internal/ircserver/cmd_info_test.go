@@ -0,0 +1,22 @@
+package ircserver
+
+import (
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestInfo(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("INFO")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 371 sECuRE :RobustIRC unknown"),
+			irc.ParseMessage(":robustirc.net 371 sECuRE :RobustIRC is IRC without netsplits, see https://robustirc.net/"),
+			irc.ParseMessage(":robustirc.net 371 sECuRE :This server was created 2016-12-07 20:53:32.969203276 +0000 UTC"),
+			irc.ParseMessage(":robustirc.net 374 sECuRE :End of INFO list"),
+		})
+}
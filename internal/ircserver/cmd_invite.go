@@ -2,18 +2,60 @@ package ircserver
 
 import (
 	"fmt"
+	"sort"
 
 	"gopkg.in/sorcix/irc.v2"
 )
 
 func init() {
 	Commands["INVITE"] = &ircCommand{
-		Func:      (*IRCServer).cmdInvite,
-		MinParams: 2,
+		Func: (*IRCServer).cmdInvite,
 	}
+	registerCap("invite-notify", capability{})
+}
+
+// sendInviteList sends the channels to which |s| is invited (but has not
+// joined yet) in response to an INVITE without parameters.
+func (i *IRCServer) sendInviteList(s *Session, reply *Replyctx) {
+	var channels []string
+	for lc := range s.invitedTo {
+		c, ok := i.channels[lc]
+		if !ok {
+			continue
+		}
+		if _, ok := c.nicks[NickToLower(s.Nick)]; ok {
+			continue
+		}
+		channels = append(channels, c.name)
+	}
+	sort.Strings(channels)
+	for _, channel := range channels {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: "336", // RPL_INVITELIST
+			Params:  []string{s.Nick, channel},
+		})
+	}
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: "337", // RPL_ENDOFINVITELIST
+		Params:  []string{s.Nick, "End of /INVITE list"},
+	})
 }
 
 func (i *IRCServer) cmdInvite(s *Session, reply *Replyctx, msg *irc.Message) {
+	if len(msg.Params) == 0 {
+		i.sendInviteList(s, reply)
+		return
+	}
+	if len(msg.Params) < 2 {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NEEDMOREPARAMS,
+			Params:  []string{s.Nick, "INVITE", "Not enough parameters"},
+		})
+		return
+	}
 	nickname := msg.Params[0]
 	channelname := msg.Params[1]
 	c, ok := i.channels[ChanToLower(channelname)]
@@ -50,7 +92,7 @@ func (i *IRCServer) cmdInvite(s *Session, reply *Replyctx, msg *irc.Message) {
 		})
 		return
 	}
-	if c.modes['i'] && !c.nicks[NickToLower(s.Nick)][chanop] {
+	if c.modes['i'] && !hasStatus(c.nicks[NickToLower(s.Nick)], halfop) {
 		i.sendUser(s, reply, &irc.Message{
 			Prefix:  i.ServerPrefix,
 			Command: irc.ERR_CHANOPRIVSNEEDED,
@@ -64,12 +106,21 @@ func (i *IRCServer) cmdInvite(s *Session, reply *Replyctx, msg *irc.Message) {
 		Command: irc.RPL_INVITING,
 		Params:  []string{s.Nick, session.Nick, c.name},
 	})
-	i.sendServices(reply,
+	invite := i.sendServices(reply,
 		i.sendUser(session, reply, &irc.Message{
 			Prefix:  &s.ircPrefix,
 			Command: irc.INVITE,
 			Params:  []string{session.Nick, c.name},
 		}))
+	// Let chanops who enabled invite-notify know about the invitation, see
+	// https://ircv3.net/specs/extensions/invite-notify
+	robustmsg := i.send(reply, invite)
+	for nick, perms := range c.nicks {
+		member := i.nicks[nick]
+		if member != s && member.caps["invite-notify"] && hasStatus(perms, chanop) {
+			robustmsg.InterestingFor[member.Id.Id] = true
+		}
+	}
 	i.sendChannel(c, reply, &irc.Message{
 		Prefix:  i.ServerPrefix,
 		Command: irc.NOTICE,
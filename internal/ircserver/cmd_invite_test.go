@@ -157,3 +157,60 @@ func TestInvite(t *testing.T) {
 		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #third")),
 		":robustirc.net 473 mero #third :Cannot join channel (+i)")
 }
+
+func TestInviteList(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("INVITE")),
+		":robustirc.net 337 mero :End of /INVITE list")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("INVITE xeen")),
+		":robustirc.net 461 mero INVITE :Not enough parameters")
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #zeta"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #zeta +i"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #Alpha"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #Alpha +i"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("INVITE mero #zeta"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("INVITE mero #alpha"))
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("INVITE")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 336 mero #Alpha"),
+			irc.ParseMessage(":robustirc.net 336 mero #zeta"),
+			irc.ParseMessage(":robustirc.net 337 mero :End of /INVITE list"),
+		})
+
+	// Joining consumes the invitation.
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #zeta"))
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("INVITE")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 336 mero #Alpha"),
+			irc.ParseMessage(":robustirc.net 337 mero :End of /INVITE list"),
+		})
+}
+
+func TestInviteNotify(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+	i.sessions[ids["secure"]].caps["invite-notify"] = true
+	i.sessions[ids["mero"]].caps["invite-notify"] = true
+
+	reply := i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("INVITE xeen #test"))
+	mustMatchIrcmsgs(t, reply, []*irc.Message{
+		irc.ParseMessage(":robustirc.net 341 mero xeen #test"),
+		irc.ParseMessage(":mero!foo@robust/0x13b5aa0a2bcfb8ae INVITE xeen #test"),
+		irc.ParseMessage(":robustirc.net NOTICE #test :mero invited xeen into the channel."),
+	})
+	// Chanops which enabled invite-notify are notified, the inviter is not.
+	if got := reply.Messages[1].InterestingFor; !got[ids["xeen"].Id] || !got[ids["secure"].Id] || got[ids["mero"].Id] {
+		t.Fatalf("INVITE delivered to %v, want xeen and secure", got)
+	}
+}
@@ -10,6 +10,7 @@ func init() {
 	Commands["ISON"] = &ircCommand{
 		Func:      (*IRCServer).cmdIson,
 		MinParams: 1,
+		ReadOnly:  true,
 	}
 }
 
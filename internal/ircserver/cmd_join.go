@@ -7,6 +7,13 @@ import (
 	"gopkg.in/sorcix/irc.v2"
 )
 
+// Numerics for joining restricted-entry channels, as used by UnrealIRCd.
+const (
+	errNeedReggedNick = "477"
+	errAdminOnly      = "519"
+	errOperOnly       = "520"
+)
+
 func init() {
 	Commands["JOIN"] = &ircCommand{
 		Func:      (*IRCServer).cmdJoin,
@@ -28,7 +35,11 @@ func (i *IRCServer) cmdJoin(s *Session, reply *Replyctx, msg *irc.Message) {
 	if len(msg.Params) > 1 {
 		keys = strings.Split(msg.Params[1], ",")
 	}
-	for idx, channelname := range strings.Split(msg.Params[0], ",") {
+	channelnames := strings.Split(msg.Params[0], ",")
+	if i.tooManyTargets(s, reply, channelnames) {
+		return
+	}
+	for idx, channelname := range channelnames {
 		var key string
 		if idx <= len(keys)-1 {
 			key = keys[idx]
@@ -41,14 +52,23 @@ func (i *IRCServer) cmdJoin(s *Session, reply *Replyctx, msg *irc.Message) {
 			})
 			continue
 		}
+		if got, limit := uint64(len(s.Channels)), i.channelsPerUserLimit(); got >= limit && limit > 0 &&
+			!s.Channels[ChanToLower(channelname)] {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.ERR_TOOMANYCHANNELS,
+				Params:  []string{s.Nick, channelname, "You have joined too many channels"},
+			})
+			continue
+		}
 		var modesmsg *irc.Message
 		c, ok := i.channels[ChanToLower(channelname)]
 		if !ok {
 			if got, limit := uint64(len(i.channels)), i.ChannelLimit(); got >= limit && limit > 0 {
 				i.sendUser(s, reply, &irc.Message{
 					Prefix:  i.ServerPrefix,
-					Command: irc.ERR_NOSUCHCHANNEL,
-					Params:  []string{s.Nick, channelname, "No such channel"},
+					Command: irc.ERR_TOOMANYCHANNELS,
+					Params:  []string{s.Nick, channelname, "Cannot create channel, the network reached its channel limit"},
 				})
 				continue
 			}
@@ -56,6 +76,7 @@ func (i *IRCServer) cmdJoin(s *Session, reply *Replyctx, msg *irc.Message) {
 			c = &channel{
 				name:  channelname,
 				nicks: make(map[lcNick]*[maxChanMemberStatus]bool),
+				ts:    s.LastActivity.Unix(),
 			}
 			c.modes['n'] = true
 			c.modes['t'] = true
@@ -72,6 +93,41 @@ func (i *IRCServer) cmdJoin(s *Session, reply *Replyctx, msg *irc.Message) {
 				Params:  []string{s.Nick, c.name, "Cannot join channel (+i)"},
 			})
 			continue
+		} else if c.modes['O'] && !s.Operator {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: errOperOnly,
+				Params:  []string{s.Nick, c.name, "Cannot join channel (+O)"},
+			})
+			continue
+		} else if c.modes['A'] && !s.modes['A'] {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: errAdminOnly,
+				Params:  []string{s.Nick, c.name, "Cannot join channel (+A)"},
+			})
+			continue
+		} else if c.modes['R'] && !s.modes['r'] {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: errNeedReggedNick,
+				Params:  []string{s.Nick, c.name, "Cannot join channel (+R) - you need to be identified with services"},
+			})
+			continue
+		} else if c.modes['k'] && key != c.key && !s.invitedTo[ChanToLower(channelname)] {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.ERR_BADCHANNELKEY,
+				Params:  []string{s.Nick, c.name, "Cannot join channel (+k)"},
+			})
+			continue
+		} else if c.modes['l'] && len(c.nicks) >= c.limit && !s.invitedTo[ChanToLower(channelname)] {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.ERR_CHANNELISFULL,
+				Params:  []string{s.Nick, c.name, "Cannot join channel (+l)"},
+			})
+			continue
 		} else if c.modes['x'] && !s.invitedTo[ChanToLower(channelname)] {
 			if err := i.verifyCaptcha(s, key); err != nil {
 				captchaUrl := i.generateCaptchaURL(s, fmt.Sprintf("join:%d:%s", s.LastActivity.UnixNano(), c.name))
@@ -97,7 +153,7 @@ func (i *IRCServer) cmdJoin(s *Session, reply *Replyctx, msg *irc.Message) {
 			continue
 		}
 		// Invites are only valid once.
-		if c.modes['i'] || c.modes['x'] {
+		if c.modes['i'] || c.modes['k'] || c.modes['l'] || c.modes['x'] {
 			delete(s.invitedTo, ChanToLower(channelname))
 		}
 		if _, ok := c.nicks[NickToLower(s.Nick)]; ok {
@@ -120,7 +176,7 @@ func (i *IRCServer) cmdJoin(s *Session, reply *Replyctx, msg *irc.Message) {
 			i.sendChannel(c, reply, modesmsg)
 		}
 		var prefix string
-		if c.nicks[NickToLower(s.Nick)][chanop] {
+		if hasStatus(c.nicks[NickToLower(s.Nick)], chanop) {
 			prefix = prefix + string('@')
 		}
 		i.sendServices(reply, &irc.Message{
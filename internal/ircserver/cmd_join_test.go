@@ -260,8 +260,8 @@ func TestBanned(t *testing.T) {
 	mustMatchIrcmsgs(t,
 		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +b")),
 		[]*irc.Message{
-			irc.ParseMessage(":robustirc.net 367 sECuRE #test *@127.*"),
-			irc.ParseMessage(":robustirc.net 367 sECuRE #test *@127.0.0.1"),
+			irc.ParseMessage(":robustirc.net 367 sECuRE #test *@127.* sECuRE!blah@robust/0x13b5aa0a2bcfb8ad 1420228218"),
+			irc.ParseMessage(":robustirc.net 367 sECuRE #test *@127.0.0.1 sECuRE!blah@robust/0x13b5aa0a2bcfb8ad 1420228218"),
 			irc.ParseMessage(":robustirc.net 368 sECuRE #test :End of Channel Ban List"),
 		})
 
@@ -278,7 +278,7 @@ func TestBanned(t *testing.T) {
 	mustMatchIrcmsgs(t,
 		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +b")),
 		[]*irc.Message{
-			irc.ParseMessage(":robustirc.net 367 sECuRE #test *@127.0.0.1"),
+			irc.ParseMessage(":robustirc.net 367 sECuRE #test *@127.0.0.1 sECuRE!blah@robust/0x13b5aa0a2bcfb8ad 1420228218"),
 			irc.ParseMessage(":robustirc.net 368 sECuRE #test :End of Channel Ban List"),
 		})
 
@@ -303,8 +303,8 @@ func TestBanned(t *testing.T) {
 	mustMatchIrcmsgs(t,
 		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +b")),
 		[]*irc.Message{
-			irc.ParseMessage(":robustirc.net 367 sECuRE #test *!*foo@robust/0x13b5aa0a2bcfb8ae"),
-			irc.ParseMessage(":robustirc.net 367 sECuRE #test *@127.0.0.1"),
+			irc.ParseMessage(":robustirc.net 367 sECuRE #test *!*foo@robust/0x13b5aa0a2bcfb8ae sECuRE!blah@robust/0x13b5aa0a2bcfb8ad 1420228218"),
+			irc.ParseMessage(":robustirc.net 367 sECuRE #test *@127.0.0.1 sECuRE!blah@robust/0x13b5aa0a2bcfb8ad 1420228218"),
 			irc.ParseMessage(":robustirc.net 368 sECuRE #test :End of Channel Ban List"),
 		})
 
@@ -323,3 +323,172 @@ func TestBanned(t *testing.T) {
 			irc.ParseMessage(":robustirc.net 474 mero #test :Cannot join channel (+b)"),
 		})
 }
+
+func TestJoinRestricted(t *testing.T) {
+	i, ids := stdIRCServer()
+	i.Config.IRC.Operators[0].Admin = true
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("OPER mero foo"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("OPER xeen foo"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +O")),
+		":robustirc.net 481 sECuRE :Permission Denied - You're not an IRC operator")
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #staff"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #admins"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("MODE #staff +O")),
+		":mero!foo@robust/0x13b5aa0a2bcfb8ae MODE #staff +O")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("MODE #admins +A")),
+		":mero!foo@robust/0x13b5aa0a2bcfb8ae MODE #admins +A")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #staff")),
+		":robustirc.net 520 sECuRE #staff :Cannot join channel (+O)")
+
+	if got := i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #staff")); len(got.Messages) == 0 || irc.ParseMessage(got.Messages[0].Data).Command != irc.JOIN {
+		t.Fatalf("operator could not join +O channel: %v", got.Messages)
+	}
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #admins")),
+		":robustirc.net 519 xeen #admins :Cannot join channel (+A)")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("MODE #staff +A")),
+		":robustirc.net 481 xeen :Permission Denied - You're not a server administrator")
+}
+
+func TestJoinKey(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +k a,b")),
+		":robustirc.net 525 sECuRE #test :Key is not well-formed")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +k sesame")),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad MODE #test +k sesame")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test")),
+		":robustirc.net 324 sECuRE #test +knt sesame")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test")),
+		":robustirc.net 475 mero #test :Cannot join channel (+k)")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test wrong")),
+		":robustirc.net 475 mero #test :Cannot join channel (+k)")
+
+	if got := i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test sesame")); len(got.Messages) == 0 || irc.ParseMessage(got.Messages[0].Data).Command != irc.JOIN {
+		t.Fatalf("could not join +k channel with the correct key: %v", got.Messages)
+	}
+
+	// Keys survive snapshots.
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i = NewIRCServer("robustirc.net", time.Now())
+	if _, err := i.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test")),
+		":robustirc.net 475 xeen #test :Cannot join channel (+k)")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test -k")),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad MODE #test -k")
+
+	if got := i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test")); len(got.Messages) == 0 || irc.ParseMessage(got.Messages[0].Data).Command != irc.JOIN {
+		t.Fatalf("could not join channel after removing the key: %v", got.Messages)
+	}
+}
+
+func TestJoinLimit(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+
+	// Invalid limits are ignored, valid modes are still applied.
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +l nope")),
+		[]*irc.Message{})
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +lik 0 sesame")),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad MODE #test +ik sesame")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test -ik+l sesame 02")),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad MODE #test +l-ik 2 sesame")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +k sesame")),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad MODE #test +k sesame")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test")),
+		":robustirc.net 324 sECuRE #test +klnt sesame 2")
+
+	if got := i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test sesame")); len(got.Messages) == 0 || irc.ParseMessage(got.Messages[0].Data).Command != irc.JOIN {
+		t.Fatalf("could not join +l channel below the limit: %v", got.Messages)
+	}
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test sesame")),
+		":robustirc.net 471 xeen #test :Cannot join channel (+l)")
+
+	// Limits survive snapshots.
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i = NewIRCServer("robustirc.net", time.Now())
+	if _, err := i.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test sesame")),
+		":robustirc.net 471 xeen #test :Cannot join channel (+l)")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test -l")),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad MODE #test -l")
+
+	if got := i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test sesame")); len(got.Messages) == 0 || irc.ParseMessage(got.Messages[0].Data).Command != irc.JOIN {
+		t.Fatalf("could not join channel after removing the limit: %v", got.Messages)
+	}
+}
+
+func TestJoinRegisteredOnly(t *testing.T) {
+	i, ids := stdIRCServerWithServices()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +R")),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad MODE #test +R")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test")),
+		":robustirc.net 477 mero #test :Cannot join channel (+R) - you need to be identified with services")
+
+	i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("SVSMODE mero +r"))
+
+	if got := i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test")); len(got.Messages) == 0 || irc.ParseMessage(got.Messages[0].Data).Command != irc.JOIN {
+		t.Fatalf("could not join +R channel after identifying: %v", got.Messages)
+	}
+}
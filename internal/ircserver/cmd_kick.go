@@ -1,6 +1,10 @@
 package ircserver
 
-import "gopkg.in/sorcix/irc.v2"
+import (
+	"strings"
+
+	"gopkg.in/sorcix/irc.v2"
+)
 
 func init() {
 	Commands["KICK"] = &ircCommand{
@@ -31,7 +35,7 @@ func (i *IRCServer) cmdKick(s *Session, reply *Replyctx, msg *irc.Message) {
 		return
 	}
 
-	if !perms[chanop] {
+	if !hasStatus(perms, halfop) {
 		i.sendUser(s, reply, &irc.Message{
 			Prefix:  i.ServerPrefix,
 			Command: irc.ERR_CHANOPRIVSNEEDED,
@@ -40,28 +44,51 @@ func (i *IRCServer) cmdKick(s *Session, reply *Replyctx, msg *irc.Message) {
 		return
 	}
 
-	if _, ok := c.nicks[NickToLower(msg.Params[1])]; !ok {
-		i.sendUser(s, reply, &irc.Message{
-			Prefix:  i.ServerPrefix,
-			Command: irc.ERR_USERNOTINCHANNEL,
-			Params:  []string{s.Nick, msg.Params[1], channelname, "They aren't on that channel"},
-		})
+	nicks := strings.Split(msg.Params[1], ",")
+	if i.tooManyTargets(s, reply, nicks) {
 		return
 	}
 
-	// Must exist since c.nicks contains the nick.
-	session, _ := i.nicks[NickToLower(msg.Params[1])]
+	reason := s.Nick
+	if len(msg.Params) > 2 {
+		reason = msg.Params[2]
+	}
+
+	for _, nick := range nicks {
+		targetPerms, ok := c.nicks[NickToLower(nick)]
+		if !ok {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.ERR_USERNOTINCHANNEL,
+				Params:  []string{s.Nick, nick, channelname, "They aren't on that channel"},
+			})
+			continue
+		}
 
-	i.sendServices(reply,
-		i.sendChannel(c, reply, &irc.Message{
-			Prefix:  &s.ircPrefix,
-			Command: irc.KICK,
-			Params:  []string{msg.Params[0], msg.Params[1], msg.Trailing()},
-		}))
+		// Members can only be kicked by members of at least the same rank,
+		// e.g. halfops cannot kick chanops.
+		if target := highestStatus(targetPerms); target != -1 && !hasStatus(perms, target) {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.ERR_CHANOPRIVSNEEDED,
+				Params:  []string{s.Nick, channelname, "You're not allowed to kick " + nick},
+			})
+			continue
+		}
 
-	// TODO(secure): reduce code duplication with cmdPart()
-	delete(c.nicks, NickToLower(msg.Params[1]))
-	i.maybeDeleteChannelLocked(c)
-	delete(session.Channels, ChanToLower(channelname))
+		// Must exist since c.nicks contains the nick.
+		session, _ := i.nicks[NickToLower(nick)]
+
+		i.sendServices(reply,
+			i.sendChannel(c, reply, &irc.Message{
+				Prefix:  &s.ircPrefix,
+				Command: irc.KICK,
+				Params:  []string{channelname, nick, reason},
+			}))
 
+		// TODO(secure): reduce code duplication with cmdPart()
+		delete(c.nicks, NickToLower(nick))
+		delete(session.Channels, ChanToLower(channelname))
+	}
+	i.maybeDeleteChannelLocked(c)
 }
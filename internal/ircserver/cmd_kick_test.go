@@ -46,3 +46,52 @@ func TestKick(t *testing.T) {
 			irc.ParseMessage(":robustirc.net 366 xeen #TEST :End of /NAMES list."),
 		})
 }
+
+func TestKickMultiple(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test"))
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("KICK #test mero,nobody,xeen")),
+		[]*irc.Message{
+			irc.ParseMessage(":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad KICK #test mero sECuRE"),
+			irc.ParseMessage(":robustirc.net 441 sECuRE nobody #test :They aren't on that channel"),
+			irc.ParseMessage(":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad KICK #test xeen sECuRE"),
+		})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NAMES #test")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 353 sECuRE = #test :@sECuRE"),
+			irc.ParseMessage(":robustirc.net 366 sECuRE #test :End of /NAMES list."),
+		})
+
+	// Kicking yourself out of a channel deletes the channel once it is empty.
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("KICK #test secure :gone")),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad KICK #test secure :gone")
+
+	if got, want := i.NumChannels(), 0; got != want {
+		t.Fatalf("NumChannels() = %d, want %d", got, want)
+	}
+}
+
+func TestKickHalfop(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("MODE #test +h xeen"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("KICK #test mero :bye")),
+		":robustirc.net 482 xeen #test :You're not allowed to kick mero")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("KICK #test sECuRE :bye")),
+		":xeen!baz@robust/0x13b5aa0a2bcfb8af KICK #test sECuRE :bye")
+}
@@ -33,7 +33,7 @@ func (i *IRCServer) cmdKill(s *Session, reply *Replyctx, msg *irc.Message) {
 		return
 	}
 
-	i.deleteSessionLocked(session, reply.msgid)
+	i.deleteSessionLocked(session, reply, "Killed: "+msg.Trailing())
 
 	i.sendServices(reply,
 		i.sendCommonChannels(session, reply, &irc.Message{
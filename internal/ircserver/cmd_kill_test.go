@@ -28,6 +28,7 @@ func TestInterestedInKill(t *testing.T) {
 	mustMatchIrcmsgs(t,
 		replies,
 		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net NOTICE * :*** Notice -- Client exiting: sECuRE (blah@robust/0x13b5aa0a2bcfb8ad) [unknown] {session 0x13b5aa0a2bcfb8ad} (Killed: bleh)"),
 			irc.ParseMessage(":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad QUIT :Killed by mero: bleh"),
 			irc.ParseMessage(":mero!foo@robust/0x13b5aa0a2bcfb8ae KILL sECuRE :ircd!robust/0x13b5aa0a2bcfb8ae!mero (bleh)"),
 			irc.ParseMessage("ERROR :Closing Link: sECuRE[robust/0x13b5aa0a2bcfb8ad] (Killed (mero (bleh)))"),
@@ -36,15 +37,20 @@ func TestInterestedInKill(t *testing.T) {
 	mustMatchInterestedMsgs(t, i,
 		msg, []*robust.Message{msgs[0]},
 		[]robust.Id{ids["secure"], ids["mero"], ids["xeen"]},
-		[]bool{false, false, true})
+		[]bool{false, true, false})
 
 	mustMatchInterestedMsgs(t, i,
 		msg, []*robust.Message{msgs[1]},
 		[]robust.Id{ids["secure"], ids["mero"], ids["xeen"]},
-		[]bool{true, false, false})
+		[]bool{false, false, true})
 
 	mustMatchInterestedMsgs(t, i,
 		msg, []*robust.Message{msgs[2]},
 		[]robust.Id{ids["secure"], ids["mero"], ids["xeen"]},
 		[]bool{true, false, false})
+
+	mustMatchInterestedMsgs(t, i,
+		msg, []*robust.Message{msgs[3]},
+		[]robust.Id{ids["secure"], ids["mero"], ids["xeen"]},
+		[]bool{true, false, false})
 }
@@ -0,0 +1,322 @@
+package ircserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// ErrBanned is returned by CheckBanned when the address of a new session is
+// banned via GLINE or KLINE.
+var ErrBanned = errors.New("You are banned from this network")
+
+// kline is a ban on sessions whose user@host matches a mask, added via KLINE
+// or GLINE. As all RobustIRC servers form one logical IRC server, K-lines and
+// G-lines only differ in the command which added them (kind “K” or “G”).
+type kline struct {
+	kind    string
+	mask    banPattern
+	reason  string
+	setBy   string
+	set     time.Time
+	expires time.Time // zero for permanent bans
+}
+
+func (k *kline) expired(now time.Time) bool {
+	return !k.expires.IsZero() && !now.Before(k.expires)
+}
+
+// matches returns whether |k| applies to |s| at |now|.
+func (k *kline) matches(s *Session, now time.Time) bool {
+	if k.expired(now) || s.Server || s.Id.Reply != 0 {
+		// Services and sessions introduced by services cannot be banned.
+		return false
+	}
+	// Cloaked sessions can still be banned by their real host.
+	return k.mask.re.MatchString(s.Username+"@"+s.ircPrefix.Host) ||
+		k.mask.re.MatchString(s.Username+"@"+s.realHost()) ||
+		(s.RemoteAddr != "" && k.mask.re.MatchString(s.Username+"@"+s.RemoteAddr))
+}
+
+func init() {
+	Commands["KLINE"] = &ircCommand{
+		Func:      (*IRCServer).cmdKline,
+		MinParams: 2,
+	}
+	Commands["UNKLINE"] = &ircCommand{
+		Func:      (*IRCServer).cmdUnkline,
+		MinParams: 1,
+	}
+}
+
+// findKline returns the index of the K-line or G-line for |mask| in
+// i.klines, or -1.
+func (i *IRCServer) findKline(mask string) int {
+	for idx, k := range i.klines {
+		if strings.EqualFold(k.mask.pattern, mask) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// klineFor returns the K-line or G-line which matches |s|, if any.
+func (i *IRCServer) klineFor(s *Session, now time.Time) *kline {
+	for idx := range i.klines {
+		if i.klines[idx].matches(s, now) {
+			return &i.klines[idx]
+		}
+	}
+	return nil
+}
+
+// CheckBanned returns an error wrapping ErrBanned when new sessions from
+// |remoteAddr| are banned at |now|. K-lines and G-lines which restrict the
+// username cannot match yet, they are enforced once the client sent USER.
+func (i *IRCServer) CheckBanned(remoteAddr string, now time.Time) error {
+	if remoteAddr == "" {
+		return nil
+	}
+	if reason := i.Banned(remoteAddr); reason != "" {
+		return fmt.Errorf("%w (%s)", ErrBanned, reason)
+	}
+	i.sessionsMu.RLock()
+	defer i.sessionsMu.RUnlock()
+	for _, k := range i.klines {
+		if !k.expired(now) && k.mask.re.MatchString("@"+remoteAddr) {
+			return fmt.Errorf("%w (%s)", ErrBanned, k.reason)
+		}
+	}
+	return nil
+}
+
+// killKlined disconnects |s| because it matches |k|.
+func (i *IRCServer) killKlined(s *Session, reply *Replyctx, k *kline) {
+	reason := k.kind + "-lined: " + k.reason
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.ERR_YOUREBANNEDCREEP,
+		Params:  []string{s.Nick, "You are banned from this network (" + k.reason + ")"},
+	})
+	i.sendUser(s, reply, &irc.Message{
+		Command: irc.ERROR,
+		Params:  []string{fmt.Sprintf("Closing Link: %s[%s] (%s)", s.Nick, s.ircPrefix.Host, reason)},
+	})
+	if s.loggedIn {
+		i.sendServices(reply,
+			i.sendCommonChannels(s, reply, &irc.Message{
+				Prefix:  &s.ircPrefix,
+				Command: irc.QUIT,
+				Params:  []string{reason},
+			}))
+	}
+	i.deleteSessionLocked(s, reply, reason)
+}
+
+// addKlineLocked adds a K-line or G-line (depending on |kind|) as specified
+// by |params| (“[minutes] user@host :reason”) and disconnects all sessions
+// which match it.
+func (i *IRCServer) addKlineLocked(s *Session, reply *Replyctx, kind string, params []string) {
+	var duration time.Duration
+	if len(params) > 2 {
+		minutes, err := strconv.ParseUint(params[0], 10, 32)
+		if err != nil {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.NOTICE,
+				Params:  []string{s.Nick, fmt.Sprintf("Invalid duration %q, expected minutes", params[0])},
+			})
+			return
+		}
+		duration = time.Duration(minutes) * time.Minute
+		params = params[1:]
+	}
+	mask, reason := params[0], params[1]
+	if idx := strings.LastIndex(mask, "@"); idx < 1 || idx == len(mask)-1 {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.NOTICE,
+			Params:  []string{s.Nick, fmt.Sprintf("Invalid mask %q, expected user@host", mask)},
+		})
+		return
+	}
+	pattern, err := silencePattern(mask)
+	if err != nil {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.NOTICE,
+			Params:  []string{s.Nick, fmt.Sprintf("Invalid mask %q: %v", mask, err)},
+		})
+		return
+	}
+
+	k := kline{
+		kind:   kind,
+		mask:   pattern,
+		reason: reason,
+		setBy:  s.Nick,
+		set:    s.LastActivity,
+	}
+	what := fmt.Sprintf("%s-line", kind)
+	if duration > 0 {
+		k.expires = s.LastActivity.Add(duration)
+		what = fmt.Sprintf("temporary %d min. %s", int(duration/time.Minute), what)
+	}
+	if idx := i.findKline(mask); idx > -1 {
+		i.klines[idx] = k
+	} else {
+		i.klines = append(i.klines, k)
+	}
+
+	i.sendOperators(nil, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.NOTICE,
+		Params:  []string{"*", fmt.Sprintf("*** Notice -- %s added %s for [%s] [%s]", s.Nick, what, mask, reason)},
+	})
+
+	// Sort the sessions so that all servers disconnect them in the same
+	// order.
+	var matching []*Session
+	for _, session := range i.sessions {
+		if session.deleted || session == s || !k.matches(session, s.LastActivity) {
+			continue
+		}
+		matching = append(matching, session)
+	}
+	sort.Slice(matching, func(a, b int) bool {
+		return matching[a].Id.Id < matching[b].Id.Id
+	})
+	for _, session := range matching {
+		i.killKlined(session, reply, &k)
+	}
+}
+
+// removeKlineLocked removes the |kind| (“K” or “G”) line for |mask|.
+func (i *IRCServer) removeKlineLocked(s *Session, reply *Replyctx, kind, mask string) {
+	idx := i.findKline(mask)
+	if idx == -1 || i.klines[idx].kind != kind {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.NOTICE,
+			Params:  []string{s.Nick, fmt.Sprintf("No %s-line for [%s] found", kind, mask)},
+		})
+		return
+	}
+	i.klines = append(i.klines[:idx], i.klines[idx+1:]...)
+	i.sendOperators(nil, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.NOTICE,
+		Params:  []string{"*", fmt.Sprintf("*** Notice -- %s has removed the %s-line for [%s]", s.Nick, kind, mask)},
+	})
+}
+
+func (i *IRCServer) cmdKline(s *Session, reply *Replyctx, msg *irc.Message) {
+	if !s.Operator {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOPRIVILEGES,
+			Params:  []string{s.Nick, "Permission Denied - You're not an IRC operator"},
+		})
+		return
+	}
+	i.addKlineLocked(s, reply, "K", msg.Params)
+}
+
+func (i *IRCServer) cmdUnkline(s *Session, reply *Replyctx, msg *irc.Message) {
+	if !s.Operator {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOPRIVILEGES,
+			Params:  []string{s.Nick, "Permission Denied - You're not an IRC operator"},
+		})
+		return
+	}
+	i.removeKlineLocked(s, reply, "K", msg.Params[0])
+}
+
+// sendStatsKlines sends RPL_STATSKLINE for all K-lines and G-lines
+// (including the addresses banned via GLINE <nick>) to |s|.
+func (i *IRCServer) sendStatsKlines(s *Session, reply *Replyctx) {
+	for _, k := range i.klines {
+		if k.expired(s.LastActivity) {
+			continue
+		}
+		reason := k.reason
+		if !k.expires.IsZero() {
+			reason = fmt.Sprintf("%s (expires %s)", reason, k.expires.UTC().Format("2006-01-02 15:04"))
+		}
+		idx := strings.LastIndex(k.mask.pattern, "@")
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.RPL_STATSKLINE,
+			Params:  []string{s.Nick, k.kind, k.mask.pattern[idx+1:], "*", k.mask.pattern[:idx], reason},
+		})
+	}
+
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	addrs := make([]string, 0, len(i.Config.Banned))
+	for addr := range i.Config.Banned {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.RPL_STATSKLINE,
+			Params:  []string{s.Nick, "G", addr, "*", "*", i.Config.Banned[addr]},
+		})
+	}
+}
+
+// ExpireKlines returns ExpireKline robust.Messages for all K-lines and
+// G-lines which have expired. These messages are then applied to raft, so
+// that the bans are removed from the state and IRC operators are notified.
+// Expired bans are not enforced even before that.
+func (i *IRCServer) ExpireKlines() []*robust.Message {
+	i.sessionsMu.RLock()
+	defer i.sessionsMu.RUnlock()
+
+	var expires []*robust.Message
+	now := time.Now()
+	for _, k := range i.klines {
+		if !k.expired(now) {
+			continue
+		}
+		log.Printf("Expiring %s-line for %q (expired: %v)", k.kind, k.mask.pattern, k.expires)
+		expires = append(expires, &robust.Message{
+			Type: robust.ExpireKline,
+			Data: k.mask.pattern,
+		})
+	}
+	return expires
+}
+
+// ExpireKline removes the expired K-line or G-line whose mask is msg.Data.
+func (i *IRCServer) ExpireKline(msg *robust.Message) *Replyctx {
+	i.sessionsMu.Lock()
+	defer i.sessionsMu.Unlock()
+
+	reply := &Replyctx{msgid: msg.Id.Id, timestamp: msg.Timestamp()}
+	idx := i.findKline(msg.Data)
+	if idx == -1 || !i.klines[idx].expired(msg.Timestamp()) {
+		// Removed or re-added in the meantime.
+		return reply
+	}
+	k := i.klines[idx]
+	i.klines = append(i.klines[:idx], i.klines[idx+1:]...)
+	i.sendOperators(nil, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.NOTICE,
+		Params:  []string{"*", fmt.Sprintf("*** Notice -- Temporary %s-line for [%s] expired", k.kind, k.mask.pattern)},
+	})
+	return reply
+}
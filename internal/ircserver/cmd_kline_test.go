@@ -0,0 +1,132 @@
+package ircserver
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestKline(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("KLINE baz@* :spam")),
+		":robustirc.net 481 sECuRE :Permission Denied - You're not an IRC operator")
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("OPER mero foo"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("KLINE baz :spam")),
+		`:robustirc.net NOTICE mero :Invalid mask "baz", expected user@host`)
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("KLINE BAZ@robust/* :spam")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net NOTICE * :*** Notice -- mero added K-line for [BAZ@robust/*] [spam]"),
+			irc.ParseMessage(":robustirc.net 465 xeen :You are banned from this network (spam)"),
+			irc.ParseMessage("ERROR :Closing Link: xeen[robust/0x13b5aa0a2bcfb8af] (K-lined: spam)"),
+			irc.ParseMessage(":xeen!baz@robust/0x13b5aa0a2bcfb8af QUIT :K-lined: spam"),
+			irc.ParseMessage(":robustirc.net NOTICE * :*** Notice -- Client exiting: xeen (baz@robust/0x13b5aa0a2bcfb8af) [unknown] {session 0x13b5aa0a2bcfb8af} (K-lined: spam)"),
+		})
+	if _, err := i.GetSession(ids["xeen"]); err == nil && !i.sessions[ids["xeen"]].deleted {
+		t.Fatalf("K-lined session xeen was not deleted")
+	}
+
+	// New sessions are disconnected once their user@host is known.
+	id := robust.Id{Id: 1420228218166687920}
+	i.CreateSession(id, "auth-baz", time.Unix(0, int64(id.Id)))
+	i.ProcessMessage(&robust.Message{Session: id}, irc.ParseMessage("NICK baz"))
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: id}, irc.ParseMessage("USER baz 0 * :Baz")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 465 baz :You are banned from this network (spam)"),
+			irc.ParseMessage("ERROR :Closing Link: baz[robust/0x13b5aa0a2bcfb8b0] (K-lined: spam)"),
+		})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("GLINE 60 *@192.0.2.1 :flood")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net NOTICE * :*** Notice -- mero added temporary 60 min. G-line for [*@192.0.2.1] [flood]"),
+		})
+
+	if err := i.CheckBanned("192.0.2.1", i.sessions[ids["mero"]].LastActivity); !errors.Is(err, ErrBanned) {
+		t.Fatalf("CheckBanned(192.0.2.1) = %v, want ErrBanned", err)
+	}
+	if err := i.CheckBanned("192.0.2.2", i.sessions[ids["mero"]].LastActivity); err != nil {
+		t.Fatalf("CheckBanned(192.0.2.2) = %v, want nil", err)
+	}
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("STATS k")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 216 mero K robust/* * BAZ :spam"),
+			irc.ParseMessage(":robustirc.net 216 mero G 192.0.2.1 * * :flood (expires 2015-01-02 20:50)"),
+			irc.ParseMessage(":robustirc.net 219 mero k :End of /STATS report"),
+		})
+
+	// K-lines and G-lines survive snapshots.
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewIRCServer("robustirc.net", time.Now())
+	if _, err := restored.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+
+	mustMatchIrcmsgs(t,
+		restored.ProcessMessage(&robust.Message{Session: ids["secure"], RemoteAddr: "192.0.2.1"}, irc.ParseMessage("PING")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 465 sECuRE :You are banned from this network (flood)"),
+			irc.ParseMessage("ERROR :Closing Link: sECuRE[robust/0x13b5aa0a2bcfb8ad] (G-lined: flood)"),
+			irc.ParseMessage(":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad QUIT :G-lined: flood"),
+			irc.ParseMessage(":robustirc.net NOTICE * :*** Notice -- Client exiting: sECuRE (blah@robust/0x13b5aa0a2bcfb8ad) [192.0.2.1] {session 0x13b5aa0a2bcfb8ad} (G-lined: flood)"),
+		})
+
+	mustMatchIrcmsgs(t,
+		restored.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("UNKLINE baz@robust/*")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net NOTICE * :*** Notice -- mero has removed the K-line for [baz@robust/*]"),
+		})
+	mustMatchIrcmsgs(t,
+		restored.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("UNKLINE *@192.0.2.1")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net NOTICE mero :No K-line for [*@192.0.2.1] found"),
+		})
+}
+
+func TestKlineExpiry(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("OPER mero foo"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("KLINE 1 *@192.0.2.1 :cool down"))
+	set := i.sessions[ids["mero"]].LastActivity
+
+	if got := i.ExpireKlines(); len(got) != 1 || got[0].Type != robust.ExpireKline || got[0].Data != "*@192.0.2.1" {
+		t.Fatalf("ExpireKlines() = %v, want one ExpireKline message", got)
+	}
+
+	// Applying the message before the K-line expired (e.g. because it was
+	// re-added in the meantime) does not remove it.
+	early := &robust.Message{Id: robust.Id{Id: uint64(set.Add(30 * time.Second).UnixNano())}, Type: robust.ExpireKline, Data: "*@192.0.2.1"}
+	mustMatchIrcmsgs(t, i.ExpireKline(early), []*irc.Message{})
+	if err := i.CheckBanned("192.0.2.1", set.Add(30*time.Second)); err == nil {
+		t.Fatalf("CheckBanned(192.0.2.1) = nil, want ErrBanned")
+	}
+	// Expired K-lines are not enforced, even before they are removed.
+	if err := i.CheckBanned("192.0.2.1", set.Add(time.Minute)); err != nil {
+		t.Fatalf("CheckBanned(192.0.2.1) = %v, want nil", err)
+	}
+
+	expire := &robust.Message{Id: robust.Id{Id: uint64(set.Add(2 * time.Minute).UnixNano())}, Type: robust.ExpireKline, Data: "*@192.0.2.1"}
+	mustMatchIrcmsgs(t, i.ExpireKline(expire), []*irc.Message{
+		irc.ParseMessage(":robustirc.net NOTICE * :*** Notice -- Temporary K-line for [*@192.0.2.1] expired"),
+	})
+	if got := i.ExpireKlines(); len(got) != 0 {
+		t.Fatalf("ExpireKlines() = %v, want none", got)
+	}
+}
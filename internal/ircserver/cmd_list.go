@@ -10,14 +10,20 @@ import (
 
 func init() {
 	Commands["LIST"] = &ircCommand{
-		Func: (*IRCServer).cmdList,
+		Func:     (*IRCServer).cmdList,
+		ReadOnly: true,
 	}
 }
 
 func (i *IRCServer) cmdList(s *Session, reply *Replyctx, msg *irc.Message) {
 	channels := make([]string, 0, len(i.channels))
-	if len(msg.Params) > 0 {
-		for _, channel := range strings.Split(msg.Params[0], ",") {
+	var next string
+	if len(msg.Params) > 0 && msg.Params[0] != "*" {
+		targets := strings.Split(msg.Params[0], ",")
+		if i.tooManyTargets(s, reply, targets) {
+			return
+		}
+		for _, channel := range targets {
 			channelname := ChanToLower(strings.TrimSpace(channel))
 			if _, ok := i.channels[channelname]; ok {
 				channels = append(channels, string(channelname))
@@ -28,10 +34,11 @@ func (i *IRCServer) cmdList(s *Session, reply *Replyctx, msg *irc.Message) {
 			channels = append(channels, string(channel))
 		}
 		sort.Strings(channels)
+		channels, next = paginate(channels, continuationToken(msg.Params), i.replyPageSize())
 	}
 	for _, channel := range channels {
 		c := i.channels[lcChan(channel)]
-		if c.modes['s'] && !s.Operator && !s.Channels[lcChan(channel)] {
+		if !c.visibleTo(s) {
 			continue
 		}
 		i.sendUser(s, reply, &irc.Message{
@@ -41,6 +48,7 @@ func (i *IRCServer) cmdList(s *Session, reply *Replyctx, msg *irc.Message) {
 		})
 	}
 
+	i.sendMoreResults(s, reply, irc.LIST, next)
 	i.sendUser(s, reply, &irc.Message{
 		Prefix:  i.ServerPrefix,
 		Command: irc.RPL_LISTEND,
@@ -0,0 +1,58 @@
+package ircserver
+
+import (
+	"fmt"
+	"strconv"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func init() {
+	Commands["LUSERS"] = &ircCommand{
+		Func:     (*IRCServer).cmdLusers,
+		ReadOnly: true,
+	}
+}
+
+func (i *IRCServer) cmdLusers(s *Session, reply *Replyctx, msg *irc.Message) {
+	var users, invisible, operators int
+	for _, session := range i.nicks {
+		if session.Server {
+			continue
+		}
+		users++
+		if session.modes['i'] {
+			invisible++
+		}
+		if session.Operator {
+			operators++
+		}
+	}
+	// The network appears as a single server, services as additional ones.
+	servers := len(i.serverSessions)
+
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.RPL_LUSERCLIENT,
+		Params:  []string{s.Nick, fmt.Sprintf("There are %d users and %d invisible on %d servers", users-invisible, invisible, servers+1)},
+	})
+	if operators > 0 {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.RPL_LUSEROP,
+			Params:  []string{s.Nick, strconv.Itoa(operators), "operator(s) online"},
+		})
+	}
+	if len(i.channels) > 0 {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.RPL_LUSERCHANNELS,
+			Params:  []string{s.Nick, strconv.Itoa(len(i.channels)), "channels formed"},
+		})
+	}
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.RPL_LUSERME,
+		Params:  []string{s.Nick, fmt.Sprintf("I have %d clients and %d servers", users, servers)},
+	})
+}
@@ -0,0 +1,26 @@
+package ircserver
+
+import (
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestLusers(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("OPER mero foo"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("MODE xeen +i"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("LUSERS")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 251 sECuRE :There are 2 users and 1 invisible on 1 servers"),
+			irc.ParseMessage(":robustirc.net 252 sECuRE 1 :operator(s) online"),
+			irc.ParseMessage(":robustirc.net 254 sECuRE 1 :channels formed"),
+			irc.ParseMessage(":robustirc.net 255 sECuRE :I have 3 clients and 0 servers"),
+		})
+}
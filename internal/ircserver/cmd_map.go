@@ -0,0 +1,105 @@
+package ircserver
+
+import (
+	"fmt"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// Numerics for MAP, as used by most IRC servers (not part of RFC2812).
+const (
+	rplMap    = "015"
+	rplMapEnd = "017"
+)
+
+func init() {
+	Commands["MAP"] = &ircCommand{
+		Func:     (*IRCServer).cmdMap,
+		ReadOnly: true,
+	}
+	Commands["LINKS"] = &ircCommand{
+		Func:     (*IRCServer).cmdLinks,
+		ReadOnly: true,
+	}
+}
+
+// peers returns the raft peer set if |reply| is answered locally, nil
+// otherwise: the peer set is not part of the (replicated) state.
+func (reply *Replyctx) peers() []PeerInfo {
+	if reply.nodeInfo == nil {
+		return nil
+	}
+	return reply.nodeInfo.Peers
+}
+
+func peerDescription(peer PeerInfo) string {
+	if peer.Self {
+		return fmt.Sprintf("%s (this node)", peer.State)
+	}
+	return peer.State
+}
+
+func (i *IRCServer) cmdMap(s *Session, reply *Replyctx, msg *irc.Message) {
+	if !s.Operator {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOPRIVILEGES,
+			Params:  []string{s.Nick, "Permission Denied - You're not an IRC operator"},
+		})
+		return
+	}
+
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: rplMap,
+		Params:  []string{s.Nick, i.ServerPrefix.Name},
+	})
+	peers := reply.peers()
+	if peers == nil {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: rplMap,
+			Params:  []string{s.Nick, "`- peer set unavailable"},
+		})
+	}
+	for idx, peer := range peers {
+		branch := "|-"
+		if idx == len(peers)-1 {
+			branch = "`-"
+		}
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: rplMap,
+			Params:  []string{s.Nick, fmt.Sprintf("%s %s [%s]", branch, peer.Addr, peerDescription(peer))},
+		})
+	}
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: rplMapEnd,
+		Params:  []string{s.Nick, "End of /MAP"},
+	})
+}
+
+func (i *IRCServer) cmdLinks(s *Session, reply *Replyctx, msg *irc.Message) {
+	if !s.Operator {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOPRIVILEGES,
+			Params:  []string{s.Nick, "Permission Denied - You're not an IRC operator"},
+		})
+		return
+	}
+
+	for _, peer := range reply.peers() {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.RPL_LINKS,
+			Params:  []string{s.Nick, peer.Addr, i.ServerPrefix.Name, "1 " + peerDescription(peer)},
+		})
+	}
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.RPL_ENDOFLINKS,
+		Params:  []string{s.Nick, "*", "End of /LINKS list"},
+	})
+}
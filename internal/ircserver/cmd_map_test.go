@@ -0,0 +1,58 @@
+package ircserver
+
+import (
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestMapLinks(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MAP")),
+		":robustirc.net 481 sECuRE :Permission Denied - You're not an IRC operator")
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("OPER mero foo"))
+
+	// Through raft, the peer set is not available.
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("MAP")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 015 mero robustirc.net"),
+			irc.ParseMessage(":robustirc.net 015 mero :`- peer set unavailable"),
+			irc.ParseMessage(":robustirc.net 017 mero :End of /MAP"),
+		})
+
+	info := &NodeInfo{
+		Peers: []PeerInfo{
+			{Addr: "node1:8001", State: "leader"},
+			{Addr: "node2:8001", State: "follower", Self: true},
+			{Addr: "node3:8001", State: "nonvoter"},
+		},
+	}
+	got, err := i.ProcessQueryWithNodeInfo(ids["mero"], irc.ParseMessage("MAP"), info)
+	if err != nil {
+		t.Fatalf("ProcessQueryWithNodeInfo(MAP): %v", err)
+	}
+	mustMatchIrcmsgs(t, got, []*irc.Message{
+		irc.ParseMessage(":robustirc.net 015 mero robustirc.net"),
+		irc.ParseMessage(":robustirc.net 015 mero :|- node1:8001 [leader]"),
+		irc.ParseMessage(":robustirc.net 015 mero :|- node2:8001 [follower (this node)]"),
+		irc.ParseMessage(":robustirc.net 015 mero :`- node3:8001 [nonvoter]"),
+		irc.ParseMessage(":robustirc.net 017 mero :End of /MAP"),
+	})
+
+	got, err = i.ProcessQueryWithNodeInfo(ids["mero"], irc.ParseMessage("LINKS"), info)
+	if err != nil {
+		t.Fatalf("ProcessQueryWithNodeInfo(LINKS): %v", err)
+	}
+	mustMatchIrcmsgs(t, got, []*irc.Message{
+		irc.ParseMessage(":robustirc.net 364 mero node1:8001 robustirc.net :1 leader"),
+		irc.ParseMessage(":robustirc.net 364 mero node2:8001 robustirc.net :1 follower (this node)"),
+		irc.ParseMessage(":robustirc.net 364 mero node3:8001 robustirc.net :1 nonvoter"),
+		irc.ParseMessage(":robustirc.net 365 mero * :End of /LINKS list"),
+	})
+}
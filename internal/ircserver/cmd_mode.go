@@ -6,12 +6,16 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/robustirc/robustirc/internal/robust"
 
 	"gopkg.in/sorcix/irc.v2"
 )
 
+// rplHostHidden is sent when user mode +x changes a session’s displayed host.
+const rplHostHidden = "396"
+
 func init() {
 	Commands["MODE"] = &ircCommand{
 		Func:      (*IRCServer).cmdMode,
@@ -38,14 +42,28 @@ func (i *IRCServer) resolveSessionToRemoteAddrLocked(pattern string) string {
 	return pattern[:idx] + "@" + s.RemoteAddr
 }
 
-func ban(c *channel, add bool, banmask, pattern string) error {
+// ban adds (|add|) or removes the ban |banmask| (matched using the regular
+// expression |pattern|) to the bans of |c|. |setBy| and |set| are recorded
+// for RPL_BANLIST.
+func ban(c *channel, add bool, banmask, pattern, setBy string, set time.Time) error {
 	re, err := regexp.Compile(pattern)
 	if err != nil {
 		return err
 	}
 
 	if add {
-		c.bans = append(c.bans, banPattern{re: re, pattern: banmask})
+		for _, b := range c.bans {
+			// Keep the original setter and timestamp of existing bans.
+			if b.pattern == banmask && b.re.String() == re.String() {
+				return nil
+			}
+		}
+		c.bans = append(c.bans, banPattern{
+			re:      re,
+			pattern: banmask,
+			setBy:   setBy,
+			set:     set,
+		})
 		return nil
 	}
 	// remove ban
@@ -60,12 +78,12 @@ func ban(c *channel, add bool, banmask, pattern string) error {
 	return nil
 }
 
-func banBoth(c *channel, add bool, banmask, pattern, patternAddr string) error {
-	if err := ban(c, add, banmask, pattern); err != nil {
+func banBoth(c *channel, add bool, banmask, pattern, patternAddr, setBy string, set time.Time) error {
+	if err := ban(c, add, banmask, pattern, setBy, set); err != nil {
 		return err
 	}
 	if patternAddr != pattern {
-		return ban(c, add, banmask, patternAddr)
+		return ban(c, add, banmask, patternAddr, setBy, set)
 	}
 	return nil
 }
@@ -76,32 +94,36 @@ func (i *IRCServer) cmdMode(s *Session, reply *Replyctx, msg *irc.Message) {
 	if s.Channels[ChanToLower(channelname)] {
 		// Channel must exist, the user is in it.
 		c := i.channels[ChanToLower(channelname)]
-		modes := normalizeModes(msg)
+		modes := channelModeTable.normalize(msg)
 		queryOnly := true
 
 		if len(modes) == 0 {
-			modestr := "+"
-			for mode := 'A'; mode < 'z'; mode++ {
-				if c.modes[mode] {
-					modestr += string(mode)
-				}
-			}
 			i.sendUser(s, reply, &irc.Message{
 				Prefix:  i.ServerPrefix,
 				Command: irc.RPL_CHANNELMODEIS,
-				Params:  []string{s.Nick, channelname, modestr},
+				Params:  append([]string{s.Nick, channelname}, channelModeParams(c)...),
 			})
 			return
 		}
 
-		isChanOp := c.nicks[NickToLower(s.Nick)][chanop] || s.Operator
+		// Permissions are checked against the statuses the user had before
+		// this MODE command, so that e.g. “-o+o self other” works.
+		var perms *[maxChanMemberStatus]bool
+		if current, ok := c.nicks[NickToLower(s.Nick)]; ok {
+			before := *current
+			perms = &before
+		}
+
+		// applied contains the modes which were actually changed, with
+		// normalized parameters, to be announced to the channel.
+		var applied []modeCmd
 
 		for _, mode := range modes {
 			char := mode.Mode[1]
 			if mode.Mode != "+b" || mode.Param != "" {
 				// Non-query modes
 				queryOnly = false
-				if !isChanOp {
+				if !s.Operator && !hasStatus(perms, requiredStatus(rune(char))) {
 					i.sendUser(s, reply, &irc.Message{
 						Prefix:  i.ServerPrefix,
 						Command: irc.ERR_CHANOPRIVSNEEDED,
@@ -111,7 +133,29 @@ func (i *IRCServer) cmdMode(s *Session, reply *Replyctx, msg *irc.Message) {
 				}
 				newvalue := (mode.Mode[0] == '+')
 				switch char {
-				case 't', 's', 'i', 'n':
+				case 't', 's', 'i', 'n', 'R':
+					c.modes[char] = newvalue
+
+				case 'O', 'A', 'P':
+					// Restricted-entry modes can only be set by those who
+					// are allowed to join the channel afterwards. Persistent
+					// channels are reserved for IRC operators.
+					if (char == 'O' || char == 'P') && !s.Operator {
+						i.sendUser(s, reply, &irc.Message{
+							Prefix:  i.ServerPrefix,
+							Command: irc.ERR_NOPRIVILEGES,
+							Params:  []string{s.Nick, "Permission Denied - You're not an IRC operator"},
+						})
+						return
+					}
+					if char == 'A' && !s.modes['A'] {
+						i.sendUser(s, reply, &irc.Message{
+							Prefix:  i.ServerPrefix,
+							Command: irc.ERR_NOPRIVILEGES,
+							Params:  []string{s.Nick, "Permission Denied - You're not a server administrator"},
+						})
+						return
+					}
 					c.modes[char] = newvalue
 
 				case 'x':
@@ -123,24 +167,43 @@ func (i *IRCServer) cmdMode(s *Session, reply *Replyctx, msg *irc.Message) {
 							Command: irc.NOTICE,
 							Params:  []string{s.Nick, "Cannot set mode +x, no CaptchaURL/CaptchaHMACSecret configured"},
 						})
+						continue
 					}
 
-				case 'o':
+				case 'k':
+					if newvalue && !validChannelKey(mode.Param) {
+						i.sendUser(s, reply, &irc.Message{
+							Prefix:  i.ServerPrefix,
+							Command: errInvalidKey,
+							Params:  []string{s.Nick, channelname, "Key is not well-formed"},
+						})
+						continue
+					}
+					setChannelKey(c, newvalue, mode.Param)
+
+				case 'l':
+					param, ok := setChannelLimit(c, newvalue, mode.Param)
+					if !ok {
+						// Like most IRC servers, silently ignore invalid limits.
+						continue
+					}
+					mode.Param = param
+
+				case 'q', 'a', 'o', 'h', 'v':
+					status, _ := prefixModeStatus(rune(char))
 					nick := mode.Param
-					perms, ok := c.nicks[NickToLower(nick)]
+					targetPerms, ok := c.nicks[NickToLower(nick)]
 					if !ok {
 						i.sendUser(s, reply, &irc.Message{
 							Prefix:  i.ServerPrefix,
 							Command: irc.ERR_USERNOTINCHANNEL,
 							Params:  []string{s.Nick, nick, channelname, "They aren't on that channel"},
 						})
-					} else {
-						// If the user already is a chanop, silently do
-						// nothing (like UnrealIRCd).
-						if perms[chanop] != newvalue {
-							c.nicks[NickToLower(nick)][chanop] = newvalue
-						}
+						continue
 					}
+					// If the user already has the status, silently do
+					// nothing (like UnrealIRCd).
+					targetPerms[status] = newvalue
 
 				case 'b':
 					// The only supported repetition operator is “*”, which will
@@ -149,14 +212,13 @@ func (i *IRCServer) cmdMode(s *Session, reply *Replyctx, msg *irc.Message) {
 					pattern = strings.Replace(pattern, "\\*", ".*", -1)
 					patternAddr := i.resolveSessionToRemoteAddrLocked(pattern)
 
-					if err := banBoth(c, newvalue, mode.Param, pattern, patternAddr); err != nil {
+					if err := banBoth(c, newvalue, mode.Param, pattern, patternAddr, s.ircPrefix.String(), s.LastActivity); err != nil {
 						i.sendUser(s, reply, &irc.Message{
 							Prefix:  i.ServerPrefix,
 							Command: irc.ERR_UNKNOWNMODE,
 							Params:  []string{s.Nick, "+b", fmt.Sprintf("%q is not a valid regexp: %v", mode.Param, err)},
 						})
-					} else {
-						queryOnly = false
+						continue
 					}
 
 				default:
@@ -165,14 +227,20 @@ func (i *IRCServer) cmdMode(s *Session, reply *Replyctx, msg *irc.Message) {
 						Command: irc.ERR_UNKNOWNMODE,
 						Params:  []string{s.Nick, string(char), "is unknown mode char to me"},
 					})
+					continue
 				}
+				applied = append(applied, mode)
 			} else {
 				// Query modes
 				switch char {
 				case 'b':
-					seen := make(map[string]bool)
+					// Bans on session references are stored twice (see
+					// banBoth), but listed once.
+					seen := make(map[string]banPattern)
 					for _, b := range c.bans {
-						seen[b.pattern] = true
+						if _, ok := seen[b.pattern]; !ok {
+							seen[b.pattern] = b
+						}
 					}
 					patterns := make([]string, 0, len(seen))
 					for pattern := range seen {
@@ -180,10 +248,19 @@ func (i *IRCServer) cmdMode(s *Session, reply *Replyctx, msg *irc.Message) {
 					}
 					sort.Strings(patterns)
 					for _, pattern := range patterns {
+						b := seen[pattern]
+						// Bans from older snapshots lack setter and timestamp.
+						setBy, set := b.setBy, "0"
+						if setBy == "" {
+							setBy = i.ServerPrefix.Name
+						}
+						if !b.set.IsZero() {
+							set = strconv.FormatInt(b.set.Unix(), 10)
+						}
 						i.sendUser(s, reply, &irc.Message{
 							Prefix:  i.ServerPrefix,
 							Command: irc.RPL_BANLIST,
-							Params:  []string{s.Nick, channelname, pattern},
+							Params:  []string{s.Nick, channelname, pattern, setBy, set},
 						})
 					}
 					i.sendUser(s, reply, &irc.Message{
@@ -202,7 +279,7 @@ func (i *IRCServer) cmdMode(s *Session, reply *Replyctx, msg *irc.Message) {
 			}
 		}
 
-		if queryOnly {
+		if queryOnly || len(applied) == 0 {
 			return
 		}
 
@@ -214,7 +291,7 @@ func (i *IRCServer) cmdMode(s *Session, reply *Replyctx, msg *irc.Message) {
 			i.sendChannel(c, reply, &irc.Message{
 				Prefix:  &s.ircPrefix,
 				Command: irc.MODE,
-				Params:  append([]string{channelname}, modeCmds(modes).IRCParams()...),
+				Params:  append([]string{channelname}, modeCmds(applied).IRCParams()...),
 			}))
 		return
 	}
@@ -230,7 +307,7 @@ func (i *IRCServer) cmdMode(s *Session, reply *Replyctx, msg *irc.Message) {
 			})
 			return
 		}
-		modes := normalizeModes(msg)
+		modes := userModeTable.normalize(msg)
 
 		if len(modes) == 0 {
 			modestr := "+"
@@ -247,25 +324,67 @@ func (i *IRCServer) cmdMode(s *Session, reply *Replyctx, msg *irc.Message) {
 				}))
 
 		} else {
+			host := session.ircPrefix.Host
+			applied := make([]modeCmd, 0, len(modes))
 			for _, mode := range modes {
 				char := mode.Mode[1]
 				newvalue := (mode.Mode[0] == '+')
 				switch char {
 				case 'i':
 					session.modes[char] = newvalue
+
+				case 'B':
+					// +B raises the session priority (see
+					// SessionPriority), so only operators (and
+					// services, via SVSMODE) may set it.
+					if newvalue && !s.Operator {
+						i.sendUser(s, reply, &irc.Message{
+							Prefix:  i.ServerPrefix,
+							Command: irc.ERR_NOPRIVILEGES,
+							Params:  []string{s.Nick, "Permission Denied - You're not an IRC operator"},
+						})
+						continue
+					}
+					session.modes[char] = newvalue
+
+				case 'x':
+					if newvalue {
+						key := i.cloakKey()
+						if len(key) == 0 {
+							i.sendUser(s, reply, &irc.Message{
+								Prefix:  i.ServerPrefix,
+								Command: irc.NOTICE,
+								Params:  []string{s.Nick, "Cannot set mode +x, no CloakKey configured"},
+							})
+							continue
+						}
+						session.cloakedHost = cloakHost(key, session.realHost())
+					}
+					session.modes[char] = newvalue
+					session.updateIrcPrefix()
 				}
+				applied = append(applied, mode)
 			}
 
-			// It would be nice to send the confirmation to s as well
-			// (in case s != session), but at least irssi gets
-			// confused and applies the mode change to the current
-			// user, not the destination user.
-			i.sendServices(reply,
+			if len(applied) > 0 {
+				// It would be nice to send the confirmation to s as well
+				// (in case s != session), but at least irssi gets
+				// confused and applies the mode change to the current
+				// user, not the destination user.
+				i.sendServices(reply,
+					i.sendUser(session, reply, &irc.Message{
+						Prefix:  &s.ircPrefix,
+						Command: irc.MODE,
+						Params:  []string{session.Nick, modeCmds(applied).IRCParams()[0]},
+					}))
+			}
+			if session.ircPrefix.Host != host {
 				i.sendUser(session, reply, &irc.Message{
-					Prefix:  &s.ircPrefix,
-					Command: irc.MODE,
-					Params:  []string{session.Nick, modeCmds(modes).IRCParams()[0]},
-				}))
+					Prefix:  i.ServerPrefix,
+					Command: rplHostHidden,
+					Params:  []string{session.Nick, session.ircPrefix.Host, "is now your displayed host"},
+				})
+			}
 		}
 		return
 	}
@@ -2,6 +2,7 @@ package ircserver
 
 import (
 	"testing"
+	"time"
 
 	"github.com/robustirc/robustirc/internal/robust"
 
@@ -97,18 +98,96 @@ func TestUserMode(t *testing.T) {
 		":xeen!baz@robust/0x13b5aa0a2bcfb8af MODE sECuRE :-i")
 }
 
+func TestUserModeCloak(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE sECuRE +x")),
+		":robustirc.net NOTICE sECuRE :Cannot set mode +x, no CloakKey configured")
+
+	i.Config.CloakKey = []byte("secret")
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE sECuRE +x")),
+		[]*irc.Message{
+			irc.ParseMessage(":sECuRE!blah@cloak/99aefb4232f83854 MODE sECuRE :+x"),
+			irc.ParseMessage(":robustirc.net 396 sECuRE cloak/99aefb4232f83854 :is now your displayed host"),
+		})
+
+	// The cloak is kept across nickname changes and snapshots.
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NICK secure2"))
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i = NewIRCServer("robustirc.net", time.Now())
+	if _, err := i.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("PRIVMSG mero :hi")),
+		":secure2!blah@cloak/99aefb4232f83854 PRIVMSG mero :hi")
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE secure2 -x")),
+		[]*irc.Message{
+			irc.ParseMessage(":secure2!blah@robust/0x13b5aa0a2bcfb8ad MODE secure2 :-x"),
+			irc.ParseMessage(":robustirc.net 396 secure2 robust/0x13b5aa0a2bcfb8ad :is now your displayed host"),
+		})
+}
+
 func TestBans(t *testing.T) {
 	i, ids := stdIRCServer()
 
 	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
 
-	// Bans are not yet implemented.
 	mustMatchMsg(t,
 		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test b")),
 		":robustirc.net 368 sECuRE #test :End of Channel Ban List")
 	mustMatchMsg(t,
 		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +b")),
 		":robustirc.net 368 sECuRE #test :End of Channel Ban List")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +b mero!*@*")),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad MODE #test +b mero!*@*")
+
+	// Setting an existing ban again keeps its original setter.
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("OPER xeen foo"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test"))
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("MODE #test +b mero!*@*")),
+		":xeen!baz@robust/0x13b5aa0a2bcfb8af MODE #test +b mero!*@*")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test")),
+		":robustirc.net 474 mero #test :Cannot join channel (+b)")
+
+	// Bans survive snapshots.
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i = NewIRCServer("robustirc.net", time.Now())
+	if _, err := i.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test b")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 367 sECuRE #test mero!*@* sECuRE!blah@robust/0x13b5aa0a2bcfb8ad 1420228218"),
+			irc.ParseMessage(":robustirc.net 368 sECuRE #test :End of Channel Ban List"),
+		})
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test")),
+		":robustirc.net 474 mero #test :Cannot join channel (+b)")
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test -b mero!*@*"))
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test b")),
+		":robustirc.net 368 sECuRE #test :End of Channel Ban List")
 }
 
 func TestChannelMemberStatus(t *testing.T) {
@@ -173,6 +252,48 @@ func TestChannelMemberStatus(t *testing.T) {
 		":xeen!baz@robust/0x13b5aa0a2bcfb8af MODE #test +o xeen")
 }
 
+func TestChannelPrefixModes(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("MODE #test +h xeen")),
+		":mero!foo@robust/0x13b5aa0a2bcfb8ae MODE #test +h xeen")
+
+	// Halfops can voice, but not op.
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("MODE #test +v sECuRE")),
+		":xeen!baz@robust/0x13b5aa0a2bcfb8af MODE #test +v sECuRE")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("MODE #test +o xeen")),
+		":robustirc.net 482 xeen #test :You're not channel operator")
+
+	// Only channel owners can make others owners or admins.
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("MODE #test +a mero")),
+		":robustirc.net 482 mero #test :You're not channel operator")
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("OPER mero foo"))
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +q mero")),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad MODE #test +q mero")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("MODE #test +a-o xeen mero")),
+		":mero!foo@robust/0x13b5aa0a2bcfb8ae MODE #test +a-o xeen mero")
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NAMES #test")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 353 sECuRE = #test :&xeen +sECuRE ~mero"),
+			irc.ParseMessage(":robustirc.net 366 sECuRE #test :End of /NAMES list."),
+		})
+}
+
 func TestInvisible(t *testing.T) {
 	i, ids := stdIRCServer()
 
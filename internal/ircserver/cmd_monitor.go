@@ -0,0 +1,184 @@
+package ircserver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// Numerics for MONITOR, see https://ircv3.net/specs/extensions/monitor
+const (
+	rplMonOnline    = "730"
+	rplMonOffline   = "731"
+	rplMonList      = "732"
+	rplEndOfMonList = "733"
+	errMonListFull  = "734"
+)
+
+// maxMonitor is the maximum number of entries in a session’s monitor list.
+const maxMonitor = 100
+
+func init() {
+	Commands["MONITOR"] = &ircCommand{
+		Func:      (*IRCServer).cmdMonitor,
+		MinParams: 1,
+	}
+}
+
+func (i *IRCServer) addMonitorLocked(s *Session, nick lcNick) {
+	sessions, ok := i.monitors[nick]
+	if !ok {
+		sessions = make(map[robust.Id]bool)
+		i.monitors[nick] = sessions
+	}
+	sessions[s.Id] = true
+}
+
+func (i *IRCServer) removeMonitorLocked(s *Session, nick lcNick) {
+	delete(i.monitors[nick], s.Id)
+	if len(i.monitors[nick]) == 0 {
+		delete(i.monitors, nick)
+	}
+}
+
+// monitorStatus sends RPL_MONONLINE and/or RPL_MONOFFLINE to |s| for
+// |targets|.
+func (i *IRCServer) monitorStatus(s *Session, reply *Replyctx, targets []string) {
+	var online, offline []string
+	for _, target := range targets {
+		if session, ok := i.nicks[NickToLower(target)]; ok {
+			online = append(online, session.ircPrefix.String())
+		} else {
+			offline = append(offline, target)
+		}
+	}
+	if len(online) > 0 {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: rplMonOnline,
+			Params:  []string{s.Nick, strings.Join(online, ",")},
+		})
+	}
+	if len(offline) > 0 {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: rplMonOffline,
+			Params:  []string{s.Nick, strings.Join(offline, ",")},
+		})
+	}
+}
+
+// sendMonitors sends RPL_MONONLINE (if |session| is non-nil) or
+// RPL_MONOFFLINE for |nick| to all sessions which monitor |nick|.
+func (i *IRCServer) sendMonitors(nick string, session *Session, reply *Replyctx) {
+	watchers := i.monitors[NickToLower(nick)]
+	if len(watchers) == 0 {
+		return
+	}
+	// Sort the watchers so that all servers generate the replies in the
+	// same order.
+	ids := make([]robust.Id, 0, len(watchers))
+	for id := range watchers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		if ids[a].Id != ids[b].Id {
+			return ids[a].Id < ids[b].Id
+		}
+		return ids[a].Reply < ids[b].Reply
+	})
+	for _, id := range ids {
+		watcher, ok := i.sessions[id]
+		if !ok || watcher == session {
+			continue
+		}
+		msg := &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: rplMonOffline,
+			Params:  []string{watcher.Nick, nick},
+		}
+		if session != nil {
+			msg.Command = rplMonOnline
+			msg.Params = []string{watcher.Nick, session.ircPrefix.String()}
+		}
+		i.sendUser(watcher, reply, msg)
+	}
+}
+
+func (i *IRCServer) cmdMonitor(s *Session, reply *Replyctx, msg *irc.Message) {
+	var targets []string
+	if len(msg.Params) > 1 {
+		for _, target := range strings.Split(msg.Params[1], ",") {
+			if target != "" {
+				targets = append(targets, target)
+			}
+		}
+	}
+
+	switch msg.Params[0] {
+	case "+":
+		var added []string
+		for idx, target := range targets {
+			lc := NickToLower(target)
+			if _, ok := s.monitor[lc]; ok {
+				continue
+			}
+			if len(s.monitor) >= maxMonitor {
+				i.sendUser(s, reply, &irc.Message{
+					Prefix:  i.ServerPrefix,
+					Command: errMonListFull,
+					Params:  []string{s.Nick, fmt.Sprintf("%d", maxMonitor), strings.Join(targets[idx:], ","), "Monitor list is full."},
+				})
+				break
+			}
+			s.monitor[lc] = target
+			i.addMonitorLocked(s, lc)
+			added = append(added, target)
+		}
+		i.monitorStatus(s, reply, added)
+
+	case "-":
+		for _, target := range targets {
+			lc := NickToLower(target)
+			delete(s.monitor, lc)
+			i.removeMonitorLocked(s, lc)
+		}
+
+	case "C", "c":
+		for lc := range s.monitor {
+			i.removeMonitorLocked(s, lc)
+		}
+		s.monitor = make(map[lcNick]string)
+
+	case "L", "l":
+		nicks := make([]string, 0, len(s.monitor))
+		for _, nick := range s.monitor {
+			nicks = append(nicks, nick)
+		}
+		sort.Strings(nicks)
+		if len(nicks) > 0 {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: rplMonList,
+				Params:  []string{s.Nick, strings.Join(nicks, ",")},
+			})
+		}
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: rplEndOfMonList,
+			Params:  []string{s.Nick, "End of MONITOR list"},
+		})
+
+	case "S", "s":
+		nicks := make([]string, 0, len(s.monitor))
+		for _, nick := range s.monitor {
+			nicks = append(nicks, nick)
+		}
+		sort.Strings(nicks)
+		i.monitorStatus(s, reply, nicks)
+	}
+}
@@ -0,0 +1,94 @@
+package ircserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// monitorReplies returns a copy of |reply| which only contains MONITOR
+// numerics.
+func monitorReplies(reply *Replyctx) *Replyctx {
+	filtered := &Replyctx{msgid: reply.msgid}
+	for _, msg := range reply.Messages {
+		parsed := irc.ParseMessage(msg.Data)
+		if parsed.Command == rplMonOnline || parsed.Command == rplMonOffline {
+			filtered.Messages = append(filtered.Messages, msg)
+		}
+	}
+	return filtered
+}
+
+func TestMonitor(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("MONITOR + secure,alice")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 730 mero sECuRE!blah@robust/0x13b5aa0a2bcfb8ad"),
+			irc.ParseMessage(":robustirc.net 731 mero alice"),
+		})
+
+	// Adding an entry twice is a no-op.
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("MONITOR + alice")),
+		[]*irc.Message{})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("MONITOR L")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 732 mero alice,secure"),
+			irc.ParseMessage(":robustirc.net 733 mero :End of MONITOR list"),
+		})
+
+	mustMatchIrcmsgs(t,
+		monitorReplies(i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NICK alice"))),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 731 mero sECuRE"),
+			irc.ParseMessage(":robustirc.net 730 mero alice!blah@robust/0x13b5aa0a2bcfb8ad"),
+		})
+
+	// Monitor lists survive snapshots.
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewIRCServer("robustirc.net", time.Now())
+	if _, err := restored.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+
+	mustMatchIrcmsgs(t,
+		monitorReplies(restored.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("QUIT :bye"))),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 731 mero alice"),
+		})
+
+	mustMatchIrcmsgs(t,
+		restored.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("MONITOR C")),
+		[]*irc.Message{})
+
+	mustMatchIrcmsgs(t,
+		restored.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("MONITOR L")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 733 mero :End of MONITOR list"),
+		})
+}
+
+func TestMonitorListFull(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	s, _ := i.GetSession(ids["mero"])
+	for n := 0; n < maxMonitor; n++ {
+		lc := NickToLower(string(rune('a'+n%26)) + string(rune('a'+n/26)))
+		s.monitor[lc] = string(lc)
+		i.addMonitorLocked(s, lc)
+	}
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("MONITOR + secure,xeen")),
+		":robustirc.net 734 mero 100 secure,xeen :Monitor list is full.")
+}
@@ -4,7 +4,8 @@ import "gopkg.in/sorcix/irc.v2"
 
 func init() {
 	Commands["MOTD"] = &ircCommand{
-		Func: (*IRCServer).cmdMotd,
+		Func:     (*IRCServer).cmdMotd,
+		ReadOnly: true,
 	}
 }
 
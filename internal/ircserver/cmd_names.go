@@ -9,39 +9,132 @@ import (
 
 func init() {
 	Commands["NAMES"] = &ircCommand{
-		Func: (*IRCServer).cmdNames,
+		Func:     (*IRCServer).cmdNames,
+		ReadOnly: true,
 	}
+	registerCap("userhost-in-names", capability{})
+}
+
+// namesEntry returns how |member| is listed in RPL_NAMREPLY for |s|, e.g.
+// “@sECuRE”, or “@sECuRE!blah@robust/0x13b5aa0a2bcfb8ad” for clients which
+// enabled the userhost-in-names capability.
+func namesEntry(s *Session, member *Session, perms *[maxChanMemberStatus]bool) string {
+	prefix := statusPrefix(perms)
+	if s.caps["userhost-in-names"] {
+		return prefix + member.ircPrefix.String()
+	}
+	return prefix + member.Nick
+}
+
+// channelNames returns the sorted RPL_NAMREPLY entries of the members of |c|
+// which are not hidden from |s|.
+func (i *IRCServer) channelNames(s *Session, c *channel) []string {
+	nicks := make([]string, 0, len(c.nicks))
+	for nick, perms := range c.nicks {
+		member := i.nicks[nick]
+		if member.hiddenFrom(s) {
+			continue
+		}
+		nicks = append(nicks, namesEntry(s, member, perms))
+	}
+	sort.Strings(nicks)
+	return nicks
 }
 
 func (i *IRCServer) cmdNames(s *Session, reply *Replyctx, msg *irc.Message) {
-	if len(msg.Params) > 0 {
-		channelname := msg.Params[0]
-		if c, ok := i.channels[ChanToLower(channelname)]; ok {
-			nicks := make([]string, 0, len(c.nicks))
-			for nick, perms := range c.nicks {
-				var prefix string
-				if perms[chanop] {
-					prefix = prefix + string('@')
-				}
-				nicks = append(nicks, prefix+i.nicks[nick].Nick)
-			}
+	if len(msg.Params) == 0 || msg.Params[0] == "*" {
+		i.cmdNamesAll(s, reply, msg)
+		return
+	}
 
-			sort.Strings(nicks)
+	channelname := msg.Params[0]
+	if c, ok := i.channels[ChanToLower(channelname)]; ok && c.visibleTo(s) {
+		nicks, next := paginate(i.channelNames(s, c), continuationToken(msg.Params), i.replyPageSize())
 
-			i.sendUser(s, reply, &irc.Message{
-				Prefix:  i.ServerPrefix,
-				Command: irc.RPL_NAMREPLY,
-				Params:  []string{s.Nick, "=", channelname, strings.Join(nicks, " ")},
-			})
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.RPL_NAMREPLY,
+			Params:  []string{s.Nick, "=", channelname, strings.Join(nicks, " ")},
+		})
+
+		i.sendMoreResults(s, reply, irc.NAMES, next)
+
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.RPL_ENDOFNAMES,
+			Params:  []string{s.Nick, channelname, "End of /NAMES list."},
+		})
+		return
+	}
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.RPL_ENDOFNAMES,
+		Params:  []string{s.Nick, "*", "End of /NAMES list."},
+	})
+}
+
+// cmdNamesAll handles NAMES without a channel (or with “*”, so that clients
+// can pass a continuation token): it lists the members of all channels which
+// are visible to |s|, followed by the users who are not on any of these
+// channels (as channel “*”). Pages consist of channels, see paginate.
+func (i *IRCServer) cmdNamesAll(s *Session, reply *Replyctx, msg *irc.Message) {
+	channels := make([]string, 0, len(i.channels))
+	for channelname, c := range i.channels {
+		if c.visibleTo(s) {
+			channels = append(channels, string(channelname))
+		}
+	}
+	sort.Strings(channels)
+	page, next := paginate(channels, continuationToken(msg.Params), i.replyPageSize())
+
+	for _, channelname := range page {
+		c := i.channels[lcChan(channelname)]
+		nicks := i.channelNames(s, c)
+		if len(nicks) == 0 {
+			continue
+		}
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.RPL_NAMREPLY,
+			Params:  []string{s.Nick, "=", c.name, strings.Join(nicks, " ")},
+		})
+	}
 
+	if next == "" {
+		var nicks []string
+		for _, session := range i.nicks {
+			if session.Server || session.hiddenFrom(s) {
+				continue
+			}
+			listed := false
+			for channelname := range session.Channels {
+				if c, ok := i.channels[channelname]; ok && c.visibleTo(s) {
+					listed = true
+					break
+				}
+			}
+			if !listed {
+				nicks = append(nicks, namesEntry(s, session, nil))
+			}
+		}
+		sort.Strings(nicks)
+		// Everything but the nick list, i.e.
+		// “:robustirc.net 353 sECuRE * * :”.
+		overhead := len((&irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.RPL_NAMREPLY,
+			Params:  []string{s.Nick, "*", "*", "a b"},
+		}).Bytes()) - len("a b")
+		for _, line := range joinLimited(nicks, maxLineLength-overhead) {
 			i.sendUser(s, reply, &irc.Message{
 				Prefix:  i.ServerPrefix,
-				Command: irc.RPL_ENDOFNAMES,
-				Params:  []string{s.Nick, channelname, "End of /NAMES list."},
+				Command: irc.RPL_NAMREPLY,
+				Params:  []string{s.Nick, "*", "*", line},
 			})
-			return
 		}
 	}
+
+	i.sendMoreResults(s, reply, irc.NAMES, next)
 	i.sendUser(s, reply, &irc.Message{
 		Prefix:  i.ServerPrefix,
 		Command: irc.RPL_ENDOFNAMES,
@@ -14,9 +14,13 @@ func TestNames(t *testing.T) {
 	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
 	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test"))
 
-	mustMatchMsg(t,
+	mustMatchIrcmsgs(t,
 		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NAMES")),
-		":robustirc.net 366 sECuRE * :End of /NAMES list.")
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 353 sECuRE = #test :@sECuRE xeen"),
+			irc.ParseMessage(":robustirc.net 353 sECuRE * * :mero"),
+			irc.ParseMessage(":robustirc.net 366 sECuRE * :End of /NAMES list."),
+		})
 
 	mustMatchIrcmsgs(t,
 		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NAMES #test")),
@@ -25,3 +29,55 @@ func TestNames(t *testing.T) {
 			irc.ParseMessage(":robustirc.net 366 sECuRE #test :End of /NAMES list."),
 		})
 }
+
+func TestNamesSecret(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +s"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("NAMES #test")),
+		":robustirc.net 366 mero * :End of /NAMES list.")
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("OPER mero foo"))
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("NAMES #test")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 353 mero = #test :@sECuRE"),
+			irc.ParseMessage(":robustirc.net 366 mero #test :End of /NAMES list."),
+		})
+}
+
+func TestNamesInvisible(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("MODE xeen +i"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("MODE mero +i"))
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("NAMES #test")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 353 mero = #test :@sECuRE"),
+			irc.ParseMessage(":robustirc.net 366 mero #test :End of /NAMES list."),
+		})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("NAMES")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 353 mero = #test :@sECuRE"),
+			irc.ParseMessage(":robustirc.net 353 mero * * :mero"),
+			irc.ParseMessage(":robustirc.net 366 mero * :End of /NAMES list."),
+		})
+
+	// Members of #test share a channel with xeen, but not with mero.
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NAMES")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 353 sECuRE = #test :@sECuRE xeen"),
+			irc.ParseMessage(":robustirc.net 366 sECuRE * :End of /NAMES list."),
+		})
+}
@@ -44,15 +44,36 @@ func (i *IRCServer) cmdNick(s *Session, reply *Replyctx, msg *irc.Message) {
 		return
 	}
 
-	if _, ok := i.nicks[NickToLower(nick)]; (ok && !onlyCapsChanged) || IsServicesNickname(nick) {
+	if reason, ok := i.qlineFor(nick); ok && !s.Operator && !onlyCapsChanged {
 		i.sendUser(s, reply, &irc.Message{
 			Prefix:  i.ServerPrefix,
-			Command: irc.ERR_NICKNAMEINUSE,
-			Params:  []string{dest, nick, "Nickname is already in use"},
+			Command: irc.ERR_ERRONEUSNICKNAME,
+			Params:  []string{dest, nick, fmt.Sprintf("Erroneous Nickname: %s", reason)},
 		})
 		return
 	}
 
+	if _, ok := i.nicks[NickToLower(nick)]; (ok && !onlyCapsChanged) || IsServicesNickname(nick) {
+		var guest string
+		if !s.loggedIn && i.guestNickOnCollision() {
+			guest = i.guestNick(s)
+		}
+		if guest == "" {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.ERR_NICKNAMEINUSE,
+				Params:  []string{dest, nick, "Nickname is already in use"},
+			})
+			return
+		}
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.NOTICE,
+			Params:  []string{dest, fmt.Sprintf("Nickname %s is already in use, using %s instead", nick, guest)},
+		})
+		nick = guest
+	}
+
 	if hold, ok := i.svsholds[NickToLower(nick)]; ok {
 		if !s.LastActivity.After(hold.added.Add(hold.duration)) {
 			i.sendUser(s, reply, &irc.Message{
@@ -66,6 +87,7 @@ func (i *IRCServer) cmdNick(s *Session, reply *Replyctx, msg *irc.Message) {
 		delete(i.svsholds, NickToLower(nick))
 	}
 
+	i.recordWhowas(s)
 	oldNick := NickToLower(s.Nick)
 	s.Nick = nick
 	i.nicks[NickToLower(s.Nick)] = s
@@ -80,6 +102,12 @@ func (i *IRCServer) cmdNick(s *Session, reply *Replyctx, msg *irc.Message) {
 		}
 	}
 	s.updateIrcPrefix()
+	if !onlyCapsChanged {
+		if oldNick != "" {
+			i.sendMonitors(oldPrefix.Name, nil, reply)
+		}
+		i.sendMonitors(s.Nick, s, reply)
+	}
 
 	if oldNick != "" {
 		i.sendServices(reply,
@@ -94,3 +122,22 @@ func (i *IRCServer) cmdNick(s *Session, reply *Replyctx, msg *irc.Message) {
 
 	i.maybeLogin(s, reply, msg)
 }
+
+// guestNick returns a Guest nickname (e.g. “Guest12345”) for |s| which is
+// neither in use nor held by services, or the empty string if there is no
+// such nickname. The nickname is derived from the session id, so that all
+// servers assign the same nickname.
+func (i *IRCServer) guestNick(s *Session) string {
+	const guestNicks = 100000
+	for n := uint64(0); n < guestNicks; n++ {
+		nick := fmt.Sprintf("Guest%05d", (s.Id.Id+n)%guestNicks)
+		if _, ok := i.nicks[NickToLower(nick)]; ok {
+			continue
+		}
+		if _, ok := i.svsholds[NickToLower(nick)]; ok {
+			continue
+		}
+		return nick
+	}
+	return ""
+}
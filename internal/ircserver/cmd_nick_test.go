@@ -43,6 +43,38 @@ func TestNickCollision(t *testing.T) {
 		":robustirc.net 433 * S{E}CURE :Nickname is already in use")
 }
 
+func TestNickCollisionGuest(t *testing.T) {
+	i, ids := stdIRCServer()
+	i.Config.GuestNickOnCollision = true
+
+	idGuest := robust.Id{Id: 1420228218166612345}
+	idGuest2 := robust.Id{Id: 1420228218166712345}
+	i.CreateSession(idGuest, "auth-guest", time.Unix(0, int64(idGuest.Id)))
+	i.CreateSession(idGuest2, "auth-guest2", time.Unix(0, int64(idGuest2.Id)))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: idGuest}, irc.ParseMessage("NICK secure")),
+		":robustirc.net NOTICE * :Nickname secure is already in use, using Guest12345 instead")
+
+	got := i.ProcessMessage(&robust.Message{Session: idGuest}, irc.ParseMessage("USER guest 0 * :Guest"))
+	if len(got.Messages) == 0 || irc.ParseMessage(got.Messages[0].Data).Command != irc.RPL_WELCOME {
+		t.Fatalf("session did not log in after being assigned a Guest nickname")
+	}
+	if got, want := i.GetNick(idGuest), "Guest12345"; got != want {
+		t.Fatalf("unexpected nickname: got %q, want %q", got, want)
+	}
+
+	// idGuest2 derives the same Guest nickname, so the next one must be used.
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: idGuest2}, irc.ParseMessage("NICK mero")),
+		":robustirc.net NOTICE * :Nickname mero is already in use, using Guest12346 instead")
+
+	// Nickname changes after registration still fail as before.
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NICK mero")),
+		":robustirc.net 433 sECuRE mero :Nickname is already in use")
+}
+
 func TestInvalidNick(t *testing.T) {
 	validNicks := []string{
 		"secure",
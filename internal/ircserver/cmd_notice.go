@@ -0,0 +1,61 @@
+package ircserver
+
+import (
+	"strings"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func init() {
+	Commands["NOTICE"] = &ircCommand{
+		Func: (*IRCServer).cmdNotice,
+	}
+}
+
+// cmdNotice is like cmdPrivmsg, but as per RFC2812 section 3.3.2, automatic
+// replies (including error replies and RPL_AWAY) must never be sent in
+// response to a NOTICE, so that bots cannot end up in reply loops.
+func (i *IRCServer) cmdNotice(s *Session, reply *Replyctx, msg *irc.Message) {
+	if len(msg.Params) < 2 || msg.Trailing() == "" {
+		return
+	}
+
+	if status, channelname := splitStatusmsg(msg.Params[0]); strings.HasPrefix(channelname, "#") {
+		c, ok := i.channels[ChanToLower(channelname)]
+		if !ok {
+			return
+		}
+		if _, ok := c.nicks[NickToLower(s.Nick)]; !ok && c.modes['n'] {
+			return
+		}
+		if needsRegistration(c, s) {
+			return
+		}
+		i.sendChannelMessage(c, status, s, reply, &irc.Message{
+			Prefix:  &s.ircPrefix,
+			Command: irc.NOTICE,
+			Params:  []string{msg.Params[0], msg.Trailing()},
+		})
+		return
+	}
+
+	session, ok := i.nicks[NickToLower(msg.Params[0])]
+	if !ok {
+		return
+	}
+
+	// To message invisible users, you must share a channel with them.
+	if session.modes['i'] && !sharesChannel(session, s) {
+		return
+	}
+
+	if session.silenced(s) {
+		return
+	}
+
+	i.tagReply(reply, i.sendUser(session, reply, &irc.Message{
+		Prefix:  &s.ircPrefix,
+		Command: irc.NOTICE,
+		Params:  []string{msg.Params[0], msg.Trailing()},
+	}))
+}
@@ -0,0 +1,56 @@
+package ircserver
+
+import (
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestNoticeNeverReplies(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #NoExternalMessages"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("MODE #NoExternalMessages +n"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("AWAY :gone"))
+
+	for _, cmd := range []string{
+		"NOTICE",
+		"NOTICE #test",
+		"NOTICE #toast :foo",
+		"NOTICE sorcix :foo",
+		"NOTICE #NoExternalMessages :foo",
+	} {
+		mustMatchIrcmsgs(t,
+			i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage(cmd)),
+			[]*irc.Message{})
+	}
+
+	// Unlike PRIVMSG, NOTICE must not trigger RPL_AWAY.
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NOTICE mero :foo")),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad NOTICE mero :foo")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NOTICE #test foo")),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad NOTICE #test :foo")
+}
+
+func TestNoticeInterested(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+
+	mustMatchInterested(t, i,
+		ids["secure"], irc.ParseMessage("NOTICE #test :hey"),
+		[]robust.Id{ids["secure"], ids["mero"], ids["xeen"]},
+		[]bool{false, true, false})
+
+	mustMatchInterested(t, i,
+		ids["secure"], irc.ParseMessage("NOTICE xeen :hey"),
+		[]robust.Id{ids["secure"], ids["mero"], ids["xeen"]},
+		[]bool{false, false, true})
+}
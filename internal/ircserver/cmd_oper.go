@@ -13,15 +13,26 @@ func (i *IRCServer) cmdOper(s *Session, reply *Replyctx, msg *irc.Message) {
 	name := msg.Params[0]
 	password := msg.Params[1]
 	authenticated := false
+	admin := false
 	i.ConfigMu.RLock()
 	defer i.ConfigMu.RUnlock()
 	for _, op := range i.Config.IRC.Operators {
 		if op.Name == name && op.Password == password {
 			authenticated = true
+			admin = op.Admin
 			break
 		}
 	}
 
+	if authenticated && i.svsnoop {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOOPERHOST,
+			Params:  []string{s.Nick, "No O-lines for your host"},
+		})
+		return
+	}
+
 	if !authenticated {
 		i.sendUser(s, reply, &irc.Message{
 			Prefix:  i.ServerPrefix,
@@ -33,6 +44,7 @@ func (i *IRCServer) cmdOper(s *Session, reply *Replyctx, msg *irc.Message) {
 
 	s.Operator = true
 	s.modes['o'] = true
+	s.modes['A'] = admin
 
 	modestr := "+"
 	for mode := 'A'; mode < 'z'; mode++ {
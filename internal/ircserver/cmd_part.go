@@ -14,7 +14,11 @@ func init() {
 }
 
 func (i *IRCServer) cmdPart(s *Session, reply *Replyctx, msg *irc.Message) {
-	for _, channelname := range strings.Split(msg.Params[0], ",") {
+	channelnames := strings.Split(msg.Params[0], ",")
+	if i.tooManyTargets(s, reply, channelnames) {
+		return
+	}
+	for _, channelname := range channelnames {
 		c, ok := i.channels[ChanToLower(channelname)]
 		if !ok {
 			i.sendUser(s, reply, &irc.Message{
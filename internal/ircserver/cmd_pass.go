@@ -26,6 +26,13 @@ func (i *IRCServer) cmdPass(s *Session, reply *Replyctx, msg *irc.Message) {
 	// network= for authenticating to a private network (not yet implemented)
 	// nickserv= for authenticating to services
 	// oper= for authenticating as an IRC operator
+	//
+	// Services which link using TS6 send “PASS <password> TS 6 :<sid>”.
+	if len(msg.Params) == 4 && msg.Params[1] == "TS" && msg.Params[2] == "6" && validSID(msg.Params[3]) {
+		s.Pass = "services=" + msg.Params[0]
+		s.uid = msg.Params[3]
+		return
+	}
 	if len(msg.Params) > 0 {
 		s.Pass = strings.Join(msg.Params, " ")
 	}
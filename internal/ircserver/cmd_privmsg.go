@@ -2,8 +2,11 @@ package ircserver
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/robustirc/robustirc/plugin"
+
 	"gopkg.in/sorcix/irc.v2"
 )
 
@@ -11,9 +14,90 @@ func init() {
 	Commands["PRIVMSG"] = &ircCommand{
 		Func: (*IRCServer).cmdPrivmsg,
 	}
-	Commands["NOTICE"] = &ircCommand{
-		Func: (*IRCServer).cmdPrivmsg,
+}
+
+// statusmsgPrefixes returns the member status prefixes which can be prepended
+// to a channel name to only address members with at least that status, e.g.
+// “@#chan” for chanops, see STATUSMSG in ISUPPORT.
+func statusmsgPrefixes() string {
+	var prefixes string
+	for _, ms := range memberStatuses {
+		prefixes += ms.prefix
+	}
+	return prefixes
+}
+
+// splitStatusmsg returns the member status addressed by |target| (or -1 if
+// |target| does not start with one of the statusmsgPrefixes) and the
+// remaining target.
+func splitStatusmsg(target string) (int, string) {
+	if len(target) > 1 {
+		for _, ms := range memberStatuses {
+			if strings.HasPrefix(target, ms.prefix) {
+				return ms.status, target[len(ms.prefix):]
+			}
+		}
+	}
+	return -1, target
+}
+
+// channelTransform returns the name of the transform configured for |c| (see
+// config.Network.ChannelTransforms) and the transform itself, if any.
+func (i *IRCServer) channelTransform(c *channel) (string, plugin.Transform) {
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	// Sorted, so that the result is deterministic even if the configuration
+	// contains multiple spellings of the same channel.
+	channelnames := make([]string, 0, len(i.Config.ChannelTransforms))
+	for channelname := range i.Config.ChannelTransforms {
+		channelnames = append(channelnames, channelname)
+	}
+	sort.Strings(channelnames)
+	for _, channelname := range channelnames {
+		if ChanToLower(channelname) != ChanToLower(c.name) {
+			continue
+		}
+		name := i.Config.ChannelTransforms[channelname]
+		// Configurations are validated before being applied, but the
+		// binary might have been rolled back to a version lacking the
+		// transform since.
+		if transform, ok := plugin.LookupTransform(name); ok {
+			return name, transform
+		}
+	}
+	return "", nil
+}
+
+// needsRegistration returns whether |s| cannot speak in |c| because only
+// users who identified with services (user mode +r, set by services) may
+// speak (channel mode +R). Voiced users are exempt, so that chanops can let
+// unregistered members speak.
+func needsRegistration(c *channel, s *Session) bool {
+	return c.modes['R'] && !s.modes['r'] && !hasStatus(c.nicks[NickToLower(s.Nick)], voice)
+}
+
+// sendChannelMessage sends |msg| to the members of |c| addressed by |status|
+// (see splitStatusmsg), except for |s|. The text of |msg| is transformed if a
+// transform is configured for |c|, and the client tags of the message being
+// processed are relayed.
+func (i *IRCServer) sendChannelMessage(c *channel, status int, s *Session, reply *Replyctx, msg *irc.Message) {
+	name, transform := i.channelTransform(c)
+	transformed := false
+	if transform != nil {
+		last := len(msg.Params) - 1
+		msg.Params[last], transformed = transform(msg.Params[last])
+	}
+	if status == -1 {
+		i.sendChannelButOne(c, s, reply, msg)
+		i.recordHistory(c, s, msg)
+	} else {
+		i.sendChannelStatusButOne(c, status, s, reply, msg)
 	}
+	if transformed {
+		// send returns the robust.Message which was just created for msg.
+		i.send(reply, msg).Transform = name
+	}
+	i.tagReply(reply, msg)
 }
 
 func (i *IRCServer) cmdPrivmsg(s *Session, reply *Replyctx, msg *irc.Message) {
@@ -35,8 +119,8 @@ func (i *IRCServer) cmdPrivmsg(s *Session, reply *Replyctx, msg *irc.Message) {
 		return
 	}
 
-	if strings.HasPrefix(msg.Params[0], "#") {
-		c, ok := i.channels[ChanToLower(msg.Params[0])]
+	if status, channelname := splitStatusmsg(msg.Params[0]); strings.HasPrefix(channelname, "#") {
+		c, ok := i.channels[ChanToLower(channelname)]
 		if !ok {
 			i.sendUser(s, reply, &irc.Message{
 				Prefix:  i.ServerPrefix,
@@ -53,7 +137,15 @@ func (i *IRCServer) cmdPrivmsg(s *Session, reply *Replyctx, msg *irc.Message) {
 			})
 			return
 		}
-		i.sendChannelButOne(c, s, reply, &irc.Message{
+		if needsRegistration(c, s) {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.ERR_CANNOTSENDTOCHAN,
+				Params:  []string{s.Nick, c.name, "Cannot send to channel (+R) - you need to be identified with services"},
+			})
+			return
+		}
+		i.sendChannelMessage(c, status, s, reply, &irc.Message{
 			Prefix:  &s.ircPrefix,
 			Command: msg.Command,
 			Params:  []string{msg.Params[0], msg.Trailing()},
@@ -71,25 +163,20 @@ func (i *IRCServer) cmdPrivmsg(s *Session, reply *Replyctx, msg *irc.Message) {
 		return
 	}
 
-	if session.modes['i'] {
-		// To message invisible users, you must share a channel with them.
-		common := false
-		for channelname := range session.Channels {
-			if _, ok := s.Channels[channelname]; ok {
-				common = true
-				break
-			}
-		}
-		if !common {
-			return
-		}
+	// To message invisible users, you must share a channel with them.
+	if session.modes['i'] && !sharesChannel(session, s) {
+		return
+	}
+
+	if session.silenced(s) {
+		return
 	}
 
-	i.sendUser(session, reply, &irc.Message{
+	i.tagReply(reply, i.sendUser(session, reply, &irc.Message{
 		Prefix:  &s.ircPrefix,
 		Command: msg.Command,
 		Params:  []string{msg.Params[0], msg.Trailing()},
-	})
+	}))
 
 	if session.AwayMsg != "" && msg.Command == irc.PRIVMSG {
 		i.sendUser(s, reply, &irc.Message{
@@ -44,38 +44,106 @@ func TestInvalidPrivmsg(t *testing.T) {
 		":robustirc.net 404 sECuRE #NoExternalMessages :Cannot send to channel")
 }
 
-func TestInvalidNotice(t *testing.T) {
+func TestPrivmsgStatusmsg(t *testing.T) {
 	i, ids := stdIRCServer()
 
 	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +v xeen"))
+
+	for _, tt := range []struct {
+		mode      string
+		msg       string
+		want      string
+		recipient []string
+	}{
+		{"", "PRIVMSG @#test :ops only", ":mero!foo@robust/0x13b5aa0a2bcfb8ae PRIVMSG @#test :ops only", []string{"secure"}},
+		{"", "PRIVMSG +#test :voiced", ":mero!foo@robust/0x13b5aa0a2bcfb8ae PRIVMSG +#test :voiced", []string{"secure", "xeen"}},
+		{"", "NOTICE @#test :ops only", ":mero!foo@robust/0x13b5aa0a2bcfb8ae NOTICE @#test :ops only", []string{"secure"}},
+		{"", "PRIVMSG %#test :halfops", ":mero!foo@robust/0x13b5aa0a2bcfb8ae PRIVMSG %#test :halfops", []string{"secure"}},
+		{"MODE #test +h-v xeen xeen", "PRIVMSG %#test :halfops", ":mero!foo@robust/0x13b5aa0a2bcfb8ae PRIVMSG %#test :halfops", []string{"secure", "xeen"}},
+		{"", "PRIVMSG @#test :ops only", ":mero!foo@robust/0x13b5aa0a2bcfb8ae PRIVMSG @#test :ops only", []string{"secure"}},
+		{"MODE #test +o-h xeen xeen", "PRIVMSG @#test :ops only", ":mero!foo@robust/0x13b5aa0a2bcfb8ae PRIVMSG @#test :ops only", []string{"secure", "xeen"}},
+	} {
+		if tt.mode != "" {
+			i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage(tt.mode))
+		}
+		reply := i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage(tt.msg))
+		mustMatchMsg(t, reply, tt.want)
+		want := make(map[uint64]bool)
+		for _, nick := range tt.recipient {
+			want[ids[nick].Id] = true
+		}
+		got := reply.Messages[0].InterestingFor
+		if len(got) != len(want) {
+			t.Fatalf("%q: InterestingFor = %v, want %v", tt.msg, got, want)
+		}
+		for id := range want {
+			if !got[id] {
+				t.Fatalf("%q: InterestingFor = %v, want %v", tt.msg, got, want)
+			}
+		}
+	}
 
 	mustMatchMsg(t,
-		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NOTICE #test")),
-		":robustirc.net 412 sECuRE :No text to send")
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("PRIVMSG @#toast :foo")),
+		":robustirc.net 403 mero @#toast :No such channel")
+}
 
-	mustMatchMsg(t,
-		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NOTICE #toast :foo")),
-		":robustirc.net 403 sECuRE #toast :No such channel")
+func TestPrivmsgChannelTransform(t *testing.T) {
+	i, ids := stdIRCServer()
+	i.Config.ChannelTransforms = map[string]string{"#Legacy": "latin1"}
 
-	mustMatchMsg(t,
-		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NOTICE #test foo")),
-		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad NOTICE #test :foo")
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #legacy"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #legacy"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+
+	got := i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("PRIVMSG #legacy :Gr\xfc\xdfe"))
+	mustMatchMsg(t, got, ":mero!foo@robust/0x13b5aa0a2bcfb8ae PRIVMSG #legacy :Grüße")
+	if got, want := got.Messages[0].Transform, "latin1"; got != want {
+		t.Fatalf("message not tagged as transformed: got %q, want %q", got, want)
+	}
+
+	// Text which does not need to be transformed is not tagged.
+	got = i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("NOTICE #legacy :Grüße"))
+	mustMatchMsg(t, got, ":mero!foo@robust/0x13b5aa0a2bcfb8ae NOTICE #legacy :Grüße")
+	if got := got.Messages[0].Transform; got != "" {
+		t.Fatalf("unmodified message unexpectedly tagged as transformed by %q", got)
+	}
+
+	// Other channels are not transformed.
+	got = i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("PRIVMSG #test :Gr\xfc\xdfe"))
+	mustMatchMsg(t, got, ":mero!foo@robust/0x13b5aa0a2bcfb8ae PRIVMSG #test :Gr\xfc\xdfe")
+}
 
-	mustMatchMsg(t,
-		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NOTICE")),
-		":robustirc.net 411 sECuRE :No recipient given (NOTICE)")
+func TestPrivmsgRegisteredOnly(t *testing.T) {
+	i, ids := stdIRCServerWithServices()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +R"))
 
 	mustMatchMsg(t,
-		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NOTICE sorcix :foo")),
-		":robustirc.net 401 sECuRE sorcix :No such nick/channel")
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("PRIVMSG #test :hey")),
+		":robustirc.net 404 mero #test :Cannot send to channel (+R) - you need to be identified with services")
 
-	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #NoExternalMessages"))
-	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("MODE #NoExternalMessages +n"))
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("NOTICE #test :hey")),
+		[]*irc.Message{})
+
+	i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("SVSMODE mero +r"))
 
 	mustMatchMsg(t,
-		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("NOTICE #NoExternalMessages :foo")),
-		":mero!foo@robust/0x13b5aa0a2bcfb8ae NOTICE #NoExternalMessages :foo")
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("PRIVMSG #test :hey")),
+		":mero!foo@robust/0x13b5aa0a2bcfb8ae PRIVMSG #test :hey")
+
+	// Voiced users may speak without identifying.
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +v xeen"))
+
 	mustMatchMsg(t,
-		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NOTICE #NoExternalMessages :foo")),
-		":robustirc.net 404 sECuRE #NoExternalMessages :Cannot send to channel")
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("PRIVMSG #test :hey")),
+		":xeen!baz@robust/0x13b5aa0a2bcfb8af PRIVMSG #test :hey")
 }
@@ -0,0 +1,134 @@
+package ircserver
+
+import (
+	"fmt"
+	"sort"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func init() {
+	Commands["QLINE"] = &ircCommand{
+		Func:      (*IRCServer).cmdQline,
+		MinParams: 2,
+	}
+	Commands["UNQLINE"] = &ircCommand{
+		Func:      (*IRCServer).cmdUnqline,
+		MinParams: 1,
+	}
+}
+
+// qlineFor returns the reason of the Q-line (see config.Network.Qlines) which
+// matches |nick|, if any.
+func (i *IRCServer) qlineFor(nick string) (string, bool) {
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	// Sorted, so that all servers return the same reason in case multiple
+	// Q-lines match.
+	patterns := make([]string, 0, len(i.Config.Qlines))
+	for pattern := range i.Config.Qlines {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		p, err := silencePattern(pattern)
+		if err != nil {
+			continue
+		}
+		if p.re.MatchString(nick) {
+			return i.Config.Qlines[pattern], true
+		}
+	}
+	return "", false
+}
+
+// qline adds |pattern| to the network configuration’s reserved nicknames.
+func (i *IRCServer) qline(pattern, reason string) {
+	i.ConfigMu.Lock()
+	defer i.ConfigMu.Unlock()
+	if i.Config.Qlines == nil {
+		i.Config.Qlines = make(map[string]string)
+	}
+	i.Config.Qlines[pattern] = reason
+}
+
+// unqline removes |pattern| from the network configuration’s reserved
+// nicknames and returns whether it was reserved.
+func (i *IRCServer) unqline(pattern string) bool {
+	i.ConfigMu.Lock()
+	defer i.ConfigMu.Unlock()
+	if _, ok := i.Config.Qlines[pattern]; !ok {
+		return false
+	}
+	delete(i.Config.Qlines, pattern)
+	return true
+}
+
+func (i *IRCServer) cmdQline(s *Session, reply *Replyctx, msg *irc.Message) {
+	if !s.Operator {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOPRIVILEGES,
+			Params:  []string{s.Nick, "Permission Denied - You're not an IRC operator"},
+		})
+		return
+	}
+	pattern, reason := msg.Params[0], msg.Params[1]
+	if _, err := silencePattern(pattern); err != nil {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.NOTICE,
+			Params:  []string{s.Nick, fmt.Sprintf("Invalid nickname pattern %q: %v", pattern, err)},
+		})
+		return
+	}
+	i.qline(pattern, reason)
+	i.sendOperators(nil, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.NOTICE,
+		Params:  []string{"*", fmt.Sprintf("*** Notice -- %s added Q-line for [%s] [%s]", s.Nick, pattern, reason)},
+	})
+}
+
+func (i *IRCServer) cmdUnqline(s *Session, reply *Replyctx, msg *irc.Message) {
+	if !s.Operator {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOPRIVILEGES,
+			Params:  []string{s.Nick, "Permission Denied - You're not an IRC operator"},
+		})
+		return
+	}
+	pattern := msg.Params[0]
+	if !i.unqline(pattern) {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.NOTICE,
+			Params:  []string{s.Nick, fmt.Sprintf("No Q-line for [%s] found", pattern)},
+		})
+		return
+	}
+	i.sendOperators(nil, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.NOTICE,
+		Params:  []string{"*", fmt.Sprintf("*** Notice -- %s has removed the Q-line for [%s]", s.Nick, pattern)},
+	})
+}
+
+// sendStatsQlines sends RPL_STATSQLINE for all Q-lines to |s|.
+func (i *IRCServer) sendStatsQlines(s *Session, reply *Replyctx) {
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	patterns := make([]string, 0, len(i.Config.Qlines))
+	for pattern := range i.Config.Qlines {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.RPL_STATSQLINE,
+			Params:  []string{s.Nick, "Q", pattern, i.Config.Qlines[pattern]},
+		})
+	}
+}
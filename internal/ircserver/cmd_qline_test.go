@@ -0,0 +1,83 @@
+package ircserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestQline(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("QLINE *Serv :reserved for services")),
+		":robustirc.net 481 sECuRE :Permission Denied - You're not an IRC operator")
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("OPER mero foo"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("QLINE *Serv :reserved for services")),
+		":robustirc.net NOTICE * :*** Notice -- mero added Q-line for [*Serv] [reserved for services]")
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("QLINE admin* :impersonation")),
+		":robustirc.net NOTICE * :*** Notice -- mero added Q-line for [admin*] [impersonation]")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NICK nickserv")),
+		":robustirc.net 432 sECuRE nickserv :Erroneous Nickname: reserved for services")
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NICK Administrator")),
+		":robustirc.net 432 sECuRE Administrator :Erroneous Nickname: impersonation")
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NICK servant")),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad NICK :servant")
+
+	// Sessions which are not logged in yet are rejected, too.
+	id := robust.Id{Id: 1420228218166687920}
+	i.CreateSession(id, "auth-new", time.Unix(0, int64(id.Id)))
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: id}, irc.ParseMessage("NICK ChanServ")),
+		":robustirc.net 432 * ChanServ :Erroneous Nickname: reserved for services")
+
+	// IRC operators may use reserved nicknames.
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("NICK admin")),
+		":mero!foo@robust/0x13b5aa0a2bcfb8ae NICK :admin")
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NICK sECuRE")),
+		[]*irc.Message{
+			irc.ParseMessage(":servant!blah@robust/0x13b5aa0a2bcfb8ad NICK :sECuRE"),
+		})
+
+	// Q-lines survive snapshots.
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i = NewIRCServer("robustirc.net", time.Now())
+	if _, err := i.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("STATS q")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 217 admin Q *Serv :reserved for services"),
+			irc.ParseMessage(":robustirc.net 217 admin Q admin* :impersonation"),
+			irc.ParseMessage(":robustirc.net 219 admin q :End of /STATS report"),
+		})
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("UNQLINE admin*")),
+		":robustirc.net NOTICE * :*** Notice -- admin has removed the Q-line for [admin*]")
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("UNQLINE admin*")),
+		":robustirc.net NOTICE admin :No Q-line for [admin*] found")
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NICK Administrator")),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad NICK :Administrator")
+}
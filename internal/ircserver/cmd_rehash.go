@@ -0,0 +1,104 @@
+package ircserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/config"
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func init() {
+	Commands["REHASH"] = &ircCommand{
+		Func: (*IRCServer).cmdRehash,
+	}
+}
+
+// cmdRehash records a request to reload the network configuration. Reading
+// the configuration file cannot happen within the state machine, so the raft
+// leader picks up the request (see RehashRequested) and applies the file
+// contents as a robust.Config message, which all nodes apply alike.
+func (i *IRCServer) cmdRehash(s *Session, reply *Replyctx, msg *irc.Message) {
+	if !s.Operator {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOPRIVILEGES,
+			Params:  []string{s.Nick, "Permission Denied - You're not an IRC operator"},
+		})
+		return
+	}
+
+	i.rehashRequested = reply.msgid
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.RPL_REHASHING,
+		Params:  []string{s.Nick, "network_config", "Rehashing"},
+	})
+	i.sendOperators(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.NOTICE,
+		Params:  []string{"*", fmt.Sprintf("*** Notice -- %s is rehashing the network configuration", s.Nick)},
+	})
+}
+
+// RehashRequested returns the id of the pending REHASH request, or 0 if
+// there is none.
+func (i *IRCServer) RehashRequested() uint64 {
+	i.sessionsMu.RLock()
+	defer i.sessionsMu.RUnlock()
+	return i.rehashRequested
+}
+
+// ConfigApplied must be called when a robust.Config message containing
+// |newCfg| is applied, before i.Config is replaced. It fulfills the pending
+// REHASH request, if any, notifies IRC operators and informs services in case
+// the retention window changes.
+func (i *IRCServer) ConfigApplied(msg *robust.Message, newCfg config.Network) *Replyctx {
+	i.sessionsMu.Lock()
+	defer i.sessionsMu.Unlock()
+
+	reply := &Replyctx{msgid: msg.Id.Id, timestamp: msg.Timestamp()}
+	defer i.translateLinks(reply)
+	oldExpiration := func() time.Duration {
+		i.ConfigMu.RLock()
+		defer i.ConfigMu.RUnlock()
+		return time.Duration(i.Config.SessionExpiration)
+	}()
+	newExpiration := time.Duration(newCfg.SessionExpiration)
+	if RetentionWindow(oldExpiration) != RetentionWindow(newExpiration) {
+		i.sendRetention(reply, newExpiration, msg.Timestamp())
+	}
+	i.sendCapChanges(reply, &newCfg)
+	if i.rehashRequested == 0 {
+		return reply
+	}
+	i.rehashRequested = 0
+	i.sendOperators(nil, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.NOTICE,
+		Params:  []string{"*", fmt.Sprintf("*** Notice -- Network configuration reloaded (revision %d)", msg.Revision)},
+	})
+	return reply
+}
+
+// RehashFailed must be called when a robust.RehashFailed message is applied.
+// It clears the pending REHASH request, if any, so that the operator can
+// retry, and notifies IRC operators of the error in msg.Data.
+func (i *IRCServer) RehashFailed(msg *robust.Message) *Replyctx {
+	i.sessionsMu.Lock()
+	defer i.sessionsMu.Unlock()
+
+	reply := &Replyctx{msgid: msg.Id.Id, timestamp: msg.Timestamp()}
+	if i.rehashRequested == 0 {
+		return reply
+	}
+	i.rehashRequested = 0
+	i.sendOperators(nil, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.NOTICE,
+		Params:  []string{"*", fmt.Sprintf("*** Notice -- Could not reload network configuration: %s", msg.Data)},
+	})
+	return reply
+}
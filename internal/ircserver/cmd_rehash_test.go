@@ -0,0 +1,74 @@
+package ircserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestRehash(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("REHASH")),
+		":robustirc.net 481 sECuRE :Permission Denied - You're not an IRC operator")
+
+	if got, want := i.RehashRequested(), uint64(0); got != want {
+		t.Fatalf("RehashRequested() = %d, want %d", got, want)
+	}
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("OPER mero foo"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("OPER xeen foo"))
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Id: robust.Id{Id: 42}, Session: ids["mero"]}, irc.ParseMessage("REHASH")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 382 mero network_config :Rehashing"),
+			irc.ParseMessage(":robustirc.net NOTICE * :*** Notice -- mero is rehashing the network configuration"),
+		})
+
+	if got, want := i.RehashRequested(), uint64(42); got != want {
+		t.Fatalf("RehashRequested() = %d, want %d", got, want)
+	}
+
+	// Pending requests survive snapshots.
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewIRCServer("robustirc.net", time.Now())
+	if _, err := restored.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := restored.RehashRequested(), uint64(42); got != want {
+		t.Fatalf("RehashRequested() after restore = %d, want %d", got, want)
+	}
+
+	mustMatchMsg(t,
+		restored.ConfigApplied(&robust.Message{Id: robust.Id{Id: 43}, Type: robust.Config, Revision: 2}, restored.Config),
+		":robustirc.net NOTICE * :*** Notice -- Network configuration reloaded (revision 2)")
+
+	if got, want := restored.RehashRequested(), uint64(0); got != want {
+		t.Fatalf("RehashRequested() = %d, want %d", got, want)
+	}
+
+	// Configuration changes without a pending REHASH are not announced.
+	mustMatchIrcmsgs(t,
+		restored.ConfigApplied(&robust.Message{Id: robust.Id{Id: 44}, Type: robust.Config, Revision: 3}, restored.Config),
+		[]*irc.Message{})
+
+	// Failures clear the pending request and are reported to operators.
+	restored.ProcessMessage(&robust.Message{Id: robust.Id{Id: 45}, Session: ids["mero"]}, irc.ParseMessage("REHASH"))
+	mustMatchMsg(t,
+		restored.RehashFailed(&robust.Message{Id: robust.Id{Id: 46}, Type: robust.RehashFailed, Data: "-network_config not specified"}),
+		":robustirc.net NOTICE * :*** Notice -- Could not reload network configuration: -network_config not specified")
+	if got, want := restored.RehashRequested(), uint64(0); got != want {
+		t.Fatalf("RehashRequested() after failure = %d, want %d", got, want)
+	}
+	mustMatchIrcmsgs(t,
+		restored.RehashFailed(&robust.Message{Id: robust.Id{Id: 47}, Type: robust.RehashFailed, Data: "stale"}),
+		[]*irc.Message{})
+}
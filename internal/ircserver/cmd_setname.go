@@ -0,0 +1,35 @@
+package ircserver
+
+import "gopkg.in/sorcix/irc.v2"
+
+func init() {
+	Commands["SETNAME"] = &ircCommand{
+		Func:      (*IRCServer).cmdSetname,
+		MinParams: 1,
+	}
+	registerCap("setname", capability{})
+}
+
+// cmdSetname changes the realname of an established session, see
+// https://ircv3.net/specs/extensions/setname
+func (i *IRCServer) cmdSetname(s *Session, reply *Replyctx, msg *irc.Message) {
+	realname := msg.Trailing()
+	if realname == "" {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: "FAIL",
+			Params:  []string{"SETNAME", "INVALID_REALNAME", "Realname is not valid"},
+		})
+		return
+	}
+	s.Realname = realname
+	setname := &irc.Message{
+		Prefix:  &s.ircPrefix,
+		Command: "SETNAME",
+		Params:  []string{realname},
+	}
+	i.sendCommonChannelsWithCap(s, reply, "setname", setname)
+	if s.caps["setname"] {
+		i.sendUser(s, reply, setname)
+	}
+}
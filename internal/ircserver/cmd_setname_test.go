@@ -0,0 +1,34 @@
+package ircserver
+
+import (
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestSetname(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test"))
+	i.sessions[ids["secure"]].caps["setname"] = true
+	i.sessions[ids["mero"]].caps["setname"] = true
+
+	reply := i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("SETNAME :Michael Stapelberg"))
+	mustMatchMsg(t, reply, ":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad SETNAME :Michael Stapelberg")
+	// Only sessions which enabled the setname capability are notified.
+	if got := reply.Messages[0].InterestingFor; !got[ids["secure"].Id] || !got[ids["mero"].Id] || got[ids["xeen"].Id] {
+		t.Fatalf("SETNAME delivered to %v, want secure and mero", got)
+	}
+
+	if got, want := i.sessions[ids["secure"]].Realname, "Michael Stapelberg"; got != want {
+		t.Fatalf("Realname = %q, want %q", got, want)
+	}
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("SETNAME :")),
+		":robustirc.net FAIL SETNAME INVALID_REALNAME :Realname is not valid")
+}
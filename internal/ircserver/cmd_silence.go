@@ -0,0 +1,117 @@
+package ircserver
+
+import (
+	"regexp"
+	"strings"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// Numerics for SILENCE, as used by UnrealIRCd.
+const (
+	rplSileList      = "271"
+	rplEndOfSileList = "272"
+	errSileListFull  = "511"
+)
+
+// maxSilence is the maximum number of entries in a session’s silence list.
+const maxSilence = 15
+
+func init() {
+	Commands["SILENCE"] = &ircCommand{
+		Func: (*IRCServer).cmdSilence,
+	}
+}
+
+// normalizeSilenceMask expands a nickname to a full nick!user@host mask.
+func normalizeSilenceMask(mask string) string {
+	if !strings.ContainsAny(mask, "!@") {
+		return mask + "!*@*"
+	}
+	return mask
+}
+
+// silencePattern compiles |mask|, in which “*” is the only wildcard, into a
+// case-insensitive regular expression.
+func silencePattern(mask string) (banPattern, error) {
+	pattern := strings.Replace(regexp.QuoteMeta(mask), "\\*", ".*", -1)
+	re, err := regexp.Compile("(?i)^" + pattern + "$")
+	if err != nil {
+		return banPattern{}, err
+	}
+	return banPattern{re: re, pattern: mask}, nil
+}
+
+// silenced returns whether |s| silenced |user|.
+func (s *Session) silenced(user *Session) bool {
+	if len(s.silence) == 0 {
+		return false
+	}
+	return banned(s.silence, user.ircPrefix.String(), user.Nick+"!"+user.Username+"@"+user.RemoteAddr)
+}
+
+func (i *IRCServer) cmdSilence(s *Session, reply *Replyctx, msg *irc.Message) {
+	if len(msg.Params) == 0 {
+		for _, b := range s.silence {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: rplSileList,
+				Params:  []string{s.Nick, b.pattern},
+			})
+		}
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: rplEndOfSileList,
+			Params:  []string{s.Nick, "End of Silence List"},
+		})
+		return
+	}
+
+	mask := msg.Params[0]
+	add := !strings.HasPrefix(mask, "-")
+	mask = normalizeSilenceMask(strings.TrimLeft(mask, "+-"))
+	if mask == "!*@*" {
+		return
+	}
+
+	idx := -1
+	for n, b := range s.silence {
+		if strings.EqualFold(b.pattern, mask) {
+			idx = n
+			break
+		}
+	}
+	if add {
+		if idx > -1 {
+			return
+		}
+		if len(s.silence) >= maxSilence {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: errSileListFull,
+				Params:  []string{s.Nick, mask, "Your silence list is full"},
+			})
+			return
+		}
+		b, err := silencePattern(mask)
+		if err != nil {
+			return
+		}
+		s.silence = append(s.silence, b)
+	} else {
+		if idx == -1 {
+			return
+		}
+		s.silence = append(s.silence[:idx], s.silence[idx+1:]...)
+	}
+
+	change := "+"
+	if !add {
+		change = "-"
+	}
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  &s.ircPrefix,
+		Command: "SILENCE",
+		Params:  []string{change + mask},
+	})
+}
@@ -0,0 +1,70 @@
+package ircserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestSilence(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("SILENCE +SECURE")),
+		":mero!foo@robust/0x13b5aa0a2bcfb8ae SILENCE +SECURE!*@*")
+
+	// Adding the same mask again is a no-op.
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("SILENCE secure")),
+		[]*irc.Message{})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("SILENCE")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 271 mero SECURE!*@*"),
+			irc.ParseMessage(":robustirc.net 272 mero :End of Silence List"),
+		})
+
+	// Private messages from silenced users are dropped, without RPL_AWAY.
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("AWAY :gone"))
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("PRIVMSG mero :hey")),
+		[]*irc.Message{})
+
+	// Channel messages are delivered to everyone but silencing users.
+	reply := i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("PRIVMSG #test :hey all"))
+	if got := reply.Messages[0].InterestingFor; !got[ids["xeen"].Id] || got[ids["mero"].Id] {
+		t.Fatalf("channel message delivered to %v, want only xeen", got)
+	}
+
+	// Silence lists survive snapshots.
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewIRCServer("robustirc.net", time.Now())
+	if _, err := restored.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+	mustMatchIrcmsgs(t,
+		restored.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NOTICE mero :hey")),
+		[]*irc.Message{})
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("SILENCE -secure!*@*")),
+		":mero!foo@robust/0x13b5aa0a2bcfb8ae SILENCE -secure!*@*")
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("PRIVMSG mero :hey")),
+		[]*irc.Message{
+			irc.ParseMessage(":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad PRIVMSG mero :hey"),
+			irc.ParseMessage(":robustirc.net 301 sECuRE mero :gone"),
+		})
+}
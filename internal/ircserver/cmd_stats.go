@@ -0,0 +1,140 @@
+package ircserver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func init() {
+	Commands["STATS"] = &ircCommand{
+		Func:      (*IRCServer).cmdStats,
+		MinParams: 1,
+		ReadOnly:  true,
+	}
+}
+
+func (i *IRCServer) cmdStats(s *Session, reply *Replyctx, msg *irc.Message) {
+	if !s.Operator {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOPRIVILEGES,
+			Params:  []string{s.Nick, "Permission Denied - You're not an IRC operator"},
+		})
+		return
+	}
+
+	query := msg.Params[0]
+	switch query {
+	case "u":
+		// Relative to the time of the message (not of processing it) to keep
+		// the output identical on all nodes.
+		uptime := s.LastActivity.Sub(i.ServerCreation)
+		if uptime < 0 {
+			uptime = 0
+		}
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.RPL_STATSUPTIME,
+			Params: []string{s.Nick, fmt.Sprintf("Server Up %d days %d:%02d:%02d",
+				int(uptime/(24*time.Hour)),
+				int(uptime/time.Hour)%24,
+				int(uptime/time.Minute)%60,
+				int(uptime/time.Second)%60)},
+		})
+
+	case "o":
+		i.sendStatsOperators(s, reply)
+
+	case "k":
+		i.sendStatsKlines(s, reply)
+
+	case "q":
+		i.sendStatsQlines(s, reply)
+
+	case "m":
+		// The command counters are not part of the (replicated) state: each
+		// node counts the messages which were posted to it since it started.
+		// Hence, they are only reported when answering locally.
+		if reply.nodeInfo == nil {
+			break
+		}
+		counts := reply.nodeInfo.CommandCounts
+		commands := make([]string, 0, len(counts))
+		for command := range counts {
+			commands = append(commands, command)
+		}
+		sort.Strings(commands)
+		for _, command := range commands {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.RPL_STATSCOMMANDS,
+				Params:  []string{s.Nick, command, strconv.FormatUint(counts[command], 10)},
+			})
+		}
+
+	case "l":
+		// Without a nickname, list the server-to-server links (services).
+		var sessions []*Session
+		if len(msg.Params) > 1 {
+			if session, ok := i.nicks[NickToLower(msg.Params[1])]; ok {
+				sessions = append(sessions, session)
+			}
+		} else {
+			for _, id := range i.serverSessions {
+				if session, ok := i.sessions[robust.Id{Id: id}]; ok {
+					sessions = append(sessions, session)
+				}
+			}
+		}
+		for _, session := range sessions {
+			linkname := session.ircPrefix.Name
+			if !session.Server {
+				linkname = session.Nick + "[" + session.Username + "@" + session.ircPrefix.Host + "]"
+			}
+			open := s.LastActivity.Sub(time.Unix(0, session.Created))
+			// Like the command counters, see above.
+			received := "0"
+			if reply.nodeInfo != nil {
+				received = strconv.FormatUint(reply.nodeInfo.MessagesReceived[session.Id.Id], 10)
+			}
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.RPL_STATSLINKINFO,
+				Params: []string{
+					s.Nick,
+					linkname,
+					"0", // sendq
+					"0", // sent messages
+					"0", // sent kbytes
+					received,
+					"0", // received kbytes
+					strconv.FormatInt(int64(open/time.Second), 10),
+				},
+			})
+		}
+	}
+
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.RPL_ENDOFSTATS,
+		Params:  []string{s.Nick, query, "End of /STATS report"},
+	})
+}
+
+func (i *IRCServer) sendStatsOperators(s *Session, reply *Replyctx) {
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	for _, op := range i.Config.IRC.Operators {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.RPL_STATSOLINE,
+			Params:  []string{s.Nick, "O", "*", "*", op.Name},
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package ircserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestStats(t *testing.T) {
+	i, ids := stdIRCServerWithServices()
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("STATS u")),
+		":robustirc.net 481 sECuRE :Permission Denied - You're not an IRC operator")
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("OPER mero foo"))
+	i.sessions[ids["mero"]].LastActivity = i.ServerCreation.Add(50*time.Hour + 3*time.Minute + 7*time.Second)
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("STATS u")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 242 mero :Server Up 2 days 2:03:07"),
+			irc.ParseMessage(":robustirc.net 219 mero u :End of /STATS report"),
+		})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("STATS o")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 243 mero O * * mero"),
+			irc.ParseMessage(":robustirc.net 243 mero O * * xeen"),
+			irc.ParseMessage(":robustirc.net 219 mero o :End of /STATS report"),
+		})
+
+	// The node-local counters must not end up in the output stream.
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("STATS m")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 219 mero m :End of /STATS report"),
+		})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("STATS l")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 211 mero services.robustirc.net 0 0 0 0 0 56271444"),
+			irc.ParseMessage(":robustirc.net 219 mero l :End of /STATS report"),
+		})
+
+	info := &NodeInfo{
+		CommandCounts:    map[string]uint64{"PRIVMSG": 3, "OPER": 1},
+		MessagesReceived: map[uint64]uint64{ids["services"].Id: 2},
+	}
+	got, err := i.ProcessQueryWithNodeInfo(ids["mero"], irc.ParseMessage("STATS m"), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustMatchIrcmsgs(t, got, []*irc.Message{
+		irc.ParseMessage(":robustirc.net 212 mero OPER 1"),
+		irc.ParseMessage(":robustirc.net 212 mero PRIVMSG 3"),
+		irc.ParseMessage(":robustirc.net 219 mero m :End of /STATS report"),
+	})
+
+	got, err = i.ProcessQueryWithNodeInfo(ids["mero"], irc.ParseMessage("STATS l"), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustMatchIrcmsgs(t, got, []*irc.Message{
+		irc.ParseMessage(":robustirc.net 211 mero services.robustirc.net 0 0 0 2 0 56271444"),
+		irc.ParseMessage(":robustirc.net 219 mero l :End of /STATS report"),
+	})
+}
@@ -0,0 +1,81 @@
+package ircserver
+
+import (
+	"strings"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func init() {
+	Commands["TAGMSG"] = &ircCommand{
+		Func:      (*IRCServer).cmdTagmsg,
+		MinParams: 1,
+	}
+	// Tags are stripped when delivering messages to clients which did not
+	// enable message-tags (see StripTags).
+	registerCap("message-tags", capability{})
+}
+
+// cmdTagmsg relays the client-only tags of |msg| (e.g. typing notifications)
+// without any text, see https://ircv3.net/specs/extensions/message-tags
+// Like NOTICE, TAGMSG never results in error replies. Only recipients which
+// enabled message-tags receive TAGMSG, as it is meaningless without tags.
+func (i *IRCServer) cmdTagmsg(s *Session, reply *Replyctx, msg *irc.Message) {
+	if reply.clientTags == "" {
+		return
+	}
+
+	tagmsg := &irc.Message{
+		Prefix:  &s.ircPrefix,
+		Command: "TAGMSG",
+		Params:  []string{msg.Params[0]},
+	}
+
+	if status, channelname := splitStatusmsg(msg.Params[0]); strings.HasPrefix(channelname, "#") {
+		c, ok := i.channels[ChanToLower(channelname)]
+		if !ok {
+			return
+		}
+		if _, ok := c.nicks[NickToLower(s.Nick)]; !ok && c.modes['n'] {
+			return
+		}
+		if needsRegistration(c, s) {
+			return
+		}
+		sent := false
+		for nick, perms := range c.nicks {
+			if status != -1 && !hasStatus(perms, status) {
+				continue
+			}
+			session := i.nicks[nick]
+			if session == s || session.silenced(s) || !session.caps["message-tags"] {
+				continue
+			}
+			robustmsg := i.send(reply, tagmsg)
+			c.sequence(robustmsg)
+			robustmsg.InterestingFor[session.Id.Id] = true
+			sent = true
+		}
+		if sent {
+			i.tagReply(reply, tagmsg)
+		}
+		return
+	}
+
+	session, ok := i.nicks[NickToLower(msg.Params[0])]
+	if !ok || !session.caps["message-tags"] {
+		return
+	}
+
+	// To message invisible users, you must share a channel with them.
+	if session.modes['i'] && !sharesChannel(session, s) {
+		return
+	}
+
+	if session.silenced(s) {
+		return
+	}
+
+	i.sendUser(session, reply, tagmsg)
+	i.tagReply(reply, tagmsg)
+}
@@ -0,0 +1,74 @@
+package ircserver
+
+import (
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestTagmsg(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test"))
+	i.sessions[ids["secure"]].caps["message-tags"] = true
+	i.sessions[ids["mero"]].caps["message-tags"] = true
+
+	data := "@+typing=active;+unknown=1 TAGMSG #test"
+	reply := i.ProcessMessage(&robust.Message{Session: ids["secure"], Data: data}, ParseMessage(data))
+	if got, want := len(reply.Messages), 1; got != want {
+		t.Fatalf("TAGMSG resulted in %d messages, want %d", got, want)
+	}
+	if got, want := reply.Messages[0].Data, "@+typing=active :sECuRE!blah@robust/0x13b5aa0a2bcfb8ad TAGMSG #test"; got != want {
+		t.Fatalf("TAGMSG = %q, want %q", got, want)
+	}
+	// Only sessions which enabled the message-tags capability receive TAGMSG.
+	if got := reply.Messages[0].InterestingFor; got[ids["secure"].Id] || !got[ids["mero"].Id] || got[ids["xeen"].Id] {
+		t.Fatalf("TAGMSG delivered to %v, want mero", got)
+	}
+
+	// TAGMSG without valid client tags is dropped.
+	data = "@+typing=bored TAGMSG #test"
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"], Data: data}, ParseMessage(data)),
+		[]*irc.Message{})
+
+	data = "@+typing=active TAGMSG xeen"
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"], Data: data}, ParseMessage(data)),
+		[]*irc.Message{})
+
+	data = `@+react=\:+1\: TAGMSG mero`
+	reply = i.ProcessMessage(&robust.Message{Session: ids["secure"], Data: data}, ParseMessage(data))
+	if got, want := len(reply.Messages), 1; got != want {
+		t.Fatalf("TAGMSG resulted in %d messages, want %d", got, want)
+	}
+	if got, want := reply.Messages[0].Data, `@+react=\:+1\: :sECuRE!blah@robust/0x13b5aa0a2bcfb8ad TAGMSG mero`; got != want {
+		t.Fatalf("TAGMSG = %q, want %q", got, want)
+	}
+}
+
+func TestPrivmsgClientTags(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+
+	data := "@+typing=done PRIVMSG #test :hello"
+	reply := i.ProcessMessage(&robust.Message{Session: ids["secure"], Data: data}, ParseMessage(data))
+	if got, want := len(reply.Messages), 1; got != want {
+		t.Fatalf("PRIVMSG resulted in %d messages, want %d", got, want)
+	}
+	if got, want := reply.Messages[0].Data, "@+typing=done :sECuRE!blah@robust/0x13b5aa0a2bcfb8ad PRIVMSG #test hello"; got != want {
+		t.Fatalf("PRIVMSG = %q, want %q", got, want)
+	}
+
+	// Invalid tags are dropped.
+	data = "@+typing=bored;time=2015-01-02T19:50:18.166Z NOTICE mero :hello"
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"], Data: data}, ParseMessage(data)),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad NOTICE mero :hello")
+}
@@ -0,0 +1,24 @@
+package ircserver
+
+import (
+	"time"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func init() {
+	Commands["TIME"] = &ircCommand{
+		Func:     (*IRCServer).cmdTime,
+		ReadOnly: true,
+	}
+}
+
+func (i *IRCServer) cmdTime(s *Session, reply *Replyctx, msg *irc.Message) {
+	// The time of the message (not of processing it) keeps the output
+	// identical on all nodes.
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.RPL_TIME,
+		Params:  []string{s.Nick, i.ServerPrefix.Name, s.LastActivity.UTC().Format(time.RFC1123)},
+	})
+}
@@ -0,0 +1,17 @@
+package ircserver
+
+import (
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestTime(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("TIME")),
+		":robustirc.net 391 sECuRE robustirc.net :Fri, 02 Jan 2015 19:50:18 UTC")
+}
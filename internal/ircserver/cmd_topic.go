@@ -28,7 +28,7 @@ func (i *IRCServer) cmdTopic(s *Session, reply *Replyctx, msg *irc.Message) {
 
 	// “TOPIC :”, i.e. unset the topic.
 	if msg.Trailing() == "" && len(msg.Params) == 2 {
-		if c.modes['t'] && !c.nicks[NickToLower(s.Nick)][chanop] {
+		if c.modes['t'] && !hasStatus(c.nicks[NickToLower(s.Nick)], halfop) {
 			i.sendUser(s, reply, &irc.Message{
 				Prefix:  i.ServerPrefix,
 				Command: irc.ERR_CHANOPRIVSNEEDED,
@@ -90,7 +90,7 @@ func (i *IRCServer) cmdTopic(s *Session, reply *Replyctx, msg *irc.Message) {
 		return
 	}
 
-	if c.modes['t'] && !c.nicks[NickToLower(s.Nick)][chanop] {
+	if c.modes['t'] && !hasStatus(c.nicks[NickToLower(s.Nick)], halfop) {
 		i.sendUser(s, reply, &irc.Message{
 			Prefix:  i.ServerPrefix,
 			Command: irc.ERR_CHANOPRIVSNEEDED,
@@ -0,0 +1,69 @@
+package ircserver
+
+import (
+	"fmt"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func init() {
+	Commands["TRACE"] = &ircCommand{
+		Func:      (*IRCServer).cmdTrace,
+		MinParams: 1,
+		ReadOnly:  true,
+	}
+}
+
+func (i *IRCServer) cmdTrace(s *Session, reply *Replyctx, msg *irc.Message) {
+	if !s.Operator {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOPRIVILEGES,
+			Params:  []string{s.Nick, "Permission Denied - You're not an IRC operator"},
+		})
+		return
+	}
+
+	session, ok := i.nicks[NickToLower(msg.Params[0])]
+	if !ok {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOSUCHNICK,
+			Params:  []string{s.Nick, msg.Params[0], "No such nick/channel"},
+		})
+		return
+	}
+
+	class := "users"
+	if session.Operator {
+		class = "opers"
+	}
+	user := fmt.Sprintf("%s[%s@%s]", session.Nick, session.Username, session.ircPrefix.Host)
+
+	// Which node serves the GetMessages request of a session is not part of
+	// the (replicated) state, so it is only known when answering locally.
+	var details []string
+	if reply.nodeInfo == nil {
+		details = []string{"connection details unavailable"}
+	} else {
+		for _, conn := range reply.nodeInfo.Connections[session.Id.Id] {
+			details = append(details, fmt.Sprintf("node=%s remote=%s queue=%d", conn.Node, conn.RemoteAddr, conn.QueueDepth))
+		}
+		if len(details) == 0 {
+			details = []string{"not attached to any node"}
+		}
+	}
+	for _, detail := range details {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.RPL_TRACEUSER,
+			Params:  []string{s.Nick, "User", class, user, detail},
+		})
+	}
+
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.RPL_TRACEEND,
+		Params:  []string{s.Nick, i.ServerPrefix.Name, "v1", "End of TRACE"},
+	})
+}
@@ -0,0 +1,58 @@
+package ircserver
+
+import (
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestTrace(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("TRACE mero")),
+		":robustirc.net 481 sECuRE :Permission Denied - You're not an IRC operator")
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("OPER mero foo"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("TRACE bero")),
+		":robustirc.net 401 mero bero :No such nick/channel")
+
+	// Through raft, the connection details are not available.
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("TRACE secure")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 205 mero User users sECuRE[blah@robust/0x13b5aa0a2bcfb8ad] :connection details unavailable"),
+			irc.ParseMessage(":robustirc.net 262 mero robustirc.net v1 :End of TRACE"),
+		})
+
+	info := &NodeInfo{
+		Connections: map[uint64][]ConnectionInfo{
+			ids["secure"].Id: {
+				{Node: "node1:8001", RemoteAddr: "192.0.2.1", QueueDepth: 3},
+				{Node: "node2:8001", RemoteAddr: "192.0.2.1", QueueDepth: 0},
+			},
+		},
+	}
+	got, err := i.ProcessQueryWithNodeInfo(ids["mero"], irc.ParseMessage("TRACE secure"), info)
+	if err != nil {
+		t.Fatalf("ProcessQueryWithNodeInfo(TRACE): %v", err)
+	}
+	mustMatchIrcmsgs(t, got, []*irc.Message{
+		irc.ParseMessage(":robustirc.net 205 mero User users sECuRE[blah@robust/0x13b5aa0a2bcfb8ad] :node=node1:8001 remote=192.0.2.1 queue=3"),
+		irc.ParseMessage(":robustirc.net 205 mero User users sECuRE[blah@robust/0x13b5aa0a2bcfb8ad] :node=node2:8001 remote=192.0.2.1 queue=0"),
+		irc.ParseMessage(":robustirc.net 262 mero robustirc.net v1 :End of TRACE"),
+	})
+
+	got, err = i.ProcessQueryWithNodeInfo(ids["mero"], irc.ParseMessage("TRACE xeen"), info)
+	if err != nil {
+		t.Fatalf("ProcessQueryWithNodeInfo(TRACE): %v", err)
+	}
+	mustMatchIrcmsgs(t, got, []*irc.Message{
+		irc.ParseMessage(":robustirc.net 205 mero User users xeen[baz@robust/0x13b5aa0a2bcfb8af] :not attached to any node"),
+		irc.ParseMessage(":robustirc.net 262 mero robustirc.net v1 :End of TRACE"),
+	})
+}
@@ -11,6 +11,7 @@ func init() {
 	Commands["USERHOST"] = &ircCommand{
 		Func:      (*IRCServer).cmdUserhost,
 		MinParams: 1,
+		ReadOnly:  true,
 	}
 }
 
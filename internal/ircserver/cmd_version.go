@@ -0,0 +1,25 @@
+package ircserver
+
+import "gopkg.in/sorcix/irc.v2"
+
+// Version is the RobustIRC version reported by VERSION and INFO. It is set by
+// the main package.
+var Version = "unknown"
+
+func init() {
+	Commands["VERSION"] = &ircCommand{
+		Func:     (*IRCServer).cmdVersion,
+		ReadOnly: true,
+	}
+}
+
+func (i *IRCServer) cmdVersion(s *Session, reply *Replyctx, msg *irc.Message) {
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.RPL_VERSION,
+		Params:  []string{s.Nick, "RobustIRC-" + Version, i.ServerPrefix.Name, "https://robustirc.net/"},
+	})
+	// Like other IRC servers, repeat ISUPPORT so that clients can refresh
+	// their view of the server’s features.
+	i.sendISupport(s, reply)
+}
@@ -0,0 +1,20 @@
+package ircserver
+
+import (
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestVersion(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("VERSION")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 351 sECuRE RobustIRC-unknown robustirc.net :https://robustirc.net/"),
+			irc.ParseMessage(":robustirc.net 005 CHANTYPES=# CHANNELLEN=32 NICKLEN=30 MODES=1 CHANMODES=b,k,l,AOPRinstx PREFIX=(qaohv)~&@%+ KNOCK CPRIVMSG CNOTICE STATUSMSG=~&@%+ SILENCE=15 MONITOR=100 :are supported by this server"),
+		})
+}
@@ -8,7 +8,8 @@ import (
 
 func init() {
 	Commands["WHO"] = &ircCommand{
-		Func: (*IRCServer).cmdWho,
+		Func:     (*IRCServer).cmdWho,
+		ReadOnly: true,
 	}
 }
 
@@ -32,24 +33,21 @@ func (i *IRCServer) cmdWho(s *Session, reply *Replyctx, msg *irc.Message) {
 	}
 
 	c, ok := i.channels[ChanToLower(channelname)]
-	if !ok {
+	if !ok || !c.visibleTo(s) {
 		i.sendUser(s, reply, lastmsg)
 		return
 	}
 
-	if c.modes['s'] {
-		if _, ok := c.nicks[NickToLower(s.Nick)]; !ok {
-			i.sendUser(s, reply, lastmsg)
-			return
-		}
-	}
-
 	nicks := make([]string, 0, len(c.nicks))
 	for nick := range c.nicks {
+		if i.nicks[nick].hiddenFrom(s) {
+			continue
+		}
 		nicks = append(nicks, i.nicks[nick].Nick)
 	}
 
 	sort.Strings(nicks)
+	nicks, next := paginate(nicks, continuationToken(msg.Params), i.replyPageSize())
 
 	for _, nick := range nicks {
 		session := i.nicks[NickToLower(nick)]
@@ -66,5 +64,6 @@ func (i *IRCServer) cmdWho(s *Session, reply *Replyctx, msg *irc.Message) {
 		})
 	}
 
+	i.sendMoreResults(s, reply, irc.WHO, next)
 	i.sendUser(s, reply, lastmsg)
 }
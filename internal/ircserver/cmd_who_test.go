@@ -42,6 +42,16 @@ func TestWho(t *testing.T) {
 			irc.ParseMessage(":robustirc.net 315 mero #test :End of /WHO list"),
 		})
 
+	// IRC operators can see secret channels.
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("OPER xeen foo"))
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("WHO #test")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 352 xeen #test blah robust/0x13b5aa0a2bcfb8ad robustirc.net sECuRE H :0 Michael Stapelberg"),
+			irc.ParseMessage(":robustirc.net 315 xeen #test :End of /WHO list"),
+		})
+
 	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
 	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("AWAY :afk"))
 
@@ -74,3 +84,29 @@ func TestWho(t *testing.T) {
 			irc.ParseMessage(":robustirc.net 315 mero #test :End of /WHO list"),
 		})
 }
+
+func TestWhoInvisible(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("MODE xeen +i"))
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("WHO #test")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 352 mero #test blah robust/0x13b5aa0a2bcfb8ad robustirc.net sECuRE H :0 Michael Stapelberg"),
+			irc.ParseMessage(":robustirc.net 315 mero #test :End of /WHO list"),
+		})
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("WHO #test")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 352 mero #test foo robust/0x13b5aa0a2bcfb8ae robustirc.net mero H :0 Axel Wagner"),
+			irc.ParseMessage(":robustirc.net 352 mero #test blah robust/0x13b5aa0a2bcfb8ad robustirc.net sECuRE H :0 Michael Stapelberg"),
+			irc.ParseMessage(":robustirc.net 352 mero #test baz robust/0x13b5aa0a2bcfb8af robustirc.net xeen H :0 Iks Enn"),
+			irc.ParseMessage(":robustirc.net 315 mero #test :End of /WHO list"),
+		})
+}
@@ -9,10 +9,15 @@ import (
 	"gopkg.in/sorcix/irc.v2"
 )
 
+// rplWhoisAccount is the numeric which ircu, Charybdis and others use to
+// show the account a user is logged into.
+const rplWhoisAccount = "330"
+
 func init() {
 	Commands["WHOIS"] = &ircCommand{
 		Func:      (*IRCServer).cmdWhois,
 		MinParams: 1,
+		ReadOnly:  true,
 	}
 }
 
@@ -35,26 +40,30 @@ func (i *IRCServer) cmdWhois(s *Session, reply *Replyctx, msg *irc.Message) {
 
 	var channels []string
 	for channel := range session.Channels {
-		var prefix string
 		c := i.channels[channel]
-		if c.modes['s'] && !s.Operator && !s.Channels[channel] {
+		if !c.visibleTo(s) {
 			continue
 		}
-		if c.nicks[NickToLower(session.Nick)][chanop] {
-			prefix = "@"
-		}
-		channels = append(channels, prefix+c.name)
+		channels = append(channels, statusPrefix(c.nicks[NickToLower(session.Nick)])+c.name)
 	}
 
 	sort.Strings(channels)
 
 	if len(channels) > 0 {
-		// TODO(secure): this needs to be split into multiple messages if the line exceeds 510 bytes.
-		i.sendUser(s, reply, &irc.Message{
+		// Everything but the channel list, i.e.
+		// “:robustirc.net 319 sECuRE mero :”.
+		overhead := len((&irc.Message{
 			Prefix:  i.ServerPrefix,
 			Command: irc.RPL_WHOISCHANNELS,
-			Params:  []string{s.Nick, session.Nick, strings.Join(channels, " ")},
-		})
+			Params:  []string{s.Nick, session.Nick, "a b"},
+		}).Bytes()) - len("a b")
+		for _, line := range joinLimited(channels, maxLineLength-overhead) {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.RPL_WHOISCHANNELS,
+				Params:  []string{s.Nick, session.Nick, line},
+			})
+		}
 	}
 
 	i.sendUser(s, reply, &irc.Message{
@@ -71,6 +80,14 @@ func (i *IRCServer) cmdWhois(s *Session, reply *Replyctx, msg *irc.Message) {
 		})
 	}
 
+	if session.account != "" {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: rplWhoisAccount,
+			Params:  []string{s.Nick, session.Nick, session.account, "is logged in as"},
+		})
+	}
+
 	if session.AwayMsg != "" {
 		i.sendUser(s, reply, &irc.Message{
 			Prefix:  i.ServerPrefix,
@@ -101,3 +118,29 @@ func (i *IRCServer) cmdWhois(s *Session, reply *Replyctx, msg *irc.Message) {
 		Params:  []string{s.Nick, session.Nick, "End of /WHOIS list"},
 	})
 }
+
+// maxLineLength is the maximum length of an IRC message, excluding the
+// trailing CR-LF (RFC 2812, section 2.3).
+const maxLineLength = 510
+
+// joinLimited joins |items| with spaces, starting a new line whenever a line
+// would exceed |limit| bytes. Items which are longer than |limit| on their
+// own end up on a line by themselves.
+func joinLimited(items []string, limit int) []string {
+	var lines []string
+	var line strings.Builder
+	for _, item := range items {
+		if line.Len() > 0 && line.Len()+1+len(item) > limit {
+			lines = append(lines, line.String())
+			line.Reset()
+		}
+		if line.Len() > 0 {
+			line.WriteByte(' ')
+		}
+		line.WriteString(item)
+	}
+	if line.Len() > 0 {
+		lines = append(lines, line.String())
+	}
+	return lines
+}
@@ -1,6 +1,9 @@
 package ircserver
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -164,3 +167,29 @@ func TestWhois(t *testing.T) {
 			irc.ParseMessage(":robustirc.net 318 sECuRE mero :End of /WHOIS list"),
 		})
 }
+
+func TestWhoisManyChannels(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	var want []string
+	for c := 0; c < 40; c++ {
+		channel := fmt.Sprintf("#a-rather-long-channel-name-%02d", c)
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN "+channel))
+		want = append(want, "@"+channel)
+	}
+
+	var got []string
+	for _, msg := range i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("WHOIS mero")).Messages {
+		if len(msg.Data) > 510 {
+			t.Errorf("reply exceeds 510 bytes: %q", msg.Data)
+		}
+		ircmsg := irc.ParseMessage(msg.Data)
+		if ircmsg.Command != irc.RPL_WHOISCHANNELS {
+			continue
+		}
+		got = append(got, strings.Split(ircmsg.Trailing(), " ")...)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("RPL_WHOISCHANNELS: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package ircserver
+
+import (
+	"strconv"
+	"time"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func init() {
+	Commands["WHOWAS"] = &ircCommand{
+		Func:      (*IRCServer).cmdWhowas,
+		MinParams: 1,
+		ReadOnly:  true,
+	}
+}
+
+// whowasEntry is a nickname which was used by a session before it quit or
+// changed its nickname.
+type whowasEntry struct {
+	nick     string
+	username string
+	host     string
+	realname string
+	time     time.Time
+}
+
+// defaultWhowasHistory is used when Config.WhowasHistory is 0.
+const defaultWhowasHistory = 100
+
+func (i *IRCServer) whowasHistory() uint64 {
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	if i.Config.WhowasHistory == 0 {
+		return defaultWhowasHistory
+	}
+	return i.Config.WhowasHistory
+}
+
+// recordWhowas adds the current nickname of |s| to the WHOWAS history, which
+// retains the most recent Config.WhowasHistory entries.
+func (i *IRCServer) recordWhowas(s *Session) {
+	if !s.loggedIn || s.Server {
+		return
+	}
+	limit := i.whowasHistory()
+	i.whowas = append(i.whowas, whowasEntry{
+		nick:     s.Nick,
+		username: s.ircPrefix.User,
+		host:     s.ircPrefix.Host,
+		realname: s.Realname,
+		time:     s.LastActivity,
+	})
+	if uint64(len(i.whowas)) > limit {
+		excess := uint64(len(i.whowas)) - limit
+		// Copy instead of re-slicing so that the backing array does not
+		// grow without bounds.
+		i.whowas = append([]whowasEntry(nil), i.whowas[excess:]...)
+	}
+}
+
+func (i *IRCServer) cmdWhowas(s *Session, reply *Replyctx, msg *irc.Message) {
+	nick := NickToLower(msg.Params[0])
+	count := 0
+	if len(msg.Params) > 1 {
+		// Non-positive or invalid counts mean “all entries”, like in
+		// RFC 2812, section 3.6.3.
+		count, _ = strconv.Atoi(msg.Params[1])
+	}
+
+	found := 0
+	for idx := len(i.whowas) - 1; idx >= 0; idx-- {
+		entry := i.whowas[idx]
+		if NickToLower(entry.nick) != nick {
+			continue
+		}
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.RPL_WHOWASUSER,
+			Params:  []string{s.Nick, entry.nick, entry.username, entry.host, "*", entry.realname},
+		})
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.RPL_WHOISSERVER,
+			Params:  []string{s.Nick, entry.nick, i.ServerPrefix.Name, entry.time.UTC().Format(time.RFC1123)},
+		})
+		found++
+		if found == count {
+			break
+		}
+	}
+
+	if found == 0 {
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_WASNOSUCHNICK,
+			Params:  []string{s.Nick, msg.Params[0], "There was no such nickname"},
+		})
+	}
+
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.RPL_ENDOFWHOWAS,
+		Params:  []string{s.Nick, msg.Params[0], "End of WHOWAS"},
+	})
+}
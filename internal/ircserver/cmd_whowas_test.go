@@ -0,0 +1,82 @@
+package ircserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestWhowas(t *testing.T) {
+	i, ids := stdIRCServer()
+	i.Config.WhowasHistory = 10
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("WHOWAS mero")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 406 sECuRE mero :There was no such nickname"),
+			irc.ParseMessage(":robustirc.net 369 sECuRE mero :End of WHOWAS"),
+		})
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("NICK merovius"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("NICK mero"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("QUIT :bye"))
+
+	signon := time.Unix(0, 1420228218166687917).UTC().Format(time.RFC1123)
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("WHOWAS xeen")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 314 sECuRE xeen baz robust/0x13b5aa0a2bcfb8af * :Iks Enn"),
+			irc.ParseMessage(":robustirc.net 312 sECuRE xeen robustirc.net :" + signon),
+			irc.ParseMessage(":robustirc.net 369 sECuRE xeen :End of WHOWAS"),
+		})
+
+	// mero’s session still exists, but the nickname was used before.
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("WHOWAS merovius")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 314 sECuRE merovius foo robust/0x13b5aa0a2bcfb8ae * :Axel Wagner"),
+			irc.ParseMessage(":robustirc.net 312 sECuRE merovius robustirc.net :" + signon),
+			irc.ParseMessage(":robustirc.net 369 sECuRE merovius :End of WHOWAS"),
+		})
+
+	// The history retains only the most recent entries.
+	i.Config.WhowasHistory = 2
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("NICK mero2"))
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("WHOWAS merovius")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 406 sECuRE merovius :There was no such nickname"),
+			irc.ParseMessage(":robustirc.net 369 sECuRE merovius :End of WHOWAS"),
+		})
+
+	// The history survives snapshots.
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewIRCServer("robustirc.net", time.Now())
+	if _, err := restored.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+	mustMatchIrcmsgs(t,
+		restored.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("WHOWAS mero 1")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 314 sECuRE mero foo robust/0x13b5aa0a2bcfb8ae * :Axel Wagner"),
+			irc.ParseMessage(":robustirc.net 312 sECuRE mero robustirc.net :" + signon),
+			irc.ParseMessage(":robustirc.net 369 sECuRE mero :End of WHOWAS"),
+		})
+}
+
+func TestWhowasDefaultHistory(t *testing.T) {
+	i, ids := stdIRCServer()
+	// Configs which do not set WhowasHistory use the default.
+	i.Config.WhowasHistory = 0
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("NICK merovius"))
+	if got, want := len(i.whowas), 1; got != want {
+		t.Fatalf("len(whowas) = %d, want %d", got, want)
+	}
+}
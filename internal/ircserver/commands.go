@@ -28,6 +28,17 @@ type ircCommand struct {
 	// irc.ERR_NEEDMOREPARAMS is returned in case less than MinParams
 	// parameters were found, otherwise, Func is called.
 	MinParams int
+
+	// ReadOnly marks commands which only read IRCServer state (e.g. WHO). Func
+	// MUST NOT modify any state for these commands: they may be called with
+	// only a read lock held and their invocation is not necessarily part of
+	// the raft log (see ProcessQuery).
+	ReadOnly bool
+
+	// Uncompactable marks commands whose effect is not entirely described by
+	// the IRCServer state, so that compaction keeps the first message using
+	// such a command (and all messages after it) in the raft log.
+	Uncompactable bool
 }
 
 func init() {
@@ -36,18 +47,9 @@ func init() {
 	serviceAlias := &ircCommand{
 		Func: (*IRCServer).cmdServiceAlias,
 	}
-	Commands["NICKSERV"] = serviceAlias
-	Commands["CHANSERV"] = serviceAlias
-	Commands["OPERSERV"] = serviceAlias
-	Commands["MEMOSERV"] = serviceAlias
-	Commands["HOSTSERV"] = serviceAlias
-	Commands["BOTSERV"] = serviceAlias
-	Commands["NS"] = serviceAlias
-	Commands["CS"] = serviceAlias
-	Commands["OS"] = serviceAlias
-	Commands["MS"] = serviceAlias
-	Commands["HS"] = serviceAlias
-	Commands["BS"] = serviceAlias
+	for alias := range serviceAliases {
+		Commands[alias] = serviceAlias
+	}
 
 	if os.Getenv("ROBUSTIRC_TESTING_ENABLE_PANIC_COMMAND") == "1" {
 		Commands["PANIC"] = &ircCommand{
@@ -69,6 +71,16 @@ func (i *IRCServer) maybeLogin(s *Session, reply *Replyctx, msg *irc.Message) {
 		return
 	}
 
+	// Registration is suspended until the client sends CAP END.
+	if s.capNegotiating {
+		return
+	}
+
+	if k := i.klineFor(s, s.LastActivity); k != nil {
+		i.killKlined(s, reply, k)
+		return
+	}
+
 	if i.captchaRequiredForLogin() {
 		captcha := extractPassword(s.Pass, "captcha")
 		if err := i.verifyCaptcha(s, captcha); err != nil {
@@ -106,25 +118,10 @@ func (i *IRCServer) maybeLogin(s *Session, reply *Replyctx, msg *irc.Message) {
 	i.sendUser(s, reply, &irc.Message{
 		Prefix:  i.ServerPrefix,
 		Command: irc.RPL_MYINFO,
-		Params:  []string{s.Nick, i.ServerPrefix.Name + " v1 i nstix"},
+		Params:  []string{s.Nick, i.ServerPrefix.Name + " v1 ABi AOPnstix"},
 	})
 
-	// send ISUPPORT as per:
-	// http://www.irc.org/tech_docs/draft-brocklesby-irc-isupport-03.txt
-	// http://www.irc.org/tech_docs/005.html
-	i.sendUser(s, reply, &irc.Message{
-		Prefix:  i.ServerPrefix,
-		Command: "005",
-		Params: []string{
-			"CHANTYPES=#",
-			"CHANNELLEN=" + maxChannelLen,
-			"NICKLEN=" + maxNickLen,
-			"MODES=1",
-			"PREFIX=(o)@",
-			"KNOCK",
-			"are supported by this server",
-		},
-	})
+	i.sendISupport(s, reply)
 
 	i.sendServices(reply, &irc.Message{
 		Command: irc.NICK,
@@ -160,29 +157,76 @@ func (i *IRCServer) maybeLogin(s *Session, reply *Replyctx, msg *irc.Message) {
 	// accidental leaks less likely.
 	s.Pass = ""
 
+	i.cmdLusers(s, reply, msg)
 	i.cmdMotd(s, reply, msg)
+
+	i.sendSnotice(s, reply, snoticeConnect, "")
 }
 
-func (i *IRCServer) cmdServiceAlias(s *Session, reply *Replyctx, msg *irc.Message) {
-	aliases := map[string]string{
-		"NICKSERV": "PRIVMSG NickServ :",
-		"NS":       "PRIVMSG NickServ :",
-		"CHANSERV": "PRIVMSG ChanServ :",
-		"CS":       "PRIVMSG ChanServ :",
-		"OPERSERV": "PRIVMSG OperServ :",
-		"OS":       "PRIVMSG OperServ :",
-		"MEMOSERV": "PRIVMSG MemoServ :",
-		"MS":       "PRIVMSG MemoServ :",
-		"HOSTSERV": "PRIVMSG HostServ :",
-		"HS":       "PRIVMSG HostServ :",
-		"BOTSERV":  "PRIVMSG BotServ :",
-		"BS":       "PRIVMSG BotServ :",
+// sendISupport sends ISUPPORT as per:
+// http://www.irc.org/tech_docs/draft-brocklesby-irc-isupport-03.txt
+// http://www.irc.org/tech_docs/005.html
+func (i *IRCServer) sendISupport(s *Session, reply *Replyctx) {
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: "005",
+		Params: append(append([]string{
+			"CHANTYPES=#",
+			"CHANNELLEN=" + maxChannelLen,
+			"NICKLEN=" + maxNickLen,
+			"MODES=1",
+			"CHANMODES=" + channelModeTable.chanmodes(),
+			"PREFIX=" + isupportPrefix(),
+			"KNOCK",
+			"CPRIVMSG",
+			"CNOTICE",
+			"STATUSMSG=" + statusmsgPrefixes(),
+			fmt.Sprintf("SILENCE=%d", maxSilence),
+			fmt.Sprintf("MONITOR=%d", maxMonitor),
+		}, i.isupportLimits()...), "are supported by this server"),
+	})
+}
+
+// serviceAliases maps the service alias commands (e.g. NS) to the PRIVMSG
+// they are expanded to.
+var serviceAliases = map[string]string{
+	"NICKSERV": "PRIVMSG NickServ :",
+	"NS":       "PRIVMSG NickServ :",
+	"CHANSERV": "PRIVMSG ChanServ :",
+	"CS":       "PRIVMSG ChanServ :",
+	"OPERSERV": "PRIVMSG OperServ :",
+	"OS":       "PRIVMSG OperServ :",
+	"MEMOSERV": "PRIVMSG MemoServ :",
+	"MS":       "PRIVMSG MemoServ :",
+	"HOSTSERV": "PRIVMSG HostServ :",
+	"HS":       "PRIVMSG HostServ :",
+	"BOTSERV":  "PRIVMSG BotServ :",
+	"BS":       "PRIVMSG BotServ :",
+}
+
+// IsServiceAlias returns whether |command| is expanded to a PRIVMSG to a
+// service, e.g. NS IDENTIFY to PRIVMSG NickServ :IDENTIFY.
+func IsServiceAlias(command string) bool {
+	_, ok := serviceAliases[strings.ToUpper(command)]
+	return ok
+}
+
+// IsCompactable returns false if |command| (or its server-to-server variant)
+// is marked Uncompactable. Unknown commands are compactable, as
+// ProcessMessage does not modify the state for them.
+func IsCompactable(command string) bool {
+	command = strings.ToUpper(command)
+	if cmd, ok := lookupCommand(command); ok && cmd.Uncompactable {
+		return false
 	}
-	for alias, expanded := range aliases {
-		if strings.ToUpper(msg.Command) != alias {
-			continue
-		}
+	if cmd, ok := Commands["server_"+command]; ok && cmd.Uncompactable {
+		return false
+	}
+	return true
+}
+
+func (i *IRCServer) cmdServiceAlias(s *Session, reply *Replyctx, msg *irc.Message) {
+	if expanded, ok := serviceAliases[strings.ToUpper(msg.Command)]; ok {
 		i.cmdPrivmsg(s, reply, irc.ParseMessage(expanded+strings.Join(msg.Params, " ")))
-		return
 	}
 }
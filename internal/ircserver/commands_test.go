@@ -3,6 +3,8 @@ package ircserver
 import (
 	"testing"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/robustirc/robustirc/internal/robust"
 	"gopkg.in/sorcix/irc.v2"
 )
 
@@ -50,6 +52,29 @@ func TestNormalizeModes(t *testing.T) {
 				{Mode: "+x", Param: ""},
 			},
 		},
+		{
+			Input: irc.ParseMessage("MODE #chan +kl-b key 5"),
+			Want: []modeCmd{
+				{Mode: "+k", Param: "key"},
+				{Mode: "+l", Param: "5"},
+				{Mode: "-b", Param: ""},
+			},
+		},
+		{
+			Input: irc.ParseMessage("MODE #chan -lk+o key secure"),
+			Want: []modeCmd{
+				{Mode: "-l", Param: ""},
+				{Mode: "-k", Param: "key"},
+				{Mode: "+o", Param: "secure"},
+			},
+		},
+		{
+			Input: irc.ParseMessage("MODE #chan +Zt"),
+			Want: []modeCmd{
+				{Mode: "+Z", Param: ""},
+				{Mode: "+t", Param: ""},
+			},
+		},
 		{
 			Input: irc.ParseMessage("MODE #chan"),
 			Want:  nil,
@@ -58,7 +83,7 @@ func TestNormalizeModes(t *testing.T) {
 
 	for _, entry := range table {
 		want := entry.Want
-		got := normalizeModes(entry.Input)
+		got := channelModeTable.normalize(entry.Input)
 		failed := len(got) != len(want)
 		for idx := 0; !failed && idx < len(want); idx++ {
 			failed = (got[idx].Mode != want[idx].Mode ||
@@ -73,8 +98,63 @@ func TestNormalizeModes(t *testing.T) {
 			for _, mode := range want {
 				t.Logf("    %q %q\n", mode.Mode, mode.Param)
 			}
-			t.Fatalf("normalizeModes() return value does not match expectation: got %v, want %v", got, want)
+			t.Fatalf("normalize() return value does not match expectation: got %v, want %v", got, want)
 		}
 
 	}
 }
+
+func TestUserModeTable(t *testing.T) {
+	got := userModeTable.normalize(irc.ParseMessage("SVSMODE secure +rd 42"))
+	want := []modeCmd{
+		{Mode: "+r", Param: ""},
+		{Mode: "+d", Param: "42"},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("normalize() = %v, want %v", got, want)
+	}
+}
+
+func TestChanmodes(t *testing.T) {
+	if got, want := channelModeTable.chanmodes(), "b,k,l,AOPRinstx"; got != want {
+		t.Fatalf("chanmodes() = %q, want %q", got, want)
+	}
+}
+
+func TestReadOnlyCommandsDoNotModifyState(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("AWAY :afk"))
+
+	for name, cmd := range Commands {
+		if !cmd.ReadOnly {
+			continue
+		}
+		for _, params := range []string{"", " #test", " mero", " secure mero", " #nonexistant"} {
+			before, err := i.CanonicalState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := i.ProcessQuery(ids["xeen"], irc.ParseMessage(name+params)); err == ErrNotReadOnly {
+				t.Fatalf("%s%s is marked ReadOnly, but sent messages to other sessions", name, params)
+			}
+			after, err := i.CanonicalState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(before, after) {
+				t.Fatalf("%s%s is marked ReadOnly, but ProcessQuery modified state:\nbefore: %v\nafter: %v", name, params, before, after)
+			}
+			i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage(name+params))
+			after, err = i.CanonicalState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(before, after) {
+				t.Fatalf("%s%s is marked ReadOnly, but modified state:\nbefore: %v\nafter: %v", name, params, before, after)
+			}
+		}
+	}
+}
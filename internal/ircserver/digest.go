@@ -0,0 +1,82 @@
+package ircserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+
+	"github.com/robustirc/robustirc/internal/robust"
+)
+
+// StateDigest returns a hex-encoded SHA-256 hash of the IRC state which
+// clients can observe: sessions (nick, user, host, realname, modes, away
+// message, channels) and channels (topic, modes, key, limit, members and
+// their statuses, bans). The digest does not depend on map iteration order,
+// so all nodes which applied the same log entries return the same digest.
+func (i *IRCServer) StateDigest() string {
+	i.sessionsMu.RLock()
+	defer i.sessionsMu.RUnlock()
+
+	h := sha256.New()
+
+	ids := make([]robust.Id, 0, len(i.sessions))
+	for id := range i.sessions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		if ids[a].Id != ids[b].Id {
+			return ids[a].Id < ids[b].Id
+		}
+		return ids[a].Reply < ids[b].Reply
+	})
+	for _, id := range ids {
+		s := i.sessions[id]
+		fmt.Fprintf(h, "session %d.%d %q %q %q %q %v %q\n",
+			id.Id, id.Reply, s.Nick, s.ircPrefix.User, s.ircPrefix.Host, s.Realname, s.Operator, s.AwayMsg)
+		digestModes(h, s.modes[:])
+		channels := make([]string, 0, len(s.Channels))
+		for c := range s.Channels {
+			channels = append(channels, string(c))
+		}
+		sort.Strings(channels)
+		fmt.Fprintf(h, "channels %q\n", channels)
+	}
+
+	names := make([]string, 0, len(i.channels))
+	for name := range i.channels {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := i.channels[lcChan(name)]
+		fmt.Fprintf(h, "channel %q %q %q %d %q %d\n",
+			c.name, c.topic, c.topicNick, c.topicTime.Unix(), c.key, c.limit)
+		digestModes(h, c.modes[:])
+		nicks := make([]string, 0, len(c.nicks))
+		for nick := range c.nicks {
+			nicks = append(nicks, string(nick))
+		}
+		sort.Strings(nicks)
+		for _, nick := range nicks {
+			fmt.Fprintf(h, "member %q %v\n", nick, *c.nicks[lcNick(nick)])
+		}
+		for _, b := range c.bans {
+			fmt.Fprintf(h, "ban %q\n", b.pattern)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// digestModes writes the set modes in |modes| (indexed by mode letter) to |h|.
+func digestModes(h hash.Hash, modes []bool) {
+	var set []byte
+	for mode, value := range modes {
+		if value {
+			set = append(set, byte(mode))
+		}
+	}
+	fmt.Fprintf(h, "modes %q\n", set)
+}
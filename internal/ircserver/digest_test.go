@@ -0,0 +1,53 @@
+package ircserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestStateDigest(t *testing.T) {
+	setup := func() (*IRCServer, map[string]robust.Id) {
+		i, ids := stdIRCServer()
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #other"))
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +kv key mero"))
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("TOPIC #test :hello"))
+		return i, ids
+	}
+
+	i, ids := setup()
+	other, _ := setup()
+	digest := i.StateDigest()
+	if got := other.StateDigest(); got != digest {
+		t.Fatalf("StateDigest() of identical states differs: got %q, want %q", got, digest)
+	}
+
+	// The digest survives snapshots.
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewIRCServer("robustirc.net", time.Now())
+	if _, err := restored.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.StateDigest(); got != digest {
+		t.Fatalf("StateDigest() after Unmarshal: got %q, want %q", got, digest)
+	}
+
+	// Messages which do not modify the state do not change the digest.
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("PRIVMSG #test :hey"))
+	if got := i.StateDigest(); got != digest {
+		t.Fatalf("StateDigest() after PRIVMSG: got %q, want %q", got, digest)
+	}
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test -v mero"))
+	if got := i.StateDigest(); got == digest {
+		t.Fatalf("StateDigest() did not change after MODE -v")
+	}
+}
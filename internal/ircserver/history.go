@@ -0,0 +1,45 @@
+package ircserver
+
+import (
+	"time"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// historyEntry is a PRIVMSG or NOTICE which was sent to a channel.
+type historyEntry struct {
+	prefix  string
+	command string
+	text    string
+	time    time.Time
+}
+
+func (i *IRCServer) channelHistory() uint64 {
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	return i.Config.ChannelHistory
+}
+
+// recordHistory adds |msg|, which |s| sent to all members of |c|, to the
+// history of |c|, which retains the most recent Config.ChannelHistory
+// entries. As the history is part of the state, it survives compaction and
+// can be replayed using CHATHISTORY.
+func (i *IRCServer) recordHistory(c *channel, s *Session, msg *irc.Message) {
+	limit := i.channelHistory()
+	if limit == 0 {
+		c.history = nil
+		return
+	}
+	c.history = append(c.history, historyEntry{
+		prefix:  msg.Prefix.String(),
+		command: msg.Command,
+		text:    msg.Trailing(),
+		time:    s.LastActivity,
+	})
+	if uint64(len(c.history)) > limit {
+		excess := uint64(len(c.history)) - limit
+		// Copy instead of re-slicing so that the backing array does not
+		// grow without bounds.
+		c.history = append([]historyEntry(nil), c.history[excess:]...)
+	}
+}
@@ -0,0 +1,74 @@
+package ircserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestChannelHistory(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+
+	// The history is disabled by default.
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("PRIVMSG #test :disabled"))
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("CHATHISTORY LATEST #test * 10")),
+		[]*irc.Message{})
+
+	i.Config.ChannelHistory = 2
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("PRIVMSG #test :first"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("PRIVMSG #test :second"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NOTICE #TEST :third"))
+	// Messages to a subset of the channel members are not recorded.
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("PRIVMSG @#test :ops only"))
+	// Neither are private messages.
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("PRIVMSG mero :private"))
+
+	want := []*irc.Message{
+		irc.ParseMessage(":mero!foo@robust/0x13b5aa0a2bcfb8ae PRIVMSG #test :second"),
+		irc.ParseMessage(":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad NOTICE #test :third"),
+	}
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("CHATHISTORY LATEST #Test * 10")),
+		want)
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("CHATHISTORY LATEST #test * 1")),
+		want[1:])
+
+	// The history survives snapshots.
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewIRCServer("robustirc.net", time.Now())
+	if _, err := restored.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+	mustMatchIrcmsgs(t,
+		restored.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("CHATHISTORY LATEST #test * 10")),
+		want)
+
+	// Clients which enabled server-time receive the original time.
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("CAP REQ :server-time"))
+	reply := i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("CHATHISTORY LATEST #test * 1"))
+	if got, want := reply.Messages[0].Data, AddServerTime(want[1].String(), time.Unix(0, int64(ids["secure"].Id))); got != want {
+		t.Fatalf("CHATHISTORY with server-time: got %q, want %q", got, want)
+	}
+
+	// Only channel members can retrieve the history.
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("CHATHISTORY LATEST #test * 10")),
+		":robustirc.net FAIL CHATHISTORY INVALID_TARGET LATEST #test :Messages could not be retrieved")
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("CHATHISTORY BEFORE #test * 10")),
+		":robustirc.net FAIL CHATHISTORY INVALID_PARAMS BEFORE :Only LATEST with * is supported")
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("CHATHISTORY LATEST #test * 0")),
+		":robustirc.net FAIL CHATHISTORY INVALID_PARAMS LATEST :Invalid limit")
+}
@@ -0,0 +1,140 @@
+package ircserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// importableModes are the channel modes which ImportChannel can set.
+const importableModes = "AOPinst"
+
+// ImportedChannel describes a channel which is migrated from a different IRC
+// server, see ImportChannel.
+type ImportedChannel struct {
+	Name string
+
+	Topic     string    `json:",omitempty"`
+	TopicNick string    `json:",omitempty"` // defaults to the server name
+	TopicTime time.Time `json:",omitempty"` // defaults to the time of the import
+
+	// Modes are channel mode characters without a leading “+”, e.g. “nt”.
+	Modes string `json:",omitempty"`
+
+	// Bans are ban masks such as “*!*@example.net”.
+	Bans []string `json:",omitempty"`
+
+	// Persistent channels (mode +P) are retained when their last member
+	// leaves.
+	Persistent bool `json:",omitempty"`
+}
+
+// Validate returns an error if |c| cannot be imported.
+func (c *ImportedChannel) Validate() error {
+	if !IsValidChannel(c.Name) {
+		return fmt.Errorf("%q is not a valid channel name", c.Name)
+	}
+	for _, mode := range c.Modes {
+		if !strings.ContainsRune(importableModes, mode) {
+			return fmt.Errorf("%s: mode %q cannot be imported (supported: %s)", c.Name, mode, importableModes)
+		}
+	}
+	for _, mask := range c.Bans {
+		if mask == "" {
+			return fmt.Errorf("%s: empty ban mask", c.Name)
+		}
+	}
+	return nil
+}
+
+// ImportChannel creates or updates a channel as described by the
+// ImportedChannel which is JSON-encoded in msg.Data. Modes and bans are added
+// to those which the channel already has. Members of an existing channel are
+// notified about the changes.
+func (i *IRCServer) ImportChannel(msg *robust.Message) (*Replyctx, error) {
+	var imported ImportedChannel
+	if err := json.Unmarshal([]byte(msg.Data), &imported); err != nil {
+		return nil, err
+	}
+	if err := imported.Validate(); err != nil {
+		return nil, err
+	}
+
+	i.sessionsMu.Lock()
+	defer i.sessionsMu.Unlock()
+
+	reply := &Replyctx{msgid: msg.Id.Id, timestamp: msg.Timestamp()}
+	defer i.translateLinks(reply)
+	lc := ChanToLower(imported.Name)
+	c, ok := i.channels[lc]
+	if !ok {
+		c = &channel{
+			name:  imported.Name,
+			nicks: make(map[lcNick]*[maxChanMemberStatus]bool),
+			ts:    msg.Timestamp().Unix(),
+		}
+		i.channels[lc] = c
+	}
+
+	modes := imported.Modes
+	if imported.Persistent {
+		modes += "P"
+	}
+	added := "+"
+	var params []string
+	for _, mode := range modes {
+		if !c.modes[mode] {
+			c.modes[mode] = true
+			added += string(mode)
+		}
+	}
+	seen := make(map[string]bool, len(c.bans))
+	for _, b := range c.bans {
+		seen[b.pattern] = true
+	}
+	for _, mask := range imported.Bans {
+		if seen[mask] {
+			continue
+		}
+		seen[mask] = true
+		// Same conversion as in cmdMode: only “*” is a repetition operator.
+		pattern := strings.Replace(regexp.QuoteMeta(mask), "\\*", ".*", -1)
+		if err := ban(c, true, mask, pattern, i.ServerPrefix.Name, msg.Timestamp()); err != nil {
+			return nil, err
+		}
+		added += "b"
+		params = append(params, mask)
+	}
+	if added != "+" {
+		i.sendChannel(c, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.MODE,
+			Params:  append([]string{c.name, added}, params...),
+		})
+	}
+
+	if imported.Topic != "" && imported.Topic != c.topic {
+		c.topic = imported.Topic
+		c.topicNick = imported.TopicNick
+		if c.topicNick == "" {
+			c.topicNick = i.ServerPrefix.Name
+		}
+		c.topicTime = imported.TopicTime
+		if c.topicTime.IsZero() {
+			c.topicTime = msg.Timestamp()
+		}
+		i.sendChannel(c, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.TOPIC,
+			Params:  []string{c.name, c.topic},
+		})
+	}
+
+	return reply, nil
+}
@@ -0,0 +1,88 @@
+package ircserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestImportChannel(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	reply, err := i.ImportChannel(&robust.Message{
+		Id:       robust.Id{Id: 1},
+		Type:     robust.ChannelImport,
+		UnixNano: 1481144012969203276,
+		Data:     `{"Name": "#test", "Topic": "welcome", "TopicNick": "mero", "Modes": "nt", "Bans": ["*!*@evil"], "Persistent": true}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(reply.Messages); got != 2 {
+		t.Fatalf("unexpected number of replies: got %d, want 2", got)
+	}
+	for _, msg := range reply.Messages {
+		if len(msg.InterestingFor) > 0 {
+			t.Fatalf("reply %q unexpectedly sent to %v", msg.Data, msg.InterestingFor)
+		}
+	}
+
+	// Joining an imported channel does not grant channel operator status.
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NAMES #test")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 353 sECuRE = #test sECuRE"),
+			irc.ParseMessage(":robustirc.net 366 sECuRE #test :End of /NAMES list."),
+		})
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test")),
+		":robustirc.net 324 sECuRE #test +Pnt")
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("TOPIC #test")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 332 sECuRE #test :welcome"),
+			irc.ParseMessage(":robustirc.net 333 sECuRE #test mero 1481144012"),
+		})
+
+	// Re-importing is idempotent, additions are sent to channel members.
+	reply, err = i.ImportChannel(&robust.Message{
+		Id:   robust.Id{Id: 2},
+		Type: robust.ChannelImport,
+		Data: `{"Name": "#TEST", "Modes": "ti", "Bans": ["*!*@evil", "*!*@worse"], "Persistent": true}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustMatchMsg(t, reply, ":robustirc.net MODE #test +ib *!*@worse")
+
+	// Persistent channels survive their last member leaving and snapshots.
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("PART #test"))
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewIRCServer("robustirc.net", time.Now())
+	if _, err := restored.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := restored.NumChannels(), 1; got != want {
+		t.Fatalf("NumChannels() after PART: got %d, want %d", got, want)
+	}
+
+	if _, err := i.ImportChannel(&robust.Message{
+		Type: robust.ChannelImport,
+		Data: `{"Name": "#test", "Modes": "x"}`,
+	}); err == nil {
+		t.Fatalf("ImportChannel unexpectedly succeeded with unsupported mode x")
+	}
+	if _, err := i.ImportChannel(&robust.Message{
+		Type: robust.ChannelImport,
+		Data: `{"Name": "test"}`,
+	}); err == nil {
+		t.Fatalf("ImportChannel unexpectedly succeeded with invalid channel name")
+	}
+}
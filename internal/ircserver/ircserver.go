@@ -129,6 +129,22 @@ type Session struct {
 
 	invitedTo map[lcChan]bool
 
+	// caps contains the IRCv3 capabilities which the client enabled via
+	// CAP REQ. capNegotiating is true between CAP LS/REQ and CAP END during
+	// registration. Both are replicated (and part of snapshots) so that
+	// the client sees the same behavior regardless of which node serves
+	// its GetMessages request.
+	caps           map[string]bool
+	capNegotiating bool
+
+	// silence contains the masks of users whose PRIVMSG and NOTICE
+	// messages are not delivered to this session, see SILENCE.
+	silence []banPattern
+
+	// monitor contains the nicknames watched via MONITOR, keyed by their
+	// lower-case form. See also IRCServer.monitors.
+	monitor map[lcNick]string
+
 	// We waste 65 bytes per session for clearer code (being able to directly
 	// access modes by using their letter as an index).
 	modes ['z']bool
@@ -151,6 +167,36 @@ type Session struct {
 	deleted bool
 
 	RemoteAddr string // network address of the most recent message
+
+	// Hostname is the reverse DNS name of the session’s address, as
+	// resolved by the raft leader (see SetHostname). When set, it is used as
+	// the host part of ircPrefix.
+	Hostname string
+
+	// cloakedHost is shown as the host part of ircPrefix instead of the
+	// real host while user mode +x is set, see cloakHost.
+	cloakedHost string
+
+	// certFingerprint is the SHA-256 fingerprint of the TLS client
+	// certificate which was presented with the message which is currently
+	// being processed, see robust.Message.CertFingerprint. Not part of
+	// snapshots, as it is set for every message.
+	certFingerprint string
+
+	// account is the account which the session logged into via SASL.
+	account string
+
+	// saslMechanism is the mechanism of an ongoing AUTHENTICATE exchange.
+	saslMechanism string
+
+	// uid is the TS6 user id of the session (assigned when first needed, see
+	// uidFor) or, for server sessions which link using TS6, the server id of
+	// the services server.
+	uid string
+
+	// link is the server-to-server protocol of server sessions, see
+	// linkProtocols.
+	link string
 }
 
 // updateIrcPrefix MUST be called whenever the Nick field changes.
@@ -158,21 +204,124 @@ func (s *Session) updateIrcPrefix() {
 	s.ircPrefix = irc.Prefix{
 		Name: s.Nick,
 		User: s.Username,
-		// Similar to FreeNode’s “unaffiliated/foo”, so clients should already
-		// support this format.
-		Host: fmt.Sprintf("robust/0x%x", s.Id.Id),
+		Host: s.realHost(),
+	}
+	if s.modes['x'] && s.cloakedHost != "" {
+		s.ircPrefix.Host = s.cloakedHost
 	}
 }
 
+// realHost returns the host part of the session’s prefix without cloaking.
+func (s *Session) realHost() string {
+	if s.Hostname != "" {
+		return s.Hostname
+	}
+	// Similar to FreeNode’s “unaffiliated/foo”, so clients should already
+	// support this format.
+	return fmt.Sprintf("robust/0x%x", s.Id.Id)
+}
+
+// cloakHost returns the cloaked version of |host|. The cloak is derived from
+// |key| (see config.Network.CloakKey), so that it is the same on all nodes
+// and for all sessions coming from |host|, but cannot be reversed without
+// knowing |key|.
+func cloakHost(key []byte, host string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(host))
+	return fmt.Sprintf("cloak/%x", mac.Sum(nil)[:8])
+}
+
+func (i *IRCServer) cloakKey() []byte {
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	return i.Config.CloakKey
+}
+
+// Member statuses, i.e. indexes into channel.nicks. New statuses must be
+// appended, as the indexes are part of snapshots.
 const (
 	chanop = iota
 	voice
+	halfop
+	chanadmin
+	chanowner
 	maxChanMemberStatus
 )
 
+// memberStatuses lists the member statuses from highest to lowest rank with
+// their channel mode and prefix, see PREFIX in ISUPPORT.
+var memberStatuses = []struct {
+	status int
+	mode   rune
+	prefix string
+}{
+	{chanowner, 'q', "~"},
+	{chanadmin, 'a', "&"},
+	{chanop, 'o', "@"},
+	{halfop, 'h', "%"},
+	{voice, 'v', "+"},
+}
+
+// statusRank returns the rank of |status| (0 is the highest rank).
+func statusRank(status int) int {
+	for rank, ms := range memberStatuses {
+		if ms.status == status {
+			return rank
+		}
+	}
+	return len(memberStatuses)
+}
+
+// highestStatus returns the highest status in |perms|, or -1 if |perms| (which
+// is nil for non-members) contains none.
+func highestStatus(perms *[maxChanMemberStatus]bool) int {
+	if perms == nil {
+		return -1
+	}
+	for _, ms := range memberStatuses {
+		if perms[ms.status] {
+			return ms.status
+		}
+	}
+	return -1
+}
+
+// hasStatus returns whether |perms| contains |status| or a higher-ranked
+// status, e.g. channel owners can do everything chanops can do.
+func hasStatus(perms *[maxChanMemberStatus]bool, status int) bool {
+	highest := highestStatus(perms)
+	return highest != -1 && statusRank(highest) <= statusRank(status)
+}
+
+// statusPrefix returns the prefix of the highest status in |perms| (e.g. “@”
+// for chanops), or the empty string.
+func statusPrefix(perms *[maxChanMemberStatus]bool) string {
+	highest := highestStatus(perms)
+	if highest == -1 {
+		return ""
+	}
+	return memberStatuses[statusRank(highest)].prefix
+}
+
+// isupportPrefix returns the value of the PREFIX ISUPPORT token, i.e.
+// “(qaohv)~&@%+”.
+func isupportPrefix() string {
+	var modes, prefixes string
+	for _, ms := range memberStatuses {
+		modes += string(ms.mode)
+		prefixes += ms.prefix
+	}
+	return "(" + modes + ")" + prefixes
+}
+
 type banPattern struct {
 	re      *regexp.Regexp
 	pattern string
+
+	// setBy and set are only tracked for channel bans (mode +b), see
+	// RPL_BANLIST.
+	setBy string
+	set   time.Time
 }
 
 type channel struct {
@@ -191,6 +340,51 @@ type channel struct {
 	modes ['z']bool
 
 	bans []banPattern
+
+	// key is the channel key which users need to specify when joining
+	// (mode +k), or empty.
+	key string
+
+	// limit is the maximum number of channel members (mode +l), or 0.
+	limit int
+
+	// seq is the sequence number of the last message sent to this channel,
+	// see robust.Message.Seq.
+	seq uint64
+
+	// ts is the creation time of the channel (unix seconds), which decides
+	// whose modes win when services link using TS6.
+	ts int64
+
+	// history are the most recent PRIVMSG and NOTICE messages sent to the
+	// channel, oldest first, see recordHistory.
+	history []historyEntry
+}
+
+// visibleTo returns whether |s| may learn about |c|, i.e. its members, topic
+// and existence. Secret channels (mode +s) are only visible to their members
+// and to IRC operators. All commands which reveal channel information (e.g.
+// WHO, WHOIS, NAMES, LIST) must check this.
+func (c *channel) visibleTo(s *Session) bool {
+	return !c.modes['s'] || s.Operator || s.Channels[ChanToLower(c.name)]
+}
+
+// sharesChannel returns whether |a| and |b| are members of at least one
+// common channel.
+func sharesChannel(a, b *Session) bool {
+	for channelname := range a.Channels {
+		if b.Channels[channelname] {
+			return true
+		}
+	}
+	return false
+}
+
+// hiddenFrom returns whether |member| must be omitted from WHO and NAMES
+// replies for |s|: invisible users (user mode +i) are only listed to users
+// with whom they share a channel and to IRC operators.
+func (member *Session) hiddenFrom(s *Session) bool {
+	return member.modes['i'] && member != s && !s.Operator && !sharesChannel(member, s)
 }
 
 // svshold stores nickname reservations set by services, e.g. for reserving the
@@ -210,7 +404,9 @@ type IRCServer struct {
 
 	// serverSessions is a slice that contains the IDs of all sessions that
 	// represent server-to-server connections, so that they can efficiently be
-	// added in e.g. interestJoin.
+	// added in e.g. interestJoin. It is kept sorted (see addServerSession), as
+	// translateLinks must process multiple links in the same order on all
+	// nodes.
 	serverSessions []uint64
 
 	// nicks maps from nicknames in lower-case (e.g. NickToLower("sECuRE")) to
@@ -225,6 +421,36 @@ type IRCServer struct {
 
 	svsholds map[lcNick]svshold
 
+	// monitors maps from nicknames in lower-case to the sessions which
+	// watch that nickname via MONITOR. It is the inverse of Session.monitor
+	// and is not part of snapshots (but rebuilt from Session.monitor).
+	monitors map[lcNick]map[robust.Id]bool
+
+	// snotices rate-limits the server notices sent to IRC operators.
+	snotices snoticeState
+
+	// whowas is the nickname history for WHOWAS, oldest entry first.
+	whowas []whowasEntry
+
+	// klines are the bans added via KLINE and GLINE, in the order in which
+	// they were added.
+	klines []kline
+
+	// rehashRequested is the id of the REHASH request which the raft
+	// leader has not yet fulfilled by applying a new configuration, or 0.
+	rehashRequested uint64
+
+	// lastUID is the counter from which TS6 user ids are derived, see uidFor.
+	lastUID uint64
+
+	// svsnoop is set while services disabled IRC operator privileges, see
+	// cmdServerSvsnoop.
+	svsnoop bool
+
+	// shutdownRequest is set by DIE and RESTART until the state machine
+	// retrieves it via TakeShutdownRequest. Not part of snapshots.
+	shutdownRequest *ShutdownRequest
+
 	// ServerPrefix is the prefix for output messages that come from the
 	// server, as opposed to from a client.
 	ServerPrefix *irc.Prefix
@@ -247,6 +473,7 @@ func NewIRCServer(networkname string, serverCreation time.Time) *IRCServer {
 	return &IRCServer{
 		channels:        make(map[lcChan]*channel),
 		svsholds:        make(map[lcNick]svshold),
+		monitors:        make(map[lcNick]map[robust.Id]bool),
 		nicks:           make(map[lcNick]*Session),
 		sessions:        make(map[robust.Id]*Session),
 		sessionsMu:      &sync.RWMutex{},
@@ -291,6 +518,8 @@ func (i *IRCServer) createSessionLocked(id robust.Id, auth string, timestamp tim
 		auth:         auth,
 		Channels:     make(map[lcChan]bool),
 		invitedTo:    make(map[lcChan]bool),
+		caps:         make(map[string]bool),
+		monitor:      make(map[lcNick]string),
 		Created:      timestamp.UnixNano(),
 		LastActivity: timestamp,
 		LastNonPing:  timestamp,
@@ -299,16 +528,54 @@ func (i *IRCServer) createSessionLocked(id robust.Id, auth string, timestamp tim
 	return nil
 }
 
+// SetHostname sets the hostname of session msg.Session to msg.Data. The
+// hostname is resolved by the raft leader outside of the state machine (see
+// package resolver), so that applying messages does not depend on DNS.
+// Sessions which already logged in keep their prefix, as other clients have
+// already seen it.
+func (i *IRCServer) SetHostname(msg *robust.Message) error {
+	if msg.Data == "" || strings.ContainsAny(msg.Data, " !@:,*?") {
+		return fmt.Errorf("invalid hostname %q", msg.Data)
+	}
+
+	i.sessionsMu.Lock()
+	defer i.sessionsMu.Unlock()
+
+	s, err := i.getSessionLocked(msg.Session)
+	if err != nil {
+		return err
+	}
+	if s.loggedIn {
+		return nil
+	}
+	s.Hostname = msg.Data
+	s.updateIrcPrefix()
+	return nil
+}
+
 // DeleteSession deletes the specified session. Called from the IRC server
 // itself (when processing QUIT or KILL) or from the API (DELETE request coming
-// from the bridge).
-func (i *IRCServer) deleteSessionLocked(s *Session, msgid uint64) {
+// from the bridge). |reason| is included in the server notice to operators.
+func (i *IRCServer) deleteSessionLocked(s *Session, reply *Replyctx, reason string) {
+	if s.loggedIn {
+		i.sendSnotice(s, reply, snoticeDisconnect, reason)
+	}
+	i.recordWhowas(s)
 	for _, c := range i.channels {
 		delete(c.nicks, NickToLower(s.Nick))
 
 		i.maybeDeleteChannelLocked(c)
 	}
 	delete(i.nicks, NickToLower(s.Nick))
+	if s.Server {
+		i.removeServerSession(s.Id.Id)
+	}
+	for nick := range s.monitor {
+		i.removeMonitorLocked(s, nick)
+	}
+	if s.Nick != "" {
+		i.sendMonitors(s.Nick, nil, reply)
+	}
 	// Instead of deleting the session here, we defer that to SendMessages, as
 	// SendMessages calls the Interesting function of each reply (such as a
 	// QUIT reply) and that function might still need access to the session to
@@ -403,7 +670,8 @@ func extractPassword(password, prefix string) string {
 }
 
 func (i *IRCServer) maybeDeleteChannelLocked(c *channel) {
-	if len(c.nicks) > 0 {
+	// Persistent channels (+P) are retained even when empty.
+	if len(c.nicks) > 0 || c.modes['P'] {
 		return
 	}
 	lc := ChanToLower(c.name)
@@ -428,7 +696,9 @@ func (i *IRCServer) ProcessMessage(msg *robust.Message, ircmsg *irc.Message) *Re
 
 	// alias for convenience
 	s := i.sessions[msg.Session]
-	reply := &Replyctx{msgid: msg.Id.Id, session: s}
+	reply := &Replyctx{msgid: msg.Id.Id, timestamp: msg.Timestamp(), session: s}
+	defer i.localizeNumerics(reply)
+	defer i.translateLinks(reply)
 
 	if ircmsg == nil {
 		i.sendUser(s, reply, &irc.Message{
@@ -440,6 +710,8 @@ func (i *IRCServer) ProcessMessage(msg *robust.Message, ircmsg *irc.Message) *Re
 	}
 
 	command := strings.ToUpper(ircmsg.Command)
+	s.certFingerprint = msg.CertFingerprint
+	reply.clientTags = clientTags(msg.Data)
 	if msg.RemoteAddr != "" && msg.RemoteAddr != s.RemoteAddr {
 		s.RemoteAddr = msg.RemoteAddr
 		if reason := i.Banned(s.RemoteAddr); reason != "" {
@@ -447,19 +719,30 @@ func (i *IRCServer) ProcessMessage(msg *robust.Message, ircmsg *irc.Message) *Re
 				Command: irc.ERROR,
 				Params:  []string{"Closing Link: You are banned (" + reason + ")"},
 			})
-			i.deleteSessionLocked(s, reply.msgid)
+			i.deleteSessionLocked(s, reply, "Banned: "+reason)
+			return reply
+		}
+		if k := i.klineFor(s, s.LastActivity); k != nil {
+			i.killKlined(s, reply, k)
 			return reply
 		}
 	}
 
 	messagesProcessed.WithLabelValues(command).Inc()
 
+	if !s.loggedIn && !s.Server && s.uid != "" && command == "CAPAB" {
+		// Sent by services which link using TS6 between PASS and SERVER.
+		return reply
+	}
+
 	if !s.loggedIn && !s.Server &&
 		command != irc.NICK &&
 		command != irc.USER &&
 		command != irc.PASS &&
 		command != irc.QUIT &&
-		command != irc.SERVER {
+		command != irc.SERVER &&
+		command != irc.CAP &&
+		command != irc.AUTHENTICATE {
 		i.sendUser(s, reply, &irc.Message{
 			Prefix:  i.ServerPrefix,
 			Command: irc.ERR_NOTREGISTERED,
@@ -470,7 +753,7 @@ func (i *IRCServer) ProcessMessage(msg *robust.Message, ircmsg *irc.Message) *Re
 				Command: irc.ERROR,
 				Params:  []string{"Closing Link: You have not registered within 10 minutes"},
 			})
-			i.deleteSessionLocked(s, reply.msgid)
+			i.deleteSessionLocked(s, reply, "Registration timeout")
 		}
 		return reply
 	}
@@ -478,8 +761,24 @@ func (i *IRCServer) ProcessMessage(msg *robust.Message, ircmsg *irc.Message) *Re
 	var serverPrefix string
 	if s.Server {
 		serverPrefix = "server_"
+		if ircmsg = linkProtocols[s.link].translateIn(i, s, reply, ircmsg); ircmsg == nil {
+			return reply
+		}
+		command = strings.ToUpper(ircmsg.Command)
+		// With multiple linked servers, one server must not act on behalf
+		// of another server or its clients.
+		if ircmsg.Prefix != nil {
+			if owner := i.prefixOwner(ircmsg.Prefix); owner != nil && owner != s {
+				i.sendUser(s, reply, &irc.Message{
+					Prefix:  i.ServerPrefix,
+					Command: irc.NOTICE,
+					Params:  []string{s.ircPrefix.Name, fmt.Sprintf("Ignoring %s: %s belongs to %s", command, ircmsg.Prefix.Name, owner.ircPrefix.Name)},
+				})
+				return reply
+			}
+		}
 	}
-	cmd, ok := Commands[serverPrefix+command]
+	cmd, ok := lookupCommand(serverPrefix + command)
 	if !ok {
 		i.sendUser(s, reply, &irc.Message{
 			Prefix:  i.ServerPrefix,
@@ -499,6 +798,21 @@ func (i *IRCServer) ProcessMessage(msg *robust.Message, ircmsg *irc.Message) *Re
 	}
 
 	cmd.Func(i, s, reply, ircmsg)
+	if cmd.ReadOnly {
+		// Rejected just like in ProcessQuery, so that the output of a
+		// ReadOnly command does not depend on whether it went through raft.
+		if err := checkReadOnly(s, reply); err != nil {
+			log.Printf("BUG: %s: %v", command, err)
+			reply.Messages = nil
+			reply.lastmsg = nil
+			reply.linked = nil
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.NOTICE,
+				Params:  []string{s.Nick, fmt.Sprintf("Rejecting %s: %v", command, err)},
+			})
+		}
+	}
 	return reply
 }
 
@@ -645,6 +959,15 @@ func (i *IRCServer) NumChannels() int {
 	return len(i.channels)
 }
 
+// SecretChannel returns whether |channelname| currently exists and is secret
+// (mode +s), i.e. must not be revealed to non-members.
+func (i *IRCServer) SecretChannel(channelname string) bool {
+	i.sessionsMu.RLock()
+	defer i.sessionsMu.RUnlock()
+	c, ok := i.channels[ChanToLower(channelname)]
+	return ok && c.modes['s']
+}
+
 // Replyctx is a reply context, i.e. information necessary when replying to an
 // IRC message. A reply context object will be passed to all cmd* functions and
 // the send* functions use it to keep track of the replyid for example.
@@ -654,9 +977,25 @@ type Replyctx struct {
 	session  *Session
 	Messages []*robust.Message
 
+	// timestamp is the time at which the message being processed was
+	// accepted by raft, see robust.Message.Timestamp.
+	timestamp time.Time
+
 	// lastmsg tracks the last sent message, so that send() can return the same
 	// message multiple times when being called in a continuation.
 	lastmsg *irc.Message
+
+	// linked are the messages which already are in the protocol of the
+	// linked server they are sent to, see sendLinkRaw and translateLinks.
+	linked map[*robust.Message]bool
+
+	// nodeInfo is only set for queries which are answered locally, see
+	// ProcessQueryWithNodeInfo.
+	nodeInfo *NodeInfo
+
+	// clientTags are the valid client-only tags of the message which is
+	// being processed, see clientTags().
+	clientTags string
 }
 
 // send converts |msg| into a robust.Message and appends it to |reply|.
@@ -706,9 +1045,43 @@ func (i *IRCServer) sendCommonChannels(user *Session, reply *Replyctx, msg *irc.
 	return msg
 }
 
+// sendCommonChannelsWithCap sends |msg| to all users who share a channel with
+// |user| and enabled |capability|, excluding |user|.
+func (i *IRCServer) sendCommonChannelsWithCap(user *Session, reply *Replyctx, capability string, msg *irc.Message) {
+	var robustmsg *robust.Message
+	for channelname := range user.Channels {
+		c, ok := i.channels[channelname]
+		if !ok {
+			continue
+		}
+		for nick := range c.nicks {
+			session := i.nicks[nick]
+			if session == user || !session.caps[capability] {
+				continue
+			}
+			if robustmsg == nil {
+				robustmsg = i.send(reply, msg)
+			}
+			robustmsg.InterestingFor[session.Id.Id] = true
+		}
+	}
+}
+
+// sequence assigns the next sequence number of |c| to |robustmsg|, unless
+// |robustmsg| already has one (i.e. it was returned again by send()).
+func (c *channel) sequence(robustmsg *robust.Message) {
+	if robustmsg.Seq != 0 {
+		return
+	}
+	c.seq++
+	robustmsg.Target = c.name
+	robustmsg.Seq = c.seq
+}
+
 // sendChannel sends |msg| to all users who are in |c|.
 func (i *IRCServer) sendChannel(c *channel, reply *Replyctx, msg *irc.Message) *irc.Message {
 	robustmsg := i.send(reply, msg)
+	c.sequence(robustmsg)
 	for nick := range c.nicks {
 		robustmsg.InterestingFor[i.nicks[nick].Id.Id] = true
 	}
@@ -716,11 +1089,32 @@ func (i *IRCServer) sendChannel(c *channel, reply *Replyctx, msg *irc.Message) *
 }
 
 // sendChannelButOne sends |msg| to all users who are in |c|, except for |user|
+// and users who silenced |user|.
 func (i *IRCServer) sendChannelButOne(c *channel, user *Session, reply *Replyctx, msg *irc.Message) *irc.Message {
 	robustmsg := i.send(reply, msg)
+	c.sequence(robustmsg)
 	for nick := range c.nicks {
 		session := i.nicks[nick]
-		if session == user {
+		if session == user || session.silenced(user) {
+			continue
+		}
+		robustmsg.InterestingFor[session.Id.Id] = true
+	}
+	return msg
+}
+
+// sendChannelStatusButOne is like sendChannelButOne, but only sends |msg| to
+// users who have at least |status| in |c| (STATUSMSG), e.g. chanops receive
+// messages addressed to voiced users, too.
+func (i *IRCServer) sendChannelStatusButOne(c *channel, status int, user *Session, reply *Replyctx, msg *irc.Message) *irc.Message {
+	robustmsg := i.send(reply, msg)
+	c.sequence(robustmsg)
+	for nick, perms := range c.nicks {
+		if !hasStatus(perms, status) {
+			continue
+		}
+		session := i.nicks[nick]
+		if session == user || session.silenced(user) {
 			continue
 		}
 		robustmsg.InterestingFor[session.Id.Id] = true
@@ -737,6 +1131,14 @@ func (i *IRCServer) sendServices(reply *Replyctx, msg *irc.Message) *irc.Message
 	return msg
 }
 
+// ResolveHostnames returns whether the raft leader should resolve the
+// hostnames of new sessions, see SetHostname.
+func (i *IRCServer) ResolveHostnames() bool {
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	return i.Config.ResolveHostnames
+}
+
 func (i *IRCServer) TrustedBridge(authHeader string) string {
 	if authHeader == "" {
 		return ""
@@ -746,6 +1148,20 @@ func (i *IRCServer) TrustedBridge(authHeader string) string {
 	return i.Config.TrustedBridges[authHeader]
 }
 
+// ServiceCertFingerprint returns the pinned certificate fingerprint of the
+// service identified by |password| (possibly empty) and whether such a service
+// is configured at all.
+func (i *IRCServer) ServiceCertFingerprint(password string) (string, bool) {
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	for _, service := range i.Config.IRC.Services {
+		if service.Password == password {
+			return service.CertFingerprint, true
+		}
+	}
+	return "", false
+}
+
 func (i *IRCServer) captchaConfigured() bool {
 	i.ConfigMu.RLock()
 	defer i.ConfigMu.RUnlock()
@@ -758,6 +1174,12 @@ func (i *IRCServer) captchaRequiredForLogin() bool {
 	return i.Config.CaptchaRequiredForLogin
 }
 
+func (i *IRCServer) guestNickOnCollision() bool {
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	return i.Config.GuestNickOnCollision
+}
+
 func (i *IRCServer) generateCaptchaURL(s *Session, purpose string) string {
 	challenge := []byte(s.auth[:8])
 
@@ -866,6 +1288,12 @@ func (i *IRCServer) ChannelLimit() uint64 {
 	return i.Config.MaxChannels
 }
 
+func (i *IRCServer) channelsPerUserLimit() uint64 {
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	return i.Config.MaxChannelsPerUser
+}
+
 func (i *IRCServer) OriginWhitelisted(origin string) bool {
 	i.ConfigMu.RLock()
 	defer i.ConfigMu.RUnlock()
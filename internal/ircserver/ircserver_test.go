@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net/url"
+	"reflect"
 	"testing"
 	"time"
 
@@ -459,9 +460,11 @@ func TestCaptchaLogin(t *testing.T) {
 			irc.ParseMessage(":robustirc.net 001 attacker :Welcome to RobustIRC!"),
 			irc.ParseMessage(":robustirc.net 002 attacker :Your host is robustirc.net"),
 			irc.ParseMessage(":robustirc.net 003 attacker :This server was created 2016-12-07 20:53:32.969203276 +0000 UTC"),
-			irc.ParseMessage(":robustirc.net 004 attacker :robustirc.net v1 i nstix"),
-			irc.ParseMessage(":robustirc.net 005 CHANTYPES=# CHANNELLEN=32 NICKLEN=30 MODES=1 PREFIX=(o)@ KNOCK :are supported by this server"),
+			irc.ParseMessage(":robustirc.net 004 attacker :robustirc.net v1 ABi AOPnstix"),
+			irc.ParseMessage(":robustirc.net 005 CHANTYPES=# CHANNELLEN=32 NICKLEN=30 MODES=1 CHANMODES=b,k,l,AOPRinstx PREFIX=(qaohv)~&@%+ KNOCK CPRIVMSG CNOTICE STATUSMSG=~&@%+ SILENCE=15 MONITOR=100 :are supported by this server"),
 			irc.ParseMessage("NICK attacker 1 1 attacker robust/0x13b5aa0a2bcfb8af robustirc.net 0 + :a"),
+			irc.ParseMessage(":robustirc.net 251 attacker :There are 4 users and 0 invisible on 1 servers"),
+			irc.ParseMessage(":robustirc.net 255 attacker :I have 4 clients and 0 servers"),
 			irc.ParseMessage(":robustirc.net 375 attacker :- robustirc.net Message of the day -"),
 			irc.ParseMessage(":robustirc.net 372 attacker :- No MOTD configured yet."),
 			irc.ParseMessage(":robustirc.net 376 attacker :End of MOTD command"),
@@ -503,5 +506,165 @@ func TestChannelLimit(t *testing.T) {
 
 	mustMatchMsg(t,
 		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #second")),
-		":robustirc.net 403 xeen #second :No such channel")
+		":robustirc.net 405 xeen #second :Cannot create channel, the network reached its channel limit")
+}
+
+func TestSessionPriority(t *testing.T) {
+	i, ids := stdIRCServerWithServices()
+
+	// Users must not be able to raise their own priority.
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("MODE xeen +B")),
+		":robustirc.net 481 xeen :Permission Denied - You're not an IRC operator")
+	if got, want := i.SessionPriority(ids["xeen"]), PriorityUser; got != want {
+		t.Fatalf("SessionPriority(xeen) after MODE +B: got %v, want %v", got, want)
+	}
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("OPER mero foo"))
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("MODE xeen +B")),
+		":mero!foo@robust/0x13b5aa0a2bcfb8ae MODE xeen :+B")
+	i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("SVSMODE secure +B"))
+
+	for _, tc := range []struct {
+		nick string
+		want Priority
+	}{
+		{"secure", PriorityBot},
+		{"xeen", PriorityBot},
+		{"mero", PriorityOperator},
+		{"services", PriorityServices},
+	} {
+		if got := i.SessionPriority(ids[tc.nick]); got != tc.want {
+			t.Errorf("SessionPriority(%s): got %v, want %v", tc.nick, got, tc.want)
+		}
+	}
+
+	if got, want := i.SessionPriority(robust.Id{Id: 1}), PriorityUser; got != want {
+		t.Errorf("SessionPriority(unknown): got %v, want %v", got, want)
+	}
+}
+
+func TestChannelSequence(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #other"))
+
+	seq := func(i *IRCServer, session robust.Id, data string) (string, uint64) {
+		reply := i.ProcessMessage(&robust.Message{Session: session}, irc.ParseMessage(data))
+		last := reply.Messages[len(reply.Messages)-1]
+		return last.Target, last.Seq
+	}
+
+	_, first := seq(i, ids["secure"], "PRIVMSG #test :hey")
+	for want := first + 1; want < first+4; want++ {
+		if target, got := seq(i, ids["secure"], "PRIVMSG #test :hey"); target != "#test" || got != want {
+			t.Fatalf("PRIVMSG #test: got %s/%d, want #test/%d", target, got, want)
+		}
+	}
+	if target, got := seq(i, ids["xeen"], "PRIVMSG #other :hey"); target != "#other" || got >= first {
+		t.Fatalf("PRIVMSG #other: got %s/%d, want #other/<%d", target, got, first)
+	}
+	if target, got := seq(i, ids["secure"], "PRIVMSG mero :hey"); target != "" || got != 0 {
+		t.Fatalf("PRIVMSG mero: got %s/%d, want no sequence number", target, got)
+	}
+
+	// The sequence numbers must survive snapshots.
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewIRCServer("robustirc.net", time.Now())
+	if _, err := restored.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+	if target, got := seq(restored, ids["mero"], "PRIVMSG #test :hey"); target != "#test" || got != first+4 {
+		t.Fatalf("PRIVMSG #test after restore: got %s/%d, want #test/%d", target, got, first+4)
+	}
+}
+
+func TestChannelsPerUserLimit(t *testing.T) {
+	i, ids := stdIRCServer()
+	i.Config.MaxChannelsPerUser = 2
+
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #first,#second"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #third")),
+		":robustirc.net 405 xeen #third :You have joined too many channels")
+
+	// Joining a channel the user already is in does not count.
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #first")),
+		[]*irc.Message{})
+
+	if got, want := i.isupportLimits(), []string{"CHANLIMIT=#:2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("isupportLimits() = %v, want %v", got, want)
+	}
+
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("PART #first"))
+	if got := i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #third")); len(got.Messages) == 0 || irc.ParseMessage(got.Messages[0].Data).Command != irc.JOIN {
+		t.Fatalf("JOIN after PART did not succeed: %v", got.Messages)
+	}
+}
+
+func TestSetHostname(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	id := robust.Id{Id: 1420228218166687555}
+	i.CreateSession(id, "auth-resolved", time.Unix(0, int64(id.Id)))
+
+	if err := i.SetHostname(&robust.Message{Session: id, Data: "evil!host"}); err == nil {
+		t.Fatalf("SetHostname(%q) did not return an error", "evil!host")
+	}
+	if err := i.SetHostname(&robust.Message{Session: id, Data: "client.example.net"}); err != nil {
+		t.Fatal(err)
+	}
+	i.ProcessMessage(&robust.Message{Session: id}, irc.ParseMessage("NICK resolved"))
+	i.ProcessMessage(&robust.Message{Session: id}, irc.ParseMessage("USER resolved 0 * :Resolved"))
+	i.ProcessMessage(&robust.Message{Session: id}, irc.ParseMessage("JOIN #test"))
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: id}, irc.ParseMessage("PRIVMSG secure :hey")),
+		":resolved!resolved@client.example.net PRIVMSG secure :hey")
+
+	// The prefix of logged-in sessions does not change.
+	if err := i.SetHostname(&robust.Message{Session: ids["secure"], Data: "late.example.net"}); err != nil {
+		t.Fatal(err)
+	}
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("PRIVMSG resolved :hey")),
+		":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad PRIVMSG resolved :hey")
+
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewIRCServer("robustirc.net", time.Now())
+	if _, err := restored.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+	restored.ProcessMessage(&robust.Message{Session: id}, irc.ParseMessage("NICK renamed"))
+	mustMatchMsg(t,
+		restored.ProcessMessage(&robust.Message{Session: id}, irc.ParseMessage("PRIVMSG secure :hey")),
+		":renamed!resolved@client.example.net PRIVMSG secure :hey")
+}
+
+func TestSecretChannel(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	if i.SecretChannel("#test") {
+		t.Fatalf("SecretChannel(#test) = true for a non-existing channel")
+	}
+
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #test"))
+	if i.SecretChannel("#TEST") {
+		t.Fatalf("SecretChannel(#TEST) = true before setting +s")
+	}
+
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("MODE #test +s"))
+	if !i.SecretChannel("#TEST") {
+		t.Fatalf("SecretChannel(#TEST) = false after setting +s")
+	}
 }
@@ -0,0 +1,305 @@
+package ircserver
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// linkProtocol is a server-to-server protocol which services use to link to
+// the network. Internally, services messages are handled by the server_
+// commands, which implement the RobustIRC protocol (a subset of the
+// UnrealIRCd protocol). Other protocols translate from and to it.
+type linkProtocol interface {
+	// burst introduces the network (this server, its users and channels)
+	// to the newly linked services server |s|.
+	burst(i *IRCServer, s *Session, reply *Replyctx)
+
+	// translateIn converts |msg|, which was received from |s|, into the
+	// RobustIRC protocol. A nil return value means that |msg| was handled
+	// entirely (or is not relevant).
+	translateIn(i *IRCServer, s *Session, reply *Replyctx, msg *irc.Message) *irc.Message
+
+	// translateOut converts |msg|, which is addressed to services (or to
+	// one of their clients), into the protocol spoken by |s|. A nil return
+	// value means that |msg| is not delivered to |s|.
+	translateOut(i *IRCServer, s *Session, msg *irc.Message) *irc.Message
+}
+
+// linkProtocols maps the value of Session.link to the protocol
+// implementation. The empty string is the RobustIRC protocol.
+var linkProtocols = map[string]linkProtocol{
+	"":    robustLink{},
+	"ts6": ts6Link{},
+}
+
+// sendLinkRaw sends |msg|, which already is in the protocol spoken by |s|,
+// to the services server |s| only.
+func (i *IRCServer) sendLinkRaw(s *Session, reply *Replyctx, msg *irc.Message) {
+	robustmsg := i.send(reply, msg)
+	robustmsg.InterestingFor[s.Id.Id] = true
+	if reply.linked == nil {
+		reply.linked = make(map[*robust.Message]bool)
+	}
+	reply.linked[robustmsg] = true
+}
+
+// translateLinks replaces each message in |reply| which is addressed to a
+// services server that does not speak the RobustIRC protocol with its
+// translation (if any). It runs after a message was processed, so that
+// the send functions do not need to know about linked protocols.
+func (i *IRCServer) translateLinks(reply *Replyctx) {
+	var links []*Session
+	for _, serverid := range i.serverSessions {
+		if s, ok := i.sessions[robust.Id{Id: serverid}]; ok && s.link != "" {
+			links = append(links, s)
+		}
+	}
+	if len(links) == 0 {
+		return
+	}
+	messages := make([]*robust.Message, 0, len(reply.Messages))
+	for _, robustmsg := range reply.Messages {
+		messages = append(messages, robustmsg)
+		if reply.linked[robustmsg] {
+			continue
+		}
+		for _, s := range links {
+			if !robustmsg.InterestingFor[s.Id.Id] {
+				continue
+			}
+			delete(robustmsg.InterestingFor, s.Id.Id)
+			msg := ParseMessage(robustmsg.Data)
+			if msg == nil {
+				continue
+			}
+			if translated := linkProtocols[s.link].translateOut(i, s, msg); translated != nil {
+				messages = append(messages, &robust.Message{
+					Data:           string(translated.Bytes()),
+					InterestingFor: map[uint64]bool{s.Id.Id: true},
+				})
+			}
+		}
+	}
+	// Renumber the replies, as translations were inserted.
+	for idx, robustmsg := range messages {
+		robustmsg.Id = robust.Id{Id: reply.msgid, Reply: uint64(idx + 1)}
+	}
+	reply.Messages = messages
+	reply.replyid = uint64(len(messages))
+}
+
+// robustLink is the RobustIRC protocol, see
+// https://github.com/robustirc/anope-robustirc
+type robustLink struct{}
+
+func (robustLink) burst(i *IRCServer, s *Session, reply *Replyctx) {
+	i.sendUser(s, reply, &irc.Message{
+		Command: "SERVER",
+		Params: []string{
+			i.ServerPrefix.Name,
+			"1",  // hopcount
+			"23", // token, must be different from the services token
+		},
+	})
+	for _, nick := range i.burstNicks() {
+		session := i.nicks[nick]
+		modestr := "+"
+		for mode := 'A'; mode < 'z'; mode++ {
+			if session.modes[mode] {
+				modestr += string(mode)
+			}
+		}
+		i.sendUser(s, reply, &irc.Message{
+			Command: irc.NICK,
+			Params: []string{
+				session.Nick,
+				"1", // hopcount (ignored by anope)
+				"1", // timestamp
+				session.Username,
+				session.ircPrefix.Host,
+				i.linkServerName(session),
+				session.svid,
+				modestr,
+				session.Realname,
+			},
+		})
+		channelnames := make([]string, 0, len(session.Channels))
+		for channelname := range session.Channels {
+			channelnames = append(channelnames, string(channelname))
+		}
+		sort.Strings(channelnames)
+		for _, channelname := range channelnames {
+			var prefix string
+
+			if hasStatus(i.channels[lcChan(channelname)].nicks[NickToLower(session.Nick)], chanop) {
+				prefix = prefix + string('@')
+			}
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: "SJOIN",
+				Params:  []string{"1", i.channels[lcChan(channelname)].name, prefix + session.Nick},
+			})
+		}
+	}
+	channelnames := make([]string, 0, len(i.channels))
+	for channelname := range i.channels {
+		channelnames = append(channelnames, string(channelname))
+	}
+	sort.Strings(channelnames)
+	// Channel modes, bans and member statuses other than chanop (which is
+	// part of SJOIN), so that services which reconnect are in sync.
+	for _, channelname := range channelnames {
+		if modes := i.burstModes(i.channels[lcChan(channelname)]); len(modes) > 0 {
+			i.sendUser(s, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.MODE,
+				Params:  append([]string{i.channels[lcChan(channelname)].name}, modes.IRCParams()...),
+			})
+		}
+	}
+	// Topics, so that services can restore them (including setter and set
+	// time) after a restart, see cmdServerTb.
+	for _, channelname := range channelnames {
+		c := i.channels[lcChan(channelname)]
+		if c.topic == "" {
+			continue
+		}
+		i.sendUser(s, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: "TB",
+			Params:  []string{c.name, strconv.FormatInt(c.topicTime.Unix(), 10), c.topicNick, c.topic},
+		})
+	}
+}
+
+// burstModes returns the modes of |c| which robustLink.burst sends in
+// addition to SJOIN, or nil if |c| has no members to burst.
+func (i *IRCServer) burstModes(c *channel) modeCmds {
+	var nicks []string
+	for nick := range c.nicks {
+		if session, ok := i.nicks[nick]; ok && session.loggedIn && !session.Server && session.Id.Reply == 0 {
+			nicks = append(nicks, string(nick))
+		}
+	}
+	if len(nicks) == 0 {
+		return nil
+	}
+	sort.Strings(nicks)
+	var modes modeCmds
+	for mode := 'A'; mode < 'z'; mode++ {
+		if !c.modes[mode] {
+			continue
+		}
+		cmd := modeCmd{Mode: "+" + string(mode)}
+		switch mode {
+		case 'k':
+			cmd.Param = c.key
+		case 'l':
+			cmd.Param = strconv.Itoa(c.limit)
+		}
+		modes = append(modes, cmd)
+	}
+	for _, nick := range nicks {
+		for _, ms := range memberStatuses {
+			if ms.status != chanop && c.nicks[lcNick(nick)][ms.status] {
+				modes = append(modes, modeCmd{Mode: "+" + string(ms.mode), Param: i.nicks[lcNick(nick)].Nick})
+			}
+		}
+	}
+	for _, ban := range c.bans {
+		modes = append(modes, modeCmd{Mode: "+b", Param: ban.pattern})
+	}
+	return modes
+}
+
+func (robustLink) translateIn(i *IRCServer, s *Session, reply *Replyctx, msg *irc.Message) *irc.Message {
+	return msg
+}
+
+func (robustLink) translateOut(i *IRCServer, s *Session, msg *irc.Message) *irc.Message {
+	return msg
+}
+
+// addServerSession registers |s| as a server-to-server connection.
+func (i *IRCServer) addServerSession(s *Session) {
+	i.serverSessions = append(i.serverSessions, s.Id.Id)
+	sort.Slice(i.serverSessions, func(a, b int) bool { return i.serverSessions[a] < i.serverSessions[b] })
+}
+
+// removeServerSession unregisters the server-to-server connection |id|,
+// e.g. when services quit.
+func (i *IRCServer) removeServerSession(id uint64) {
+	for idx, serverid := range i.serverSessions {
+		if serverid == id {
+			i.serverSessions = append(i.serverSessions[:idx], i.serverSessions[idx+1:]...)
+			return
+		}
+	}
+}
+
+// linkedServer returns the server session whose server name is |name|
+// (case-insensitively), or nil.
+func (i *IRCServer) linkedServer(name string) *Session {
+	for _, serverid := range i.serverSessions {
+		if s, ok := i.sessions[robust.Id{Id: serverid}]; ok && strings.EqualFold(s.ircPrefix.Name, name) {
+			return s
+		}
+	}
+	return nil
+}
+
+// prefixOwner returns the server session which |prefix| belongs to, i.e.
+// the server itself or the server which introduced the client, or nil if
+// |prefix| does not belong to any linked server.
+func (i *IRCServer) prefixOwner(prefix *irc.Prefix) *Session {
+	if s := i.linkedServer(prefix.Name); s != nil {
+		return s
+	}
+	if session, ok := i.nicks[NickToLower(prefix.Name)]; ok && session.Id.Reply != 0 {
+		return i.sessions[robust.Id{Id: session.Id.Id}]
+	}
+	return nil
+}
+
+// sendOtherLinks sends |msg| to all linked servers except |s|, e.g. to
+// introduce the clients of one services server to the others.
+func (i *IRCServer) sendOtherLinks(s *Session, reply *Replyctx, msg *irc.Message) {
+	robustmsg := i.send(reply, msg)
+	for _, serverid := range i.serverSessions {
+		if serverid != s.Id.Id {
+			robustmsg.InterestingFor[serverid] = true
+		}
+	}
+}
+
+// burstNicks returns the (sorted) nicknames of all sessions which are
+// introduced to newly linked services: sessions that are not yet logged in
+// and sessions that represent a server connection are skipped. Clients of
+// other linked servers (e.g. a stats pseudo-server) are included.
+func (i *IRCServer) burstNicks() []lcNick {
+	nicks := make([]lcNick, 0, len(i.nicks))
+	for nick, session := range i.nicks {
+		if (!session.loggedIn && session.Id.Reply == 0) || session.Server {
+			continue
+		}
+		nicks = append(nicks, nick)
+	}
+	sort.Slice(nicks, func(a, b int) bool { return nicks[a] < nicks[b] })
+	return nicks
+}
+
+// linkServerName returns the name of the server which |session| is
+// connected to from the perspective of linked servers.
+func (i *IRCServer) linkServerName(session *Session) string {
+	if session.Id.Reply != 0 {
+		if server, ok := i.sessions[robust.Id{Id: session.Id.Id}]; ok {
+			return server.ircPrefix.Name
+		}
+	}
+	return i.ServerPrefix.Name
+}
@@ -0,0 +1,684 @@
+package ircserver
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// robustSID is the TS6 server id of RobustIRC. From the perspective of
+// services, all RobustIRC servers form a single IRC server, so they share it.
+const robustSID = "0RB"
+
+// ts6Capab are the TS6 capabilities which RobustIRC supports, see
+// https://github.com/grawity/irc-docs/blob/master/server/ts6.txt
+const ts6Capab = "QS ENCAP EX IE SERVICES TB"
+
+// validSID returns whether |sid| is a well-formed TS6 server id, e.g. “0AA”.
+func validSID(sid string) bool {
+	if len(sid) != 3 || sid[0] < '0' || sid[0] > '9' {
+		return false
+	}
+	for _, c := range sid[1:] {
+		if (c < '0' || c > '9') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// ts6UID returns the TS6 user id derived from the counter |n|, i.e.
+// robustSID followed by a letter and five alphanumeric characters.
+func ts6UID(n uint64) string {
+	const alnum = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	var id [6]byte
+	for idx := len(id) - 1; idx > 0; idx-- {
+		id[idx] = alnum[n%36]
+		n /= 36
+	}
+	id[0] = alnum[n%26]
+	return robustSID + string(id[:])
+}
+
+// uidFor returns the TS6 user id of |s|, assigning one if necessary.
+func (i *IRCServer) uidFor(s *Session) string {
+	if s.uid == "" {
+		i.lastUID++
+		s.uid = ts6UID(i.lastUID)
+	}
+	return s.uid
+}
+
+// sessionByUID returns the session with TS6 user id |uid|, or nil. Sessions
+// are not indexed by uid, as only services traffic needs this lookup.
+func (i *IRCServer) sessionByUID(uid string) *Session {
+	for _, s := range i.sessions {
+		if s.uid == uid && !s.Server {
+			return s
+		}
+	}
+	return nil
+}
+
+// sessionForPrefix returns the session which sent a message with |prefix|.
+// Sessions which quit are no longer in i.nicks, but remain in i.sessions
+// until the message is processed, see MaybeDeleteSession.
+func (i *IRCServer) sessionForPrefix(prefix *irc.Prefix) *Session {
+	if s, ok := i.nicks[NickToLower(prefix.Name)]; ok {
+		return s
+	}
+	for _, s := range i.sessions {
+		if s.deleted && s.ircPrefix.Name == prefix.Name {
+			return s
+		}
+	}
+	return nil
+}
+
+// ts6Nick returns the nickname of the session with TS6 user id |uid|, or
+// |uid| if there is no such session.
+func (i *IRCServer) ts6Nick(uid string) string {
+	if s := i.sessionByUID(uid); s != nil {
+		return s.Nick
+	}
+	return uid
+}
+
+// ts6Target returns the TS6 user id of the session with nickname |nick|, or
+// |nick| if there is no such session (e.g. for channel names).
+func (i *IRCServer) ts6Target(nick string) string {
+	if s, ok := i.nicks[NickToLower(nick)]; ok {
+		return i.uidFor(s)
+	}
+	return nick
+}
+
+// ts6ChannelTS returns the creation time of |c| in TS6 notation. Channels
+// which were created before TS6 support was added are reported as created
+// in the year 2000, so that services do not override their modes.
+func ts6ChannelTS(c *channel) string {
+	if c.ts == 0 {
+		return "946684800"
+	}
+	return strconv.FormatInt(c.ts, 10)
+}
+
+// ts6Link is the TS6 protocol, as spoken by e.g. Atheme and Anope’s
+// charybdis/ratbox modules. See
+// https://github.com/grawity/irc-docs/blob/master/server/ts6.txt
+//
+// Users and channels are identified by their TS6 user id, and collisions
+// are resolved using timestamps: the older nickname or channel wins.
+type ts6Link struct{}
+
+func (ts6Link) burst(i *IRCServer, s *Session, reply *Replyctx) {
+	sid := &irc.Prefix{Name: robustSID}
+	i.sendLinkRaw(s, reply, &irc.Message{
+		Command: irc.PASS,
+		Params:  []string{strings.TrimPrefix(s.Pass, "services="), "TS", "6", robustSID},
+	})
+	i.sendLinkRaw(s, reply, &irc.Message{
+		Command: "CAPAB",
+		Params:  []string{ts6Capab},
+	})
+	i.sendLinkRaw(s, reply, &irc.Message{
+		Command: "SERVER",
+		Params:  []string{i.ServerPrefix.Name, "1", "RobustIRC"},
+	})
+	i.sendLinkRaw(s, reply, &irc.Message{
+		Command: "SVINFO",
+		Params:  []string{"6", "6", "0", strconv.FormatInt(s.LastActivity.Unix(), 10)},
+	})
+
+	burstNicks := i.burstNicks()
+	burst := make(map[lcNick]bool, len(burstNicks))
+	for _, nick := range burstNicks {
+		burst[nick] = true
+		i.sendLinkRaw(s, reply, i.ts6UIDMessage(i.nicks[nick]))
+	}
+
+	channelnames := make([]string, 0, len(i.channels))
+	for channelname := range i.channels {
+		channelnames = append(channelnames, string(channelname))
+	}
+	sort.Strings(channelnames)
+	for _, channelname := range channelnames {
+		c := i.channels[lcChan(channelname)]
+		nicks := make([]string, 0, len(c.nicks))
+		for nick := range c.nicks {
+			if burst[nick] {
+				nicks = append(nicks, string(nick))
+			}
+		}
+		if len(nicks) == 0 {
+			continue
+		}
+		sort.Strings(nicks)
+		members := make([]string, len(nicks))
+		for idx, nick := range nicks {
+			members[idx] = ts6StatusPrefix(c.nicks[lcNick(nick)]) + i.uidFor(i.nicks[lcNick(nick)])
+		}
+		params := append([]string{ts6ChannelTS(c), c.name}, channelModeParams(c)...)
+		i.sendLinkRaw(s, reply, &irc.Message{
+			Prefix:  sid,
+			Command: "SJOIN",
+			Params:  append(params, strings.Join(members, " ")),
+		})
+		if len(c.bans) > 0 {
+			masks := make([]string, len(c.bans))
+			for idx, ban := range c.bans {
+				masks[idx] = ban.pattern
+			}
+			i.sendLinkRaw(s, reply, &irc.Message{
+				Prefix:  sid,
+				Command: "BMASK",
+				Params:  []string{ts6ChannelTS(c), c.name, "b", strings.Join(masks, " ")},
+			})
+		}
+	}
+
+	for _, channelname := range channelnames {
+		c := i.channels[lcChan(channelname)]
+		if c.topic == "" {
+			continue
+		}
+		i.sendLinkRaw(s, reply, &irc.Message{
+			Prefix:  sid,
+			Command: "TB",
+			Params:  []string{c.name, strconv.FormatInt(c.topicTime.Unix(), 10), c.topicNick, c.topic},
+		})
+	}
+
+	// Services consider the burst complete once they receive a PING.
+	i.sendLinkRaw(s, reply, &irc.Message{
+		Prefix:  sid,
+		Command: irc.PING,
+		Params:  []string{i.ServerPrefix.Name, s.uid},
+	})
+}
+
+// ts6StatusPrefix returns the TS6 SJOIN prefix for |perms|. TS6 only knows
+// chanops and voiced users, so higher statuses are reported as chanop and
+// halfops as voiced users.
+func ts6StatusPrefix(perms *[maxChanMemberStatus]bool) string {
+	if hasStatus(perms, chanop) {
+		return "@"
+	}
+	if hasStatus(perms, voice) {
+		return "+"
+	}
+	return ""
+}
+
+// ts6UIDMessage returns the UID message which introduces |session|.
+func (i *IRCServer) ts6UIDMessage(session *Session) *irc.Message {
+	modestr := "+"
+	for mode := 'A'; mode < 'z'; mode++ {
+		if session.modes[mode] {
+			modestr += string(mode)
+		}
+	}
+	return &irc.Message{
+		Prefix:  &irc.Prefix{Name: robustSID},
+		Command: "UID",
+		Params: []string{
+			session.Nick,
+			"1", // hopcount
+			strconv.FormatInt(session.Created/int64(time.Second), 10),
+			modestr,
+			session.Username,
+			session.ircPrefix.Host,
+			"0", // IP address, hidden
+			i.uidFor(session),
+			session.Realname,
+		},
+	}
+}
+
+func (ts6Link) translateIn(i *IRCServer, s *Session, reply *Replyctx, msg *irc.Message) *irc.Message {
+	in := &irc.Message{
+		Prefix:  &s.ircPrefix,
+		Command: strings.ToUpper(msg.Command),
+		Params:  append([]string(nil), msg.Params...),
+	}
+	if msg.Prefix != nil && msg.Prefix.Name != s.uid && msg.Prefix.Name != s.ircPrefix.Name {
+		in.Prefix = &irc.Prefix{Name: i.ts6Nick(msg.Prefix.Name)}
+	}
+	param := func(idx int) string {
+		if idx < len(in.Params) {
+			return in.Params[idx]
+		}
+		return ""
+	}
+
+	switch in.Command {
+	case irc.PASS, "CAPAB", "SVINFO", irc.PONG, "SERVER", "EOB":
+		return nil
+
+	case irc.QUIT, "SQUIT":
+		// Services quit entirely when they send SQUIT or when their session
+		// is deleted (e.g. it expired), see cmdServerQuit.
+		if msg.Prefix == nil || in.Command == "SQUIT" {
+			return &irc.Message{Command: irc.QUIT, Params: []string{msg.Trailing()}}
+		}
+
+	case irc.PING:
+		i.sendLinkRaw(s, reply, &irc.Message{
+			Prefix:  &irc.Prefix{Name: robustSID},
+			Command: irc.PONG,
+			Params:  []string{i.ServerPrefix.Name, param(0)},
+		})
+		return nil
+
+	case "UID", "EUID":
+		if len(in.Params) >= 9 {
+			i.ts6Introduce(s, reply, in)
+		}
+		return nil
+
+	case "SJOIN":
+		if len(in.Params) >= 4 {
+			i.ts6Sjoin(s, reply, in)
+		}
+		return nil
+
+	case irc.JOIN:
+		// “:<uid> JOIN <ts> <channel> +”
+		if len(in.Params) < 2 {
+			return nil
+		}
+		in.Params = []string{in.Params[1]}
+
+	case "TMODE":
+		// “:<uid> TMODE <ts> <channel> <modes> [params]”
+		if len(in.Params) < 3 {
+			return nil
+		}
+		c, ok := i.channels[ChanToLower(in.Params[1])]
+		if ok && ts6Newer(in.Params[0], c) {
+			return nil
+		}
+		in.Command = irc.MODE
+		in.Params = in.Params[1:]
+		modes := channelModeTable.normalize(in)
+		for idx, mode := range modes {
+			if strings.ContainsRune(channelPrefixModes, rune(mode.Mode[1])) {
+				modes[idx].Param = i.ts6Nick(mode.Param)
+			}
+		}
+		in.Params = append([]string{in.Params[0]}, modeCmds(modes).IRCParams()...)
+
+	case "BMASK":
+		// “:<sid> BMASK <ts> <channel> <type> :<masks>”
+		if len(in.Params) < 4 {
+			return nil
+		}
+		c, ok := i.channels[ChanToLower(in.Params[1])]
+		if !ok || ts6Newer(in.Params[0], c) {
+			return nil
+		}
+		masks := strings.Fields(in.Params[3])
+		in.Command = irc.MODE
+		in.Params = append([]string{c.name, "+" + strings.Repeat(in.Params[2], len(masks))}, masks...)
+
+	case irc.MODE:
+		// User modes, e.g. “:<uid> MODE <uid> :+i”. Services set modes of
+		// their own clients, which RobustIRC does not track.
+		target := i.sessionByUID(param(0))
+		if target == nil || target.Id.Id == s.Id.Id || len(in.Params) < 2 {
+			return nil
+		}
+		in.Command = "SVSMODE"
+		in.Params = []string{target.Nick, in.Params[1]}
+
+	case irc.PRIVMSG, irc.NOTICE, irc.KILL:
+		if len(in.Params) > 0 {
+			in.Params[0] = i.ts6Nick(in.Params[0])
+		}
+
+	case irc.INVITE:
+		// “:<uid> INVITE <uid> <channel> <ts>”
+		if len(in.Params) < 2 {
+			return nil
+		}
+		in.Params = []string{i.ts6Nick(in.Params[0]), in.Params[1]}
+
+	case irc.KICK:
+		if len(in.Params) > 1 {
+			in.Params[1] = i.ts6Nick(in.Params[1])
+		}
+
+	case irc.NICK:
+		// “:<uid> NICK <nick> :<ts>”
+		if len(in.Params) > 0 {
+			in.Params = in.Params[:1]
+		}
+
+	case "ENCAP":
+		return i.ts6Encap(s, in)
+	}
+	return in
+}
+
+// ts6Newer returns whether the TS6 timestamp |ts| is newer than the
+// creation time of |c|, in which case mode changes are ignored.
+func ts6Newer(ts string, c *channel) bool {
+	parsed, err := strconv.ParseInt(ts, 10, 64)
+	return err != nil || (c.ts != 0 && parsed > c.ts)
+}
+
+// ts6Encap translates the ENCAP commands which services use to manage
+// users into their RobustIRC equivalents.
+func (i *IRCServer) ts6Encap(s *Session, in *irc.Message) *irc.Message {
+	// “ENCAP <target mask> <command> [params]”
+	if len(in.Params) < 3 {
+		return nil
+	}
+	params := in.Params[2:]
+	switch strings.ToUpper(in.Params[1]) {
+	case "RSFNC":
+		// “ENCAP * RSFNC <uid> <new nick> <new ts> <old ts>”
+		if len(params) < 2 {
+			return nil
+		}
+		in.Command = "SVSNICK"
+		in.Params = []string{i.ts6Nick(params[0]), params[1]}
+	case "SU":
+		// “ENCAP * SU <uid> [<account>]”: (un)marks the user as logged in.
+		modes := "-r"
+		if len(params) > 1 && params[1] != "" {
+			modes = "+r"
+		}
+		in.Command = "SVSMODE"
+		in.Params = []string{i.ts6Nick(params[0]), modes}
+	default:
+		return nil
+	}
+	return in
+}
+
+// ts6Introduce handles UID and EUID, which introduce a services client:
+//
+//	UID <nick> <hops> <ts> <umodes> <user> <host> <ip> <uid> :<gecos>
+//
+// In case the nickname is in use, the older nickname wins.
+func (i *IRCServer) ts6Introduce(s *Session, reply *Replyctx, msg *irc.Message) {
+	nick, uid := msg.Params[0], msg.Params[7]
+	ts, err := strconv.ParseInt(msg.Params[2], 10, 64)
+	if err != nil {
+		ts = 0
+	}
+	if existing, ok := i.nicks[NickToLower(nick)]; ok {
+		if existing.Id.Id == s.Id.Id {
+			// Re-introduction of one of the services’ own clients.
+			existing.uid = uid
+			return
+		}
+		guest := i.guestNick(existing)
+		if ts == 0 || ts >= existing.Created/int64(time.Second) || guest == "" {
+			i.sendLinkRaw(s, reply, &irc.Message{
+				Prefix:  &irc.Prefix{Name: robustSID},
+				Command: irc.KILL,
+				Params:  []string{uid, i.ServerPrefix.Name + " (Nick collision (older nick wins))"},
+			})
+			return
+		}
+		i.cmdServerSvsnick(s, reply, &irc.Message{
+			Prefix:  &s.ircPrefix,
+			Command: "SVSNICK",
+			Params:  []string{existing.Nick, guest},
+		})
+	}
+	i.cmdServerNick(s, reply, &irc.Message{
+		Prefix:  &s.ircPrefix,
+		Command: irc.NICK,
+		Params: []string{
+			nick,
+			msg.Params[1], // hopcount
+			msg.Params[2], // timestamp
+			msg.Params[4], // username
+			msg.Params[5], // host
+			s.ircPrefix.Name,
+			"0",           // services id
+			msg.Params[3], // user modes
+			msg.Trailing(),
+		},
+	})
+	if session, ok := i.nicks[NickToLower(nick)]; ok && session.Id.Id == s.Id.Id {
+		session.uid = uid
+	}
+}
+
+// ts6Sjoin handles SJOIN, which services use to join their clients to
+// channels (and to create channels):
+//
+//	SJOIN <ts> <channel> <modes> [params] :<members, e.g. @0AAAAAAAB>
+//
+// If the services’ channel is older, the modes and statuses of the existing
+// channel are removed; if it is newer, the services’ modes and statuses are
+// ignored.
+func (i *IRCServer) ts6Sjoin(s *Session, reply *Replyctx, msg *irc.Message) {
+	ts, err := strconv.ParseInt(msg.Params[0], 10, 64)
+	if err != nil {
+		return
+	}
+	channelname := msg.Params[1]
+	members := strings.Fields(msg.Trailing())
+	if !IsValidChannel(channelname) || len(members) == 0 {
+		return
+	}
+	c, existed := i.channels[ChanToLower(channelname)]
+	if !existed {
+		c = &channel{
+			name:  channelname,
+			nicks: make(map[lcNick]*[maxChanMemberStatus]bool),
+			ts:    ts,
+		}
+		i.channels[ChanToLower(channelname)] = c
+	}
+	accept := !existed || c.ts == 0 || ts <= c.ts
+	if existed && c.ts != 0 && ts < c.ts {
+		i.ts6ResetChannel(c, reply)
+	}
+	if existed && (c.ts == 0 || ts < c.ts) {
+		c.ts = ts
+	}
+	if accept && len(msg.Params) > 3 {
+		i.cmdServerMode(s, reply, &irc.Message{
+			Prefix:  &s.ircPrefix,
+			Command: irc.MODE,
+			Params:  append([]string{c.name}, msg.Params[2:len(msg.Params)-1]...),
+		})
+	}
+
+	modestr := "+"
+	var modeparams []string
+	for _, member := range members {
+		uid := strings.TrimLeft(member, "@+")
+		session := i.sessionByUID(uid)
+		if session == nil {
+			continue
+		}
+		nick := NickToLower(session.Nick)
+		if _, ok := c.nicks[nick]; !ok {
+			c.nicks[nick] = &[maxChanMemberStatus]bool{}
+			session.Channels[ChanToLower(c.name)] = true
+			i.sendCommonChannels(session, reply, &irc.Message{
+				Prefix:  servicesPrefix(&session.ircPrefix),
+				Command: irc.JOIN,
+				Params:  []string{c.name},
+			})
+		}
+		if !accept {
+			continue
+		}
+		for _, prefix := range member[:len(member)-len(uid)] {
+			status, mode := voice, "v"
+			if prefix == '@' {
+				status, mode = chanop, "o"
+			}
+			c.nicks[nick][status] = true
+			modestr += mode
+			modeparams = append(modeparams, session.Nick)
+		}
+	}
+	if len(modeparams) > 0 {
+		i.sendChannel(c, reply, &irc.Message{
+			Prefix:  servicesPrefix(&s.ircPrefix),
+			Command: irc.MODE,
+			Params:  append([]string{c.name, modestr}, modeparams...),
+		})
+	}
+}
+
+// ts6ResetChannel removes all modes and member statuses of |c|, which lost
+// a TS6 channel collision. Bans are kept.
+func (i *IRCServer) ts6ResetChannel(c *channel, reply *Replyctx) {
+	var unset []modeCmd
+	for mode := 'A'; mode < 'z'; mode++ {
+		if !c.modes[mode] || mode == 'b' {
+			continue
+		}
+		cmd := modeCmd{Mode: "-" + string(mode)}
+		if mode == 'k' {
+			cmd.Param = c.key
+		}
+		unset = append(unset, cmd)
+		c.modes[mode] = false
+	}
+	c.key = ""
+	c.limit = 0
+	nicks := make([]string, 0, len(c.nicks))
+	for nick := range c.nicks {
+		nicks = append(nicks, string(nick))
+	}
+	sort.Strings(nicks)
+	for _, nick := range nicks {
+		perms := c.nicks[lcNick(nick)]
+		for _, ms := range memberStatuses {
+			if perms[ms.status] {
+				unset = append(unset, modeCmd{Mode: "-" + string(ms.mode), Param: i.nicks[lcNick(nick)].Nick})
+				perms[ms.status] = false
+			}
+		}
+	}
+	if len(unset) == 0 {
+		return
+	}
+	i.sendChannel(c, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.MODE,
+		Params:  append([]string{c.name}, modeCmds(unset).IRCParams()...),
+	})
+}
+
+func (ts6Link) translateOut(i *IRCServer, s *Session, msg *irc.Message) *irc.Message {
+	out := &irc.Message{
+		Prefix:  &irc.Prefix{Name: robustSID},
+		Command: msg.Command,
+		Params:  append([]string(nil), msg.Params...),
+	}
+	var sender *Session
+	if msg.Prefix != nil && msg.Prefix.Name != i.ServerPrefix.Name {
+		if msg.Command == irc.NICK && len(msg.Params) == 1 {
+			// Nickname changes are sent after the session was renamed.
+			sender = i.nicks[NickToLower(msg.Params[0])]
+		} else {
+			sender = i.sessionForPrefix(msg.Prefix)
+		}
+		if sender == nil || sender.Id.Id == s.Id.Id {
+			// Services do not expect their own messages to be echoed.
+			return nil
+		}
+		out.Prefix = &irc.Prefix{Name: i.uidFor(sender)}
+	}
+
+	switch msg.Command {
+	case irc.NICK:
+		if len(msg.Params) == 1 {
+			if sender == nil {
+				return nil
+			}
+			out.Params = []string{msg.Params[0], strconv.FormatInt(sender.LastActivity.Unix(), 10)}
+			return out
+		}
+		// Introduction of a new user, see maybeLogin.
+		session, ok := i.nicks[NickToLower(msg.Params[0])]
+		if !ok {
+			return nil
+		}
+		return i.ts6UIDMessage(session)
+
+	case "SJOIN":
+		// “:<server> SJOIN 1 <channel> [@]<nick>”, see cmdJoin.
+		if len(msg.Params) < 3 {
+			return nil
+		}
+		c, ok := i.channels[ChanToLower(msg.Params[1])]
+		if !ok {
+			return nil
+		}
+		nick := strings.TrimLeft(msg.Params[2], "@")
+		params := append([]string{ts6ChannelTS(c), c.name}, channelModeParams(c)...)
+		out.Params = append(params, msg.Params[2][:len(msg.Params[2])-len(nick)]+i.ts6Target(nick))
+
+	case irc.JOIN:
+		c, ok := i.channels[ChanToLower(msg.Params[0])]
+		if !ok {
+			return nil
+		}
+		out.Params = []string{ts6ChannelTS(c), c.name, "+"}
+
+	case irc.MODE:
+		if len(msg.Params) < 2 {
+			return nil
+		}
+		c, ok := i.channels[ChanToLower(msg.Params[0])]
+		if !ok {
+			out.Params[0] = i.ts6Target(msg.Params[0])
+			return out
+		}
+		modes := channelModeTable.normalize(msg)
+		for idx, mode := range modes {
+			if strings.ContainsRune(channelPrefixModes, rune(mode.Mode[1])) {
+				modes[idx].Param = i.ts6Target(mode.Param)
+			}
+		}
+		out.Command = "TMODE"
+		out.Params = append([]string{ts6ChannelTS(c), c.name}, modeCmds(modes).IRCParams()...)
+
+	case irc.INVITE:
+		if len(msg.Params) < 2 {
+			return nil
+		}
+		c, ok := i.channels[ChanToLower(msg.Params[1])]
+		if !ok {
+			return nil
+		}
+		out.Params = []string{i.ts6Target(msg.Params[0]), c.name, ts6ChannelTS(c)}
+
+	case irc.PRIVMSG, irc.NOTICE, irc.KILL:
+		if len(out.Params) > 0 {
+			out.Params[0] = i.ts6Target(out.Params[0])
+		}
+
+	case irc.KICK:
+		if len(out.Params) > 1 {
+			out.Params[1] = i.ts6Target(out.Params[1])
+		}
+
+	case irc.TOPIC:
+		// “TOPIC <channel> <setter> <ts> :<topic>”, see cmdTopic.
+		out.Params = []string{msg.Params[0], msg.Trailing()}
+
+	default:
+		// Numeric replies are addressed to a services client.
+		if len(msg.Command) == 3 && len(out.Params) > 0 {
+			out.Params[0] = i.ts6Target(out.Params[0])
+		}
+	}
+	return out
+}
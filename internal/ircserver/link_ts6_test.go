@@ -0,0 +1,233 @@
+package ircserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/config"
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func stdIRCServerWithTS6Services() (*IRCServer, map[string]robust.Id) {
+	i, ids := stdIRCServer()
+	i.Config.IRC.Services = append(i.Config.IRC.Services, config.Service{
+		Password: "mypass",
+	})
+	ids["services"] = robust.Id{Id: 0x13c6cdee3e749faf}
+	i.CreateSession(ids["services"], "auth-server", time.Unix(0, int64(ids["services"].Id)))
+	i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("PASS mypass TS 6 :00A"))
+	i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("SERVER services.robustirc.net 1 :Services"))
+	i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":00A UID ChanServ 1 1422134861 +io services services.robustirc.net 0 00AAAAAAA :Channel Services"))
+	return i, ids
+}
+
+func TestTS6Handshake(t *testing.T) {
+	i, ids := stdIRCServer()
+	i.Config.IRC.Services = append(i.Config.IRC.Services, config.Service{
+		Password: "mypass",
+	})
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+
+	ids["services"] = robust.Id{Id: 0x13c6cdee3e749faf}
+	i.CreateSession(ids["services"], "auth-server", time.Unix(0, int64(ids["services"].Id)))
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("PASS mypass TS 6 :00A")),
+		[]*irc.Message{})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("CAPAB :QS ENCAP EX IE")),
+		[]*irc.Message{})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("SERVER services.robustirc.net 1 :Services")),
+		[]*irc.Message{
+			irc.ParseMessage("PASS mypass TS 6 0RB"),
+			irc.ParseMessage("CAPAB :QS ENCAP EX IE SERVICES TB"),
+			irc.ParseMessage("SERVER robustirc.net 1 RobustIRC"),
+			irc.ParseMessage("SVINFO 6 6 0 1425052755"),
+			irc.ParseMessage(":0RB UID mero 1 1420228218 + foo robust/0x13b5aa0a2bcfb8ae 0 0RBAAAAAB :Axel Wagner"),
+			irc.ParseMessage(":0RB UID sECuRE 1 1420228218 + blah robust/0x13b5aa0a2bcfb8ad 0 0RBAAAAAC :Michael Stapelberg"),
+			irc.ParseMessage(":0RB UID xeen 1 1420228218 + baz robust/0x13b5aa0a2bcfb8af 0 0RBAAAAAD :Iks Enn"),
+			irc.ParseMessage(":0RB SJOIN 1420228218 #test +nt @0RBAAAAAB"),
+			irc.ParseMessage(":0RB PING robustirc.net 00A"),
+			irc.ParseMessage(":robustirc.net ENCAP * RETENTION 610 1425052145"),
+			irc.ParseMessage(":0RB ENCAP * RETENTION 610 1425052145"),
+		})
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":00A PING :0RB")),
+		":0RB PONG robustirc.net 0RB")
+}
+
+func TestTS6Privmsg(t *testing.T) {
+	i, ids := stdIRCServerWithTS6Services()
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":00AAAAAAA PRIVMSG 0RBAAAAAB :hi there")),
+		":ChanServ!services@services PRIVMSG mero :hi there")
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("PRIVMSG ChanServ :help")),
+		[]*irc.Message{
+			irc.ParseMessage(":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad PRIVMSG ChanServ :help"),
+			irc.ParseMessage(":0RBAAAAAC PRIVMSG 00AAAAAAA :help"),
+		})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NICK sec")),
+		[]*irc.Message{
+			irc.ParseMessage(":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad NICK sec"),
+			irc.ParseMessage(":0RBAAAAAC NICK sec 1420228218"),
+		})
+}
+
+func TestTS6NickCollision(t *testing.T) {
+	i, ids := stdIRCServerWithTS6Services()
+
+	// The services’ nickname is newer, so it loses.
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":00A UID sECuRE 1 1500000000 +i services services.robustirc.net 0 00AAAAAAB :Collider")),
+		":0RB KILL 00AAAAAAB :robustirc.net (Nick collision (older nick wins))")
+
+	// The services’ nickname is older, so the existing user is renamed.
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":00A UID sECuRE 1 1 +i services services.robustirc.net 0 00AAAAAAB :Collider")),
+		[]*irc.Message{
+			irc.ParseMessage(":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad NICK Guest87917"),
+			irc.ParseMessage(":0RBAAAAAC NICK Guest87917 1420228218"),
+		})
+
+	if s, ok := i.nicks[NickToLower("sECuRE")]; !ok || s.uid != "00AAAAAAB" {
+		t.Fatalf("services client sECuRE not introduced")
+	}
+}
+
+func TestTS6Sjoin(t *testing.T) {
+	i, ids := stdIRCServerWithTS6Services()
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+
+	// The existing channel is older, so the services’ status is ignored.
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":00A SJOIN 1500000000 #test +ntm :@00AAAAAAA")),
+		":ChanServ!services@services JOIN #test")
+
+	// Mode changes with a newer timestamp are ignored.
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":00AAAAAAA TMODE 1500000000 #test +m")),
+		[]*irc.Message{})
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":00AAAAAAA TMODE 1420228218 #test +v 0RBAAAAAB")),
+		":ChanServ!services@services MODE #test +v mero")
+
+	// The services’ channel is older, so the existing modes are removed.
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":00A SJOIN 1 #test + :@00AAAAAAA")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net MODE #test -ntov mero mero"),
+			irc.ParseMessage(":0RB TMODE 1 #test -ntov 0RBAAAAAB 0RBAAAAAB"),
+			irc.ParseMessage(":services.robustirc.net!services@services MODE #test +o ChanServ"),
+		})
+
+	if got, want := i.channels[ChanToLower("#test")].ts, int64(1); got != want {
+		t.Fatalf("channel ts: got %d, want %d", got, want)
+	}
+
+	// Channel mode changes are sent to services as TMODE.
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #new")),
+		[]*irc.Message{
+			irc.ParseMessage(":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad JOIN #new"),
+			irc.ParseMessage(":robustirc.net MODE #new +nt"),
+			irc.ParseMessage(":robustirc.net SJOIN 1 #new :@sECuRE"),
+			irc.ParseMessage(":0RB SJOIN 1420228218 #new +nt :@0RBAAAAAC"),
+			irc.ParseMessage(":robustirc.net 324 sECuRE #new +nt"),
+			irc.ParseMessage(":robustirc.net 331 sECuRE #new :No topic is set"),
+			irc.ParseMessage(":robustirc.net 353 sECuRE = #new :@sECuRE"),
+			irc.ParseMessage(":robustirc.net 366 sECuRE #new :End of /NAMES list."),
+		})
+}
+
+func TestTS6Snapshot(t *testing.T) {
+	i, ids := stdIRCServerWithTS6Services()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewIRCServer("robustirc.net", time.Now())
+	if _, err := restored.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+	i = restored
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("PRIVMSG ChanServ :help")),
+		[]*irc.Message{
+			irc.ParseMessage(":mero!foo@robust/0x13b5aa0a2bcfb8ae PRIVMSG ChanServ :help"),
+			irc.ParseMessage(":0RBAAAAAB PRIVMSG 00AAAAAAA :help"),
+		})
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":00AAAAAAA TMODE 1420228218 #test +v 0RBAAAAAC")),
+		":ChanServ!services@services MODE #test +v sECuRE")
+}
+
+func TestTS6Topic(t *testing.T) {
+	i, ids := stdIRCServerWithTS6Services()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":00A TB #test 1422134861 ChanServ :restored topic")),
+		":services.robustirc.net!services@services TOPIC #test :restored topic")
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("TOPIC #test :new topic")),
+		[]*irc.Message{
+			irc.ParseMessage(":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad TOPIC #test :new topic"),
+			irc.ParseMessage(":sECuRE TOPIC #test sECuRE 1420228218 :new topic"),
+			irc.ParseMessage(":0RBAAAAAC TOPIC #test :new topic"),
+		})
+}
+
+func TestTS6Reconnect(t *testing.T) {
+	i, ids := stdIRCServerWithTS6Services()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +b *!*@example.net"))
+
+	// The services session expired, so all services clients quit.
+	i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("QUIT :Ping timeout"))
+	i.MaybeDeleteSession(ids["services"])
+	if _, ok := i.nicks[NickToLower("ChanServ")]; ok {
+		t.Fatalf("ChanServ still present after the services session quit")
+	}
+
+	ids["services2"] = robust.Id{Id: 0x13c6cdee3e749fb0}
+	i.CreateSession(ids["services2"], "auth-server", time.Unix(0, int64(ids["services2"].Id)))
+	i.ProcessMessage(&robust.Message{Session: ids["services2"]}, irc.ParseMessage("PASS mypass TS 6 :00A"))
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services2"]}, irc.ParseMessage("SERVER services.robustirc.net 1 :Services")),
+		[]*irc.Message{
+			irc.ParseMessage("PASS mypass TS 6 0RB"),
+			irc.ParseMessage("CAPAB :QS ENCAP EX IE SERVICES TB"),
+			irc.ParseMessage("SERVER robustirc.net 1 RobustIRC"),
+			irc.ParseMessage("SVINFO 6 6 0 1425052755"),
+			irc.ParseMessage(":0RB UID mero 1 1420228218 + foo robust/0x13b5aa0a2bcfb8ae 0 0RBAAAAAB :Axel Wagner"),
+			irc.ParseMessage(":0RB UID sECuRE 1 1420228218 + blah robust/0x13b5aa0a2bcfb8ad 0 0RBAAAAAC :Michael Stapelberg"),
+			irc.ParseMessage(":0RB UID xeen 1 1420228218 + baz robust/0x13b5aa0a2bcfb8af 0 0RBAAAAAD :Iks Enn"),
+			irc.ParseMessage(":0RB SJOIN 1420228218 #test +nt @0RBAAAAAC"),
+			irc.ParseMessage(":0RB BMASK 1420228218 #test b *!*@example.net"),
+			irc.ParseMessage(":0RB PING robustirc.net 00A"),
+			irc.ParseMessage(":robustirc.net ENCAP * RETENTION 610 1425052145"),
+			irc.ParseMessage(":0RB ENCAP * RETENTION 610 1425052145"),
+		})
+}
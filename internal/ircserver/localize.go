@@ -0,0 +1,100 @@
+package ircserver
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"text/template"
+)
+
+var numericRe = regexp.MustCompile(`^[0-9]{3}$`)
+
+// numericTextData is available to the templates of Config.NumericTexts.
+type numericTextData struct {
+	// Nick is the recipient of the numeric, i.e. its first parameter.
+	Nick string
+
+	// Params are the parameters between Nick and Text, e.g. [“mero”] for
+	// “:robustirc.net 401 sECuRE mero :No such nick/channel”.
+	Params []string
+
+	// Text is the original text, i.e. the last parameter.
+	Text string
+}
+
+func parseNumericText(numeric, text string) (*template.Template, error) {
+	return template.New(numeric).Option("missingkey=error").Parse(text)
+}
+
+// ValidateNumericTexts returns an error if |texts| (see
+// config.Network.NumericTexts) contains keys which are not numerics or
+// templates which cannot be parsed.
+func ValidateNumericTexts(texts map[string]string) error {
+	numerics := make([]string, 0, len(texts))
+	for numeric := range texts {
+		numerics = append(numerics, numeric)
+	}
+	sort.Strings(numerics)
+	for _, numeric := range numerics {
+		if !numericRe.MatchString(numeric) {
+			return fmt.Errorf("NumericTexts: %q is not a numeric", numeric)
+		}
+		if _, err := parseNumericText(numeric, texts[numeric]); err != nil {
+			return fmt.Errorf("NumericTexts: %v", err)
+		}
+	}
+	return nil
+}
+
+func (i *IRCServer) numericTexts() map[string]string {
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	return i.Config.NumericTexts
+}
+
+// localizeNumerics replaces the text of all numerics in |reply| for which the
+// network configuration contains a NumericTexts template. Numerics whose
+// template fails to execute (e.g. because it refers to a parameter the
+// numeric does not have) keep their original text.
+func (i *IRCServer) localizeNumerics(reply *Replyctx) {
+	texts := i.numericTexts()
+	if len(texts) == 0 {
+		return
+	}
+	parsed := make(map[string]*template.Template)
+	for _, msg := range reply.Messages {
+		ircmsg := ParseMessage(msg.Data)
+		if ircmsg == nil ||
+			ircmsg.Prefix == nil ||
+			ircmsg.Prefix.Name != i.ServerPrefix.Name ||
+			len(ircmsg.Params) < 2 {
+			continue
+		}
+		text, ok := texts[ircmsg.Command]
+		if !ok {
+			continue
+		}
+		tmpl, ok := parsed[ircmsg.Command]
+		if !ok {
+			var err error
+			// Invalid templates are rejected when applying the
+			// configuration, so this cannot fail in practice.
+			if tmpl, err = parseNumericText(ircmsg.Command, text); err != nil {
+				continue
+			}
+			parsed[ircmsg.Command] = tmpl
+		}
+		last := len(ircmsg.Params) - 1
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, numericTextData{
+			Nick:   ircmsg.Params[0],
+			Params: ircmsg.Params[1:last],
+			Text:   ircmsg.Params[last],
+		}); err != nil {
+			continue
+		}
+		ircmsg.Params[last] = buf.String()
+		msg.Data = string(ircmsg.Bytes())
+	}
+}
@@ -0,0 +1,68 @@
+package ircserver
+
+import (
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestLocalizeNumerics(t *testing.T) {
+	i, ids := stdIRCServer()
+	i.Config.NumericTexts = map[string]string{
+		"401": "{{ index .Params 0 }}: Kein solcher Nick/Kanal",
+		"421": "Unbekannter Befehl ({{ .Text }})",
+		// Refers to a parameter which ERR_NOTONCHANNEL does not have.
+		"442": "{{ index .Params 5 }}",
+	}
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("WHOIS nobody")),
+		":robustirc.net 401 sECuRE nobody :nobody: Kein solcher Nick/Kanal")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("FOO")),
+		":robustirc.net 421 sECuRE FOO :Unbekannter Befehl (Unknown command)")
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("PART #test")),
+		":robustirc.net 442 sECuRE #test :You're not on that channel")
+
+	// Only numerics sent by the server are localized.
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("PRIVMSG #test :401")),
+		":mero!foo@robust/0x13b5aa0a2bcfb8ae PRIVMSG #test :401")
+}
+
+func TestLocalizeQuery(t *testing.T) {
+	i, ids := stdIRCServer()
+	i.Config.NumericTexts = map[string]string{
+		"366": "Ende der /NAMES-Liste.",
+	}
+
+	reply, err := i.ProcessQuery(ids["secure"], irc.ParseMessage("NAMES #nonexistent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustMatchMsg(t, reply, ":robustirc.net 366 sECuRE * :Ende der /NAMES-Liste.")
+}
+
+func TestValidateNumericTexts(t *testing.T) {
+	for _, tt := range []struct {
+		texts   map[string]string
+		wantErr bool
+	}{
+		{texts: nil},
+		{texts: map[string]string{"401": "{{ .Text }}"}},
+		{texts: map[string]string{"PRIVMSG": "hello"}, wantErr: true},
+		{texts: map[string]string{"4011": "hello"}, wantErr: true},
+		{texts: map[string]string{"401": "{{ .Text "}, wantErr: true},
+	} {
+		if err := ValidateNumericTexts(tt.texts); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateNumericTexts(%v) = %v, want error = %v", tt.texts, err, tt.wantErr)
+		}
+	}
+}
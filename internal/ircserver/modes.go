@@ -1,6 +1,72 @@
 package ircserver
 
-import "gopkg.in/sorcix/irc.v2"
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// errInvalidKey is returned when setting a malformed channel key, as used by
+// e.g. InspIRCd.
+const errInvalidKey = "525"
+
+// maxKeyLen is the maximum length of channel keys (mode +k).
+const maxKeyLen = 23
+
+// validChannelKey returns whether |key| can be used as a channel key. As JOIN
+// separates keys by commas, keys must not contain any.
+func validChannelKey(key string) bool {
+	return key != "" && len(key) <= maxKeyLen && !strings.ContainsAny(key, ", ")
+}
+
+// setChannelKey sets (|add|) or removes the channel key of |c|.
+func setChannelKey(c *channel, add bool, key string) {
+	c.modes['k'] = add
+	if add {
+		c.key = key
+	} else {
+		c.key = ""
+	}
+}
+
+// setChannelLimit sets (|add|) or removes the member limit of |c|. It returns
+// the normalized limit parameter and false if |param| is not a valid limit.
+func setChannelLimit(c *channel, add bool, param string) (string, bool) {
+	if !add {
+		c.modes['l'] = false
+		c.limit = 0
+		return "", true
+	}
+	limit, err := strconv.Atoi(param)
+	if err != nil || limit <= 0 {
+		return "", false
+	}
+	c.modes['l'] = true
+	c.limit = limit
+	return strconv.Itoa(limit), true
+}
+
+// channelModeParams returns the mode string of |c| (e.g. “+klnt”), followed by
+// the parameters of parameterized modes in the same order.
+func channelModeParams(c *channel) []string {
+	modestr := "+"
+	var params []string
+	for mode := 'A'; mode < 'z'; mode++ {
+		if !c.modes[mode] {
+			continue
+		}
+		modestr += string(mode)
+		switch mode {
+		case 'k':
+			params = append(params, c.key)
+		case 'l':
+			params = append(params, strconv.Itoa(c.limit))
+		}
+	}
+	return append([]string{modestr}, params...)
+}
 
 type modeCmd struct {
 	Mode  string
@@ -42,7 +108,127 @@ func (cmds modeCmds) IRCParams() []string {
 	return append([]string{modeStr}, params...)
 }
 
-func normalizeModes(msg *irc.Message) []modeCmd {
+// modeType describes whether a mode takes a parameter, following the
+// classification (and order) of the CHANMODES ISUPPORT token.
+type modeType int
+
+const (
+	// modeList (type A) modes add to or remove from a list and take a
+	// parameter, except when querying the list, e.g. +b.
+	modeList modeType = iota
+
+	// modeParam (type B) modes always take a parameter, e.g. +k.
+	modeParam
+
+	// modeParamWhenSet (type C) modes only take a parameter when being set,
+	// e.g. +l.
+	modeParamWhenSet
+
+	// modeFlag (type D) modes never take a parameter, e.g. +t.
+	modeFlag
+)
+
+// modeTable maps mode characters to their type. Characters which are not in
+// the table are parsed as modeFlag, so that they can be rejected as unknown.
+type modeTable map[rune]modeType
+
+// prefixModeStatus returns the member status which the prefix mode |char|
+// (e.g. 'o' for chanop) sets, see memberStatuses.
+func prefixModeStatus(char rune) (int, bool) {
+	for _, ms := range memberStatuses {
+		if ms.mode == char {
+			return ms.status, true
+		}
+	}
+	return -1, false
+}
+
+// requiredStatus returns the member status which users need to change the
+// channel mode |char|: halfops can (de)voice and ban, only channel owners can
+// make others owners or admins, and everything else requires chanop.
+func requiredStatus(char rune) int {
+	switch char {
+	case 'v', 'b':
+		return halfop
+	case 'q', 'a':
+		return chanowner
+	}
+	return chanop
+}
+
+// channelPrefixModes are the channel modes which set a membership prefix
+// (see PREFIX). They take a nick as parameter, but are not part of CHANMODES.
+const channelPrefixModes = "qaohv"
+
+var channelModeTable = modeTable{
+	'b': modeList,
+	'k': modeParam,
+	'q': modeParam,
+	'a': modeParam,
+	'o': modeParam,
+	'h': modeParam,
+	'v': modeParam,
+	'l': modeParamWhenSet,
+	'A': modeFlag,
+	'O': modeFlag,
+	'P': modeFlag,
+	'R': modeFlag,
+	'i': modeFlag,
+	'n': modeFlag,
+	's': modeFlag,
+	't': modeFlag,
+	'x': modeFlag,
+}
+
+var userModeTable = modeTable{
+	// +d sets the services id, see SVSMODE.
+	'd': modeParam,
+	'B': modeFlag,
+	'i': modeFlag,
+	'o': modeFlag,
+	'r': modeFlag,
+	// +x cloaks the host, see cloakHost.
+	'x': modeFlag,
+}
+
+// chanmodes returns the value of the CHANMODES ISUPPORT token for |t|, e.g.
+// “b,k,l,AOPRinstx”.
+func (t modeTable) chanmodes() string {
+	var types [modeFlag + 1][]string
+	for char, typ := range t {
+		if strings.ContainsRune(channelPrefixModes, char) {
+			continue
+		}
+		types[typ] = append(types[typ], string(char))
+	}
+	parts := make([]string, len(types))
+	for idx, chars := range types {
+		sort.Strings(chars)
+		parts[idx] = strings.Join(chars, "")
+	}
+	return strings.Join(parts, ",")
+}
+
+// takesParam returns whether |char| consumes a parameter when being added
+// (|adding|) or removed.
+func (t modeTable) takesParam(char rune, adding bool) bool {
+	typ, ok := t[char]
+	if !ok {
+		return false
+	}
+	switch typ {
+	case modeList, modeParam:
+		return true
+	case modeParamWhenSet:
+		return adding
+	}
+	return false
+}
+
+// normalize splits the mode string of |msg| (e.g. “MODE #chan +kl-o key 5
+// nick”) into one modeCmd per mode, assigning parameters according to |t|.
+// Mode strings without a leading + or - add modes.
+func (t modeTable) normalize(msg *irc.Message) []modeCmd {
 	if len(msg.Params) <= 1 {
 		return nil
 	}
@@ -52,26 +238,23 @@ func normalizeModes(msg *irc.Message) []modeCmd {
 	modestr := msg.Params[1]
 	modearg := 2
 	for _, char := range modestr {
-		var mode modeCmd
-		switch char {
-		case '+', '-':
+		if char == '+' || char == '-' {
 			adding = (char == '+')
-		case 'o', 'd', 'b':
-			// Modes which require a parameter.
+			continue
+		}
+		var mode modeCmd
+		if adding {
+			mode.Mode = "+" + string(char)
+		} else {
+			mode.Mode = "-" + string(char)
+		}
+		if t.takesParam(char, adding) {
+			// A missing parameter is left empty, which list modes
+			// interpret as a query.
 			if len(msg.Params) > modearg {
 				mode.Param = msg.Params[modearg]
 			}
 			modearg++
-			fallthrough
-		default:
-			if adding {
-				mode.Mode = "+" + string(char)
-			} else {
-				mode.Mode = "-" + string(char)
-			}
-		}
-		if mode.Mode == "" {
-			continue
 		}
 		results = append(results, mode)
 	}
@@ -0,0 +1,101 @@
+package ircserver
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// rplMoreResults is sent right before the end-of-list numeric when a WHO,
+// LIST or NAMES reply was cut short because of Config.ReplyPageSize. Its
+// parameters are the command and the continuation token, which clients pass
+// as last parameter of the same command to retrieve the next page, e.g.:
+//
+//	:robustirc.net 799 nick LIST +#foo :More results available
+//	LIST * +#foo
+const rplMoreResults = "799"
+
+func (i *IRCServer) replyPageSize() int {
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	return int(i.Config.ReplyPageSize)
+}
+
+func (i *IRCServer) maxTargets() int {
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	return int(i.Config.MaxTargets)
+}
+
+// isupportLimits returns the ISUPPORT tokens advertising the configured
+// MaxTargets and ReplyPageSize, if any.
+func (i *IRCServer) isupportLimits() []string {
+	var tokens []string
+	if max := i.maxTargets(); max > 0 {
+		tokens = append(tokens, "MAXTARGETS="+strconv.Itoa(max))
+	}
+	if size := i.replyPageSize(); size > 0 {
+		tokens = append(tokens, "PAGELEN="+strconv.Itoa(size))
+	}
+	if limit := i.channelsPerUserLimit(); limit > 0 {
+		tokens = append(tokens, "CHANLIMIT=#:"+strconv.FormatUint(limit, 10))
+	}
+	return tokens
+}
+
+// continuationToken returns the continuation token (without its “+” prefix)
+// if the last of |params| is one, or the empty string otherwise. The first
+// parameter is never considered a continuation token, as it always is the
+// command’s target.
+func continuationToken(params []string) string {
+	if len(params) < 2 || !strings.HasPrefix(params[len(params)-1], "+") {
+		return ""
+	}
+	return strings.TrimPrefix(params[len(params)-1], "+")
+}
+
+// paginate returns the page of |sorted| which follows the entry |cursor| and
+// the cursor for the next page, which is empty if there are no more entries.
+// Using the last returned entry instead of an offset as cursor ensures that
+// entries are neither skipped nor repeated when entries before the cursor are
+// added or removed in between two requests.
+func paginate(sorted []string, cursor string, pageSize int) (page []string, next string) {
+	if cursor != "" {
+		sorted = sorted[sort.Search(len(sorted), func(idx int) bool {
+			return sorted[idx] > cursor
+		}):]
+	}
+	if pageSize <= 0 || len(sorted) <= pageSize {
+		return sorted, ""
+	}
+	page = sorted[:pageSize]
+	return page, page[len(page)-1]
+}
+
+func (i *IRCServer) sendMoreResults(s *Session, reply *Replyctx, command, next string) {
+	if next == "" {
+		return
+	}
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: rplMoreResults,
+		Params:  []string{s.Nick, command, "+" + next, "More results available"},
+	})
+}
+
+// tooManyTargets sends ERR_TOOMANYTARGETS and returns true if |targets|
+// exceeds Config.MaxTargets.
+func (i *IRCServer) tooManyTargets(s *Session, reply *Replyctx, targets []string) bool {
+	max := i.maxTargets()
+	if max <= 0 || len(targets) <= max {
+		return false
+	}
+	i.sendUser(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.ERR_TOOMANYTARGETS,
+		Params:  []string{s.Nick, strings.Join(targets, ","), "Too many targets (maximum is " + strconv.Itoa(max) + ")"},
+	})
+	return true
+}
@@ -0,0 +1,114 @@
+package ircserver
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestPaginate(t *testing.T) {
+	sorted := []string{"a", "b", "c", "d", "e"}
+	for _, tt := range []struct {
+		cursor   string
+		pageSize int
+		page     []string
+		next     string
+	}{
+		{"", 0, sorted, ""},
+		{"", 5, sorted, ""},
+		{"", 2, []string{"a", "b"}, "b"},
+		{"b", 2, []string{"c", "d"}, "d"},
+		{"d", 2, []string{"e"}, ""},
+		// The cursor does not need to be contained in the list anymore.
+		{"bb", 2, []string{"c", "d"}, "d"},
+		{"z", 2, []string{}, ""},
+	} {
+		page, next := paginate(sorted, tt.cursor, tt.pageSize)
+		if !reflect.DeepEqual(page, tt.page) || next != tt.next {
+			t.Errorf("paginate(%v, %q, %d) = %v, %q, want %v, %q",
+				sorted, tt.cursor, tt.pageSize, page, next, tt.page, tt.next)
+		}
+	}
+}
+
+func TestReplyPagination(t *testing.T) {
+	i, ids := stdIRCServer()
+	i.Config.ReplyPageSize = 2
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #a,#b,#c"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #a"))
+	i.ProcessMessage(&robust.Message{Session: ids["xeen"]}, irc.ParseMessage("JOIN #a"))
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("LIST")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 322 sECuRE #a 3 :"),
+			irc.ParseMessage(":robustirc.net 322 sECuRE #b 1 :"),
+			irc.ParseMessage(":robustirc.net 799 sECuRE LIST +#b :More results available"),
+			irc.ParseMessage(":robustirc.net 323 sECuRE :End of LIST"),
+		})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("LIST * +#b")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 322 sECuRE #c 1 :"),
+			irc.ParseMessage(":robustirc.net 323 sECuRE :End of LIST"),
+		})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("WHO #a")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 352 sECuRE #a foo robust/0x13b5aa0a2bcfb8ae robustirc.net mero H :0 Axel Wagner"),
+			irc.ParseMessage(":robustirc.net 352 sECuRE #a blah robust/0x13b5aa0a2bcfb8ad robustirc.net sECuRE H :0 Michael Stapelberg"),
+			irc.ParseMessage(":robustirc.net 799 sECuRE WHO +sECuRE :More results available"),
+			irc.ParseMessage(":robustirc.net 315 sECuRE #a :End of /WHO list"),
+		})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("WHO #a +sECuRE")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 352 sECuRE #a baz robust/0x13b5aa0a2bcfb8af robustirc.net xeen H :0 Iks Enn"),
+			irc.ParseMessage(":robustirc.net 315 sECuRE #a :End of /WHO list"),
+		})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NAMES #a")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 353 sECuRE = #a :@sECuRE mero"),
+			irc.ParseMessage(":robustirc.net 799 sECuRE NAMES +mero :More results available"),
+			irc.ParseMessage(":robustirc.net 366 sECuRE #a :End of /NAMES list."),
+		})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("NAMES #a +mero")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 353 sECuRE = #a :xeen"),
+			irc.ParseMessage(":robustirc.net 366 sECuRE #a :End of /NAMES list."),
+		})
+}
+
+func TestMaxTargets(t *testing.T) {
+	i, ids := stdIRCServer()
+	i.Config.MaxTargets = 2
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #a,#b,#c")),
+		":robustirc.net 407 sECuRE #a,#b,#c :Too many targets (maximum is 2)")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("LIST #a,#b,#c")),
+		":robustirc.net 407 sECuRE #a,#b,#c :Too many targets (maximum is 2)")
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #a"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("KICK #a mero,xeen,sECuRE")),
+		":robustirc.net 407 sECuRE mero,xeen,sECuRE :Too many targets (maximum is 2)")
+
+	if got, want := i.isupportLimits(), []string{"MAXTARGETS=2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("isupportLimits() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package ircserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robustirc/robustirc/plugin"
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// lookupCommand returns the built-in command |command| or, if |command| is of
+// the form NAMESPACE.VERB, the corresponding plugin command.
+func lookupCommand(command string) (*ircCommand, bool) {
+	if cmd, ok := Commands[command]; ok {
+		return cmd, true
+	}
+	p, pcmd, ok := plugin.Lookup(command)
+	if !ok {
+		return nil, false
+	}
+	namespace := p.Namespace()
+	return &ircCommand{
+		Func: func(i *IRCServer, s *Session, reply *Replyctx, msg *irc.Message) {
+			pcmd.Func(&pluginContext{
+				i:         i,
+				s:         s,
+				reply:     reply,
+				namespace: namespace,
+				readOnly:  pcmd.ReadOnly,
+			}, msg)
+		},
+		MinParams: pcmd.MinParams,
+		ReadOnly:  pcmd.ReadOnly,
+	}, true
+}
+
+// pluginContext implements plugin.Context on top of the send* functions.
+type pluginContext struct {
+	i         *IRCServer
+	s         *Session
+	reply     *Replyctx
+	namespace string
+	readOnly  bool
+}
+
+func (c *pluginContext) ServerName() string { return c.i.ServerPrefix.Name }
+
+func (c *pluginContext) Nick() string { return c.s.Nick }
+
+func (c *pluginContext) Operator() bool { return c.s.Operator }
+
+func (c *pluginContext) Config() map[string]string {
+	c.i.ConfigMu.RLock()
+	defer c.i.ConfigMu.RUnlock()
+	return c.i.Config.Plugins[c.namespace]
+}
+
+func (c *pluginContext) Reply(msg *irc.Message) {
+	c.i.sendUser(c.s, c.reply, msg)
+}
+
+// mustModify panics if the command is marked ReadOnly, as sending messages to
+// other sessions must go through raft.
+func (c *pluginContext) mustModify(method string) {
+	if c.readOnly {
+		panic(fmt.Sprintf("plugin %q: %s called from a ReadOnly command", c.namespace, method))
+	}
+}
+
+func (c *pluginContext) SendNick(nick string, msg *irc.Message) bool {
+	c.mustModify("SendNick")
+	session, ok := c.i.nicks[NickToLower(nick)]
+	if !ok {
+		return false
+	}
+	c.i.sendUser(session, c.reply, msg)
+	return true
+}
+
+func (c *pluginContext) SendChannel(channel string, msg *irc.Message) bool {
+	c.mustModify("SendChannel")
+	ch, ok := c.i.channels[ChanToLower(channel)]
+	if !ok {
+		return false
+	}
+	c.i.sendChannel(ch, c.reply, msg)
+	return true
+}
+
+func (c *pluginContext) SendServices(msg *irc.Message) {
+	c.mustModify("SendServices")
+	c.i.sendServices(c.reply, msg)
+}
+
+// flattenPluginConfig converts |cfg| into the snapshot representation, which
+// is keyed by “<namespace>.<key>”. Namespaces never contain a dot.
+func flattenPluginConfig(cfg map[string]map[string]string) map[string]string {
+	if len(cfg) == 0 {
+		return nil
+	}
+	result := make(map[string]string)
+	for namespace, values := range cfg {
+		for key, value := range values {
+			result[namespace+"."+key] = value
+		}
+	}
+	return result
+}
+
+// unflattenPluginConfig is the inverse of flattenPluginConfig.
+func unflattenPluginConfig(flat map[string]string) map[string]map[string]string {
+	if len(flat) == 0 {
+		return nil
+	}
+	result := make(map[string]map[string]string)
+	for nskey, value := range flat {
+		namespace, key, _ := strings.Cut(nskey, ".")
+		if result[namespace] == nil {
+			result[namespace] = make(map[string]string)
+		}
+		result[namespace][key] = value
+	}
+	return result
+}
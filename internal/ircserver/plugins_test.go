@@ -0,0 +1,107 @@
+package ircserver
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+	"github.com/robustirc/robustirc/plugin"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+type testPlugin struct{}
+
+func (testPlugin) Namespace() string { return "test" }
+
+func (testPlugin) Commands() map[string]plugin.Command {
+	return map[string]plugin.Command{
+		"GREET": {
+			MinParams: 1,
+			Func: func(ctx plugin.Context, msg *irc.Message) {
+				greeting := ctx.Config()["greeting"]
+				if !ctx.SendNick(msg.Params[0], &irc.Message{
+					Prefix:  &irc.Prefix{Name: ctx.ServerName()},
+					Command: irc.NOTICE,
+					Params:  []string{msg.Params[0], fmt.Sprintf("%s from %s", greeting, ctx.Nick())},
+				}) {
+					ctx.Reply(&irc.Message{
+						Prefix:  &irc.Prefix{Name: ctx.ServerName()},
+						Command: irc.ERR_NOSUCHNICK,
+						Params:  []string{ctx.Nick(), msg.Params[0], "No such nick/channel"},
+					})
+				}
+			},
+		},
+		"WHOAMI": {
+			ReadOnly: true,
+			Func: func(ctx plugin.Context, msg *irc.Message) {
+				ctx.Reply(&irc.Message{
+					Prefix:  &irc.Prefix{Name: ctx.ServerName()},
+					Command: irc.NOTICE,
+					Params:  []string{ctx.Nick(), fmt.Sprintf("operator=%v", ctx.Operator())},
+				})
+			},
+		},
+	}
+}
+
+func (testPlugin) ValidateConfig(cfg map[string]string) error { return nil }
+
+func init() {
+	plugin.Register(testPlugin{})
+}
+
+func TestPluginCommands(t *testing.T) {
+	i, ids := stdIRCServer()
+	i.Config.Plugins = map[string]map[string]string{
+		"test": {"greeting": "hello"},
+	}
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("TEST.GREET")),
+		":robustirc.net 461 sECuRE TEST.GREET :Not enough parameters")
+
+	mustMatchInterested(t, i,
+		ids["secure"], irc.ParseMessage("test.greet mero"),
+		[]robust.Id{ids["secure"], ids["mero"], ids["xeen"]},
+		[]bool{false, true, false})
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("TEST.GREET nobody")),
+		":robustirc.net 401 sECuRE nobody :No such nick/channel")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("TEST.NOPE")),
+		":robustirc.net 421 sECuRE TEST.NOPE :Unknown command")
+
+	if IsQueryCommand("TEST.GREET") {
+		t.Fatalf("IsQueryCommand(TEST.GREET) = true, want false")
+	}
+	if !IsQueryCommand("test.whoami") {
+		t.Fatalf("IsQueryCommand(test.whoami) = false, want true")
+	}
+	reply, err := i.ProcessQuery(ids["secure"], irc.ParseMessage("TEST.WHOAMI"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustMatchMsg(t, reply, ":robustirc.net NOTICE sECuRE :operator=false")
+}
+
+func TestPluginConfigFlattening(t *testing.T) {
+	cfg := map[string]map[string]string{
+		"test":   {"greeting": "hello", "with.dot": "x"},
+		"deploy": {"channel": "#ops"},
+	}
+	flat := flattenPluginConfig(cfg)
+	if got, want := flat["test.with.dot"], "x"; got != want {
+		t.Fatalf("flat[test.with.dot] = %q, want %q", got, want)
+	}
+	if got := unflattenPluginConfig(flat); !reflect.DeepEqual(got, cfg) {
+		t.Fatalf("unflattenPluginConfig(flattenPluginConfig(%v)) = %v", cfg, got)
+	}
+	if got := flattenPluginConfig(nil); got != nil {
+		t.Fatalf("flattenPluginConfig(nil) = %v, want nil", got)
+	}
+}
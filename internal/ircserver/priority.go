@@ -0,0 +1,49 @@
+package ircserver
+
+import "github.com/robustirc/robustirc/internal/robust"
+
+// Priority classifies sessions for load shedding: when the network is
+// overloaded, messages of lower priority sessions are rejected first.
+type Priority int
+
+const (
+	PriorityUser Priority = iota
+	PriorityBot
+	PriorityOperator
+	PriorityServices
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityUser:
+		return "user"
+	case PriorityBot:
+		return "bot"
+	case PriorityOperator:
+		return "operator"
+	case PriorityServices:
+		return "services"
+	}
+	return "unknown"
+}
+
+// SessionPriority returns the Priority of |sessionid|. Bots are sessions
+// with user mode +B, which only operators and services can set. Unknown
+// sessions are treated as users.
+func (i *IRCServer) SessionPriority(sessionid robust.Id) Priority {
+	i.sessionsMu.RLock()
+	defer i.sessionsMu.RUnlock()
+	s, ok := i.sessions[sessionid]
+	if !ok {
+		return PriorityUser
+	}
+	switch {
+	case s.Server:
+		return PriorityServices
+	case s.Operator:
+		return PriorityOperator
+	case s.modes['B']:
+		return PriorityBot
+	}
+	return PriorityUser
+}
@@ -0,0 +1,136 @@
+package ircserver
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/robustirc/robustirc/internal/robust"
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// ErrNotAQuery is returned by ProcessQuery when the message cannot be
+// answered without going through raft, e.g. because it modifies state.
+var ErrNotAQuery = errors.New("Message is not a query")
+
+// ErrNotReadOnly is returned by ProcessQuery when a command which is marked
+// ReadOnly sent messages to other sessions, which must go through raft.
+var ErrNotReadOnly = errors.New("ReadOnly command sent messages to other sessions")
+
+// IsQueryCommand returns true if |command| can be answered via ProcessQuery,
+// i.e. if it is marked ReadOnly. The output of such commands only depends on
+// the state that was applied so far, so any node can answer them locally
+// without appending to the raft log.
+func IsQueryCommand(command string) bool {
+	cmd, ok := lookupCommand(strings.ToUpper(command))
+	return ok && cmd.ReadOnly
+}
+
+// IsCommand returns true if |command| is handled by the IRC server, including
+// commands provided by plugins.
+func IsCommand(command string) bool {
+	_, ok := lookupCommand(strings.ToUpper(command))
+	return ok
+}
+
+// ProcessQuery answers |ircmsg| on behalf of |sessionid| from the current
+// state, without modifying it. In contrast to ProcessMessage, the resulting
+// messages are not part of the (replicated) output stream: they are only
+// interesting for |sessionid| and their ids are left for the caller to fill
+// in.
+func (i *IRCServer) ProcessQuery(sessionid robust.Id, ircmsg *irc.Message) (*Replyctx, error) {
+	return i.ProcessQueryWithNodeInfo(sessionid, ircmsg, nil)
+}
+
+// ConnectionInfo describes a GetMessages request of a session.
+type ConnectionInfo struct {
+	// Node is the address of the node which serves the GetMessages request.
+	Node string
+
+	// RemoteAddr is the address of the client, as provided by the bridge
+	// (if trusted) or as seen by Node.
+	RemoteAddr string
+
+	// QueueDepth is the number of output messages which the GetMessages
+	// request has not yet delivered.
+	QueueDepth uint64
+}
+
+// PeerInfo describes a raft peer.
+type PeerInfo struct {
+	// Addr is the raft address of the peer, e.g. "fastbox.robustirc.net:60667".
+	Addr string
+
+	// State is the raft state of the peer, e.g. "leader" or "follower".
+	State string
+
+	// Self is true for the node which answers the query.
+	Self bool
+}
+
+// NodeInfo is node-local information and hence not part of the IRCServer
+// state, so it is only available to queries which are answered locally.
+type NodeInfo struct {
+	// Connections are the GetMessages requests of all nodes, keyed by
+	// session id (used by TRACE).
+	Connections map[uint64][]ConnectionInfo
+
+	// Peers is the raft peer set, sorted by address (used by MAP and LINKS).
+	Peers []PeerInfo
+
+	// CommandCounts is how often each command was posted to this node
+	// (used by STATS m).
+	CommandCounts map[string]uint64
+
+	// MessagesReceived is the number of messages which each session posted
+	// to this node, keyed by session id (used by STATS l).
+	MessagesReceived map[uint64]uint64
+}
+
+// ProcessQueryWithNodeInfo is like ProcessQuery, but additionally makes
+// |info| available to the query.
+func (i *IRCServer) ProcessQueryWithNodeInfo(sessionid robust.Id, ircmsg *irc.Message, info *NodeInfo) (*Replyctx, error) {
+	if ircmsg == nil || !IsQueryCommand(ircmsg.Command) {
+		return nil, ErrNotAQuery
+	}
+
+	i.sessionsMu.RLock()
+	defer i.sessionsMu.RUnlock()
+
+	s, err := i.getSessionLocked(sessionid)
+	if err != nil {
+		return nil, err
+	}
+
+	command := strings.ToUpper(ircmsg.Command)
+	cmd, _ := lookupCommand(command)
+	// Leave error handling (e.g. ERR_NOTREGISTERED) to ProcessMessage, so
+	// that we do not need to duplicate it here.
+	if !s.loggedIn || s.Server || len(ircmsg.Params) < cmd.MinParams {
+		return nil, ErrNotAQuery
+	}
+
+	messagesProcessed.WithLabelValues(command).Inc()
+
+	reply := &Replyctx{session: s, nodeInfo: info}
+	cmd.Func(i, s, reply, ircmsg)
+	if err := checkReadOnly(s, reply); err != nil {
+		log.Printf("BUG: %s: %v", command, err)
+		return nil, err
+	}
+	i.localizeNumerics(reply)
+	return reply, nil
+}
+
+// checkReadOnly returns ErrNotReadOnly if |reply| contains messages for
+// sessions other than |s|. The output of queries is only delivered to |s|.
+func checkReadOnly(s *Session, reply *Replyctx) error {
+	for _, msg := range reply.Messages {
+		for session, interested := range msg.InterestingFor {
+			if interested && session != s.Id.Id {
+				return ErrNotReadOnly
+			}
+		}
+	}
+	return nil
+}
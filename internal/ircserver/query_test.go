@@ -0,0 +1,62 @@
+package ircserver
+
+import (
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestProcessQuery(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+
+	got, err := i.ProcessQuery(ids["mero"], irc.ParseMessage("WHO #test"))
+	if err != nil {
+		t.Fatalf("ProcessQuery(WHO): %v", err)
+	}
+	mustMatchIrcmsgs(t, got, []*irc.Message{
+		irc.ParseMessage(":robustirc.net 352 mero #test blah robust/0x13b5aa0a2bcfb8ad robustirc.net sECuRE H :0 Michael Stapelberg"),
+		irc.ParseMessage(":robustirc.net 315 mero #test :End of /WHO list"),
+	})
+	for _, msg := range got.Messages {
+		if len(msg.InterestingFor) != 1 || !msg.InterestingFor[ids["mero"].Id] {
+			t.Fatalf("reply %q: InterestingFor = %v, want only mero", msg.Data, msg.InterestingFor)
+		}
+	}
+
+	if _, err := i.ProcessQuery(ids["mero"], irc.ParseMessage("JOIN #test")); err != ErrNotAQuery {
+		t.Fatalf("ProcessQuery(JOIN): got %v, want %v", err, ErrNotAQuery)
+	}
+
+	if _, err := i.ProcessQuery(ids["mero"], irc.ParseMessage("WHOIS")); err != ErrNotAQuery {
+		t.Fatalf("ProcessQuery(WHOIS) without parameters: got %v, want %v", err, ErrNotAQuery)
+	}
+
+	if _, err := i.ProcessQuery(robust.Id{Id: 1}, irc.ParseMessage("LIST")); err != ErrSessionNotYetSeen {
+		t.Fatalf("ProcessQuery() for unknown session: got %v, want %v", err, ErrSessionNotYetSeen)
+	}
+}
+
+func TestReadOnlyRejectsSendingToOthers(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	Commands["TEST.BROKEN"] = &ircCommand{
+		Func: func(i *IRCServer, s *Session, reply *Replyctx, msg *irc.Message) {
+			i.sendUser(i.sessions[ids["secure"]], reply, &irc.Message{Command: irc.NOTICE, Params: []string{"sECuRE", "hi"}})
+		},
+		ReadOnly: true,
+	}
+	defer delete(Commands, "TEST.BROKEN")
+
+	if _, err := i.ProcessQuery(ids["mero"], irc.ParseMessage("TEST.BROKEN")); err != ErrNotReadOnly {
+		t.Fatalf("ProcessQuery(TEST.BROKEN): got %v, want %v", err, ErrNotReadOnly)
+	}
+
+	// Going through raft must not make a difference.
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("TEST.BROKEN")),
+		":robustirc.net NOTICE mero :Rejecting TEST.BROKEN: ReadOnly command sent messages to other sessions")
+}
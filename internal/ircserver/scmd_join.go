@@ -42,6 +42,7 @@ func (i *IRCServer) cmdServerJoin(s *Session, reply *Replyctx, msg *irc.Message)
 			c = &channel{
 				name:  channelname,
 				nicks: make(map[lcNick]*[maxChanMemberStatus]bool),
+				ts:    s.LastActivity.Unix(),
 			}
 			i.channels[ChanToLower(channelname)] = c
 		}
@@ -22,15 +22,33 @@ func (i *IRCServer) cmdServerMode(s *Session, reply *Replyctx, msg *irc.Message)
 	}
 
 	// TODO(secure): possibly refactor this with cmdMode()
-	modes := normalizeModes(msg)
+	modes := channelModeTable.normalize(msg)
+	var applied []modeCmd
 	for _, mode := range modes {
 		char := mode.Mode[1]
 		newvalue := (mode.Mode[0] == '+')
 
 		switch char {
-		case 't', 's', 'r', 'i':
+		case 't', 's', 'r', 'i', 'P', 'R':
 			c.modes[char] = newvalue
-		case 'o':
+		case 'k':
+			if newvalue && !validChannelKey(mode.Param) {
+				i.sendServices(reply, &irc.Message{
+					Prefix:  i.ServerPrefix,
+					Command: errInvalidKey,
+					Params:  []string{msg.Prefix.Name, channelname, "Key is not well-formed"},
+				})
+				continue
+			}
+			setChannelKey(c, newvalue, mode.Param)
+		case 'l':
+			param, ok := setChannelLimit(c, newvalue, mode.Param)
+			if !ok {
+				continue
+			}
+			mode.Param = param
+		case 'q', 'a', 'o', 'h', 'v':
+			status, _ := prefixModeStatus(rune(char))
 			nick := mode.Param
 			perms, ok := c.nicks[NickToLower(nick)]
 			if !ok {
@@ -39,27 +57,29 @@ func (i *IRCServer) cmdServerMode(s *Session, reply *Replyctx, msg *irc.Message)
 					Command: irc.ERR_USERNOTINCHANNEL,
 					Params:  []string{msg.Prefix.Name, nick, channelname, "They aren't on that channel"},
 				})
-			} else {
-				// If the user already is a chanop, silently do
-				// nothing (like UnrealIRCd).
-				if perms[chanop] != newvalue {
-					c.nicks[NickToLower(nick)][chanop] = newvalue
-				}
+				continue
 			}
+			// If the user already has the status, silently do nothing
+			// (like UnrealIRCd).
+			perms[status] = newvalue
 		default:
 			i.sendServices(reply, &irc.Message{
 				Prefix:  i.ServerPrefix,
 				Command: irc.ERR_UNKNOWNMODE,
 				Params:  []string{msg.Prefix.Name, string(char), "is unknown mode char to me"},
 			})
+			continue
 		}
+		applied = append(applied, mode)
 	}
-	if reply.replyid > 0 {
+	if reply.replyid > 0 || len(applied) == 0 {
 		return
 	}
 	i.sendChannel(c, reply, &irc.Message{
 		Prefix:  servicesPrefix(msg.Prefix),
 		Command: irc.MODE,
-		Params:  append([]string{channelname}, modeCmds(modes).IRCParams()...),
+		Params:  append([]string{channelname}, modeCmds(applied).IRCParams()...),
 	})
+	// Removing +P from an empty channel deletes it.
+	i.maybeDeleteChannelLocked(c)
 }
@@ -25,7 +25,7 @@ func (i *IRCServer) cmdServerNick(s *Session, reply *Replyctx, msg *irc.Message)
 	}
 
 	if _, ok := i.nicks[NickToLower(msg.Params[0])]; ok {
-		i.sendServices(reply, &irc.Message{
+		i.sendUser(s, reply, &irc.Message{
 			Prefix:  i.ServerPrefix,
 			Command: irc.ERR_NICKNAMEINUSE,
 			Params:  []string{"*", msg.Params[0], "Nickname is already in use"},
@@ -49,4 +49,22 @@ func (i *IRCServer) cmdServerNick(s *Session, reply *Replyctx, msg *irc.Message)
 	ss.Username = msg.Params[3]
 	ss.Realname = msg.Trailing()
 	ss.updateIrcPrefix()
+	i.sendMonitors(ss.Nick, ss, reply)
+	// Introduce the new client to the other linked servers, if any.
+	if len(i.serverSessions) > 1 {
+		i.sendOtherLinks(s, reply, &irc.Message{
+			Command: irc.NICK,
+			Params: []string{
+				ss.Nick,
+				"1", // hopcount
+				"1", // timestamp
+				ss.Username,
+				ss.ircPrefix.Host,
+				s.ircPrefix.Name,
+				ss.svid,
+				"+",
+				ss.Realname,
+			},
+		})
+	}
 }
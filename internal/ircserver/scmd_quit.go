@@ -1,6 +1,12 @@
 package ircserver
 
-import "gopkg.in/sorcix/irc.v2"
+import (
+	"sort"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
 
 func init() {
 	Commands["server_QUIT"] = &ircCommand{
@@ -11,20 +17,7 @@ func init() {
 func (i *IRCServer) cmdServerQuit(s *Session, reply *Replyctx, msg *irc.Message) {
 	// No prefix means the server quits the entire session.
 	if msg.Prefix == nil {
-		i.deleteSessionLocked(s, reply.msgid)
-		// For services, we also need to delete all sessions that share the
-		// same .Id, but have a different .Reply.
-		for id, session := range i.sessions {
-			if id.Id != s.Id.Id || id.Reply == 0 {
-				continue
-			}
-			i.sendCommonChannels(session, reply, &irc.Message{
-				Prefix:  &session.ircPrefix,
-				Command: irc.QUIT,
-				Params:  []string{msg.Trailing()},
-			})
-			i.deleteSessionLocked(session, reply.msgid)
-		}
+		i.deleteServerLocked(s, reply, msg.Trailing())
 		return
 	}
 
@@ -34,12 +27,37 @@ func (i *IRCServer) cmdServerQuit(s *Session, reply *Replyctx, msg *irc.Message)
 		if id.Id != s.Id.Id || id.Reply == 0 || NickToLower(session.Nick) != NickToLower(msg.Prefix.Name) {
 			continue
 		}
-		i.sendCommonChannels(session, reply, &irc.Message{
+		i.sendOtherLinks(s, reply, i.sendCommonChannels(session, reply, &irc.Message{
 			Prefix:  &session.ircPrefix,
 			Command: irc.QUIT,
 			Params:  []string{msg.Trailing()},
-		})
-		i.deleteSessionLocked(session, reply.msgid)
+		}))
+		i.deleteSessionLocked(session, reply, msg.Trailing())
 		return
 	}
 }
+
+// deleteServerLocked deletes the server session |s| and all sessions which
+// it introduced (e.g. NickServ), e.g. when services quit or reconnect.
+func (i *IRCServer) deleteServerLocked(s *Session, reply *Replyctx, reason string) {
+	i.deleteSessionLocked(s, reply, reason)
+	// For services, we also need to delete all sessions that share the
+	// same .Id, but have a different .Reply. They are deleted in a
+	// deterministic order, as their QUIT messages are sent to clients.
+	var ids []robust.Id
+	for id := range i.sessions {
+		if id.Id == s.Id.Id && id.Reply != 0 && !i.sessions[id].deleted {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a].Reply < ids[b].Reply })
+	for _, id := range ids {
+		session := i.sessions[id]
+		i.sendOtherLinks(s, reply, i.sendCommonChannels(session, reply, &irc.Message{
+			Prefix:  &session.ircPrefix,
+			Command: irc.QUIT,
+			Params:  []string{reason},
+		}))
+		i.deleteSessionLocked(session, reply, reason)
+	}
+}
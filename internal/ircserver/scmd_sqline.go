@@ -0,0 +1,56 @@
+package ircserver
+
+import (
+	"fmt"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func init() {
+	Commands["server_SQLINE"] = &ircCommand{
+		Func:      (*IRCServer).cmdServerSqline,
+		MinParams: 1,
+	}
+	Commands["server_UNSQLINE"] = &ircCommand{
+		Func:      (*IRCServer).cmdServerUnsqline,
+		MinParams: 1,
+	}
+}
+
+// cmdServerSqline reserves nicknames just like the QLINE operator command,
+// i.e. the reserved nicknames are part of the network configuration.
+func (i *IRCServer) cmdServerSqline(s *Session, reply *Replyctx, msg *irc.Message) {
+	// e.g. “SQLINE *Serv :Reserved for services”
+	pattern := msg.Params[0]
+	reason := "Reserved for services"
+	if len(msg.Params) > 1 {
+		reason = msg.Trailing()
+	}
+	if _, err := silencePattern(pattern); err != nil {
+		i.sendServices(reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.NOTICE,
+			Params:  []string{s.ircPrefix.Name, fmt.Sprintf("Invalid nickname pattern %q: %v", pattern, err)},
+		})
+		return
+	}
+	i.qline(pattern, reason)
+	i.sendOperators(nil, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.NOTICE,
+		Params:  []string{"*", fmt.Sprintf("*** Notice -- %s added Q-line for [%s] [%s]", s.ircPrefix.Name, pattern, reason)},
+	})
+}
+
+func (i *IRCServer) cmdServerUnsqline(s *Session, reply *Replyctx, msg *irc.Message) {
+	// e.g. “UNSQLINE *Serv”
+	pattern := msg.Params[0]
+	if !i.unqline(pattern) {
+		return
+	}
+	i.sendOperators(nil, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.NOTICE,
+		Params:  []string{"*", fmt.Sprintf("*** Notice -- %s has removed the Q-line for [%s]", s.ircPrefix.Name, pattern)},
+	})
+}
@@ -0,0 +1,31 @@
+package ircserver
+
+import (
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestServerSqline(t *testing.T) {
+	i, ids := stdIRCServerWithServices()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("OPER mero foo"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("SQLINE Stat* :Reserved for services")),
+		":robustirc.net NOTICE * :*** Notice -- services.robustirc.net added Q-line for [Stat*] [Reserved for services]")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("NICK StatBot")),
+		":robustirc.net 432 mero StatBot :Erroneous Nickname: Reserved for services")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("UNSQLINE Stat*")),
+		":robustirc.net NOTICE * :*** Notice -- services.robustirc.net has removed the Q-line for [Stat*]")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("NICK StatBot")),
+		":mero!foo@robust/0x13b5aa0a2bcfb8ae NICK StatBot")
+}
@@ -37,6 +37,7 @@ func (i *IRCServer) cmdServerSvsjoin(s *Session, reply *Replyctx, msg *irc.Messa
 		c = &channel{
 			name:  channelname,
 			nicks: make(map[lcNick]*[maxChanMemberStatus]bool),
+			ts:    s.LastActivity.Unix(),
 		}
 		i.channels[ChanToLower(channelname)] = c
 	}
@@ -57,7 +58,7 @@ func (i *IRCServer) cmdServerSvsjoin(s *Session, reply *Replyctx, msg *irc.Messa
 		Params:  []string{channelname},
 	})
 	var prefix string
-	if c.nicks[nick][chanop] {
+	if hasStatus(c.nicks[nick], chanop) {
 		prefix = prefix + string('@')
 	}
 	i.sendServices(reply, &irc.Message{
@@ -0,0 +1,44 @@
+package ircserver
+
+import (
+	"fmt"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func init() {
+	Commands["server_SVSKILL"] = &ircCommand{
+		Func:      (*IRCServer).cmdServerSvskill,
+		MinParams: 1,
+	}
+}
+
+func (i *IRCServer) cmdServerSvskill(s *Session, reply *Replyctx, msg *irc.Message) {
+	// e.g. “SVSKILL blArgh :Too many failed login attempts”
+	// Unlike KILL, the reason is used verbatim as quit message.
+	session, ok := i.nicks[NickToLower(msg.Params[0])]
+	if !ok || session.Server || session.Id.Reply != 0 {
+		i.sendServices(reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOSUCHNICK,
+			Params:  []string{"*", msg.Params[0], "No such nick/channel"},
+		})
+		return
+	}
+
+	reason := "SVSKilled"
+	if len(msg.Params) > 1 {
+		reason = msg.Trailing()
+	}
+	i.sendUser(session, reply, &irc.Message{
+		Command: irc.ERROR,
+		Params:  []string{fmt.Sprintf("Closing Link: %s[%s] (%s)", session.Nick, session.ircPrefix.Host, reason)},
+	})
+	i.sendServices(reply,
+		i.sendCommonChannels(session, reply, &irc.Message{
+			Prefix:  &session.ircPrefix,
+			Command: irc.QUIT,
+			Params:  []string{reason},
+		}))
+	i.deleteSessionLocked(session, reply, reason)
+}
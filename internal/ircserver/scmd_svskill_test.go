@@ -0,0 +1,31 @@
+package ircserver
+
+import (
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestServerSvskill(t *testing.T) {
+	i, ids := stdIRCServerWithServices()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("JOIN #test"))
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("SVSKILL secure :Too many failed logins")),
+		[]*irc.Message{
+			irc.ParseMessage("ERROR :Closing Link: sECuRE[robust/0x13b5aa0a2bcfb8ad] (Too many failed logins)"),
+			irc.ParseMessage(":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad QUIT :Too many failed logins"),
+		})
+
+	if _, ok := i.nicks[NickToLower("secure")]; ok {
+		t.Fatalf("sECuRE still present after SVSKILL")
+	}
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("SVSKILL secure :bye")),
+		":robustirc.net 401 * secure :No such nick/channel")
+}
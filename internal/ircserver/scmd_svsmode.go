@@ -32,7 +32,7 @@ func (i *IRCServer) cmdServerSvsmode(s *Session, reply *Replyctx, msg *irc.Messa
 		})
 		return
 	}
-	modes := normalizeModes(msg)
+	modes := userModeTable.normalize(msg)
 
 	// true for adding a mode, false for removing it
 	for _, mode := range modes {
@@ -44,6 +44,9 @@ func (i *IRCServer) cmdServerSvsmode(s *Session, reply *Replyctx, msg *irc.Messa
 		case 'r':
 			// Store registered flag
 			session.modes[char] = newvalue
+		case 'B':
+			// Bot flag, see SessionPriority.
+			session.modes[char] = newvalue
 		default:
 			i.sendServices(reply, &irc.Message{
 				Prefix:  i.ServerPrefix,
@@ -32,6 +32,7 @@ func (i *IRCServer) cmdServerSvsnick(s *Session, reply *Replyctx, msg *irc.Messa
 
 	// TODO(secure): kill this code duplication with cmdNick()
 	oldPrefix := session.ircPrefix
+	i.recordWhowas(session)
 	oldNick := NickToLower(msg.Params[0])
 	session.Nick = msg.Params[1]
 	i.nicks[NickToLower(session.Nick)] = session
@@ -50,4 +51,8 @@ func (i *IRCServer) cmdServerSvsnick(s *Session, reply *Replyctx, msg *irc.Messa
 				Command: irc.NICK,
 				Params:  []string{session.Nick},
 			})))
+	if oldNick != NickToLower(session.Nick) {
+		i.sendMonitors(oldPrefix.Name, nil, reply)
+		i.sendMonitors(session.Nick, session, reply)
+	}
 }
@@ -0,0 +1,72 @@
+package ircserver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func init() {
+	Commands["server_SVSNOOP"] = &ircCommand{
+		Func:      (*IRCServer).cmdServerSvsnoop,
+		MinParams: 2,
+	}
+}
+
+func (i *IRCServer) cmdServerSvsnoop(s *Session, reply *Replyctx, msg *irc.Message) {
+	// e.g. “SVSNOOP robustirc.net +”
+	// As all RobustIRC servers form a single IRC server, the server name can
+	// only refer to the entire network.
+	if msg.Params[0] != "*" && !strings.EqualFold(msg.Params[0], i.ServerPrefix.Name) {
+		i.sendServices(reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NOSUCHSERVER,
+			Params:  []string{"*", msg.Params[0], "No such server"},
+		})
+		return
+	}
+
+	enable := strings.HasPrefix(msg.Params[1], "+")
+	if enable == i.svsnoop {
+		return
+	}
+	i.svsnoop = enable
+	if !enable {
+		i.sendOperators(nil, reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.NOTICE,
+			Params:  []string{"*", fmt.Sprintf("*** Notice -- %s re-enabled IRC operator privileges", s.ircPrefix.Name)},
+		})
+		return
+	}
+
+	i.sendOperators(nil, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.NOTICE,
+		Params:  []string{"*", fmt.Sprintf("*** Notice -- %s disabled IRC operator privileges", s.ircPrefix.Name)},
+	})
+	var operators []*Session
+	for _, session := range i.sessions {
+		if session.Operator && !session.Server && !session.deleted {
+			operators = append(operators, session)
+		}
+	}
+	sort.Slice(operators, func(a, b int) bool { return operators[a].Created < operators[b].Created })
+	for _, session := range operators {
+		modestr := "-o"
+		if session.modes['A'] {
+			modestr += "A"
+		}
+		session.Operator = false
+		session.modes['o'] = false
+		session.modes['A'] = false
+		i.sendServices(reply,
+			i.sendUser(session, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.MODE,
+				Params:  []string{session.Nick, modestr},
+			}))
+	}
+}
@@ -0,0 +1,58 @@
+package ircserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestServerSvsnoop(t *testing.T) {
+	i, ids := stdIRCServerWithServices()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("OPER mero foo"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("SVSNOOP irc.example.net +")),
+		":robustirc.net 402 * irc.example.net :No such server")
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("SVSNOOP robustirc.net +")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net NOTICE * :*** Notice -- services.robustirc.net disabled IRC operator privileges"),
+			irc.ParseMessage(":robustirc.net MODE sECuRE -o"),
+		})
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("OPER mero foo")),
+		":robustirc.net 491 mero :No O-lines for your host")
+
+	// The state survives snapshots.
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewIRCServer("robustirc.net", time.Now())
+	if _, err := restored.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+	restored.Config = i.Config
+	i = restored
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("OPER mero foo")),
+		":robustirc.net 491 mero :No O-lines for your host")
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("SVSNOOP robustirc.net -")),
+		[]*irc.Message{})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("OPER mero foo")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 381 mero :You are now an IRC operator"),
+			irc.ParseMessage(":robustirc.net MODE mero +o"),
+		})
+}
@@ -11,6 +11,10 @@ import (
 func init() {
 	Commands["server_TOPIC"] = &ircCommand{
 		Func:      (*IRCServer).cmdServerTopic,
+		MinParams: 2,
+	}
+	Commands["server_TB"] = &ircCommand{
+		Func:      (*IRCServer).cmdServerTb,
 		MinParams: 3,
 	}
 }
@@ -28,7 +32,7 @@ func (i *IRCServer) cmdServerTopic(s *Session, reply *Replyctx, msg *irc.Message
 		return
 	}
 
-	// “TOPIC :”, i.e. unset the topic.
+	// “TOPIC #chan :”, i.e. unset the topic.
 	if msg.Trailing() == "" && len(msg.Params) == 2 {
 		c.topicNick = ""
 		c.topicTime = time.Time{}
@@ -41,6 +45,20 @@ func (i *IRCServer) cmdServerTopic(s *Session, reply *Replyctx, msg *irc.Message
 		return
 	}
 
+	// “TOPIC #chan :topic” (without setter and timestamp) sets the topic on
+	// behalf of the client which sent it.
+	if len(msg.Params) == 2 {
+		c.topicNick = msg.Prefix.Name
+		c.topicTime = s.LastActivity
+		c.topic = msg.Trailing()
+		i.sendChannel(c, reply, &irc.Message{
+			Prefix:  servicesPrefix(msg.Prefix),
+			Command: irc.TOPIC,
+			Params:  []string{channel, msg.Trailing()},
+		})
+		return
+	}
+
 	ts, err := strconv.ParseInt(msg.Params[2], 0, 64)
 	if err != nil {
 		i.sendServices(reply, &irc.Message{
@@ -61,3 +79,48 @@ func (i *IRCServer) cmdServerTopic(s *Session, reply *Replyctx, msg *irc.Message
 		Params:  []string{channel, msg.Trailing()},
 	})
 }
+
+// cmdServerTb handles topic bursts, which services send when linking in
+// order to restore topics (e.g. after a restart of the network):
+//
+//	TB <channel> <topic ts> [<setter>] :<topic>
+//
+// Unlike TOPIC, the topic is only changed if the channel has no topic or
+// the burst topic is older than the current topic.
+func (i *IRCServer) cmdServerTb(s *Session, reply *Replyctx, msg *irc.Message) {
+	channel := msg.Params[0]
+	c, ok := i.channels[ChanToLower(channel)]
+	if !ok {
+		// The channel may be gone by the time services burst.
+		return
+	}
+	ts, err := strconv.ParseInt(msg.Params[1], 10, 64)
+	if err != nil {
+		i.sendServices(reply, &irc.Message{
+			Prefix:  i.ServerPrefix,
+			Command: irc.ERR_NEEDMOREPARAMS,
+			Params:  []string{"*", channel, fmt.Sprintf("Could not parse timestamp: %v", err)},
+		})
+		return
+	}
+	topic := msg.Trailing()
+	if topic == "" || (c.topic != "" && ts >= c.topicTime.Unix()) {
+		return
+	}
+	c.topicNick = s.ircPrefix.Name
+	if len(msg.Params) > 3 {
+		c.topicNick = msg.Params[2]
+	}
+	c.topicTime = time.Unix(ts, 0)
+	if c.topic == topic {
+		// Only the setter and set time changed, which clients are not
+		// notified about.
+		return
+	}
+	c.topic = topic
+	i.sendChannel(c, reply, &irc.Message{
+		Prefix:  servicesPrefix(&s.ircPrefix),
+		Command: irc.TOPIC,
+		Params:  []string{c.name, topic},
+	})
+}
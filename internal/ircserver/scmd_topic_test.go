@@ -2,6 +2,7 @@ package ircserver
 
 import (
 	"testing"
+	"time"
 
 	"github.com/robustirc/robustirc/internal/robust"
 
@@ -22,12 +23,12 @@ func TestServerModeTopic(t *testing.T) {
 		":robustirc.net 403 ChanServ #toast :No such nick/channel")
 
 	mustMatchMsg(t,
-		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":ChanServ MODE #test +q blargh")),
-		":robustirc.net 472 ChanServ q :is unknown mode char to me")
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":ChanServ MODE #test +z blargh")),
+		":robustirc.net 472 ChanServ z :is unknown mode char to me")
 
 	mustMatchMsg(t,
-		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":ChanServ MODE #test q blargh")),
-		":robustirc.net 472 ChanServ q :is unknown mode char to me")
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":ChanServ MODE #test z blargh")),
+		":robustirc.net 472 ChanServ z :is unknown mode char to me")
 
 	mustMatchMsg(t,
 		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":ChanServ MODE #test -r+o secure")),
@@ -57,3 +58,66 @@ func TestServerModeTopic(t *testing.T) {
 		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":ChanServ TOPIC #test ChanServ 0 :")),
 		":ChanServ!services@services TOPIC #test :")
 }
+
+func TestServerTopicBurst(t *testing.T) {
+	i, ids := stdIRCServerWithServices()
+
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("TB #test 1422134861 ChanServ :restored topic")),
+		":services.robustirc.net!services@services TOPIC #test :restored topic")
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("TOPIC #test")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 332 sECuRE #test :restored topic"),
+			irc.ParseMessage(":robustirc.net 333 sECuRE #test ChanServ 1422134861"),
+		})
+
+	// A newer burst topic does not replace the existing topic.
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("TB #test 1422134999 ChanServ :newer topic")),
+		[]*irc.Message{})
+
+	// An older burst topic with the same text only updates setter and time.
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("TB #test 1422000000 :restored topic")),
+		[]*irc.Message{})
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("TOPIC #test")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 332 sECuRE #test :restored topic"),
+			irc.ParseMessage(":robustirc.net 333 sECuRE #test services.robustirc.net 1422000000"),
+		})
+
+	// Topics set without setter and timestamp are attributed to the sender.
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":ChanServ TOPIC #test :plain topic")),
+		":ChanServ!services@services TOPIC #test :plain topic")
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("TOPIC #test")),
+		[]*irc.Message{
+			irc.ParseMessage(":robustirc.net 332 sECuRE #test :plain topic"),
+			irc.ParseMessage(":robustirc.net 333 sECuRE #test ChanServ 1425052755"),
+		})
+
+	// Topics are part of the burst when services (re-)link.
+	ids["services2"] = robust.Id{Id: 0x13c6cdee3e749fb0}
+	i.CreateSession(ids["services2"], "auth-server", time.Unix(0, int64(ids["services2"].Id)))
+	i.ProcessMessage(&robust.Message{Session: ids["services2"]}, irc.ParseMessage("PASS :services=mypass"))
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services2"]}, irc.ParseMessage("SERVER stats.robustirc.net 1 :Stats")),
+		[]*irc.Message{
+			irc.ParseMessage("SERVER robustirc.net 1 23"),
+			irc.ParseMessage("NICK mero 1 1 foo robust/0x13b5aa0a2bcfb8ae robustirc.net 0 + :Axel Wagner"),
+			irc.ParseMessage("NICK sECuRE 1 1 blah robust/0x13b5aa0a2bcfb8ad robustirc.net 0 + :Michael Stapelberg"),
+			irc.ParseMessage(":robustirc.net SJOIN 1 #test :@sECuRE"),
+			irc.ParseMessage("NICK xeen 1 1 baz robust/0x13b5aa0a2bcfb8af robustirc.net 0 + :Iks Enn"),
+			irc.ParseMessage(":robustirc.net MODE #test +nt"),
+			irc.ParseMessage(":robustirc.net TB #test 1425052755 ChanServ :plain topic"),
+			irc.ParseMessage(":robustirc.net ENCAP * RETENTION 610 1425052145"),
+		})
+}
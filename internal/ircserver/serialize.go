@@ -3,6 +3,7 @@ package ircserver
 import (
 	"encoding/hex"
 	"regexp"
+	"sort"
 	"time"
 
 	"github.com/golang/protobuf/proto"
@@ -48,6 +49,18 @@ func (i *IRCServer) Marshal(lastIncludedIndex uint64) ([]byte, error) {
 				modes = append(modes, string(mode))
 			}
 		}
+		caps := make([]string, 0, len(session.caps))
+		for c := range session.caps {
+			caps = append(caps, c)
+		}
+		monitor := make([]string, 0, len(session.monitor))
+		for _, nick := range session.monitor {
+			monitor = append(monitor, nick)
+		}
+		silence := make([]string, len(session.silence))
+		for idx, b := range session.silence {
+			silence[idx] = b.pattern
+		}
 		loggedIn := pb.Bool_FALSE
 		if session.loggedIn {
 			loggedIn = pb.Bool_TRUE
@@ -73,12 +86,22 @@ func (i *IRCServer) Marshal(lastIncludedIndex uint64) ([]byte, error) {
 			Pass:                session.Pass,
 			Server:              session.Server,
 			LastClientMessageId: session.lastClientMessageId,
+			Caps:                caps,
+			CapNegotiating:      session.capNegotiating,
+			Silence:             silence,
+			Monitor:             monitor,
 			IrcPrefix: &pb.Snapshot_IRCPrefix{
 				Name: session.ircPrefix.Name,
 				User: session.ircPrefix.User,
 				Host: session.ircPrefix.Host,
 			},
-			RemoteAddr: session.RemoteAddr,
+			RemoteAddr:    session.RemoteAddr,
+			Hostname:      session.Hostname,
+			CloakedHost:   session.cloakedHost,
+			Account:       session.account,
+			SaslMechanism: session.saslMechanism,
+			Uid:           session.uid,
+			Link:          session.link,
 		})
 	}
 
@@ -110,6 +133,17 @@ func (i *IRCServer) Marshal(lastIncludedIndex uint64) ([]byte, error) {
 			bans[idx] = &pb.Snapshot_Channel_BanPattern{
 				Pattern: b.pattern,
 				Regexp:  b.re.String(),
+				SetBy:   b.setBy,
+				Set:     timeToTimestamp(b.set),
+			}
+		}
+		history := make([]*pb.Snapshot_Channel_History, len(channel.history))
+		for idx, entry := range channel.history {
+			history[idx] = &pb.Snapshot_Channel_History{
+				Prefix:  entry.prefix,
+				Command: entry.command,
+				Text:    entry.text,
+				Time:    timeToTimestamp(entry.time),
 			}
 		}
 		channels = append(channels, &pb.Snapshot_Channel{
@@ -120,6 +154,11 @@ func (i *IRCServer) Marshal(lastIncludedIndex uint64) ([]byte, error) {
 			Nicks:     nicks,
 			Modes:     modes,
 			Bans:      bans,
+			Seq:       channel.seq,
+			Key:       channel.key,
+			Limit:     uint64(channel.limit),
+			Ts:        channel.ts,
+			History:   history,
 		})
 	}
 
@@ -136,12 +175,14 @@ func (i *IRCServer) Marshal(lastIncludedIndex uint64) ([]byte, error) {
 		operators = append(operators, &pb.Snapshot_Config_IRC_Operator{
 			Name:     ircop.Name,
 			Password: ircop.Password,
+			Admin:    ircop.Admin,
 		})
 	}
 	services := make([]*pb.Snapshot_Config_IRC_Service, 0, len(i.Config.IRC.Services))
 	for _, service := range i.Config.IRC.Services {
 		services = append(services, &pb.Snapshot_Config_IRC_Service{
-			Password: service.Password,
+			Password:        service.Password,
+			CertFingerprint: service.CertFingerprint,
 		})
 	}
 	config := &pb.Snapshot_Config{
@@ -159,6 +200,46 @@ func (i *IRCServer) Marshal(lastIncludedIndex uint64) ([]byte, error) {
 		MaxSessions:             i.Config.MaxSessions,
 		MaxChannels:             i.Config.MaxChannels,
 		Banned:                  i.Config.Banned,
+		Qlines:                  i.Config.Qlines,
+		ClientCertAccounts:      i.Config.ClientCertAccounts,
+		GuestNickOnCollision:    i.Config.GuestNickOnCollision,
+		PrivacyFilter:           i.Config.PrivacyFilter,
+		WhowasHistory:           i.Config.WhowasHistory,
+		ChannelHistory:          i.Config.ChannelHistory,
+		Plugins:                 flattenPluginConfig(i.Config.Plugins),
+		MaxTargets:              i.Config.MaxTargets,
+		ReplyPageSize:           i.Config.ReplyPageSize,
+		MaxChannelsPerUser:      i.Config.MaxChannelsPerUser,
+		ResolveHostnames:        i.Config.ResolveHostnames,
+		ChannelTransforms:       i.Config.ChannelTransforms,
+		NumericTexts:            i.Config.NumericTexts,
+		CloakKey:                i.Config.CloakKey.String(),
+		StsDuration:             i.Config.STSDuration.String(),
+		StsPreload:              i.Config.STSPreload,
+		AdminLocation:           i.Config.Admin.Location,
+		AdminOrganization:       i.Config.Admin.Organization,
+		AdminEmail:              i.Config.Admin.Email,
+	}
+	whowas := make([]*pb.Snapshot_Whowas, len(i.whowas))
+	for idx, entry := range i.whowas {
+		whowas[idx] = &pb.Snapshot_Whowas{
+			Nick:     entry.nick,
+			Username: entry.username,
+			Host:     entry.host,
+			Realname: entry.realname,
+			Time:     timeToTimestamp(entry.time),
+		}
+	}
+	klines := make([]*pb.Snapshot_Kline, len(i.klines))
+	for idx, k := range i.klines {
+		klines[idx] = &pb.Snapshot_Kline{
+			Mask:    k.mask.pattern,
+			Reason:  k.reason,
+			SetBy:   k.setBy,
+			Set:     timeToTimestamp(k.set),
+			Expires: timeToTimestamp(k.expires),
+			Kind:    k.kind,
+		}
 	}
 	snapshot := pb.Snapshot{
 		Sessions:          sessions,
@@ -167,10 +248,49 @@ func (i *IRCServer) Marshal(lastIncludedIndex uint64) ([]byte, error) {
 		LastProcessed:     &pb.RobustId{Id: i.lastProcessed.Id, Reply: i.lastProcessed.Reply},
 		Config:            config,
 		LastIncludedIndex: lastIncludedIndex,
+
+		SnoticeWindow:         i.snotices.window,
+		SnoticesSent:          i.snotices.sent,
+		SuppressedConnects:    i.snotices.suppressedConnects,
+		SuppressedDisconnects: i.snotices.suppressedDisconnects,
+
+		Whowas: whowas,
+		Klines: klines,
+
+		RehashRequested: i.rehashRequested,
+		LastUid:         i.lastUID,
+		Svsnoop:         i.svsnoop,
 	}
 	return proto.Marshal(&snapshot)
 }
 
+// CanonicalState returns the state of |i| like Marshal, but with all fields
+// which Marshal fills in map iteration order sorted, so that the states of
+// two IRCServers can be compared, e.g. in tests.
+func (i *IRCServer) CanonicalState() (*pb.Snapshot, error) {
+	data, err := i.Marshal(0)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot pb.Snapshot
+	if err := proto.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	sort.Slice(snapshot.Sessions, func(a, b int) bool {
+		return snapshot.Sessions[a].Id.Id < snapshot.Sessions[b].Id.Id
+	})
+	for _, s := range snapshot.Sessions {
+		sort.Strings(s.Channels)
+		sort.Strings(s.InvitedTo)
+		sort.Strings(s.Caps)
+		sort.Strings(s.Monitor)
+	}
+	sort.Slice(snapshot.Channels, func(a, b int) bool {
+		return snapshot.Channels[a].Name < snapshot.Channels[b].Name
+	})
+	return &snapshot, nil
+}
+
 // Unmarshal treats |data| as a protobuf-encoded snapshot of IRCServer
 // state and applies it to the IRCServer. It returns the last included
 // ircstore index of the snapshot.
@@ -193,6 +313,24 @@ func (i *IRCServer) Unmarshal(data []byte) (uint64, error) {
 		for _, mode := range s.Modes {
 			modes[mode[0]] = true
 		}
+		// Sessions from snapshots which predate CAP support did not
+		// negotiate any capabilities, so they correctly end up with none.
+		caps := make(map[string]bool, len(s.Caps))
+		for _, c := range s.Caps {
+			caps[c] = true
+		}
+		monitor := make(map[lcNick]string, len(s.Monitor))
+		for _, nick := range s.Monitor {
+			monitor[NickToLower(nick)] = nick
+		}
+		silence := make([]banPattern, len(s.Silence))
+		for idx, mask := range s.Silence {
+			b, err := silencePattern(mask)
+			if err != nil {
+				return 0, err
+			}
+			silence[idx] = b
+		}
 		loggedIn := false
 		switch s.LoggedIn {
 		case pb.Bool_UNSET:
@@ -223,6 +361,10 @@ func (i *IRCServer) Unmarshal(data []byte) (uint64, error) {
 			Created:             created,
 			throttlingExponent:  int(s.ThrottlingExponent),
 			invitedTo:           invitedTo,
+			caps:                caps,
+			capNegotiating:      s.CapNegotiating,
+			silence:             silence,
+			monitor:             monitor,
 			modes:               modes,
 			svid:                s.Svid,
 			Pass:                s.Pass,
@@ -233,16 +375,25 @@ func (i *IRCServer) Unmarshal(data []byte) (uint64, error) {
 				User: s.IrcPrefix.User,
 				Host: s.IrcPrefix.Host,
 			},
-			RemoteAddr: s.RemoteAddr,
+			RemoteAddr:    s.RemoteAddr,
+			Hostname:      s.Hostname,
+			cloakedHost:   s.CloakedHost,
+			account:       s.Account,
+			saslMechanism: s.SaslMechanism,
+			uid:           s.Uid,
+			link:          s.Link,
 		}
 		if newSession.LastNonPing.IsZero() {
 			newSession.LastNonPing = newSession.LastActivity
 		}
 		i.sessions[newSession.Id] = newSession
 		if s.Server {
-			i.serverSessions = append(i.serverSessions, newSession.Id.Id)
+			i.addServerSession(newSession)
 		}
 		i.nicks[NickToLower(newSession.Nick)] = newSession
+		for nick := range monitor {
+			i.addMonitorLocked(newSession, nick)
+		}
 	}
 	for _, c := range snapshot.Channels {
 		nicks := make(map[lcNick]*[maxChanMemberStatus]bool, len(c.Nicks))
@@ -266,8 +417,19 @@ func (i *IRCServer) Unmarshal(data []byte) (uint64, error) {
 			bans[idx] = banPattern{
 				pattern: ban.Pattern,
 				re:      re,
+				setBy:   ban.SetBy,
+				set:     timestampToTime(ban.Set),
 			}
 		}
+		var history []historyEntry
+		for _, entry := range c.History {
+			history = append(history, historyEntry{
+				prefix:  entry.Prefix,
+				command: entry.Command,
+				text:    entry.Text,
+				time:    timestampToTime(entry.Time),
+			})
+		}
 		newChannel := channel{
 			name:      c.Name,
 			topicNick: c.TopicNick,
@@ -276,6 +438,11 @@ func (i *IRCServer) Unmarshal(data []byte) (uint64, error) {
 			nicks:     nicks,
 			modes:     modes,
 			bans:      bans,
+			seq:       c.Seq,
+			key:       c.Key,
+			limit:     int(c.Limit),
+			ts:        c.Ts,
+			history:   history,
 		}
 		i.channels[ChanToLower(newChannel.name)] = &newChannel
 	}
@@ -294,17 +461,53 @@ func (i *IRCServer) Unmarshal(data []byte) (uint64, error) {
 		Id:    snapshot.LastProcessed.Id,
 		Reply: snapshot.LastProcessed.Reply,
 	}
+	i.snotices = snoticeState{
+		window:                snapshot.SnoticeWindow,
+		sent:                  snapshot.SnoticesSent,
+		suppressedConnects:    snapshot.SuppressedConnects,
+		suppressedDisconnects: snapshot.SuppressedDisconnects,
+	}
+	i.whowas = make([]whowasEntry, len(snapshot.Whowas))
+	for idx, entry := range snapshot.Whowas {
+		i.whowas[idx] = whowasEntry{
+			nick:     entry.Nick,
+			username: entry.Username,
+			host:     entry.Host,
+			realname: entry.Realname,
+			time:     timestampToTime(entry.Time),
+		}
+	}
+	i.klines = make([]kline, 0, len(snapshot.Klines))
+	for _, k := range snapshot.Klines {
+		pattern, err := silencePattern(k.Mask)
+		if err != nil {
+			return 0, err
+		}
+		i.klines = append(i.klines, kline{
+			kind:    k.Kind,
+			mask:    pattern,
+			reason:  k.Reason,
+			setBy:   k.SetBy,
+			set:     timestampToTime(k.Set),
+			expires: timestampToTime(k.Expires),
+		})
+	}
+	i.rehashRequested = snapshot.RehashRequested
+	i.lastUID = snapshot.LastUid
+	i.svsnoop = snapshot.Svsnoop
 	operators := make([]config.IRCOp, len(snapshot.Config.Irc.Operators))
 	for idx, operator := range snapshot.Config.Irc.Operators {
 		operators[idx] = config.IRCOp{
 			Name:     operator.Name,
 			Password: operator.Password,
+			Admin:    operator.Admin,
 		}
 	}
 	services := make([]config.Service, len(snapshot.Config.Irc.Services))
 	for idx, service := range snapshot.Config.Irc.Services {
 		services[idx] = config.Service{
-			Password: service.Password,
+			Password:        service.Password,
+			CertFingerprint: service.CertFingerprint,
 		}
 	}
 	sessionExpiration, err := time.ParseDuration(snapshot.Config.SessionExpiration)
@@ -319,6 +522,17 @@ func (i *IRCServer) Unmarshal(data []byte) (uint64, error) {
 	if err != nil {
 		return 0, err
 	}
+	cloakKey, err := hex.DecodeString(snapshot.Config.CloakKey)
+	if err != nil {
+		return 0, err
+	}
+	var stsDuration time.Duration
+	// Snapshots taken before STS was introduced do not contain a duration.
+	if snapshot.Config.StsDuration != "" {
+		if stsDuration, err = time.ParseDuration(snapshot.Config.StsDuration); err != nil {
+			return 0, err
+		}
+	}
 	i.Config = config.Network{
 		Revision: snapshot.Config.Revision,
 		IRC: config.IRC{
@@ -334,6 +548,27 @@ func (i *IRCServer) Unmarshal(data []byte) (uint64, error) {
 		MaxSessions:             snapshot.Config.MaxSessions,
 		MaxChannels:             snapshot.Config.MaxChannels,
 		Banned:                  snapshot.Config.Banned,
+		Qlines:                  snapshot.Config.Qlines,
+		ClientCertAccounts:      snapshot.Config.ClientCertAccounts,
+		GuestNickOnCollision:    snapshot.Config.GuestNickOnCollision,
+		PrivacyFilter:           snapshot.Config.PrivacyFilter,
+		WhowasHistory:           snapshot.Config.WhowasHistory,
+		ChannelHistory:          snapshot.Config.ChannelHistory,
+		Plugins:                 unflattenPluginConfig(snapshot.Config.Plugins),
+		MaxTargets:              snapshot.Config.MaxTargets,
+		ReplyPageSize:           snapshot.Config.ReplyPageSize,
+		MaxChannelsPerUser:      snapshot.Config.MaxChannelsPerUser,
+		ResolveHostnames:        snapshot.Config.ResolveHostnames,
+		ChannelTransforms:       snapshot.Config.ChannelTransforms,
+		NumericTexts:            snapshot.Config.NumericTexts,
+		CloakKey:                cloakKey,
+		STSDuration:             config.Duration(stsDuration),
+		STSPreload:              snapshot.Config.StsPreload,
+		Admin: config.Admin{
+			Location:     snapshot.Config.AdminLocation,
+			Organization: snapshot.Config.AdminOrganization,
+			Email:        snapshot.Config.AdminEmail,
+		},
 	}
 	if i.Config.Banned == nil {
 		i.Config.Banned = make(map[string]string)
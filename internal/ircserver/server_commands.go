@@ -1,11 +1,49 @@
 package ircserver
 
 import (
-	"sort"
+	"fmt"
+	"strings"
+	"time"
 
 	"gopkg.in/sorcix/irc.v2"
 )
 
+// ExpireSessionsInterval is how often the raft leader expires sessions.
+const ExpireSessionsInterval = 10 * time.Second
+
+// RetentionWindow returns for how long messages are kept before compaction
+// may fold them into the IRC server state, given the configured
+// SessionExpiration: messages are kept for as long as they could possibly be
+// useful to continue a hanging session (think a user who suspends their
+// notebook, walks around for 9m, opens the notebook and wants to resume the
+// same session in RobustIRC).
+func RetentionWindow(sessionExpiration time.Duration) time.Duration {
+	if sessionExpiration == 0 {
+		// in case the config does not set SessionExpiration at all
+		sessionExpiration = 10 * time.Minute
+	}
+	return sessionExpiration + ExpireSessionsInterval
+}
+
+// sendRetention tells services about the compaction horizon as of |now| and
+// the retention window, so that history-aware modules (e.g. last-seen
+// tracking) know how far back replayed state can be relied upon:
+//
+//	ENCAP * RETENTION <window seconds> <horizon unix timestamp>
+func (i *IRCServer) sendRetention(reply *Replyctx, sessionExpiration time.Duration, now time.Time) {
+	window := RetentionWindow(sessionExpiration)
+	i.sendServices(reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: "ENCAP",
+		Params: []string{
+			"*",
+			"RETENTION",
+			fmt.Sprintf("%d", int64(window/time.Second)),
+			fmt.Sprintf("%d", now.Add(-window).Unix()),
+		},
+	})
+}
+
 func init() {
 	// When developing the anope RobustIRC module, I used the following command
 	// to make sure all commands which anope sends are implemented:
@@ -42,67 +80,36 @@ func (i *IRCServer) cmdServer(s *Session, reply *Replyctx, msg *irc.Message) {
 		})
 		return
 	}
+	if s.Server {
+		i.sendUser(s, reply, &irc.Message{
+			Command: irc.ERROR,
+			Params:  []string{"Already linked"},
+		})
+		return
+	}
+	if strings.EqualFold(msg.Params[0], i.ServerPrefix.Name) {
+		i.sendUser(s, reply, &irc.Message{
+			Command: irc.ERROR,
+			Params:  []string{"Server " + msg.Params[0] + " already exists"},
+		})
+		return
+	}
+	// Services which reconnect (e.g. after a restart or a network problem)
+	// replace their previous link, which might not have expired yet. Their
+	// clients are removed, as services re-introduce them after the burst.
+	if old := i.linkedServer(msg.Params[0]); old != nil {
+		i.deleteServerLocked(old, reply, "Services reconnected")
+	}
 	s.Server = true
 	s.ircPrefix = irc.Prefix{
 		Name: msg.Params[0],
 	}
-	i.serverSessions = append(i.serverSessions, s.Id.Id)
-	i.sendServices(reply, &irc.Message{
-		Command: "SERVER",
-		Params: []string{
-			i.ServerPrefix.Name,
-			"1",  // hopcount
-			"23", // token, must be different from the services token
-		},
-	})
-	nicks := make([]string, 0, len(i.nicks))
-	for nick := range i.nicks {
-		nicks = append(nicks, string(nick))
-	}
-	sort.Strings(nicks)
-	for _, nick := range nicks {
-		session := i.nicks[lcNick(nick)]
-		// Skip sessions that are not yet logged in, sessions that represent a
-		// server connection and subsessions of a server connection.
-		if !session.loggedIn || session.Server || session.Id.Reply != 0 {
-			continue
-		}
-		modestr := "+"
-		for mode := 'A'; mode < 'z'; mode++ {
-			if session.modes[mode] {
-				modestr += string(mode)
-			}
-		}
-		i.sendServices(reply, &irc.Message{
-			Command: irc.NICK,
-			Params: []string{
-				session.Nick,
-				"1", // hopcount (ignored by anope)
-				"1", // timestamp
-				session.Username,
-				session.ircPrefix.Host,
-				i.ServerPrefix.Name,
-				session.svid,
-				modestr,
-				session.Realname,
-			},
-		})
-		channelnames := make([]string, 0, len(session.Channels))
-		for channelname := range session.Channels {
-			channelnames = append(channelnames, string(channelname))
-		}
-		sort.Strings(channelnames)
-		for _, channelname := range channelnames {
-			var prefix string
-
-			if i.channels[lcChan(channelname)].nicks[NickToLower(session.Nick)][chanop] {
-				prefix = prefix + string('@')
-			}
-			i.sendServices(reply, &irc.Message{
-				Prefix:  i.ServerPrefix,
-				Command: "SJOIN",
-				Params:  []string{"1", i.channels[lcChan(channelname)].name, prefix + session.Nick},
-			})
-		}
+	// Services which introduced themselves with a TS6 PASS command (see
+	// cmdPass) have a server id.
+	if s.uid != "" {
+		s.link = "ts6"
 	}
+	i.addServerSession(s)
+	linkProtocols[s.link].burst(i, s, reply)
+	i.sendRetention(reply, time.Duration(i.Config.SessionExpiration), s.LastActivity)
 }
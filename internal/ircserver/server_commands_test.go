@@ -1,6 +1,7 @@
 package ircserver
 
 import (
+	"reflect"
 	"testing"
 	"time"
 
@@ -54,9 +55,25 @@ func TestServerHandshake(t *testing.T) {
 			irc.ParseMessage(":robustirc.net SJOIN 1 #test :@mero"),
 			irc.ParseMessage("NICK sECuRE 1 1 blah robust/0x13b5aa0a2bcfb8ad robustirc.net 0 +o :Michael Stapelberg"),
 			irc.ParseMessage("NICK xeen 1 1 baz robust/0x13b5aa0a2bcfb8af robustirc.net 0 + :Iks Enn"),
+			irc.ParseMessage(":robustirc.net MODE #test +nt"),
+			irc.ParseMessage(":robustirc.net ENCAP * RETENTION 610 1425052145"),
 		})
 }
 
+func TestServerRetention(t *testing.T) {
+	i, _ := stdIRCServerWithServices()
+
+	cfg := i.Config
+	mustMatchIrcmsgs(t,
+		i.ConfigApplied(&robust.Message{Id: robust.Id{Id: 1425052755000000000}, Type: robust.Config, Revision: 2}, cfg),
+		[]*irc.Message{})
+
+	cfg.SessionExpiration = config.Duration(20 * time.Minute)
+	mustMatchMsg(t,
+		i.ConfigApplied(&robust.Message{Id: robust.Id{Id: 1425052755000000000}, Type: robust.Config, Revision: 3}, cfg),
+		":robustirc.net ENCAP * RETENTION 1210 1425051545")
+}
+
 func TestServerSjoin(t *testing.T) {
 	i, ids := stdIRCServerWithServices()
 
@@ -123,3 +140,93 @@ func TestServerKickKill(t *testing.T) {
 		i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":ChanServ KICK #test xeen :bye")),
 		":ChanServ!services@services KICK #test xeen :bye")
 }
+
+func TestServerMultipleLinks(t *testing.T) {
+	i, ids := stdIRCServerWithServices()
+	i.Config.IRC.Services = append(i.Config.IRC.Services, config.Service{
+		Password: "statspass",
+	})
+
+	i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("NICK ChanServ 1 1422134861 services robustirc.net services.robustirc.net 0 :ChanServ"))
+
+	ids["stats"] = robust.Id{Id: 0x13c6cdee3e749fb0}
+	i.CreateSession(ids["stats"], "auth-server", time.Unix(0, int64(ids["stats"].Id)))
+	i.ProcessMessage(&robust.Message{Session: ids["stats"]}, irc.ParseMessage("PASS :services=statspass"))
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["stats"]}, irc.ParseMessage("SERVER robustirc.net 1 :Stats")),
+		"ERROR :Server robustirc.net already exists")
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["stats"]}, irc.ParseMessage("SERVER stats.robustirc.net 1 :Stats")),
+		[]*irc.Message{
+			irc.ParseMessage("SERVER robustirc.net 1 23"),
+			irc.ParseMessage("NICK ChanServ 1 1 services robust/0x13c6cdee3e749faf services.robustirc.net 0 + :ChanServ"),
+			irc.ParseMessage("NICK mero 1 1 foo robust/0x13b5aa0a2bcfb8ae robustirc.net 0 + :Axel Wagner"),
+			irc.ParseMessage("NICK sECuRE 1 1 blah robust/0x13b5aa0a2bcfb8ad robustirc.net 0 + :Michael Stapelberg"),
+			irc.ParseMessage("NICK xeen 1 1 baz robust/0x13b5aa0a2bcfb8af robustirc.net 0 + :Iks Enn"),
+			irc.ParseMessage(":robustirc.net ENCAP * RETENTION 610 1425052145"),
+		})
+
+	// Clients of one link are introduced to the other links.
+	reply := i.ProcessMessage(&robust.Message{Session: ids["stats"]}, irc.ParseMessage("NICK StatServ 1 1422134861 stats robustirc.net stats.robustirc.net 0 :StatServ"))
+	mustMatchMsg(t, reply, "NICK StatServ 1 1 stats robust/0x13c6cdee3e749fb0 stats.robustirc.net 0 + :StatServ")
+	if got, want := reply.Messages[0].InterestingFor, map[uint64]bool{ids["services"].Id: true}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("NICK introduction: got InterestingFor %v, want %v", got, want)
+	}
+
+	// Servers cannot act on behalf of other servers or their clients.
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["stats"]}, irc.ParseMessage(":ChanServ PRIVMSG secure :ohai")),
+		":robustirc.net NOTICE stats.robustirc.net :Ignoring PRIVMSG: ChanServ belongs to services.robustirc.net")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["stats"]}, irc.ParseMessage(":StatServ PRIVMSG secure :ohai")),
+		":StatServ!services@services PRIVMSG secure :ohai")
+
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["stats"]}, irc.ParseMessage("QUIT :bye")),
+		[]*irc.Message{
+			irc.ParseMessage(":StatServ!stats@robust/0x13c6cdee3e749fb0 QUIT :bye"),
+		})
+
+	if got, want := i.serverSessions, []uint64{ids["services"].Id}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("serverSessions after QUIT: got %v, want %v", got, want)
+	}
+}
+
+func TestServerReconnect(t *testing.T) {
+	i, ids := stdIRCServerWithServices()
+
+	i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage("NICK ChanServ 1 1422134861 services robustirc.net services.robustirc.net 0 :ChanServ"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
+	i.ProcessMessage(&robust.Message{Session: ids["services"]}, irc.ParseMessage(":ChanServ SJOIN 1 #test :@ChanServ"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +kv key secure"))
+	i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("MODE #test +b *!*@example.net"))
+
+	// Services restart while their previous session is still around.
+	ids["services2"] = robust.Id{Id: 0x13c6cdee3e749fb0}
+	i.CreateSession(ids["services2"], "auth-server", time.Unix(0, int64(ids["services2"].Id)))
+	i.ProcessMessage(&robust.Message{Session: ids["services2"]}, irc.ParseMessage("PASS :services=mypass"))
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services2"]}, irc.ParseMessage("SERVER services.robustirc.net 1 :Services for IRC Networks")),
+		[]*irc.Message{
+			irc.ParseMessage(":ChanServ!services@robust/0x13c6cdee3e749faf QUIT :Services reconnected"),
+			irc.ParseMessage("SERVER robustirc.net 1 23"),
+			irc.ParseMessage("NICK mero 1 1 foo robust/0x13b5aa0a2bcfb8ae robustirc.net 0 + :Axel Wagner"),
+			irc.ParseMessage("NICK sECuRE 1 1 blah robust/0x13b5aa0a2bcfb8ad robustirc.net 0 + :Michael Stapelberg"),
+			irc.ParseMessage(":robustirc.net SJOIN 1 #test :@sECuRE"),
+			irc.ParseMessage("NICK xeen 1 1 baz robust/0x13b5aa0a2bcfb8af robustirc.net 0 + :Iks Enn"),
+			irc.ParseMessage(":robustirc.net MODE #test +kntvb key sECuRE *!*@example.net"),
+			irc.ParseMessage(":robustirc.net ENCAP * RETENTION 610 1425052145"),
+		})
+
+	if got, want := i.serverSessions, []uint64{ids["services2"].Id}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("serverSessions after reconnect: got %v, want %v", got, want)
+	}
+
+	// The services client can be re-introduced.
+	mustMatchIrcmsgs(t,
+		i.ProcessMessage(&robust.Message{Session: ids["services2"]}, irc.ParseMessage("NICK ChanServ 1 1422134861 services robustirc.net services.robustirc.net 0 :ChanServ")),
+		[]*irc.Message{})
+}
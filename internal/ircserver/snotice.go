@@ -0,0 +1,103 @@
+package ircserver
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// maxSnoticesPerMinute is the number of CONNECT/DISCONNECT server notices
+// which are sent to IRC operators per minute. Any further notices are only
+// counted and summarized once the next minute begins, so that reconnect storms
+// (e.g. after a bridge restart) do not flood operators.
+const maxSnoticesPerMinute = 20
+
+// snoticeState tracks how many server notices were sent in the current
+// minute. The minute is derived from the message timestamp (i.e. the time at
+// which the message was accepted by raft), so that all servers agree on it.
+type snoticeState struct {
+	// window is the current minute, in minutes since the unix epoch.
+	window int64
+	sent   uint64
+
+	suppressedConnects    uint64
+	suppressedDisconnects uint64
+}
+
+type snoticeKind int
+
+const (
+	snoticeConnect snoticeKind = iota
+	snoticeDisconnect
+)
+
+// sendOperators sends |msg| to all IRC operators except for |except| (which
+// may be nil). In case there are no such operators, |msg| is not sent at all.
+func (i *IRCServer) sendOperators(except *Session, reply *Replyctx, msg *irc.Message) *irc.Message {
+	var operators []uint64
+	for _, s := range i.sessions {
+		if !s.Operator || !s.loggedIn || s.deleted || s == except {
+			continue
+		}
+		operators = append(operators, s.Id.Id)
+	}
+	if len(operators) == 0 {
+		return msg
+	}
+	robustmsg := i.send(reply, msg)
+	for _, id := range operators {
+		robustmsg.InterestingFor[id] = true
+	}
+	return msg
+}
+
+// sendSnotice sends a server notice about |s| connecting or disconnecting to
+// all IRC operators, subject to maxSnoticesPerMinute.
+func (i *IRCServer) sendSnotice(s *Session, reply *Replyctx, kind snoticeKind, reason string) {
+	if s.Server || s.Id.Reply != 0 {
+		// Services and sessions introduced by services are not interesting.
+		return
+	}
+
+	window := reply.timestamp.Unix() / 60
+	if window != i.snotices.window {
+		if connects, disconnects := i.snotices.suppressedConnects, i.snotices.suppressedDisconnects; connects > 0 || disconnects > 0 {
+			i.sendOperators(nil, reply, &irc.Message{
+				Prefix:  i.ServerPrefix,
+				Command: irc.NOTICE,
+				Params: []string{"*", fmt.Sprintf("*** Notice -- Suppressed %d client connecting and %d client exiting notices during %s",
+					connects, disconnects, time.Unix(i.snotices.window*60, 0).UTC().Format("2006-01-02 15:04"))},
+			})
+		}
+		i.snotices = snoticeState{window: window}
+	}
+
+	if i.snotices.sent >= maxSnoticesPerMinute {
+		if kind == snoticeConnect {
+			i.snotices.suppressedConnects++
+		} else {
+			i.snotices.suppressedDisconnects++
+		}
+		return
+	}
+	i.snotices.sent++
+
+	remoteAddr := s.RemoteAddr
+	if remoteAddr == "" {
+		remoteAddr = "unknown"
+	}
+	var text string
+	if kind == snoticeConnect {
+		text = fmt.Sprintf("*** Notice -- Client connecting: %s (%s@%s) [%s] {session 0x%x}",
+			s.Nick, s.Username, s.ircPrefix.Host, remoteAddr, s.Id.Id)
+	} else {
+		text = fmt.Sprintf("*** Notice -- Client exiting: %s (%s@%s) [%s] {session 0x%x} (%s)",
+			s.Nick, s.Username, s.ircPrefix.Host, remoteAddr, s.Id.Id, reason)
+	}
+	i.sendOperators(s, reply, &irc.Message{
+		Prefix:  i.ServerPrefix,
+		Command: irc.NOTICE,
+		Params:  []string{"*", text},
+	})
+}
@@ -0,0 +1,97 @@
+package ircserver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/robust"
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// lastSnotice returns the last server notice in |reply| which is interesting
+// for |operator|, or the empty string.
+func lastSnotice(reply *Replyctx, operator robust.Id) string {
+	var last string
+	for _, msg := range reply.Messages {
+		if !msg.InterestingFor[operator.Id] {
+			continue
+		}
+		if ircmsg := irc.ParseMessage(msg.Data); ircmsg.Command == irc.NOTICE && ircmsg.Params[0] == "*" {
+			last = ircmsg.Trailing()
+		}
+	}
+	return last
+}
+
+// snoticeIdOffset mirrors the default -robustirc_message_offset, so that
+// message ids are unrelated to message timestamps like in production.
+const snoticeIdOffset = 4648398125000000000
+
+func TestSnotices(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.ProcessMessage(&robust.Message{Session: ids["mero"]}, irc.ParseMessage("OPER mero foo"))
+
+	minute := time.Date(2016, 12, 7, 20, 0, 0, 0, time.UTC)
+	index := uint64(100)
+	// msg returns a message sent by |session| which raft accepted at |ts|.
+	msg := func(session robust.Id, ts time.Time) *robust.Message {
+		index++
+		return &robust.Message{
+			Id:       robust.Id{Id: snoticeIdOffset + index},
+			Session:  session,
+			UnixNano: ts.UnixNano(),
+		}
+	}
+	// connect creates a new session at |ts| and logs it in.
+	connect := func(nick string, ts time.Time) (robust.Id, *Replyctx) {
+		m := msg(robust.Id{}, ts)
+		id := m.Id
+		i.CreateSession(id, "auth", ts)
+		i.ProcessMessage(msg(id, ts), irc.ParseMessage("NICK "+nick))
+		user := msg(id, ts)
+		user.RemoteAddr = "10.0.0.1"
+		return id, i.ProcessMessage(user, irc.ParseMessage("USER "+nick+" 0 * :"+nick))
+	}
+
+	id, reply := connect("guest", minute)
+	if got, want := lastSnotice(reply, ids["mero"]), fmt.Sprintf("*** Notice -- Client connecting: guest (guest@robust/0x%x) [10.0.0.1] {session 0x%x}", id.Id, id.Id); got != want {
+		t.Fatalf("unexpected connect notice: got %q, want %q", got, want)
+	}
+	if got := lastSnotice(reply, ids["xeen"]); got != "" {
+		t.Fatalf("non-operator xeen unexpectedly got a notice: %q", got)
+	}
+
+	reply = i.ProcessMessage(msg(id, minute), irc.ParseMessage("QUIT :bye"))
+	if got, want := lastSnotice(reply, ids["mero"]), fmt.Sprintf("*** Notice -- Client exiting: guest (guest@robust/0x%x) [10.0.0.1] {session 0x%x} (bye)", id.Id, id.Id); got != want {
+		t.Fatalf("unexpected exit notice: got %q, want %q", got, want)
+	}
+
+	// Simulate a reconnect storm (the two notices above count, too): only
+	// maxSnoticesPerMinute notices must be sent, the rest must be summarized
+	// in the next minute.
+	for n := 0; n < 2*maxSnoticesPerMinute; n++ {
+		_, reply := connect(fmt.Sprintf("storm%d", n), minute.Add(time.Duration(n+1)*time.Second))
+		got := lastSnotice(reply, ids["mero"])
+		if sent := n + 3; sent <= maxSnoticesPerMinute && got == "" {
+			t.Fatalf("connect notice %d unexpectedly suppressed", sent)
+		} else if sent > maxSnoticesPerMinute && got != "" {
+			t.Fatalf("connect notice %d unexpectedly sent: %q", sent, got)
+		}
+	}
+
+	_, reply = connect("late", minute.Add(1*time.Minute))
+	var notices []string
+	for _, msg := range reply.Messages {
+		if ircmsg := irc.ParseMessage(msg.Data); ircmsg.Command == irc.NOTICE && ircmsg.Params[0] == "*" {
+			notices = append(notices, ircmsg.Trailing())
+		}
+	}
+	if len(notices) != 2 {
+		t.Fatalf("expected a summary and a connect notice, got %q", notices)
+	}
+	if got, want := notices[0], "*** Notice -- Suppressed 22 client connecting and 0 client exiting notices during 2016-12-07 20:00"; got != want {
+		t.Fatalf("unexpected summary notice: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,31 @@
+package ircserver
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/config"
+)
+
+func init() {
+	registerCap("sts", capability{
+		value:         stsPolicy,
+		informational: true,
+	})
+}
+
+// stsPolicy returns the strict transport security policy configured in
+// |cfg|.STSDuration, see https://ircv3.net/specs/extensions/sts
+// RobustIRC is only reachable via HTTPS, so clients (i.e. bridges) are
+// always connected securely and the policy never contains a port.
+func stsPolicy(cfg *config.Network) string {
+	duration := time.Duration(cfg.STSDuration)
+	if duration <= 0 {
+		return ""
+	}
+	policy := "duration=" + strconv.FormatInt(int64(duration/time.Second), 10)
+	if cfg.STSPreload {
+		policy += ",preload"
+	}
+	return policy
+}
@@ -0,0 +1,44 @@
+package ircserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robustirc/robustirc/internal/config"
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestSts(t *testing.T) {
+	i, ids := stdIRCServer()
+
+	i.Config.STSDuration = config.Duration(30 * 24 * time.Hour)
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("CAP LS 302")),
+		":robustirc.net CAP sECuRE LS :away-notify cap-notify invite-notify message-tags sasl=EXTERNAL server-time setname sts=duration=2592000 userhost-in-names")
+
+	// Clients which do not support values cannot make use of the policy.
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("CAP LS")),
+		":robustirc.net CAP sECuRE LS :away-notify cap-notify invite-notify message-tags sasl server-time setname userhost-in-names")
+
+	mustMatchMsg(t,
+		i.ProcessMessage(&robust.Message{Session: ids["secure"]}, irc.ParseMessage("CAP REQ sts")),
+		":robustirc.net CAP sECuRE NAK sts")
+
+	i.Config.STSPreload = true
+	// The policy survives snapshots.
+	state, err := i.Marshal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i = NewIRCServer("robustirc.net", time.Now())
+	if _, err := i.Unmarshal(state); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stsPolicy(&i.Config), "duration=2592000,preload"; got != want {
+		t.Fatalf("stsPolicy() = %q, want %q", got, want)
+	}
+}
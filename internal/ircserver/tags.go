@@ -0,0 +1,200 @@
+package ircserver
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/robustirc/robustirc/internal/robust"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// serverTimeFormat is the timestamp format of the server-time tag, see
+// https://ircv3.net/specs/extensions/server-time
+const serverTimeFormat = "2006-01-02T15:04:05.000Z"
+
+func init() {
+	// server-time is added when delivering messages to clients (see
+	// AddServerTime), as the same output message is shared by all
+	// recipients, regardless of their capabilities.
+	registerCap("server-time", capability{})
+}
+
+// HasCap returns whether session |sessionid| enabled |capability|.
+func (i *IRCServer) HasCap(sessionid robust.Id, capability string) bool {
+	i.sessionsMu.RLock()
+	defer i.sessionsMu.RUnlock()
+	if s, ok := i.sessions[sessionid]; ok {
+		return s.caps[capability]
+	}
+	return false
+}
+
+// addTag returns |data| (an IRC message, possibly with tags) with the tag
+// |key|=|value| added.
+func addTag(data, key, value string) string {
+	tag := key + "=" + value
+	if strings.HasPrefix(data, "@") {
+		return "@" + tag + ";" + data[1:]
+	}
+	return "@" + tag + " " + data
+}
+
+// AddServerTime returns |data| (an IRC message) with a server-time tag for
+// |t|, unless |data| already has one (e.g. replayed history, see
+// CHATHISTORY).
+func AddServerTime(data string, t time.Time) string {
+	if tags, _ := splitTags(data); tags != "" {
+		if _, ok := parseTags(tags)["time"]; ok {
+			return data
+		}
+	}
+	return addTag(data, "time", t.UTC().Format(serverTimeFormat))
+}
+
+// maxReactLength limits the value of the +react client tag, which is meant
+// to carry a single emoji or a short reaction.
+const maxReactLength = 64
+
+// clientTagValidators are the client-only tags (see
+// https://ircv3.net/specs/extensions/message-tags#client-only-tags) which
+// are relayed to other clients. All other client tags are dropped.
+var clientTagValidators = map[string]func(value string) bool{
+	// https://ircv3.net/specs/client-tags/typing
+	"+typing": func(value string) bool {
+		return value == "active" || value == "paused" || value == "done"
+	},
+	// https://ircv3.net/specs/client-tags/react
+	"+react": func(value string) bool {
+		return value != "" && len(value) <= maxReactLength && utf8.ValidString(value)
+	},
+}
+
+var tagEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\:`,
+	" ", `\s`,
+	"\r", `\r`,
+	"\n", `\n`)
+
+// unescapeTagValue reverses tagEscaper, see
+// https://ircv3.net/specs/extensions/message-tags#escaping-values
+func unescapeTagValue(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var b strings.Builder
+	for idx := 0; idx < len(value); idx++ {
+		if value[idx] != '\\' {
+			b.WriteByte(value[idx])
+			continue
+		}
+		idx++
+		if idx == len(value) {
+			break // a trailing backslash is dropped
+		}
+		switch value[idx] {
+		case ':':
+			b.WriteByte(';')
+		case 's':
+			b.WriteByte(' ')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(value[idx])
+		}
+	}
+	return b.String()
+}
+
+// splitTags splits |data| (an IRC message) into its tags (without the
+// leading @) and the remaining message.
+func splitTags(data string) (tags, rest string) {
+	if !strings.HasPrefix(data, "@") {
+		return "", data
+	}
+	idx := strings.IndexByte(data, ' ')
+	if idx == -1 {
+		return data[1:], ""
+	}
+	return data[1:idx], strings.TrimLeft(data[idx:], " ")
+}
+
+// parseTags parses |tags| (as returned by splitTags) into a map from key to
+// unescaped value. Later occurrences of a key override earlier ones.
+func parseTags(tags string) map[string]string {
+	result := make(map[string]string)
+	for _, tag := range strings.Split(tags, ";") {
+		if tag == "" {
+			continue
+		}
+		key, value := tag, ""
+		if idx := strings.IndexByte(tag, '='); idx > -1 {
+			key, value = tag[:idx], unescapeTagValue(tag[idx+1:])
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// clientTags returns the valid client-only tags of |data| (an IRC message
+// sent by a client), serialized in a canonical form (sorted by key) so that
+// all servers produce the same output.
+func clientTags(data string) string {
+	tags, _ := splitTags(data)
+	if tags == "" {
+		return ""
+	}
+	parsed := parseTags(tags)
+	keys := make([]string, 0, len(parsed))
+	for key, value := range parsed {
+		if valid, ok := clientTagValidators[key]; ok && valid(value) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	serialized := make([]string, len(keys))
+	for idx, key := range keys {
+		serialized[idx] = key + "=" + tagEscaper.Replace(parsed[key])
+	}
+	return strings.Join(serialized, ";")
+}
+
+// ParseMessage is like irc.ParseMessage, but skips the tags of |data|, which
+// irc.ParseMessage does not support.
+func ParseMessage(data string) *irc.Message {
+	_, rest := splitTags(data)
+	return irc.ParseMessage(rest)
+}
+
+// StripTags returns |data| (an IRC message) without its tags, for delivering
+// it to clients which did not enable the message-tags capability. A
+// server-time tag is retained, as it is only present in messages for clients
+// which enabled server-time.
+func StripTags(data string) string {
+	tags, rest := splitTags(data)
+	if tags == "" {
+		return rest
+	}
+	if value, ok := parseTags(tags)["time"]; ok {
+		return addTag(rest, "time", value)
+	}
+	return rest
+}
+
+// tagReply adds the client tags of the message which is being processed to
+// the robust.Message which was created for |msg|.
+func (i *IRCServer) tagReply(reply *Replyctx, msg *irc.Message) {
+	if reply.clientTags == "" {
+		return
+	}
+	robustmsg := i.send(reply, msg)
+	if strings.HasPrefix(robustmsg.Data, "@") {
+		return // already tagged, i.e. send() returned the same message again
+	}
+	robustmsg.Data = "@" + reply.clientTags + " " + robustmsg.Data
+}
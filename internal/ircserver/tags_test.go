@@ -0,0 +1,79 @@
+package ircserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddServerTime(t *testing.T) {
+	ts := time.Unix(0, 1420228218166687917)
+	for _, tt := range []struct {
+		data string
+		want string
+	}{
+		{
+			data: ":robustirc.net PONG robustirc.net",
+			want: "@time=2015-01-02T19:50:18.166Z :robustirc.net PONG robustirc.net",
+		},
+		{
+			data: "@msgid=1 :sECuRE!blah@robust/0x13b5aa0a2bcfb8ad PRIVMSG #test :hi",
+			want: "@time=2015-01-02T19:50:18.166Z;msgid=1 :sECuRE!blah@robust/0x13b5aa0a2bcfb8ad PRIVMSG #test :hi",
+		},
+		{
+			// Replayed history retains the original time.
+			data: "@time=2014-12-24T18:00:00.000Z :sECuRE!blah@robust/0x13b5aa0a2bcfb8ad PRIVMSG #test :hi",
+			want: "@time=2014-12-24T18:00:00.000Z :sECuRE!blah@robust/0x13b5aa0a2bcfb8ad PRIVMSG #test :hi",
+		},
+	} {
+		if got := AddServerTime(tt.data, ts); got != tt.want {
+			t.Errorf("AddServerTime(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestClientTags(t *testing.T) {
+	for _, tt := range []struct {
+		data string
+		want string
+	}{
+		{data: "PRIVMSG #test :hi", want: ""},
+		{data: "@+typing=active TAGMSG #test", want: "+typing=active"},
+		{data: "@+typing=paused;+typing=done TAGMSG #test", want: "+typing=done"},
+		{data: "@+typing TAGMSG #test", want: ""},
+		{data: "@+react=👍;+typing=active TAGMSG #test", want: "+react=👍;+typing=active"},
+		{data: `@+react=a\sb\:c\\ TAGMSG #test`, want: `+react=a\sb\:c\\`},
+		{data: `@+react=\ TAGMSG #test`, want: ""},
+		{data: "@msgid=1;+example.com/foo=bar TAGMSG #test", want: ""},
+	} {
+		if got := clientTags(tt.data); got != tt.want {
+			t.Errorf("clientTags(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestStripTags(t *testing.T) {
+	for _, tt := range []struct {
+		data string
+		want string
+	}{
+		{
+			data: ":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad PRIVMSG #test :hi",
+			want: ":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad PRIVMSG #test :hi",
+		},
+		{
+			data: "@+typing=active :sECuRE!blah@robust/0x13b5aa0a2bcfb8ad PRIVMSG #test :hi",
+			want: ":sECuRE!blah@robust/0x13b5aa0a2bcfb8ad PRIVMSG #test :hi",
+		},
+		{
+			data: "@+typing=active;time=2014-12-24T18:00:00.000Z :sECuRE!blah@robust/0x13b5aa0a2bcfb8ad PRIVMSG #test :hi",
+			want: "@time=2014-12-24T18:00:00.000Z :sECuRE!blah@robust/0x13b5aa0a2bcfb8ad PRIVMSG #test :hi",
+		},
+	} {
+		if got := StripTags(tt.data); got != tt.want {
+			t.Errorf("StripTags(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+		if got := ParseMessage(tt.data); got == nil || got.Command != "PRIVMSG" {
+			t.Errorf("ParseMessage(%q) = %v, want a PRIVMSG", tt.data, got)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"math/big"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/user"
@@ -81,6 +82,12 @@ type localnet struct {
 	// ROBUSTIRC_TESTING_ENABLE_PANIC_COMMAND environment variable. If set to
 	// "1", the PANIC command is enabled, otherwise disabled.
 	EnablePanicCommand string
+
+	// EnablePartitionHooks is passed to the RobustIRC server processes in
+	// the ROBUSTIRC_TESTING_ENABLE_PARTITION_HOOKS environment variable. If
+	// set to "1", raft traffic between nodes can be dropped or delayed, see
+	// Partition and Heal.
+	EnablePartitionHooks string
 }
 
 // RecordResource appends a line to a file in -localnet_dir so that we can
@@ -117,6 +124,75 @@ func (l *localnet) leader(port int) (string, error) {
 	return string(body), nil
 }
 
+// SetPartitionRule configures the node listening on |port| to drop and/or
+// delay its raft traffic to |peers|. Dropping false with a zero delay removes
+// the rule. Requires EnablePartitionHooks.
+func (l *localnet) SetPartitionRule(port int, peers []string, drop bool, delay time.Duration) error {
+	values := url.Values{
+		"peer":  peers,
+		"drop":  []string{strconv.FormatBool(drop)},
+		"delay": []string{delay.String()},
+	}
+	return l.postPartition(port, values)
+}
+
+func (l *localnet) postPartition(port int, values url.Values) error {
+	u := fmt.Sprintf("https://robustirc:%s@localhost:%d/partition", l.NetworkPassword, port)
+	req, err := http.NewRequest("POST", u, strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := l.Httpclient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%q: got HTTP %v, expected 200: %s", u, resp.Status, body)
+	}
+	return nil
+}
+
+// Partition cuts the raft traffic between the nodes listening on |ports| and
+// all other nodes, in both directions. Requires EnablePartitionHooks.
+func (l *localnet) Partition(ports ...int) error {
+	inside := make(map[int]bool)
+	for _, port := range ports {
+		inside[port] = true
+	}
+	peers := func(in bool) []string {
+		var result []string
+		for _, port := range l.Ports {
+			if inside[port] == in {
+				result = append(result, fmt.Sprintf("localhost:%d", port))
+			}
+		}
+		return result
+	}
+	for _, port := range l.Ports {
+		other := peers(!inside[port])
+		if len(other) == 0 {
+			continue
+		}
+		if err := l.SetPartitionRule(port, other, true, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Heal removes all partition rules from all nodes.
+func (l *localnet) Heal() error {
+	for _, port := range l.Ports {
+		if err := l.postPartition(port, url.Values{"reset": []string{"1"}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (l *localnet) Running() bool {
 	_, err := os.Stat(filepath.Join(l.dir, "pids"))
 	return !os.IsNotExist(err)
@@ -179,9 +255,10 @@ func (l *localnet) StartIRCServer(singlenode bool, args ...string) (*exec.Cmd, s
 	}
 
 	fmt.Fprintf(f, "#!/bin/sh\n")
-	fmt.Fprintf(f, "PATH=%q ROBUSTIRC_TESTING_ENABLE_PANIC_COMMAND=%s ROBUSTIRC_NETWORK_PASSWORD=%q GOMAXPROCS=%d exec robustirc %s >>%q 2>>%q\n",
+	fmt.Fprintf(f, "PATH=%q ROBUSTIRC_TESTING_ENABLE_PANIC_COMMAND=%s ROBUSTIRC_TESTING_ENABLE_PARTITION_HOOKS=%s ROBUSTIRC_NETWORK_PASSWORD=%q GOMAXPROCS=%d exec robustirc %s >>%q 2>>%q\n",
 		os.Getenv("PATH"),
 		l.EnablePanicCommand,
+		l.EnablePartitionHooks,
 		l.NetworkPassword,
 		runtime.NumCPU(),
 		strings.Join(quotedargs, " "),
@@ -199,7 +276,8 @@ func (l *localnet) StartIRCServer(singlenode bool, args ...string) (*exec.Cmd, s
 	cmd.Env = append(os.Environ(),
 		fmt.Sprintf("ROBUSTIRC_NETWORK_PASSWORD=%s", l.NetworkPassword),
 		fmt.Sprintf("GOMAXPROCS=%d", runtime.NumCPU()),
-		fmt.Sprintf("ROBUSTIRC_TESTING_ENABLE_PANIC_COMMAND=%s", l.EnablePanicCommand))
+		fmt.Sprintf("ROBUSTIRC_TESTING_ENABLE_PANIC_COMMAND=%s", l.EnablePanicCommand),
+		fmt.Sprintf("ROBUSTIRC_TESTING_ENABLE_PARTITION_HOOKS=%s", l.EnablePartitionHooks))
 	stdout, err := os.Create(filepath.Join(tempdir, "stdout.txt"))
 	if err != nil {
 		log.Panic(err)
@@ -31,6 +31,15 @@ type Message struct {
 	Id             robust.Id
 	Data           string
 	InterestingFor map[uint64]bool
+
+	// Target and Seq, see robust.Message.
+	Target string
+	Seq    uint64
+
+	// UnixNano is the timestamp of the input message which caused this
+	// message, see robust.Message.Timestamp. Delivered to clients which
+	// enabled the server-time capability.
+	UnixNano int64
 }
 
 type messageBatch struct {
@@ -223,8 +232,8 @@ func (os *OutputStream) Delete(inputID robust.Id) error {
 }
 
 // GetNext returns the next IRC output message after lastseen, even if lastseen
-// was deleted in the meanwhile. In case there is no next message yet,
-// GetNext blocks until it appears.
+// was deleted in the meanwhile or was not yet added. In case there is no next
+// message yet, GetNext blocks until it appears.
 // GetNext(types.RobustId{Id: 0}) returns the first message.
 func (os *OutputStream) GetNext(ctx context.Context, lastseen robust.Id) []Message {
 	// GetNext handles 4 different cases:
@@ -286,6 +295,13 @@ func (os *OutputStream) GetNext(ctx context.Context, lastseen robust.Id) []Messa
 	for {
 		current, _ = os.getUnlocked(uint64(current.Messages[0].Id.Id))
 		next, ok := os.getUnlocked(current.NextID)
+		if ok && next.Messages[0].Id.Id <= lastseen.Id {
+			// This node is behind lastseen, e.g. because the client
+			// switched over from a node which is further ahead. Skip
+			// the messages which the client has already received.
+			current = next
+			continue
+		}
 		if ok {
 			os.messagesMu.Unlock()
 			return next.Messages
@@ -300,6 +316,33 @@ func (os *OutputStream) GetNext(ctx context.Context, lastseen robust.Id) []Messa
 	}
 }
 
+// Pending returns the number of IRC output messages for |session| which are
+// more recent than lastseen, but at most limit.
+func (os *OutputStream) Pending(session, lastseen robust.Id, limit int) int {
+	os.messagesMu.RLock()
+	defer os.messagesMu.RUnlock()
+
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], uint64(lastseen.Id)+1)
+	i := os.db.NewIterator(&util.Range{
+		Start: key[:],
+		Limit: nil,
+	}, nil)
+	defer i.Release()
+	pending := 0
+	for i.Next() && pending < limit {
+		for _, msg := range unmarshalMessageBatch(i.Value()).Messages {
+			if msg.InterestingFor[session.Id] {
+				pending++
+			}
+		}
+	}
+	if pending > limit {
+		return limit
+	}
+	return pending
+}
+
 // InterruptGetNext interrupts any running GetNext() calls so that they return
 // if |cancelled| is specified and true in the GetNext() call.
 func (os *OutputStream) InterruptGetNext() {
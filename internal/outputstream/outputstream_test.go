@@ -2,6 +2,7 @@ package outputstream
 
 import (
 	"context"
+	"reflect"
 	"runtime"
 	"testing"
 	"time"
@@ -83,6 +84,39 @@ func TestCatchUp(t *testing.T) {
 	}
 }
 
+func TestPending(t *testing.T) {
+	os, err := NewOutputStream("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Add([]Message{
+		{Id: robust.Id{Id: 1, Reply: 1}, InterestingFor: map[uint64]bool{23: true}},
+		{Id: robust.Id{Id: 1, Reply: 2}, InterestingFor: map[uint64]bool{23: true, 42: true}},
+	})
+	os.Add([]Message{{Id: robust.Id{Id: 2, Reply: 1}, InterestingFor: map[uint64]bool{42: true}}})
+	os.Add([]Message{{Id: robust.Id{Id: 3, Reply: 1}, InterestingFor: map[uint64]bool{23: true}}})
+
+	for _, tt := range []struct {
+		session  uint64
+		lastseen uint64
+		limit    int
+		want     int
+	}{
+		{23, 0, 100, 3},
+		{42, 0, 100, 2},
+		{5, 0, 100, 0},
+		{23, 1, 100, 1},
+		{42, 2, 100, 0},
+		{23, 0, 2, 2},
+	} {
+		got := os.Pending(robust.Id{Id: tt.session}, robust.Id{Id: tt.lastseen}, tt.limit)
+		if got != tt.want {
+			t.Errorf("Pending(%d, %d, %d) = %d, want %d", tt.session, tt.lastseen, tt.limit, got, tt.want)
+		}
+	}
+}
+
 func TestDeleteMiddle(t *testing.T) {
 	os, err := NewOutputStream("")
 	if err != nil {
@@ -201,3 +235,76 @@ func TestInterrupt(t *testing.T) {
 	default:
 	}
 }
+
+func TestSerializationTargetSeq(t *testing.T) {
+	mb := messageBatch{
+		Messages: []Message{
+			{Id: robust.Id{Id: 1, Reply: 1}, Data: "foo", InterestingFor: map[uint64]bool{1: true}, Target: "#test", Seq: 42, UnixNano: 1420228218166687917},
+			{Id: robust.Id{Id: 1, Reply: 2}, Data: "bar", InterestingFor: map[uint64]bool{}},
+		},
+		NextID: 2,
+	}
+	got := unmarshalMessageBatch(mb.marshal())
+	if !reflect.DeepEqual(*got, mb) {
+		t.Fatalf("unmarshalMessageBatch(marshal()): got %+v, want %+v", *got, mb)
+	}
+}
+
+// TestOrderingAcrossNodes simulates a client whose GetMessages request
+// alternates between nodes which apply the same log at different speeds. The
+// per-target sequence numbers the client sees must never go backwards.
+func TestOrderingAcrossNodes(t *testing.T) {
+	const numMessages = 500
+	targets := []string{"#a", "#b", "#c"}
+
+	var nodes []*OutputStream
+	for n := 0; n < 3; n++ {
+		os, err := NewOutputStream("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Close()
+		nodes = append(nodes, os)
+	}
+
+	for _, os := range nodes {
+		go func(os *OutputStream) {
+			seqs := make(map[string]uint64)
+			for id := uint64(1); id <= numMessages; id++ {
+				target := targets[id%uint64(len(targets))]
+				seqs[target]++
+				os.Add([]Message{
+					{Id: robust.Id{Id: id, Reply: 1}, Target: target, Seq: seqs[target]},
+					{Id: robust.Id{Id: id, Reply: 2}},
+				})
+				runtime.Gosched()
+			}
+		}(os)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var lastseen robust.Id
+	lastSeq := make(map[string]uint64)
+	for received := 0; lastseen.Id < numMessages; received++ {
+		// Switch nodes after every message, like a client would after
+		// its GetMessages request failed.
+		msgs := nodes[received%len(nodes)].GetNext(ctx, lastseen)
+		if ctx.Err() != nil {
+			t.Fatalf("Timeout waiting for message after %v", lastseen)
+		}
+		for _, msg := range msgs {
+			if msg.Id.Id < lastseen.Id {
+				t.Fatalf("message %v received after %v", msg.Id, lastseen)
+			}
+			if msg.Target == "" {
+				continue
+			}
+			if msg.Seq <= lastSeq[msg.Target] {
+				t.Fatalf("message %v: Seq %d for %s, but already received %d", msg.Id, msg.Seq, msg.Target, lastSeq[msg.Target])
+			}
+			lastSeq[msg.Target] = msg.Seq
+		}
+		lastseen = msgs[0].Id
+	}
+}
@@ -17,7 +17,11 @@ func (m *messageBatch) marshal() []byte {
 			unsafe.Sizeof(uint64(0)) /* len(Data) */ +
 			unsafe.Sizeof(byte(0))*uintptr(len(msg.Data)) /* Data */ +
 			unsafe.Sizeof(uint64(0)) /* len(InterestingFor) */ +
-			unsafe.Sizeof(uint64(0))*uintptr(len(msg.InterestingFor)) /* InterestingFor */
+			unsafe.Sizeof(uint64(0))*uintptr(len(msg.InterestingFor)) /* InterestingFor */ +
+			unsafe.Sizeof(uint64(0)) /* len(Target) */ +
+			unsafe.Sizeof(byte(0))*uintptr(len(msg.Target)) /* Target */ +
+			unsafe.Sizeof(uint64(0)) /* Seq */ +
+			unsafe.Sizeof(int64(0)) /* UnixNano */
 	}
 
 	buffer := make([]byte, bufLen)
@@ -41,6 +45,14 @@ func (m *messageBatch) marshal() []byte {
 			binary.LittleEndian.PutUint64(buffer[n:], uint64(session))
 			n += 8
 		}
+		binary.LittleEndian.PutUint64(buffer[n:], uint64(len(msg.Target)))
+		n += 8
+		copy(buffer[n:], msg.Target)
+		n += len(msg.Target)
+		binary.LittleEndian.PutUint64(buffer[n:], msg.Seq)
+		n += 8
+		binary.LittleEndian.PutUint64(buffer[n:], uint64(msg.UnixNano))
+		n += 8
 	}
 	return buffer
 }
@@ -71,6 +83,14 @@ func unmarshalMessageBatch(buffer []byte) *messageBatch {
 			msg.InterestingFor[uint64(binary.LittleEndian.Uint64(buffer[n:]))] = true
 			n += 8
 		}
+		lenData = int(binary.LittleEndian.Uint64(buffer[n:]))
+		n += 8
+		msg.Target = string(buffer[n : n+lenData])
+		n += lenData
+		msg.Seq = binary.LittleEndian.Uint64(buffer[n:])
+		n += 8
+		msg.UnixNano = int64(binary.LittleEndian.Uint64(buffer[n:]))
+		n += 8
 	}
 	return &result
 }
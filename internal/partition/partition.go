@@ -0,0 +1,152 @@
+// Package partition simulates network partitions between RobustIRC nodes by
+// dropping or delaying the raft traffic which a node sends to selected peers.
+//
+// This is only meant for testing: robustirc enables it when the
+// ROBUSTIRC_TESTING_ENABLE_PARTITION_HOOKS environment variable is set to
+// "1", and the rules are then configured via the /partition handler.
+package partition
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Doer provides the Do() method, as found in net/http.Client and expected by
+// rafthttp.NewHTTPTransport.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Rule describes what happens to requests to a peer.
+type Rule struct {
+	// Drop makes requests fail without ever reaching the peer.
+	Drop bool `json:",omitempty"`
+
+	// Delay is added before each request is sent to the peer.
+	Delay time.Duration `json:",omitempty"`
+}
+
+// Table holds the rules by peer address (host:port).
+type Table struct {
+	mu    sync.RWMutex
+	rules map[string]Rule
+}
+
+func NewTable() *Table {
+	return &Table{rules: make(map[string]Rule)}
+}
+
+// Set configures |rule| for |peer|. A zero rule removes any previous rule.
+func (t *Table) Set(peer string, rule Rule) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if rule == (Rule{}) {
+		delete(t.rules, peer)
+		return
+	}
+	t.rules[peer] = rule
+}
+
+// Rule returns the rule for |peer|.
+func (t *Table) Rule(peer string) Rule {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.rules[peer]
+}
+
+// Rules returns a copy of all configured rules.
+func (t *Table) Rules() map[string]Rule {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	result := make(map[string]Rule, len(t.rules))
+	for peer, rule := range t.rules {
+		result[peer] = rule
+	}
+	return result
+}
+
+// Reset removes all rules, i.e. heals all partitions.
+func (t *Table) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rules = make(map[string]Rule)
+}
+
+type doer struct {
+	t    *Table
+	next Doer
+}
+
+func (d *doer) Do(req *http.Request) (*http.Response, error) {
+	rule := d.t.Rule(req.URL.Host)
+	if rule.Drop {
+		return nil, fmt.Errorf("partition: dropped request to %s", req.URL.Host)
+	}
+	if rule.Delay > 0 {
+		select {
+		case <-time.After(rule.Delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	return d.next.Do(req)
+}
+
+// Wrap returns a Doer which applies the rules of |t| before passing requests
+// on to |next|.
+func (t *Table) Wrap(next Doer) Doer {
+	return &doer{t: t, next: next}
+}
+
+// ServeHTTP returns the rules as JSON for GET requests and modifies them for
+// POST requests. POST requests take the form values “peer” (repeatable),
+// “drop” (bool) and “delay” (duration), or “reset=1” to remove all rules.
+func (t *Table) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if err := t.handlePost(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(t.Rules()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (t *Table) handlePost(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	if r.FormValue("reset") == "1" {
+		t.Reset()
+		return nil
+	}
+	peers := r.Form["peer"]
+	if len(peers) == 0 {
+		return fmt.Errorf("no peer specified")
+	}
+	var rule Rule
+	if v := r.FormValue("drop"); v != "" {
+		drop, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid drop: %v", err)
+		}
+		rule.Drop = drop
+	}
+	if v := r.FormValue("delay"); v != "" {
+		delay, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid delay: %v", err)
+		}
+		rule.Delay = delay
+	}
+	for _, peer := range peers {
+		t.Set(peer, rule)
+	}
+	return nil
+}
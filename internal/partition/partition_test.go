@@ -0,0 +1,107 @@
+package partition
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type countingDoer struct {
+	hosts []string
+}
+
+func (c *countingDoer) Do(req *http.Request) (*http.Response, error) {
+	c.hosts = append(c.hosts, req.URL.Host)
+	return &http.Response{StatusCode: http.StatusOK}, nil
+}
+
+func TestWrap(t *testing.T) {
+	table := NewTable()
+	next := &countingDoer{}
+	d := table.Wrap(next)
+
+	do := func(host string) error {
+		req, err := http.NewRequest("POST", "https://"+host+"/raft/AppendEntries", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = d.Do(req)
+		return err
+	}
+
+	table.Set("localhost:13002", Rule{Drop: true})
+	table.Set("localhost:13003", Rule{Delay: 50 * time.Millisecond})
+
+	if err := do("localhost:13001"); err != nil {
+		t.Fatalf("unexpected error for unpartitioned peer: %v", err)
+	}
+	if err := do("localhost:13002"); err == nil {
+		t.Fatalf("request to partitioned peer unexpectedly succeeded")
+	}
+	start := time.Now()
+	if err := do("localhost:13003"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("request to delayed peer took %v, want >= 50ms", elapsed)
+	}
+
+	if got, want := strings.Join(next.hosts, ","), "localhost:13001,localhost:13003"; got != want {
+		t.Fatalf("unexpected requests passed on: got %q, want %q", got, want)
+	}
+
+	table.Set("localhost:13002", Rule{})
+	if err := do("localhost:13002"); err != nil {
+		t.Fatalf("request to healed peer failed: %v", err)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	table := NewTable()
+
+	post := func(values url.Values) int {
+		req := httptest.NewRequest("POST", "/partition", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		table.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if got, want := post(url.Values{"drop": {"1"}}), http.StatusBadRequest; got != want {
+		t.Fatalf("POST without peer: got HTTP %d, want %d", got, want)
+	}
+	if got, want := post(url.Values{"peer": {"a"}, "delay": {"soon"}}), http.StatusBadRequest; got != want {
+		t.Fatalf("POST with invalid delay: got HTTP %d, want %d", got, want)
+	}
+
+	if got, want := post(url.Values{"peer": {"a", "b"}, "drop": {"true"}}), http.StatusOK; got != want {
+		t.Fatalf("POST: got HTTP %d, want %d", got, want)
+	}
+	if got := table.Rules(); len(got) != 2 || !got["a"].Drop || !got["b"].Drop {
+		t.Fatalf("unexpected rules after POST: %+v", got)
+	}
+
+	if got, want := post(url.Values{"peer": {"a"}, "delay": {"1s"}}), http.StatusOK; got != want {
+		t.Fatalf("POST: got HTTP %d, want %d", got, want)
+	}
+	if got, want := table.Rule("a"), (Rule{Delay: 1 * time.Second}); got != want {
+		t.Fatalf("unexpected rule for a: got %+v, want %+v", got, want)
+	}
+
+	if got, want := post(url.Values{"reset": {"1"}}), http.StatusOK; got != want {
+		t.Fatalf("POST reset: got HTTP %d, want %d", got, want)
+	}
+	if got := table.Rules(); len(got) != 0 {
+		t.Fatalf("unexpected rules after reset: %+v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	table.Set("c", Rule{Drop: true})
+	table.ServeHTTP(rec, httptest.NewRequest("GET", "/partition", nil))
+	if got, want := strings.TrimSpace(rec.Body.String()), `{"c":{"Drop":true}}`; got != want {
+		t.Fatalf("GET: got %q, want %q", got, want)
+	}
+}
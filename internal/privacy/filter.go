@@ -3,17 +3,124 @@
 package privacy
 
 import (
+	"strings"
+
 	"github.com/golang/protobuf/proto"
 	"gopkg.in/sorcix/irc.v2"
 
+	"github.com/robustirc/robustirc/internal/ircserver"
 	pb "github.com/robustirc/robustirc/internal/proto"
 	"github.com/robustirc/robustirc/internal/robust"
 )
 
+// Policy specifies which information is removed. It is configured using the
+// PrivacyFilter network configuration option.
+type Policy string
+
+const (
+	// RedactAll removes the text of all PRIVMSG and NOTICE messages, services
+	// commands and passwords. This is the default.
+	RedactAll Policy = "all"
+
+	// RedactPrivate removes the text of messages which are not sent to a
+	// channel (i.e. private queries), services commands and passwords.
+	RedactPrivate Policy = "private"
+
+	// RedactNone does not remove any information.
+	RedactNone Policy = "none"
+)
+
+const filtered = "<privacy filtered>"
+
+// ParsePolicy returns the Policy named |name|, defaulting to RedactAll for the
+// empty string.
+func ParsePolicy(name string) (Policy, bool) {
+	switch p := Policy(name); p {
+	case "":
+		return RedactAll, true
+	case RedactAll, RedactPrivate, RedactNone:
+		return p, true
+	}
+	return RedactAll, false
+}
+
+// redact returns whether the trailing parameter of |message| needs to be
+// removed according to |p|.
+func (p Policy) redact(message *irc.Message) bool {
+	if p == RedactNone {
+		return false
+	}
+	command := strings.ToUpper(message.Command)
+	if command == irc.PASS || command == "AUTHENTICATE" ||
+		strings.HasSuffix(command, "SERV") || ircserver.IsServiceAlias(command) {
+		return true
+	}
+	if command == irc.JOIN || command == irc.OPER {
+		// The channel keys (mode +k) or captcha solutions (mode +x), or the
+		// password of the IRC operator.
+		return len(message.Params) > 1
+	}
+	if command != irc.PRIVMSG && command != irc.NOTICE {
+		return false
+	}
+	if p == RedactPrivate && len(message.Params) > 1 &&
+		strings.HasPrefix(message.Params[0], "#") {
+		return false
+	}
+	return true
+}
+
+// FilterIrcmsg removes information from |message| (in place) according to |p|.
+func (p Policy) FilterIrcmsg(message *irc.Message) *irc.Message {
+	if message == nil {
+		return nil
+	}
+	if !p.redact(message) || len(message.Params) == 0 {
+		return message
+	}
+	if ircserver.IsServiceAlias(message.Command) {
+		// All parameters form the text of the message to the service,
+		// e.g. NS REGISTER <password> <email>.
+		message.Params = []string{filtered}
+		return message
+	}
+	message.Params[len(message.Params)-1] = filtered
+	return message
+}
+
+// FilterMsg returns a copy of |message| with information removed according to
+// |p|. Only IRC messages are filtered, other types are returned unmodified.
+func (p Policy) FilterMsg(message *robust.Message) *robust.Message {
+	result := *message
+	if message.Type != robust.IRCToClient && message.Type != robust.IRCFromClient {
+		return &result
+	}
+	if ircmsg := ircserver.ParseMessage(message.Data); ircmsg != nil && p.redact(ircmsg) {
+		result.Data = string(p.FilterIrcmsg(ircmsg).Bytes())
+	}
+	return &result
+}
+
+// FilterSnapshot removes information from |snapshot| according to |p|.
+func (p Policy) FilterSnapshot(snapshot pb.Snapshot) pb.Snapshot {
+	if p == RedactNone {
+		return snapshot
+	}
+	return FilterSnapshot(snapshot)
+}
+
 func FilterSnapshot(snapshot pb.Snapshot) pb.Snapshot {
 	result := proto.Clone(&snapshot).(*pb.Snapshot)
 	for _, session := range result.Sessions {
-		session.Pass = "<privacy filtered>"
+		session.Pass = filtered
+	}
+	for _, channel := range result.Channels {
+		if channel.Key != "" {
+			channel.Key = filtered
+		}
+	}
+	if result.Config != nil && result.Config.CloakKey != "" {
+		result.Config.CloakKey = filtered
 	}
 	return *result
 }
@@ -26,7 +133,7 @@ func FilterIrcmsg(message *irc.Message) *irc.Message {
 		message.Command == irc.NOTICE ||
 		message.Command == irc.PASS {
 		if len(message.Params) > 0 {
-			message.Params[len(message.Params)-1] = "<privacy filtered>"
+			message.Params[len(message.Params)-1] = filtered
 		}
 	}
 	return message
@@ -37,7 +144,7 @@ func FilterMsg(message *robust.Message) *robust.Message {
 		Id:      message.Id,
 		Session: message.Session,
 		Type:    message.Type,
-		Data:    FilterIrcmsg(irc.ParseMessage(message.Data)).String(),
+		Data:    FilterIrcmsg(ircserver.ParseMessage(message.Data)).String(),
 	}
 }
 
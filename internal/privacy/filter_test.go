@@ -0,0 +1,49 @@
+package privacy
+
+import (
+	"testing"
+
+	"github.com/robustirc/robustirc/internal/robust"
+)
+
+func TestPolicyFilterMsg(t *testing.T) {
+	for _, tt := range []struct {
+		policy Policy
+		input  string
+		want   string
+	}{
+		{RedactAll, "PRIVMSG #chan :hello", "PRIVMSG #chan :<privacy filtered>"},
+		{RedactAll, "PRIVMSG mero :hello", "PRIVMSG mero :<privacy filtered>"},
+		{RedactAll, "JOIN #chan", "JOIN #chan"},
+		{RedactPrivate, "JOIN #chan,#other secret", "JOIN #chan,#other :<privacy filtered>"},
+		{RedactNone, "JOIN #chan secret", "JOIN #chan secret"},
+		{RedactPrivate, "PRIVMSG #chan :hello", "PRIVMSG #chan :hello"},
+		{RedactPrivate, "PRIVMSG mero :hello", "PRIVMSG mero :<privacy filtered>"},
+		{RedactPrivate, "PASS :secret", "PASS :<privacy filtered>"},
+		{RedactPrivate, "NICKSERV IDENTIFY secret", "NICKSERV :<privacy filtered>"},
+		{RedactPrivate, "NS IDENTIFY secret", "NS :<privacy filtered>"},
+		{RedactAll, "cs REGISTER #chan secret", "cs :<privacy filtered>"},
+		{RedactPrivate, "OPER mero secret", "OPER mero :<privacy filtered>"},
+		{RedactAll, "AUTHENTICATE bWVybwBtZXJvAHNlY3JldA==", "AUTHENTICATE :<privacy filtered>"},
+		{RedactNone, "NS IDENTIFY secret", "NS IDENTIFY secret"},
+		{RedactNone, "PRIVMSG mero :hello", "PRIVMSG mero :hello"},
+		{RedactNone, "PASS :secret", "PASS :secret"},
+	} {
+		msg := &robust.Message{Type: robust.IRCFromClient, Data: tt.input}
+		if got := tt.policy.FilterMsg(msg).Data; got != tt.want {
+			t.Errorf("Policy(%q).FilterMsg(%q) = %q, want %q", tt.policy, tt.input, got, tt.want)
+		}
+		if msg.Data != tt.input {
+			t.Errorf("Policy(%q).FilterMsg(%q) modified its input", tt.policy, tt.input)
+		}
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	if got, ok := ParsePolicy(""); !ok || got != RedactAll {
+		t.Errorf(`ParsePolicy("") = %q, %v, want %q, true`, got, ok, RedactAll)
+	}
+	if _, ok := ParsePolicy("some"); ok {
+		t.Errorf(`ParsePolicy("some") unexpectedly succeeded`)
+	}
+}
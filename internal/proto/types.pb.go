@@ -38,38 +38,53 @@ const _ = proto1.ProtoPackageIsVersion1
 type RobustMessage_RobustType int32
 
 const (
-	RobustMessage_CREATE_SESSION   RobustMessage_RobustType = 0
-	RobustMessage_DELETE_SESSION   RobustMessage_RobustType = 1
-	RobustMessage_IRC_FROM_CLIENT  RobustMessage_RobustType = 2
-	RobustMessage_IRC_TO_CLIENT    RobustMessage_RobustType = 3
-	RobustMessage_PING             RobustMessage_RobustType = 4
-	RobustMessage_MESSAGE_OF_DEATH RobustMessage_RobustType = 5
-	RobustMessage_CONFIG           RobustMessage_RobustType = 6
-	RobustMessage_STATE            RobustMessage_RobustType = 7
-	RobustMessage_ANY              RobustMessage_RobustType = 8
+	RobustMessage_CREATE_SESSION    RobustMessage_RobustType = 0
+	RobustMessage_DELETE_SESSION    RobustMessage_RobustType = 1
+	RobustMessage_IRC_FROM_CLIENT   RobustMessage_RobustType = 2
+	RobustMessage_IRC_TO_CLIENT     RobustMessage_RobustType = 3
+	RobustMessage_PING              RobustMessage_RobustType = 4
+	RobustMessage_MESSAGE_OF_DEATH  RobustMessage_RobustType = 5
+	RobustMessage_CONFIG            RobustMessage_RobustType = 6
+	RobustMessage_STATE             RobustMessage_RobustType = 7
+	RobustMessage_ANY               RobustMessage_RobustType = 8
+	RobustMessage_CHANNEL_IMPORT    RobustMessage_RobustType = 9
+	RobustMessage_RESOLVED_HOSTNAME RobustMessage_RobustType = 10
+	RobustMessage_EXPIRE_KLINE      RobustMessage_RobustType = 11
+	RobustMessage_REHASH_FAILED     RobustMessage_RobustType = 12
+	RobustMessage_SNAPSHOT_SUMMARY  RobustMessage_RobustType = 13
 )
 
 var RobustMessage_RobustType_name = map[int32]string{
-	0: "CREATE_SESSION",
-	1: "DELETE_SESSION",
-	2: "IRC_FROM_CLIENT",
-	3: "IRC_TO_CLIENT",
-	4: "PING",
-	5: "MESSAGE_OF_DEATH",
-	6: "CONFIG",
-	7: "STATE",
-	8: "ANY",
+	0:  "CREATE_SESSION",
+	1:  "DELETE_SESSION",
+	2:  "IRC_FROM_CLIENT",
+	3:  "IRC_TO_CLIENT",
+	4:  "PING",
+	5:  "MESSAGE_OF_DEATH",
+	6:  "CONFIG",
+	7:  "STATE",
+	8:  "ANY",
+	9:  "CHANNEL_IMPORT",
+	10: "RESOLVED_HOSTNAME",
+	11: "EXPIRE_KLINE",
+	12: "REHASH_FAILED",
+	13: "SNAPSHOT_SUMMARY",
 }
 var RobustMessage_RobustType_value = map[string]int32{
-	"CREATE_SESSION":   0,
-	"DELETE_SESSION":   1,
-	"IRC_FROM_CLIENT":  2,
-	"IRC_TO_CLIENT":    3,
-	"PING":             4,
-	"MESSAGE_OF_DEATH": 5,
-	"CONFIG":           6,
-	"STATE":            7,
-	"ANY":              8,
+	"CREATE_SESSION":    0,
+	"DELETE_SESSION":    1,
+	"IRC_FROM_CLIENT":   2,
+	"IRC_TO_CLIENT":     3,
+	"PING":              4,
+	"MESSAGE_OF_DEATH":  5,
+	"CONFIG":            6,
+	"STATE":             7,
+	"ANY":               8,
+	"CHANNEL_IMPORT":    9,
+	"RESOLVED_HOSTNAME": 10,
+	"EXPIRE_KLINE":      11,
+	"REHASH_FAILED":     12,
+	"SNAPSHOT_SUMMARY":  13,
 }
 
 func (x RobustMessage_RobustType) String() string {
@@ -131,6 +146,8 @@ type RobustMessage struct {
 	ClientMessageId uint64   `protobuf:"varint,8,opt,name=client_message_id,json=clientMessageId,proto3" json:"client_message_id,omitempty"`
 	Revision        uint64   `protobuf:"varint,9,opt,name=revision,proto3" json:"revision,omitempty"`
 	RemoteAddr      string   `protobuf:"bytes,10,opt,name=remote_addr,json=remoteAddr,proto3" json:"remote_addr,omitempty"`
+	Origin          string   `protobuf:"bytes,11,opt,name=origin,proto3" json:"origin,omitempty"`
+	CertFingerprint string   `protobuf:"bytes,12,opt,name=cert_fingerprint,json=certFingerprint,proto3" json:"cert_fingerprint,omitempty"`
 }
 
 func (m *RobustMessage) Reset()                    { *m = RobustMessage{} }
@@ -288,6 +305,18 @@ func (m *RobustMessage) MarshalTo(data []byte) (int, error) {
 		i = encodeVarintTypes(data, i, uint64(len(m.RemoteAddr)))
 		i += copy(data[i:], m.RemoteAddr)
 	}
+	if len(m.Origin) > 0 {
+		data[i] = 0x5a
+		i++
+		i = encodeVarintTypes(data, i, uint64(len(m.Origin)))
+		i += copy(data[i:], m.Origin)
+	}
+	if len(m.CertFingerprint) > 0 {
+		data[i] = 0x62
+		i++
+		i = encodeVarintSnapshot(data, i, uint64(len(m.CertFingerprint)))
+		i += copy(data[i:], m.CertFingerprint)
+	}
 	return i, nil
 }
 
@@ -416,6 +445,14 @@ func (m *RobustMessage) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovTypes(uint64(l))
 	}
+	l = len(m.Origin)
+	if l > 0 {
+		n += 1 + l + sovTypes(uint64(l))
+	}
+	l = len(m.CertFingerprint)
+	if l > 0 {
+		n += 1 + l + sovSnapshot(uint64(l))
+	}
 	return n
 }
 
@@ -826,6 +863,64 @@ func (m *RobustMessage) Unmarshal(data []byte) error {
 			}
 			m.RemoteAddr = string(data[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 11:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Origin", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTypes
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthTypes
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Origin = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 12:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CertFingerprint", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSnapshot
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthSnapshot
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.CertFingerprint = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipTypes(data[iNdEx:])
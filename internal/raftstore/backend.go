@@ -0,0 +1,96 @@
+package raftstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/raft"
+
+	pb "github.com/robustirc/robustirc/internal/proto"
+)
+
+// LogStore is implemented by all raftlog backends, see OpenLogStore.
+type LogStore interface {
+	raft.LogStore
+	raft.StableStore
+
+	// StoreLogProto stores an already converted log entry, e.g. to replace
+	// an entry with a message of death.
+	StoreLogProto(msg *pb.RaftLog) error
+
+	// Close closes the store. No other methods may be called after this.
+	Close() error
+}
+
+// backend is a raftlog implementation which can be selected by name.
+type backend struct {
+	// path is the file or directory (relative to the raft directory) in
+	// which the backend stores the raftlog, empty if it is not persisted.
+	path string
+
+	// open is nil if the backend was not compiled in (see build tags).
+	open func(path string, errorIfExist, useProtobuf bool, o Options) (LogStore, error)
+}
+
+var backends = map[string]*backend{
+	"leveldb": {
+		path: "raftlog",
+		open: func(path string, errorIfExist, useProtobuf bool, o Options) (LogStore, error) {
+			return NewLevelDBStoreWithOptions(path, errorIfExist, useProtobuf, o)
+		},
+	},
+	"memory": {
+		open: func(path string, errorIfExist, useProtobuf bool, o Options) (LogStore, error) {
+			return NewMemoryStore(useProtobuf)
+		},
+	},
+	"bbolt":  {path: "raftlog.bolt"},
+	"badger": {path: "raftlog.badger"},
+}
+
+// lookupBackend returns the backend called |name| if it was compiled in.
+func lookupBackend(name string) (*backend, error) {
+	b, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown raftlog backend %q", name)
+	}
+	if b.open == nil {
+		return nil, fmt.Errorf("raftlog backend %q was not compiled in, rebuild with -tags %s", name, name)
+	}
+	return b, nil
+}
+
+// OpenBackend opens the store at |path| (ignored by the memory backend)
+// using the backend called |name|, e.g. for tools which convert between
+// backends. Nodes use OpenLogStore instead.
+func OpenBackend(name, path string, errorIfExist, useProtobuf bool, o Options) (LogStore, error) {
+	b, err := lookupBackend(name)
+	if err != nil {
+		return nil, err
+	}
+	return b.open(path, errorIfExist, useProtobuf, o)
+}
+
+// OpenLogStore opens the raftlog in |raftDir| using the backend called
+// |name|. It refuses to open the raftlog while the raftlog of a different
+// backend exists, as raft would otherwise start from an empty log. |o| is
+// only used by the leveldb backend.
+func OpenLogStore(name, raftDir string, errorIfExist, useProtobuf bool, o Options) (LogStore, error) {
+	b, err := lookupBackend(name)
+	if err != nil {
+		return nil, err
+	}
+	for other, ob := range backends {
+		if other == name || ob.path == "" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(raftDir, ob.path)); err == nil {
+			return nil, fmt.Errorf("%q contains a raftlog of backend %q, refusing to start with an empty %q raftlog", raftDir, other, name)
+		}
+	}
+	if b.path == "" {
+		return b.open("", errorIfExist, useProtobuf, o)
+	}
+	return b.open(filepath.Join(raftDir, b.path), errorIfExist, useProtobuf, o)
+}
@@ -0,0 +1,122 @@
+package raftstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestOpenLogStore(t *testing.T) {
+	tempdir := t.TempDir()
+
+	if _, err := OpenLogStore("unknown", tempdir, false, true, Options{}); err == nil {
+		t.Fatalf("OpenLogStore(unknown): unexpectedly succeeded")
+	}
+
+	mem, err := OpenLogStore("memory", tempdir, false, true, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.StoreLog(&raft.Log{Index: 1, Type: raft.LogCommand}); err != nil {
+		t.Fatal(err)
+	}
+	if last, err := mem.LastIndex(); err != nil || last != 1 {
+		t.Fatalf("LastIndex(): got %d, %v, want 1, nil", last, err)
+	}
+	if err := mem.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if fis, err := ioutil.ReadDir(tempdir); err != nil || len(fis) > 0 {
+		t.Fatalf("memory backend unexpectedly created files: %v, %v", fis, err)
+	}
+
+	s, err := OpenLogStore("leveldb", tempdir, false, true, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetUint64([]byte("CurrentTerm"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A raftlog of a different backend must not be silently ignored.
+	if err := ioutil.WriteFile(filepath.Join(tempdir, backends["bbolt"].path), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenLogStore("leveldb", tempdir, false, true, Options{}); err == nil {
+		t.Fatalf("OpenLogStore(leveldb): unexpectedly succeeded with an existing bbolt raftlog")
+	}
+}
+
+// benchmarkBackends runs |fn| for all compiled-in backends, so that e.g.
+// “go test -tags badger -bench .” compares badger against LevelDB.
+func benchmarkBackends(b *testing.B, fn func(b *testing.B, s LogStore)) {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if backends[name].open == nil {
+			continue
+		}
+		b.Run(name, func(b *testing.B) {
+			// The bbolt and badger backends always write synchronously.
+			s, err := OpenLogStore(name, b.TempDir(), false, true, Options{Sync: true})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer s.Close()
+			fn(b, s)
+		})
+	}
+}
+
+// benchmarkLogs returns |n| log entries starting at |first|, whose data is
+// the size of a typical IRC message.
+func benchmarkLogs(first uint64, n int) []*raft.Log {
+	data := bytes.Repeat([]byte("x"), 300)
+	logs := make([]*raft.Log, n)
+	for i := range logs {
+		logs[i] = &raft.Log{
+			Index: first + uint64(i),
+			Term:  1,
+			Type:  raft.LogCommand,
+			Data:  data,
+		}
+	}
+	return logs
+}
+
+func BenchmarkStoreLogs(b *testing.B) {
+	benchmarkBackends(b, func(b *testing.B, s LogStore) {
+		const batch = 64
+		for i := 0; i < b.N; i++ {
+			if err := s.StoreLogs(benchmarkLogs(uint64(i*batch+1), batch)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetLog(b *testing.B) {
+	benchmarkBackends(b, func(b *testing.B, s LogStore) {
+		const n = 10000
+		if err := s.StoreLogs(benchmarkLogs(1, n)); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		var l raft.Log
+		for i := 0; i < b.N; i++ {
+			if err := s.GetLog(uint64(i%n+1), &l); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package raftstore
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// backupBatchSize is the number of keys Backup writes at once.
+const backupBatchSize = 1000
+
+// Backup writes a consistent copy of the database (log entries, stable store
+// values and the session index) to a new LevelDB database in |dir|, while
+// the LevelDBStore remains usable. Values are copied as stored, i.e. still
+// encrypted if encryption is enabled.
+func (s *LevelDBStore) Backup(dir string) error {
+	snap, err := s.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	db, err := leveldb.OpenFile(dir, &opt.Options{ErrorIfExist: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	i := snap.snap.NewIterator(nil, &opt.ReadOptions{DontFillCache: true})
+	defer i.Release()
+	var batch leveldb.Batch
+	for i.Next() {
+		batch.Put(i.Key(), i.Value())
+		if batch.Len() >= backupBatchSize {
+			if err := db.Write(&batch, nil); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := i.Error(); err != nil {
+		return err
+	}
+	if err := db.Write(&batch, &opt.WriteOptions{Sync: true}); err != nil {
+		return err
+	}
+	return db.Close()
+}
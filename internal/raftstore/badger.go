@@ -0,0 +1,293 @@
+//go:build badger
+// +build badger
+
+package raftstore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+
+	pb "github.com/robustirc/robustirc/internal/proto"
+)
+
+// The badger backend is not part of the default build, so that
+// github.com/dgraph-io/badger is not linked into the binaries of networks
+// which do not use it. Build with -tags badger to select it via
+// -raftlog_backend=badger.
+
+var (
+	badgerLogPrefix    = []byte("l")
+	badgerStablePrefix = []byte("s")
+)
+
+// badgerValueThreshold is the size above which values are stored in the value
+// log instead of the LSM tree. Most log entries are larger, so the LSM tree
+// only contains keys and compacts quickly even at high message rates.
+const badgerValueThreshold = 64
+
+// badgerGCInterval is how often the value log is garbage collected, which
+// reclaims the space of log entries removed by DeleteRange.
+const badgerGCInterval = 5 * time.Minute
+
+func init() {
+	backends["badger"].open = func(path string, errorIfExist, useProtobuf bool, o Options) (LogStore, error) {
+		return NewBadgerStore(path, errorIfExist, useProtobuf)
+	}
+}
+
+// BadgerStore implements the raft.LogStore and raft.StableStore interfaces on
+// top of badger. Values are encoded like in LevelDBStore.
+type BadgerStore struct {
+	db   *badger.DB
+	done chan struct{}
+
+	// XXX(1.0): delete this field
+	useProtobuf bool
+}
+
+// NewBadgerStore opens the badger database in the directory |dir|.
+func NewBadgerStore(dir string, errorIfExist bool, useProtobuf bool) (*BadgerStore, error) {
+	if errorIfExist {
+		if fis, err := ioutil.ReadDir(dir); err == nil && len(fis) > 0 {
+			return nil, fmt.Errorf("You specified -singlenode or -join, but %q already contains data, indicating this node is already part of a RobustIRC network. THIS IS UNSAFE! It will lead to split-brain scenarios and data-loss. Please see http://robustirc.net/docs/adminguide.html#_healing_partitions if you are trying to heal a network partition.", dir)
+		}
+	}
+	opts := badger.DefaultOptions(dir).
+		WithLogger(nil).
+		WithValueThreshold(badgerValueThreshold).
+		// raft requires log entries to be durable once StoreLogs returns.
+		WithSyncWrites(true)
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not open: %v", err)
+	}
+	s := &BadgerStore{db: db, done: make(chan struct{}), useProtobuf: useProtobuf}
+	go s.collectGarbage()
+	return s, nil
+}
+
+func (s *BadgerStore) collectGarbage() {
+	ticker := time.NewTicker(badgerGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		// Each call rewrites at most one value log file, so repeat until
+		// there is nothing left to collect.
+		for {
+			if err := s.db.RunValueLogGC(0.5); err != nil {
+				if err != badger.ErrNoRewrite {
+					log.Printf("badger value log GC: %v", err)
+				}
+				break
+			}
+		}
+	}
+}
+
+func badgerLogKey(index uint64) []byte {
+	key := make([]byte, len(badgerLogPrefix)+binary.Size(index))
+	copy(key, badgerLogPrefix)
+	binary.BigEndian.PutUint64(key[len(badgerLogPrefix):], index)
+	return key
+}
+
+func badgerStableKey(key []byte) []byte {
+	return append(append([]byte(nil), badgerStablePrefix...), key...)
+}
+
+// Close implements LogStore.
+func (s *BadgerStore) Close() error {
+	close(s.done)
+	return s.db.Close()
+}
+
+// boundary returns the first (or, if |last| is true, the last) index.
+func (s *BadgerStore) boundary(last bool) (uint64, error) {
+	var index uint64
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{
+			Prefix:  badgerLogPrefix,
+			Reverse: last,
+		})
+		defer it.Close()
+		if last {
+			it.Seek(badgerLogKey(^uint64(0)))
+		} else {
+			it.Rewind()
+		}
+		if it.Valid() {
+			index = binary.BigEndian.Uint64(it.Item().Key()[len(badgerLogPrefix):])
+		}
+		return nil
+	})
+	return index, err
+}
+
+// FirstIndex implements raft.LogStore.
+func (s *BadgerStore) FirstIndex() (uint64, error) {
+	return s.boundary(false)
+}
+
+// LastIndex implements raft.LogStore.
+func (s *BadgerStore) LastIndex() (uint64, error) {
+	return s.boundary(true)
+}
+
+// GetLog implements raft.LogStore.
+func (s *BadgerStore) GetLog(index uint64, rlog *raft.Log) error {
+	var value []byte
+	if err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(badgerLogKey(index))
+		if err != nil {
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		return err
+	}); err != nil {
+		if err == badger.ErrKeyNotFound {
+			return raft.ErrLogNotFound
+		}
+		return err
+	}
+	if len(value) > 0 && value[0] == 'p' {
+		var msg pb.RaftLog
+		if err := proto.Unmarshal(value[1:], &msg); err != nil {
+			return err
+		}
+		rlog.Index = msg.Index
+		rlog.Term = msg.Term
+		rlog.Type = raft.LogType(msg.Type)
+		rlog.Data = msg.Data
+		rlog.Extensions = msg.Extensions
+		return nil
+	}
+	// XXX(1.0): delete this branch, all stores use proto
+	return json.Unmarshal(value, rlog)
+}
+
+// StoreLog implements raft.LogStore.
+func (s *BadgerStore) StoreLog(entry *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{entry})
+}
+
+// StoreLogs implements raft.LogStore.
+func (s *BadgerStore) StoreLogs(logs []*raft.Log) error {
+	// Unlike a transaction, a WriteBatch is not limited in size.
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	var msg pb.RaftLog
+	for _, entry := range logs {
+		var v []byte
+		if s.useProtobuf {
+			msg.Index = entry.Index
+			msg.Term = entry.Term
+			msg.Type = pb.RaftLog_LogType(entry.Type)
+			msg.Data = entry.Data
+			msg.Extensions = entry.Extensions
+			encoded, err := proto.Marshal(&msg)
+			if err != nil {
+				return err
+			}
+			v = append([]byte{'p'}, encoded...)
+		} else {
+			var err error
+			if v, err = json.Marshal(entry); err != nil {
+				return err
+			}
+		}
+		if err := wb.Set(badgerLogKey(entry.Index), v); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// StoreLogProto implements LogStore.
+func (s *BadgerStore) StoreLogProto(msg *pb.RaftLog) error {
+	v, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(badgerLogKey(msg.Index), append([]byte{'p'}, v...))
+	})
+}
+
+// DeleteRange implements raft.LogStore.
+func (s *BadgerStore) DeleteRange(min, max uint64) error {
+	var keys [][]byte
+	if err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: badgerLogPrefix})
+		defer it.Close()
+		for it.Seek(badgerLogKey(min)); it.Valid(); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			if binary.BigEndian.Uint64(key[len(badgerLogPrefix):]) > max {
+				break
+			}
+			keys = append(keys, key)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range keys {
+		if err := wb.Delete(key); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// Set implements raft.StableStore.
+func (s *BadgerStore) Set(key []byte, val []byte) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(badgerStableKey(key), val)
+	})
+}
+
+// Get implements raft.StableStore.
+func (s *BadgerStore) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(badgerStableKey(key))
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return nil
+			}
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		return err
+	})
+	return value, err
+}
+
+// SetUint64 implements raft.StableStore.
+func (s *BadgerStore) SetUint64(key []byte, val uint64) error {
+	v := make([]byte, binary.Size(val))
+	binary.BigEndian.PutUint64(v, val)
+	return s.Set(key, v)
+}
+
+// GetUint64 implements raft.StableStore.
+func (s *BadgerStore) GetUint64(key []byte) (uint64, error) {
+	v, err := s.Get(key)
+	if err != nil || v == nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
@@ -0,0 +1,232 @@
+//go:build bbolt
+// +build bbolt
+
+package raftstore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+	bolt "go.etcd.io/bbolt"
+
+	pb "github.com/robustirc/robustirc/internal/proto"
+)
+
+// The bbolt backend is not part of the default build, so that go.etcd.io/bbolt
+// is not linked into the binaries of networks which do not use it. Build with
+// -tags bbolt to select it via -raftlog_backend=bbolt.
+
+var (
+	boltLogsBucket   = []byte("logs")
+	boltStableBucket = []byte("stablestore")
+)
+
+func init() {
+	backends["bbolt"].open = func(path string, errorIfExist, useProtobuf bool, o Options) (LogStore, error) {
+		return NewBoltStore(path, errorIfExist, useProtobuf)
+	}
+}
+
+// BoltStore implements the raft.LogStore and raft.StableStore interfaces on
+// top of a single bbolt file. Keys and values are encoded like in
+// LevelDBStore, so that raftlog entries look the same in both backends.
+type BoltStore struct {
+	db *bolt.DB
+
+	// XXX(1.0): delete this field
+	useProtobuf bool
+}
+
+// NewBoltStore opens the bbolt file |path|.
+func NewBoltStore(path string, errorIfExist bool, useProtobuf bool) (*BoltStore, error) {
+	if errorIfExist {
+		if _, err := os.Stat(path); err == nil {
+			return nil, fmt.Errorf("You specified -singlenode or -join, but %q already exists, indicating this node is already part of a RobustIRC network. THIS IS UNSAFE! It will lead to split-brain scenarios and data-loss. Please see http://robustirc.net/docs/adminguide.html#_healing_partitions if you are trying to heal a network partition.", path)
+		}
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open: %v", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltLogsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltStableBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db, useProtobuf: useProtobuf}, nil
+}
+
+// Close implements LogStore.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// FirstIndex implements raft.LogStore.
+func (s *BoltStore) FirstIndex() (uint64, error) {
+	var index uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if k, _ := tx.Bucket(boltLogsBucket).Cursor().First(); k != nil {
+			index = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+	return index, err
+}
+
+// LastIndex implements raft.LogStore.
+func (s *BoltStore) LastIndex() (uint64, error) {
+	var index uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if k, _ := tx.Bucket(boltLogsBucket).Cursor().Last(); k != nil {
+			index = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+	return index, err
+}
+
+// GetLog implements raft.LogStore.
+func (s *BoltStore) GetLog(index uint64, rlog *raft.Log) error {
+	key := make([]byte, binary.Size(index))
+	binary.BigEndian.PutUint64(key, index)
+	return s.db.View(func(tx *bolt.Tx) error {
+		// value is only valid within the transaction, but both
+		// proto.Unmarshal and json.Unmarshal copy the data.
+		value := tx.Bucket(boltLogsBucket).Get(key)
+		if value == nil {
+			return raft.ErrLogNotFound
+		}
+		if len(value) > 0 && value[0] == 'p' {
+			var msg pb.RaftLog
+			if err := proto.Unmarshal(value[1:], &msg); err != nil {
+				return err
+			}
+			rlog.Index = msg.Index
+			rlog.Term = msg.Term
+			rlog.Type = raft.LogType(msg.Type)
+			rlog.Data = append([]byte(nil), msg.Data...)
+			rlog.Extensions = append([]byte(nil), msg.Extensions...)
+			return nil
+		}
+		// XXX(1.0): delete this branch, all stores use proto
+		return json.Unmarshal(value, rlog)
+	})
+}
+
+// StoreLog implements raft.LogStore.
+func (s *BoltStore) StoreLog(entry *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{entry})
+}
+
+// StoreLogs implements raft.LogStore.
+func (s *BoltStore) StoreLogs(logs []*raft.Log) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltLogsBucket)
+		var msg pb.RaftLog
+		for _, entry := range logs {
+			key := make([]byte, binary.Size(uint64(0)))
+			binary.BigEndian.PutUint64(key, entry.Index)
+			var v []byte
+			if s.useProtobuf {
+				msg.Index = entry.Index
+				msg.Term = entry.Term
+				msg.Type = pb.RaftLog_LogType(entry.Type)
+				msg.Data = entry.Data
+				msg.Extensions = entry.Extensions
+				encoded, err := proto.Marshal(&msg)
+				if err != nil {
+					return err
+				}
+				v = append([]byte{'p'}, encoded...)
+			} else {
+				var err error
+				if v, err = json.Marshal(entry); err != nil {
+					return err
+				}
+			}
+			if err := b.Put(key, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// StoreLogProto implements LogStore.
+func (s *BoltStore) StoreLogProto(msg *pb.RaftLog) error {
+	v, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	key := make([]byte, binary.Size(uint64(0)))
+	binary.BigEndian.PutUint64(key, msg.Index)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltLogsBucket).Put(key, append([]byte{'p'}, v...))
+	})
+}
+
+// DeleteRange implements raft.LogStore.
+func (s *BoltStore) DeleteRange(min, max uint64) error {
+	start := make([]byte, binary.Size(min))
+	binary.BigEndian.PutUint64(start, min)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltLogsBucket)
+		// Deleting while iterating skips entries, so collect the keys first.
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.Seek(start); k != nil && binary.BigEndian.Uint64(k) <= max; k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Set implements raft.StableStore.
+func (s *BoltStore) Set(key []byte, val []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltStableBucket).Put(key, val)
+	})
+}
+
+// Get implements raft.StableStore.
+func (s *BoltStore) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(boltStableBucket).Get(key); v != nil {
+			value = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+// SetUint64 implements raft.StableStore.
+func (s *BoltStore) SetUint64(key []byte, val uint64) error {
+	v := make([]byte, binary.Size(val))
+	binary.BigEndian.PutUint64(v, val)
+	return s.Set(key, v)
+}
+
+// GetUint64 implements raft.StableStore.
+func (s *BoltStore) GetUint64(key []byte) (uint64, error) {
+	v, err := s.Get(key)
+	if err != nil || v == nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
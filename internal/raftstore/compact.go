@@ -0,0 +1,86 @@
+package raftstore
+
+import (
+	"encoding/binary"
+	"log"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// EnableCompaction makes DeleteRange compact the deleted key span in the
+// background, so that the disk space of deleted log entries is reclaimed
+// instead of only writing tombstones. Compactions start at most once per
+// |minInterval|; spans deleted in the meantime are compacted along with the
+// next one. It must be called before the LevelDBStore is used.
+func (s *LevelDBStore) EnableCompaction(minInterval time.Duration) {
+	s.compactEnabled = true
+	s.compactInterval = minInterval
+}
+
+// Compact compacts the entire database. It blocks until the compaction is
+// done.
+func (s *LevelDBStore) Compact() error {
+	start := time.Now()
+	if err := s.db.CompactRange(util.Range{}); err != nil {
+		return err
+	}
+	log.Printf("Compacted %q in %v", s.dir, time.Since(start))
+	return nil
+}
+
+// scheduleCompaction records that the log entries [min, max] were deleted and
+// starts a background compaction unless one is running or the last one
+// started less than s.compactInterval ago.
+func (s *LevelDBStore) scheduleCompaction(min, max uint64) {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+	if !s.compactEnabled {
+		return
+	}
+	if !s.compactPending || min < s.compactMin {
+		s.compactMin = min
+	}
+	if !s.compactPending || max > s.compactMax {
+		s.compactMax = max
+	}
+	s.compactPending = true
+	if s.compacting || time.Since(s.compactLast) < s.compactInterval {
+		return
+	}
+	s.compacting = true
+	s.compactLast = time.Now()
+	min, max = s.compactMin, s.compactMax
+	s.compactPending = false
+	s.compactWg.Add(1)
+	go func() {
+		defer s.compactWg.Done()
+		if err := s.compactLogRange(min, max); err != nil {
+			log.Printf("Could not compact %q: %v", s.dir, err)
+		}
+		s.compactMu.Lock()
+		defer s.compactMu.Unlock()
+		s.compacting = false
+	}()
+}
+
+// compactLogRange compacts the keys of the log entries [min, max] and of
+// their reverse session index entries.
+func (s *LevelDBStore) compactLogRange(min, max uint64) error {
+	start := time.Now()
+	startKey := make([]byte, binary.Size(min))
+	limitKey := make([]byte, binary.Size(max))
+	binary.BigEndian.PutUint64(startKey, min)
+	binary.BigEndian.PutUint64(limitKey, max+1)
+	if err := s.db.CompactRange(util.Range{Start: startKey, Limit: limitKey}); err != nil {
+		return err
+	}
+	if err := s.db.CompactRange(util.Range{
+		Start: sessionIndexReverseKey(min),
+		Limit: sessionIndexReverseKey(max + 1),
+	}); err != nil {
+		return err
+	}
+	log.Printf("Compacted log entries [%d, %d] of %q in %v", min, max, s.dir, time.Since(start))
+	return nil
+}
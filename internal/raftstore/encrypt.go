@@ -0,0 +1,126 @@
+package raftstore
+
+import (
+	"fmt"
+
+	"github.com/robustirc/robustirc/internal/encryption"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+)
+
+// encryptedPrefix is the first byte of encrypted log entry values. Protobuf
+// values start with 'p', JSON values with '{'.
+const encryptedPrefix = 'e'
+
+// EnableEncryption makes the LevelDBStore encrypt log entries with |c| when
+// storing them. Log entries which were stored unencrypted remain readable.
+// Stable store values and the session index are not encrypted, as they do
+// not contain IRC messages. It must be called before the LevelDBStore is
+// used.
+func (s *LevelDBStore) EnableEncryption(c *encryption.Cipher) {
+	s.cipher = c
+}
+
+// sealValue returns the log entry value |v| encrypted if encryption is
+// enabled.
+func (s *LevelDBStore) sealValue(v []byte) ([]byte, error) {
+	if s.cipher == nil {
+		return v, nil
+	}
+	return s.cipher.Seal([]byte{encryptedPrefix}, v)
+}
+
+// OpenValue returns the plaintext of the log entry value |v|, which is
+// returned unmodified unless it was encrypted (see EnableEncryption). |c| may
+// be nil if the store is not encrypted.
+func OpenValue(c *encryption.Cipher, v []byte) ([]byte, error) {
+	if len(v) == 0 || v[0] != encryptedPrefix {
+		return v, nil
+	}
+	if c == nil {
+		return nil, fmt.Errorf("log entry is encrypted, but no encryption key was specified")
+	}
+	return c.Open(v[1:])
+}
+
+// sealBatch returns |batch| with all log entry values encrypted.
+func (s *LevelDBStore) sealBatch(batch *leveldb.Batch) (*leveldb.Batch, error) {
+	if s.cipher == nil {
+		return batch, nil
+	}
+	sealer := &batchSealer{s: s}
+	if err := batch.Replay(sealer); err != nil {
+		return nil, err
+	}
+	return &sealer.batch, sealer.err
+}
+
+type batchSealer struct {
+	s     *LevelDBStore
+	batch leveldb.Batch
+	err   error
+}
+
+func (b *batchSealer) Put(key, value []byte) {
+	if IsLogKey(key) {
+		sealed, err := b.s.sealValue(value)
+		if err != nil && b.err == nil {
+			b.err = err
+		}
+		value = sealed
+	}
+	b.batch.Put(key, value)
+}
+
+func (b *batchSealer) Delete(key []byte) {
+	b.batch.Delete(key)
+}
+
+// openingIterator returns decrypted log entry values. Values are decrypted
+// when the iterator is positioned, so that callers which check Error before
+// calling Value (as in the loops over GetBulkIterator) notice decryption
+// errors. Value returns nil for entries which could not be decrypted.
+type openingIterator struct {
+	iterator.Iterator
+	cipher *encryption.Cipher
+	value  []byte
+	err    error
+}
+
+func newOpeningIterator(i iterator.Iterator, c *encryption.Cipher) iterator.Iterator {
+	if c == nil {
+		return i
+	}
+	return &openingIterator{Iterator: i, cipher: c}
+}
+
+// open decrypts the value at the current position if |ok|.
+func (o *openingIterator) open(ok bool) bool {
+	o.value = nil
+	if !ok {
+		return false
+	}
+	v, err := OpenValue(o.cipher, o.Iterator.Value())
+	if err != nil {
+		if o.err == nil {
+			o.err = fmt.Errorf("key %x: %v", o.Iterator.Key(), err)
+		}
+		return true
+	}
+	o.value = v
+	return true
+}
+
+func (o *openingIterator) First() bool          { return o.open(o.Iterator.First()) }
+func (o *openingIterator) Last() bool           { return o.open(o.Iterator.Last()) }
+func (o *openingIterator) Seek(key []byte) bool { return o.open(o.Iterator.Seek(key)) }
+func (o *openingIterator) Next() bool           { return o.open(o.Iterator.Next()) }
+func (o *openingIterator) Prev() bool           { return o.open(o.Iterator.Prev()) }
+func (o *openingIterator) Value() []byte        { return o.value }
+
+func (o *openingIterator) Error() error {
+	if o.err != nil {
+		return o.err
+	}
+	return o.Iterator.Error()
+}
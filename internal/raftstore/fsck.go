@@ -0,0 +1,169 @@
+package raftstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/robustirc/robustirc/internal/raftlog"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// stableStorePrefix is the key prefix of raft.StableStore values, see Set.
+var stableStorePrefix = []byte("stablestore-")
+
+// FsckReport describes the contents of a LevelDBStore and the problems Fsck
+// found in it.
+type FsckReport struct {
+	// LogEntries is the number of decodable log entries, First and Last
+	// are the lowest and highest index among them.
+	LogEntries  int
+	First, Last uint64
+
+	// Gaps are the ranges of missing (or corrupted) indexes between First
+	// and Last. Gaps are expected in the irclog (see DeleteRange), but not
+	// in the raftlog.
+	Gaps [][2]uint64
+
+	// Corrupted are the keys of log entries which cannot be decrypted or
+	// decoded, or whose index does not match their key.
+	Corrupted []uint64
+
+	// DanglingIndex is the number of session index entries which refer to
+	// missing or corrupted log entries.
+	DanglingIndex int
+
+	// UnknownKeys is the number of keys which belong to neither log
+	// entries, the stable store nor the session index.
+	UnknownKeys int
+
+	// Repaired is true if Fsck deleted the corrupted log entries and
+	// dangling session index entries.
+	Repaired bool
+}
+
+// OK returns whether the store contains no corrupted entries. Gaps are not
+// considered, as they are expected in the irclog.
+func (r *FsckReport) OK() bool {
+	return len(r.Corrupted) == 0 && r.DanglingIndex == 0 && r.UnknownKeys == 0
+}
+
+func (r *FsckReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d log entries in [%d, %d], %d gaps", r.LogEntries, r.First, r.Last, len(r.Gaps))
+	for idx, gap := range r.Gaps {
+		if idx == 10 {
+			fmt.Fprintf(&b, " …")
+			break
+		}
+		fmt.Fprintf(&b, " [%d, %d]", gap[0], gap[1])
+	}
+	fmt.Fprintf(&b, ", %d corrupted log entries %v, %d dangling session index entries, %d unknown keys",
+		len(r.Corrupted), r.Corrupted, r.DanglingIndex, r.UnknownKeys)
+	if r.Repaired {
+		fmt.Fprintf(&b, " (repaired)")
+	}
+	return b.String()
+}
+
+// Fsck reads all keys of the store and verifies that log entries can be
+// decoded and that the session index only refers to existing log entries.
+// If |repair| is true, corrupted log entries and dangling session index
+// entries are deleted. Deleting corrupted log entries leaves gaps, so
+// operators must decide whether the node can continue (e.g. when the gaps
+// are covered by a snapshot) or has to re-join the network.
+func (s *LevelDBStore) Fsck(repair bool) (*FsckReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		report FsckReport
+		batch  leveldb.Batch
+		prev   uint64
+	)
+	valid := make(map[uint64]bool)
+	i := s.db.NewIterator(nil, nil)
+	defer i.Release()
+	for i.Next() {
+		key := i.Key()
+		switch {
+		case IsLogKey(key):
+			index := binary.BigEndian.Uint64(key)
+			if err := s.checkLogValue(index, i.Value()); err != nil {
+				report.Corrupted = append(report.Corrupted, index)
+				batch.Delete(append([]byte(nil), key...))
+				continue
+			}
+			valid[index] = true
+			if report.LogEntries == 0 {
+				report.First = index
+			} else if index > prev+1 {
+				report.Gaps = append(report.Gaps, [2]uint64{prev + 1, index - 1})
+			}
+			prev = index
+			report.Last = index
+			report.LogEntries++
+
+		case bytes.HasPrefix(key, sessionIndexReversePrefix):
+			if len(key) != len(sessionIndexReversePrefix)+binary.Size(uint64(0)) {
+				report.UnknownKeys++
+				continue
+			}
+			if index := binary.BigEndian.Uint64(key[len(sessionIndexReversePrefix):]); !valid[index] {
+				report.DanglingIndex++
+				batch.Delete(append([]byte(nil), key...))
+			}
+
+		case bytes.HasPrefix(key, sessionIndexPrefix):
+			if len(key) != len(sessionIndexPrefix)+2*binary.Size(uint64(0)) {
+				report.UnknownKeys++
+				continue
+			}
+			if index := binary.BigEndian.Uint64(key[len(key)-binary.Size(uint64(0)):]); !valid[index] {
+				report.DanglingIndex++
+				batch.Delete(append([]byte(nil), key...))
+			}
+
+		case bytes.HasPrefix(key, stableStorePrefix):
+			// Stable store values are opaque.
+
+		case bytes.Equal(key, sessionIndexedKey):
+			// Marker, see BackfillSessionIndex.
+
+		default:
+			report.UnknownKeys++
+		}
+	}
+	if err := i.Error(); err != nil {
+		return &report, err
+	}
+	if repair && batch.Len() > 0 {
+		if s.cache != nil {
+			s.cache.Purge()
+		}
+		if err := s.db.Write(&batch, &opt.WriteOptions{Sync: true}); err != nil {
+			return &report, err
+		}
+		report.Repaired = true
+	}
+	return &report, nil
+}
+
+// checkLogValue returns an error if |value| is not a decodable log entry with
+// index |index|.
+func (s *LevelDBStore) checkLogValue(index uint64, value []byte) error {
+	value, err := OpenValue(s.cipher, value)
+	if err != nil {
+		return err
+	}
+	l, err := raftlog.FromBytes(value)
+	if err != nil {
+		return err
+	}
+	if l.Index != index {
+		return fmt.Errorf("log entry stored under index %d has index %d", index, l.Index)
+	}
+	return nil
+}
@@ -6,43 +6,161 @@
 package raftstore
 
 import (
-	"bytes"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/golang/protobuf/proto"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/hashicorp/raft"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robustirc/robustirc/internal/encryption"
 	"github.com/robustirc/robustirc/internal/raftlog"
 	"github.com/robustirc/robustirc/internal/robust"
 	"github.com/syndtr/goleveldb/leveldb"
 	leveldb_errors "github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
 	"github.com/syndtr/goleveldb/leveldb/util"
 
 	pb "github.com/robustirc/robustirc/internal/proto"
 )
 
+var (
+	// sessionIndexPrefix is the key prefix of the secondary index which maps a
+	// session id to the log entries which are relevant for that session, i.e.
+	// keys are sessionIndexPrefix + session id + raft index.
+	sessionIndexPrefix = []byte("sessionindex-")
+
+	// sessionIndexReversePrefix is the key prefix of the reverse mapping of
+	// sessionIndexPrefix, i.e. keys are sessionIndexReversePrefix + raft index,
+	// values are the concatenated session ids. It is used to delete the
+	// secondary index entries along with the log entries in DeleteRange.
+	sessionIndexReversePrefix = []byte("sessionindexrev-")
+
+	// sessionIndexedKey is present once BackfillSessionIndex indexed the log
+	// entries which were stored before the secondary index existed.
+	sessionIndexedKey = []byte("sessionindexed")
+
+	// logRange covers all log entries. Log entries are stored under their
+	// big-endian raft index, which sorts before all other keys (e.g.
+	// sessionIndexPrefix) for any realistic index (< 0x73 << 56).
+	logRange = &util.Range{Limit: []byte("sessionindex")}
+)
+
+var (
+	getLogCacheHits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "raftstore",
+			Name:      "getlog_cache_hits",
+			Help:      "GetLog calls which were served from the cache, see EnableCache",
+		},
+		[]string{"store"},
+	)
+
+	getLogCacheMisses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "raftstore",
+			Name:      "getlog_cache_misses",
+			Help:      "GetLog calls which had to read from LevelDB, see EnableCache",
+		},
+		[]string{"store"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(getLogCacheHits)
+	prometheus.MustRegister(getLogCacheMisses)
+}
+
+// IsLogKey returns whether |key| refers to a log entry, as opposed to e.g. a
+// stable store value or a secondary index entry.
+func IsLogKey(key []byte) bool {
+	return len(key) == binary.Size(uint64(0))
+}
+
 // LevelDBStore implements the raft.LogStore and raft.StableStore interfaces on
 // top of leveldb.
 type LevelDBStore struct {
 	mu sync.RWMutex
 	db *leveldb.DB
+	wo *opt.WriteOptions
+
+	// cache is nil unless EnableCache was called.
+	cache *lru.Cache
+
+	// cipher is nil unless EnableEncryption was called.
+	cipher *encryption.Cipher
+
+	// The compaction fields are set by EnableCompaction and used by
+	// scheduleCompaction.
+	compactEnabled  bool
+	compactInterval time.Duration
+	compactMu       sync.Mutex
+	compactPending  bool
+	compactMin      uint64
+	compactMax      uint64
+	compacting      bool
+	compactLast     time.Time
+	compactWg       sync.WaitGroup
+
+	// keyCounts caches the result of countKeys, see EnableMetrics.
+	keyCountsMu sync.Mutex
+	keyCounts   keyCounts
 
 	// XXX(1.0): delete these fields
 	useProtobuf bool
 	dir         string
 }
 
+// name identifies the LevelDBStore in metrics, e.g. “raftlog”.
+func (s *LevelDBStore) name() string {
+	return filepath.Base(s.dir)
+}
+
+// Options tunes the durability and performance of a LevelDBStore. The zero
+// value results in asynchronous writes and the goleveldb defaults.
+type Options struct {
+	// Sync makes writes wait until the data was flushed to disk (fsync).
+	// Asynchronous writes survive crashes of the process, but not crashes
+	// of the machine.
+	Sync bool
+
+	// WriteBuffer is the size in bytes up to which writes are buffered in
+	// memory before they are written to a table file. 0 means the goleveldb
+	// default (4 MiB).
+	WriteBuffer int
+
+	// NoCompression disables the snappy compression of table blocks.
+	NoCompression bool
+}
+
+func (o Options) leveldbOptions() *opt.Options {
+	lo := &opt.Options{WriteBuffer: o.WriteBuffer}
+	if o.NoCompression {
+		lo.Compression = opt.NoCompression
+	}
+	return lo
+}
+
 // NewLevelDBStore opens a leveldb at the given directory to be used as a log-
 // and stable storage for raft.
 func NewLevelDBStore(dir string, errorIfExist bool, useProtobuf bool) (*LevelDBStore, error) {
-	db, err := leveldb.OpenFile(dir, &opt.Options{ErrorIfExist: errorIfExist})
+	return NewLevelDBStoreWithOptions(dir, errorIfExist, useProtobuf, Options{})
+}
+
+// NewLevelDBStoreWithOptions is like NewLevelDBStore, but uses |o| instead
+// of the default Options.
+func NewLevelDBStoreWithOptions(dir string, errorIfExist bool, useProtobuf bool, o Options) (*LevelDBStore, error) {
+	openOpts := o.leveldbOptions()
+	openOpts.ErrorIfExist = errorIfExist
+	db, err := leveldb.OpenFile(dir, openOpts)
 	if err != nil {
 		if errorIfExist && err == os.ErrExist {
 			// TODO: migrate this check to raft.HasExistingState
@@ -51,19 +169,39 @@ func NewLevelDBStore(dir string, errorIfExist bool, useProtobuf bool) (*LevelDBS
 		if _, ok := err.(*leveldb_errors.ErrCorrupted); !ok {
 			return nil, fmt.Errorf("could not open: %v", err)
 		}
-		db, err = leveldb.RecoverFile(dir, nil)
+		db, err = leveldb.RecoverFile(dir, o.leveldbOptions())
 		if err != nil {
 			return nil, fmt.Errorf("could not recover: %v", err)
 		}
 	}
 
-	s := &LevelDBStore{db: db, useProtobuf: useProtobuf, dir: dir}
+	s := &LevelDBStore{
+		db:          db,
+		wo:          &opt.WriteOptions{Sync: o.Sync},
+		useProtobuf: useProtobuf,
+		dir:         dir,
+	}
 	if useProtobuf {
 		return s, s.ConvertToProto()
 	}
 	return s, nil
 }
 
+// NewMemoryStore returns a LevelDBStore which keeps all data in memory, e.g.
+// for tests or for nodes which do not need to survive restarts.
+func NewMemoryStore(useProtobuf bool) (*LevelDBStore, error) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelDBStore{
+		db:          db,
+		wo:          &opt.WriteOptions{},
+		useProtobuf: useProtobuf,
+		dir:         "(memory)",
+	}, nil
+}
+
 // convertToProto converts the database to use protobuf-encoded values instead
 // of json-encoded values. This is a no-op once the database has been converted.
 func (s *LevelDBStore) ConvertToProto() error {
@@ -72,16 +210,11 @@ func (s *LevelDBStore) ConvertToProto() error {
 	log.Printf("converting database %q to proto if necessary", s.dir)
 	start := time.Now()
 	defer func() { log.Printf("database conversion done in %v", time.Since(start)) }()
-	i := s.db.NewIterator(nil, nil)
+	i := s.db.NewIterator(logRange, nil)
 	defer i.Release()
 	if !i.First() {
 		return nil
 	}
-	for bytes.HasPrefix(i.Key(), []byte("stablestore-")) {
-		if !i.Next() {
-			return nil
-		}
-	}
 
 	var (
 		batch leveldb.Batch
@@ -91,8 +224,14 @@ func (s *LevelDBStore) ConvertToProto() error {
 		Id:      &pb.RobustId{},
 		Session: &pb.RobustId{},
 	}
-	for !bytes.HasPrefix(i.Key(), []byte("stablestore-")) {
+	for {
 		val := i.Value()
+		if len(val) > 0 && val[0] == encryptedPrefix {
+			// Encrypted entries are only written by versions which
+			// store protobuf.
+			log.Printf("database already converted")
+			return nil
+		}
 		l, err := raftlog.FromBytes(val)
 		if err != nil {
 			return err
@@ -140,7 +279,7 @@ func (s *LevelDBStore) ConvertToProto() error {
 			return nil
 		}
 		if batch.Len() > 100 {
-			if err := s.db.Write(&batch, nil); err != nil {
+			if err := s.db.Write(&batch, s.wo); err != nil {
 				return err
 			}
 			batch.Reset()
@@ -150,11 +289,15 @@ func (s *LevelDBStore) ConvertToProto() error {
 			break
 		}
 	}
-	return s.db.Write(&batch, nil)
+	return s.db.Write(&batch, s.wo)
 }
 
 // Close closes the LevelDBStore. No other methods may be called after this.
 func (s *LevelDBStore) Close() error {
+	// Background compactions use s.db without holding s.mu.
+	s.compactWg.Wait()
+	s.disableMetrics()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -168,16 +311,11 @@ func (s *LevelDBStore) FirstIndex() (uint64, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	i := s.db.NewIterator(nil, nil)
+	i := s.db.NewIterator(logRange, nil)
 	defer i.Release()
 	if !i.First() {
 		return 0, nil
 	}
-	for bytes.HasPrefix(i.Key(), []byte("stablestore-")) {
-		if !i.Next() {
-			return 0, nil
-		}
-	}
 	return binary.BigEndian.Uint64(i.Key()), nil
 }
 
@@ -186,16 +324,11 @@ func (s *LevelDBStore) LastIndex() (uint64, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	i := s.db.NewIterator(nil, nil)
+	i := s.db.NewIterator(logRange, nil)
 	defer i.Release()
 	if !i.Last() {
 		return 0, nil
 	}
-	for bytes.HasPrefix(i.Key(), []byte("stablestore-")) {
-		if !i.Prev() {
-			return 0, nil
-		}
-	}
 	return binary.BigEndian.Uint64(i.Key()), nil
 }
 
@@ -208,30 +341,134 @@ func (s *LevelDBStore) GetBulkIterator(start, limit uint64) iterator.Iterator {
 	limitKey := make([]byte, binary.Size(limit))
 	binary.BigEndian.PutUint64(startKey, start)
 	binary.BigEndian.PutUint64(limitKey, limit)
-	return s.db.NewIterator(&util.Range{
+	return newOpeningIterator(s.db.NewIterator(&util.Range{
 		Start: startKey,
 		Limit: limitKey,
 	}, &opt.ReadOptions{
 		// This function is for reading through (almost) the entire database in
 		// bulk, so caching the blocks does not make sense.
 		DontFillCache: true,
-	})
+	}), s.cipher)
+}
+
+// Iterate calls |fn| for each log entry with an index in [min, max], in index
+// order, stopping at the first error |fn| returns. Indexes without an entry
+// (e.g. compacted ones) are skipped. Unlike calling GetLog for each index,
+// Iterate reads the entries sequentially.
+func (s *LevelDBStore) Iterate(min, max uint64, fn func(*raft.Log) error) error {
+	iterator := s.GetBulkIterator(min, max+1)
+	defer iterator.Release()
+	for available := iterator.First(); available; available = iterator.Next() {
+		l, err := raftlog.FromBytes(iterator.Value())
+		if err != nil {
+			if iterator.Error() != nil {
+				return iterator.Error()
+			}
+			return err
+		}
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+	return iterator.Error()
+}
+
+// Snapshot is a consistent, read-only view of a LevelDBStore at the time
+// GetSnapshot was called. Subsequent writes and deletions (e.g. by
+// DeleteRange) are not visible in the Snapshot.
+type Snapshot struct {
+	snap   *leveldb.Snapshot
+	cipher *encryption.Cipher
+}
+
+// GetSnapshot returns a Snapshot of the current database contents. Release
+// must be called once the Snapshot is no longer needed.
+func (s *LevelDBStore) GetSnapshot() (*Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.db == nil {
+		return nil, leveldb.ErrClosed
+	}
+	snap, err := s.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{snap: snap, cipher: s.cipher}, nil
+}
+
+// LogIterator returns an iterator over all log entries in the Snapshot,
+// starting at raft index |start|.
+func (s *Snapshot) LogIterator(start uint64) iterator.Iterator {
+	startKey := make([]byte, binary.Size(start))
+	binary.BigEndian.PutUint64(startKey, start)
+	return newOpeningIterator(s.snap.NewIterator(&util.Range{
+		Start: startKey,
+		Limit: logRange.Limit,
+	}, &opt.ReadOptions{
+		// Snapshots are read in bulk, see GetBulkIterator.
+		DontFillCache: true,
+	}), s.cipher)
+}
+
+// Release releases the Snapshot. It is safe to call Release multiple times.
+func (s *Snapshot) Release() {
+	s.snap.Release()
+}
+
+// EnableCache makes GetLog serve up to |size| recently stored or read log
+// entries from memory. It must be called before the LevelDBStore is used.
+// The returned entries share their Data with the cache and must not be
+// modified.
+func (s *LevelDBStore) EnableCache(size int) error {
+	cache, err := lru.New(size)
+	if err != nil {
+		return err
+	}
+	s.cache = cache
+	return nil
 }
 
 // GetLog implements raft.LogStore.
 func (s *LevelDBStore) GetLog(index uint64, rlog *raft.Log) error {
+	if s.cache != nil {
+		if cached, ok := s.cache.Get(index); ok {
+			getLogCacheHits.WithLabelValues(s.name()).Inc()
+			*rlog = *cached.(*raft.Log)
+			return nil
+		}
+		getLogCacheMisses.WithLabelValues(s.name()).Inc()
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	if err := s.getLogLocked(index, rlog); err != nil {
+		return err
+	}
+	if s.cache != nil {
+		// Added while holding s.mu, so that DeleteRange cannot remove the
+		// entry from the database in between.
+		cached := *rlog
+		s.cache.Add(index, &cached)
+	}
+	return nil
+}
+
+func (s *LevelDBStore) getLogLocked(index uint64, rlog *raft.Log) error {
 	key := make([]byte, binary.Size(index))
 	binary.BigEndian.PutUint64(key, index)
+	start := time.Now()
 	value, err := s.db.Get(key, nil)
+	readLatency.WithLabelValues(s.name()).Observe(time.Since(start).Seconds())
 	if err != nil {
 		if err == leveldb.ErrNotFound {
 			return raft.ErrLogNotFound
 		}
 		return err
 	}
+	if value, err = OpenValue(s.cipher, value); err != nil {
+		return fmt.Errorf("log entry %d: %v", index, err)
+	}
 	if len(value) > 0 && value[0] == 'p' {
 		var msg pb.RaftLog
 		if err := proto.Unmarshal(value[1:], &msg); err != nil {
@@ -257,7 +494,25 @@ func (s *LevelDBStore) StoreLog(entry *raft.Log) error {
 func (s *LevelDBStore) WriteBatch(batch *leveldb.Batch) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.db.Write(batch, nil)
+	if s.cache != nil {
+		// Batches are only written in bulk (e.g. when restoring), so
+		// there is no point in finding out which entries they replace.
+		s.cache.Purge()
+	}
+	batch, err := s.sealBatch(batch)
+	if err != nil {
+		return err
+	}
+	return s.write(batch)
+}
+
+// write writes |batch| to the database and records its size and latency.
+func (s *LevelDBStore) write(batch *leveldb.Batch) error {
+	start := time.Now()
+	err := s.db.Write(batch, s.wo)
+	writeLatency.WithLabelValues(s.name()).Observe(time.Since(start).Seconds())
+	writeBatchSize.WithLabelValues(s.name()).Observe(float64(batch.Len()))
+	return err
 }
 
 // StoreLogs implements raft.LogStore.
@@ -281,7 +536,11 @@ func (s *LevelDBStore) StoreLogs(logs []*raft.Log) error {
 			if err != nil {
 				return err
 			}
-			batch.Put(key, append([]byte{'p'}, v...))
+			v, err = s.sealValue(append([]byte{'p'}, v...))
+			if err != nil {
+				return err
+			}
+			batch.Put(key, v)
 		}
 	} else {
 		for _, entry := range logs {
@@ -290,11 +549,25 @@ func (s *LevelDBStore) StoreLogs(logs []*raft.Log) error {
 			if err != nil {
 				return err
 			}
+			if v, err = s.sealValue(v); err != nil {
+				return err
+			}
 			batch.Put(key, v)
 		}
 	}
 
-	return s.db.Write(&batch, nil)
+	if err := s.write(&batch); err != nil {
+		return err
+	}
+	if s.cache != nil {
+		// Recently stored entries are likely to be read soon, e.g. when
+		// replicating them to followers.
+		for _, entry := range logs {
+			cached := *entry
+			s.cache.Add(entry.Index, &cached)
+		}
+	}
+	return nil
 }
 
 func (s *LevelDBStore) StoreLogProto(msg *pb.RaftLog) error {
@@ -302,15 +575,21 @@ func (s *LevelDBStore) StoreLogProto(msg *pb.RaftLog) error {
 	if err != nil {
 		return err
 	}
+	if v, err = s.sealValue(append([]byte{'p'}, v...)); err != nil {
+		return err
+	}
 
 	var batch leveldb.Batch
 	key := make([]byte, binary.Size(uint64(0)))
 	binary.BigEndian.PutUint64(key, msg.Index)
-	batch.Put(key, append([]byte{'p'}, v...))
+	batch.Put(key, v)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.db.Write(&batch, nil)
+	if s.cache != nil {
+		s.cache.Remove(msg.Index)
+	}
+	return s.db.Write(&batch, s.wo)
 }
 
 // DeleteRange implements raft.LogStore.
@@ -328,15 +607,159 @@ func (s *LevelDBStore) DeleteRange(min, max uint64) error {
 			return err
 		}
 		batch.Delete(iterator.Key())
+		if err := s.deleteSessionIndexLocked(&batch, binary.BigEndian.Uint64(iterator.Key())); err != nil {
+			return err
+		}
 		available = iterator.Next()
 	}
-	return s.db.Write(&batch, nil)
+	if s.cache != nil {
+		for _, key := range s.cache.Keys() {
+			if index := key.(uint64); index >= min && index <= max {
+				s.cache.Remove(index)
+			}
+		}
+	}
+	if err := s.write(&batch); err != nil {
+		return err
+	}
+	s.scheduleCompaction(min, max)
+	return nil
+}
+
+func sessionIndexKey(session, index uint64) []byte {
+	key := make([]byte, len(sessionIndexPrefix)+2*binary.Size(uint64(0)))
+	n := copy(key, sessionIndexPrefix)
+	binary.BigEndian.PutUint64(key[n:], session)
+	binary.BigEndian.PutUint64(key[n+binary.Size(session):], index)
+	return key
+}
+
+func sessionIndexReverseKey(index uint64) []byte {
+	key := make([]byte, len(sessionIndexReversePrefix)+binary.Size(index))
+	n := copy(key, sessionIndexReversePrefix)
+	binary.BigEndian.PutUint64(key[n:], index)
+	return key
+}
+
+// IndexSessions records that the log entry at |index| is relevant for
+// |sessions|, i.e. either sent by or resulted in output for these sessions.
+func (s *LevelDBStore) IndexSessions(index uint64, sessions []uint64) error {
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var batch leveldb.Batch
+	indexSessions(&batch, index, sessions)
+	return s.db.Write(&batch, s.wo)
+}
+
+// indexSessions adds the secondary index entries of the log entry at |index|
+// to |batch|.
+func indexSessions(batch *leveldb.Batch, index uint64, sessions []uint64) {
+	rev := make([]byte, len(sessions)*binary.Size(uint64(0)))
+	for idx, session := range sessions {
+		batch.Put(sessionIndexKey(session, index), nil)
+		binary.BigEndian.PutUint64(rev[idx*binary.Size(session):], session)
+	}
+	batch.Put(sessionIndexReverseKey(index), rev)
+}
+
+// BackfillSessionIndex indexes the sending session of all log entries which
+// are not yet in the secondary index, i.e. which were stored by a version
+// without IndexSessions. Sessions which only received output cannot be
+// determined without applying the log entry. This is a no-op once the
+// database has been backfilled.
+func (s *LevelDBStore) BackfillSessionIndex() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.db.Get(sessionIndexedKey, nil); err != leveldb.ErrNotFound {
+		return err
+	}
+	log.Printf("backfilling session index of database %q", s.dir)
+	start := time.Now()
+	defer func() { log.Printf("session index backfill done in %v", time.Since(start)) }()
+	i := newOpeningIterator(s.db.NewIterator(logRange, &opt.ReadOptions{DontFillCache: true}), s.cipher)
+	defer i.Release()
+	var batch leveldb.Batch
+	for i.Next() {
+		if err := i.Error(); err != nil {
+			return err
+		}
+		l, err := raftlog.FromBytes(i.Value())
+		if err != nil {
+			return err
+		}
+		if l.Type != raft.LogCommand {
+			continue
+		}
+		msg := robust.NewMessageFromBytes(l.Data, robust.IdFromRaftIndex(l.Index))
+		if msg.Session.Id == 0 {
+			continue
+		}
+		if ok, err := s.db.Has(sessionIndexReverseKey(l.Index), nil); err != nil || ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		indexSessions(&batch, l.Index, []uint64{msg.Session.Id})
+		if batch.Len() > 100 {
+			if err := s.db.Write(&batch, s.wo); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := i.Error(); err != nil {
+		return err
+	}
+	batch.Put(sessionIndexedKey, nil)
+	return s.db.Write(&batch, s.wo)
+}
+
+// deleteSessionIndexLocked adds deletions of all secondary index entries of
+// the log entry at |index| to |batch|.
+func (s *LevelDBStore) deleteSessionIndexLocked(batch *leveldb.Batch, index uint64) error {
+	revKey := sessionIndexReverseKey(index)
+	rev, err := s.db.Get(revKey, nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	for len(rev) >= binary.Size(uint64(0)) {
+		batch.Delete(sessionIndexKey(binary.BigEndian.Uint64(rev), index))
+		rev = rev[binary.Size(uint64(0)):]
+	}
+	batch.Delete(revKey)
+	return nil
+}
+
+// SessionIndexes returns the raft indexes of all log entries which are
+// relevant for |session| (see IndexSessions), in ascending order.
+func (s *LevelDBStore) SessionIndexes(session uint64) ([]uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prefix := sessionIndexKey(session, 0)
+	prefix = prefix[:len(prefix)-binary.Size(uint64(0))]
+	i := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer i.Release()
+	var indexes []uint64
+	for i.Next() {
+		indexes = append(indexes, binary.BigEndian.Uint64(i.Key()[len(prefix):]))
+	}
+	return indexes, i.Error()
 }
 
 // Set implements raft.StableStore.
 func (s *LevelDBStore) Set(key []byte, val []byte) error {
 	key = append([]byte("stablestore-"), key...)
-	return s.db.Put(key, val, nil)
+	return s.db.Put(key, val, s.wo)
 }
 
 // Get implements raft.StableStore.
@@ -356,7 +779,7 @@ func (s *LevelDBStore) SetUint64(key []byte, val uint64) error {
 	v := make([]byte, binary.Size(val))
 	binary.BigEndian.PutUint64(v, val)
 
-	return s.db.Put(key, v, nil)
+	return s.db.Put(key, v, s.wo)
 }
 
 // GetUint64 implements raft.StableStore.
@@ -0,0 +1,623 @@
+package raftstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/robustirc/robustirc/internal/encryption"
+	"github.com/robustirc/robustirc/internal/robust"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestSessionIndex(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "robustirc-raftstore-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	s, err := NewLevelDBStore(tempdir, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for index := uint64(1); index <= 4; index++ {
+		if err := s.StoreLog(&raft.Log{Index: index, Type: raft.LogCommand}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetUint64([]byte("CurrentTerm"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.IndexSessions(1, []uint64{23}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.IndexSessions(2, []uint64{23, 42}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.IndexSessions(4, []uint64{42}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The secondary index must not be mistaken for log entries.
+	first, err := s.FirstIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := first, uint64(1); got != want {
+		t.Fatalf("FirstIndex() = %d, want %d", got, want)
+	}
+	last, err := s.LastIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := last, uint64(4); got != want {
+		t.Fatalf("LastIndex() = %d, want %d", got, want)
+	}
+
+	for session, want := range map[uint64][]uint64{
+		23: {1, 2},
+		42: {2, 4},
+		5:  nil,
+	} {
+		got, err := s.SessionIndexes(session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("SessionIndexes(%d) = %v, want %v", session, got, want)
+		}
+	}
+
+	// Deleting log entries (e.g. during compaction) must delete the
+	// corresponding secondary index entries.
+	if err := s.DeleteRange(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.SessionIndexes(23)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) > 0 {
+		t.Fatalf("SessionIndexes(23) = %v after DeleteRange, want []", got)
+	}
+	got, err = s.SessionIndexes(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("SessionIndexes(42) = %v after DeleteRange, want %v", got, want)
+	}
+}
+
+func TestBackfillSessionIndex(t *testing.T) {
+	s, err := NewMemoryStore(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	storeMessage := func(index, session uint64) {
+		data, err := json.Marshal(&robust.Message{
+			Session: robust.Id{Id: session},
+			Type:    robust.IRCFromClient,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.StoreLog(&raft.Log{Index: index, Type: raft.LogCommand, Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storeMessage(1, 23)
+	storeMessage(2, 42)
+	storeMessage(3, 42)
+	// Already indexed when it was applied, including output for session 23.
+	if err := s.IndexSessions(3, []uint64{23, 42}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.BackfillSessionIndex(); err != nil {
+		t.Fatal(err)
+	}
+	// Stored after the backfill, but not indexed: the backfill must not run
+	// again.
+	storeMessage(4, 23)
+	if err := s.BackfillSessionIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	for session, want := range map[uint64][]uint64{
+		23: {1, 3},
+		42: {2, 3},
+	} {
+		got, err := s.SessionIndexes(session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("SessionIndexes(%d) = %v, want %v", session, got, want)
+		}
+	}
+
+	report, err := s.Fsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.UnknownKeys != 0 {
+		t.Fatalf("Fsck() found %d unknown keys, want 0", report.UnknownKeys)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "robustirc-raftstore-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	s, err := NewLevelDBStore(tempdir, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for index := uint64(1); index <= 4; index++ {
+		if err := s.StoreLog(&raft.Log{Index: index, Type: raft.LogCommand}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.IndexSessions(3, []uint64{23}); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := s.GetSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Release()
+
+	// Neither new nor deleted log entries are visible in the snapshot.
+	if err := s.StoreLog(&raft.Log{Index: 5, Type: raft.LogCommand}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteRange(2, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	iterator := snap.LogIterator(2)
+	defer iterator.Release()
+	var got []uint64
+	for iterator.Next() {
+		got = append(got, binary.BigEndian.Uint64(iterator.Key()))
+	}
+	if err := iterator.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("snapshot log entries = %v, want %v", got, want)
+	}
+}
+
+func TestIterate(t *testing.T) {
+	s, err := NewMemoryStore(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for index := uint64(1); index <= 6; index++ {
+		if err := s.StoreLog(&raft.Log{Index: index, Type: raft.LogCommand}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.IndexSessions(3, []uint64{23}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteRange(4, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []uint64
+	if err := s.Iterate(2, 5, func(l *raft.Log) error {
+		got = append(got, l.Index)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{2, 3, 5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Iterate(2, 5): got %v, want %v", got, want)
+	}
+
+	errStop := errors.New("stop")
+	got = nil
+	if err := s.Iterate(1, 6, func(l *raft.Log) error {
+		got = append(got, l.Index)
+		if l.Index == 2 {
+			return errStop
+		}
+		return nil
+	}); err != errStop {
+		t.Fatalf("Iterate(1, 6): got error %v, want %v", err, errStop)
+	}
+	if want := []uint64{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Iterate(1, 6): got %v, want %v", got, want)
+	}
+}
+
+func TestCache(t *testing.T) {
+	s, err := NewMemoryStore(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.EnableCache(2); err != nil {
+		t.Fatal(err)
+	}
+	hits := getLogCacheHits.WithLabelValues(s.name())
+	misses := getLogCacheMisses.WithLabelValues(s.name())
+	hitsBefore, missesBefore := testutil.ToFloat64(hits), testutil.ToFloat64(misses)
+
+	for index := uint64(1); index <= 3; index++ {
+		if err := s.StoreLog(&raft.Log{Index: index, Type: raft.LogCommand, Data: []byte{byte(index)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var l raft.Log
+	// Entry 3 was stored most recently, entry 1 was evicted.
+	for _, index := range []uint64{3, 1, 1} {
+		if err := s.GetLog(index, &l); err != nil {
+			t.Fatal(err)
+		}
+		if l.Index != index || !reflect.DeepEqual(l.Data, []byte{byte(index)}) {
+			t.Fatalf("GetLog(%d): got %+v", index, l)
+		}
+	}
+	if got, want := testutil.ToFloat64(hits)-hitsBefore, float64(2); got != want {
+		t.Fatalf("unexpected number of cache hits: got %v, want %v", got, want)
+	}
+	if got, want := testutil.ToFloat64(misses)-missesBefore, float64(1); got != want {
+		t.Fatalf("unexpected number of cache misses: got %v, want %v", got, want)
+	}
+
+	if err := s.DeleteRange(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GetLog(1, &l); err != raft.ErrLogNotFound {
+		t.Fatalf("GetLog(1) after DeleteRange: got error %v, want %v", err, raft.ErrLogNotFound)
+	}
+}
+
+func TestCompactAfterDelete(t *testing.T) {
+	s, err := NewMemoryStore(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.EnableCompaction(time.Hour)
+
+	for index := uint64(1); index <= 10; index++ {
+		if err := s.StoreLog(&raft.Log{Index: index, Type: raft.LogCommand}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.DeleteRange(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	s.compactWg.Wait()
+	if s.compactLast.IsZero() || s.compactPending {
+		t.Fatalf("DeleteRange did not compact the deleted entries")
+	}
+
+	// The next deletion is rate-limited and remembered for later.
+	if err := s.DeleteRange(4, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteRange(7, 8); err != nil {
+		t.Fatal(err)
+	}
+	s.compactWg.Wait()
+	if !s.compactPending || s.compactMin != 4 || s.compactMax != 8 {
+		t.Fatalf("unexpected pending compaction: pending = %v, span = [%d, %d], want [4, 8]",
+			s.compactPending, s.compactMin, s.compactMax)
+	}
+
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	first, err := s.FirstIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != 6 {
+		t.Fatalf("FirstIndex() after compaction: got %d, want 6", first)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{0x23}, encryption.KeySize)
+	c, err := encryption.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewMemoryStore(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	secret := []byte("PRIVMSG alice :secret")
+	// Entry 1 is stored before encryption was enabled.
+	if err := s.StoreLog(&raft.Log{Index: 1, Type: raft.LogCommand, Data: secret}); err != nil {
+		t.Fatal(err)
+	}
+	s.EnableEncryption(c)
+	if err := s.StoreLog(&raft.Log{Index: 2, Type: raft.LogCommand, Data: secret}); err != nil {
+		t.Fatal(err)
+	}
+	var batch leveldb.Batch
+	batch.Put([]byte{0, 0, 0, 0, 0, 0, 0, 3}, []byte(`{"Index":3,"Type":0,"Data":"c2VjcmV0"}`))
+	if err := s.WriteBatch(&batch); err != nil {
+		t.Fatal(err)
+	}
+
+	for index := uint64(2); index <= 3; index++ {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, index)
+		raw, err := s.db.Get(key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw[0] != encryptedPrefix || bytes.Contains(raw, []byte("secret")) || bytes.Contains(raw, []byte("c2VjcmV0")) {
+			t.Fatalf("log entry %d stored in plaintext: %q", index, raw)
+		}
+	}
+
+	var got []uint64
+	if err := s.Iterate(1, 3, func(l *raft.Log) error {
+		got = append(got, l.Index)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Iterate(1, 3): got %v, want %v", got, want)
+	}
+	var l raft.Log
+	if err := s.GetLog(2, &l); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(l.Data, secret) {
+		t.Fatalf("GetLog(2): got data %q, want %q", l.Data, secret)
+	}
+
+	// Reading with the wrong key must fail instead of returning garbage.
+	wrong, err := encryption.New(bytes.Repeat([]byte{0x42}, encryption.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.EnableEncryption(wrong)
+	if err := s.GetLog(2, &l); err == nil {
+		t.Fatalf("GetLog(2) with the wrong key unexpectedly succeeded")
+	}
+	i := s.GetBulkIterator(2, 3)
+	defer i.Release()
+	if !i.First() || i.Error() == nil {
+		t.Fatalf("GetBulkIterator with the wrong key: got error %v, want decryption error", i.Error())
+	}
+}
+
+func TestOptions(t *testing.T) {
+	dir := t.TempDir()
+	o := Options{Sync: true, WriteBuffer: 1 << 20, NoCompression: true}
+	s, err := NewLevelDBStoreWithOptions(dir, false, true, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.wo.Sync {
+		t.Fatalf("Options.Sync not used for writes")
+	}
+	if err := s.StoreLog(&raft.Log{Index: 1, Type: raft.LogCommand, Data: []byte("foo")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// useProtobuf is false to skip ConvertToProto, which expects robust
+	// messages as data.
+	s, err = NewLevelDBStore(dir, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.wo.Sync {
+		t.Fatalf("NewLevelDBStore unexpectedly uses synchronous writes")
+	}
+	var l raft.Log
+	if err := s.GetLog(1, &l); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(l.Data), "foo"; got != want {
+		t.Fatalf("GetLog(1): got data %q, want %q", got, want)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	s, err := NewMemoryStore(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for index := uint64(1); index <= 2500; index++ {
+		if err := s.StoreLog(&raft.Log{Index: index, Type: raft.LogCommand, Data: []byte("foo")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetUint64([]byte("CurrentTerm"), 42); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "raftlog")
+	if err := s.Backup(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Backup(dir); err == nil {
+		t.Fatalf("Backup() unexpectedly overwrote an existing database")
+	}
+
+	b, err := NewLevelDBStore(dir, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	last, err := b.LastIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != 2500 {
+		t.Fatalf("LastIndex() of backup: got %d, want 2500", last)
+	}
+	term, err := b.GetUint64([]byte("CurrentTerm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if term != 42 {
+		t.Fatalf("GetUint64(CurrentTerm) of backup: got %d, want 42", term)
+	}
+}
+
+func TestFsck(t *testing.T) {
+	s, err := NewMemoryStore(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, index := range []uint64{1, 2, 3, 5, 6} {
+		if err := s.StoreLog(&raft.Log{Index: index, Type: raft.LogCommand}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.IndexSessions(index, []uint64{23}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetUint64([]byte("CurrentTerm"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.Fsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Fatalf("Fsck() on a healthy store: %v", report)
+	}
+	if want := [][2]uint64{{4, 4}}; !reflect.DeepEqual(report.Gaps, want) {
+		t.Fatalf("Fsck(): got gaps %v, want %v", report.Gaps, want)
+	}
+
+	// Corrupt entry 2 and store entry 6 under the key of index 7.
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, 2)
+	if err := s.db.Put(key, []byte("pgarbage"), nil); err != nil {
+		t.Fatal(err)
+	}
+	value, err := s.db.Get([]byte{0, 0, 0, 0, 0, 0, 0, 6}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.Put([]byte{0, 0, 0, 0, 0, 0, 0, 7}, value, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = s.Fsck(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{2, 7}; !reflect.DeepEqual(report.Corrupted, want) {
+		t.Fatalf("Fsck(): got corrupted entries %v, want %v", report.Corrupted, want)
+	}
+	// Forward and reverse session index entries of index 2.
+	if got, want := report.DanglingIndex, 2; got != want {
+		t.Fatalf("Fsck(): got %d dangling session index entries, want %d", got, want)
+	}
+	if !report.Repaired {
+		t.Fatalf("Fsck(true) did not repair the store")
+	}
+
+	report, err = s.Fsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Fatalf("Fsck() after repairing: %v", report)
+	}
+	indexes, err := s.SessionIndexes(23)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{1, 3, 5, 6}; !reflect.DeepEqual(indexes, want) {
+		t.Fatalf("SessionIndexes(23) after repairing: got %v, want %v", indexes, want)
+	}
+}
+
+func TestStoreMetrics(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "robustirc-raftstore-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	s, err := NewLevelDBStore(tempdir, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.EnableMetrics()
+
+	if err := s.StoreLogs([]*raft.Log{
+		{Index: 1, Type: raft.LogCommand},
+		{Index: 2, Type: raft.LogCommand},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.IndexSessions(2, []uint64{23}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetUint64([]byte("CurrentTerm"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	kinds, err := s.countKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{
+		"log":          2,
+		"sessionindex": 2, // forward and reverse entry
+		"stablestore":  1,
+		"other":        0,
+	}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("countKeys(): got %v, want %v", kinds, want)
+	}
+
+	if _, _, err := s.writeDelay(); err != nil {
+		t.Fatalf("writeDelay(): %v", err)
+	}
+	if size, err := s.sizeOnDisk(); err != nil || size == 0 {
+		t.Fatalf("sizeOnDisk(): got (%d, %v), want (> 0, nil)", size, err)
+	}
+}
@@ -0,0 +1,240 @@
+package raftstore
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// keyCountInterval is the minimum time between two key counts of the same
+// store. Counting keys reads the entire database, so it is not done on every
+// scrape.
+const keyCountInterval = 1 * time.Minute
+
+var (
+	readLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "raftstore",
+			Name:      "read_latency_seconds",
+			Help:      "How long reading a log entry from LevelDB took (GetLog cache misses)",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+		},
+		[]string{"store"},
+	)
+
+	writeLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "raftstore",
+			Name:      "write_latency_seconds",
+			Help:      "How long writing a batch to LevelDB took (StoreLogs, WriteBatch and DeleteRange)",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+		},
+		[]string{"store"},
+	)
+
+	writeBatchSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "raftstore",
+			Name:      "write_batch_size",
+			Help:      "Number of keys written (or deleted) per LevelDB batch",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		},
+		[]string{"store"},
+	)
+
+	sizeDesc = prometheus.NewDesc(
+		"raftstore_size_bytes",
+		"Size of the LevelDB directory on disk",
+		[]string{"store"}, nil)
+
+	keysDesc = prometheus.NewDesc(
+		"raftstore_keys",
+		"Number of keys in LevelDB by kind (log, sessionindex, stablestore, other), counted at most once per minute",
+		[]string{"store", "kind"}, nil)
+
+	writeDelaysDesc = prometheus.NewDesc(
+		"raftstore_write_delays_total",
+		"Number of writes which LevelDB delayed because compaction could not keep up",
+		[]string{"store"}, nil)
+
+	writeDelayDesc = prometheus.NewDesc(
+		"raftstore_write_delay_seconds_total",
+		"Total time writes were delayed because compaction could not keep up",
+		[]string{"store"}, nil)
+
+	// stores contains all LevelDBStores for which EnableMetrics was called.
+	stores = &storeCollector{stores: make(map[string]*LevelDBStore)}
+)
+
+func init() {
+	prometheus.MustRegister(readLatency)
+	prometheus.MustRegister(writeLatency)
+	prometheus.MustRegister(writeBatchSize)
+	prometheus.MustRegister(stores)
+}
+
+// keyCounts is the result of LevelDBStore.countKeys.
+type keyCounts struct {
+	counted time.Time
+	kinds   map[string]int
+}
+
+// EnableMetrics exports the size on disk, the number of keys and the write
+// delays of the LevelDBStore to prometheus until it is closed. A later
+// LevelDBStore with the same name (e.g. after re-opening the database)
+// replaces it.
+func (s *LevelDBStore) EnableMetrics() {
+	stores.mu.Lock()
+	defer stores.mu.Unlock()
+	stores.stores[s.name()] = s
+}
+
+// disableMetrics undoes EnableMetrics.
+func (s *LevelDBStore) disableMetrics() {
+	stores.mu.Lock()
+	defer stores.mu.Unlock()
+	if stores.stores[s.name()] == s {
+		delete(stores.stores, s.name())
+	}
+}
+
+// countKeys returns the number of keys by kind, re-using the previous result
+// if it is younger than keyCountInterval.
+func (s *LevelDBStore) countKeys() (map[string]int, error) {
+	s.keyCountsMu.Lock()
+	defer s.keyCountsMu.Unlock()
+	if time.Since(s.keyCounts.counted) < keyCountInterval {
+		return s.keyCounts.kinds, nil
+	}
+
+	snap, err := s.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+	i := snap.snap.NewIterator(nil, &opt.ReadOptions{DontFillCache: true})
+	defer i.Release()
+	kinds := map[string]int{
+		"log":          0,
+		"sessionindex": 0,
+		"stablestore":  0,
+		"other":        0,
+	}
+	for i.Next() {
+		key := i.Key()
+		switch {
+		case IsLogKey(key):
+			kinds["log"]++
+		case bytes.HasPrefix(key, sessionIndexPrefix),
+			bytes.HasPrefix(key, sessionIndexReversePrefix):
+			kinds["sessionindex"]++
+		case bytes.HasPrefix(key, []byte("stablestore-")):
+			kinds["stablestore"]++
+		default:
+			kinds["other"]++
+		}
+	}
+	if err := i.Error(); err != nil {
+		return nil, err
+	}
+	s.keyCounts = keyCounts{counted: time.Now(), kinds: kinds}
+	return kinds, nil
+}
+
+// sizeOnDisk returns the total size of the files in the database directory.
+func (s *LevelDBStore) sizeOnDisk() (int64, error) {
+	var size int64
+	err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// writeDelay returns how often and for how long LevelDB delayed writes
+// because level 0 had too many tables, i.e. compaction could not keep up.
+func (s *LevelDBStore) writeDelay() (int, time.Duration, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.db == nil {
+		return 0, 0, leveldb.ErrClosed
+	}
+	prop, err := s.db.GetProperty("leveldb.writedelay")
+	if err != nil {
+		return 0, 0, err
+	}
+	var (
+		n     int
+		delay string
+	)
+	if _, err := fmt.Sscanf(prop, "DelayN:%d Delay:%s", &n, &delay); err != nil {
+		return 0, 0, fmt.Errorf("parsing %q: %v", prop, err)
+	}
+	d, err := time.ParseDuration(delay)
+	if err != nil {
+		return 0, 0, err
+	}
+	return n, d, nil
+}
+
+// storeCollector is a prometheus.Collector exporting metrics which are read
+// from the LevelDBStores on every scrape.
+type storeCollector struct {
+	mu     sync.Mutex
+	stores map[string]*LevelDBStore
+}
+
+// Describe implements prometheus.Collector.
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sizeDesc
+	ch <- keysDesc
+	ch <- writeDelaysDesc
+	ch <- writeDelayDesc
+}
+
+// open returns the LevelDBStores for which EnableMetrics was called.
+func (c *storeCollector) open() []*LevelDBStore {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	open := make([]*LevelDBStore, 0, len(c.stores))
+	for _, s := range c.stores {
+		open = append(open, s)
+	}
+	return open
+}
+
+// Collect implements prometheus.Collector.
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.open() {
+		name := s.name()
+		if size, err := s.sizeOnDisk(); err == nil {
+			ch <- prometheus.MustNewConstMetric(sizeDesc, prometheus.GaugeValue, float64(size), name)
+		}
+		if kinds, err := s.countKeys(); err != nil {
+			if err != leveldb.ErrClosed {
+				log.Printf("Could not count keys of %q: %v", s.dir, err)
+			}
+		} else {
+			for kind, n := range kinds {
+				ch <- prometheus.MustNewConstMetric(keysDesc, prometheus.GaugeValue, float64(n), name, kind)
+			}
+		}
+		if n, d, err := s.writeDelay(); err == nil {
+			ch <- prometheus.MustNewConstMetric(writeDelaysDesc, prometheus.CounterValue, float64(n), name)
+			ch <- prometheus.MustNewConstMetric(writeDelayDesc, prometheus.CounterValue, d.Seconds(), name)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Package resolver looks up the reverse DNS names of client addresses.
+//
+// Lookups must not happen within the raft state machine: DNS answers differ
+// between nodes and over time, which would break determinism, and a slow DNS
+// server would delay applying all messages. Instead, the raft leader resolves
+// addresses using this package and records the result in a follow-up
+// robust.ResolvedHostname log entry.
+package resolver
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCacheEntries bounds the memory usage of the cache. When it is reached,
+// expired entries are removed, and if that does not suffice, the cache is
+// cleared.
+const maxCacheEntries = 10000
+
+// maxHostnameLen is the maximum length of hostnames used in IRC prefixes.
+// Longer names are rejected, as they would cut into the maximum IRC line
+// length.
+const maxHostnameLen = 63
+
+type cacheEntry struct {
+	hostname string
+	expires  time.Time
+}
+
+// Resolver resolves addresses to hostnames, caching the results (including
+// failed lookups).
+type Resolver struct {
+	timeout time.Duration
+	ttl     time.Duration
+
+	// lookupAddr and lookupHost are net.Resolver methods, overridden in
+	// tests.
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+// New returns a Resolver whose lookups take at most |timeout| and whose
+// results are cached for |ttl|.
+func New(timeout, ttl time.Duration) *Resolver {
+	return &Resolver{
+		timeout:    timeout,
+		ttl:        ttl,
+		lookupAddr: net.DefaultResolver.LookupAddr,
+		lookupHost: net.DefaultResolver.LookupHost,
+		cache:      make(map[string]cacheEntry),
+	}
+}
+
+// validHostname returns whether |name| can be used as the host part of an
+// IRC prefix.
+func validHostname(name string) bool {
+	if name == "" || len(name) > maxHostnameLen {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' ||
+			r >= 'A' && r <= 'Z' ||
+			r >= '0' && r <= '9' ||
+			r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup returns the first name of |addr| which resolves back to |addr|
+// (forward-confirmed reverse DNS), so that clients cannot claim arbitrary
+// hostnames by controlling the reverse DNS zone of their address.
+func (r *Resolver) lookup(ctx context.Context, addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	names, err := r.lookupAddr(ctx, addr)
+	if err != nil {
+		return ""
+	}
+	for _, name := range names {
+		name = strings.TrimSuffix(name, ".")
+		if !validHostname(name) {
+			continue
+		}
+		addrs, err := r.lookupHost(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ip.Equal(net.ParseIP(a)) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+func (r *Resolver) cached(addr string, now time.Time) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.cache[addr]
+	if !ok || !now.Before(entry.expires) {
+		return "", false
+	}
+	return entry.hostname, true
+}
+
+func (r *Resolver) store(addr, hostname string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.cache) >= maxCacheEntries {
+		for key, entry := range r.cache {
+			if !now.Before(entry.expires) {
+				delete(r.cache, key)
+			}
+		}
+		if len(r.cache) >= maxCacheEntries {
+			r.cache = make(map[string]cacheEntry)
+		}
+	}
+	r.cache[addr] = cacheEntry{
+		hostname: hostname,
+		expires:  now.Add(r.ttl),
+	}
+}
+
+// Lookup returns the hostname of |addr|, or the empty string if |addr| has
+// no (forward-confirmed) hostname or the lookup did not finish in time.
+func (r *Resolver) Lookup(addr string) string {
+	now := time.Now()
+	if hostname, ok := r.cached(addr, now); ok {
+		return hostname
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	hostname := r.lookup(ctx, addr)
+	r.store(addr, hostname, now)
+	return hostname
+}
@@ -0,0 +1,67 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func fakeResolver(ptr map[string][]string, hosts map[string][]string) (*Resolver, *int) {
+	r := New(time.Second, time.Minute)
+	lookups := 0
+	r.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		lookups++
+		if names, ok := ptr[addr]; ok {
+			return names, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	r.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		if addrs, ok := hosts[host]; ok {
+			return addrs, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	return r, &lookups
+}
+
+func TestLookup(t *testing.T) {
+	r, lookups := fakeResolver(map[string][]string{
+		"192.0.2.1":   {"client.example.net."},
+		"192.0.2.2":   {"spoofed.example.com."},
+		"192.0.2.3":   {"in valid.example.net."},
+		"2001:db8::1": {"bogus.example.org.", "v6.example.net."},
+	}, map[string][]string{
+		"client.example.net":  {"192.0.2.1"},
+		"spoofed.example.com": {"198.51.100.1"},
+		"v6.example.net":      {"2001:db8:0::1"},
+	})
+
+	for _, tt := range []struct {
+		addr string
+		want string
+	}{
+		{"192.0.2.1", "client.example.net"},
+		{"192.0.2.2", ""}, // not forward-confirmed
+		{"192.0.2.3", ""}, // invalid characters
+		{"192.0.2.4", ""}, // no PTR record
+		{"2001:db8::1", "v6.example.net"},
+		{"not-an-address", ""},
+	} {
+		if got := r.Lookup(tt.addr); got != tt.want {
+			t.Errorf("Lookup(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+
+	before := *lookups
+	if got, want := r.Lookup("192.0.2.1"), "client.example.net"; got != want {
+		t.Fatalf("Lookup(%q) = %q, want %q", "192.0.2.1", got, want)
+	}
+	if got, want := r.Lookup("192.0.2.4"), ""; got != want {
+		t.Fatalf("Lookup(%q) = %q, want %q", "192.0.2.4", got, want)
+	}
+	if *lookups != before {
+		t.Fatalf("cached lookups hit DNS: %d lookups, want %d", *lookups, before)
+	}
+}
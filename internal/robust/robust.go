@@ -4,11 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang/protobuf/proto"
-	"gopkg.in/sorcix/irc.v2"
 
 	pb "github.com/robustirc/robustirc/internal/proto"
 )
@@ -35,6 +35,35 @@ func (i *Id) String() string {
 	return fmt.Sprintf("%d.%d", i.Id, i.Reply)
 }
 
+// ParseId parses an Id as formatted by (*Id).String, i.e. “<id>.<reply>”.
+// Both parts are parsed like strconv.ParseUint with base 0, i.e. 0x prefixes
+// work.
+func ParseId(s string) (Id, error) {
+	idstr, replystr, ok := strings.Cut(s, ".")
+	if !ok {
+		return Id{}, fmt.Errorf("invalid id %q: expected <id>.<reply>", s)
+	}
+	id, err := ParseSessionId(idstr)
+	if err != nil {
+		return Id{}, fmt.Errorf("invalid id %q: %v", s, err)
+	}
+	id.Reply, err = strconv.ParseUint(replystr, 0, 64)
+	if err != nil {
+		return Id{}, fmt.Errorf("invalid id %q: %v", s, err)
+	}
+	return id, nil
+}
+
+// ParseSessionId parses a session id, i.e. an Id without reply part, like
+// strconv.ParseUint with base 0.
+func ParseSessionId(s string) (Id, error) {
+	id, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return Id{}, fmt.Errorf("invalid session id %q: %v", s, err)
+	}
+	return Id{Id: id}, nil
+}
+
 type Type int64
 
 const (
@@ -45,8 +74,21 @@ const (
 	Ping
 	MessageOfDeath
 	Config
+	// State is the first entry of every snapshot and contains the
+	// serialized IRCServer state (sessions with their nick, user,
+	// channels and modes) of all compacted log entries, so that
+	// sessions do not need to retain their NICK/USER/JOIN prologue.
 	State
 	Any
+	ChannelImport
+	ResolvedHostname
+	ExpireKline
+	// RehashFailed reports that the raft leader could not fulfill a
+	// REHASH request. Data contains the error message.
+	RehashFailed
+	// SnapshotSummary is the first entry of snapshots and contains the
+	// JSON-encoded snapshotmeta.Summary. It is never part of the raft log.
+	SnapshotSummary
 )
 
 func (t Type) String() string {
@@ -69,6 +111,16 @@ func (t Type) String() string {
 		return "state"
 	case Any:
 		return "any"
+	case ChannelImport:
+		return "channel_import"
+	case ResolvedHostname:
+		return "resolved_hostname"
+	case ExpireKline:
+		return "expire_kline"
+	case RehashFailed:
+		return "rehash_failed"
+	case SnapshotSummary:
+		return "snapshot_summary"
 	default:
 		log.Panicf("(robust.Type).String() not updated for type %d", t)
 	}
@@ -107,8 +159,33 @@ type Message struct {
 	// robust.Config
 	Revision uint64 `json:",omitempty"`
 
+	// Target and Seq are only present when Type == robust.IRCToClient and
+	// the message was sent to a channel. Seq is the per-Target sequence
+	// number, which strictly increases in raft commit order on all servers,
+	// so clients can detect reordering when switching servers. Seq has gaps
+	// for messages which were not interesting for the client and starts
+	// over when a channel is re-created.
+	Target string `json:",omitempty"`
+	Seq    uint64 `json:",omitempty"`
+
 	// RemoteAddr is the network address that sent the request.
 	RemoteAddr string `json:",omitempty"`
+
+	// CertFingerprint is the SHA-256 fingerprint of the TLS client
+	// certificate which the client presented, if any. Only present when
+	// Type == robust.IRCFromClient.
+	CertFingerprint string `json:",omitempty"`
+
+	// Origin is the address of the server which accepted the message and
+	// applied it to raft. Not set for messages which were created before
+	// Origin was introduced, nor for messages which the IRC server
+	// generates.
+	Origin string `json:",omitempty"`
+
+	// Transform is the name of the transform which modified the text of
+	// this channel message (see config.Network.ChannelTransforms). Only
+	// present when Type == robust.IRCToClient.
+	Transform string `json:",omitempty"`
 }
 
 func (m *Message) Timestamp() time.Time {
@@ -123,25 +200,6 @@ func (m *Message) TimestampString() string {
 	return m.Timestamp().Format("2006-01-02 15:04:05 -07:00")
 }
 
-func (m *Message) PrivacyFilter() string {
-	if m.Type != IRCToClient && m.Type != IRCFromClient {
-		return m.Data
-	}
-	if msg := irc.ParseMessage(m.Data); msg != nil {
-		command := strings.ToUpper(msg.Command)
-		if command == irc.PRIVMSG ||
-			command == irc.NOTICE ||
-			command == irc.PASS ||
-			strings.HasSuffix(command, "serv") {
-			if len(msg.Params) > 0 {
-				msg.Params[len(msg.Params)-1] = "<privacy filtered>"
-			}
-			return string(msg.Bytes())
-		}
-	}
-	return m.Data
-}
-
 func (m *Message) ProtoMessage() *pb.RobustMessage {
 	return &pb.RobustMessage{
 		Id: &pb.RobustId{
@@ -160,6 +218,8 @@ func (m *Message) ProtoMessage() *pb.RobustMessage {
 		ClientMessageId: m.ClientMessageId,
 		Revision:        m.Revision,
 		RemoteAddr:      m.RemoteAddr,
+		Origin:          m.Origin,
+		CertFingerprint: m.CertFingerprint,
 	}
 }
 
@@ -178,6 +238,8 @@ func (m *Message) CopyToProtoMessage(dst *pb.RobustMessage) {
 	dst.ClientMessageId = m.ClientMessageId
 	dst.Revision = m.Revision
 	dst.RemoteAddr = m.RemoteAddr
+	dst.Origin = m.Origin
+	dst.CertFingerprint = m.CertFingerprint
 }
 
 func NewMessageFromBytes(b []byte, index uint64) Message {
@@ -199,6 +261,8 @@ func NewMessageFromBytes(b []byte, index uint64) Message {
 		msg.ClientMessageId = p.ClientMessageId
 		msg.Revision = p.Revision
 		msg.RemoteAddr = p.RemoteAddr
+		msg.Origin = p.Origin
+		msg.CertFingerprint = p.CertFingerprint
 	} else {
 		if err := json.Unmarshal(b, &msg); err != nil {
 			log.Panicf("Could not json.Unmarshal() a (supposed) robust.Message (%v): %v\n", b, err)
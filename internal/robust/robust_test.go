@@ -131,3 +131,96 @@ func BenchmarkEncodeProtoBufferCopyTo(b *testing.B) {
 		}
 	}
 }
+
+func TestParseId(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		want    robust.Id
+		wantErr bool
+	}{
+		{in: "1234.5", want: robust.Id{Id: 1234, Reply: 5}},
+		{in: "0x40826d776b433c17.3", want: robust.Id{Id: 0x40826d776b433c17, Reply: 3}},
+		{in: "1234.0", want: robust.Id{Id: 1234}},
+		{in: "1234", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "foo", wantErr: true},
+		{in: "1.", wantErr: true},
+		{in: ".1", wantErr: true},
+		{in: "123.abc", wantErr: true},
+		{in: "abc.123", wantErr: true},
+		{in: "123.4.5", wantErr: true},
+		{in: "-1.2", wantErr: true},
+		{in: "1.-2", wantErr: true},
+	} {
+		got, err := robust.ParseId(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseId(%q): got err %v, want err %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseId(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	id := robust.Id{Id: 0x40826d776b433c17, Reply: 3}
+	got, err := robust.ParseId(id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != id {
+		t.Errorf("ParseId(%q) = %v, want %v", id.String(), got, id)
+	}
+}
+
+func TestParseSessionId(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		want    robust.Id
+		wantErr bool
+	}{
+		{in: "1234", want: robust.Id{Id: 1234}},
+		{in: "0x40826d776b433c17", want: robust.Id{Id: 0x40826d776b433c17}},
+		{in: "", wantErr: true},
+		{in: "foo", wantErr: true},
+		{in: "1234.5", wantErr: true},
+		{in: "1234.", wantErr: true},
+		{in: "123.abc", wantErr: true},
+		{in: "-1", wantErr: true},
+	} {
+		got, err := robust.ParseSessionId(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSessionId(%q): got err %v, want err %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSessionId(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestOriginRoundTrip(t *testing.T) {
+	msg := robust.Message{
+		Id:      robust.Id{Id: 0x40826d776b433c17, Reply: 3},
+		Session: robust.Id{Id: 0x40826d776b433c17},
+		Type:    robust.IRCFromClient,
+		Data:    "JOIN #robustirc",
+		Origin:  "localhost:13001",
+	}
+	b, err := proto.Marshal(msg.ProtoMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := robust.NewMessageFromBytes(append([]byte{'p'}, b...), 0)
+	if got.Origin != msg.Origin {
+		t.Errorf("proto: got Origin %q, want %q", got.Origin, msg.Origin)
+	}
+
+	b, err = json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = robust.NewMessageFromBytes(b, 0)
+	if got.Origin != msg.Origin {
+		t.Errorf("json: got Origin %q, want %q", got.Origin, msg.Origin)
+	}
+}
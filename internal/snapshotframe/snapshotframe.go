@@ -0,0 +1,178 @@
+// Package snapshotframe implements the checksummed framing of protobuf
+// snapshots, which allows detecting truncated or corrupted snapshots before
+// restoring them results in partial state.
+//
+// A checksummed snapshot starts with Magic, followed by records and a
+// manifest:
+//
+//	record:   uint64 length | payload | uint32 CRC-32C of payload
+//	manifest: uint64 manifestMarker | uint64 number of records |
+//	          uint32 CRC-32C of all payloads
+//
+// All integers are big endian. The manifest is the last part of the stream.
+package snapshotframe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// Magic is the first byte of a checksummed snapshot. Unchecksummed protobuf
+// snapshots start with 'p', JSON snapshots with '{'.
+const Magic = 'c'
+
+// manifestMarker takes the place of the record length to introduce the
+// manifest. Records cannot be this large.
+const manifestMarker = math.MaxUint64
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	// ErrTruncated is returned when the snapshot ends before its manifest.
+	ErrTruncated = errors.New("snapshot truncated: manifest missing")
+
+	// ErrChecksum is returned when a record or the manifest does not match
+	// the data which was read.
+	ErrChecksum = errors.New("snapshot corrupted: checksum mismatch")
+)
+
+// Writer writes a checksummed snapshot.
+type Writer struct {
+	w       io.Writer
+	records uint64
+	crc     uint32
+}
+
+// NewWriter writes Magic to |w| and returns a Writer for the records. Close
+// must be called after the last record to write the manifest.
+func NewWriter(w io.Writer) (*Writer, error) {
+	if _, err := w.Write([]byte{Magic}); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// WriteRecord writes the concatenation of |parts| as one record and returns
+// the number of bytes written, including the framing.
+func (w *Writer) WriteRecord(parts ...[]byte) (n int, err error) {
+	var total int
+	for _, part := range parts {
+		total += len(part)
+	}
+	var lenbuf [8]byte // binary.Size(uint64(0))
+	binary.BigEndian.PutUint64(lenbuf[:], uint64(total))
+	n, err = w.w.Write(lenbuf[:])
+	if err != nil {
+		return n, err
+	}
+	var crc uint32
+	for _, part := range parts {
+		crc = crc32.Update(crc, castagnoli, part)
+		w.crc = crc32.Update(w.crc, castagnoli, part)
+		nPart, err := w.w.Write(part)
+		n += nPart
+		if err != nil {
+			return n, err
+		}
+	}
+	var crcbuf [4]byte
+	binary.BigEndian.PutUint32(crcbuf[:], crc)
+	nCRC, err := w.w.Write(crcbuf[:])
+	n += nCRC
+	if err != nil {
+		return n, err
+	}
+	w.records++
+	return n, nil
+}
+
+// Close writes the manifest. It does not close the underlying io.Writer.
+func (w *Writer) Close() error {
+	var manifest [8 + 8 + 4]byte
+	binary.BigEndian.PutUint64(manifest[0:8], manifestMarker)
+	binary.BigEndian.PutUint64(manifest[8:16], w.records)
+	binary.BigEndian.PutUint32(manifest[16:20], w.crc)
+	_, err := w.w.Write(manifest[:])
+	return err
+}
+
+// Reader reads a checksummed snapshot.
+type Reader struct {
+	r       io.Reader
+	records uint64
+	crc     uint32
+}
+
+// NewReader reads and verifies Magic from |r|.
+func NewReader(r io.Reader) (*Reader, error) {
+	var magic [1]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if magic[0] != Magic {
+		return nil, fmt.Errorf("not a checksummed snapshot: got first byte %q, want %q", magic[0], Magic)
+	}
+	return &Reader{r: r}, nil
+}
+
+// Next returns the payload of the next record. Once the manifest was read and
+// verified, Next returns io.EOF. A snapshot which ends before the manifest
+// results in ErrTruncated, a checksum mismatch in ErrChecksum.
+func (r *Reader) Next() ([]byte, error) {
+	var lenbuf [8]byte // binary.Size(uint64(0))
+	if err := r.readFull(lenbuf[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint64(lenbuf[:])
+	if length == manifestMarker {
+		return nil, r.readManifest()
+	}
+	buf := make([]byte, length)
+	if err := r.readFull(buf); err != nil {
+		return nil, err
+	}
+	var crcbuf [4]byte
+	if err := r.readFull(crcbuf[:]); err != nil {
+		return nil, err
+	}
+	if got, want := crc32.Checksum(buf, castagnoli), binary.BigEndian.Uint32(crcbuf[:]); got != want {
+		return nil, fmt.Errorf("record %d: %w", r.records, ErrChecksum)
+	}
+	r.crc = crc32.Update(r.crc, castagnoli, buf)
+	r.records++
+	return buf, nil
+}
+
+func (r *Reader) readManifest() error {
+	var manifest [8 + 4]byte
+	if err := r.readFull(manifest[:]); err != nil {
+		return err
+	}
+	if got, want := r.records, binary.BigEndian.Uint64(manifest[0:8]); got != want {
+		return fmt.Errorf("read %d records, manifest lists %d: %w", got, want, ErrChecksum)
+	}
+	if got, want := r.crc, binary.BigEndian.Uint32(manifest[8:12]); got != want {
+		return fmt.Errorf("manifest: %w", ErrChecksum)
+	}
+	var trailing [1]byte
+	if n, _ := r.r.Read(trailing[:]); n > 0 {
+		return fmt.Errorf("unexpected data after manifest: %w", ErrChecksum)
+	}
+	return io.EOF
+}
+
+// readFull is like io.ReadFull, but reports the end of the stream as
+// ErrTruncated.
+func (r *Reader) readFull(buf []byte) error {
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// Package snapshotmeta stores a summary of the network state within each raft
+// snapshot, so that tooling can describe snapshots without restoring them.
+package snapshotmeta
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/robustirc/robustirc/internal/robust"
+	"github.com/robustirc/robustirc/internal/snapshotframe"
+
+	pb "github.com/robustirc/robustirc/internal/proto"
+)
+
+// Summary describes the contents of a snapshot.
+type Summary struct {
+	// Sessions and Channels are the number of sessions and channels of the
+	// network as of the last index of the snapshot.
+	Sessions int
+	Channels int
+
+	// Retained is the number of log entries which were too new to be
+	// compacted, keyed by IRC command (or message type for messages which do
+	// not carry an IRC command).
+	Retained map[string]int
+
+	// CompactionDuration is how long compacting the log took.
+	CompactionDuration time.Duration
+}
+
+// Record returns the snapshot record (a 'p'-prefixed pb.RaftLog) which
+// carries |s|. It must be the first record of a protobuf snapshot, so that
+// Read does not need to read the entire snapshot.
+func Record(s *Summary) ([]byte, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	msg := robust.Message{
+		Type: robust.SnapshotSummary,
+		Data: string(b),
+	}
+	msgBytes, err := proto.Marshal(msg.ProtoMessage())
+	if err != nil {
+		return nil, err
+	}
+	record, err := proto.Marshal(&pb.RaftLog{
+		Type:  pb.RaftLog_COMMAND,
+		Index: 0, // never passed to raft
+		Data:  append([]byte{'p'}, msgBytes...),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{'p'}, record...), nil
+}
+
+// Read returns the summary stored in the snapshot read from |r|. Snapshots
+// which were taken before summaries were introduced (or which are not
+// protobuf-encoded) result in (nil, nil).
+func Read(r io.Reader) (*Summary, error) {
+	b := bufio.NewReader(r)
+	if magic, err := b.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(b)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		b = bufio.NewReader(zr)
+	}
+	first, err := b.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	var record []byte
+	switch first[0] {
+	case snapshotframe.Magic:
+		fr, err := snapshotframe.NewReader(b)
+		if err != nil {
+			return nil, err
+		}
+		if record, err = fr.Next(); err != nil {
+			return nil, err
+		}
+	case 'p':
+		// Length-prefixed records after the leading 'p', see
+		// writeLenPrefixed in package main.
+		var buf [9]byte // 'p' + binary.Size(uint64(0))
+		if _, err := io.ReadFull(b, buf[:]); err != nil {
+			return nil, err
+		}
+		record = make([]byte, binary.BigEndian.Uint64(buf[1:]))
+		if _, err := io.ReadFull(b, record); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil // JSON snapshot
+	}
+
+	if len(record) == 0 || record[0] != 'p' {
+		return nil, fmt.Errorf("unexpected first record %q", record)
+	}
+	var entry pb.RaftLog
+	if err := proto.Unmarshal(record[1:], &entry); err != nil {
+		return nil, err
+	}
+	msg := robust.NewMessageFromBytes(entry.Data, robust.IdFromRaftIndex(entry.Index))
+	if msg.Type != robust.SnapshotSummary {
+		return nil, nil
+	}
+	var s Summary
+	if err := json.Unmarshal([]byte(msg.Data), &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
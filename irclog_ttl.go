@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// irclogTTLCheckInterval is how often the irclogPruner checks the age of the
+// oldest irclog entry.
+const irclogTTLCheckInterval = 1 * time.Minute
+
+// irclogPruner bounds the age of the irclog entries (see -irclog_ttl)
+// independently of the snapshot interval: once the oldest entry is older than
+// ttl, it takes a snapshot, which folds all entries outside of the retention
+// window into the snapshot state and deletes them. Entries which compaction
+// must retain (e.g. because they are still relevant for the IRC state) are
+// not deleted; the pruner then waits for the oldest entry to change instead
+// of taking snapshots in vain.
+type irclogPruner struct {
+	ttl time.Duration
+
+	// oldest returns the index and timestamp of the oldest irclog entry,
+	// see api.HTTP.OldestIrclogEntry.
+	oldest func() (uint64, time.Time, error)
+
+	// snapshot takes a raft snapshot.
+	snapshot func() error
+
+	// triggered is the index of the oldest entry when the pruner last took
+	// a snapshot.
+	triggered uint64
+}
+
+// check takes a snapshot if the oldest irclog entry is older than p.ttl as of
+// |now|.
+func (p *irclogPruner) check(now time.Time) {
+	index, ts, err := p.oldest()
+	if err != nil {
+		log.Printf("Could not determine the oldest irclog entry: %v", err)
+		return
+	}
+	if index == 0 {
+		irclogOldestEntryAge.Set(0)
+		return
+	}
+	age := now.Sub(ts)
+	irclogOldestEntryAge.Set(age.Seconds())
+	if age <= p.ttl || index == p.triggered {
+		return
+	}
+	log.Printf("irclog entry %d is %v old (-irclog_ttl=%v), taking a snapshot", index, age.Truncate(time.Second), p.ttl)
+	p.triggered = index
+	if err := p.snapshot(); err != nil {
+		log.Printf("Could not take snapshot: %v", err)
+		return
+	}
+	if index, _, err := p.oldest(); err == nil && index == p.triggered {
+		log.Printf("irclog entry %d was retained by compaction, not taking further snapshots until it is compacted", index)
+	}
+}
+
+func (p *irclogPruner) run() {
+	for range time.Tick(irclogTTLCheckInterval) {
+		p.check(time.Now())
+	}
+}
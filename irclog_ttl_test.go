@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIrclogPruner(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var (
+		index     uint64
+		ts        time.Time
+		snapshots int
+	)
+	p := &irclogPruner{
+		ttl: 24 * time.Hour,
+		oldest: func() (uint64, time.Time, error) {
+			return index, ts, nil
+		},
+		snapshot: func() error {
+			snapshots++
+			return nil
+		},
+	}
+
+	// An empty irclog never triggers a snapshot.
+	p.check(now)
+	if got, want := snapshots, 0; got != want {
+		t.Fatalf("empty irclog: got %d snapshots, want %d", got, want)
+	}
+
+	index, ts = 5, now.Add(-1*time.Hour)
+	p.check(now)
+	if got, want := snapshots, 0; got != want {
+		t.Fatalf("young entry: got %d snapshots, want %d", got, want)
+	}
+
+	index, ts = 5, now.Add(-25*time.Hour)
+	p.check(now)
+	if got, want := snapshots, 1; got != want {
+		t.Fatalf("old entry: got %d snapshots, want %d", got, want)
+	}
+
+	// The entry was retained by compaction: do not snapshot again.
+	p.check(now.Add(irclogTTLCheckInterval))
+	if got, want := snapshots, 1; got != want {
+		t.Fatalf("retained entry: got %d snapshots, want %d", got, want)
+	}
+
+	// Once the oldest entry changes, the pruner snapshots again.
+	index, ts = 9, now.Add(-25*time.Hour)
+	p.check(now)
+	if got, want := snapshots, 2; got != want {
+		t.Fatalf("new old entry: got %d snapshots, want %d", got, want)
+	}
+}
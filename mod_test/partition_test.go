@@ -0,0 +1,147 @@
+package mod_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robustirc/bridge/robustsession"
+	"github.com/robustirc/internal/health"
+	"github.com/robustirc/robustirc/internal/localnet"
+)
+
+// waitFor calls |cond| once a second until it returns nil or |timeout|
+// passes, in which case the last error is returned.
+func waitFor(timeout time.Duration, cond func() error) error {
+	started := time.Now()
+	for {
+		err := cond()
+		if err == nil || time.Since(started) > timeout {
+			return err
+		}
+		time.Sleep(1 * time.Second)
+	}
+}
+
+func TestPartitionedLeader(t *testing.T) {
+	tempdir := t.TempDir()
+
+	l, err := localnet.NewLocalnet(-1, tempdir)
+	if err != nil {
+		t.Fatalf("Could not start local RobustIRC network: %v", err)
+	}
+	defer l.Kill(true)
+
+	l.EnablePartitionHooks = "1"
+
+	for i := 0; i < 3; i++ {
+		l.StartIRCServer(i == 0)
+	}
+
+	var statuses map[string]health.ServerStatus
+	if err := waitFor(20*time.Second, func() error {
+		var err error
+		statuses, err = health.EnsureNetworkHealthy(l.Servers(), l.NetworkPassword)
+		return err
+	}); err != nil {
+		t.Fatalf("Expected healthy network: %v", err)
+	}
+
+	// Cut the leader off from the other two nodes.
+	var (
+		leaderPort int
+		majority   []string
+	)
+	for idx, server := range l.Servers() {
+		if statuses[server].State == "Leader" {
+			leaderPort = l.Ports[idx]
+		} else {
+			majority = append(majority, server)
+		}
+	}
+	if leaderPort == 0 || len(majority) != 2 {
+		t.Fatalf("Could not determine the leader: %+v", statuses)
+	}
+	oldLeader := fmt.Sprintf("localhost:%d", leaderPort)
+	t.Logf("Partitioning leader %s from %v", oldLeader, majority)
+	if err := l.Partition(leaderPort); err != nil {
+		t.Fatalf("Partition(%d): %v", leaderPort, err)
+	}
+
+	// The majority needs to elect a new leader.
+	if err := waitFor(20*time.Second, func() error {
+		statuses, err := health.EnsureNetworkHealthy(majority, l.NetworkPassword)
+		if err != nil {
+			return err
+		}
+		if leader := statuses[majority[0]].Leader; leader == oldLeader {
+			return fmt.Errorf("%s is still the leader", leader)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Majority did not elect a new leader: %v", err)
+	}
+
+	// The majority must make progress while the old leader is partitioned.
+	session, err := robustsession.Create(strings.Join(majority, ","), filepath.Join(tempdir, "cert.pem"))
+	if err != nil {
+		t.Fatalf("Could not create robustsession: %v", err)
+	}
+	joined := make(chan bool)
+	go func() {
+		for msg := range session.Messages {
+			if strings.HasPrefix(msg, ":part!1@") &&
+				strings.HasSuffix(msg, " JOIN #partition") {
+				joined <- true
+			}
+		}
+	}()
+	go func() {
+		for err := range session.Errors {
+			t.Errorf("RobustSession error: %v", err)
+		}
+	}()
+
+	session.PostMessage("NICK part")
+	session.PostMessage("USER 1 2 3 4")
+	session.PostMessage("JOIN #partition")
+	select {
+	case <-joined:
+		t.Logf("JOIN reply received, majority progressing")
+	case <-time.After(20 * time.Second):
+		t.Fatalf("Timeout waiting for JOIN message while partitioned")
+	}
+
+	status, err := health.GetServerStatus(majority[0], l.NetworkPassword)
+	if err != nil {
+		t.Fatalf("GetServerStatus(%s): %v", majority[0], err)
+	}
+	committed := status.CommitIndex
+
+	if err := l.Heal(); err != nil {
+		t.Fatalf("Heal(): %v", err)
+	}
+
+	// After healing, the old leader must rejoin the network and catch up on
+	// the messages it missed.
+	if err := waitFor(30*time.Second, func() error {
+		_, err := health.EnsureNetworkHealthy(l.Servers(), l.NetworkPassword)
+		return err
+	}); err != nil {
+		t.Fatalf("Expected healthy network after healing: %v", err)
+	}
+	if err := waitFor(20*time.Second, func() error {
+		status, err := health.GetServerStatus(oldLeader, l.NetworkPassword)
+		if err != nil {
+			return err
+		}
+		if status.AppliedIndex < committed {
+			return fmt.Errorf("%s applied index %d, want >= %d", oldLeader, status.AppliedIndex, committed)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Old leader did not catch up: %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+// Package plugin allows networks to add custom IRC commands to RobustIRC
+// without modifying the IRC server itself.
+//
+// A plugin is a Go package which calls Register in its init function. It is
+// compiled into the robustirc binary by adding a blank import of the package
+// to the main package, e.g. in a file plugins_local.go:
+//
+//	import _ "example.net/robustirc-plugins/deploy"
+//
+// Commands of a plugin are available to clients as NAMESPACE.VERB (e.g.
+// DEPLOY.STATUS). Built-in commands never contain a dot, so plugins cannot
+// collide with current or future built-in commands, nor with each other.
+//
+// Plugin commands are executed as part of the raft state machine, so just like
+// built-in commands, they MUST be deterministic: their output may only depend
+// on the message, the Context and the plugin’s configuration (not on the wall
+// clock, randomness, network access, etc.).
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// Context is the view a plugin command has of the IRC server while it is
+// being executed. Which sessions are interested in a message (and hence which
+// sessions it is relevant for, e.g. in the per-session IRC log) is determined
+// by the method which is used to send it.
+type Context interface {
+	// ServerName returns the name of the network, e.g. for use as prefix.
+	ServerName() string
+
+	// Nick returns the nickname of the session which sent the command.
+	Nick() string
+
+	// Operator returns whether the session which sent the command is an IRC
+	// operator.
+	Operator() bool
+
+	// Config returns the plugin’s section of the network configuration,
+	// i.e. [Plugins.<namespace>]. The returned map must not be modified.
+	Config() map[string]string
+
+	// Reply sends |msg| to the session which sent the command.
+	Reply(msg *irc.Message)
+
+	// SendNick sends |msg| to the session using |nick|. It returns false if
+	// there is no such session.
+	SendNick(nick string, msg *irc.Message) bool
+
+	// SendChannel sends |msg| to all members of |channel|. It returns false
+	// if there is no such channel.
+	SendChannel(channel string, msg *irc.Message) bool
+
+	// SendServices sends |msg| to the IRC services.
+	SendServices(msg *irc.Message)
+}
+
+// Command is an IRC command provided by a plugin.
+type Command struct {
+	Func func(ctx Context, msg *irc.Message)
+
+	// MinParams ensures that enough parameters were specified, see the
+	// corresponding field of built-in commands.
+	MinParams int
+
+	// ReadOnly marks commands which can be answered by any node without
+	// going through raft. ReadOnly commands may only use Context.Reply.
+	ReadOnly bool
+}
+
+// Plugin is implemented by packages which provide commands.
+type Plugin interface {
+	// Namespace returns the name under which the plugin’s commands and
+	// configuration are available. Namespaces consist of lower-case letters
+	// and digits and must be unique.
+	Namespace() string
+
+	// Commands returns the plugin’s commands, keyed by verb. Verbs consist of
+	// upper-case letters and digits. The result must not change.
+	Commands() map[string]Command
+
+	// ValidateConfig is called with the plugin’s section of a new network
+	// configuration before the configuration is applied. Returning an error
+	// rejects the configuration.
+	ValidateConfig(cfg map[string]string) error
+}
+
+var (
+	validNamespace = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+	validVerb      = regexp.MustCompile(`^[A-Z][A-Z0-9]*$`)
+)
+
+var (
+	pluginsMu sync.RWMutex
+	plugins   = make(map[string]Plugin)
+)
+
+// Register makes |p| available. It panics if |p| has an invalid or duplicate
+// namespace or invalid verbs, so that mistakes are caught on startup.
+func Register(p Plugin) {
+	ns := p.Namespace()
+	if !validNamespace.MatchString(ns) {
+		panic(fmt.Sprintf("plugin: invalid namespace %q", ns))
+	}
+	for verb, cmd := range p.Commands() {
+		if !validVerb.MatchString(verb) {
+			panic(fmt.Sprintf("plugin: %s: invalid verb %q", ns, verb))
+		}
+		if cmd.Func == nil {
+			panic(fmt.Sprintf("plugin: %s: verb %q has no Func", ns, verb))
+		}
+	}
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	if _, ok := plugins[ns]; ok {
+		panic(fmt.Sprintf("plugin: namespace %q registered twice", ns))
+	}
+	plugins[ns] = p
+}
+
+// Plugins returns all registered plugins, sorted by namespace.
+func Plugins() []Plugin {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	result := make([]Plugin, 0, len(plugins))
+	for _, p := range plugins {
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Namespace() < result[j].Namespace()
+	})
+	return result
+}
+
+func byNamespace(ns string) (Plugin, bool) {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	p, ok := plugins[ns]
+	return p, ok
+}
+
+// Lookup returns the plugin and command for |command| (NAMESPACE.VERB, in any
+// case), if registered.
+func Lookup(command string) (Plugin, Command, bool) {
+	ns, verb, ok := strings.Cut(command, ".")
+	if !ok {
+		return nil, Command{}, false
+	}
+	p, ok := byNamespace(strings.ToLower(ns))
+	if !ok {
+		return nil, Command{}, false
+	}
+	cmd, ok := p.Commands()[strings.ToUpper(verb)]
+	return p, cmd, ok
+}
+
+// ValidateConfig calls the ValidateConfig method of all registered plugins
+// with their section of |cfg| (keyed by namespace). Sections for plugins which
+// are not registered are ignored, e.g. while a plugin is being rolled out.
+func ValidateConfig(cfg map[string]map[string]string) error {
+	for _, p := range Plugins() {
+		if err := p.ValidateConfig(cfg[p.Namespace()]); err != nil {
+			return fmt.Errorf("plugin %q: %v", p.Namespace(), err)
+		}
+	}
+	return nil
+}
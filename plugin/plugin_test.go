@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"fmt"
+	"testing"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+type fakePlugin struct {
+	namespace string
+	commands  map[string]Command
+	validate  func(map[string]string) error
+}
+
+func (p *fakePlugin) Namespace() string            { return p.namespace }
+func (p *fakePlugin) Commands() map[string]Command { return p.commands }
+
+func (p *fakePlugin) ValidateConfig(cfg map[string]string) error {
+	if p.validate == nil {
+		return nil
+	}
+	return p.validate(cfg)
+}
+
+func nop(Context, *irc.Message) {}
+
+func mustPanic(t *testing.T, name string, f func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("%s: did not panic", name)
+		}
+	}()
+	f()
+}
+
+func TestRegister(t *testing.T) {
+	mustPanic(t, "invalid namespace", func() {
+		Register(&fakePlugin{namespace: "Deploy"})
+	})
+	mustPanic(t, "invalid verb", func() {
+		Register(&fakePlugin{namespace: "verbs", commands: map[string]Command{"A.B": {Func: nop}}})
+	})
+	mustPanic(t, "missing Func", func() {
+		Register(&fakePlugin{namespace: "nofunc", commands: map[string]Command{"STATUS": {}}})
+	})
+
+	Register(&fakePlugin{
+		namespace: "deploy",
+		commands:  map[string]Command{"STATUS": {Func: nop, MinParams: 1}},
+		validate: func(cfg map[string]string) error {
+			if cfg["channel"] == "" {
+				return fmt.Errorf("channel not set")
+			}
+			return nil
+		},
+	})
+	mustPanic(t, "duplicate namespace", func() {
+		Register(&fakePlugin{namespace: "deploy"})
+	})
+
+	if _, cmd, ok := Lookup("Deploy.status"); !ok || cmd.MinParams != 1 {
+		t.Errorf("Lookup(Deploy.status) = %+v, %v, want the STATUS command", cmd, ok)
+	}
+	for _, command := range []string{"DEPLOY", "DEPLOY.NOPE", "OTHER.STATUS"} {
+		if _, _, ok := Lookup(command); ok {
+			t.Errorf("Lookup(%q) unexpectedly succeeded", command)
+		}
+	}
+
+	if err := ValidateConfig(nil); err == nil {
+		t.Errorf("ValidateConfig(nil) unexpectedly succeeded")
+	}
+	cfg := map[string]map[string]string{
+		"deploy":  {"channel": "#ops"},
+		"removed": {"foo": "bar"},
+	}
+	if err := ValidateConfig(cfg); err != nil {
+		t.Errorf("ValidateConfig(%v): %v", cfg, err)
+	}
+}
@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+// Transform rewrites the text of a message sent to a channel, e.g. to convert
+// text in a legacy encoding to UTF-8. It returns false if |text| was left
+// unchanged.
+//
+// Transforms are applied as part of the raft state machine, so just like
+// commands, they MUST be deterministic.
+type Transform func(text string) (string, bool)
+
+var (
+	transformsMu sync.RWMutex
+	transforms   = map[string]Transform{
+		"latin1": latin1ToUTF8,
+	}
+)
+
+// latin1ToUTF8 interprets text which is not valid UTF-8 as ISO-8859-1.
+func latin1ToUTF8(text string) (string, bool) {
+	if utf8.ValidString(text) {
+		return text, false
+	}
+	runes := make([]rune, len(text))
+	for idx := 0; idx < len(text); idx++ {
+		runes[idx] = rune(text[idx])
+	}
+	return string(runes), true
+}
+
+// RegisterTransform makes |t| available under |name|, which operators refer to
+// in the ChannelTransforms section of the network configuration. Names
+// follow the rules for plugin namespaces. It panics on invalid or duplicate
+// names, so that mistakes are caught on startup.
+func RegisterTransform(name string, t Transform) {
+	if !validNamespace.MatchString(name) {
+		panic(fmt.Sprintf("plugin: invalid transform name %q", name))
+	}
+	transformsMu.Lock()
+	defer transformsMu.Unlock()
+	if _, ok := transforms[name]; ok {
+		panic(fmt.Sprintf("plugin: transform %q registered twice", name))
+	}
+	transforms[name] = t
+}
+
+// LookupTransform returns the transform registered as |name|, if any.
+func LookupTransform(name string) (Transform, bool) {
+	transformsMu.RLock()
+	defer transformsMu.RUnlock()
+	t, ok := transforms[name]
+	return t, ok
+}
+
+// ValidateTransforms returns an error if |cfg| (channel name to transform
+// name) refers to transforms which are not registered.
+func ValidateTransforms(cfg map[string]string) error {
+	channels := make([]string, 0, len(cfg))
+	for channel := range cfg {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	for _, channel := range channels {
+		if _, ok := LookupTransform(cfg[channel]); !ok {
+			return fmt.Errorf("channel %q: unknown transform %q", channel, cfg[channel])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLatin1ToUTF8(t *testing.T) {
+	for _, entry := range []struct {
+		input   string
+		want    string
+		changed bool
+	}{
+		{"hello", "hello", false},
+		{"Grüße", "Grüße", false},
+		{"Gr\xfc\xdfe", "Grüße", true},
+	} {
+		got, changed := latin1ToUTF8(entry.input)
+		if got != entry.want || changed != entry.changed {
+			t.Errorf("latin1ToUTF8(%q) = %q, %v, want %q, %v", entry.input, got, changed, entry.want, entry.changed)
+		}
+	}
+}
+
+func TestRegisterTransform(t *testing.T) {
+	upper := func(text string) (string, bool) {
+		return strings.ToUpper(text), true
+	}
+	mustPanic(t, "invalid name", func() {
+		RegisterTransform("Upper", upper)
+	})
+	mustPanic(t, "duplicate name", func() {
+		RegisterTransform("latin1", upper)
+	})
+
+	if err := ValidateTransforms(map[string]string{"#shout": "upper"}); err == nil {
+		t.Fatalf("ValidateTransforms unexpectedly accepted an unknown transform")
+	}
+	RegisterTransform("upper", upper)
+	if err := ValidateTransforms(map[string]string{"#shout": "upper", "#legacy": "latin1"}); err != nil {
+		t.Fatal(err)
+	}
+	transform, ok := LookupTransform("upper")
+	if !ok {
+		t.Fatalf("LookupTransform(%q) did not find the transform", "upper")
+	}
+	if got, _ := transform("hey"); got != "HEY" {
+		t.Fatalf("transform(%q) = %q, want %q", "hey", got, "HEY")
+	}
+}
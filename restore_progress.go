@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io"
+	"log"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// restoreProgressInterval is how often FSM.Restore logs its progress.
+const restoreProgressInterval = 10 * time.Second
+
+// sizedSnapshotStore is a raft.SnapshotStore whose opened snapshots carry
+// their size, so that FSM.Restore can estimate how long restoring takes.
+type sizedSnapshotStore struct {
+	raft.SnapshotStore
+}
+
+// sizedReadCloser is a snapshot opened by sizedSnapshotStore.
+type sizedReadCloser struct {
+	io.ReadCloser
+	size int64
+}
+
+func (s *sizedReadCloser) Size() int64 { return s.size }
+
+func (s *sizedSnapshotStore) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	meta, rc, err := s.SnapshotStore.Open(id)
+	if err != nil {
+		return meta, rc, err
+	}
+	return meta, &sizedReadCloser{ReadCloser: rc, size: meta.Size}, nil
+}
+
+// restoreProgress counts the bytes read from a snapshot and the entries
+// restored from it, and periodically logs and exports them.
+type restoreProgress struct {
+	r       io.Reader
+	total   int64 // 0 if unknown
+	read    int64
+	entries uint64
+	start   time.Time
+	logged  time.Time
+}
+
+// newRestoreProgress returns a restoreProgress which reads from |snap|. The
+// total size is known if |snap| was opened by a sizedSnapshotStore.
+func newRestoreProgress(snap io.Reader) *restoreProgress {
+	p := &restoreProgress{r: snap, start: time.Now()}
+	if sized, ok := snap.(interface{ Size() int64 }); ok {
+		p.total = sized.Size()
+	}
+	p.logged = p.start
+	restoreBytesTotal.Set(float64(p.total))
+	restoreBytesRead.Set(0)
+	restoreEntries.Set(0)
+	return p
+}
+
+func (p *restoreProgress) Read(buf []byte) (int, error) {
+	n, err := p.r.Read(buf)
+	p.read += int64(n)
+	restoreBytesRead.Set(float64(p.read))
+	return n, err
+}
+
+// records wraps |next| such that each record counts as a restored entry.
+func (p *restoreProgress) records(next func() ([]byte, error)) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		buf, err := next()
+		if err == nil {
+			p.entry()
+		}
+		return buf, err
+	}
+}
+
+// entry counts one restored entry and logs the progress if
+// restoreProgressInterval has passed since it was last logged.
+func (p *restoreProgress) entry() {
+	p.entries++
+	restoreEntries.Set(float64(p.entries))
+	if now := time.Now(); now.Sub(p.logged) >= restoreProgressInterval {
+		p.logged = now
+		p.log(now)
+	}
+}
+
+// eta returns the estimated remaining duration, assuming the remaining bytes
+// are read at the same rate, or 0 if the total size is unknown.
+func (p *restoreProgress) eta(now time.Time) time.Duration {
+	if p.total <= 0 || p.read == 0 || p.read >= p.total {
+		return 0
+	}
+	elapsed := now.Sub(p.start)
+	return time.Duration(float64(elapsed) * float64(p.total-p.read) / float64(p.read))
+}
+
+func (p *restoreProgress) log(now time.Time) {
+	if p.total <= 0 {
+		log.Printf("Restore progress: %d entries, %d bytes read in %v",
+			p.entries, p.read, now.Sub(p.start))
+		return
+	}
+	log.Printf("Restore progress: %d entries, %d of %d bytes read (%.1f%%) in %v, ETA %v",
+		p.entries, p.read, p.total, 100*float64(p.read)/float64(p.total),
+		now.Sub(p.start), p.eta(now).Round(time.Second))
+}
+
+// done logs the final progress.
+func (p *restoreProgress) done() {
+	p.log(time.Now())
+}
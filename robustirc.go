@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -17,6 +18,7 @@ import (
 	"runtime/debug"
 	"runtime/pprof"
 	"strings"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/http2"
@@ -27,8 +29,10 @@ import (
 	"github.com/robustirc/internal/robusthttp"
 	"github.com/robustirc/rafthttp"
 	"github.com/robustirc/robustirc/internal/api"
+	"github.com/robustirc/robustirc/internal/encryption"
 	"github.com/robustirc/robustirc/internal/ircserver"
 	"github.com/robustirc/robustirc/internal/outputstream"
+	"github.com/robustirc/robustirc/internal/partition"
 	"github.com/robustirc/robustirc/internal/raftstore"
 	"github.com/robustirc/robustirc/internal/robust"
 	"github.com/robustirc/robustirc/internal/timesafeguard"
@@ -41,10 +45,6 @@ import (
 	_ "net/http/pprof"
 )
 
-const (
-	expireSessionsInterval = 10 * time.Second
-)
-
 // XXX: when introducing a new flag, you must add it to the flag.Usage function in main().
 var (
 	raftDir = flag.String("raftdir",
@@ -56,6 +56,12 @@ var (
 	listen = flag.String("listen",
 		":443",
 		"[host]:port to listen on. Set to a port in the dynamic port range (49152 to 65535) and use DNS SRV records.")
+	servicesListen = flag.String("services_listen",
+		"",
+		"[host]:port of a dedicated TLS listener for services. If set, services can only link via this listener, optionally presenting the TLS client certificate configured in CertFingerprint. Should be set on all nodes.")
+	requestClientCerts = flag.Bool("request_client_certs",
+		false,
+		"Request (but do not require) TLS client certificates on -listen, so that clients can log in via SASL EXTERNAL, see ClientCertAccounts in the network configuration. Should be set on all nodes.")
 	version = flag.Bool("version",
 		false,
 		"Print version and exit")
@@ -69,6 +75,9 @@ var (
 	dumpCanaryState = flag.String("dump_canary_state",
 		"",
 		"If specified, initializes the raft node (from a snapshot), then dumps all message state to the specified file. To be used via robustirc-canary.")
+	dumpCompactionReport = flag.String("dump_compaction_report",
+		"",
+		"If specified, initializes the raft node (from a snapshot), then writes a JSON report of which log entries the next compaction would remove (per command and per session) to the specified file, without compacting. Useful to validate changes to the compaction rules.")
 	dumpHeapProfile = flag.String("dump_heap_profile",
 		"",
 		"If specified, a heap profile will be dumped to the specified file. Only relevant when -dump_canary_state is set.")
@@ -96,6 +105,97 @@ var (
 		4648398125000000000, // 2117-04-20 23:42:05
 		"will be added to all robust.Message ids. We need an offset because message ids must be monotonically increasing, and RobustIRC used to use UNIX nano timestamps. For new networks, the offset doesn’t hurt, and it’s configurable in case networks need to transition back and forth between the old and the new mechanism. See also issue #150.")
 
+	localQueryStaleness = flag.Duration("local_query_staleness",
+		2*time.Second,
+		"Read-only IRC commands (e.g. WHO, NAMES, LIST) are answered from this node’s state without going through raft, provided the node was in contact with the raft leader within the specified duration. Set to 0 to always go through raft.")
+
+	shedApplyLatency = flag.Duration("shed_apply_latency",
+		0,
+		"If the average raft Apply latency exceeds this duration, messages are rejected in order of session priority (users, then bots at twice the latency, then operators at four times the latency; services are never rejected). Set to 0 to disable.")
+	shedQueueDepth = flag.Uint64("shed_queue_depth",
+		0,
+		"Like -shed_apply_latency, but for the number of raft log entries which were not yet applied. Set to 0 to disable.")
+
+	networkConfig = flag.String("network_config",
+		"",
+		"Path to a TOML file containing the network configuration (as accepted by robustirc-editconfig). When an IRC operator sends REHASH, the raft leader applies the contents of this file as the new configuration. Should be set on all nodes.")
+
+	archiveChannels = flag.String("archive_channels",
+		"",
+		"Comma-separated list of public channels (e.g. \"#robustirc,#go-nuts\") whose retained history is periodically rendered into static HTML and JSON files. Channels are skipped while they are secret (mode +s). Note that messages are only retained until they are compacted.")
+	archiveDir = flag.String("archive_dir",
+		"",
+		"Directory into which the -archive_channels files are written. If empty, they are served (without authentication) under /archive/ instead.")
+	archiveInterval = flag.Duration("archive_interval",
+		5*time.Minute,
+		"How often the -archive_channels files are rendered.")
+
+	warmStart = flag.Bool("warm_start",
+		false,
+		"Keep a copy of the full IRC server state next to each snapshot and use it on restart instead of replaying the log entries which were too new to be compacted. Speeds up restarts considerably, but the output of these log entries (e.g. for the irclog status pages) is not regenerated.")
+
+	stateHashInterval = flag.Uint64("state_hash_interval",
+		0,
+		"If > 0, a hash of the IRC state (sessions, channels, modes, topics) is recorded after applying every raft index which is a multiple of the specified number. The most recent hashes are served under /statehash on the private API, so that they can be compared across nodes to detect state divergence.")
+
+	snapshotCompression = flag.String("snapshot_compression",
+		"none",
+		"Compression of the snapshots written by this node, either \"none\" or \"gzip\". Snapshots are decompressed transparently on restore, but nodes older than this flag cannot restore compressed snapshots, so only enable compression once all nodes were upgraded.")
+
+	raftlogBackend = flag.String("raftlog_backend",
+		"leveldb",
+		"Storage backend of the raftlog: \"leveldb\", \"memory\" (diskless, for ephemeral nodes whose state is lost on restart), \"bbolt\" (a single file, which simplifies backups; requires building with -tags bbolt) or \"badger\" (separates values from keys, for networks with very high message rates; requires building with -tags badger). The backend of an existing node cannot be changed without migrating its raftlog.")
+
+	snapshotArchive = flag.String("snapshot_archive",
+		"",
+		"Directory (or file:// URL) to which each completed snapshot is copied, together with a manifest, for disaster recovery when the disks of all nodes are lost. Should be on a different machine, e.g. a network file system. See also -restore_from_archive.")
+	restoreFromArchive = flag.String("restore_from_archive",
+		"",
+		"Directory (or file:// URL) previously used as -snapshot_archive. Requires -singlenode: the new network takes on the state of the most recent archived snapshot. Other nodes can then be added using -join.")
+
+	getLogCacheSize = flag.Int("getlog_cache_size",
+		4096,
+		"Number of recently stored or read log entries which are kept in memory per LevelDB store (raftlog and irclog), so that e.g. GetMessages catch-up and snapshotting do not need to read them from disk. See the raftstore_getlog_cache_hits and raftstore_getlog_cache_misses metrics for tuning. 0 disables the cache.")
+
+	compactAfterDelete = flag.Bool("compact_after_delete",
+		true,
+		"Compact the LevelDB key span of deleted log entries (after snapshotting) in the background, so that their disk space is reclaimed. Without compaction, deleting only writes tombstones. See also -compaction_min_interval and POST /compact on the private API.")
+	compactionMinInterval = flag.Duration("compaction_min_interval",
+		1*time.Minute,
+		"Minimum time between two background compactions of the same database, see -compact_after_delete. Log entries deleted in the meantime are compacted with the next compaction. 0 compacts after every deletion.")
+
+	irclogTTL = flag.Duration("irclog_ttl",
+		0,
+		"If > 0, take a snapshot whenever the oldest irclog entry is older than the specified duration, so that old messages are deleted from disk even when snapshots are infrequent. Snapshots only delete messages outside of the retention window (the session expiration plus the session expiration check interval), and messages which are still relevant for the IRC state are retained, so values below the retention window have no effect. See also the fsm_irclog_oldest_entry_age_seconds metric.")
+
+	fsck = flag.Bool("fsck",
+		false,
+		"Check the raftlog and irclog for corrupted log entries, dangling session index entries and gaps, print a report and exit (with status 1 if problems were found). The node must not be running. See also -fsck_repair.")
+	fsckRepair = flag.Bool("fsck_repair",
+		false,
+		"With -fsck, delete corrupted log entries and dangling session index entries. Corrupted raftlog entries leave gaps, after which the node should be wiped and re-join the network.")
+
+	raftlogSync = flag.Bool("raftlog_sync",
+		true,
+		"Wait until raftlog writes were flushed to disk (fsync) before acknowledging them. Raft requires durable log entries: disabling this risks losing acknowledged messages or diverging state when the machine crashes, in exchange for lower latency.")
+	irclogSync = flag.Bool("irclog_sync",
+		false,
+		"Wait until irclog writes were flushed to disk (fsync). The irclog is derived from the snapshots and the raftlog, which are re-applied after restarting, so asynchronous writes are safe.")
+	leveldbWriteBufferSize = flag.Int("leveldb_write_buffer_size",
+		0,
+		"Size in bytes up to which the LevelDB databases (raftlog and irclog) buffer writes in memory before writing them to a table file. Larger buffers result in fewer, larger table files at the cost of memory and a longer recovery after crashes. 0 uses the LevelDB default (4 MiB).")
+	leveldbCompression = flag.String("leveldb_compression",
+		"snappy",
+		"Compression of the table blocks of the LevelDB databases (raftlog and irclog), either \"snappy\" or \"none\". Only affects newly written tables.")
+
+	encryptionKeyFile = flag.String("encryption_key_file",
+		"",
+		"Path to a file containing a 32-byte AES-256 key (raw or hex-encoded, e.g. created with “openssl rand -hex 32 > key”). If set, log entries in the raftlog and irclog as well as snapshots are stored encrypted (AES-GCM), so that IRC logs are not stored in plaintext on disk. Data written before specifying the key remains readable and is replaced over time by compaction. The key cannot be changed and must not be lost: without it, the node cannot read its data. Archived snapshots (see -snapshot_archive) are stored encrypted, too.")
+
+	snapshotChecksums = flag.Bool("snapshot_checksums",
+		false,
+		"Add per-record checksums and a trailing manifest to the snapshots written by this node, so that truncated or corrupted snapshots are detected on restore (and the previous snapshot is used instead). Nodes older than this flag cannot restore checksummed snapshots, so only enable checksums once all nodes were upgraded.")
+
 	// XXX(1.0): delete this flag
 	useProtobuf = flag.Bool("pre1.0_protobuf",
 		true,
@@ -111,6 +211,9 @@ var (
 	// which IRC client(s) are interested in that message.
 	outputStream *outputstream.OutputStream
 
+	// atRestCipher is nil unless -encryption_key_file is set.
+	atRestCipher *encryption.Cipher
+
 	// Version is overwritten by Makefile.
 	Version = "unknown"
 
@@ -187,6 +290,81 @@ var (
 		},
 		[]string{"state"},
 	)
+
+	snapshotDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Subsystem: "fsm",
+			Name:      "snapshot_duration_seconds",
+			Help:      "How long taking a snapshot (including compaction) took",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+		},
+	)
+
+	restoreDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Subsystem: "fsm",
+			Name:      "restore_duration_seconds",
+			Help:      "How long restoring a snapshot took",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+		},
+	)
+
+	restoreEntries = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: "fsm",
+			Name:      "restore_entries",
+			Help:      "How many entries the current (or last) snapshot restore restored so far",
+		},
+	)
+
+	restoreBytesRead = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: "fsm",
+			Name:      "restore_bytes_read",
+			Help:      "How many bytes the current (or last) snapshot restore read so far",
+		},
+	)
+
+	restoreBytesTotal = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: "fsm",
+			Name:      "restore_bytes_total",
+			Help:      "Size of the snapshot which is (or was last) restored, 0 if unknown",
+		},
+	)
+
+	compactionExamined = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: "fsm",
+			Name:      "compaction_examined_entries",
+			Help:      "How many ircstore entries compaction examined",
+		},
+	)
+
+	compactionDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "fsm",
+			Name:      "compaction_dropped_entries",
+			Help:      "How many ircstore entries compaction dropped, partitioned by IRC command (or message type)",
+		},
+		[]string{"command"},
+	)
+
+	lastSnapshotIndex = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: "fsm",
+			Name:      "last_snapshot_index",
+			Help:      "Last raft index contained in the most recent snapshot",
+		},
+	)
+
+	irclogOldestEntryAge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: "fsm",
+			Name:      "irclog_oldest_entry_age_seconds",
+			Help:      "Age of the oldest irclog entry, only exported with -irclog_ttl",
+		},
+	)
 )
 
 func init() {
@@ -197,6 +375,15 @@ func init() {
 	prometheus.MustRegister(channelLimitGauge)
 	prometheus.MustRegister(appliedMessages)
 	prometheus.MustRegister(secondsInState)
+	prometheus.MustRegister(snapshotDuration)
+	prometheus.MustRegister(restoreDuration)
+	prometheus.MustRegister(restoreEntries)
+	prometheus.MustRegister(restoreBytesRead)
+	prometheus.MustRegister(restoreBytesTotal)
+	prometheus.MustRegister(compactionExamined)
+	prometheus.MustRegister(compactionDropped)
+	prometheus.MustRegister(lastSnapshotIndex)
+	prometheus.MustRegister(irclogOldestEntryAge)
 }
 
 func joinMaster(addr string) {
@@ -285,6 +472,33 @@ func printDefault(f *flag.Flag) {
 	fmt.Fprintf(os.Stderr, format, f.Name, f.DefValue, f.Usage)
 }
 
+// leveldbOptions returns the raftstore.Options specified by the -leveldb_*
+// flags, with synchronous writes if |sync|.
+func leveldbOptions(sync bool) raftstore.Options {
+	return raftstore.Options{
+		Sync:          sync,
+		WriteBuffer:   *leveldbWriteBufferSize,
+		NoCompression: *leveldbCompression == "none",
+	}
+}
+
+// configureLevelDBStore enables encryption (see -encryption_key_file), the
+// GetLog cache (see -getlog_cache_size) and background compaction (see
+// -compact_after_delete) of |store|.
+func configureLevelDBStore(store *raftstore.LevelDBStore) error {
+	if atRestCipher != nil {
+		store.EnableEncryption(atRestCipher)
+	}
+	if *compactAfterDelete {
+		store.EnableCompaction(*compactionMinInterval)
+	}
+	store.EnableMetrics()
+	if *getLogCacheSize == 0 {
+		return nil
+	}
+	return store.EnableCache(*getLogCacheSize)
+}
+
 func main() {
 	flag.Usage = func() {
 		// It is unfortunate that we need to re-implement flag.PrintDefaults(),
@@ -301,14 +515,42 @@ func main() {
 		fmt.Fprintf(os.Stderr, "The following flags are only relevant when bootstrapping the network (once):\n")
 		printDefault(flag.Lookup("join"))
 		printDefault(flag.Lookup("singlenode"))
+		printDefault(flag.Lookup("restore_from_archive"))
 		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, "The following flags are optional:\n")
+		printDefault(flag.Lookup("archive_channels"))
+		printDefault(flag.Lookup("archive_dir"))
+		printDefault(flag.Lookup("archive_interval"))
 		printDefault(flag.Lookup("dump_canary_state"))
+		printDefault(flag.Lookup("dump_compaction_report"))
 		printDefault(flag.Lookup("dump_heap_profile"))
+		printDefault(flag.Lookup("encryption_key_file"))
+		printDefault(flag.Lookup("fsck"))
+		printDefault(flag.Lookup("fsck_repair"))
+		printDefault(flag.Lookup("getlog_cache_size"))
+		printDefault(flag.Lookup("irclog_sync"))
+		printDefault(flag.Lookup("irclog_ttl"))
+		printDefault(flag.Lookup("leveldb_compression"))
+		printDefault(flag.Lookup("leveldb_write_buffer_size"))
 		printDefault(flag.Lookup("canary_compaction_start"))
+		printDefault(flag.Lookup("compact_after_delete"))
+		printDefault(flag.Lookup("compaction_min_interval"))
 		printDefault(flag.Lookup("listen"))
+		printDefault(flag.Lookup("local_query_staleness"))
+		printDefault(flag.Lookup("network_config"))
 		printDefault(flag.Lookup("raftdir"))
+		printDefault(flag.Lookup("raftlog_backend"))
+		printDefault(flag.Lookup("raftlog_sync"))
+		printDefault(flag.Lookup("request_client_certs"))
+		printDefault(flag.Lookup("services_listen"))
+		printDefault(flag.Lookup("snapshot_archive"))
+		printDefault(flag.Lookup("snapshot_checksums"))
+		printDefault(flag.Lookup("snapshot_compression"))
+		printDefault(flag.Lookup("state_hash_interval"))
+		printDefault(flag.Lookup("shed_apply_latency"))
+		printDefault(flag.Lookup("shed_queue_depth"))
 		printDefault(flag.Lookup("tls_ca_file"))
+		printDefault(flag.Lookup("warm_start"))
 		printDefault(flag.Lookup("robustirc_message_offset"))
 		printDefault(flag.Lookup("pre1.0_protobuf"))
 		printDefault(flag.Lookup("version"))
@@ -342,6 +584,7 @@ func main() {
 	if *version {
 		return
 	}
+	ircserver.Version = Version
 
 	if _, err := os.Stat(filepath.Join(*raftDir, "deletestate")); err == nil {
 		if err := os.RemoveAll(*raftDir); err != nil {
@@ -357,12 +600,27 @@ func main() {
 		log.Fatalf("Could not delete old outputstream databases: %v\n", err)
 	}
 
+	if err := api.DeleteOldBackups(*raftDir); err != nil {
+		log.Fatalf("Could not delete old backups: %v\n", err)
+	}
+
 	if err := deleteOldCompactionDatabases(*raftDir); err != nil {
 		glog.Errorf("Could not delete old compaction databases: %v (ignoring)\n", err)
 	}
 
 	log.Printf("Initializing RobustIRC…\n")
 
+	if *leveldbCompression != "snappy" && *leveldbCompression != "none" {
+		log.Fatalf("-leveldb_compression must be \"snappy\" or \"none\", not %q\n", *leveldbCompression)
+	}
+
+	if *encryptionKeyFile != "" {
+		var err error
+		if atRestCipher, err = encryption.LoadKeyFile(*encryptionKeyFile); err != nil {
+			log.Fatalf("-encryption_key_file: %v\n", err)
+		}
+	}
+
 	if *networkPassword == "" {
 		*networkPassword = os.Getenv("ROBUSTIRC_NETWORK_PASSWORD")
 	}
@@ -374,6 +632,21 @@ func main() {
 		log.Fatalf("-network_name not set, but required.\n")
 	}
 
+	if _, err := newSnapshotWriter(ioutil.Discard, *snapshotCompression); err != nil {
+		log.Fatalf("-snapshot_compression: %v\n", err)
+	}
+
+	var archived archiveTarget
+	if *restoreFromArchive != "" {
+		if !*singleNode {
+			log.Fatalf("-restore_from_archive requires -singlenode\n")
+		}
+		var err error
+		if archived, err = newArchiveTarget(*restoreFromArchive); err != nil {
+			log.Fatalf("-restore_from_archive: %v\n", err)
+		}
+	}
+
 	if *peerAddr == "" {
 		log.Printf("-peer_addr not set, initializing to %q. Make sure %q is a host:port string that other raft nodes can connect to!\n", *listen, *listen)
 		flag.Set("peer_addr", *listen)
@@ -386,10 +659,18 @@ func main() {
 		log.Fatalf("Could not create new outputstream: %v", err)
 	}
 
+	// Not deadlined, otherwise snapshot installments fail.
+	var raftClient rafthttp.Doer = robusthttp.Client(*networkPassword, false)
+	var partitions *partition.Table
+	if os.Getenv("ROBUSTIRC_TESTING_ENABLE_PARTITION_HOOKS") == "1" {
+		log.Printf("Partition testing hooks enabled, raft traffic can be dropped via /partition")
+		partitions = partition.NewTable()
+		raftClient = partitions.Wrap(raftClient)
+	}
+
 	transport := rafthttp.NewHTTPTransport(
 		raft.ServerAddress(*peerAddr),
-		// Not deadlined, otherwise snapshot installments fail.
-		robusthttp.Client(*networkPassword, false),
+		raftClient,
 		nil,
 		"")
 
@@ -399,10 +680,25 @@ func main() {
 		hclog.DefaultOptions)
 
 	// Keep 5 snapshots in *raftDir/snapshots, log to stderr.
-	fss, err := raft.NewFileSnapshotStoreWithLogger(*raftDir, 5, config.Logger)
+	fileSnapshots, err := raft.NewFileSnapshotStoreWithLogger(*raftDir, 5, config.Logger)
 	if err != nil {
 		log.Fatal(err)
 	}
+	var fss raft.SnapshotStore = fileSnapshots
+	if *snapshotArchive != "" {
+		target, err := newArchiveTarget(*snapshotArchive)
+		if err != nil {
+			log.Fatalf("-snapshot_archive: %v\n", err)
+		}
+		// Wraps fileSnapshots directly, so that encrypted snapshots are
+		// archived as-is.
+		fss = &archivingSnapshotStore{SnapshotStore: fss, target: target}
+	}
+	if atRestCipher != nil {
+		fss = &encryptingSnapshotStore{SnapshotStore: fss, cipher: atRestCipher}
+	}
+	// Opened snapshots carry their size, see restoreProgress.
+	fss = &sizedSnapshotStore{fss}
 
 	// How often to check whether a snapshot should be taken. The check is
 	// cheap, and the default value far too high for networks with a high
@@ -436,11 +732,55 @@ func main() {
 	metrics.NewGlobal(metrics.DefaultConfig("raftmetrics"), sink)
 
 	bootstrapping := *singleNode || *join != ""
-	logStore, err := raftstore.NewLevelDBStore(filepath.Join(*raftDir, "raftlog"), bootstrapping, *useProtobuf)
+	logStore, err := raftstore.OpenLogStore(*raftlogBackend, *raftDir, bootstrapping, *useProtobuf, leveldbOptions(*raftlogSync))
 	if err != nil {
 		log.Fatal(err)
 	}
-	ircStore, err = raftstore.NewLevelDBStore(filepath.Join(*raftDir, "irclog"), bootstrapping, *useProtobuf)
+	ircStore, err = raftstore.NewLevelDBStoreWithOptions(filepath.Join(*raftDir, "irclog"), bootstrapping, *useProtobuf, leveldbOptions(*irclogSync))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *fsck {
+		// Enables decryption, see -encryption_key_file.
+		if err := configureLevelDBStore(ircStore); err != nil {
+			log.Fatal(err)
+		}
+		if leveldbStore, ok := logStore.(*raftstore.LevelDBStore); ok {
+			if err := configureLevelDBStore(leveldbStore); err != nil {
+				log.Fatal(err)
+			}
+		}
+		ok, err := fsckStores(fss, logStore, ircStore, *fsckRepair)
+		if err != nil {
+			log.Fatalf("fsck: %v", err)
+		}
+		logStore.Close()
+		ircStore.Close()
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+	if !bootstrapping {
+		if ircStore, err = verifyStores(fss, logStore, ircStore); err != nil {
+			log.Fatalf("Refusing to start: %v", err)
+		}
+	}
+	if err := configureLevelDBStore(ircStore); err != nil {
+		log.Fatal(err)
+	}
+	// Requires the encryption key, see configureLevelDBStore.
+	if err := ircStore.BackfillSessionIndex(); err != nil {
+		log.Fatal(err)
+	}
+	if leveldbStore, ok := logStore.(*raftstore.LevelDBStore); ok {
+		if err := configureLevelDBStore(leveldbStore); err != nil {
+			log.Fatal(err)
+		}
+	} else if atRestCipher != nil {
+		log.Fatalf("-encryption_key_file is not supported with -raftlog_backend=%s\n", *raftlogBackend)
+	}
+	startIndex, err := logStore.LastIndex()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -448,10 +788,14 @@ func main() {
 		store:             logStore,
 		ircstore:          ircStore,
 		lastSnapshotState: make(map[uint64][]byte),
+		startIndex:        startIndex,
 		ReplaceState: func(*ircserver.IRCServer, *raftstore.LevelDBStore, *outputstream.OutputStream) {
 			// no-op, will be replaced down below with api.ReplaceState
 		},
 	}
+	if *stateHashInterval > 0 {
+		fsm.stateHashes = &stateHashes{}
+	}
 	logcache, err := raft.NewLogCache(config.MaxAppendEntries, logStore)
 	if err != nil {
 		log.Fatal(err)
@@ -491,7 +835,7 @@ func main() {
 		log.Fatal(err)
 	}
 
-	if *singleNode && *dumpCanaryState == "" {
+	if *singleNode && *dumpCanaryState == "" && *dumpCompactionReport == "" {
 		if err := node.BootstrapCluster(raft.Configuration{
 			Servers: []raft.Server{
 				raft.Server{
@@ -504,6 +848,20 @@ func main() {
 		}
 	}
 
+	if archived != nil {
+		for node.State() != raft.Leader {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if err := restoreArchivedSnapshot(node, archived); err != nil {
+			log.Fatalf("Could not restore from archive: %v", err)
+		}
+	}
+
+	if *dumpCompactionReport != "" {
+		writeCompactionReport(fsm, *dumpCompactionReport)
+		return
+	}
+
 	if *dumpCanaryState != "" {
 		canary(fsm, *dumpCanaryState)
 		if *dumpHeapProfile != "" {
@@ -528,6 +886,7 @@ func main() {
 	api := api.NewHTTP(
 		ircServer,
 		node,
+		fss,
 		ircStore,
 		outputStream,
 		transport,
@@ -537,9 +896,42 @@ func main() {
 		*peerAddr,
 		http.DefaultServeMux,
 		*useProtobuf,
-		*raftProtocolVersion)
+		*raftProtocolVersion,
+		*localQueryStaleness,
+		*shedApplyLatency,
+		*shedQueueDepth)
 
 	fsm.ReplaceState = api.ReplaceState
+	if fsm.stateHashes != nil {
+		api.EnableStateHashes(fsm.stateHashes)
+	}
+	if leveldbStore, ok := logStore.(*raftstore.LevelDBStore); ok {
+		api.SetRaftlog(leveldbStore)
+	}
+	shutdown := make(chan bool, 1)
+	fsm.ShutdownNode = func(restart bool) {
+		select {
+		case shutdown <- restart:
+		default:
+			// A shutdown is already in progress.
+		}
+	}
+	if partitions != nil {
+		api.EnablePartitionHooks(partitions)
+	}
+	if *archiveChannels != "" {
+		api.EnableArchive(http.DefaultServeMux, strings.Split(*archiveChannels, ","), *archiveDir, *archiveInterval)
+	}
+	if *irclogTTL > 0 {
+		pruner := &irclogPruner{
+			ttl:    *irclogTTL,
+			oldest: api.OldestIrclogEntry,
+			snapshot: func() error {
+				return node.Snapshot().Error()
+			},
+		}
+		go pruner.run()
+	}
 
 	srv := http.Server{Addr: *listen}
 	if err := http2.ConfigureServer(&srv, nil); err != nil {
@@ -554,6 +946,11 @@ func main() {
 		log.Fatal(err)
 	}
 	srv.TLSConfig.GetCertificate = kpr.GetCertificateFunc()
+	if *requestClientCerts {
+		// Client certificates are matched against the configured
+		// fingerprints, not verified against a CA.
+		srv.TLSConfig.ClientAuth = tls.RequestClientCert
+	}
 
 	ln, err := net.Listen("tcp", *listen)
 	if err != nil {
@@ -563,6 +960,25 @@ func main() {
 	tlsListener := tls.NewListener(tcpKeepAliveListener{ln.(*net.TCPListener)}, srv.TLSConfig)
 	go srv.Serve(tlsListener)
 
+	if *servicesListen != "" {
+		servicesSrv := http.Server{
+			Addr:    *servicesListen,
+			Handler: api.ServicesHandler(),
+			TLSConfig: &tls.Config{
+				GetCertificate: kpr.GetCertificateFunc(),
+				// Client certificates are verified against the configured
+				// fingerprints, not against a CA.
+				ClientAuth: tls.RequestClientCert,
+			},
+		}
+		servicesLn, err := net.Listen("tcp", *servicesListen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go servicesSrv.Serve(tls.NewListener(tcpKeepAliveListener{servicesLn.(*net.TCPListener)}, servicesSrv.TLSConfig))
+		log.Printf("Services listener on %q\n", *servicesListen)
+	}
+
 	log.Printf("RobustIRC listening on %q. For status, see %s\n",
 		*peerAddr,
 		fmt.Sprintf("https://robustirc:%s@%s/", *networkPassword, *peerAddr))
@@ -576,7 +992,8 @@ func main() {
 		// TODO(secure): properly handle joins on the server-side where the joining node is already in the network.
 	}
 
-	expireSessionsTimer := time.After(expireSessionsInterval)
+	var lastRehash uint64
+	expireSessionsTimer := time.After(ircserver.ExpireSessionsInterval)
 	secondTicker := time.Tick(1 * time.Second)
 	for {
 		select {
@@ -584,8 +1001,14 @@ func main() {
 			if node.State() == raft.Shutdown {
 				log.Fatal("Node removed from the network (in raft state shutdown), terminating.")
 			}
+		case restart := <-shutdown:
+			shutdownNode(api, restart)
 		case <-expireSessionsTimer:
-			expireSessionsTimer = time.After(expireSessionsInterval)
+			expireSessionsTimer = time.After(ircserver.ExpireSessionsInterval)
+
+			// Read activity is tracked locally on every node, so every node
+			// needs to forget about expired sessions.
+			api.PruneReadActivity()
 
 			// Race conditions (a node becoming a leader or ceasing to be the
 			// leader shortly before/after this runs) are okay, since the timer
@@ -600,6 +1023,57 @@ func main() {
 					log.Printf("Apply(): %v", err)
 				}
 			}
+			for _, msg := range ircServer.ExpireKlines() {
+				if err := api.ApplyMessageWait(msg, 10*time.Second); err != nil {
+					log.Printf("Apply(): %v", err)
+				}
+			}
+
+			// Each REHASH request is attempted only once per node: if the
+			// configuration file is invalid, the failure is reported to the
+			// IRC operators, who need to fix it and send REHASH again.
+			if id := ircServer.RehashRequested(); id != 0 && id != lastRehash {
+				lastRehash = id
+				err := errors.New("-network_config not specified")
+				if *networkConfig != "" {
+					err = api.ApplyConfigFile(*networkConfig)
+				}
+				if err != nil {
+					log.Printf("Cannot fulfill REHASH: %v", err)
+					failure := &robust.Message{Type: robust.RehashFailed, Data: err.Error()}
+					if err := api.ApplyMessageWait(failure, 10*time.Second); err != nil {
+						log.Printf("Apply(): %v", err)
+					}
+				}
+			}
 		}
 	}
 }
+
+// shutdownNode gracefully shuts down this node as requested via DIE or
+// RESTART: clients are asked to reconnect to a different node, raft
+// leadership is transferred (if applicable) and raft is shut down before the
+// process exits or re-executes itself.
+func shutdownNode(api *api.HTTP, restart bool) {
+	log.Printf("Shutting down (restart = %v) as requested by an IRC operator", restart)
+	api.Drain()
+	if node.State() == raft.Leader {
+		if err := node.LeadershipTransfer().Error(); err != nil {
+			log.Printf("Could not transfer leadership: %v", err)
+		}
+	}
+	if err := node.Shutdown().Error(); err != nil {
+		log.Printf("Could not shut down raft: %v", err)
+	}
+	if err := ircStore.Close(); err != nil {
+		log.Printf("Could not close irclog: %v", err)
+	}
+	if !restart {
+		os.Exit(0)
+	}
+	binary, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(syscall.Exec(binary, os.Args, os.Environ()))
+}
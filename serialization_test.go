@@ -25,7 +25,7 @@ func createIrcServer(tempdir string) (*raftstore.LevelDBStore, *raftstore.LevelD
 	}
 	flag.Set("raftdir", tempdir)
 
-	logstore, err := raftstore.NewLevelDBStore(filepath.Join(tempdir, "raftlog"), false, false)
+	logstore, err := raftstore.NewMemoryStore(false)
 	if err != nil {
 		return nil, nil, &FSM{}, err
 	}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/robustirc/robustirc/internal/encryption"
+)
+
+// archiveTarget is an object store to which snapshots are archived, see
+// -snapshot_archive. Keys are slash-separated, like object names in S3 or
+// GCS.
+type archiveTarget interface {
+	// Put stores the contents of |r| under |key|. Readers of |key| must
+	// never observe partial contents.
+	Put(key string, r io.Reader) error
+
+	// Get returns the contents stored under |key|.
+	Get(key string) (io.ReadCloser, error)
+
+	// List returns all keys starting with |prefix|.
+	List(prefix string) ([]string, error)
+}
+
+// newArchiveTarget returns the archiveTarget described by |spec|, which is
+// either a directory or a file:// URL.
+func newArchiveTarget(spec string) (archiveTarget, error) {
+	if idx := strings.Index(spec, "://"); idx > -1 {
+		switch scheme := spec[:idx]; scheme {
+		case "file":
+			spec = spec[idx+len("://"):]
+		default:
+			return nil, fmt.Errorf("unsupported archive target scheme %q, expected a directory or a file:// URL", scheme)
+		}
+	}
+	if !filepath.IsAbs(spec) {
+		return nil, fmt.Errorf("archive directory %q is not an absolute path", spec)
+	}
+	return &fileArchiveTarget{dir: spec}, nil
+}
+
+// fileArchiveTarget is an archiveTarget on a (typically network-mounted)
+// file system.
+type fileArchiveTarget struct {
+	dir string
+}
+
+func (f *fileArchiveTarget) Put(key string, r io.Reader) error {
+	fn := filepath.Join(f.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(fn), filepath.Base(fn)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fn)
+}
+
+func (f *fileArchiveTarget) Get(key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(f.dir, filepath.FromSlash(key)))
+}
+
+func (f *fileArchiveTarget) List(prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.Walk(f.dir, func(fn string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && fn == f.dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(f.dir, fn)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// archiveManifest describes an archived snapshot. It is stored after the
+// snapshot itself, so a snapshot is only considered archived once its
+// manifest exists.
+type archiveManifest struct {
+	Meta raft.SnapshotMeta
+
+	// SHA256 is the hex-encoded SHA-256 hash of the snapshot contents.
+	SHA256 string
+
+	Archived time.Time
+}
+
+func archiveStateKey(id string) string    { return path.Join("snapshots", id, "state.bin") }
+func archiveManifestKey(id string) string { return path.Join("snapshots", id, "manifest.json") }
+
+// archiveSnapshot copies snapshot |id| from |snapshots| to |target|.
+func archiveSnapshot(snapshots raft.SnapshotStore, target archiveTarget, id string) error {
+	meta, rc, err := snapshots.Open(id)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if err := target.Put(archiveStateKey(id), io.TeeReader(rc, h)); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(&archiveManifest{
+		Meta:     *meta,
+		SHA256:   hex.EncodeToString(h.Sum(nil)),
+		Archived: time.Now(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return target.Put(archiveManifestKey(id), bytes.NewReader(b))
+}
+
+// archivingSnapshotStore is a raft.SnapshotStore which archives each snapshot
+// to target once it was completely written.
+type archivingSnapshotStore struct {
+	raft.SnapshotStore
+	target archiveTarget
+}
+
+func (a *archivingSnapshotStore) Create(version raft.SnapshotVersion, index, term uint64, configuration raft.Configuration, configurationIndex uint64, trans raft.Transport) (raft.SnapshotSink, error) {
+	sink, err := a.SnapshotStore.Create(version, index, term, configuration, configurationIndex, trans)
+	if err != nil {
+		return nil, err
+	}
+	return &archivingSink{SnapshotSink: sink, store: a}, nil
+}
+
+// archivingSink archives the snapshot in a separate goroutine when it is
+// closed successfully, so that archiving does not delay raft.
+type archivingSink struct {
+	raft.SnapshotSink
+	store *archivingSnapshotStore
+}
+
+func (s *archivingSink) Close() error {
+	if err := s.SnapshotSink.Close(); err != nil {
+		return err
+	}
+	id := s.ID()
+	go func() {
+		start := time.Now()
+		if err := archiveSnapshot(s.store.SnapshotStore, s.store.target, id); err != nil {
+			log.Printf("Could not archive snapshot %q: %v", id, err)
+			return
+		}
+		log.Printf("Archived snapshot %q in %v", id, time.Since(start))
+	}()
+	return nil
+}
+
+// latestArchivedSnapshot returns the manifest of the archived snapshot with
+// the highest index.
+func latestArchivedSnapshot(target archiveTarget) (*archiveManifest, error) {
+	keys, err := target.List("snapshots/")
+	if err != nil {
+		return nil, err
+	}
+	var manifests []*archiveManifest
+	for _, key := range keys {
+		if path.Base(key) != "manifest.json" {
+			continue
+		}
+		rc, err := target.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		var m archiveManifest
+		err = json.NewDecoder(rc).Decode(&m)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		manifests = append(manifests, &m)
+	}
+	if len(manifests) == 0 {
+		return nil, fmt.Errorf("no archived snapshots found")
+	}
+	sort.Slice(manifests, func(i, j int) bool {
+		if manifests[i].Meta.Index != manifests[j].Meta.Index {
+			return manifests[i].Meta.Index > manifests[j].Meta.Index
+		}
+		return manifests[i].Meta.Term > manifests[j].Meta.Term
+	})
+	return manifests[0], nil
+}
+
+// verifyingReader returns an error instead of io.EOF if the data it read
+// does not match the expected SHA-256 hash.
+type verifyingReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want string
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	if err == io.EOF {
+		if got := hex.EncodeToString(v.h.Sum(nil)); got != v.want {
+			return n, fmt.Errorf("archived snapshot corrupted: got SHA-256 %s, want %s", got, v.want)
+		}
+	}
+	return n, err
+}
+
+// restoreArchivedSnapshot makes the raft leader |node| take on the state of
+// the latest snapshot archived in |target|, see -restore_from_archive.
+func restoreArchivedSnapshot(node *raft.Raft, target archiveTarget) error {
+	m, err := latestArchivedSnapshot(target)
+	if err != nil {
+		return err
+	}
+	log.Printf("Restoring archived snapshot %q (index %d, archived %v)", m.Meta.ID, m.Meta.Index, m.Archived)
+	rc, err := target.Get(archiveStateKey(m.Meta.ID))
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	// Snapshots are archived as stored, i.e. possibly encrypted (see
+	// -encryption_key_file), but node.Restore expects the plaintext.
+	r, _, err := encryption.MaybeDecrypt(&verifyingReader{
+		r:    rc,
+		h:    sha256.New(),
+		want: m.SHA256,
+	}, atRestCipher)
+	if err != nil {
+		return err
+	}
+	return node.Restore(&m.Meta, r, 0)
+}
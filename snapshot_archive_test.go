@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/robustirc/rafthttp"
+)
+
+func TestSnapshotArchive(t *testing.T) {
+	tempdir := t.TempDir()
+	fss, err := raft.NewFileSnapshotStore(tempdir, 5, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := newArchiveTarget("file://" + filepath.Join(tempdir, "archive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := latestArchivedSnapshot(target); err == nil {
+		t.Fatalf("latestArchivedSnapshot(): unexpectedly succeeded on an empty archive")
+	}
+
+	contents := map[uint64][]byte{
+		10: []byte("older snapshot"),
+		20: []byte("newer snapshot"),
+	}
+	for _, index := range []uint64{20, 10} {
+		sink, err := fss.Create(1, index, 1, raft.Configuration{}, 0, &rafthttp.HTTPTransport{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sink.Write(contents[index]); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := archiveSnapshot(fss, target, sink.ID()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := latestArchivedSnapshot(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.Meta.Index, uint64(20); got != want {
+		t.Fatalf("latestArchivedSnapshot(): got index %d, want %d", got, want)
+	}
+
+	rc, err := target.Get(archiveStateKey(m.Meta.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got, err := ioutil.ReadAll(&verifyingReader{r: rc, h: sha256.New(), want: m.SHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, contents[20]) {
+		t.Fatalf("archived snapshot: got %q, want %q", got, contents[20])
+	}
+
+	corrupted := &verifyingReader{
+		r:    bytes.NewReader([]byte("newer snapshoT")),
+		h:    sha256.New(),
+		want: m.SHA256,
+	}
+	if _, err := ioutil.ReadAll(corrupted); err == nil {
+		t.Fatalf("reading a corrupted archived snapshot unexpectedly succeeded")
+	}
+
+	if _, err := newArchiveTarget("s3://bucket/prefix"); err == nil {
+		t.Fatalf("newArchiveTarget(s3://…) unexpectedly succeeded")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io"
+	"log"
+
+	"github.com/hashicorp/raft"
+	"github.com/robustirc/robustirc/internal/encryption"
+)
+
+// encryptingSnapshotStore is a raft.SnapshotStore which encrypts snapshots
+// before they reach the underlying store, see -encryption_key_file.
+// Snapshots which were written unencrypted can still be opened.
+type encryptingSnapshotStore struct {
+	raft.SnapshotStore
+	cipher *encryption.Cipher
+}
+
+func (e *encryptingSnapshotStore) Create(version raft.SnapshotVersion, index, term uint64, configuration raft.Configuration, configurationIndex uint64, trans raft.Transport) (raft.SnapshotSink, error) {
+	sink, err := e.SnapshotStore.Create(version, index, term, configuration, configurationIndex, trans)
+	if err != nil {
+		return nil, err
+	}
+	w, err := e.cipher.NewWriter(sink)
+	if err != nil {
+		sink.Cancel()
+		return nil, err
+	}
+	return &encryptingSink{SnapshotSink: sink, w: w}, nil
+}
+
+func (e *encryptingSnapshotStore) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	meta, rc, err := e.SnapshotStore.Open(id)
+	if err != nil {
+		return meta, rc, err
+	}
+	r, encrypted, err := encryption.MaybeDecrypt(rc, e.cipher)
+	if err != nil {
+		rc.Close()
+		return nil, nil, err
+	}
+	if !encrypted {
+		log.Printf("Snapshot %q is not encrypted, it was written before -encryption_key_file was specified", id)
+	}
+	return meta, &decryptingReadCloser{Reader: r, Closer: rc}, nil
+}
+
+// encryptingSink encrypts everything written to it, see
+// encryptingSnapshotStore.
+type encryptingSink struct {
+	raft.SnapshotSink
+	w *encryption.Writer
+}
+
+func (s *encryptingSink) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+func (s *encryptingSink) Close() error {
+	if err := s.w.Close(); err != nil {
+		s.SnapshotSink.Cancel()
+		return err
+	}
+	return s.SnapshotSink.Close()
+}
+
+type decryptingReadCloser struct {
+	io.Reader
+	io.Closer
+}
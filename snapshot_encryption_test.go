@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/robustirc/rafthttp"
+	"github.com/robustirc/robustirc/internal/encryption"
+)
+
+func TestEncryptingSnapshotStore(t *testing.T) {
+	tempdir := t.TempDir()
+	fileSnapshots, err := raft.NewFileSnapshotStore(tempdir, 5, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := encryption.New(bytes.Repeat([]byte{0x23}, encryption.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fss := &encryptingSnapshotStore{SnapshotStore: fileSnapshots, cipher: c}
+
+	write := func(store raft.SnapshotStore, index uint64, contents []byte) string {
+		sink, err := store.Create(1, index, 1, raft.Configuration{}, 0, &rafthttp.HTTPTransport{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sink.Write(contents); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return sink.ID()
+	}
+	read := func(id string) []byte {
+		_, rc, err := fss.Open(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		b, err := ioutil.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	// Larger than one chunk, so that the stream consists of multiple chunks.
+	secret := bytes.Repeat([]byte("PRIVMSG alice :secret\n"), 10000)
+	encrypted := write(fss, 20, secret)
+	plain := []byte("written before -encryption_key_file")
+	unencrypted := write(fileSnapshots, 10, plain)
+
+	raw, err := ioutil.ReadFile(filepath.Join(tempdir, "snapshots", encrypted, "state.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("secret")) {
+		t.Fatalf("snapshot stored in plaintext")
+	}
+
+	if got := read(encrypted); !bytes.Equal(got, secret) {
+		t.Fatalf("encrypted snapshot: got %d bytes, want %d bytes", len(got), len(secret))
+	}
+	if got := read(unencrypted); !bytes.Equal(got, plain) {
+		t.Fatalf("unencrypted snapshot: got %q, want %q", got, plain)
+	}
+
+	// Truncated snapshots must not be restored partially.
+	truncated := raw[:len(raw)-100]
+	r, _, err := encryption.MaybeDecrypt(bytes.NewReader(truncated), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Fatalf("reading a truncated snapshot unexpectedly succeeded")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// maxStateHashes is the number of state hashes which are kept, see
+// -state_hash_interval.
+const maxStateHashes = 64
+
+// stateHash is the ircserver.StateDigest after applying the log entry with
+// raft index Index.
+type stateHash struct {
+	Index uint64
+	Hash  string
+}
+
+// stateHashes records state hashes at deterministic raft indexes so that
+// operators can compare them across nodes to detect state divergence.
+type stateHashes struct {
+	mu     sync.RWMutex
+	hashes []stateHash // oldest first
+}
+
+func (s *stateHashes) record(index uint64, hash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hashes = append(s.hashes, stateHash{Index: index, Hash: hash})
+	if len(s.hashes) > maxStateHashes {
+		s.hashes = append([]stateHash(nil), s.hashes[len(s.hashes)-maxStateHashes:]...)
+	}
+}
+
+// ServeHTTP serves all recorded state hashes as JSON, or only the state hash
+// for the raft index specified in the “index” parameter.
+func (s *stateHashes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hashes := s.hashes
+	if param := r.FormValue("index"); param != "" {
+		index, err := strconv.ParseUint(param, 0, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hashes = nil
+		for _, h := range s.hashes {
+			if h.Index == index {
+				hashes = []stateHash{h}
+				break
+			}
+		}
+		if len(hashes) == 0 {
+			http.Error(w, "No state hash recorded for index "+param, http.StatusNotFound)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(hashes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
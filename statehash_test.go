@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/robustirc/robustirc/internal/ircserver"
+	"github.com/robustirc/robustirc/internal/outputstream"
+	"github.com/robustirc/robustirc/internal/raftstore"
+)
+
+func TestStateHashes(t *testing.T) {
+	defer func(old uint64) { *stateHashInterval = old }(*stateHashInterval)
+	*stateHashInterval = 2
+
+	ircServer = ircserver.NewIRCServer("testnetwork", time.Now())
+	var err error
+	outputStream, err = outputstream.NewOutputStream("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ircstore, err := raftstore.NewMemoryStore(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsm := FSM{
+		ircstore:    ircstore,
+		stateHashes: &stateHashes{},
+	}
+
+	var logs []*raft.Log
+	logs = appendLog(logs, `{"Id": {"Id": 1}, "Type": 0, "Data": "auth"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 2}, "Session": {"Id": 1}, "Type": 2, "Data": "NICK sECuRE"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 3}, "Session": {"Id": 1}, "Type": 2, "Data": "USER blah 0 * :Michael Stapelberg"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 4}, "Session": {"Id": 1}, "Type": 2, "Data": "JOIN #chaos-hd"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 5}, "Session": {"Id": 1}, "Type": 2, "Data": "PRIVMSG #chaos-hd :heya"}`)
+	for _, log := range logs {
+		fsm.Apply(log)
+	}
+
+	hashes := fsm.stateHashes.hashes
+	if got, want := len(hashes), 2; got != want {
+		t.Fatalf("len(hashes): got %d, want %d", got, want)
+	}
+	if hashes[0].Index != 2 || hashes[1].Index != 4 {
+		t.Fatalf("hashes: got %+v, want indexes 2 and 4", hashes)
+	}
+	if hashes[1].Hash != ircServer.StateDigest() {
+		t.Fatalf("hash at index 4: got %q, want %q (PRIVMSG does not change the state)", hashes[1].Hash, ircServer.StateDigest())
+	}
+
+	rec := httptest.NewRecorder()
+	fsm.stateHashes.ServeHTTP(rec, httptest.NewRequest("GET", "/statehash?index=4", nil))
+	var got []stateHash
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := hashes[1:]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("/statehash?index=4: got %+v, want %+v", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	fsm.stateHashes.ServeHTTP(rec, httptest.NewRequest("GET", "/statehash?index=3", nil))
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Fatalf("/statehash?index=3: got HTTP status %d, want %d", got, want)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
@@ -10,6 +12,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -21,6 +24,8 @@ import (
 	"github.com/robustirc/robustirc/internal/outputstream"
 	"github.com/robustirc/robustirc/internal/raftstore"
 	"github.com/robustirc/robustirc/internal/robust"
+	"github.com/robustirc/robustirc/internal/snapshotframe"
+	"github.com/robustirc/robustirc/internal/snapshotmeta"
 	"github.com/stapelberg/glog"
 	"github.com/syndtr/goleveldb/leveldb"
 	"gopkg.in/sorcix/irc.v2"
@@ -30,7 +35,7 @@ import (
 
 type FSM struct {
 	// Used for invalidating messages of death.
-	store *raftstore.LevelDBStore
+	store raftstore.LogStore
 
 	ircstore *raftstore.LevelDBStore
 
@@ -45,6 +50,18 @@ type FSM struct {
 	sessionExpirationDur time.Duration
 
 	ReplaceState func(*ircserver.IRCServer, *raftstore.LevelDBStore, *outputstream.OutputStream)
+
+	// startIndex is the last raft log index at the time this process
+	// started. DIE and RESTART requests up to and including this index are
+	// replayed from the raft log and ignored.
+	startIndex uint64
+
+	// ShutdownNode is called when an IRC operator asked this node to shut
+	// down (DIE) or restart (RESTART). Must not block.
+	ShutdownNode func(restart bool)
+
+	// stateHashes is only set with -state_hash_interval.
+	stateHashes *stateHashes
 }
 
 func (fsm *FSM) sessionExpiration() time.Duration {
@@ -53,11 +70,22 @@ func (fsm *FSM) sessionExpiration() time.Duration {
 	return fsm.sessionExpirationDur
 }
 
+// maybeShutdown calls fsm.ShutdownNode if |req| is addressed to this node and
+// was appended to the raft log after this process started. The raft index is
+// used instead of the message timestamp because the latter comes from the
+// leader's clock.
+func (fsm *FSM) maybeShutdown(msg *robust.Message, req *ircserver.ShutdownRequest) {
+	if req.Node != *peerAddr || fsm.ShutdownNode == nil || msg.Id.Id-robust.MessageOffset <= fsm.startIndex {
+		return
+	}
+	fsm.ShutdownNode(req.Restart)
+}
+
 // sendMessages appends the specified batch of messages to the output,
 // marking them as a response to the incoming message with id 'id' and
-// associating them with session 'session'. IRC clients will
-// eventually receive these messages by calling GetNext.
-func sendMessages(reply *ircserver.Replyctx, session robust.Id, id uint64, o *outputstream.OutputStream) {
+// timestamp 'timestamp' and associating them with session 'session'. IRC
+// clients will eventually receive these messages by calling GetNext.
+func sendMessages(reply *ircserver.Replyctx, session robust.Id, id uint64, timestamp time.Time, o *outputstream.OutputStream) {
 	if len(reply.Messages) == 0 || o == nil {
 		return
 	}
@@ -68,6 +96,9 @@ func sendMessages(reply *ircserver.Replyctx, session robust.Id, id uint64, o *ou
 			Id:             msg.Id,
 			Data:           msg.Data,
 			InterestingFor: msg.InterestingFor,
+			Target:         msg.Target,
+			Seq:            msg.Seq,
+			UnixNano:       timestamp.UnixNano(),
 		}
 	}
 	if err := o.Add(converted); err != nil {
@@ -75,7 +106,10 @@ func sendMessages(reply *ircserver.Replyctx, session robust.Id, id uint64, o *ou
 	}
 }
 
-func (fsm *FSM) applyRobustMessage(msg *robust.Message, i *ircserver.IRCServer, o *outputstream.OutputStream) error {
+// applyRobustMessage applies |msg| to |i| and returns the IRC server’s reply,
+// if any.
+func (fsm *FSM) applyRobustMessage(msg *robust.Message, i *ircserver.IRCServer, o *outputstream.OutputStream) (*ircserver.Replyctx, error) {
+	var reply *ircserver.Replyctx
 	switch msg.Type {
 	case robust.MessageOfDeath:
 		// To prevent the message from being accepted again.
@@ -83,13 +117,16 @@ func (fsm *FSM) applyRobustMessage(msg *robust.Message, i *ircserver.IRCServer,
 		log.Printf("Skipped message of death with msgid %d.\n", msg.Id.Id)
 
 	case robust.CreateSession:
-		return i.CreateSession(msg.Id, msg.Data, msg.Timestamp())
+		if err := i.CheckBanned(msg.RemoteAddr, msg.Timestamp()); err != nil {
+			return nil, err
+		}
+		return nil, i.CreateSession(msg.Id, msg.Data, msg.Timestamp())
 	case robust.DeleteSession:
 		if _, err := i.GetSession(msg.Session); err == nil {
 			// TODO(secure): overwrite QUIT messages for services with an faq entry explaining that they are not robust yet.
-			reply := i.ProcessMessage(msg, irc.ParseMessage("QUIT :"+string(msg.Data)))
+			reply = i.ProcessMessage(msg, irc.ParseMessage("QUIT :"+string(msg.Data)))
 			i.SetLastProcessed(robust.Id{Id: msg.Id.Id})
-			sendMessages(reply, msg.Session, msg.Id.Id, o)
+			sendMessages(reply, msg.Session, msg.Id.Id, msg.Timestamp(), o)
 			i.MaybeDeleteSession(msg.Session)
 		}
 
@@ -99,10 +136,13 @@ func (fsm *FSM) applyRobustMessage(msg *robust.Message, i *ircserver.IRCServer,
 		if err := i.UpdateLastClientMessageID(msg); err != nil {
 			log.Printf("Error updating the last message for session: %v\n", err)
 		} else {
-			ircmsg := irc.ParseMessage(msg.Data)
-			reply := i.ProcessMessage(msg, ircmsg)
+			ircmsg := ircserver.ParseMessage(msg.Data)
+			reply = i.ProcessMessage(msg, ircmsg)
 			i.SetLastProcessed(robust.Id{Id: msg.Session.Id})
-			sendMessages(reply, msg.Session, msg.Session.Id, o)
+			sendMessages(reply, msg.Session, msg.Session.Id, msg.Timestamp(), o)
+			if req := i.TakeShutdownRequest(); req != nil {
+				fsm.maybeShutdown(msg, req)
+			}
 			i.MaybeDeleteSession(msg.Session)
 		}
 
@@ -111,6 +151,10 @@ func (fsm *FSM) applyRobustMessage(msg *robust.Message, i *ircserver.IRCServer,
 		if err != nil {
 			log.Printf("Skipping unexpectedly invalid configuration (%v)\n", err)
 		} else {
+			// Called before locking ConfigMu, as ConfigApplied locks
+			// sessionsMu, which must be locked first.
+			reply = i.ConfigApplied(msg, newCfg)
+			sendMessages(reply, msg.Session, msg.Id.Id, msg.Timestamp(), o)
 			i.ConfigMu.Lock()
 			defer i.ConfigMu.Unlock()
 			i.Config = newCfg
@@ -119,8 +163,55 @@ func (fsm *FSM) applyRobustMessage(msg *robust.Message, i *ircserver.IRCServer,
 			defer fsm.sessionExpirationMu.Unlock()
 			fsm.sessionExpirationDur = time.Duration(i.Config.SessionExpiration)
 		}
+
+	case robust.ChannelImport:
+		var err error
+		reply, err = i.ImportChannel(msg)
+		if err != nil {
+			log.Printf("Skipping unexpectedly invalid channel import (%v)\n", err)
+		} else {
+			sendMessages(reply, msg.Session, msg.Id.Id, msg.Timestamp(), o)
+		}
+
+	case robust.ExpireKline:
+		reply = i.ExpireKline(msg)
+		sendMessages(reply, msg.Session, msg.Id.Id, msg.Timestamp(), o)
+
+	case robust.RehashFailed:
+		reply = i.RehashFailed(msg)
+		sendMessages(reply, msg.Session, msg.Id.Id, msg.Timestamp(), o)
+
+	case robust.ResolvedHostname:
+		if err := i.SetHostname(msg); err != nil {
+			log.Printf("Skipping hostname for session %v (%v)\n", msg.Session, err)
+		}
 	}
-	return nil
+	return reply, nil
+}
+
+// relevantSessions returns the ids of all sessions for which |msg| is
+// relevant, i.e. the session which sent |msg| and all sessions which are
+// interested in |reply|.
+func relevantSessions(msg *robust.Message, reply *ircserver.Replyctx) []uint64 {
+	seen := make(map[uint64]bool)
+	if msg.Session.Id != 0 {
+		seen[msg.Session.Id] = true
+	}
+	if reply != nil {
+		for _, m := range reply.Messages {
+			for session, interested := range m.InterestingFor {
+				if interested {
+					seen[session] = true
+				}
+			}
+		}
+	}
+	sessions := make([]uint64, 0, len(seen))
+	for session := range seen {
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i] < sessions[j] })
+	return sessions
 }
 
 func (fsm *FSM) applyProto(l *pb.RaftLog, msg *robust.Message) interface{} {
@@ -165,7 +256,10 @@ func (fsm *FSM) applyProto(l *pb.RaftLog, msg *robust.Message) interface{} {
 		}
 	}()
 
-	err := fsm.applyRobustMessage(msg, ircServer, outputStream)
+	reply, err := fsm.applyRobustMessage(msg, ircServer, outputStream)
+	if err := fsm.ircstore.IndexSessions(l.Index, relevantSessions(msg, reply)); err != nil {
+		glog.Errorf("Could not index message %d in irclogs/: %v", l.Index, err)
+	}
 
 	appliedMessages.WithLabelValues(msg.Type.String()).Inc()
 
@@ -198,33 +292,16 @@ func (fsm *FSM) Apply(l *raft.Log) interface{} {
 
 	msg := robust.NewMessageFromBytes(l.Data, robust.IdFromRaftIndex(l.Index))
 	glog.Infof("Apply(msg.Type=%s)\n", msg.Type)
-	return fsm.applyProto(&p, &msg)
-}
-
-// Snapshot returns a raftSnapshot, containing a snapshot of the
-// IRCServer state and all messages which cannot be compacted yet
-// because they are too new.  After restoring that snapshot, the
-// server state (current sessions, channels, modes, …) should be
-// identical to the state before taking the snapshot.
-func (fsm *FSM) Snapshot() (raft.FSMSnapshot, error) {
-	start := time.Now()
-	defer metrics.MeasureSince([]string{"robustirc", "fsm", "snapshot"}, start)
-
-	first, err := fsm.ircstore.FirstIndex()
-	if err != nil {
-		return nil, err
-	}
-
-	last, err := fsm.ircstore.LastIndex()
-	if err != nil {
-		return nil, err
-	}
-	if first < 1 {
-		return nil, fmt.Errorf("first index of ircstore (%d) is < 1", first)
+	result := fsm.applyProto(&p, &msg)
+	if fsm.stateHashes != nil && l.Index%*stateHashInterval == 0 {
+		fsm.stateHashes.record(l.Index, ircServer.StateDigest())
 	}
+	return result
+}
 
-	log.Printf("Filtering and writing up to %d indexes (from %d to %d)\n", last-first, first, last)
-
+// compactionEnd returns the point in time up to which log entries are
+// compacted.
+func (fsm *FSM) compactionEnd() time.Time {
 	// Get a timestamp and keep it constant, so that we only compact messages
 	// older than n days from compactionStart. If we used time.Since, new
 	// messages would pour into the window on every compaction round, possibly
@@ -236,21 +313,18 @@ func (fsm *FSM) Snapshot() (raft.FSMSnapshot, error) {
 		log.Printf("compactionStart %s (overridden with -canary_compaction_start)\n", compactionStart.String())
 	}
 
-	exp := fsm.sessionExpiration()
-	if exp == 0 {
-		// in case the config does not set SessionExpiration at all
-		exp = 10 * time.Minute
-	}
-	// Keep messages for as long as they could possibly be useful to continue a
-	// hanging session (think a user who suspends their notebook, walks around
-	// for 9m, opens the notebook and wants to resume the same session in
-	// RobustIRC).
-	exp += expireSessionsInterval
+	exp := ircserver.RetentionWindow(fsm.sessionExpiration())
 	log.Printf("sessionExpiration is %v", exp)
-	compactionEnd := compactionStart.Add(-1 * exp)
+	return compactionStart.Add(-1 * exp)
+}
 
+// compactedState returns an IRCServer containing the state of the previous
+// snapshot, i.e. of all log entries before |first|. If |prune| is true, all
+// other snapshot states are discarded.
+func (fsm *FSM) compactedState(first uint64, prune bool) (*ircserver.IRCServer, error) {
 	tmpServer := ircserver.NewIRCServer("testnetwork", time.Now())
-	if oldState, ok := fsm.lastSnapshotState[first-1]; !ok {
+	oldState, ok := fsm.lastSnapshotState[first-1]
+	if !ok {
 		if first == 1 {
 			// This is the first snapshot which this RobustIRC network
 			// is taking, there cannot be previous state.
@@ -258,10 +332,12 @@ func (fsm *FSM) Snapshot() (raft.FSMSnapshot, error) {
 			// XXX(1.0): Reword the message once compatibility is broken.
 			glog.Errorf("No snapshot state containing index %d found. Unless you just upgraded this node from v0.3, this is a BUG.", first-1)
 		}
-	} else {
-		if _, err := tmpServer.Unmarshal(oldState); err != nil {
-			return nil, err
-		}
+		return tmpServer, nil
+	}
+	if _, err := tmpServer.Unmarshal(oldState); err != nil {
+		return nil, err
+	}
+	if prune {
 		// All snapshot states but first-1 can now be deleted. first-1
 		// needs to be retained in case the snapshot which is
 		// currently in progress fails and needs to be repeated.
@@ -272,58 +348,157 @@ func (fsm *FSM) Snapshot() (raft.FSMSnapshot, error) {
 			delete(fsm.lastSnapshotState, key)
 		}
 	}
+	return tmpServer, nil
+}
 
-	iterator := fsm.ircstore.GetBulkIterator(first, last+1)
-	defer iterator.Release()
-	available := iterator.First()
-	for available {
-		var nlog raft.Log
-		if err := iterator.Error(); err != nil {
-			return nil, err
+// compactionEntry is an ircstore entry decoded by decodeForCompaction.
+type compactionEntry struct {
+	index  uint64
+	msg    robust.Message
+	ircmsg *irc.Message
+	err    error
+}
+
+// decodeForCompaction decodes the ircstore entries from |first| to |last| in
+// a separate goroutine, so that decoding overlaps with applying the entries.
+// Decoding stops early once |done| is closed.
+func (fsm *FSM) decodeForCompaction(first, last uint64, done <-chan struct{}) <-chan compactionEntry {
+	entries := make(chan compactionEntry, 1024)
+	go func() {
+		defer close(entries)
+		send := func(e compactionEntry) bool {
+			select {
+			case entries <- e:
+				return true
+			case <-done:
+				return false
+			}
 		}
-		i := binary.BigEndian.Uint64(iterator.Key())
-		value := iterator.Value()
-		if len(value) > 0 && value[0] == 'p' {
-			var p pb.RaftLog
-			if err := proto.Unmarshal(value[1:], &p); err != nil {
-				glog.Errorf("Skipping log entry %d because of a proto unmarshaling error: %v", i, err)
-				available = iterator.Next()
-				continue
+		iterator := fsm.ircstore.GetBulkIterator(first, last+1)
+		defer iterator.Release()
+		for available := iterator.First(); available; available = iterator.Next() {
+			var nlog raft.Log
+			if err := iterator.Error(); err != nil {
+				send(compactionEntry{err: err})
+				return
 			}
-			nlog.Index = p.Index
-			nlog.Term = p.Term
-			nlog.Type = raft.LogType(p.Type)
-			nlog.Data = p.Data
-			nlog.Extensions = p.Extensions
-		} else {
-			// XXX(1.0): delete this branch, ircstore uses proto
-			if err := json.Unmarshal(value, &nlog); err != nil {
-				glog.Errorf("Skipping log entry %d because of a JSON unmarshaling error: %v", i, err)
-				available = iterator.Next()
+			i := binary.BigEndian.Uint64(iterator.Key())
+			if err := unmarshalLog(iterator.Value(), &nlog); err != nil {
+				glog.Errorf("Skipping log entry %d: %v", i, err)
 				continue
 			}
+			if nlog.Type != raft.LogCommand {
+				send(compactionEntry{err: fmt.Errorf("nlog.Type = %d instead of LogCommand", nlog.Type)})
+				return
+			}
+			e := compactionEntry{
+				index: i,
+				msg:   robust.NewMessageFromBytes(nlog.Data, robust.IdFromRaftIndex(nlog.Index)),
+			}
+			if e.msg.Type == robust.IRCFromClient {
+				e.ircmsg = ircserver.ParseMessage(e.msg.Data)
+			}
+			if !send(e) {
+				return
+			}
 		}
-		available = iterator.Next()
+	}()
+	return entries
+}
 
-		if nlog.Type != raft.LogCommand {
-			return nil, fmt.Errorf("nlog.Type = %d instead of LogCommand", nlog.Type)
+// compact applies the ircstore entries from |first| to |last| which are older
+// than |compactionEnd| to |tmpServer|, until an entry is retained (see
+// retained). |folded| is called for each applied entry. compact
+// returns the index of the first entry which was not applied and the number
+// of entries which were examined.
+//
+// Applying is inherently sequential (later entries depend on the state
+// created by earlier ones), so only decoding happens concurrently.
+func (fsm *FSM) compact(first, last uint64, compactionEnd time.Time, tmpServer *ircserver.IRCServer, folded func(i uint64, msg *robust.Message, ircmsg *irc.Message)) (uint64, int, error) {
+	done := make(chan struct{})
+	defer close(done)
+	var examined int
+	for e := range fsm.decodeForCompaction(first, last, done) {
+		if e.err != nil {
+			return 0, examined, e.err
 		}
-
-		parsed := robust.NewMessageFromBytes(nlog.Data, robust.IdFromRaftIndex(nlog.Index))
-		if parsed.Timestamp().After(compactionEnd) {
-			first = i
-			break
+		examined++
+		if e.msg.Timestamp().After(compactionEnd) {
+			return e.index, examined, nil
+		}
+		if retained(&e.msg, e.ircmsg) {
+			return e.index, examined, nil
 		}
 
-		fsm.applyRobustMessage(&parsed, tmpServer, nil)
+		fsm.applyRobustMessage(&e.msg, tmpServer, nil)
+		folded(e.index, &e.msg, e.ircmsg)
+	}
+	return first, examined, nil
+}
 
-		if !fsm.skipDeletionForCanary {
+// Snapshot returns a raftSnapshot, containing a snapshot of the
+// IRCServer state and all messages which cannot be compacted yet
+// because they are too new.  After restoring that snapshot, the
+// server state (current sessions, channels, modes, …) should be
+// identical to the state before taking the snapshot.
+func (fsm *FSM) Snapshot() (raft.FSMSnapshot, error) {
+	start := time.Now()
+	defer metrics.MeasureSince([]string{"robustirc", "fsm", "snapshot"}, start)
+	defer func() {
+		snapshotDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	first, err := fsm.ircstore.FirstIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := fsm.ircstore.LastIndex()
+	if err != nil {
+		return nil, err
+	}
+	if first < 1 {
+		return nil, fmt.Errorf("first index of ircstore (%d) is < 1", first)
+	}
+
+	log.Printf("Filtering and writing up to %d indexes (from %d to %d)\n", last-first, first, last)
+
+	compactionEnd := fsm.compactionEnd()
+
+	tmpServer, err := fsm.compactedState(first, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compacted entries are deleted in a separate goroutine, overlapping
+	// with applying the next entries.
+	type deletion struct {
+		index uint64
+		id    robust.Id
+	}
+	deletions := make(chan deletion, 1024)
+	deleted := make(chan struct{})
+	go func(o *outputstream.OutputStream) {
+		defer close(deleted)
+		for d := range deletions {
 			// TODO: make the following more efficient, we can whack out the entire range at once.
-			if err := outputStream.Delete(parsed.Id); err != nil {
+			if err := o.Delete(d.id); err != nil {
 				log.Panicf("Could not delete outputstream message: %v\n", err)
 			}
-			fsm.ircstore.DeleteRange(i, i)
+			fsm.ircstore.DeleteRange(d.index, d.index)
 		}
+	}(outputStream)
+	first, examined, err := fsm.compact(first, last, compactionEnd, tmpServer, func(i uint64, msg *robust.Message, ircmsg *irc.Message) {
+		compactionDropped.WithLabelValues(compactionKey(msg, ircmsg)).Inc()
+		if !fsm.skipDeletionForCanary {
+			deletions <- deletion{index: i, id: msg.Id}
+		}
+	})
+	close(deletions)
+	<-deleted
+	compactionExamined.Add(float64(examined))
+	if err != nil {
+		return nil, err
 	}
 
 	state, err := tmpServer.Marshal(first - 1)
@@ -333,60 +508,189 @@ func (fsm *FSM) Snapshot() (raft.FSMSnapshot, error) {
 
 	fsm.lastSnapshotState[first-1] = state
 
+	var warm *warmState
+	if *warmStart {
+		// Snapshot() is not called concurrently with Apply(), so ircServer
+		// contains exactly the log entries up to and including last.
+		full, err := ircServer.Marshal(last)
+		if err != nil {
+			return nil, err
+		}
+		warm = &warmState{
+			lastIndex: last,
+			compacted: sha256.Sum256(state),
+			state:     full,
+		}
+	}
+
+	// Taken after compacting, so that the deleted log entries are not
+	// part of the snapshot.
+	snap, err := fsm.ircstore.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	lastSnapshotIndex.Set(float64(last))
+
 	return &robustSnapshot{
 		firstIndex:    first,
 		lastIndex:     last,
 		state:         state,
-		store:         fsm.ircstore,
+		snap:          snap,
 		compactionEnd: compactionEnd,
+		warmState:     warm,
+		summary: snapshotmeta.Summary{
+			Sessions:           ircServer.NumSessions(),
+			Channels:           ircServer.NumChannels(),
+			CompactionDuration: time.Since(start),
+		},
 	}, err
 }
 
+// unmarshalLog decodes an ircstore entry, which is either protobuf-encoded
+// (prefixed with 'p') or JSON-encoded.
+func unmarshalLog(value []byte, nlog *raft.Log) error {
+	if len(value) > 0 && value[0] == 'p' {
+		var p pb.RaftLog
+		if err := proto.Unmarshal(value[1:], &p); err != nil {
+			return fmt.Errorf("proto unmarshaling error: %v", err)
+		}
+		nlog.Index = p.Index
+		nlog.Term = p.Term
+		nlog.Type = raft.LogType(p.Type)
+		nlog.Data = p.Data
+		nlog.Extensions = p.Extensions
+		return nil
+	}
+	// XXX(1.0): delete this branch, ircstore uses proto
+	if err := json.Unmarshal(value, nlog); err != nil {
+		return fmt.Errorf("JSON unmarshaling error: %v", err)
+	}
+	return nil
+}
+
 func (fsm *FSM) Restore(snap io.ReadCloser) error {
 	start := time.Now()
 	defer metrics.MeasureSince([]string{"robustirc", "fsm", "restore"}, start)
+	defer func() {
+		restoreDuration.Observe(time.Since(start).Seconds())
+	}()
 
 	log.Printf("Restoring snapshot\n")
 	defer snap.Close()
 
-	if err := fsm.ircstore.Close(); err != nil {
-		log.Fatal(err)
-	}
-	// Deleting irclog and creating a new database is significantly faster than
-	// using DeleteRange() on the entire keyspace. Re-creating the database
-	// saves us 4 minutes of CPU time (out of 5 minutes total!) and >1G of
-	// memory usage.
+	// The snapshot is restored into a staging irclog and a new IRCServer,
+	// which replace the current ones only once the entire snapshot was
+	// restored. A crash (or a truncated snapshot) mid-restore hence never
+	// leaves a partially restored irclog behind, and a left-over staging
+	// irclog is discarded.
+	//
+	// Creating a new database is significantly faster than using
+	// DeleteRange() on the entire keyspace. Re-creating the database saves
+	// us 4 minutes of CPU time (out of 5 minutes total!) and >1G of memory
+	// usage.
 	irclogPath := filepath.Join(*raftDir, "irclog")
-	if err := os.RemoveAll(irclogPath); err != nil {
+	stagingPath := irclogPath + ".restore"
+	if err := os.RemoveAll(stagingPath); err != nil {
 		log.Fatal(err)
 	}
-	var err error
-	ircStore, err = raftstore.NewLevelDBStore(irclogPath, true, *useProtobuf)
+	staging, err := raftstore.NewLevelDBStoreWithOptions(stagingPath, true, *useProtobuf, leveldbOptions(*irclogSync))
 	if err != nil {
 		log.Fatal(err)
 	}
-	fsm.ircstore = ircStore
-	if err := outputStream.Close(); err != nil {
-		glog.Error(err)
+	if err := configureLevelDBStore(staging); err != nil {
+		log.Fatal(err)
+	}
+	stagingOutput, err := outputstream.NewOutputStream(*raftDir)
+	if err != nil {
+		log.Fatal(err)
 	}
 
+	// decodeSnapshot applies the snapshot to the global state, so it is
+	// pointed to the staging state until decoding succeeded.
+	oldServer, oldOutput, oldStore := ircServer, outputStream, fsm.ircstore
+	oldSnapshotState := fsm.lastSnapshotState
+	fsm.lastSnapshotState = make(map[uint64][]byte, len(oldSnapshotState))
+	for index, state := range oldSnapshotState {
+		fsm.lastSnapshotState[index] = state
+	}
 	ircServer = ircserver.NewIRCServer(*network, time.Now())
-	outputStream, err = outputstream.NewOutputStream(*raftDir)
+	outputStream = stagingOutput
+	fsm.ircstore = staging
+
+	progress := newRestoreProgress(snap)
+	if err := fsm.decodeSnapshot(bufio.NewReader(progress), progress); err != nil {
+		ircServer, outputStream, fsm.ircstore = oldServer, oldOutput, oldStore
+		fsm.lastSnapshotState = oldSnapshotState
+		if err := stagingOutput.Close(); err != nil {
+			glog.Error(err)
+		}
+		if err := staging.Close(); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.RemoveAll(stagingPath); err != nil {
+			log.Fatal(err)
+		}
+		return err
+	}
+	progress.done()
+
+	if err := oldOutput.Close(); err != nil {
+		glog.Error(err)
+	}
+	if err := oldStore.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := staging.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.RemoveAll(irclogPath); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Rename(stagingPath, irclogPath); err != nil {
+		log.Fatal(err)
+	}
+	ircStore, err = raftstore.NewLevelDBStoreWithOptions(irclogPath, false, *useProtobuf, leveldbOptions(*irclogSync))
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := configureLevelDBStore(ircStore); err != nil {
+		log.Fatal(err)
+	}
+	fsm.ircstore = ircStore
 	fsm.ReplaceState(ircServer, ircStore, outputStream)
+	return nil
+}
+
+// decodeSnapshot restores the snapshot read from |b|, which is in any of the
+// formats robustirc ever wrote.
+func (fsm *FSM) decodeSnapshot(b *bufio.Reader, progress *restoreProgress) error {
 	// XXX(1.0): remove this conditional, all snapshots are protobuf-encoded now
-	b := bufio.NewReader(snap)
+	if magic, err := b.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		// gzip-compressed snapshot, see -snapshot_compression
+		zr, err := gzip.NewReader(b)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		b = bufio.NewReader(zr)
+	}
 	first, err := b.Peek(1)
 	if err != nil {
 		return err
 	}
+	if first[0] == snapshotframe.Magic {
+		// checksummed protobuf snapshot, see -snapshot_checksums
+		r, err := snapshotframe.NewReader(b)
+		if err != nil {
+			return err
+		}
+		return fsm.decodeProtobuf(progress.records(r.Next))
+	}
 	if first[0] == 'p' {
 		// protobuf snapshot prefix (invalid JSON)
-		return fsm.decodeProtobuf(b)
+		return fsm.decodeProtobuf(progress.records(readLenPrefixed(b)))
 	}
-	if err := fsm.decodeJson(b); err != nil {
+	if err := fsm.decodeJson(b, progress); err != nil {
 		return err
 	}
 	if *useProtobuf {
@@ -395,29 +699,39 @@ func (fsm *FSM) Restore(snap io.ReadCloser) error {
 	return nil
 }
 
-func (fsm *FSM) decodeProtobuf(b *bufio.Reader) error {
+// decodeProtobuf restores the snapshot records returned by |next|, which
+// returns io.EOF after the last record. Entries which were applied before
+// |next| detected a truncated or corrupted snapshot are not rolled back here:
+// Restore discards the staging state they were applied to.
+func (fsm *FSM) decodeProtobuf(next func() ([]byte, error)) error {
 	start := time.Now()
 	log.Printf("decoding protobuf snapshot")
-	// discard leading 'p'
-	if _, err := b.ReadByte(); err != nil {
-		return err
-	}
 	var (
 		lenbuf [8]byte // binary.Size(uint64(0))
 		entry  pb.RaftLog
 		batch  leveldb.Batch
+
+		// Only set with -warm_start: log entries are stored, but not
+		// applied, see restoreWarmState.
+		warm        *warmState
+		compacted   [sha256.Size]byte
+		first, last uint64
 	)
+	if *warmStart {
+		var err error
+		if warm, err = readWarmState(); err != nil {
+			log.Printf("Not using warm state, replaying all log entries: %v", err)
+			warm = nil
+		}
+	}
 	for {
-		if _, err := io.ReadFull(b, lenbuf[:]); err != nil {
+		buf, err := next()
+		if err != nil {
 			if err == io.EOF {
 				break
 			}
 			return err
 		}
-		buf := make([]byte, binary.BigEndian.Uint64(lenbuf[:]))
-		if _, err := io.ReadFull(b, buf); err != nil {
-			return err
-		}
 		if len(buf) > 0 {
 			if got, want := buf[0], byte('p'); got != want {
 				return fmt.Errorf("unexpected first byte: got %v, want %v", got, want)
@@ -427,6 +741,10 @@ func (fsm *FSM) decodeProtobuf(b *bufio.Reader) error {
 			return err
 		}
 		msg := robust.NewMessageFromBytes(entry.Data, robust.IdFromRaftIndex(entry.Index))
+		if msg.Type == robust.SnapshotSummary {
+			// Only informational, see snapshotmeta.Read.
+			continue
+		}
 		if msg.Type == robust.State {
 			log.Printf("found RobustState, unmarshalling\n")
 			state, err := base64.StdEncoding.DecodeString(msg.Data)
@@ -439,6 +757,8 @@ func (fsm *FSM) decodeProtobuf(b *bufio.Reader) error {
 			}
 			log.Printf("storing RobustState as index %d\n", lastIncludedIndex)
 			fsm.lastSnapshotState[lastIncludedIndex] = state
+			compacted = sha256.Sum256(state)
+			first, last = lastIncludedIndex+1, lastIncludedIndex
 			continue
 		}
 
@@ -451,17 +771,27 @@ func (fsm *FSM) decodeProtobuf(b *bufio.Reader) error {
 			batch.Reset()
 		}
 
+		if warm != nil {
+			last = entry.Index
+			continue
+		}
 		fsm.applyProto(&entry, &msg)
 	}
 	if err := fsm.ircstore.WriteBatch(&batch); err != nil {
 		return err
 	}
 
+	if warm != nil {
+		if err := fsm.restoreWarmState(warm, compacted, first, last); err != nil {
+			return err
+		}
+	}
+
 	log.Printf("Restored snapshot in %v", time.Since(start))
 	return nil
 }
 
-func (fsm *FSM) decodeJson(b *bufio.Reader) error {
+func (fsm *FSM) decodeJson(b *bufio.Reader, progress *restoreProgress) error {
 	start := time.Now()
 	log.Printf("decoding JSON snapshot")
 	decoder := json.NewDecoder(b)
@@ -491,6 +821,7 @@ func (fsm *FSM) decodeJson(b *bufio.Reader) error {
 		}
 
 		fsm.Apply(&entry)
+		progress.entry()
 	}
 
 	log.Printf("Restored snapshot in %v", time.Since(start))
@@ -37,7 +37,7 @@ func TestPlumbing(t *testing.T) {
 
 	msgid := robust.Id{Id: uint64(time.Now().UnixNano())}
 	replies := i.ProcessMessage(&robust.Message{Id: msgid, Session: ids["secure"]}, irc.ParseMessage("JOIN #test"))
-	sendMessages(replies, ids["secure"], msgid.Id, o)
+	sendMessages(replies, ids["secure"], msgid.Id, time.Unix(0, int64(msgid.Id)), o)
 	got, ok := o.Get(msgid)
 	if !ok {
 		t.Fatalf("_, ok := Get(%d); got false, want true", msgid.Id)
@@ -51,7 +51,7 @@ func TestPlumbing(t *testing.T) {
 
 	nextid := robust.Id{Id: uint64(time.Now().UnixNano())}
 	replies = i.ProcessMessage(&robust.Message{Id: nextid, Session: ids["secure"]}, irc.ParseMessage("JOIN #foobar"))
-	sendMessages(replies, ids["secure"], nextid.Id, o)
+	sendMessages(replies, ids["secure"], nextid.Id, time.Unix(0, int64(nextid.Id)), o)
 	got = o.GetNext(context.TODO(), msgid)
 	if !ok {
 		t.Fatalf("_, ok := Get(%d); got false, want true", msgid.Id)
@@ -71,9 +71,50 @@ func TestPlumbing(t *testing.T) {
 
 	msgid = robust.Id{Id: uint64(time.Now().UnixNano())}
 	replies = i.ProcessMessage(&robust.Message{Id: msgid, Session: ids["secure"]}, irc.ParseMessage("JOIN #baz"))
-	sendMessages(replies, ids["secure"], msgid.Id, o)
+	sendMessages(replies, ids["secure"], msgid.Id, time.Unix(0, int64(msgid.Id)), o)
 	got, _ = o.Get(msgid)
 	if !got[0].InterestingFor[ids["mero"].Id] {
 		t.Fatalf("sMero not interestedIn JOIN to #baz, expected true")
 	}
 }
+
+func TestMaybeShutdown(t *testing.T) {
+	oldPeerAddr := *peerAddr
+	defer func() { *peerAddr = oldPeerAddr }()
+	*peerAddr = "localhost:13001"
+
+	const startIndex = 42
+	var requests []bool
+	fsm := &FSM{
+		startIndex: startIndex,
+		ShutdownNode: func(restart bool) {
+			requests = append(requests, restart)
+		},
+	}
+
+	for _, tt := range []struct {
+		node     string
+		index    uint64
+		restart  bool
+		shutdown bool
+	}{
+		{"localhost:13001", startIndex - 1, false, false}, // replayed
+		{"localhost:13001", startIndex, true, false},      // replayed
+		{"localhost:13002", startIndex + 1, false, false}, // other node
+		{"localhost:13001", startIndex + 1, true, true},
+	} {
+		requests = nil
+		// The timestamp must not matter, the leader's clock may be off.
+		msg := &robust.Message{
+			Id:       robust.Id{Id: robust.IdFromRaftIndex(tt.index)},
+			UnixNano: time.Unix(0, 1481144012969203276).UnixNano(),
+		}
+		fsm.maybeShutdown(msg, &ircserver.ShutdownRequest{Node: tt.node, Restart: tt.restart})
+		if got, want := len(requests) > 0, tt.shutdown; got != want {
+			t.Fatalf("maybeShutdown(%q, %d): shut down = %v, want %v", tt.node, tt.index, got, want)
+		}
+		if tt.shutdown && requests[0] != tt.restart {
+			t.Fatalf("maybeShutdown(%q, %d): restart = %v, want %v", tt.node, tt.index, requests[0], tt.restart)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+	"github.com/robustirc/robustirc/internal/ircserver"
+	"github.com/robustirc/robustirc/internal/robust"
+
+	pb "github.com/robustirc/robustirc/internal/proto"
+)
+
+// warmStateVersion must be increased whenever replaying the same log entries
+// could result in a different IRCServer state than before (e.g. because a
+// command changed its behavior), so that old warm states are not used.
+const warmStateVersion = 1
+
+var warmStateMagic = []byte("robustirc-warmstate\n")
+
+// warmState is the full IRCServer state as of the last index of a snapshot,
+// i.e. including all log entries which were too new to be compacted. With
+// -warm_start, Restore() uses it instead of replaying these log entries.
+//
+// The warm state is node-local: it is written to -raftdir whenever this node
+// persists a snapshot, and it is only used for restoring that very snapshot.
+type warmState struct {
+	// lastIndex is the last ircstore index which is included in state.
+	lastIndex uint64
+
+	// compacted is the SHA-256 checksum of the compacted state contained in
+	// the snapshot, identifying the snapshot together with lastIndex.
+	compacted [sha256.Size]byte
+
+	state []byte
+}
+
+func warmStatePath() string {
+	return filepath.Join(*raftDir, "warmstate")
+}
+
+// writeWarmState atomically replaces the warm state file with |ws|.
+func writeWarmState(ws *warmState) error {
+	var buf bytes.Buffer
+	buf.Write(warmStateMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(warmStateVersion))
+	binary.Write(&buf, binary.BigEndian, ws.lastIndex)
+	buf.Write(ws.compacted[:])
+	buf.Write(ws.state)
+
+	f, err := ioutil.TempFile(*raftDir, "warmstate-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), warmStatePath())
+}
+
+// readWarmState reads the warm state file, failing if it was written by a
+// version which uses a different warmStateVersion.
+func readWarmState() (*warmState, error) {
+	b, err := ioutil.ReadFile(warmStatePath())
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(b, warmStateMagic) {
+		return nil, fmt.Errorf("%s: invalid magic", warmStatePath())
+	}
+	b = b[len(warmStateMagic):]
+	const headerLen = 4 + 8 + sha256.Size
+	if len(b) < headerLen {
+		return nil, fmt.Errorf("%s: truncated header", warmStatePath())
+	}
+	if got, want := binary.BigEndian.Uint32(b), uint32(warmStateVersion); got != want {
+		return nil, fmt.Errorf("%s: version mismatch: got %d, want %d", warmStatePath(), got, want)
+	}
+	ws := &warmState{
+		lastIndex: binary.BigEndian.Uint64(b[4:]),
+		state:     b[headerLen:],
+	}
+	copy(ws.compacted[:], b[4+8:])
+	return ws, nil
+}
+
+// restoreWarmState replaces the IRCServer state with |ws| if |ws| matches the
+// snapshot which was just restored. Otherwise, it falls back to replaying the
+// log entries from |first| to |last| (which were stored in the ircstore, but
+// not applied) on top of the compacted state.
+func (fsm *FSM) restoreWarmState(ws *warmState, compacted [sha256.Size]byte, first, last uint64) error {
+	start := time.Now()
+	if ws.lastIndex == last && ws.compacted == compacted {
+		i := ircserver.NewIRCServer(*network, time.Now())
+		_, err := i.Unmarshal(ws.state)
+		if err == nil {
+			ircServer = i
+			fsm.setSessionExpiration(i)
+			// The log entries were not applied, so only their senders can be
+			// indexed for /irclog.
+			if err := fsm.indexSenders(first, last); err != nil {
+				return err
+			}
+			log.Printf("Restored warm state up to index %d in %v", last, time.Since(start))
+			return nil
+		}
+		log.Printf("Could not unmarshal warm state, falling back to full replay: %v", err)
+	} else {
+		log.Printf("Warm state (index %d) does not match snapshot (index %d), falling back to full replay", ws.lastIndex, last)
+	}
+
+	if first > last {
+		return nil
+	}
+	iterator := fsm.ircstore.GetBulkIterator(first, last+1)
+	defer iterator.Release()
+	for available := iterator.First(); available; available = iterator.Next() {
+		if err := iterator.Error(); err != nil {
+			return err
+		}
+		value := iterator.Value()
+		var entry pb.RaftLog
+		if err := proto.Unmarshal(value[1:], &entry); err != nil {
+			return err
+		}
+		msg := robust.NewMessageFromBytes(entry.Data, robust.IdFromRaftIndex(entry.Index))
+		fsm.applyProto(&entry, &msg)
+	}
+	log.Printf("Replayed indexes %d to %d in %v", first, last, time.Since(start))
+	return nil
+}
+
+// indexSenders adds the log entries from |first| to |last| to the session
+// index of their sending session.
+func (fsm *FSM) indexSenders(first, last uint64) error {
+	if first > last {
+		return nil
+	}
+	return fsm.ircstore.Iterate(first, last, func(l *raft.Log) error {
+		if l.Type != raft.LogCommand {
+			return nil
+		}
+		msg := robust.NewMessageFromBytes(l.Data, robust.IdFromRaftIndex(l.Index))
+		return fsm.ircstore.IndexSessions(l.Index, relevantSessions(&msg, nil))
+	})
+}
+
+func (fsm *FSM) setSessionExpiration(i *ircserver.IRCServer) {
+	i.ConfigMu.RLock()
+	defer i.ConfigMu.RUnlock()
+	fsm.sessionExpirationMu.Lock()
+	defer fsm.sessionExpirationMu.Unlock()
+	fsm.sessionExpirationDur = time.Duration(i.Config.SessionExpiration)
+}
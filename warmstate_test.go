@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/robustirc/robustirc/internal/ircserver"
+	"github.com/robustirc/robustirc/internal/robust"
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestWarmStart(t *testing.T) {
+	*warmStart = true
+	defer func() { *warmStart = false }()
+
+	tempdir := t.TempDir()
+	fsm := newTestFSM(t, tempdir)
+
+	var logs []*raft.Log
+	logs = appendLog(logs, `{"Id": {"Id": 1}, "Type": 0, "Data": "auth"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 2}, "Session": {"Id": 1}, "Type": 2, "Data": "NICK sECuRE"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 3}, "Session": {"Id": 1}, "Type": 2, "Data": "USER blah 0 * :Michael Stapelberg"}`)
+	logs = appendLog(logs, `{"Id": {"Id": 4}, "Session": {"Id": 1}, "Type": 2, "Data": "JOIN #i3"}`)
+
+	// These messages are too new to be compacted, so they are either
+	// replayed or covered by the warm state.
+	nowID := time.Now().UnixNano()
+	logs = appendLog(logs, `{"Id": {"Id": 5}, "UnixNano": `+strconv.FormatInt(nowID, 10)+`, "Session": {"Id": 1}, "Type": 2, "Data": "NICK secure_"}`)
+	nowID++
+	logs = appendLog(logs, `{"Id": {"Id": 6}, "UnixNano": `+strconv.FormatInt(nowID, 10)+`, "Session": {"Id": 1}, "Type": 2, "Data": "JOIN #chaos-hd"}`)
+	nowID++
+	logs = appendLog(logs, `{"Id": {"Id": 7}, "UnixNano": `+strconv.FormatInt(nowID, 10)+`, "Session": {"Id": 1}, "Type": 2, "Data": "PART #i3"}`)
+
+	if err := fsm.store.StoreLogs(logs); err != nil {
+		t.Fatal(err)
+	}
+	for _, log := range logs {
+		fsm.Apply(log)
+	}
+	verifyEndState(t)
+
+	fss, err := raft.NewFileSnapshotStore(tempdir, 5, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshot(fsm, fss, uint64(len(logs))); err != nil {
+		t.Fatal(err)
+	}
+
+	ws, err := readWarmState()
+	if err != nil {
+		t.Fatalf("readWarmState: %v", err)
+	}
+	if got, want := ws.lastIndex, uint64(len(logs)); got != want {
+		t.Fatalf("warm state lastIndex: got %d, want %d", got, want)
+	}
+
+	nick := func() string {
+		s, err := ircServer.GetSession(robust.Id{Id: 1})
+		if err != nil {
+			t.Fatalf("No session found after restoring")
+		}
+		return s.Nick
+	}
+
+	// Replace the warm state with one in which the session has a different
+	// nickname, so that we can tell whether the warm state was used.
+	ircServer.ProcessMessage(&robust.Message{Session: robust.Id{Id: 1}}, irc.ParseMessage("NICK warm"))
+	modified, err := ircServer.Marshal(ws.lastIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeWarmState(&warmState{
+		lastIndex: ws.lastIndex,
+		compacted: ws.compacted,
+		state:     modified,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ircServer = ircserver.NewIRCServer("testnetwork", time.Now())
+	if err := restore(fsm, fss, uint64(len(logs))); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := nick(), "warm"; got != want {
+		t.Fatalf("warm state not used: got nick %q, want %q", got, want)
+	}
+
+	// A warm state which does not match the snapshot must not be used.
+	if err := writeWarmState(&warmState{
+		lastIndex: ws.lastIndex + 1,
+		compacted: ws.compacted,
+		state:     modified,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ircServer = ircserver.NewIRCServer("testnetwork", time.Now())
+	if err := restore(fsm, fss, uint64(len(logs))); err != nil {
+		t.Fatal(err)
+	}
+	verifyEndState(t)
+}